@@ -5,7 +5,12 @@
 // Package apitype contains types for the Tailscale local API and control plane API.
 package apitype
 
-import "tailscale.com/tailcfg"
+import (
+	"net/netip"
+	"time"
+
+	"tailscale.com/tailcfg"
+)
 
 // WhoIsResponse is the JSON type returned by tailscaled debug server's /whois?ip=$IP handler.
 type WhoIsResponse struct {
@@ -30,3 +35,203 @@ type WaitingFile struct {
 	Name string
 	Size int64
 }
+
+// WakeOnLANResponse is the JSON type returned by the local API's
+// /wol handler.
+type WakeOnLANResponse struct {
+	// MACs are the MAC addresses the magic packets were sent to.
+	MACs []string
+
+	// Relay is the name of the node on the target's LAN that sent
+	// the packets. It's empty if this node sent them itself.
+	Relay string `json:",omitempty"`
+
+	// SentFrom are the relay's interfaces the packets were sent
+	// from.
+	SentFrom []string
+}
+
+// ExitNodeExclusions is the JSON request body of the local API's
+// /exit-node-exclusions handler.
+type ExitNodeExclusions struct {
+	// Routes are the destination prefixes to route directly instead
+	// of via the exit node.
+	Routes []netip.Prefix
+
+	// Apps are the package names of the applications whose traffic
+	// bypasses the tunnel. Android only.
+	Apps []string `json:",omitempty"`
+}
+
+// DoctorCheckResult is the result of a single doctor check run on
+// behalf of a peer.
+type DoctorCheckResult struct {
+	// Name is the name of the check, in lower-kebab-case.
+	Name string
+
+	// Severity is how serious the outcome is: "ok", "info",
+	// "warning", "error" or "skipped". "info" is something worth
+	// knowing that isn't a problem. It's empty from nodes that
+	// predate it.
+	Severity string `json:",omitempty"`
+
+	// Summary is a one-line description of the outcome.
+	Summary string `json:",omitempty"`
+
+	// Detail is the structured data the check reported, if any. Its
+	// format is specific to the check.
+	Detail any `json:",omitempty"`
+
+	// Log are the lines the check logged.
+	Log []string `json:",omitempty"`
+
+	// Error is the error the check returned, if any.
+	Error string `json:",omitempty"`
+
+	// Remediation are actionable steps to fix what the check found,
+	// such as "set net.ipv4.ip_forward to 1", in order.
+	Remediation []string `json:",omitempty"`
+
+	// Skipped, if non-empty, is why the check didn't run on the
+	// node, such as "requires root".
+	Skipped string `json:",omitempty"`
+
+	// CutShort is whether the run was canceled or timed out while
+	// the check was running, so its outcome may be incomplete.
+	CutShort bool `json:",omitempty"`
+}
+
+// DoctorRun is a past run of the doctor checks, as kept by tailscaled
+// and returned by the local API's /doctor-runs handler.
+type DoctorRun struct {
+	// Time is when the run started.
+	Time time.Time
+
+	// Scheduled is whether the run was scheduled by the
+	// DoctorInterval pref, rather than asked for.
+	Scheduled bool `json:",omitempty"`
+
+	// Lightweight is whether only the lightweight checks ran.
+	Lightweight bool `json:",omitempty"`
+
+	// Checks are the results of each check. Those kept in the state
+	// store omit what the checks logged.
+	Checks []DoctorCheckResult
+}
+
+// DoctorRunDiff is the JSON type returned by the local API's
+// /doctor-runs handler when asked to diff two runs.
+type DoctorRunDiff struct {
+	// A and B are the times of the older and newer runs compared.
+	A, B time.Time
+
+	// Changes are what changed from A to B, by check in the order
+	// of B, then those of checks only in A.
+	Changes []DoctorCheckChange
+}
+
+// DoctorCheckChange is a difference in the result of a doctor check
+// between two runs.
+type DoctorCheckChange struct {
+	// Check is the name of the check.
+	Check string
+
+	// Field is what changed: "severity", "summary", "error",
+	// "skipped", or "detail." followed by the path of a value in
+	// the check's Detail, such as "detail.Routes.Default". It's
+	// empty if the check is only in one of the runs.
+	Field string `json:",omitempty"`
+
+	// Old and New are the values before and after, formatted for
+	// people. Old is empty if the value is new, and New if it
+	// went away.
+	Old string `json:",omitempty"`
+	New string `json:",omitempty"`
+}
+
+// DoctorProgress is an update on the progress of a doctor check, as
+// streamed by the local API's /doctor handler when asked for.
+type DoctorProgress struct {
+	// Check is the name of the check.
+	Check string
+
+	// Done and Total are how many steps of the check are done and
+	// how many there are in all. Total is zero if the check didn't
+	// say.
+	Done  int `json:",omitempty"`
+	Total int `json:",omitempty"`
+
+	// What is what the check is doing, such as "probing region 3
+	// (nyc) node 3b", if it said.
+	What string `json:",omitempty"`
+
+	// Finished is whether the check finished or was skipped.
+	Finished bool `json:",omitempty"`
+}
+
+// DoctorStreamMessage is a line of the newline-delimited JSON stream
+// returned by the local API's /doctor handler when asked for progress.
+// Exactly one field is set; the last message has the results.
+type DoctorStreamMessage struct {
+	Progress *DoctorProgress     `json:",omitempty"`
+	Results  []DoctorCheckResult `json:",omitempty"`
+}
+
+// PeerDoctorResponse is the JSON type returned by the local API's
+// /doctor-peer handler.
+type PeerDoctorResponse struct {
+	// Peer is the name of the node that ran the checks.
+	Peer string
+
+	// Checks are the results of each check, in the order the peer
+	// ran them.
+	Checks []DoctorCheckResult
+}
+
+// PreflightReport is the JSON type printed by "tailscaled --preflight"
+// and returned by the local API's /preflight handler.
+type PreflightReport struct {
+	// OK is whether no check failed. Warnings don't count.
+	OK bool
+
+	// Checks are the results of each preflight check.
+	Checks []DoctorCheckResult
+}
+
+// PeerSpeedTestResponse is the JSON type returned by the local API's
+// /speedtest handler.
+type PeerSpeedTestResponse struct {
+	// Peer is the name of the node tested against.
+	Peer string
+
+	// Path is how packets to the peer flowed at the end of the test:
+	// "direct" and the peer's endpoint, or "DERP" and the relay's
+	// region code. It's empty if unknown.
+	Path string `json:",omitempty"`
+
+	// Download is the throughput from the peer to this node.
+	Download SpeedTestResult
+
+	// Upload is the throughput from this node to the peer.
+	Upload SpeedTestResult
+}
+
+// SpeedTestResult is the result of a throughput test in one direction.
+type SpeedTestResult struct {
+	Bytes    int64
+	Duration time.Duration
+
+	// Retransmits is the number of TCP segments the sender
+	// retransmitted during the test, or -1 if unknown. It's only
+	// known for uploads, and only where this node's kernel reports
+	// it; the peer logs its count for downloads.
+	Retransmits int64
+}
+
+// MBitsPerSecond returns the throughput in megabits per second.
+func (r SpeedTestResult) MBitsPerSecond() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Bytes) * 8 / 1e6 / r.Duration.Seconds()
+}
@@ -30,13 +30,18 @@
 
 	"go4.org/mem"
 	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/clientupdate"
+	"tailscale.com/envknob"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/hostfw"
 	"tailscale.com/net/netutil"
+	"tailscale.com/net/pktpath"
 	"tailscale.com/paths"
 	"tailscale.com/safesocket"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tka"
+	"tailscale.com/types/proxyconn"
 )
 
 // defaultLocalClient is the default LocalClient when using the legacy
@@ -268,15 +273,76 @@ func (lc *LocalClient) Profile(ctx context.Context, pprofType string, sec int) (
 	return lc.get200(ctx, fmt.Sprintf("/localapi/v0/profile?name=%s&seconds=%v", url.QueryEscape(pprofType), secArg))
 }
 
-// BugReport logs and returns a log marker that can be shared by the user with support.
-func (lc *LocalClient) BugReport(ctx context.Context, note string) (string, error) {
-	body, err := lc.send(ctx, "POST", "/localapi/v0/bugreport?note="+url.QueryEscape(note), 200, nil)
+// BugReportOpts contains options to pass to the Tailscale daemon when
+// generating a bug report.
+type BugReportOpts struct {
+	// Note contains an optional user-provided note to add to the logs.
+	Note string
+
+	// Diagnose specifies whether to print additional diagnostic
+	// information to the logs when generating this bugreport.
+	Diagnose bool
+
+	// Profile, if non-empty, is the state key of a stored profile
+	// (such as "user-1234") whose prefs are also checked when
+	// Diagnose is set. All stored profiles are summarized either way.
+	Profile ipn.StateKey
+
+	// Checks, if non-empty, are the names of the only diagnostic
+	// checks to run when Diagnose is set, such as "dns-manager".
+	Checks []string
+
+	// Categories, if non-empty, limits the diagnostic checks run when
+	// Diagnose is set to those in any of the named categories, such
+	// as "dns".
+	Categories []string
+
+	// Redact, if non-empty, is what to redact from the output of the
+	// diagnostic checks before it's logged, such as "ips,macs". See
+	// the --redact flag of "tailscale bugreport".
+	Redact string
+}
+
+// BugReportWithOpts logs and returns a log marker that can be shared by the
+// user with support.
+//
+// The opts type specifies options to pass to the Tailscale daemon when
+// generating this bug report.
+func (lc *LocalClient) BugReportWithOpts(ctx context.Context, opts BugReportOpts) (string, error) {
+	qparams := make(url.Values)
+	if opts.Note != "" {
+		qparams.Set("note", opts.Note)
+	}
+	if opts.Diagnose {
+		qparams.Set("diagnose", "true")
+	}
+	if opts.Profile != "" {
+		qparams.Set("profile", string(opts.Profile))
+	}
+	if len(opts.Checks) > 0 {
+		qparams.Set("checks", strings.Join(opts.Checks, ","))
+	}
+	if len(opts.Categories) > 0 {
+		qparams.Set("categories", strings.Join(opts.Categories, ","))
+	}
+	if opts.Redact != "" {
+		qparams.Set("redact", opts.Redact)
+	}
+	body, err := lc.send(ctx, "POST", "/localapi/v0/bugreport?"+qparams.Encode(), 200, nil)
 	if err != nil {
 		return "", err
 	}
 	return strings.TrimSpace(string(body)), nil
 }
 
+// BugReport logs and returns a log marker that can be shared by the user with support.
+//
+// This is the same as calling BugReportWithOpts and only specifying the Note
+// field.
+func (lc *LocalClient) BugReport(ctx context.Context, note string) (string, error) {
+	return lc.BugReportWithOpts(ctx, BugReportOpts{Note: note})
+}
+
 // DebugAction invokes a debug action, such as "rebind" or "restun".
 // These are development tools and subject to change or removal over time.
 func (lc *LocalClient) DebugAction(ctx context.Context, action string) error {
@@ -287,6 +353,363 @@ func (lc *LocalClient) DebugAction(ctx context.Context, action string) error {
 	return nil
 }
 
+// SetPeerPathPin restricts the paths tailscaled uses to reach the peer
+// with Tailscale IP ip. A zero pin removes any restriction.
+// This is a debugging tool and is subject to change or removal.
+func (lc *LocalClient) SetPeerPathPin(ctx context.Context, ip netip.Addr, pin ipnstate.PathPin) error {
+	pj, err := json.Marshal(pin)
+	if err != nil {
+		return err
+	}
+	_, err = lc.send(ctx, "POST", "/localapi/v0/path-pin?ip="+url.QueryEscape(ip.String()), http.StatusOK, bytes.NewReader(pj))
+	return err
+}
+
+// LocalEndpoints returns the UDP endpoints that tailscaled advertises,
+// to import on a peer with ImportPeerEndpoints.
+// This is a debugging tool and is subject to change or removal.
+func (lc *LocalClient) LocalEndpoints(ctx context.Context) (*ipnstate.NodeEndpoints, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/debug-endpoints")
+	if err != nil {
+		return nil, err
+	}
+	ret := new(ipnstate.NodeEndpoints)
+	if err := json.Unmarshal(body, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// ImportPeerEndpoints adds eps to the endpoints tailscaled tries to
+// reach the peer with Tailscale IP ip at, replacing those imported
+// before, until tailscaled restarts. Empty eps removes them.
+// This is a debugging tool and is subject to change or removal.
+func (lc *LocalClient) ImportPeerEndpoints(ctx context.Context, ip netip.Addr, eps []netip.AddrPort) error {
+	if eps == nil {
+		eps = []netip.AddrPort{}
+	}
+	ej, err := json.Marshal(eps)
+	if err != nil {
+		return err
+	}
+	_, err = lc.send(ctx, "POST", "/localapi/v0/debug-endpoints?ip="+url.QueryEscape(ip.String()), http.StatusOK, bytes.NewReader(ej))
+	return err
+}
+
+// ExitNodeExclusions returns the destination prefixes that tailscaled
+// routes directly instead of via the exit node, with those it couldn't
+// exclude marked Dropped. It's empty without an exit node.
+func (lc *LocalClient) ExitNodeExclusions(ctx context.Context) ([]ipnstate.ExcludedRoute, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/exit-node-exclusions")
+	if err != nil {
+		return nil, err
+	}
+	var ret []ipnstate.ExcludedRoute
+	if err := json.Unmarshal(body, &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// SetExitNodeExclusions sets the destination prefixes and, on Android,
+// the applications excluded from the tunnel, replacing any previous
+// ones, and returns the resulting prefs.
+func (lc *LocalClient) SetExitNodeExclusions(ctx context.Context, ex apitype.ExitNodeExclusions) (*ipn.Prefs, error) {
+	exj, err := json.Marshal(ex)
+	if err != nil {
+		return nil, err
+	}
+	body, err := lc.send(ctx, "POST", "/localapi/v0/exit-node-exclusions", http.StatusOK, bytes.NewReader(exj))
+	if err != nil {
+		return nil, err
+	}
+	var p ipn.Prefs
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, fmt.Errorf("invalid prefs JSON: %w", err)
+	}
+	return &p, nil
+}
+
+// FirstContact returns the trace of tailscaled's most recent connection
+// setup to the peer with Tailscale IP ip after it had no recent traffic.
+// This is a debugging tool and is subject to change or removal.
+func (lc *LocalClient) FirstContact(ctx context.Context, ip netip.Addr) (*ipnstate.FirstContactTrace, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/first-contact?ip="+url.QueryEscape(ip.String()))
+	if err != nil {
+		return nil, err
+	}
+	ret := new(ipnstate.FirstContactTrace)
+	if err := json.Unmarshal(body, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// ProfileKeys returns the state keys of the profiles stored by
+// tailscaled.
+func (lc *LocalClient) ProfileKeys(ctx context.Context) ([]ipn.StateKey, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/profiles")
+	if err != nil {
+		return nil, err
+	}
+	var keys []ipn.StateKey
+	if err := json.Unmarshal(body, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// LogLevels returns the log verbosity of each tailscaled logging
+// component that has its own level, and of "all" components.
+// This is a debugging tool and is subject to change or removal.
+func (lc *LocalClient) LogLevels(ctx context.Context) (map[string]int, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/log-level")
+	if err != nil {
+		return nil, err
+	}
+	var ret map[string]int
+	if err := json.Unmarshal(body, &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// SetLogLevel sets the log verbosity of a tailscaled logging component,
+// such as "magicsock", or of "all" of them. The level is a number, or
+// one of "info", "verbose", "debug" or "default".
+// This is a debugging tool and is subject to change or removal.
+func (lc *LocalClient) SetLogLevel(ctx context.Context, component, level string) (map[string]int, error) {
+	q := url.Values{"component": {component}, "level": {level}}
+	body, err := lc.send(ctx, "POST", "/localapi/v0/log-level?"+q.Encode(), http.StatusOK, nil)
+	if err != nil {
+		return nil, err
+	}
+	var ret map[string]int
+	if err := json.Unmarshal(body, &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// DebugKnobs returns the debug knobs tailscaled allows changing at
+// runtime, with their current values.
+// This is a debugging tool and is subject to change or removal.
+func (lc *LocalClient) DebugKnobs(ctx context.Context) ([]envknob.Knob, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/debug-knobs")
+	if err != nil {
+		return nil, err
+	}
+	var ret []envknob.Knob
+	if err := json.Unmarshal(body, &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// SetDebugKnob sets the tailscaled debug knob name, such as
+// "TS_DEBUG_DISCO", to val until tailscaled restarts. An empty val
+// unsets it. It returns the knobs with their new values.
+// This is a debugging tool and is subject to change or removal.
+func (lc *LocalClient) SetDebugKnob(ctx context.Context, name, val string) ([]envknob.Knob, error) {
+	q := url.Values{"name": {name}, "value": {val}}
+	body, err := lc.send(ctx, "POST", "/localapi/v0/debug-knobs?"+q.Encode(), http.StatusOK, nil)
+	if err != nil {
+		return nil, err
+	}
+	var ret []envknob.Knob
+	if err := json.Unmarshal(body, &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// RecentLogs returns the log lines tailscaled keeps in memory, one per
+// line. If since is non-zero, only those logged within that duration
+// are returned, and if component is non-empty, only those of that
+// logging component.
+// This is a debugging tool and is subject to change or removal.
+func (lc *LocalClient) RecentLogs(ctx context.Context, since time.Duration, component string) ([]byte, error) {
+	q := url.Values{}
+	if since > 0 {
+		q.Set("since", since.String())
+	}
+	if component != "" {
+		q.Set("component", component)
+	}
+	return lc.get200(ctx, "/localapi/v0/logs?"+q.Encode())
+}
+
+// WakeOnLAN asks tailscaled to wake the peer with Tailscale IP ip by
+// sending Wake-on-LAN packets from a node on its LAN. If via is valid,
+// it's the Tailscale IP of the node to send them from.
+func (lc *LocalClient) WakeOnLAN(ctx context.Context, ip, via netip.Addr) (*apitype.WakeOnLANResponse, error) {
+	q := url.Values{"ip": {ip.String()}}
+	if via.IsValid() {
+		q.Set("via", via.String())
+	}
+	body, err := lc.send(ctx, "POST", "/localapi/v0/wol?"+q.Encode(), http.StatusOK, nil)
+	if err != nil {
+		return nil, err
+	}
+	res := new(apitype.WakeOnLANResponse)
+	if err := json.Unmarshal(body, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Doctor runs tailscaled's doctor checks and returns their results.
+// If profile is non-empty, it names the state key of a stored profile
+// whose prefs are checked too. If checks is non-empty, only the checks
+// it names run. If categories is non-empty, only the checks in any of
+// the categories it names, such as "dns", run. If redact is non-empty,
+// it's what to redact from the results, as in BugReportOpts.Redact.
+func (lc *LocalClient) Doctor(ctx context.Context, profile ipn.StateKey, checks, categories []string, redact string) ([]apitype.DoctorCheckResult, error) {
+	q := doctorQuery(profile, checks, categories, redact)
+	body, err := lc.send(ctx, "POST", "/localapi/v0/doctor?"+q.Encode(), http.StatusOK, nil)
+	if err != nil {
+		return nil, err
+	}
+	var res []apitype.DoctorCheckResult
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// DoctorWithProgress is like Doctor, but calls progress as each check
+// starts, reports its progress and finishes, so that a long run
+// doesn't appear hung.
+func (lc *LocalClient) DoctorWithProgress(ctx context.Context, profile ipn.StateKey, checks, categories []string, redact string, progress func(apitype.DoctorProgress)) ([]apitype.DoctorCheckResult, error) {
+	q := doctorQuery(profile, checks, categories, redact)
+	q.Set("progress", "1")
+	req, err := http.NewRequestWithContext(ctx, "POST", "http://local-tailscaled.sock/localapi/v0/doctor?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := lc.doLocalRequestNiceError(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(res.Body)
+		return nil, bestError(fmt.Errorf("%v: %s", res.Status, bytes.TrimSpace(body)), body)
+	}
+	dec := json.NewDecoder(res.Body)
+	for {
+		var m apitype.DoctorStreamMessage
+		if err := dec.Decode(&m); err != nil {
+			return nil, err
+		}
+		if m.Progress == nil {
+			return m.Results, nil
+		}
+		if progress != nil {
+			progress(*m.Progress)
+		}
+	}
+}
+
+// doctorQuery returns the parameters of the local API's /doctor
+// handler for the arguments of Doctor.
+func doctorQuery(profile ipn.StateKey, checks, categories []string, redact string) url.Values {
+	q := url.Values{}
+	if profile != "" {
+		q.Set("profile", string(profile))
+	}
+	if len(checks) > 0 {
+		q.Set("checks", strings.Join(checks, ","))
+	}
+	if len(categories) > 0 {
+		q.Set("categories", strings.Join(categories, ","))
+	}
+	if redact != "" {
+		q.Set("redact", redact)
+	}
+	return q
+}
+
+// DoctorRuns returns the past doctor runs that tailscaled keeps,
+// oldest first.
+func (lc *LocalClient) DoctorRuns(ctx context.Context) ([]apitype.DoctorRun, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/doctor-runs")
+	if err != nil {
+		return nil, err
+	}
+	var res []apitype.DoctorRun
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// DiffDoctorRuns returns what changed between the two past doctor runs
+// that a and b select. Each is an index into the runs returned by
+// DoctorRuns, counting back from the newest if negative, or a time in
+// RFC 3339 format or a date such as "2022-10-04", selecting the newest
+// run at or before it.
+func (lc *LocalClient) DiffDoctorRuns(ctx context.Context, a, b string) (*apitype.DoctorRunDiff, error) {
+	q := url.Values{"a": {a}, "b": {b}}
+	body, err := lc.get200(ctx, "/localapi/v0/doctor-runs?"+q.Encode())
+	if err != nil {
+		return nil, err
+	}
+	res := new(apitype.DoctorRunDiff)
+	if err := json.Unmarshal(body, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Preflight runs tailscaled's preflight checks of its environment, as
+// "tailscaled --preflight" does before starting, against the running
+// tailscaled's configuration.
+func (lc *LocalClient) Preflight(ctx context.Context) (*apitype.PreflightReport, error) {
+	body, err := lc.send(ctx, "POST", "/localapi/v0/preflight", http.StatusOK, nil)
+	if err != nil {
+		return nil, err
+	}
+	res := new(apitype.PreflightReport)
+	if err := json.Unmarshal(body, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// DoctorPeer asks the peer with Tailscale IP ip to run its doctor
+// checks and return the results. The peer must allow it with
+// "tailscale up --allow-remote-doctor".
+func (lc *LocalClient) DoctorPeer(ctx context.Context, ip netip.Addr) (*apitype.PeerDoctorResponse, error) {
+	q := url.Values{"ip": {ip.String()}}
+	body, err := lc.send(ctx, "POST", "/localapi/v0/doctor-peer?"+q.Encode(), http.StatusOK, nil)
+	if err != nil {
+		return nil, err
+	}
+	res := new(apitype.PeerDoctorResponse)
+	if err := json.Unmarshal(body, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// SpeedTestPeer runs a throughput test against the peer with Tailscale
+// IP ip, downloading and then uploading for d each. The peer must have
+// opted in with "tailscale up --allow-speedtest", and be owned by the
+// same user or grant this node the speedtest-peer capability.
+func (lc *LocalClient) SpeedTestPeer(ctx context.Context, ip netip.Addr, d time.Duration) (*apitype.PeerSpeedTestResponse, error) {
+	q := url.Values{"ip": {ip.String()}, "duration": {d.String()}}
+	body, err := lc.send(ctx, "POST", "/localapi/v0/speedtest?"+q.Encode(), http.StatusOK, nil)
+	if err != nil {
+		return nil, err
+	}
+	res := new(apitype.PeerSpeedTestResponse)
+	if err := json.Unmarshal(body, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
 // Status returns the Tailscale daemon's status.
 func Status(ctx context.Context) (*ipnstate.Status, error) {
 	return defaultLocalClient.Status(ctx)
@@ -566,6 +989,218 @@ func (lc *LocalClient) CurrentDERPMap(ctx context.Context) (*tailcfg.DERPMap, er
 	return &derpMap, nil
 }
 
+// NetInterfaces returns the local tailscaled's view of the machine's
+// network interfaces.
+func (lc *LocalClient) NetInterfaces(ctx context.Context) (*ipnstate.NetInterfaces, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/interfaces")
+	if err != nil {
+		return nil, err
+	}
+	ret := new(ipnstate.NetInterfaces)
+	if err := json.Unmarshal(body, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Diagnostics runs the local tailscaled's doctor checks and returns
+// their output, along with its latest netcheck results and the system
+// route table.
+func (lc *LocalClient) Diagnostics(ctx context.Context) (*ipnstate.Diagnostics, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/diagnostics")
+	if err != nil {
+		return nil, err
+	}
+	ret := new(ipnstate.Diagnostics)
+	if err := json.Unmarshal(body, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// CheckUpdate reports whether a newer version of Tailscale than the one
+// tailscaled is running is available on track. If track is empty, the
+// running version's track is used.
+func (lc *LocalClient) CheckUpdate(ctx context.Context, track clientupdate.Track) (*clientupdate.CheckResult, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/update/check?track="+url.QueryEscape(string(track)))
+	if err != nil {
+		return nil, err
+	}
+	ret := new(clientupdate.CheckResult)
+	if err := json.Unmarshal(body, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// StateSnapshot returns a redacted snapshot of the node's prefs, network
+// map and health, for reproducing its configuration elsewhere.
+func (lc *LocalClient) StateSnapshot(ctx context.Context) (*ipn.StateSnapshot, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/state-snapshot")
+	if err != nil {
+		return nil, err
+	}
+	ret := new(ipn.StateSnapshot)
+	if err := json.Unmarshal(body, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// DebugNetmapRoutes returns the subnet routes and exit nodes that peers
+// in the netmap offer, and whether and why not tailscaled uses each.
+func (lc *LocalClient) DebugNetmapRoutes(ctx context.Context) ([]*ipnstate.NetmapRoute, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/debug-netmap-routes")
+	if err != nil {
+		return nil, err
+	}
+	var ret []*ipnstate.NetmapRoute
+	if err := json.Unmarshal(body, &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// DebugDERPSelection returns tailscaled's home DERP region and how it
+// was selected.
+func (lc *LocalClient) DebugDERPSelection(ctx context.Context) (*ipnstate.DERPSelection, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/debug-derp-selection")
+	if err != nil {
+		return nil, err
+	}
+	ret := new(ipnstate.DERPSelection)
+	if err := json.Unmarshal(body, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// DebugDNSCache returns the contents of the MagicDNS forwarder's cache
+// of upstream responses.
+func (lc *LocalClient) DebugDNSCache(ctx context.Context) (*ipnstate.DNSCache, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/debug-dns-cache")
+	if err != nil {
+		return nil, err
+	}
+	ret := new(ipnstate.DNSCache)
+	if err := json.Unmarshal(body, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// DebugDNSQueries returns the stats of the DNS queries that local
+// clients sent to MagicDNS at 100.100.100.100:53, by client.
+func (lc *LocalClient) DebugDNSQueries(ctx context.Context) (*ipnstate.DNSQueryStats, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/debug-dns-queries")
+	if err != nil {
+		return nil, err
+	}
+	ret := new(ipnstate.DNSQueryStats)
+	if err := json.Unmarshal(body, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// DebugProxyConns returns the recent connections of tailscaled's
+// outbound SOCKS5 and HTTP proxies.
+func (lc *LocalClient) DebugProxyConns(ctx context.Context) (*proxyconn.Conns, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/debug-proxy-conns")
+	if err != nil {
+		return nil, err
+	}
+	ret := new(proxyconn.Conns)
+	if err := json.Unmarshal(body, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// DebugRouterState returns the routing configuration tailscaled last
+// applied to the OS, and the routes that failed to be added or removed.
+func (lc *LocalClient) DebugRouterState(ctx context.Context) (*ipnstate.RouterState, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/debug-router-state")
+	if err != nil {
+		return nil, err
+	}
+	ret := new(ipnstate.RouterState)
+	if err := json.Unmarshal(body, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// DebugFilterStats returns how many packets tailscaled's packet filter
+// accepted by each of its rules and dropped for each reason.
+func (lc *LocalClient) DebugFilterStats(ctx context.Context) (*ipnstate.FilterStats, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/debug-filter")
+	if err != nil {
+		return nil, err
+	}
+	ret := new(ipnstate.FilterStats)
+	if err := json.Unmarshal(body, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// FlushDNSCache drops the MagicDNS forwarder's cached responses and
+// returns the then empty cache.
+func (lc *LocalClient) FlushDNSCache(ctx context.Context) (*ipnstate.DNSCache, error) {
+	body, err := lc.send(ctx, "POST", "/localapi/v0/debug-dns-cache", http.StatusOK, nil)
+	if err != nil {
+		return nil, err
+	}
+	ret := new(ipnstate.DNSCache)
+	if err := json.Unmarshal(body, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// DebugPacketPathStats measures how long each layer of tailscaled's
+// packet path spends on packets for duration d.
+func (lc *LocalClient) DebugPacketPathStats(ctx context.Context, d time.Duration) (*pktpath.Stats, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/debug-packet-path-stats?duration="+url.QueryEscape(d.String()))
+	if err != nil {
+		return nil, err
+	}
+	ret := new(pktpath.Stats)
+	if err := json.Unmarshal(body, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// HostFirewall returns the host firewall rules that allow inbound UDP to
+// tailscaled's port, and whether they're installed.
+func (lc *LocalClient) HostFirewall(ctx context.Context) (*hostfw.Plan, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/host-firewall")
+	if err != nil {
+		return nil, err
+	}
+	ret := new(hostfw.Plan)
+	if err := json.Unmarshal(body, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// ApplyHostFirewall adds the rules returned by HostFirewall to the host
+// firewall and returns the updated rules.
+func (lc *LocalClient) ApplyHostFirewall(ctx context.Context) (*hostfw.Plan, error) {
+	body, err := lc.send(ctx, "POST", "/localapi/v0/host-firewall", 200, nil)
+	if err != nil {
+		return nil, err
+	}
+	ret := new(hostfw.Plan)
+	if err := json.Unmarshal(body, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
 // CertPair returns a cert and private key for the provided DNS domain.
 //
 // It returns a cached certificate from disk if it's still valid.
@@ -681,6 +1316,23 @@ func (lc *LocalClient) Ping(ctx context.Context, ip netip.Addr, pingtype tailcfg
 	return pr, nil
 }
 
+// Traceroute traces the route through the tunnel to ip, probing at most
+// maxHops hops.
+func (lc *LocalClient) Traceroute(ctx context.Context, ip netip.Addr, maxHops int) (*ipnstate.TracerouteResult, error) {
+	v := url.Values{}
+	v.Set("ip", ip.String())
+	v.Set("max", strconv.Itoa(maxHops))
+	body, err := lc.send(ctx, "POST", "/localapi/v0/traceroute?"+v.Encode(), 200, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error %w: %s", err, body)
+	}
+	res := new(ipnstate.TracerouteResult)
+	if err := json.Unmarshal(body, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
 // NetworkLockStatus fetches information about the tailnet key authority, if one is configured.
 func (lc *LocalClient) NetworkLockStatus(ctx context.Context) (*ipnstate.NetworkLockStatus, error) {
 	body, err := lc.send(ctx, "GET", "/localapi/v0/tka/status", 200, nil)
@@ -0,0 +1,262 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package clientupdate updates the Tailscale client installed on this
+// machine using the platform's package manager or installer: apt or
+// yum on Linux, the MSI installer on Windows and pkg on FreeBSD.
+//
+// Updates are installed by the caller's process rather than by
+// tailscaled, as installing a new package restarts tailscaled.
+package clientupdate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"tailscale.com/types/logger"
+	"tailscale.com/version"
+)
+
+// Track is a release track of Tailscale packages.
+type Track string
+
+const (
+	// StableTrack is the track of stable releases, which have even
+	// minor version numbers.
+	StableTrack = Track("stable")
+
+	// UnstableTrack is the track of unstable releases, which have odd
+	// minor version numbers.
+	UnstableTrack = Track("unstable")
+)
+
+// ParseTrack parses a track name.
+func ParseTrack(s string) (Track, error) {
+	switch t := Track(s); t {
+	case StableTrack, UnstableTrack:
+		return t, nil
+	}
+	return "", fmt.Errorf("unknown track %q; want %q or %q", s, StableTrack, UnstableTrack)
+}
+
+// TrackOf returns the track that the version v was released on.
+func TrackOf(v string) Track {
+	major, rest, _ := strings.Cut(v, ".")
+	minorStr, _, _ := strings.Cut(rest, ".")
+	if _, err := strconv.Atoi(major); err != nil {
+		return StableTrack // OSS build ("date.20221015")
+	}
+	if minor, err := strconv.Atoi(minorStr); err == nil && minor%2 == 1 {
+		return UnstableTrack
+	}
+	return StableTrack
+}
+
+// pkgsURL is the base URL of the Tailscale package server.
+const pkgsURL = "https://pkgs.tailscale.com"
+
+// httpClient is the client for the package server. Its timeouts only
+// cover getting a response, not reading it, as a package can take a
+// while to download on a slow link.
+var httpClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   30 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+	},
+}
+
+// latestVersionTimeout is how long LatestVersion has in all.
+const latestVersionTimeout = time.Minute
+
+// pkgsVersions is the part of the package server's JSON listing of a
+// track that says which versions are released.
+type pkgsVersions struct {
+	// Version is the latest version on the track.
+	Version string
+	// MSIsVersion is the version of the Windows installers, which
+	// may lag Version while a release is rolled out in stages.
+	MSIsVersion string
+}
+
+// forOS returns the latest version released for goos: the version of
+// its packages, if the listing has one, or else the track's.
+func (v pkgsVersions) forOS(goos string) string {
+	if goos == "windows" && v.MSIsVersion != "" {
+		return v.MSIsVersion
+	}
+	return v.Version
+}
+
+// LatestVersion returns the latest version of Tailscale released on
+// track for this platform. While a release is rolled out in stages,
+// that may be older than the track's latest.
+func LatestVersion(ctx context.Context, track Track) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, latestVersionTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", pkgsURL+"/"+string(track)+"/?mode=json", nil)
+	if err != nil {
+		return "", err
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("fetching latest version: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetching latest version: %v", res.Status)
+	}
+	var pkgs pkgsVersions
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&pkgs); err != nil {
+		return "", fmt.Errorf("decoding latest version: %w", err)
+	}
+	v := pkgs.forOS(runtime.GOOS)
+	if v == "" {
+		return "", errors.New("no latest version found")
+	}
+	return v, nil
+}
+
+// CheckResult is the result of checking for an update.
+type CheckResult struct {
+	Running         string // short version of the running tailscaled
+	Track           Track
+	Latest          string // latest version on Track
+	UpdateAvailable bool
+}
+
+// Check reports whether a newer version than the running one is
+// available on track.
+func Check(ctx context.Context, track Track) (*CheckResult, error) {
+	latest, err := LatestVersion(ctx, track)
+	if err != nil {
+		return nil, err
+	}
+	return &CheckResult{
+		Running:         version.Short,
+		Track:           track,
+		Latest:          latest,
+		UpdateAvailable: latest != version.Short && !version.AtLeast(version.Short, latest),
+	}, nil
+}
+
+// ErrUnsupported is returned by Update when this machine's Tailscale
+// installation can't be updated by this package.
+var ErrUnsupported = errors.New("updating this installation of Tailscale isn't supported; update it the same way it was installed")
+
+// Args are the arguments to Update.
+type Args struct {
+	// Version is the version to install, which may be older than the
+	// installed one. Empty means the latest version on Track.
+	Version string
+
+	// Track is the release track to install from. Switching tracks
+	// changes the package repository configured on this machine.
+	Track Track
+
+	// Logf logs progress.
+	Logf logger.Logf
+
+	// Stdout and Stderr, if non-nil, receive the output of the
+	// package manager or installer.
+	Stdout, Stderr io.Writer
+
+	// ReexecArgs are, on Windows, the arguments to run a copy of this
+	// program with to finish the update, such as os.Args[1:] with the
+	// version pinned and confirmation skipped. The installer replaces
+	// the executables of Tailscale, which Windows doesn't allow while
+	// they're running, so Update starts the copy and exits the
+	// program. If nil, the installer is run by this program, which
+	// must then not be one of Tailscale's.
+	ReexecArgs []string
+}
+
+// Update installs the Tailscale version described by args. It must run
+// as root, or as an Administrator on Windows.
+//
+// The package's signature is always verified before it's installed,
+// either by the package manager or, on Windows, by checking the
+// installer's Authenticode signature.
+func Update(ctx context.Context, args Args) error {
+	if args.Track == "" {
+		args.Track = TrackOf(version.Short)
+	}
+	if args.Logf == nil {
+		args.Logf = logger.Discard
+	}
+	if args.Version != "" && TrackOf(args.Version) != args.Track {
+		return fmt.Errorf("version %s isn't on the %s track", args.Version, args.Track)
+	}
+	return update(ctx, args)
+}
+
+// resolveVersion sets a.Version to the latest version on a.Track if
+// it's empty.
+func (a *Args) resolveVersion(ctx context.Context) error {
+	if a.Version != "" {
+		return nil
+	}
+	v, err := LatestVersion(ctx, a.Track)
+	if err != nil {
+		return err
+	}
+	a.Version = v
+	return nil
+}
+
+// run runs the command name with args, sending its output to
+// a.Stdout and a.Stderr.
+func (a *Args) run(ctx context.Context, name string, args ...string) error {
+	a.Logf("running %s %s", name, strings.Join(args, " "))
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = a.Stdout
+	cmd.Stderr = a.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// execOutput runs the command name with args and returns its trimmed
+// standard output.
+func execOutput(ctx context.Context, name string, args ...string) (string, error) {
+	out, err := exec.CommandContext(ctx, name, args...).Output()
+	if err != nil {
+		var ee *exec.ExitError
+		if errors.As(err, &ee) {
+			return "", fmt.Errorf("%s: %w: %s", name, err, bytes.TrimSpace(ee.Stderr))
+		}
+		return "", fmt.Errorf("%s: %w", name, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// rewriteTrack returns the package repository configuration conf with
+// its pkgs.tailscale.com URLs changed to use track.
+func rewriteTrack(conf []byte, track Track) ([]byte, error) {
+	var found bool
+	for _, t := range []Track{StableTrack, UnstableTrack} {
+		from := []byte("pkgs.tailscale.com/" + string(t) + "/")
+		if bytes.Contains(conf, from) {
+			found = true
+			conf = bytes.ReplaceAll(conf, from, []byte("pkgs.tailscale.com/"+string(track)+"/"))
+		}
+	}
+	if !found {
+		return nil, errors.New("no pkgs.tailscale.com repository found")
+	}
+	return conf, nil
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package clientupdate
+
+import "testing"
+
+func TestTrackOf(t *testing.T) {
+	tests := map[string]Track{
+		"1.32.2":             StableTrack,
+		"1.33.144":           UnstableTrack,
+		"1.10.0":             StableTrack,
+		"1.11.0-t1234abcd":   UnstableTrack,
+		"1.33.0-dev20221015": UnstableTrack,
+		"date.20221015":      StableTrack,
+		"":                   StableTrack,
+	}
+	for v, want := range tests {
+		if got := TrackOf(v); got != want {
+			t.Errorf("TrackOf(%q) = %q; want %q", v, got, want)
+		}
+	}
+}
+
+func TestParseTrack(t *testing.T) {
+	for _, s := range []string{"stable", "unstable"} {
+		if tr, err := ParseTrack(s); err != nil || string(tr) != s {
+			t.Errorf("ParseTrack(%q) = %q, %v", s, tr, err)
+		}
+	}
+	if _, err := ParseTrack("beta"); err == nil {
+		t.Error("ParseTrack(beta) succeeded")
+	}
+}
+
+func TestRewriteTrack(t *testing.T) {
+	const apt = "deb [signed-by=/usr/share/keyrings/tailscale-archive-keyring.gpg] https://pkgs.tailscale.com/stable/debian bullseye main\n"
+	got, err := rewriteTrack([]byte(apt), UnstableTrack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = "deb [signed-by=/usr/share/keyrings/tailscale-archive-keyring.gpg] https://pkgs.tailscale.com/unstable/debian bullseye main\n"
+	if string(got) != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+	got, err = rewriteTrack(got, StableTrack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != apt {
+		t.Errorf("got %q; want %q", got, apt)
+	}
+
+	if _, err := rewriteTrack([]byte("deb http://deb.debian.org/debian bullseye main\n"), StableTrack); err == nil {
+		t.Error("rewriteTrack of a non-Tailscale repository succeeded")
+	}
+}
+
+func TestPkgsVersionsForOS(t *testing.T) {
+	v := pkgsVersions{Version: "1.34.0", MSIsVersion: "1.32.3"}
+	if got := v.forOS("windows"); got != "1.32.3" {
+		t.Errorf("windows = %q; want the MSIs' version while they're rolled out", got)
+	}
+	if got := v.forOS("linux"); got != "1.34.0" {
+		t.Errorf("linux = %q; want 1.34.0", got)
+	}
+	v.MSIsVersion = ""
+	if got := v.forOS("windows"); got != "1.34.0" {
+		t.Errorf("windows without MSIsVersion = %q; want 1.34.0", got)
+	}
+}
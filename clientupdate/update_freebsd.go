@@ -0,0 +1,39 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package clientupdate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// update upgrades the tailscale package with pkg, which verifies
+// package signatures against the repository's configured keys. The
+// FreeBSD package repositories only carry one, stable, version.
+func update(ctx context.Context, args Args) error {
+	if args.Track != StableTrack {
+		return errors.New("only the stable track is available from the FreeBSD package repositories")
+	}
+	if args.Version != "" {
+		out, err := execOutput(ctx, "pkg", "rquery", "%v", "tailscale")
+		if err != nil {
+			return err
+		}
+		if v := pkgVersion(out); v != args.Version {
+			return fmt.Errorf("only version %s is available from the FreeBSD package repositories", v)
+		}
+	}
+	return args.run(ctx, "pkg", "upgrade", "--yes", "tailscale")
+}
+
+// pkgVersion returns the Tailscale version of the FreeBSD package
+// version v, without its port revision and epoch ("1.32.2_1,1").
+func pkgVersion(v string) string {
+	v, _, _ = strings.Cut(v, ",")
+	v, _, _ = strings.Cut(v, "_")
+	return v
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package clientupdate
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"tailscale.com/version"
+)
+
+const (
+	aptSourcesFile = "/etc/apt/sources.list.d/tailscale.list"
+	yumRepoFile    = "/etc/yum.repos.d/tailscale.repo"
+)
+
+func update(ctx context.Context, args Args) error {
+	if err := args.resolveVersion(ctx); err != nil {
+		return err
+	}
+	if _, err := os.Stat(aptSourcesFile); err == nil {
+		return updateApt(ctx, args)
+	}
+	if _, err := os.Stat(yumRepoFile); err == nil {
+		return updateYum(ctx, args)
+	}
+	return ErrUnsupported
+}
+
+func updateApt(ctx context.Context, args Args) error {
+	conf, err := os.ReadFile(aptSourcesFile)
+	if err != nil {
+		return err
+	}
+	if err := checkAptSources(conf); err != nil {
+		return fmt.Errorf("%s: %w", aptSourcesFile, err)
+	}
+	if err := setTrack(aptSourcesFile, conf, args); err != nil {
+		return err
+	}
+	if err := args.run(ctx, "apt-get", "update"); err != nil {
+		return err
+	}
+	return args.run(ctx, "apt-get", "install", "--yes", "--allow-downgrades", "tailscale="+args.Version)
+}
+
+func updateYum(ctx context.Context, args Args) error {
+	conf, err := os.ReadFile(yumRepoFile)
+	if err != nil {
+		return err
+	}
+	if err := checkYumRepo(conf); err != nil {
+		return fmt.Errorf("%s: %w", yumRepoFile, err)
+	}
+	if err := setTrack(yumRepoFile, conf, args); err != nil {
+		return err
+	}
+	yum := "yum"
+	if _, err := exec.LookPath("dnf"); err == nil {
+		yum = "dnf"
+	}
+	verb := "install"
+	if args.Version != version.Short && version.AtLeast(version.Short, args.Version) {
+		verb = "downgrade"
+	}
+	return args.run(ctx, yum, verb, "--assumeyes", "tailscale-"+args.Version)
+}
+
+// setTrack rewrites the package repository configuration file path,
+// whose contents are conf, to use args.Track.
+func setTrack(path string, conf []byte, args Args) error {
+	newConf, err := rewriteTrack(conf, args.Track)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if bytes.Equal(newConf, conf) {
+		return nil
+	}
+	args.Logf("switching %s to the %s track", path, args.Track)
+	return os.WriteFile(path, newConf, 0644)
+}
+
+// checkAptSources returns an error if the apt sources list conf
+// disables signature verification of packages.
+func checkAptSources(conf []byte) error {
+	if bytes.Contains(conf, []byte("trusted=yes")) {
+		return errors.New("package signature verification is disabled by trusted=yes")
+	}
+	return nil
+}
+
+// checkYumRepo returns an error if the yum repository configuration
+// conf doesn't verify package signatures.
+func checkYumRepo(conf []byte) error {
+	var gpgcheck bool
+	sc := bufio.NewScanner(bytes.NewReader(conf))
+	for sc.Scan() {
+		k, v, ok := strings.Cut(sc.Text(), "=")
+		if !ok || strings.TrimSpace(k) != "gpgcheck" {
+			continue
+		}
+		if strings.TrimSpace(v) != "1" {
+			return errors.New("package signature verification is disabled by gpgcheck")
+		}
+		gpgcheck = true
+	}
+	if !gpgcheck {
+		return errors.New("package signature verification isn't enabled with gpgcheck=1")
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package clientupdate
+
+import "testing"
+
+func TestCheckAptSources(t *testing.T) {
+	if err := checkAptSources([]byte("deb [signed-by=/usr/share/keyrings/tailscale-archive-keyring.gpg] https://pkgs.tailscale.com/stable/debian bullseye main\n")); err != nil {
+		t.Errorf("signed source: %v", err)
+	}
+	if err := checkAptSources([]byte("deb [trusted=yes] https://pkgs.tailscale.com/stable/debian bullseye main\n")); err == nil {
+		t.Error("trusted=yes source accepted")
+	}
+}
+
+func TestCheckYumRepo(t *testing.T) {
+	tests := []struct {
+		name    string
+		conf    string
+		wantErr bool
+	}{
+		{
+			name: "ok",
+			conf: "[tailscale-stable]\nbaseurl=https://pkgs.tailscale.com/stable/fedora/$basearch\nenabled=1\ngpgcheck=1\nrepo_gpgcheck=1\n",
+		},
+		{
+			name:    "disabled",
+			conf:    "[tailscale-stable]\nbaseurl=https://pkgs.tailscale.com/stable/fedora/$basearch\ngpgcheck=0\n",
+			wantErr: true,
+		},
+		{
+			name:    "missing",
+			conf:    "[tailscale-stable]\nbaseurl=https://pkgs.tailscale.com/stable/fedora/$basearch\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		if err := checkYumRepo([]byte(tt.conf)); (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v; want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux && !windows && !freebsd
+// +build !linux,!windows,!freebsd
+
+package clientupdate
+
+import "context"
+
+func update(ctx context.Context, args Args) error {
+	return ErrUnsupported
+}
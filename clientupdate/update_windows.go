@@ -0,0 +1,163 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package clientupdate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// msiSigner is the organization the Tailscale MSI installers are
+// signed by.
+const msiSigner = "O=Tailscale Inc."
+
+// updaterMSIEnv is the environment variable that tells the copy of the
+// program started by reexec the path of the installer it's to run.
+// It's also how the copy knows not to start another.
+const updaterMSIEnv = "TS_UPDATE_WIN_MSI"
+
+// downloadTimeout is the longest an installer may take to download.
+const downloadTimeout = 30 * time.Minute
+
+func update(ctx context.Context, args Args) error {
+	if err := args.resolveVersion(ctx); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("tailscale-setup-%s-%s.msi", args.Version, runtime.GOARCH)
+	handedOff := os.Getenv(updaterMSIEnv)
+	if handedOff != "" && filepath.Base(handedOff) == name {
+		// This is the copy started by reexec; its installer was
+		// downloaded already. Anything else, such as a rollback,
+		// is downloaded again.
+		return installMSI(ctx, args, handedOff)
+	}
+
+	dir, err := os.MkdirTemp("", "tailscale-update")
+	if err != nil {
+		return err
+	}
+	msi := filepath.Join(dir, name)
+	url := pkgsURL + "/" + string(args.Track) + "/" + name
+	args.Logf("downloading %s", url)
+	if err := download(ctx, url, msi); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	if args.ReexecArgs == nil || handedOff != "" {
+		defer os.RemoveAll(dir)
+		return installMSI(ctx, args, msi)
+	}
+	if err := verifyAuthenticode(ctx, msi); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	return reexec(args, dir, msi)
+}
+
+// installMSI runs the installer msi, after checking its signature.
+func installMSI(ctx context.Context, args Args, msi string) error {
+	if err := verifyAuthenticode(ctx, msi); err != nil {
+		return err
+	}
+	return args.run(ctx, "msiexec.exe", "/i", msi, "/quiet", "/norestart")
+}
+
+// reexec copies this program into dir, where the installer msi is,
+// starts the copy with args.ReexecArgs to run msi, and exits, so that
+// the installer can replace this program's executable. The copy, and
+// msi, are left in dir, as a running program can't remove itself.
+func reexec(args Args, dir, msi string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	updater := filepath.Join(dir, "tailscale-updater.exe")
+	if err := copyFile(exe, updater); err != nil {
+		return fmt.Errorf("copying %s to run the installer from: %w", filepath.Base(exe), err)
+	}
+	cmd := exec.Command(updater, args.ReexecArgs...)
+	cmd.Env = append(os.Environ(), updaterMSIEnv+"="+msi)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	args.Logf("continuing the update in %s, so that the installer can replace this program", updater)
+	os.Exit(0)
+	panic("unreachable")
+}
+
+// copyFile copies the file src to dst, which mustn't exist.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// download writes the contents of url to the file dst.
+func download(ctx context.Context, url, dst string) error {
+	ctx, cancel := context.WithTimeout(ctx, downloadTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("downloading %s: %v", url, res.Status)
+	}
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, res.Body); err != nil {
+		f.Close()
+		return fmt.Errorf("downloading %s: %w", url, err)
+	}
+	return f.Close()
+}
+
+// verifyAuthenticode returns an error unless the file at path has a
+// valid Authenticode signature from Tailscale.
+func verifyAuthenticode(ctx context.Context, path string) error {
+	script := fmt.Sprintf("$s = Get-AuthenticodeSignature -LiteralPath '%s'; $s.Status; $s.SignerCertificate.Subject",
+		strings.ReplaceAll(path, "'", "''"))
+	out, err := execOutput(ctx, "powershell.exe", "-NoProfile", "-NonInteractive", "-Command", script)
+	if err != nil {
+		return fmt.Errorf("checking signature of %s: %w", filepath.Base(path), err)
+	}
+	status, subject, _ := strings.Cut(out, "\n")
+	if status = strings.TrimSpace(status); status != "Valid" {
+		return fmt.Errorf("signature of %s is %s", filepath.Base(path), status)
+	}
+	if !strings.Contains(subject, msiSigner) {
+		return fmt.Errorf("%s is signed by %q, not Tailscale", filepath.Base(path), strings.TrimSpace(subject))
+	}
+	return nil
+}
@@ -47,6 +47,7 @@
 	certDir    = flag.String("certdir", tsweb.DefaultCertDir("derper-certs"), "directory to store LetsEncrypt certs, if addr's port is :443")
 	hostname   = flag.String("hostname", "derp.tailscale.com", "LetsEncrypt host name, if addr's port is :443")
 	runSTUN    = flag.Bool("stun", true, "whether to run a STUN server. It will bind to the same IP (if any) as the --addr flag value.")
+	quicSTUN   = flag.Bool("quic-stun", false, "whether to also answer QUIC-framed STUN probes on UDP port 443, for clients on networks that block the STUN port. It will bind to the same IP (if any) as the --addr flag value.")
 	runDERP    = flag.Bool("derp", true, "whether to run a DERP server. The only reason to set this false is if you're decommissioning a server but want to keep its bootstrap DNS functionality still running.")
 
 	meshPSKFile    = flag.String("mesh-psk-file", defaultMeshPSKFile(), "if non-empty, path to file containing the mesh pre-shared key file. It should contain some hex string; whitespace is trimmed.")
@@ -63,6 +64,7 @@
 	stats             = new(metrics.Set)
 	stunDisposition   = &metrics.LabelMap{Label: "disposition"}
 	stunAddrFamily    = &metrics.LabelMap{Label: "family"}
+	stunFraming       = &metrics.LabelMap{Label: "framing"}
 	tlsRequestVersion = &metrics.LabelMap{Label: "version"}
 	tlsActiveVersion  = &metrics.LabelMap{Label: "version"}
 
@@ -73,11 +75,15 @@
 
 	stunIPv4 = stunAddrFamily.Get("ipv4")
 	stunIPv6 = stunAddrFamily.Get("ipv6")
+
+	stunPlain = stunFraming.Get("plain")
+	stunQUIC  = stunFraming.Get("quic")
 )
 
 func init() {
 	stats.Set("counter_requests", stunDisposition)
 	stats.Set("counter_addrfamily", stunAddrFamily)
+	stats.Set("counter_framing", stunFraming)
 	expvar.Publish("stun", stats)
 	expvar.Publish("derper_tls_request_version", tlsRequestVersion)
 	expvar.Publish("gauge_derper_tls_active_version", tlsActiveVersion)
@@ -222,6 +228,9 @@ func main() {
 
 	if *runSTUN {
 		go serveSTUN(listenHost, *stunPort)
+		if *quicSTUN {
+			go serveSTUN(listenHost, 443)
+		}
 	}
 
 	quietLogger := log.New(logFilter{}, "", 0)
@@ -370,7 +379,10 @@ func serverSTUNListener(ctx context.Context, pc *net.UDPConn) {
 			continue
 		}
 		pkt := buf[:n]
-		if !stun.Is(pkt) {
+		msg, isQUIC := stun.FromQUICRequest(pkt)
+		if isQUIC {
+			pkt = msg
+		} else if !stun.Is(pkt) {
 			stunNotSTUN.Add(1)
 			continue
 		}
@@ -385,7 +397,14 @@ func serverSTUNListener(ctx context.Context, pc *net.UDPConn) {
 			stunIPv6.Add(1)
 		}
 		addr, _ := netip.AddrFromSlice(ua.IP)
-		res := stun.Response(txid, netip.AddrPortFrom(addr, uint16(ua.Port)))
+		var res []byte
+		if isQUIC {
+			stunQUIC.Add(1)
+			res = stun.QUICResponse(txid, netip.AddrPortFrom(addr, uint16(ua.Port)))
+		} else {
+			stunPlain.Add(1)
+			res = stun.Response(txid, netip.AddrPortFrom(addr, uint16(ua.Port)))
+		}
 		_, err = pc.WriteTo(res, ua)
 		if err != nil {
 			stunWriteError.Add(1)
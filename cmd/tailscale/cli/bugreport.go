@@ -7,8 +7,11 @@
 import (
 	"context"
 	"errors"
+	"flag"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/client/tailscale"
+	"tailscale.com/ipn"
 )
 
 var bugReportCmd = &ffcli.Command{
@@ -16,6 +19,23 @@
 	Exec:       runBugReport,
 	ShortHelp:  "Print a shareable identifier to help diagnose issues",
 	ShortUsage: "bugreport [note]",
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("bugreport")
+		fs.BoolVar(&bugReportArgs.diagnose, "diagnose", false, "run additional in-depth checks, such as DERP region reachability, and log the results")
+		fs.StringVar(&bugReportArgs.profile, "profile", "", `with --diagnose, the state key of a stored, non-active profile (e.g. "user-1234") to check`)
+		fs.StringVar(&bugReportArgs.checks, "checks", "", `with --diagnose, comma-separated names of the only checks to run (e.g. "portmap,dns-manager")`)
+		fs.StringVar(&bugReportArgs.category, "category", "", `with --diagnose, comma-separated categories of the only checks to run (e.g. "dns")`)
+		fs.StringVar(&bugReportArgs.redact, "redact", "", `with --diagnose, what to redact from the checks' output before it's logged: comma-separated "ips" to hash IP addresses, "macs" to strip MAC addresses, "prefixes" to keep route prefixes, or "all" for "ips,macs"`)
+		return fs
+	})(),
+}
+
+var bugReportArgs struct {
+	diagnose bool
+	profile  string
+	checks   string
+	category string
+	redact   string
 }
 
 func runBugReport(ctx context.Context, args []string) error {
@@ -27,7 +47,26 @@ func runBugReport(ctx context.Context, args []string) error {
 	default:
 		return errors.New("unknown argumets")
 	}
-	logMarker, err := localClient.BugReport(ctx, note)
+	if bugReportArgs.profile != "" && !bugReportArgs.diagnose {
+		return errors.New("--profile requires --diagnose")
+	}
+	if bugReportArgs.checks != "" && !bugReportArgs.diagnose {
+		return errors.New("--checks requires --diagnose")
+	}
+	if bugReportArgs.category != "" && !bugReportArgs.diagnose {
+		return errors.New("--category requires --diagnose")
+	}
+	if bugReportArgs.redact != "" && !bugReportArgs.diagnose {
+		return errors.New("--redact requires --diagnose")
+	}
+	logMarker, err := localClient.BugReportWithOpts(ctx, tailscale.BugReportOpts{
+		Note:       note,
+		Diagnose:   bugReportArgs.diagnose,
+		Profile:    ipn.StateKey(bugReportArgs.profile),
+		Checks:     splitCommaList(bugReportArgs.checks),
+		Categories: splitCommaList(bugReportArgs.category),
+		Redact:     bugReportArgs.redact,
+	})
 	if err != nil {
 		return err
 	}
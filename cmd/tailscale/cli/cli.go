@@ -162,15 +162,21 @@ func Run(args []string) (err error) {
 			ipCmd,
 			statusCmd,
 			pingCmd,
+			tracerouteCmd,
+			troubleshootCmd,
+			wolCmd,
 			ncCmd,
 			sshCmd,
 			versionCmd,
 			webCmd,
 			fileCmd,
 			bugReportCmd,
+			doctorCmd,
 			certCmd,
 			netlockCmd,
 			licensesCmd,
+			updateCmd,
+			completionCmd,
 		},
 		FlagSet:   rootfs,
 		Exec:      func(context.Context, []string) error { return flag.ErrHelp },
@@ -191,6 +197,10 @@ func Run(args []string) (err error) {
 		rootCmd.Subcommands = append(rootCmd.Subcommands, configureHostCmd)
 	}
 
+	if len(args) > 0 && args[0] == completeCmdName {
+		return runComplete(rootCmd, args[1:])
+	}
+
 	if err := rootCmd.Parse(args); err != nil {
 		if errors.Is(err, flag.ErrHelp) {
 			return nil
@@ -6,6 +6,7 @@
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -13,12 +14,16 @@
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	qt "github.com/frankban/quicktest"
 	"github.com/google/go-cmp/cmp"
+	"github.com/peterbourgon/ff/v3/ffcli"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
 	"tailscale.com/tstest"
+	"tailscale.com/types/key"
 	"tailscale.com/types/persist"
 	"tailscale.com/types/preftype"
 	"tailscale.com/version/distro"
@@ -597,6 +602,29 @@ func TestPrefsFromUpArgs(t *testing.T) {
 			},
 			wantErr: `--exit-node-allow-lan-access can only be used with --exit-node`,
 		},
+		{
+			name: "error_exit_node_exclude_without_exit_node",
+			args: upArgsT{
+				exitNodeExclude: "192.0.2.0/24",
+			},
+			wantErr: `--exit-node-exclude can only be used with --exit-node`,
+		},
+		{
+			name: "error_exit_node_exclude_non_masked",
+			args: upArgsT{
+				exitNodeIP:      "100.105.106.107",
+				exitNodeExclude: "192.0.2.1/24",
+			},
+			wantErr: `192.0.2.1/24 has non-address bits set; expected 192.0.2.0/24`,
+		},
+		{
+			name: "error_derp_avoid_home_region",
+			args: upArgsT{
+				derpHomeRegion:   2,
+				derpAvoidRegions: "1,2",
+			},
+			wantErr: `DERP region 2 can't be both --derp-home-region and in --derp-avoid-regions`,
+		},
 		{
 			name: "error_tag_prefix",
 			args: upArgsT{
@@ -755,7 +783,7 @@ func TestPrefFlagMapping(t *testing.T) {
 		case "WantRunning", "Persist", "LoggedOut":
 			// All explicitly handled (ignored) by checkForAccidentalSettingReverts.
 			continue
-		case "OSVersion", "DeviceModel":
+		case "OSVersion", "DeviceModel", "ExitNodeExcludeApps":
 			// Only used by Android, which doesn't have a CLI mode anyway, so
 			// fine to not map.
 			continue
@@ -840,19 +868,39 @@ func TestUpdatePrefs(t *testing.T) {
 			wantJustEditMP: &ipn.MaskedPrefs{
 				AdvertiseRoutesSet:        true,
 				AdvertiseTagsSet:          true,
+				AllowRemoteDoctorSet:      true,
+				AllowSpeedTestSet:         true,
 				AllowSingleHostsSet:       true,
+				ClampMSSSet:               true,
 				ControlURLSet:             true,
 				CorpDNSSet:                true,
+				DERPAvoidRegionsSet:       true,
+				DERPHomeRegionSet:         true,
+				DERPSendQueueSet:          true,
+				DoctorIntervalSet:         true,
+				DoctorLightweightSet:      true,
+				DoctorLogResultsSet:       true,
 				ExitNodeAllowLANAccessSet: true,
+				ExitNodeExcludeRoutesSet:  true,
 				ExitNodeIDSet:             true,
 				ExitNodeIPSet:             true,
+				ForceDERPSet:              true,
 				HostnameSet:               true,
+				LatencySLOsSet:            true,
+				PowerSaverSet:             true,
 				NetfilterModeSet:          true,
+				NoAutoMTUSet:              true,
 				NoSNATSet:                 true,
 				OperatorUserSet:           true,
+				ReadvertiseRoutesSet:      true,
+				RecvBatchSizeSet:          true,
 				RouteAllSet:               true,
+				RouteMetricSet:            true,
 				RunSSHSet:                 true,
 				ShieldsUpSet:              true,
+				SyntheticMonitorPeerSet:   true,
+				UDPPortRangeSet:           true,
+				UplinkPolicySet:           true,
 				WantRunningSet:            true,
 			},
 		},
@@ -988,3 +1036,104 @@ func TestCleanUpArgs(t *testing.T) {
 		c.Assert(got, qt.DeepEquals, tt.want)
 	}
 }
+
+func TestActivitySummary(t *testing.T) {
+	now := time.Unix(1_000_000, 0)
+	tests := []struct {
+		name string
+		ps   *ipnstate.PeerStatus
+		want string
+	}{
+		{
+			name: "never",
+			ps:   &ipnstate.PeerStatus{LastHandshake: time.Unix(0, 0)},
+			want: "disco ping never, pong never; path relayed; handshake never",
+		},
+		{
+			name: "direct",
+			ps: &ipnstate.PeerStatus{
+				LastDiscoPing: now.Add(-5 * time.Second),
+				LastDiscoPong: now.Add(-90 * time.Second),
+				CurAddr:       "1.2.3.4:41641",
+				CurAddrSince:  now.Add(-3 * time.Hour),
+				LastHandshake: now.Add(-72 * time.Hour),
+			},
+			want: "disco ping 5s ago, pong 1m ago; path direct for 3h; handshake 3d ago",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := activitySummary(tt.ps, now); got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComplete(t *testing.T) {
+	upfs := flag.NewFlagSet("up", flag.ContinueOnError)
+	upfs.Bool("ssh", false, "")
+	upfs.String("hostname", "", "")
+	upfs.String("login-server", "", "")
+	root := &ffcli.Command{
+		Name: "tailscale",
+		Subcommands: []*ffcli.Command{
+			{Name: "up", FlagSet: upfs},
+			{Name: "down"},
+			{Name: "debug", Subcommands: []*ffcli.Command{
+				{Name: "derp-map"},
+				{Name: "daemon-goroutines"},
+			}},
+		},
+	}
+	tests := []struct {
+		words []string
+		want  []string
+	}{
+		{nil, []string{"debug", "down", "up"}},
+		{[]string{"d"}, []string{"debug", "down"}},
+		{[]string{"debug", "d"}, []string{"daemon-goroutines", "derp-map"}},
+		{[]string{"up", "--"}, []string{"--hostname", "--login-server", "--ssh"}},
+		{[]string{"up", "--ssh", "--h"}, []string{"--hostname"}},
+		{[]string{"up", "--hostname", ""}, nil},
+		{[]string{"up", "foo", ""}, nil},
+		{[]string{"nosuch", ""}, nil},
+	}
+	for _, tt := range tests {
+		got := complete(context.Background(), root, tt.words)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("complete(%q) = %q; want %q", tt.words, got, tt.want)
+		}
+	}
+}
+
+func TestExportedEndpoints(t *testing.T) {
+	ip := netip.MustParseAddr("100.101.102.103")
+	wan := netip.MustParseAddrPort("1.2.3.4:41641")
+	lan := netip.MustParseAddrPort("192.168.1.5:41641")
+	j, err := json.Marshal(&ipnstate.NodeEndpoints{
+		NodeKey:      key.NewNode().Public(),
+		TailscaleIPs: []netip.Addr{ip, netip.MustParseAddr("fd7a:115c:a1e0::1")},
+		Endpoints: []tailcfg.Endpoint{
+			{Addr: wan, Type: tailcfg.EndpointSTUN},
+			{Addr: lan, Type: tailcfg.EndpointLocal},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := exportedEndpoints(j, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []netip.AddrPort{wan, lan}; !reflect.DeepEqual(got, want) {
+		t.Errorf("endpoints = %v; want %v", got, want)
+	}
+
+	if _, err := exportedEndpoints(j, netip.MustParseAddr("100.64.0.1")); err == nil {
+		t.Error("imported endpoints exported by another node")
+	}
+	if _, err := exportedEndpoints([]byte(`{"Version": 1}`), ip); err == nil {
+		t.Error("imported endpoints from a file that isn't an export")
+	}
+}
@@ -0,0 +1,276 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/util/dnsname"
+)
+
+var completionCmd = &ffcli.Command{
+	Name:       "completion",
+	ShortUsage: "completion <bash|zsh|fish>",
+	ShortHelp:  "Print a shell completion script",
+	LongHelp: strings.TrimSpace(`
+The 'tailscale completion' command prints a script that makes the shell
+complete tailscale's subcommands and flags, as well as peer names, exit
+nodes and profiles, which it asks tailscaled for as you type.
+
+To load it into the current bash or zsh session:
+
+  source <(tailscale completion bash)
+
+or for fish:
+
+  tailscale completion fish | source
+`),
+	Exec: runCompletion,
+}
+
+func runCompletion(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: tailscale completion <bash|zsh|fish>")
+	}
+	script, ok := completionScripts[args[0]]
+	if !ok {
+		return fmt.Errorf("unsupported shell %q; want bash, zsh or fish", args[0])
+	}
+	outln(strings.TrimSpace(script))
+	return nil
+}
+
+// completeCmdName is the hidden subcommand the completion scripts run to
+// get candidates for the word being completed, as in:
+//
+//	tailscale __complete -- up --exit-node=
+//
+// The words after "--" are those of the command line up to the cursor,
+// without the leading "tailscale"; the last one is the word being
+// completed, possibly empty. It prints one candidate per line.
+const completeCmdName = "__complete"
+
+var completionScripts = map[string]string{
+	// bash splits "--flag=value" at the "=" and only replaces what
+	// follows it, so the "--flag=" prefix is stripped from candidates.
+	"bash": `
+_tailscale() {
+	local line=${COMP_LINE:0:COMP_POINT}
+	local -a words
+	read -ra words <<<"$line"
+	[[ $line == *[[:space:]] ]] && words+=("")
+	local IFS=$'\n'
+	COMPREPLY=($(tailscale __complete -- "${words[@]:1}" 2>/dev/null))
+	COMPREPLY=("${COMPREPLY[@]#--*=}")
+}
+complete -o default -F _tailscale tailscale
+`,
+	"zsh": `
+#compdef tailscale
+_tailscale() {
+	local -a candidates
+	candidates=("${(@f)$(tailscale __complete -- "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+	compadd -Q -- "${candidates[@]}"
+}
+compdef _tailscale tailscale
+`,
+	"fish": `
+complete -c tailscale -f -a '(tailscale __complete -- (commandline -opc)[2..-1] (commandline -ct) 2>/dev/null)'
+`,
+}
+
+// flagValueCompleters complete the values of flags, by flag name.
+var flagValueCompleters = map[string]func(context.Context) ([]string, error){
+	"exit-node": completeExitNodes,
+	"profile":   completeProfiles,
+	"via":       completePeers,
+}
+
+// runComplete prints the completions of the last of words for the
+// command tree rooted at root.
+func runComplete(root *ffcli.Command, words []string) error {
+	if len(words) > 0 && words[0] == "--" {
+		words = words[1:]
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	for _, c := range complete(ctx, root, words) {
+		outln(c)
+	}
+	return nil
+}
+
+// complete returns the candidates for the last of words, which are the
+// command line after the program name, up to the cursor.
+func complete(ctx context.Context, root *ffcli.Command, words []string) []string {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	cur := words[len(words)-1]
+	cmd := root
+	positional := 0 // positional args of cmd before cur
+	valueOf := ""   // if non-empty, the flag that cur is the value of
+	for _, w := range words[:len(words)-1] {
+		if valueOf != "" {
+			valueOf = ""
+			continue
+		}
+		if strings.HasPrefix(w, "-") && w != "-" {
+			name, _, hasValue := strings.Cut(strings.TrimLeft(w, "-"), "=")
+			f := lookupFlag(cmd, name)
+			if f != nil && !hasValue && !isBoolFlag(f) {
+				valueOf = name
+			}
+			continue
+		}
+		if sub := findSubcommand(cmd, w); sub != nil && positional == 0 {
+			cmd = sub
+			continue
+		}
+		positional++
+	}
+
+	var cands []string
+	prefix := cur
+	switch {
+	case valueOf != "":
+		cands = completeFlagValue(ctx, valueOf)
+	case strings.HasPrefix(cur, "-"):
+		if name, _, ok := strings.Cut(strings.TrimLeft(cur, "-"), "="); ok {
+			for _, v := range completeFlagValue(ctx, name) {
+				cands = append(cands, "--"+name+"="+v)
+			}
+			break
+		}
+		if cmd.FlagSet != nil {
+			cmd.FlagSet.VisitAll(func(f *flag.Flag) {
+				cands = append(cands, "--"+f.Name)
+			})
+		}
+	default:
+		if positional == 0 {
+			for _, sub := range cmd.Subcommands {
+				cands = append(cands, sub.Name)
+			}
+		}
+		if positional == 0 && takesPeer(cmd) {
+			peers, _ := completePeers(ctx)
+			if user, _, ok := strings.Cut(cur, "@"); ok && cmd.Name == "ssh" {
+				for i, p := range peers {
+					peers[i] = user + "@" + p
+				}
+			}
+			cands = append(cands, peers...)
+		}
+	}
+
+	var ret []string
+	for _, c := range cands {
+		if strings.HasPrefix(c, prefix) {
+			ret = append(ret, c)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func lookupFlag(cmd *ffcli.Command, name string) *flag.Flag {
+	if cmd.FlagSet == nil {
+		return nil
+	}
+	return cmd.FlagSet.Lookup(name)
+}
+
+func findSubcommand(cmd *ffcli.Command, name string) *ffcli.Command {
+	for _, sub := range cmd.Subcommands {
+		if sub.Name == name {
+			return sub
+		}
+	}
+	return nil
+}
+
+// takesPeer reports whether cmd's first positional argument is a peer,
+// going by its usage.
+func takesPeer(cmd *ffcli.Command) bool {
+	u := cmd.ShortUsage
+	return strings.Contains(u, "hostname") || strings.Contains(u, "<host>")
+}
+
+func completeFlagValue(ctx context.Context, name string) []string {
+	f, ok := flagValueCompleters[name]
+	if !ok {
+		return nil
+	}
+	vals, err := f(ctx)
+	if err != nil {
+		return nil
+	}
+	return vals
+}
+
+// completePeers returns the base names of the peers.
+func completePeers(ctx context.Context) ([]string, error) {
+	st, err := localClient.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var ret []string
+	for _, ps := range st.Peer {
+		if name := peerCompletionName(st, ps); name != "" {
+			ret = append(ret, name)
+		}
+	}
+	return ret, nil
+}
+
+// peerCompletionName returns the name of ps that the CLI accepts in
+// place of its Tailscale IP.
+func peerCompletionName(st *ipnstate.Status, ps *ipnstate.PeerStatus) string {
+	if name := dnsname.TrimSuffix(ps.DNSName, st.MagicDNSSuffix); name != "" {
+		return name
+	}
+	return dnsname.SanitizeHostname(ps.HostName)
+}
+
+// completeExitNodes returns the base names of the peers offering to be
+// an exit node.
+func completeExitNodes(ctx context.Context) ([]string, error) {
+	st, err := localClient.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var ret []string
+	for _, ps := range st.Peer {
+		if !ps.ExitNodeOption {
+			continue
+		}
+		if name := peerCompletionName(st, ps); name != "" {
+			ret = append(ret, name)
+		}
+	}
+	return ret, nil
+}
+
+// completeProfiles returns the state keys of the stored profiles.
+func completeProfiles(ctx context.Context) ([]string, error) {
+	keys, err := localClient.ProfileKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]string, len(keys))
+	for i, k := range keys {
+		ret[i] = string(k)
+	}
+	return ret, nil
+}
@@ -20,14 +20,19 @@
 	"net/netip"
 	"os"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/control/controlhttp"
 	"tailscale.com/hostinfo"
 	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/speedtest"
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/paths"
 	"tailscale.com/safesocket"
@@ -68,6 +73,30 @@
 				return fs
 			})(),
 		},
+		{
+			Name:       "profile",
+			Exec:       runDebugProfile,
+			ShortUsage: "profile [flags] <cpu|heap|allocs|goroutine|mutex|block|threadcreate>",
+			ShortHelp:  "capture a pprof profile of tailscaled",
+			LongHelp: strings.TrimSpace(`
+The 'tailscale debug profile' command captures a profile of the running
+tailscaled in pprof format, for use with "go tool pprof".
+
+CPU, mutex and block profiles are collected over --seconds. Other
+profiles are a snapshot, unless --seconds is given, in which case they
+show the change over that time.
+
+For example:
+
+  tailscale debug profile cpu --seconds=30 -o out.pb.gz
+`),
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("profile")
+				fs.IntVar(&profileArgs.seconds, "seconds", 0, "number of seconds to profile for; 0 means 15 for cpu, mutex and block profiles, and a snapshot otherwise")
+				fs.StringVar(&profileArgs.out, "o", "", "file to write the profile to, or - for stdout; default is tailscaled-<type>.pb.gz")
+				return fs
+			})(),
+		},
 		{
 			Name:      "env",
 			Exec:      runEnv,
@@ -83,6 +112,16 @@
 			Exec:      runHostinfo,
 			ShortHelp: "print hostinfo",
 		},
+		{
+			Name:      "interfaces",
+			Exec:      runDebugInterfaces,
+			ShortHelp: "print network interfaces as seen by tailscaled",
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("interfaces")
+				fs.BoolVar(&debugInterfacesArgs.json, "json", false, "output in JSON format")
+				return fs
+			})(),
+		},
 		{
 			Name:      "local-creds",
 			Exec:      runLocalCreds,
@@ -98,6 +137,120 @@
 			Exec:      localAPIAction("rebind"),
 			ShortHelp: "force a magicsock rebind",
 		},
+		{
+			Name:      "clean-stale-state",
+			Exec:      localAPIAction("clean-stale-state"),
+			ShortHelp: "remove orphaned profiles, temp files, stale pidfiles and dead sockets left by crashes",
+		},
+		{
+			Name:      "logs",
+			Exec:      runDebugLogs,
+			ShortHelp: "print tailscaled's recent logs, kept in memory",
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("logs")
+				fs.DurationVar(&debugLogsArgs.since, "since", 0, "only print logs from this long ago or later; 0 means all that are kept")
+				fs.StringVar(&debugLogsArgs.component, "component", "", "if non-empty, only print logs of this component, such as \"magicsock\"")
+				return fs
+			})(),
+		},
+		{
+			Name:       "set-log-level",
+			Exec:       runSetLogLevel,
+			ShortUsage: "set-log-level [<component>=<level> ...]",
+			ShortHelp:  "change tailscaled's log verbosity per component",
+			LongHelp: strings.TrimSpace(`
+The 'tailscale debug set-log-level' command changes how verbosely
+tailscaled logs, until it restarts. A component is the prefix of a log
+line, such as "magicsock", "dns" or "netcheck", or "all" for the
+default of all components. A level is "info", "verbose", "debug", a
+number, or "default" to remove a component's own level.
+
+For example, "tailscale debug set-log-level magicsock=debug" logs
+magicsock in detail without making the rest of tailscaled noisier.
+
+With no arguments, it prints the current levels.
+`),
+		},
+		{
+			Name:       "set-knob",
+			Exec:       runSetKnob,
+			ShortUsage: "set-knob [<NAME>=<value> ...]",
+			ShortHelp:  "change tailscaled's debug knobs at runtime",
+			LongHelp: strings.TrimSpace(`
+The 'tailscale debug set-knob' command changes one of tailscaled's
+TS_DEBUG_* environment knobs without restarting it, until it restarts.
+Only knobs that tailscaled re-reads at runtime can be changed. An empty
+value unsets a knob.
+
+For example, "tailscale debug set-knob TS_DEBUG_DISCO=true" turns on
+disco logging on a running node.
+
+With no arguments, it prints the knobs that can be changed and their
+current values.
+`),
+		},
+		{
+			Name:       "path-pin",
+			Exec:       runPathPin,
+			ShortUsage: "path-pin [flags] <hostname-or-IP>",
+			ShortHelp:  "restrict the paths used to reach a peer",
+			LongHelp: strings.TrimSpace(`
+The 'tailscale debug path-pin' command overrides the usual best-path
+selection for one peer, until tailscaled restarts. With no flags it
+removes any existing pin.
+
+It's useful when the path that looks best to Tailscale is broken, such
+as a middlebox that passes disco pings but not WireGuard traffic.
+`),
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("path-pin")
+				fs.BoolVar(&pathPinArgs.derpOnly, "derp-only", false, "only reach the peer via DERP")
+				fs.StringVar(&pathPinArgs.endpoint, "endpoint", "", "if non-empty, the only ip:port used to reach the peer directly")
+				fs.StringVar(&pathPinArgs.forbidIfaces, "forbid-interfaces", "", "comma-separated local interface names never used to reach the peer")
+				return fs
+			})(),
+		},
+		debugEndpointsCmd,
+		{
+			Name:       "first-contact",
+			Exec:       runFirstContact,
+			ShortUsage: "first-contact [--json] <hostname-or-IP>",
+			ShortHelp:  "print how long connecting to a peer took, by stage",
+			LongHelp: strings.TrimSpace(`
+The 'tailscale debug first-contact' command prints a trace of the most
+recent connection setup to a peer that had no recent traffic: when it
+was added to WireGuard, when the handshake and disco pings went out,
+when DERP was used, and when the handshake response, a direct path and
+the first data arrived, relative to the first packet sent to it.
+
+It helps tell which stage makes the first packet to an idle peer slow.
+`),
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("first-contact")
+				fs.BoolVar(&firstContactArgs.json, "json", false, "output in JSON format")
+				return fs
+			})(),
+		},
+		{
+			Name:       "host-firewall",
+			Exec:       runHostFirewall,
+			ShortUsage: "host-firewall [--apply]",
+			ShortHelp:  "preview or add host firewall rules allowing direct connections",
+			LongHelp: strings.TrimSpace(`
+The 'tailscale debug host-firewall' command prints the host firewall
+rules (firewalld, nftables, iptables, pf or Windows Firewall) that allow
+inbound UDP to tailscaled's port, which direct connections to this
+machine need, and whether they already exist.
+
+With --apply, it adds them after asking for confirmation.
+`),
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("host-firewall")
+				fs.BoolVar(&hostFirewallArgs.apply, "apply", false, "add the rules to the host firewall")
+				fs.BoolVar(&hostFirewallArgs.yes, "yes", false, "with --apply, don't ask for confirmation")
+				return fs
+			})(),
+		},
 		{
 			Name:      "prefs",
 			Exec:      runPrefs,
@@ -108,6 +261,254 @@
 				return fs
 			})(),
 		},
+		{
+			Name:       "export-state",
+			Exec:       runExportState,
+			ShortUsage: "export-state [file]",
+			ShortHelp:  "write a redacted snapshot of prefs, netmap and health",
+			LongHelp: strings.TrimSpace(`
+The 'tailscale debug export-state' command writes a JSON snapshot of this
+node's prefs, a summary of its network map and its health problems to
+file, or to stdout if file is omitted or "-". It contains no private or
+public keys, no login names, and neither the control server URL nor the
+operator user, so it can be shared with support to reproduce the node's
+configuration.
+`),
+		},
+		{
+			Name:       "import-state",
+			Exec:       runImportState,
+			ShortUsage: "import-state [--dry-run] [--yes] <file>",
+			ShortHelp:  "apply the prefs from a snapshot written by export-state",
+			LongHelp: strings.TrimSpace(`
+The 'tailscale debug import-state' command reads a snapshot written by
+'tailscale debug export-state' from file, or from stdin if file is "-",
+and applies its prefs to this node. Prefs tied to the original machine
+or login, such as the control server, operator user, hostname and
+advertised tags, are left as they are. The network map summary is only printed; integration tests
+can serve its peers with testcontrol.Server.AddSnapshotPeers.
+`),
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("import-state")
+				fs.BoolVar(&importStateArgs.dryRun, "dry-run", false, "print the snapshot and the prefs it would set, without applying them")
+				fs.BoolVar(&importStateArgs.yes, "yes", false, "apply the prefs without asking for confirmation")
+				return fs
+			})(),
+		},
+		{
+			Name:       "netmap-routes",
+			Exec:       runNetmapRoutes,
+			ShortUsage: "netmap-routes [--json]",
+			ShortHelp:  "show which subnet routes and exit nodes are offered and used",
+			LongHelp: strings.TrimSpace(`
+The 'tailscale debug netmap-routes' command lists the subnet routes and
+exit nodes that peers advertise or are approved for in the current
+network map, whether this node uses each, and if not, why: it isn't
+approved, another router is primary for it, the exit node isn't
+selected, or --accept-routes is off. It also notes routes whose router
+is offline or that overlap one of this node's local networks.
+`),
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("netmap-routes")
+				fs.BoolVar(&netmapRoutesArgs.json, "json", false, "output in JSON format")
+				return fs
+			})(),
+		},
+		{
+			Name:       "derp-selection",
+			Exec:       runDERPSelection,
+			ShortUsage: "derp-selection [--json]",
+			ShortHelp:  "show the home DERP region and why it was selected",
+			LongHelp: strings.TrimSpace(`
+The 'tailscale debug derp-selection' command shows the home DERP region
+and why it was selected: it's pinned with --derp-home-region, it has
+the lowest latency, or the lower latency ones are avoided with
+--derp-avoid-regions. If every region that replied is avoided, the
+lowest latency one is used anyway. It lists each region's latency as measured by
+the last netcheck.
+`),
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("derp-selection")
+				fs.BoolVar(&derpSelectionArgs.json, "json", false, "output in JSON format")
+				return fs
+			})(),
+		},
+		{
+			Name:       "dns-cache",
+			Exec:       runDNSCache,
+			ShortUsage: "dns-cache [--flush] [--json]",
+			ShortHelp:  "show or flush the MagicDNS forwarder's cache",
+			LongHelp: strings.TrimSpace(`
+The 'tailscale debug dns-cache' command lists the responses that the
+MagicDNS forwarder has cached from upstream resolvers, with how many
+forwarded queries were answered from the cache. Responses are cached
+for their TTL, NXDOMAIN and empty responses for the TTL in their SOA
+record, at most 5 minutes. The TS_DNS_CACHE_SIZE and
+TS_DNS_CACHE_MAX_TTL environment variables of tailscaled change how
+many responses are cached and the longest they're cached for; a size
+of 0 disables the cache. With --flush, it drops the cached responses.
+`),
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("dns-cache")
+				fs.BoolVar(&dnsCacheArgs.flush, "flush", false, "drop all cached responses")
+				fs.BoolVar(&dnsCacheArgs.json, "json", false, "output in JSON format")
+				return fs
+			})(),
+		},
+		{
+			Name:       "dns-queries",
+			Exec:       runDNSQueries,
+			ShortUsage: "dns-queries [--json]",
+			ShortHelp:  "show the stats of DNS queries to MagicDNS, by client",
+			LongHelp: strings.TrimSpace(`
+The 'tailscale debug dns-queries' command shows how many DNS queries
+local clients sent to MagicDNS at 100.100.100.100:53 since tailscaled
+started, how many failed and why, and how long they took to answer,
+then the same for each client. Failures are "queue-full" (too many
+queries in flight), "malformed", "timeout" or "upstream" (forwarding
+failed), "servfail" and "refused" (answered with those codes) and
+"closed" (tailscaled was shutting down). It tells a broken DNS path
+apart from one broken client or upstream resolver.
+`),
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("dns-queries")
+				fs.BoolVar(&dnsQueriesArgs.json, "json", false, "output in JSON format")
+				return fs
+			})(),
+		},
+		{
+			Name:       "proxy-conns",
+			Exec:       runProxyConns,
+			ShortUsage: "proxy-conns [--json]",
+			ShortHelp:  "show the connections of the SOCKS5 and HTTP proxies",
+			LongHelp: strings.TrimSpace(`
+The 'tailscale debug proxy-conns' command lists the open and most
+recent closed connections that tailscaled's outbound SOCKS5 and HTTP
+proxies (--socks5-server and --outbound-http-proxy-listen) made for
+their clients: the target asked for, how its name was resolved and the
+address dialed, the bytes through the connection, and the first error,
+such as a failure to resolve the name or to dial it.
+`),
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("proxy-conns")
+				fs.BoolVar(&proxyConnsArgs.json, "json", false, "output in JSON format")
+				return fs
+			})(),
+		},
+		{
+			Name:       "router-state",
+			Exec:       runRouterState,
+			ShortUsage: "router-state [--json]",
+			ShortHelp:  "show the routes tailscaled installed and which failed",
+			LongHelp: strings.TrimSpace(`
+The 'tailscale debug router-state' command shows the routes and
+addresses that tailscaled last configured the OS with and, on Linux,
+each route or address that it failed to add or remove, with the error,
+such as "file exists" when another route is in the way or "operation
+not permitted" without CAP_NET_ADMIN. It fails if any did.
+`),
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("router-state")
+				fs.BoolVar(&routerStateArgs.json, "json", false, "output in JSON format")
+				return fs
+			})(),
+		},
+		{
+			Name:       "filter",
+			Exec:       runFilterStats,
+			ShortUsage: "filter [--json]",
+			ShortHelp:  "show which packet filter rules are matching traffic",
+			LongHelp: strings.TrimSpace(`
+The 'tailscale debug filter' command shows the rules of the packet
+filter, as derived from the tailnet's ACLs, with how many packets each
+accepted, and how many packets the filter dropped for each reason,
+since the filter was last replaced. A rule only counts the packets
+that start a flow, such as TCP SYNs.
+`),
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("filter")
+				fs.BoolVar(&filterStatsArgs.json, "json", false, "output in JSON format")
+				return fs
+			})(),
+		},
+		{
+			Name:       "doctor-peer",
+			Exec:       runDoctorPeer,
+			ShortUsage: "doctor-peer [--json] <hostname-or-IP>",
+			ShortHelp:  "run a peer's doctor checks and show the results",
+			LongHelp: strings.TrimSpace(`
+The 'tailscale debug doctor-peer' command asks a peer to run the same
+in-depth diagnostic checks as 'tailscale bugreport --diagnose' and
+prints what each check found. The peer must have opted in with
+'tailscale up --allow-remote-doctor', and this node must be owned by
+the same user or be granted the doctor-peer capability in the tailnet
+policy.
+`),
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("doctor-peer")
+				fs.BoolVar(&doctorPeerArgs.json, "json", false, "output in JSON format")
+				return fs
+			})(),
+		},
+		{
+			Name:       "speedtest",
+			Exec:       runSpeedTestPeer,
+			ShortUsage: "speedtest [--duration=5s] [--json] <hostname-or-IP>",
+			ShortHelp:  "measure throughput to a peer",
+			LongHelp: strings.TrimSpace(`
+The 'tailscale debug speedtest' command measures TCP throughput between
+this node and a peer, first downloading from the peer and then
+uploading to it, over the peer API. The peer's tailscaled serves the
+test itself, so nothing needs to run on it, but it must have opted in
+with 'tailscale up --allow-speedtest', and this node must be owned by
+the same user or be granted the speedtest-peer capability in the
+tailnet policy. It reports the throughput in each direction, the TCP
+retransmits where known, and whether the traffic went direct or via a
+DERP relay.
+`),
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("speedtest")
+				fs.DurationVar(&speedTestPeerArgs.duration, "duration", speedtest.DefaultDuration, fmt.Sprintf("how long to test each direction for, between %v and %v", speedtest.MinDuration, speedtest.MaxDuration))
+				fs.BoolVar(&speedTestPeerArgs.json, "json", false, "output in JSON format")
+				return fs
+			})(),
+		},
+		{
+			Name:       "diff-diagnostics",
+			Exec:       runDiffDiagnostics,
+			ShortUsage: "diff-diagnostics <before.json> <after.json>",
+			ShortHelp:  "compare two saved diagnostics files",
+			LongHelp: strings.TrimSpace(`
+The 'tailscale debug diff-diagnostics' command compares two structured
+diagnostics files and prints what changed between them: system routes,
+interfaces, DNS configuration, subnet routes, netcheck results, health
+problems and doctor output. It works offline, and accepts the JSON from
+the web UI's diagnostics page, the snapshots tailscaled writes to its
+diag-snapshots directory when health degrades, and state snapshots from
+'tailscale debug export-state'. Aspects missing from either file are
+skipped, and latencies are ignored as they always differ.
+`),
+		},
+		{
+			Name:       "packet-path-stats",
+			Exec:       runPacketPathStats,
+			ShortUsage: "packet-path-stats [--duration=5s]",
+			ShortHelp:  "measure where packets spend their time in tailscaled",
+			LongHelp: strings.TrimSpace(`
+The 'tailscale debug packet-path-stats' command measures, for the given
+duration, how many packets each layer of tailscaled's data path handles
+and how long it takes on average: reading from and writing to the TUN
+device, the packet filter, and sending and receiving in magicsock. Run
+it while traffic is flowing to find which layer is the bottleneck on a
+slow machine. wireguard-go's encryption isn't measured directly; compare
+with a CPU profile from 'tailscale debug profile' for that.
+`),
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("packet-path-stats")
+				fs.DurationVar(&packetPathStatsArgs.duration, "duration", 5*time.Second, "how long to measure for")
+				return fs
+			})(),
+		},
 		{
 			Name:      "watch-ipn",
 			Exec:      runWatchIPN,
@@ -162,13 +563,69 @@ func outName(dst string) string {
 	return dst
 }
 
+var profileArgs struct {
+	seconds int
+	out     string
+}
+
+// profileTypes maps the profile types accepted by "tailscale debug
+// profile" to their pprof names.
+var profileTypes = map[string]string{
+	"cpu":          "profile",
+	"heap":         "heap",
+	"allocs":       "allocs",
+	"goroutine":    "goroutine",
+	"mutex":        "mutex",
+	"block":        "block",
+	"threadcreate": "threadcreate",
+}
+
+func runDebugProfile(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: tailscale debug profile [flags] <type>")
+	}
+	typ := args[0]
+	name, ok := profileTypes[typ]
+	if !ok {
+		return fmt.Errorf("unknown profile type %q; want cpu, heap, allocs, goroutine, mutex, block or threadcreate", typ)
+	}
+	sec := profileArgs.seconds
+	if sec < 0 {
+		return errors.New("--seconds must not be negative")
+	}
+	switch name {
+	case "profile", "mutex", "block":
+		if sec == 0 {
+			sec = 15
+		}
+	}
+	out := profileArgs.out
+	if out == "" {
+		out = "tailscaled-" + typ + ".pb.gz"
+	}
+	if sec > 0 {
+		log.Printf("Capturing %s profile for %v seconds ...", typ, sec)
+	} else {
+		log.Printf("Capturing %s profile ...", typ)
+	}
+	v, err := localClient.Profile(ctx, name, sec)
+	if err != nil {
+		return err
+	}
+	if err := writeProfile(out, v); err != nil {
+		return err
+	}
+	log.Printf("%s profile written to %s", typ, outName(out))
+	return nil
+}
+
 func runDebug(ctx context.Context, args []string) error {
 	if len(args) > 0 {
 		return errors.New("unknown arguments")
 	}
 	var usedFlag bool
 	if out := debugArgs.cpuFile; out != "" {
-		usedFlag = true // TODO(bradfitz): add "profile" subcommand
+		usedFlag = true
 		log.Printf("Capturing CPU profile for %v seconds ...", debugArgs.cpuSec)
 		if v, err := localClient.Profile(ctx, "profile", debugArgs.cpuSec); err != nil {
 			return err
@@ -180,7 +637,7 @@ func runDebug(ctx context.Context, args []string) error {
 		}
 	}
 	if out := debugArgs.memFile; out != "" {
-		usedFlag = true // TODO(bradfitz): add "profile" subcommand
+		usedFlag = true
 		log.Printf("Capturing memory profile ...")
 		if v, err := localClient.Profile(ctx, "heap", 0); err != nil {
 			return err
@@ -237,99 +694,1024 @@ func runLocalCreds(ctx context.Context, args []string) error {
 	return nil
 }
 
-var prefsArgs struct {
-	pretty bool
+var pathPinArgs struct {
+	derpOnly     bool
+	endpoint     string
+	forbidIfaces string
 }
 
-func runPrefs(ctx context.Context, args []string) error {
-	prefs, err := localClient.GetPrefs(ctx)
+var hostFirewallArgs struct {
+	apply bool
+	yes   bool
+}
+
+func runHostFirewall(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unknown arguments")
+	}
+	p, err := localClient.HostFirewall(ctx)
 	if err != nil {
 		return err
 	}
-	if prefsArgs.pretty {
-		outln(prefs.Pretty())
-	} else {
-		j, _ := json.MarshalIndent(prefs, "", "\t")
-		outln(string(j))
+	if p.Installed {
+		printf("%s already allows UDP port %d.\n", p.Backend, p.Port)
+		return nil
+	}
+	printf("%s doesn't allow UDP port %d yet. These commands add the rules:\n\n", p.Backend, p.Port)
+	for _, c := range p.Commands {
+		printf("  %s\n", strings.ReplaceAll(c.String(), "\n", "\n  "))
+	}
+	for _, n := range p.Notes {
+		printf("\nNote: %s\n", n)
+	}
+	if !hostFirewallArgs.apply {
+		printf("\nRun with --apply to add them.\n")
+		return nil
 	}
+	outln()
+	if !hostFirewallArgs.yes && !confirm("Add these rules?") {
+		return errAborted
+	}
+	p, err = localClient.ApplyHostFirewall(ctx)
+	if err != nil {
+		return err
+	}
+	if !p.Installed {
+		return fmt.Errorf("added the rules, but %s still doesn't appear to allow UDP port %d", p.Backend, p.Port)
+	}
+	printf("%s now allows UDP port %d.\n", p.Backend, p.Port)
 	return nil
 }
 
-var watchIPNArgs struct {
-	netmap bool
+func runPathPin(ctx context.Context, args []string) error {
+	if len(args) != 1 || args[0] == "" {
+		return errors.New("usage: path-pin [flags] <hostname-or-IP>")
+	}
+	ipStr, self, err := tailscaleIPFromArg(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if self {
+		return fmt.Errorf("%v is the local Tailscale IP", ipStr)
+	}
+	ip, err := netip.ParseAddr(ipStr)
+	if err != nil {
+		return err
+	}
+	var pin ipnstate.PathPin
+	pin.DERPOnly = pathPinArgs.derpOnly
+	if pathPinArgs.endpoint != "" {
+		pin.Endpoint, err = netip.ParseAddrPort(pathPinArgs.endpoint)
+		if err != nil {
+			return fmt.Errorf("invalid --endpoint: %w", err)
+		}
+	}
+	if pin.DERPOnly && pin.Endpoint.IsValid() {
+		return errors.New("--derp-only and --endpoint are mutually exclusive")
+	}
+	if pathPinArgs.forbidIfaces != "" {
+		pin.ForbidInterfaces = strings.Split(pathPinArgs.forbidIfaces, ",")
+	}
+	if err := localClient.SetPeerPathPin(ctx, ip, pin); err != nil {
+		return err
+	}
+	printf("path pin for %v: %v\n", ip, pin)
+	return nil
 }
 
-func runWatchIPN(ctx context.Context, args []string) error {
-	c, bc, ctx, cancel := connect(ctx)
-	defer cancel()
+var debugEndpointsCmd = &ffcli.Command{
+	Name:       "endpoints",
+	ShortUsage: "endpoints <export|import> ...",
+	ShortHelp:  "export this node's endpoints, or import a peer's by hand",
+	LongHelp: strings.TrimSpace(`
+The 'tailscale debug endpoints' commands copy the UDP endpoints one node
+advertises to a peer by hand, bypassing the control server and disco
+call-me-maybe messages, to tell whether it's discovery or connectivity
+that fails when two nodes can't connect directly.
 
-	bc.SetNotifyCallback(func(n ipn.Notify) {
-		if !watchIPNArgs.netmap {
-			n.NetMap = nil
-		}
-		j, _ := json.MarshalIndent(n, "", "\t")
-		printf("%s\n", j)
-	})
-	bc.RequestEngineStatus()
-	pump(ctx, bc, c)
-	return errors.New("exit")
+Run 'tailscale debug endpoints export > eps.json' on one node, copy
+eps.json to the other, and run 'tailscale debug endpoints import
+<node> eps.json' there. If the nodes then connect directly, the
+endpoints weren't being exchanged; if not, packets between them are
+being dropped.
+`),
+	Subcommands: []*ffcli.Command{
+		{
+			Name:       "export",
+			Exec:       runDebugEndpointsExport,
+			ShortUsage: "endpoints export",
+			ShortHelp:  "print the endpoints this node advertises, as JSON",
+		},
+		{
+			Name:       "import",
+			Exec:       runDebugEndpointsImport,
+			ShortUsage: "endpoints import [--clear] <hostname-or-IP> {<file> | - | <ip:port>...}",
+			ShortHelp:  "add endpoints to those used to reach a peer",
+			LongHelp: strings.TrimSpace(`
+The 'tailscale debug endpoints import' command adds endpoints to those
+tailscaled tries to reach a peer at, as if the peer had advertised
+them, until tailscaled restarts: either those in a file written by
+'tailscale debug endpoints export' on the peer ("-" for stdin), or the
+ip:ports given. It replaces those imported for the peer before. With
+--clear, it removes them.
+`),
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("import")
+				fs.BoolVar(&debugEndpointsArgs.clear, "clear", false, "remove the endpoints imported for the peer")
+				return fs
+			})(),
+		},
+	},
+	Exec: func(context.Context, []string) error {
+		return errors.New("endpoints subcommand required; run 'tailscale debug endpoints -h' for details")
+	},
 }
 
-func runDERPMap(ctx context.Context, args []string) error {
-	dm, err := localClient.CurrentDERPMap(ctx)
-	if err != nil {
-		return fmt.Errorf(
-			"failed to get local derp map, instead `curl %s/derpmap/default`: %w", ipn.DefaultControlURL, err,
-		)
-	}
-	enc := json.NewEncoder(Stdout)
-	enc.SetIndent("", "\t")
-	enc.Encode(dm)
-	return nil
+var debugEndpointsArgs struct {
+	clear bool
 }
 
-func localAPIAction(action string) func(context.Context, []string) error {
-	return func(ctx context.Context, args []string) error {
-		if len(args) > 0 {
-			return errors.New("unexpected arguments")
-		}
-		return localClient.DebugAction(ctx, action)
+func runDebugEndpointsExport(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
 	}
-}
-
-func runEnv(ctx context.Context, args []string) error {
-	for _, e := range os.Environ() {
-		outln(e)
+	eps, err := localClient.LocalEndpoints(ctx)
+	if err != nil {
+		return err
 	}
-	return nil
+	e := json.NewEncoder(Stdout)
+	e.SetIndent("", "\t")
+	return e.Encode(eps)
 }
 
-func runStat(ctx context.Context, args []string) error {
-	for _, a := range args {
-		fi, err := os.Lstat(a)
+func runDebugEndpointsImport(ctx context.Context, args []string) error {
+	const usage = "usage: endpoints import [--clear] <hostname-or-IP> {<file> | - | <ip:port>...}"
+	if len(args) == 0 || args[0] == "" {
+		return errors.New(usage)
+	}
+	ipStr, self, err := tailscaleIPFromArg(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if self {
+		return fmt.Errorf("%v is the local Tailscale IP", ipStr)
+	}
+	ip, err := netip.ParseAddr(ipStr)
+	if err != nil {
+		return err
+	}
+	fromFile := false // rather than ip:ports
+	if len(args) == 2 {
+		_, err := netip.ParseAddrPort(args[1])
+		fromFile = err != nil
+	}
+	var eps []netip.AddrPort
+	switch {
+	case debugEndpointsArgs.clear:
+		if len(args) > 1 {
+			return errors.New("--clear takes no endpoints")
+		}
+	case len(args) == 1:
+		return errors.New(usage)
+	case fromFile:
+		var j []byte
+		if args[1] == "-" {
+			j, err = io.ReadAll(os.Stdin)
+		} else {
+			j, err = os.ReadFile(args[1])
+		}
 		if err != nil {
-			printf("%s: %v\n", a, err)
-			continue
+			return err
 		}
-		printf("%s: %v, %v\n", a, fi.Mode(), fi.Size())
-		if fi.IsDir() {
-			ents, _ := os.ReadDir(a)
-			for i, ent := range ents {
-				if i == 25 {
-					printf("  ...\n")
-					break
-				}
-				printf("  - %s\n", ent.Name())
+		eps, err = exportedEndpoints(j, ip)
+		if err != nil {
+			return err
+		}
+	default:
+		for _, arg := range args[1:] {
+			ep, err := netip.ParseAddrPort(arg)
+			if err != nil {
+				return fmt.Errorf("invalid endpoint %q: %w", arg, err)
 			}
+			eps = append(eps, ep)
 		}
 	}
+	if err := localClient.ImportPeerEndpoints(ctx, ip, eps); err != nil {
+		return err
+	}
+	if len(eps) == 0 {
+		printf("removed the endpoints imported for %v\n", ip)
+		return nil
+	}
+	printf("imported endpoints for %v: %v\n", ip, eps)
 	return nil
 }
 
-func runHostinfo(ctx context.Context, args []string) error {
-	hi := hostinfo.New()
-	j, _ := json.MarshalIndent(hi, "", "  ")
-	os.Stdout.Write(j)
+// exportedEndpoints returns the endpoints of j, the output of
+// "tailscale debug endpoints export" on the peer with Tailscale IP ip.
+func exportedEndpoints(j []byte, ip netip.Addr) ([]netip.AddrPort, error) {
+	var ne ipnstate.NodeEndpoints
+	if err := json.Unmarshal(j, &ne); err != nil {
+		return nil, fmt.Errorf("parsing endpoints: %w", err)
+	}
+	if ne.NodeKey.IsZero() {
+		return nil, errors.New("not endpoints written by 'tailscale debug endpoints export'")
+	}
+	found := false
+	for _, a := range ne.TailscaleIPs {
+		found = found || a == ip
+	}
+	if !found {
+		return nil, fmt.Errorf("endpoints are of the node with Tailscale IPs %v, not %v", ne.TailscaleIPs, ip)
+	}
+	if len(ne.Endpoints) == 0 {
+		return nil, errors.New("the node had no endpoints to export")
+	}
+	eps := make([]netip.AddrPort, len(ne.Endpoints))
+	for i, ep := range ne.Endpoints {
+		eps[i] = ep.Addr
+	}
+	return eps, nil
+}
+
+var firstContactArgs struct {
+	json bool
+}
+
+func runFirstContact(ctx context.Context, args []string) error {
+	if len(args) != 1 || args[0] == "" {
+		return errors.New("usage: first-contact [--json] <hostname-or-IP>")
+	}
+	ipStr, self, err := tailscaleIPFromArg(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if self {
+		return fmt.Errorf("%v is the local Tailscale IP", ipStr)
+	}
+	ip, err := netip.ParseAddr(ipStr)
+	if err != nil {
+		return err
+	}
+	t, err := localClient.FirstContact(ctx, ip)
+	if err != nil {
+		return err
+	}
+	if firstContactArgs.json {
+		e := json.NewEncoder(Stdout)
+		e.SetIndent("", "\t")
+		return e.Encode(t)
+	}
+	printf("first contact with %v at %v:\n", ip, t.Start.Format(time.RFC3339Nano))
+	for _, s := range t.Stages {
+		printf("  +%-10v %-18s %s\n", s.Elapsed.Round(time.Millisecond), s.Name, s.Detail)
+	}
+	switch {
+	case t.Complete:
+		printf("complete\n")
+	case t.InProgress:
+		printf("in progress\n")
+	default:
+		printf("incomplete; no data from the peer\n")
+	}
+	return nil
+}
+
+var prefsArgs struct {
+	pretty bool
+}
+
+func runPrefs(ctx context.Context, args []string) error {
+	prefs, err := localClient.GetPrefs(ctx)
+	if err != nil {
+		return err
+	}
+	if prefsArgs.pretty {
+		outln(prefs.Pretty())
+	} else {
+		j, _ := json.MarshalIndent(prefs, "", "\t")
+		outln(string(j))
+	}
+	return nil
+}
+
+func runExportState(ctx context.Context, args []string) error {
+	if len(args) > 1 {
+		return errors.New("usage: export-state [file]")
+	}
+	snap, err := localClient.StateSnapshot(ctx)
+	if err != nil {
+		return err
+	}
+	j, err := json.MarshalIndent(snap, "", "\t")
+	if err != nil {
+		return err
+	}
+	j = append(j, '\n')
+	if len(args) == 0 || args[0] == "-" {
+		Stdout.Write(j)
+		return nil
+	}
+	if err := os.WriteFile(args[0], j, 0600); err != nil {
+		return err
+	}
+	printf("Wrote state snapshot to %s.\n", args[0])
+	return nil
+}
+
+var netmapRoutesArgs struct {
+	json bool
+}
+
+func runNetmapRoutes(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	routes, err := localClient.DebugNetmapRoutes(ctx)
+	if err != nil {
+		return err
+	}
+	if netmapRoutesArgs.json {
+		e := json.NewEncoder(Stdout)
+		e.SetIndent("", "\t")
+		return e.Encode(routes)
+	}
+	if len(routes) == 0 {
+		outln("No peers offer subnet routes or exit nodes.")
+		return nil
+	}
+	w := tabwriter.NewWriter(Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "NODE\tROUTE\tADVERTISED\tAPPROVED\tSTATUS\n")
+	for _, r := range routes {
+		status := "used"
+		if !r.Used {
+			status = "not used: " + r.Reason
+		}
+		if r.Note != "" {
+			status += " (" + r.Note + ")"
+		}
+		route := r.Route.String()
+		if r.ExitNode {
+			route += " (exit node)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%v\t%v\t%s\n", r.NodeName, route, r.Advertised, r.Approved, status)
+	}
+	return w.Flush()
+}
+
+var derpSelectionArgs struct {
+	json bool
+}
+
+func runDERPSelection(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	sel, err := localClient.DebugDERPSelection(ctx)
+	if err != nil {
+		return err
+	}
+	if derpSelectionArgs.json {
+		e := json.NewEncoder(Stdout)
+		e.SetIndent("", "\t")
+		return e.Encode(sel)
+	}
+	if sel.Home == 0 {
+		outln("No home DERP region.")
+	} else {
+		printf("Home DERP region: %d (%s)\n", sel.Home, sel.Reason)
+	}
+	if sel.AsOf.IsZero() {
+		return nil
+	}
+	printf("Latencies as of %v:\n", sel.AsOf.Local().Format(time.RFC3339))
+	w := tabwriter.NewWriter(Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "ID\tCODE\tLATENCY\tNOTE\n")
+	for _, r := range sel.Regions {
+		latency := "-"
+		if r.Latency != 0 {
+			latency = r.Latency.Round(time.Millisecond / 10).String()
+		}
+		var notes []string
+		if r.ID == sel.Home {
+			notes = append(notes, "home")
+		}
+		if r.ID == sel.Pinned {
+			notes = append(notes, "pinned")
+		}
+		if r.Avoided {
+			notes = append(notes, "avoided")
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", r.ID, r.Code, latency, strings.Join(notes, ", "))
+	}
+	return w.Flush()
+}
+
+var dnsCacheArgs struct {
+	flush bool
+	json  bool
+}
+
+func runDNSCache(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	var st *ipnstate.DNSCache
+	var err error
+	if dnsCacheArgs.flush {
+		st, err = localClient.FlushDNSCache(ctx)
+	} else {
+		st, err = localClient.DebugDNSCache(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	if dnsCacheArgs.json {
+		e := json.NewEncoder(Stdout)
+		e.SetIndent("", "\t")
+		return e.Encode(st)
+	}
+	if st.MaxEntries == 0 {
+		outln("DNS cache disabled.")
+		return nil
+	}
+	if dnsCacheArgs.flush {
+		outln("Flushed DNS cache.")
+	}
+	printf("%d of %d entries, max TTL %v; %d hits, %d misses\n", len(st.Entries), st.MaxEntries, st.MaxTTL, st.Hits, st.Misses)
+	if len(st.Entries) == 0 {
+		return nil
+	}
+	now := time.Now()
+	w := tabwriter.NewWriter(Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "NAME\tTYPE\tRCODE\tANSWERS\tEXPIRES IN\n")
+	for _, ent := range st.Entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%v\n", ent.Name, ent.Type, ent.RCode, ent.Answers, ent.Expires.Sub(now).Round(time.Second))
+	}
+	return w.Flush()
+}
+
+var dnsQueriesArgs struct {
+	json bool
+}
+
+func runDNSQueries(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	st, err := localClient.DebugDNSQueries(ctx)
+	if err != nil {
+		return err
+	}
+	if dnsQueriesArgs.json {
+		e := json.NewEncoder(Stdout)
+		e.SetIndent("", "\t")
+		return e.Encode(st)
+	}
+	if st.Queries == 0 {
+		outln("No DNS queries yet.")
+		return nil
+	}
+	printf("%d queries since %v; latency over %v: p50 %v, p90 %v, p99 %v\n", st.Queries, st.Since.Local().Format(time.RFC3339), st.LatencyWindow, st.P50, st.P90, st.P99)
+	if len(st.Errors) > 0 {
+		printf("Errors: %s\n", formatDNSQueryErrors(st.Errors))
+	}
+	outln()
+	now := time.Now()
+	w := tabwriter.NewWriter(Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "CLIENT\tQUERIES\tERRORS\tMEAN LATENCY\tLAST QUERY\n")
+	for _, c := range st.Clients {
+		errs := "-"
+		if c.Errors > 0 {
+			errs = fmt.Sprintf("%d (%s)", c.Errors, formatDNSQueryErrors(c.ErrorClasses))
+		}
+		fmt.Fprintf(w, "%v\t%d\t%s\t%v\t%v ago\n", c.Addr, c.Queries, errs, c.MeanLatency().Round(time.Microsecond), now.Sub(c.Last).Round(time.Second))
+	}
+	return w.Flush()
+}
+
+// formatDNSQueryErrors returns errs, counts of errors by class, as
+// "class=n" pairs sorted by class.
+func formatDNSQueryErrors(errs map[string]int64) string {
+	var parts []string
+	for class, n := range errs {
+		parts = append(parts, fmt.Sprintf("%s=%d", class, n))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, " ")
+}
+
+var proxyConnsArgs struct {
+	json bool
+}
+
+func runProxyConns(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	st, err := localClient.DebugProxyConns(ctx)
+	if err != nil {
+		return err
+	}
+	if proxyConnsArgs.json {
+		e := json.NewEncoder(Stdout)
+		e.SetIndent("", "\t")
+		return e.Encode(st)
+	}
+	printf("%d connections, %d failed\n", st.Total, st.Failed)
+	if len(st.Conns) == 0 {
+		return nil
+	}
+	now := time.Now()
+	w := tabwriter.NewWriter(Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "ID\tPROXY\tTARGET\tRESOLVED\tROUTE\tDURATION\tIN\tOUT\tERROR\n")
+	for _, c := range st.Conns {
+		resolved := "-"
+		if c.Resolved != "" {
+			resolved = c.Resolved + " (" + c.ResolvedBy + ")"
+		}
+		route := c.Route
+		if route == "" {
+			route = "-"
+		}
+		dur := now.Sub(c.Start).Round(time.Second).String() + " (open)"
+		if !c.End.IsZero() {
+			dur = c.End.Sub(c.Start).Round(time.Millisecond).String()
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%d\t%d\t%s\n", c.ID, c.Proxy, c.Target, resolved, route, dur, c.BytesIn, c.BytesOut, c.Err)
+	}
+	return w.Flush()
+}
+
+var routerStateArgs struct {
+	json bool
+}
+
+func runRouterState(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	st, err := localClient.DebugRouterState(ctx)
+	if err != nil {
+		return err
+	}
+	if routerStateArgs.json {
+		e := json.NewEncoder(Stdout)
+		e.SetIndent("", "\t")
+		if err := e.Encode(st); err != nil {
+			return err
+		}
+	} else {
+		printf("addresses: %v\n", st.LocalAddrs)
+		printf("routes: %d\n", len(st.Routes))
+		if len(st.LocalRoutes) > 0 {
+			printf("local routes: %v\n", st.LocalRoutes)
+		}
+		if st.RouteMetric != nil {
+			printf("route metric: %d\n", *st.RouteMetric)
+		}
+		for _, r := range st.DampenedRoutes {
+			printf("dampened: %v until %v\n", r.Route, r.Until.Format(time.RFC3339))
+		}
+		switch {
+		case !st.ErrorsTracked:
+			printf("route failures: not tracked on this platform\n")
+		case len(st.Errors) == 0:
+			printf("route failures: none\n")
+		default:
+			w := tabwriter.NewWriter(Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintf(w, "OP\tKIND\tROUTE\tERROR\n")
+			for _, e := range st.Errors {
+				fmt.Fprintf(w, "%s\t%s\t%v\t%s\n", e.Op, e.Kind, e.Route, e.Err)
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if len(st.Errors) > 0 {
+		return fmt.Errorf("%d route change(s) failed", len(st.Errors))
+	}
+	return nil
+}
+
+var filterStatsArgs struct {
+	json bool
+}
+
+func runFilterStats(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	st, err := localClient.DebugFilterStats(ctx)
+	if err != nil {
+		return err
+	}
+	if filterStatsArgs.json {
+		e := json.NewEncoder(Stdout)
+		e.SetIndent("", "\t")
+		return e.Encode(st)
+	}
+	printf("since: %v\n", st.Since.Format(time.RFC3339))
+	if st.ShieldsUp {
+		printf("shields up: all incoming connections are blocked\n")
+	}
+	w := tabwriter.NewWriter(Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "#\tPACKETS\tRULE\n")
+	for i, r := range st.Rules {
+		fmt.Fprintf(w, "%d\t%d\t%s\n", i, r.Packets, r.Rule)
+	}
+	if len(st.Drops) > 0 {
+		fmt.Fprintf(w, "\nDIR\tPACKETS\tDROPPED FOR\n")
+		for _, d := range st.Drops {
+			fmt.Fprintf(w, "%s\t%d\t%s\n", d.Dir, d.Packets, d.Reason)
+		}
+	}
+	return w.Flush()
+}
+
+var doctorPeerArgs struct {
+	json bool
+}
+
+func runDoctorPeer(ctx context.Context, args []string) error {
+	if len(args) != 1 || args[0] == "" {
+		return errors.New("usage: doctor-peer [--json] <hostname-or-IP>")
+	}
+	ip, err := peerIPFromArg(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	res, err := localClient.DoctorPeer(ctx, ip)
+	if err != nil {
+		return err
+	}
+	if doctorPeerArgs.json {
+		e := json.NewEncoder(Stdout)
+		e.SetIndent("", "\t")
+		return e.Encode(res)
+	}
+	failed := 0
+	for _, c := range res.Checks {
+		status := "ok"
+		switch {
+		case c.Error != "":
+			status = "FAILED: " + c.Error
+			failed++
+		case c.Skipped != "":
+			status = "skipped: " + c.Skipped
+		case c.Severity == "warning":
+			status = "warning: " + c.Summary
+		case c.Severity == "info":
+			status = "ok (info: " + c.Summary + ")"
+		}
+		printf("%s: %s\n", c.Name, status)
+		for _, step := range c.Remediation {
+			printf("    to fix: %s\n", step)
+		}
+		for _, line := range c.Log {
+			printf("    %s\n", line)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks on %s failed", failed, len(res.Checks), res.Peer)
+	}
+	return nil
+}
+
+var speedTestPeerArgs struct {
+	duration time.Duration
+	json     bool
+}
+
+func runSpeedTestPeer(ctx context.Context, args []string) error {
+	if len(args) != 1 || args[0] == "" {
+		return errors.New("usage: speedtest [--duration=5s] [--json] <hostname-or-IP>")
+	}
+	ip, err := peerIPFromArg(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if !speedTestPeerArgs.json {
+		printf("Testing throughput to %s for %v in each direction...\n", args[0], speedTestPeerArgs.duration)
+	}
+	res, err := localClient.SpeedTestPeer(ctx, ip, speedTestPeerArgs.duration)
+	if err != nil {
+		return err
+	}
+	if speedTestPeerArgs.json {
+		e := json.NewEncoder(Stdout)
+		e.SetIndent("", "\t")
+		return e.Encode(res)
+	}
+	path := res.Path
+	if path == "" {
+		path = "unknown"
+	}
+	printf("Peer: %s\n", res.Peer)
+	printf("Path: %s\n", path)
+	for _, d := range []struct {
+		name string
+		r    apitype.SpeedTestResult
+	}{
+		{"Download", res.Download},
+		{"Upload", res.Upload},
+	} {
+		printf("%-8s  %8.2f Mbit/s  (%.2f MB in %v", d.name, d.r.MBitsPerSecond(), float64(d.r.Bytes)/1e6, d.r.Duration.Round(time.Millisecond))
+		if d.r.Retransmits >= 0 {
+			printf(", %d retransmits", d.r.Retransmits)
+		}
+		printf(")\n")
+	}
+	return nil
+}
+
+var packetPathStatsArgs struct {
+	duration time.Duration
+}
+
+func runPacketPathStats(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	printf("Measuring for %v...\n", packetPathStatsArgs.duration)
+	st, err := localClient.DebugPacketPathStats(ctx, packetPathStatsArgs.duration)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "LAYER\tPACKETS\tPACKETS/S\tTIME\tPER PACKET\n")
+	for _, l := range st.Layers {
+		var rate float64
+		if st.Duration > 0 {
+			rate = float64(l.Packets) / st.Duration.Seconds()
+		}
+		fmt.Fprintf(w, "%s\t%d\t%.0f\t%v\t%v\n", l.Name, l.Packets, rate, l.Time.Round(time.Microsecond), l.PerPacket())
+	}
+	w.Flush()
+	printf("\nProcess heap allocations: %d (%d bytes) in %v\n", st.HeapAllocs, st.HeapAllocBytes, st.Duration)
+	return nil
+}
+
+var importStateArgs struct {
+	dryRun bool
+	yes    bool
+}
+
+func runImportState(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: import-state [--dry-run] [--yes] <file>")
+	}
+	var j []byte
+	var err error
+	if args[0] == "-" {
+		if !importStateArgs.yes && !importStateArgs.dryRun {
+			return errors.New("reading the snapshot from stdin requires --yes or --dry-run")
+		}
+		j, err = io.ReadAll(os.Stdin)
+	} else {
+		j, err = os.ReadFile(args[0])
+	}
+	if err != nil {
+		return err
+	}
+	snap := new(ipn.StateSnapshot)
+	if err := json.Unmarshal(j, snap); err != nil {
+		return fmt.Errorf("parsing snapshot: %w", err)
+	}
+	switch {
+	case snap.Version == 0:
+		return errors.New("not a state snapshot written by 'tailscale debug export-state'")
+	case snap.Version > ipn.StateSnapshotVersion:
+		return fmt.Errorf("snapshot version %d is newer than this version of Tailscale supports (%d)", snap.Version, ipn.StateSnapshotVersion)
+	}
+
+	printf("Snapshot taken %v of a %s node running %s, state %s.\n", snap.Time.Format(time.RFC3339), snap.OS, snap.TailscaleVersion, snap.BackendState)
+	if nm := snap.NetMap; nm != nil {
+		printf("Network map: %s in %s, %d peers, %d packet filter rules.\n", nm.Name, nm.Domain, len(nm.Peers), nm.PacketFilterRules)
+	}
+	for _, h := range snap.Health {
+		printf("Health: %s\n", h)
+	}
+	mp := snap.ImportPrefs()
+	if mp == nil {
+		return errors.New("snapshot has no prefs to apply")
+	}
+	printf("Prefs to apply: %s\n", mp.Pretty())
+	if importStateArgs.dryRun {
+		return nil
+	}
+	if !importStateArgs.yes && !confirm("Apply these prefs?") {
+		return errAborted
+	}
+	if _, err := localClient.EditPrefs(ctx, mp); err != nil {
+		return err
+	}
+	printf("Applied prefs from snapshot.\n")
+	return nil
+}
+
+var watchIPNArgs struct {
+	netmap bool
+}
+
+func runWatchIPN(ctx context.Context, args []string) error {
+	c, bc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	bc.SetNotifyCallback(func(n ipn.Notify) {
+		if !watchIPNArgs.netmap {
+			n.NetMap = nil
+		}
+		j, _ := json.MarshalIndent(n, "", "\t")
+		printf("%s\n", j)
+	})
+	bc.RequestEngineStatus()
+	pump(ctx, bc, c)
+	return errors.New("exit")
+}
+
+func runDERPMap(ctx context.Context, args []string) error {
+	dm, err := localClient.CurrentDERPMap(ctx)
+	if err != nil {
+		return fmt.Errorf(
+			"failed to get local derp map, instead `curl %s/derpmap/default`: %w", ipn.DefaultControlURL, err,
+		)
+	}
+	enc := json.NewEncoder(Stdout)
+	enc.SetIndent("", "\t")
+	enc.Encode(dm)
+	return nil
+}
+
+func localAPIAction(action string) func(context.Context, []string) error {
+	return func(ctx context.Context, args []string) error {
+		if len(args) > 0 {
+			return errors.New("unexpected arguments")
+		}
+		return localClient.DebugAction(ctx, action)
+	}
+}
+
+func runEnv(ctx context.Context, args []string) error {
+	for _, e := range os.Environ() {
+		outln(e)
+	}
+	return nil
+}
+
+func runStat(ctx context.Context, args []string) error {
+	for _, a := range args {
+		fi, err := os.Lstat(a)
+		if err != nil {
+			printf("%s: %v\n", a, err)
+			continue
+		}
+		printf("%s: %v, %v\n", a, fi.Mode(), fi.Size())
+		if fi.IsDir() {
+			ents, _ := os.ReadDir(a)
+			for i, ent := range ents {
+				if i == 25 {
+					printf("  ...\n")
+					break
+				}
+				printf("  - %s\n", ent.Name())
+			}
+		}
+	}
+	return nil
+}
+
+func runHostinfo(ctx context.Context, args []string) error {
+	hi := hostinfo.New()
+	j, _ := json.MarshalIndent(hi, "", "  ")
+	os.Stdout.Write(j)
+	return nil
+}
+
+var debugInterfacesArgs struct {
+	json bool
+}
+
+var debugLogsArgs struct {
+	since     time.Duration
+	component string
+}
+
+func runDebugLogs(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	logs, err := localClient.RecentLogs(ctx, debugLogsArgs.since, debugLogsArgs.component)
+	if err != nil {
+		return err
+	}
+	Stdout.Write(logs)
+	return nil
+}
+
+func runSetLogLevel(ctx context.Context, args []string) error {
+	levels, err := localClient.LogLevels(ctx)
+	if err != nil {
+		return err
+	}
+	for _, arg := range args {
+		component, level, ok := strings.Cut(arg, "=")
+		if !ok || component == "" || level == "" {
+			return fmt.Errorf("invalid argument %q; want <component>=<level>", arg)
+		}
+		levels, err = localClient.SetLogLevel(ctx, component, level)
+		if err != nil {
+			return err
+		}
+	}
+	components := make([]string, 0, len(levels))
+	for c := range levels {
+		components = append(components, c)
+	}
+	sort.Strings(components)
+	for _, c := range components {
+		printf("%s=%d\n", c, levels[c])
+	}
+	return nil
+}
+
+func runSetKnob(ctx context.Context, args []string) error {
+	knobs, err := localClient.DebugKnobs(ctx)
+	if err != nil {
+		return err
+	}
+	for _, arg := range args {
+		name, val, ok := strings.Cut(arg, "=")
+		if !ok || name == "" {
+			return fmt.Errorf("invalid argument %q; want <NAME>=<value>", arg)
+		}
+		knobs, err = localClient.SetDebugKnob(ctx, name, val)
+		if err != nil {
+			return err
+		}
+	}
+	for _, k := range knobs {
+		printf("%s=%s\n", k.Name, k.Value)
+	}
+	return nil
+}
+
+func runDebugInterfaces(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	ni, err := localClient.NetInterfaces(ctx)
+	if err != nil {
+		return err
+	}
+	if debugInterfacesArgs.json {
+		j, err := json.MarshalIndent(ni, "", "\t")
+		if err != nil {
+			return err
+		}
+		printf("%s\n", j)
+		return nil
+	}
+
+	printf("default route: %s\n", ni.DefaultRouteInterface)
+	printf("have v4: %v, v6: %v, expensive: %v\n", ni.HaveV4, ni.HaveV6, ni.IsExpensive)
+	if ni.HTTPProxy != "" {
+		printf("HTTP proxy: %s\n", ni.HTTPProxy)
+	}
+	if ni.PAC != "" {
+		printf("PAC: %s\n", ni.PAC)
+	}
+	outln()
+	tw := tabwriter.NewWriter(Stdout, 0, 2, 2, ' ', 0)
+	fmt.Fprintf(tw, "IDX\tNAME\tCLASS\tMTU\tFLAGS\tADDRS\n")
+	for _, iface := range ni.Interfaces {
+		name := iface.Name
+		if name == ni.DefaultRouteInterface {
+			name += "*"
+		}
+		if iface.Desc != "" {
+			name += " (" + iface.Desc + ")"
+		}
+		addrs := make([]string, len(iface.Addrs))
+		for i, a := range iface.Addrs {
+			addrs[i] = a.String()
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%s\t%s\n", iface.Index, name, iface.Class, iface.MTU, iface.Flags, strings.Join(addrs, " "))
+	}
+	tw.Flush()
+
+	if len(ni.RecentChanges) > 0 {
+		outln()
+		outln("recent link changes:")
+		for _, c := range ni.RecentChanges {
+			kind := "minor"
+			if c.Major {
+				kind = "major"
+			}
+			printf("  %s %s %s\n", c.Time.Format(time.RFC3339), kind, c.State)
+		}
+	}
 	return nil
 }
 
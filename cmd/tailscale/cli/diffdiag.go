@@ -0,0 +1,267 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/net/netcheck"
+	"tailscale.com/tailcfg"
+)
+
+// diagnosticsFile is the union of the structured diagnostics files that
+// 'tailscale debug diff-diagnostics' understands: the JSON from the web
+// UI's diagnostics page (ipnstate.Diagnostics), the snapshots
+// tailscaled writes to its diag-snapshots directory when health
+// degrades, and state snapshots from 'tailscale debug export-state'.
+// Fields a kind of file lacks are left zero.
+type diagnosticsFile struct {
+	Version    int // non-zero only in state snapshots
+	Time       time.Time
+	Routes     string             `json:",omitempty"`
+	Interfaces string             `json:",omitempty"`
+	Doctor     []string           `json:",omitempty"`
+	Health     []string           `json:",omitempty"`
+	NetInfo    *tailcfg.NetInfo   `json:",omitempty"`
+	Netcheck   *netcheck.Report   `json:",omitempty"`
+	NetMap     *ipn.NetMapSummary `json:",omitempty"`
+}
+
+// diagDiffSection is the difference in one aspect of two diagnostics
+// files, as lines removed from the first and added in the second.
+type diagDiffSection struct {
+	Name    string
+	Removed []string
+	Added   []string
+}
+
+func runDiffDiagnostics(ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: diff-diagnostics <before.json> <after.json>")
+	}
+	a, err := readDiagnosticsFile(args[0])
+	if err != nil {
+		return err
+	}
+	b, err := readDiagnosticsFile(args[1])
+	if err != nil {
+		return err
+	}
+	printf("--- %s %s\n", args[0], diagTimeString(a.Time))
+	printf("+++ %s %s\n", args[1], diagTimeString(b.Time))
+	secs := diffDiagnostics(a, b)
+	if len(secs) == 0 {
+		printf("no differences\n")
+		return nil
+	}
+	for _, s := range secs {
+		printf("\n%s:\n", s.Name)
+		for _, l := range s.Removed {
+			printf("  - %s\n", l)
+		}
+		for _, l := range s.Added {
+			printf("  + %s\n", l)
+		}
+	}
+	return nil
+}
+
+func readDiagnosticsFile(name string) (*diagnosticsFile, error) {
+	j, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	d := new(diagnosticsFile)
+	if err := json.Unmarshal(j, d); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", name, err)
+	}
+	return d, nil
+}
+
+func diagTimeString(t time.Time) string {
+	if t.IsZero() {
+		return "(no time)"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// diffDiagnostics returns the sections in which a and b differ. Aspects
+// missing from either file aren't compared.
+func diffDiagnostics(a, b *diagnosticsFile) []diagDiffSection {
+	var ret []diagDiffSection
+	add := func(name string, x, y []string) {
+		removed, added := diffLines(x, y)
+		if len(removed) > 0 || len(added) > 0 {
+			ret = append(ret, diagDiffSection{Name: name, Removed: removed, Added: added})
+		}
+	}
+	if a.Routes != "" && b.Routes != "" {
+		add("routes", routeTableLines(a.Routes), routeTableLines(b.Routes))
+	}
+	if a.Interfaces != "" && b.Interfaces != "" {
+		add("interfaces", interfaceStateLines(a.Interfaces), interfaceStateLines(b.Interfaces))
+	}
+	if a.NetMap != nil && b.NetMap != nil {
+		add("DNS", flattenJSON("", a.NetMap.DNS), flattenJSON("", b.NetMap.DNS))
+		add("subnet routes", peerRouteLines(a.NetMap), peerRouteLines(b.NetMap))
+	}
+	if a.NetInfo != nil && b.NetInfo != nil {
+		add("netinfo", withoutLatency(flattenJSON("", a.NetInfo)), withoutLatency(flattenJSON("", b.NetInfo)))
+	}
+	if a.Netcheck != nil && b.Netcheck != nil {
+		add("netcheck", withoutLatency(flattenJSON("", a.Netcheck)), withoutLatency(flattenJSON("", b.Netcheck)))
+	}
+	if a.Version != 0 && b.Version != 0 {
+		// Only state snapshots have health; empty means healthy.
+		add("health", a.Health, b.Health)
+	}
+	if a.Doctor != nil && b.Doctor != nil {
+		add("doctor", a.Doctor, b.Doctor)
+	}
+	return ret
+}
+
+// diffLines returns the lines of a missing from b and the lines of b
+// missing from a, each in their original order. Repeated lines are
+// matched up by count.
+func diffLines(a, b []string) (removed, added []string) {
+	count := map[string]int{}
+	for _, l := range b {
+		count[l]++
+	}
+	for _, l := range a {
+		if count[l] > 0 {
+			count[l]--
+			continue
+		}
+		removed = append(removed, l)
+	}
+	count = map[string]int{}
+	for _, l := range a {
+		count[l]++
+	}
+	for _, l := range b {
+		if count[l] > 0 {
+			count[l]--
+			continue
+		}
+		added = append(added, l)
+	}
+	return removed, added
+}
+
+// routeTableLines returns the non-empty lines of a route table as
+// printed by the platform's usual tool, with whitespace normalized so
+// column alignment changes don't count as differences.
+func routeTableLines(s string) []string {
+	var ret []string
+	for _, l := range strings.Split(s, "\n") {
+		if f := strings.Fields(l); len(f) > 0 {
+			ret = append(ret, strings.Join(f, " "))
+		}
+	}
+	return ret
+}
+
+// ifaceRx matches the interfaces in the "ifs={...}" part of an
+// interfaces.State string: a name, then either its addresses in
+// brackets or "down".
+var ifaceRx = regexp.MustCompile(`([^:\]]+?):(\[[^\]]*\]|down)`)
+
+// interfaceStateLines parses the interfaces.State string in a
+// diagnostic snapshot into a line per interface, plus one for the
+// default route interface. If s isn't in the expected format, it's
+// returned as a single line.
+func interfaceStateLines(s string) []string {
+	var ret []string
+	if _, rest, ok := strings.Cut(s, "defaultRoute="); ok {
+		def, _, _ := strings.Cut(rest, " ifs={")
+		ret = append(ret, "default route interface: "+def)
+	}
+	_, rest, ok := strings.Cut(s, "ifs={")
+	if !ok {
+		return []string{s}
+	}
+	ifs, rest, _ := strings.Cut(rest, "}")
+	for _, m := range ifaceRx.FindAllStringSubmatch(ifs, -1) {
+		ret = append(ret, strings.TrimSpace(m[1])+": "+m[2])
+	}
+	for _, f := range strings.Fields(strings.TrimSuffix(rest, "}")) {
+		ret = append(ret, f)
+	}
+	return ret
+}
+
+// peerRouteLines returns a line for each subnet route a peer in nm is
+// primary for.
+func peerRouteLines(nm *ipn.NetMapSummary) []string {
+	var ret []string
+	for _, p := range nm.Peers {
+		for _, r := range p.PrimaryRoutes {
+			ret = append(ret, fmt.Sprintf("%v via %s", r, p.Name))
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// withoutLatency removes lines about latencies, which differ between
+// any two measurements and would drown out meaningful changes.
+func withoutLatency(lines []string) []string {
+	var ret []string
+	for _, l := range lines {
+		if !strings.Contains(l, "Latency") {
+			ret = append(ret, l)
+		}
+	}
+	return ret
+}
+
+// flattenJSON returns v as JSON flattened into sorted "path: value"
+// lines, one per scalar, so that the lines of two values can be
+// compared with diffLines.
+func flattenJSON(prefix string, v any) []string {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return []string{fmt.Sprintf("%s: %v", prefix, err)}
+	}
+	var x any
+	if err := json.Unmarshal(j, &x); err != nil {
+		return []string{fmt.Sprintf("%s: %v", prefix, err)}
+	}
+	var ret []string
+	var walk func(path string, x any)
+	walk = func(path string, x any) {
+		switch x := x.(type) {
+		case map[string]any:
+			for k, v := range x {
+				p := k
+				if path != "" {
+					p = path + "." + k
+				}
+				walk(p, v)
+			}
+		case []any:
+			for i, v := range x {
+				walk(fmt.Sprintf("%s[%d]", path, i), v)
+			}
+		default:
+			j, _ := json.Marshal(x)
+			ret = append(ret, fmt.Sprintf("%s: %s", path, j))
+		}
+	}
+	walk(prefix, x)
+	sort.Strings(ret)
+	return ret
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cli
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	removed, added := diffLines(
+		[]string{"a", "b", "b", "c"},
+		[]string{"b", "c", "d"},
+	)
+	if want := []string{"a", "b"}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("removed = %q; want %q", removed, want)
+	}
+	if want := []string{"d"}; !reflect.DeepEqual(added, want) {
+		t.Errorf("added = %q; want %q", added, want)
+	}
+}
+
+func TestInterfaceStateLines(t *testing.T) {
+	got := interfaceStateLines("interfaces.State{defaultRoute=eth0 ifs={eth0:[10.0.0.2/24 fd7a:115c::1/64] Wi-Fi 2:down} httpproxy=http://proxy:3128 v4=true v6=false}")
+	want := []string{
+		"default route interface: eth0",
+		"eth0: [10.0.0.2/24 fd7a:115c::1/64]",
+		"Wi-Fi 2: down",
+		"httpproxy=http://proxy:3128",
+		"v4=true",
+		"v6=false",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q\nwant %q", got, want)
+	}
+}
+
+func TestDiffDiagnostics(t *testing.T) {
+	a := &diagnosticsFile{
+		Routes: "default via 192.168.1.1 dev eth0\n10.0.0.0/8  dev tailscale0\n",
+		Doctor: []string{"derp: ok"},
+	}
+	b := &diagnosticsFile{
+		Routes: "default via 10.1.1.1 dev wlan0\n10.0.0.0/8 dev tailscale0\n",
+		Doctor: []string{"derp: ok", "mtu: uplink MTU 1300 is too small"},
+	}
+	got := diffDiagnostics(a, b)
+	want := []diagDiffSection{
+		{
+			Name:    "routes",
+			Removed: []string{"default via 192.168.1.1 dev eth0"},
+			Added:   []string{"default via 10.1.1.1 dev wlan0"},
+		},
+		{
+			Name:  "doctor",
+			Added: []string{"mtu: uplink MTU 1300 is too small"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestFlattenJSON(t *testing.T) {
+	got := flattenJSON("", map[string]any{
+		"Domains": []string{"a.example", "b.example"},
+		"Routes":  map[string]any{"corp.example": nil},
+		"Proxied": true,
+	})
+	want := []string{
+		`Domains[0]: "a.example"`,
+		`Domains[1]: "b.example"`,
+		`Proxied: true`,
+		`Routes.corp.example: null`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q\nwant %q", got, want)
+	}
+}
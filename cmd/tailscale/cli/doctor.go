@@ -0,0 +1,466 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/doctor/report"
+	"tailscale.com/ipn"
+)
+
+var doctorCmd = &ffcli.Command{
+	Name:       "doctor",
+	Exec:       runDoctor,
+	ShortUsage: "doctor [--checks=name,...] [--category=name,...] [--redact=ips,macs] [--json | --format=html|markdown] [--verbose]",
+	ShortHelp:  "Run in-depth diagnostic checks",
+	LongHelp: strings.TrimSpace(`
+
+The 'tailscale doctor' command runs the same in-depth diagnostic checks
+as 'tailscale bugreport --diagnose' and prints one line per check: its
+name, then "pass", "info", "warn", "FAIL" or "skip" and why. "info"
+marks something worth knowing that isn't a problem. A check that
+failed or warned may be followed by the steps to fix what it found.
+While the checks run, if standard error is a terminal, a line there
+shows which are running and how far along they are.
+
+With --category, only the checks in any of the named categories run,
+to target the area being debugged: "network", "dns", "firewall",
+"platform", "performance" or "external". It can be combined with
+--checks, in which case a check must be named by both.
+
+If tailscaled was started with --doctor-checks-dir, the executables in
+that directory are run as checks too, named "ext-" and their file name,
+so that sites can add their own diagnostics. They're all in the
+"external" category.
+
+The checks run in parallel. On a small device, setting
+TS_DOCTOR_MAX_PARALLEL for tailscaled, such as with "tailscale debug
+set-knob", limits how many run at once; 1 runs them one at a time.
+
+With --redact, IP addresses are hashed and MAC addresses stripped from
+the output, so that it can be shared without revealing the network.
+The same address hashes the same way within a run, but not across
+runs.
+
+With --format=html or --format=markdown, the results are printed as a
+report to attach to or paste into a support ticket instead: a table
+summarizing the checks, then a section for each with the steps to fix
+what it found and, collapsed, what it reported and logged. Combine it
+with --redact to share it outside your organization.
+
+The command exits with status 1 if any check failed, or with --strict
+if any warned, so that it can be used in provisioning scripts and
+monitoring. It exits with status 2 if the checks couldn't be run, such
+as when tailscaled isn't running.
+
+tailscaled keeps the last runs of all checks that weren't redacted,
+including scheduled ones, in its state. 'tailscale doctor runs' lists
+them and 'tailscale doctor diff' shows what changed between two of
+them, such as a default route that changed or DNS servers that went
+away.
+
+`),
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("doctor")
+		fs.StringVar(&doctorArgs.checks, "checks", "", `comma-separated names of the only checks to run (e.g. "derp,mtu")`)
+		fs.StringVar(&doctorArgs.category, "category", "", `comma-separated categories of the only checks to run: "network", "dns", "firewall", "platform", "performance" or "external"`)
+		fs.StringVar(&doctorArgs.profile, "profile", "", `the state key of a stored, non-active profile (e.g. "user-1234") to check too`)
+		fs.StringVar(&doctorArgs.redact, "redact", "", `what to redact from the output: comma-separated "ips" to hash IP addresses, "macs" to strip MAC addresses, "prefixes" to keep route prefixes, or "all" for "ips,macs"`)
+		fs.BoolVar(&doctorArgs.json, "json", false, "output in JSON format")
+		fs.StringVar(&doctorArgs.format, "format", "", `output a report to share instead: "html" or "markdown"`)
+		fs.BoolVar(&doctorArgs.verbose, "verbose", false, "also print what each check logged")
+		fs.BoolVar(&doctorArgs.strict, "strict", false, "exit with status 1 if any check warned, not just if one failed")
+		return fs
+	})(),
+	Subcommands: []*ffcli.Command{
+		{
+			Name:       "runs",
+			Exec:       runDoctorRuns,
+			ShortUsage: "doctor runs [--json]",
+			ShortHelp:  "List the past doctor runs tailscaled kept",
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("runs")
+				fs.BoolVar(&doctorRunsArgs.json, "json", false, "output in JSON format")
+				return fs
+			})(),
+		},
+		{
+			Name:       "diff",
+			Exec:       runDoctorDiff,
+			ShortUsage: "doctor diff [--json] [<a> [<b>]]",
+			ShortHelp:  "Show what changed between two past doctor runs",
+			LongHelp: strings.TrimSpace(`
+
+The 'tailscale doctor diff' command shows what changed in the results
+of the checks from past run <a> to past run <b>, by default the
+second-to-last and last runs. Each is the index of a run as listed by
+'tailscale doctor runs', counting back from the last if negative (so
+-1 is the last), or a time such as 2022-10-04T09:00:00Z or a date such
+as 2022-10-04, selecting the last run at or before it.
+
+`),
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("diff")
+				fs.BoolVar(&doctorRunsArgs.json, "json", false, "output in JSON format")
+				return fs
+			})(),
+		},
+	},
+}
+
+var doctorRunsArgs struct {
+	json bool
+}
+
+var doctorArgs struct {
+	checks   string
+	category string
+	profile  string
+	redact   string
+	json     bool
+	format   string
+	verbose  bool
+	strict   bool
+}
+
+func runDoctor(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	var render func(io.Writer, *report.Report) error
+	switch doctorArgs.format {
+	case "":
+	case "html":
+		render = report.HTML
+	case "markdown", "md":
+		render = report.Markdown
+	default:
+		return fmt.Errorf("unknown --format %q; want \"html\" or \"markdown\"", doctorArgs.format)
+	}
+	if render != nil && doctorArgs.json {
+		return errors.New("--json and --format are mutually exclusive")
+	}
+	start := time.Now()
+	checks := splitCommaList(doctorArgs.checks)
+	cats := splitCommaList(doctorArgs.category)
+	var res []apitype.DoctorCheckResult
+	var err error
+	if isTerminal(Stderr) {
+		// Show which checks are running, so that slow ones such as
+		// probing every DERP node don't make it appear hung.
+		sp := newDoctorSpinner(Stderr)
+		res, err = localClient.DoctorWithProgress(ctx, ipn.StateKey(doctorArgs.profile), checks, cats, doctorArgs.redact, sp.update)
+		sp.close()
+	} else {
+		res, err = localClient.Doctor(ctx, ipn.StateKey(doctorArgs.profile), checks, cats, doctorArgs.redact)
+	}
+	if err != nil {
+		fmt.Fprintf(Stderr, "%v\n", fixTailscaledConnectError(err))
+		os.Exit(2)
+	}
+	switch {
+	case render != nil:
+		rep := &report.Report{Time: start, Checks: res}
+		if st, err := localClient.StatusWithoutPeers(ctx); err == nil {
+			rep.Version = st.Version
+			if st.Self != nil {
+				rep.Host = st.Self.HostName
+			}
+		}
+		if err := render(Stdout, rep); err != nil {
+			return err
+		}
+	case doctorArgs.json:
+		e := json.NewEncoder(Stdout)
+		e.SetIndent("", "\t")
+		if err := e.Encode(res); err != nil {
+			return err
+		}
+	default:
+		for _, r := range res {
+			status := report.Status(r)
+			printf("%-4s %s\n", status, doctorLine(r))
+			if status == "FAIL" || status == "warn" {
+				for _, step := range r.Remediation {
+					printf("     to fix: %s\n", step)
+				}
+			}
+			if doctorArgs.verbose {
+				for _, line := range r.Log {
+					printf("     | %s\n", line)
+				}
+			}
+		}
+	}
+	if doctorFailed(res, doctorArgs.strict) {
+		os.Exit(1)
+	}
+	return nil
+}
+
+func runDoctorRuns(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	runs, err := localClient.DoctorRuns(ctx)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	if doctorRunsArgs.json {
+		e := json.NewEncoder(Stdout)
+		e.SetIndent("", "\t")
+		return e.Encode(runs)
+	}
+	if len(runs) == 0 {
+		printf("No doctor runs kept yet.\n")
+		return nil
+	}
+	w := tabwriter.NewWriter(Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "#\tTIME\tKIND\tRESULTS\n")
+	for i, run := range runs {
+		kind := "asked"
+		switch {
+		case run.Scheduled && run.Lightweight:
+			kind = "scheduled, lightweight"
+		case run.Scheduled:
+			kind = "scheduled"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", i, run.Time.Local().Format(time.RFC3339), kind, report.Counts(run.Checks))
+	}
+	return w.Flush()
+}
+
+func runDoctorDiff(ctx context.Context, args []string) error {
+	a, b := "-2", "-1"
+	switch len(args) {
+	case 0:
+	case 1:
+		a = args[0]
+	case 2:
+		a, b = args[0], args[1]
+	default:
+		return errors.New("usage: tailscale doctor diff [<a> [<b>]]")
+	}
+	diff, err := localClient.DiffDoctorRuns(ctx, a, b)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	if doctorRunsArgs.json {
+		e := json.NewEncoder(Stdout)
+		e.SetIndent("", "\t")
+		return e.Encode(diff)
+	}
+	printf("Changes from %v to %v:\n", diff.A.Local().Format(time.RFC3339), diff.B.Local().Format(time.RFC3339))
+	if len(diff.Changes) == 0 {
+		printf("  none\n")
+	}
+	for _, c := range diff.Changes {
+		printf("  %s\n", doctorChangeLine(c))
+	}
+	return nil
+}
+
+// splitCommaList returns the non-empty, space-trimmed elements of the
+// comma-separated list s.
+func splitCommaList(s string) []string {
+	var ret []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+// doctorChangeLine returns the line shown for the change c between two
+// doctor runs.
+func doctorChangeLine(c apitype.DoctorCheckChange) string {
+	switch {
+	case c.Field == "" && c.Old == "":
+		return fmt.Sprintf("%s: new check: %s", c.Check, c.New)
+	case c.Field == "":
+		return fmt.Sprintf("%s: check gone: %s", c.Check, c.Old)
+	}
+	old, new := c.Old, c.New
+	if old == "" {
+		old = "(none)"
+	}
+	if new == "" {
+		new = "(none)"
+	}
+	return fmt.Sprintf("%s: %s: %s -> %s", c.Check, c.Field, old, new)
+}
+
+// isTerminal reports whether w is a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// doctorSpinnerLen is the longest line doctorSpinner shows, so that it
+// doesn't wrap in a narrow terminal, leaving lines it can't clear.
+const doctorSpinnerLen = 79
+
+// doctorSpinner shows, on one line that it redraws, a spinner and the
+// doctor checks that are running and how far along they are.
+type doctorSpinner struct {
+	w    io.Writer
+	stop chan struct{}
+	done chan struct{}
+
+	mu       sync.Mutex
+	running  []apitype.DoctorProgress // in the order they started
+	finished int
+	frame    int
+	shown    int // length of the line shown
+}
+
+func newDoctorSpinner(w io.Writer) *doctorSpinner {
+	sp := &doctorSpinner{
+		w:    w,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go sp.run()
+	return sp
+}
+
+func (sp *doctorSpinner) run() {
+	defer close(sp.done)
+	t := time.NewTicker(100 * time.Millisecond)
+	defer t.Stop()
+	for {
+		select {
+		case <-sp.stop:
+			return
+		case <-t.C:
+		}
+		sp.mu.Lock()
+		sp.frame++
+		sp.draw(doctorProgressLine(`|/-\`[sp.frame%4], sp.finished, sp.running))
+		sp.mu.Unlock()
+	}
+}
+
+// update records p, for the next time the line is redrawn.
+func (sp *doctorSpinner) update(p apitype.DoctorProgress) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	for i, r := range sp.running {
+		if r.Check != p.Check {
+			continue
+		}
+		if p.Finished {
+			sp.running = append(sp.running[:i], sp.running[i+1:]...)
+			sp.finished++
+		} else {
+			sp.running[i] = p
+		}
+		return
+	}
+	if p.Finished {
+		sp.finished++
+	} else {
+		sp.running = append(sp.running, p)
+	}
+}
+
+// draw replaces the line shown with line. sp.mu must be held.
+func (sp *doctorSpinner) draw(line string) {
+	pad := ""
+	if n := sp.shown - len(line); n > 0 {
+		pad = strings.Repeat(" ", n)
+	}
+	fmt.Fprintf(sp.w, "\r%s%s\r", line, pad)
+	sp.shown = len(line)
+}
+
+// close stops redrawing the line and clears it.
+func (sp *doctorSpinner) close() {
+	close(sp.stop)
+	<-sp.done
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.draw("")
+}
+
+// doctorProgressLine returns the line shown by doctorSpinner, such as
+// `/ 4 done; running derp (25%: probing region 3 (nyc) node 3b), mtu`,
+// cut to doctorSpinnerLen.
+func doctorProgressLine(spin byte, finished int, running []apitype.DoctorProgress) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%c %d done", spin, finished)
+	for i, p := range running {
+		if i == 0 {
+			sb.WriteString("; running ")
+		} else {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(p.Check)
+		var pct string
+		if p.Total > 0 {
+			pct = fmt.Sprintf("%d%%", p.Done*100/p.Total)
+		}
+		switch {
+		case pct != "" && p.What != "":
+			fmt.Fprintf(&sb, " (%s: %s)", pct, p.What)
+		case pct != "":
+			fmt.Fprintf(&sb, " (%s)", pct)
+		case p.What != "":
+			fmt.Fprintf(&sb, " (%s)", p.What)
+		}
+	}
+	line := sb.String()
+	if len(line) > doctorSpinnerLen {
+		line = line[:doctorSpinnerLen-3] + "..."
+	}
+	return line
+}
+
+// doctorLine returns the name of the check of r and its summary, if
+// any, noting if it was cut short.
+func doctorLine(r apitype.DoctorCheckResult) string {
+	line := r.Name
+	if r.CutShort {
+		line += " (cut short)"
+	}
+	if r.Summary == "" {
+		return line
+	}
+	return line + ": " + r.Summary
+}
+
+// doctorFailed reports whether any of res failed, or if strict, warned.
+// A check that was skipped never fails.
+func doctorFailed(res []apitype.DoctorCheckResult, strict bool) bool {
+	for _, r := range res {
+		switch report.Status(r) {
+		case "FAIL":
+			return true
+		case "warn":
+			if strict {
+				return true
+			}
+		}
+	}
+	return false
+}
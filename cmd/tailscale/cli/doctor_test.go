@@ -0,0 +1,81 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cli
+
+import (
+	"strings"
+	"testing"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/doctor/report"
+)
+
+func TestDoctorFailed(t *testing.T) {
+	var (
+		pass    = apitype.DoctorCheckResult{Name: "a", Severity: "ok"}
+		info    = apitype.DoctorCheckResult{Name: "e", Severity: "info", Summary: "knob set"}
+		warn    = apitype.DoctorCheckResult{Name: "b", Severity: "warning", Summary: "slow"}
+		fail    = apitype.DoctorCheckResult{Name: "c", Severity: "error", Summary: "broken", Error: "broken"}
+		skipped = apitype.DoctorCheckResult{Name: "d", Severity: "skipped", Skipped: "requires root"}
+	)
+	tests := []struct {
+		name   string
+		res    []apitype.DoctorCheckResult
+		strict bool
+		want   bool
+	}{
+		{"none", nil, true, false},
+		{"pass", []apitype.DoctorCheckResult{pass, skipped}, true, false},
+		{"info-strict", []apitype.DoctorCheckResult{pass, info}, true, false},
+		{"warn", []apitype.DoctorCheckResult{pass, warn}, false, false},
+		{"warn-strict", []apitype.DoctorCheckResult{pass, warn}, true, true},
+		{"fail", []apitype.DoctorCheckResult{pass, fail, skipped}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := doctorFailed(tt.res, tt.strict); got != tt.want {
+				t.Errorf("doctorFailed = %v; want %v", got, tt.want)
+			}
+		})
+	}
+	if got, want := report.Status(fail)+" "+doctorLine(fail), "FAIL c: broken"; got != want {
+		t.Errorf("line = %q; want %q", got, want)
+	}
+	cut := apitype.DoctorCheckResult{Name: "d", Severity: "error", Summary: "context canceled", CutShort: true}
+	if got, want := doctorLine(cut), "d (cut short): context canceled"; got != want {
+		t.Errorf("line = %q; want %q", got, want)
+	}
+	if got, want := report.Status(info), "info"; got != want {
+		t.Errorf("status = %q; want %q", got, want)
+	}
+}
+
+func TestDoctorProgressLine(t *testing.T) {
+	tests := []struct {
+		finished int
+		running  []apitype.DoctorProgress
+		want     string
+	}{
+		{0, nil, "| 0 done"},
+		{
+			4,
+			[]apitype.DoctorProgress{
+				{Check: "derp", Done: 3, Total: 12, What: "probing region 3 (nyc)"},
+				{Check: "mtu"},
+				{Check: "portrange", Done: 1, Total: 2},
+			},
+			"| 4 done; running derp (25%: probing region 3 (nyc)), mtu, portrange (50%)",
+		},
+	}
+	for _, tt := range tests {
+		if got := doctorProgressLine('|', tt.finished, tt.running); got != tt.want {
+			t.Errorf("doctorProgressLine = %q; want %q", got, tt.want)
+		}
+	}
+	long := doctorProgressLine('|', 0, []apitype.DoctorProgress{{Check: "derp", What: strings.Repeat("x", 100)}})
+	if len(long) != doctorSpinnerLen || !strings.HasSuffix(long, "...") {
+		t.Errorf("long line not cut: %q", long)
+	}
+}
@@ -24,32 +24,54 @@
 	"tailscale.com/net/portmapper"
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/logger"
+	"tailscale.com/types/opt"
 )
 
 var netcheckCmd = &ffcli.Command{
 	Name:       "netcheck",
-	ShortUsage: "netcheck",
+	ShortUsage: "netcheck [--format=json] [--udp-timeout=2m]",
 	ShortHelp:  "Print an analysis of local network conditions",
-	Exec:       runNetcheck,
+	LongHelp: strings.TrimSpace(`
+
+The 'tailscale netcheck' command probes the local network: UDP and
+IPv4/IPv6 connectivity, NAT behavior, port mapping services, captive
+portals, HTTP proxies and the latency to each DERP region.
+
+With --format=json or --format=json-line, the report is a JSON object
+with a "Version" field, currently 1. Within a version, fields are only
+ever added, never removed, renamed or changed in meaning, so the output
+is safe to scrape from monitoring systems. Latencies are in
+milliseconds. Fields of probes that weren't run are null, except
+UDPOpenPorts and UDPMappingTimeout, which are omitted, as are the
+latencies of DERP regions that didn't reply.
+
+`),
+	Exec: runNetcheck,
 	FlagSet: (func() *flag.FlagSet {
 		fs := newFlagSet("netcheck")
 		fs.StringVar(&netcheckArgs.format, "format", "", `output format; empty (for human-readable), "json" or "json-line"`)
 		fs.DurationVar(&netcheckArgs.every, "every", 0, "if non-zero, do an incremental report with the given frequency")
+		fs.DurationVar(&netcheckArgs.udpTimeout, "udp-timeout", 0, "if non-zero, also measure how long the NAT keeps idle UDP mappings, testing idle periods up to this long; takes up to twice as long")
 		fs.BoolVar(&netcheckArgs.verbose, "verbose", false, "verbose logs")
 		return fs
 	})(),
 }
 
 var netcheckArgs struct {
-	format  string
-	every   time.Duration
-	verbose bool
+	format     string
+	every      time.Duration
+	udpTimeout time.Duration
+	verbose    bool
 }
 
 func runNetcheck(ctx context.Context, args []string) error {
 	c := &netcheck.Client{
 		UDPBindAddr: envknob.String("TS_DEBUG_NETCHECK_UDP_BIND"),
 		PortMapper:  portmapper.NewClient(logger.WithPrefix(log.Printf, "portmap: "), nil),
+
+		CheckHTTPPath:          true,
+		CheckUDPPorts:          true,
+		CheckWellKnownUDPPorts: true,
 	}
 	if netcheckArgs.verbose {
 		c.Logf = logger.WithPrefix(log.Printf, "netcheck: ")
@@ -58,10 +80,6 @@ func runNetcheck(ctx context.Context, args []string) error {
 		c.Logf = logger.Discard
 	}
 
-	if strings.HasPrefix(netcheckArgs.format, "json") {
-		fmt.Fprintln(Stderr, "# Warning: this JSON format is not yet considered a stable interface")
-	}
-
 	dm, err := localClient.CurrentDERPMap(ctx)
 	noRegions := dm != nil && len(dm.Regions) == 0
 	if noRegions {
@@ -83,7 +101,17 @@ func runNetcheck(ctx context.Context, args []string) error {
 		if err != nil {
 			return fmt.Errorf("netcheck: %w", err)
 		}
-		if err := printReport(dm, report); err != nil {
+		var lifetime *netcheck.MappingLifetime
+		if netcheckArgs.udpTimeout > 0 && report.UDP {
+			if netcheckArgs.format == "" {
+				printf("Measuring UDP mapping timeout for up to %v...\n", 2*netcheckArgs.udpTimeout)
+			}
+			lifetime, err = c.MeasureMappingLifetime(ctx, dm, netcheckArgs.udpTimeout)
+			if err != nil {
+				return fmt.Errorf("netcheck: measuring UDP mapping timeout: %w", err)
+			}
+		}
+		if err := printReport(dm, report, lifetime); err != nil {
 			return err
 		}
 		if netcheckArgs.every == 0 {
@@ -93,16 +121,16 @@ func runNetcheck(ctx context.Context, args []string) error {
 	}
 }
 
-func printReport(dm *tailcfg.DERPMap, report *netcheck.Report) error {
+func printReport(dm *tailcfg.DERPMap, report *netcheck.Report, lifetime *netcheck.MappingLifetime) error {
 	var j []byte
 	var err error
 	switch netcheckArgs.format {
 	case "":
 		break
 	case "json":
-		j, err = json.MarshalIndent(report, "", "\t")
+		j, err = json.MarshalIndent(netcheckJSONOf(dm, report, lifetime, time.Now()), "", "\t")
 	case "json-line":
-		j, err = json.Marshal(report)
+		j, err = json.Marshal(netcheckJSONOf(dm, report, lifetime, time.Now()))
 	default:
 		return fmt.Errorf("unknown output format %q", netcheckArgs.format)
 	}
@@ -117,13 +145,20 @@ func printReport(dm *tailcfg.DERPMap, report *netcheck.Report) error {
 
 	printf("\nReport:\n")
 	printf("\t* UDP: %v\n", report.UDP)
+	if report.QUIC {
+		printf("\t* QUIC (UDP 443): %v\n", report.QUIC)
+	}
 	if report.GlobalV4 != "" {
 		printf("\t* IPv4: yes, %v\n", report.GlobalV4)
 	} else {
 		printf("\t* IPv4: (no addr found)\n")
 	}
 	if report.GlobalV6 != "" {
-		printf("\t* IPv6: yes, %v\n", report.GlobalV6)
+		if report.GlobalV6Temporary {
+			printf("\t* IPv6: yes, %v (temporary address)\n", report.GlobalV6)
+		} else {
+			printf("\t* IPv6: yes, %v\n", report.GlobalV6)
+		}
 	} else if report.IPv6 {
 		printf("\t* IPv6: (no addr found)\n")
 	} else if report.OSHasIPv6 {
@@ -134,6 +169,18 @@ func printReport(dm *tailcfg.DERPMap, report *netcheck.Report) error {
 	printf("\t* MappingVariesByDestIP: %v\n", report.MappingVariesByDestIP)
 	printf("\t* HairPinning: %v\n", report.HairPinning)
 	printf("\t* PortMapping: %v\n", portMapping(report))
+	if v, ok := report.CaptivePortal.Get(); ok {
+		printf("\t* CaptivePortal: %v\n", v)
+	}
+	if report.HTTPProxy != "" {
+		printf("\t* HTTPProxy: %v\n", report.HTTPProxy)
+	}
+	if len(report.UDPOpenPorts) > 0 {
+		printf("\t* UDP works from local ports: %v\n", report.UDPOpenPorts)
+	}
+	if lifetime != nil {
+		printf("\t* UDPMappingTimeout: %v\n", mappingLifetimeString(lifetime))
+	}
 
 	// When DERP latency checking failed,
 	// magicsock will try to pick the DERP server that
@@ -143,22 +190,7 @@ func printReport(dm *tailcfg.DERPMap, report *netcheck.Report) error {
 	} else {
 		printf("\t* Nearest DERP: %v\n", dm.Regions[report.PreferredDERP].RegionName)
 		printf("\t* DERP latency:\n")
-		var rids []int
-		for rid := range dm.Regions {
-			rids = append(rids, rid)
-		}
-		sort.Slice(rids, func(i, j int) bool {
-			l1, ok1 := report.RegionLatency[rids[i]]
-			l2, ok2 := report.RegionLatency[rids[j]]
-			if ok1 != ok2 {
-				return ok1 // defined things sort first
-			}
-			if !ok1 {
-				return rids[i] < rids[j]
-			}
-			return l1 < l2
-		})
-		for _, rid := range rids {
+		for _, rid := range regionIDsByLatency(dm, report) {
 			d, ok := report.RegionLatency[rid]
 			var latency string
 			if ok {
@@ -175,6 +207,159 @@ func printReport(dm *tailcfg.DERPMap, report *netcheck.Report) error {
 	return nil
 }
 
+// regionIDsByLatency returns the IDs of dm's regions, those with the
+// lowest latency in report first, then those without one by ID.
+func regionIDsByLatency(dm *tailcfg.DERPMap, report *netcheck.Report) []int {
+	var rids []int
+	for rid := range dm.Regions {
+		rids = append(rids, rid)
+	}
+	sort.Slice(rids, func(i, j int) bool {
+		l1, ok1 := report.RegionLatency[rids[i]]
+		l2, ok2 := report.RegionLatency[rids[j]]
+		if ok1 != ok2 {
+			return ok1 // defined things sort first
+		}
+		if !ok1 {
+			return rids[i] < rids[j]
+		}
+		return l1 < l2
+	})
+	return rids
+}
+
+func mappingLifetimeString(l *netcheck.MappingLifetime) string {
+	switch {
+	case l.Expired == 0:
+		return fmt.Sprintf("at least %v", l.Survived)
+	case l.Survived == 0:
+		return fmt.Sprintf("under %v", l.Expired)
+	}
+	return fmt.Sprintf("between %v and %v", l.Survived, l.Expired)
+}
+
+// netcheckJSONVersion is the version of the JSON output of
+// "tailscale netcheck". Fields may be added to netcheckJSON without
+// changing it, but not removed, renamed or changed in meaning.
+const netcheckJSONVersion = 1
+
+// netcheckJSON is the JSON output of "tailscale netcheck". Unlike
+// netcheck.Report, which it's derived from, its fields are a stable
+// interface; see netcheckJSONVersion.
+type netcheckJSON struct {
+	Version int
+	Time    time.Time
+
+	UDP         bool // a UDP STUN round trip completed
+	IPv4        bool // an IPv4 STUN round trip completed
+	IPv6        bool // an IPv6 STUN round trip completed
+	IPv4CanSend bool // an IPv4 packet was able to be sent
+	IPv6CanSend bool // an IPv6 packet was able to be sent
+	OSHasIPv6   bool // could bind a socket to ::1
+	ICMPv4      bool // an ICMPv4 round trip completed
+	QUIC        bool // a QUIC-framed STUN round trip to UDP port 443 completed
+
+	GlobalV4          string `json:",omitempty"` // ip:port of global IPv4
+	GlobalV6          string `json:",omitempty"` // [ip]:port of global IPv6
+	GlobalV6Temporary bool   // GlobalV6 is a temporary (privacy) address
+
+	// The following are null if not checked.
+	MappingVariesByDestIP opt.Bool
+	HairPinning           opt.Bool
+	UPnP                  opt.Bool
+	PMP                   opt.Bool
+	PCP                   opt.Bool
+	CaptivePortal         opt.Bool
+
+	// HTTPProxy is the HTTP proxy used to reach DERP servers, if any.
+	HTTPProxy string `json:",omitempty"`
+
+	// UDPOpenPorts are the alternative local ports UDP works from
+	// when it doesn't from the usual one.
+	UDPOpenPorts []uint16 `json:",omitempty"`
+
+	// UDPMappingTimeout is how long the NAT keeps idle UDP mappings.
+	// It's only present with --udp-timeout.
+	UDPMappingTimeout *netcheckMappingTimeoutJSON `json:",omitempty"`
+
+	// PreferredDERP is the ID of the nearest DERP region, or 0 if
+	// unknown.
+	PreferredDERP int
+
+	// Regions are the DERP regions, those with the lowest latency
+	// first, then those that didn't reply by ID.
+	Regions []netcheckRegionJSON
+}
+
+type netcheckRegionJSON struct {
+	ID   int
+	Code string
+	Name string
+
+	// LatencyMs is the lowest latency to the region over any
+	// protocol. It's omitted if the region didn't reply.
+	LatencyMs     float64 `json:",omitempty"`
+	IPv4LatencyMs float64 `json:",omitempty"`
+	IPv6LatencyMs float64 `json:",omitempty"`
+}
+
+type netcheckMappingTimeoutJSON struct {
+	// SurvivedSeconds is the longest tested idle period after which
+	// the mapping still existed, or 0 if it never did.
+	SurvivedSeconds float64
+
+	// ExpiredSeconds is the shortest tested idle period after which
+	// the mapping was gone. It's omitted if it never was.
+	ExpiredSeconds float64 `json:",omitempty"`
+}
+
+func netcheckJSONOf(dm *tailcfg.DERPMap, r *netcheck.Report, lifetime *netcheck.MappingLifetime, now time.Time) *netcheckJSON {
+	j := &netcheckJSON{
+		Version:               netcheckJSONVersion,
+		Time:                  now.UTC(),
+		UDP:                   r.UDP,
+		IPv4:                  r.IPv4,
+		IPv6:                  r.IPv6,
+		IPv4CanSend:           r.IPv4CanSend,
+		IPv6CanSend:           r.IPv6CanSend,
+		OSHasIPv6:             r.OSHasIPv6,
+		ICMPv4:                r.ICMPv4,
+		QUIC:                  r.QUIC,
+		GlobalV4:              r.GlobalV4,
+		GlobalV6:              r.GlobalV6,
+		GlobalV6Temporary:     r.GlobalV6Temporary,
+		MappingVariesByDestIP: r.MappingVariesByDestIP,
+		HairPinning:           r.HairPinning,
+		UPnP:                  r.UPnP,
+		PMP:                   r.PMP,
+		PCP:                   r.PCP,
+		CaptivePortal:         r.CaptivePortal,
+		HTTPProxy:             r.HTTPProxy,
+		UDPOpenPorts:          r.UDPOpenPorts,
+		PreferredDERP:         r.PreferredDERP,
+		Regions:               []netcheckRegionJSON{},
+	}
+	if lifetime != nil {
+		j.UDPMappingTimeout = &netcheckMappingTimeoutJSON{
+			SurvivedSeconds: lifetime.Survived.Seconds(),
+			ExpiredSeconds:  lifetime.Expired.Seconds(),
+		}
+	}
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	for _, rid := range regionIDsByLatency(dm, r) {
+		reg := dm.Regions[rid]
+		j.Regions = append(j.Regions, netcheckRegionJSON{
+			ID:            rid,
+			Code:          reg.RegionCode,
+			Name:          reg.RegionName,
+			LatencyMs:     ms(r.RegionLatency[rid]),
+			IPv4LatencyMs: ms(r.RegionV4Latency[rid]),
+			IPv6LatencyMs: ms(r.RegionV6Latency[rid]),
+		})
+	}
+	return j
+}
+
 func portMapping(r *netcheck.Report) string {
 	if !r.AnyPortMappingChecked() {
 		return "not checked"
@@ -16,6 +16,7 @@
 	"net/netip"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"github.com/toqueteos/webbrowser"
@@ -49,7 +50,7 @@
 		fs := newFlagSet("status")
 		fs.BoolVar(&statusArgs.json, "json", false, "output in JSON format (WARNING: format subject to change)")
 		fs.BoolVar(&statusArgs.web, "web", false, "run webserver with HTML showing status")
-		fs.BoolVar(&statusArgs.active, "active", false, "filter output to only peers with active sessions (not applicable to web mode)")
+		fs.BoolVar(&statusArgs.active, "active", false, "filter output to only peers with active sessions, showing how recently each exchanged disco pings, pongs and handshakes (not applicable to web mode)")
 		fs.BoolVar(&statusArgs.self, "self", true, "show status of local machine")
 		fs.BoolVar(&statusArgs.peers, "peers", true, "show status of peers")
 		fs.StringVar(&statusArgs.listen, "listen", "127.0.0.1:8384", "listen address for web mode; use port 0 for automatic")
@@ -63,7 +64,7 @@
 	web     bool   // run webserver
 	listen  string // in web mode, webserver address to listen on, empty means auto
 	browser bool   // in web mode, whether to open browser
-	active  bool   // in CLI mode, filter output to only peers with active sessions
+	active  bool   // in CLI mode, filter output to only peers with active sessions, with their activity summaries
 	self    bool   // in CLI mode, show status of local machine
 	peers   bool   // in CLI mode, show status of peer machines
 }
@@ -130,7 +131,11 @@ func runStatus(ctx context.Context, args []string) error {
 
 	// print health check information prior to checking LocalBackend state as
 	// it may provide an explanation to the user if we choose to exit early
-	if len(st.Health) > 0 {
+	if st.Offline != "" {
+		printf("# Offline: %s.\n", st.Offline)
+		printf("# Changes will reach the coordination server once connectivity returns.\n")
+		outln()
+	} else if len(st.Health) > 0 {
 		printf("# Health check:\n")
 		for _, m := range st.Health {
 			printf("#     - %s\n", m)
@@ -190,11 +195,61 @@ func runStatus(ctx context.Context, args []string) error {
 		if anyTraffic {
 			f(", tx %d rx %d", ps.TxBytes, ps.RxBytes)
 		}
+		if ps.PathPin != nil {
+			f("; path pinned: %v", ps.PathPin)
+		}
+		if len(ps.ImportedEndpoints) > 0 {
+			f("; imported endpoints: %v", ps.ImportedEndpoints)
+		}
 		f("\n")
 	}
 
 	if statusArgs.self && st.Self != nil {
 		printPS(st.Self)
+		if st.RouteMetric != nil && *st.RouteMetric != 0 {
+			f("# Route metric: %d\n", *st.RouteMetric)
+		}
+		if st.ForceDERP {
+			f("# Forced DERP: all traffic is relayed over TCP port 443\n")
+		}
+		for _, r := range st.DERPRegions {
+			if r.RelayedPeers > 0 {
+				f("# DERP %s: relaying %d active peer(s) over TCP port 443\n", r.RegionCode, r.RelayedPeers)
+			}
+		}
+		for _, u := range st.Uplinks {
+			var state string
+			switch {
+			case u.Bound:
+				state = ", bound"
+			case !u.Up:
+				state = ", down"
+			}
+			f("# Uplink %s: %s%s\n", u.Interface, u.Mode, state)
+		}
+		if ps := st.PowerSaver; ps != nil {
+			if ps.Active {
+				f("# Power saver: %s, on (%s); saved %d heartbeats (~%d KB) and %d STUN runs\n", ps.Mode, ps.Reason, ps.HeartbeatsSaved, ps.BytesSaved/1000, ps.ReSTUNsSaved)
+			} else {
+				f("# Power saver: %s, off (%s)\n", ps.Mode, ps.Reason)
+			}
+		}
+		for _, r := range st.DampenedRoutes {
+			f("# Route %v: flapping (%d reinstalls), held down until %v\n", r.Route, r.Reinstalls, r.Until.Local().Format(time.Kitchen))
+		}
+		for _, r := range st.ExitNodeExclusions {
+			if r.Dropped != "" {
+				f("# Route %v: not excluded from exit node: %s\n", r.Route, r.Dropped)
+			} else {
+				f("# Route %v: excluded from exit node\n", r.Route)
+			}
+		}
+		if st.UDPPortRange != "" {
+			f("# UDP port: %d (range %s)\n", st.UDPPort, st.UDPPortRange)
+		}
+		if st.UDPPortSuggestion != "" {
+			f("# %s\n", st.UDPPortSuggestion)
+		}
 	}
 	if statusArgs.peers {
 		var peers []*ipnstate.PeerStatus
@@ -211,12 +266,58 @@ func runStatus(ctx context.Context, args []string) error {
 				continue
 			}
 			printPS(ps)
+			if statusArgs.active {
+				f("    %s\n", activitySummary(ps, time.Now()))
+			}
 		}
 	}
 	Stdout.Write(buf.Bytes())
 	return nil
 }
 
+// activitySummary describes, relative to now, when we last sent ps a
+// disco ping, got a disco pong and completed a WireGuard handshake with
+// it, and how long its current path has been in use.
+func activitySummary(ps *ipnstate.PeerStatus, now time.Time) string {
+	path := "relayed"
+	if ps.CurAddr != "" {
+		path = "direct"
+		if !ps.CurAddrSince.IsZero() {
+			path += " for " + shortDuration(now.Sub(ps.CurAddrSince))
+		}
+	}
+	return fmt.Sprintf("disco ping %s, pong %s; path %s; handshake %s",
+		ago(ps.LastDiscoPing, now),
+		ago(ps.LastDiscoPong, now),
+		path,
+		ago(ps.LastHandshake, now))
+}
+
+// ago returns how long before now t was, or "never" if t is unset.
+func ago(t, now time.Time) string {
+	// WireGuard reports never having completed a handshake as the
+	// Unix epoch.
+	if t.IsZero() || t.Unix() == 0 {
+		return "never"
+	}
+	return shortDuration(now.Sub(t)) + " ago"
+}
+
+// shortDuration formats d in its largest whole unit, from seconds up to
+// days.
+func shortDuration(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", d/time.Second)
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	default:
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	}
+}
+
 // isRunningOrStarting reports whether st is in state Running or Starting.
 // It also returns a description of the status suitable to display to a user.
 func isRunningOrStarting(st *ipnstate.Status) (description string, ok bool) {
@@ -0,0 +1,119 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/ipn/ipnstate"
+)
+
+var tracerouteCmd = &ffcli.Command{
+	Name:       "traceroute",
+	ShortUsage: "traceroute [--max-hops=N] <hostname-or-IP>",
+	ShortHelp:  "Trace the route to a host through the Tailscale tunnel",
+	LongHelp: strings.TrimSpace(`
+
+The 'tailscale traceroute' command sends ICMP probes with increasing
+TTLs into the Tailscale tunnel, as if they came from this host's
+operating system, and reports the routers that reply, marking which
+peer is the subnet router or exit node forwarding to the destination.
+
+Routers that forward in userspace (without a TUN device) don't report
+themselves, so their hop shows as a timeout.
+
+The provided hostname must resolve to or be a Tailscale IP
+(e.g. 100.x.y.z) or an IP routed by a subnet router or exit node.
+
+`),
+	Exec: runTraceroute,
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("traceroute")
+		fs.IntVar(&tracerouteArgs.maxHops, "max-hops", 16, "maximum number of hops to probe")
+		return fs
+	})(),
+}
+
+var tracerouteArgs struct {
+	maxHops int
+}
+
+func runTraceroute(ctx context.Context, args []string) error {
+	st, err := localClient.Status(ctx)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	description, ok := isRunningOrStarting(st)
+	if !ok {
+		printf("%s\n", description)
+		os.Exit(1)
+	}
+	if len(args) != 1 || args[0] == "" {
+		return errors.New("usage: traceroute <hostname-or-IP>")
+	}
+	ip, self, err := tailscaleIPFromArg(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if self {
+		printf("%v is local Tailscale IP\n", ip)
+		return nil
+	}
+	dst, err := netip.ParseAddr(ip)
+	if err != nil {
+		return err
+	}
+
+	res, err := localClient.Traceroute(ctx, dst, tracerouteArgs.maxHops)
+	if err != nil {
+		return err
+	}
+	via := res.NodeName
+	if res.Route != "" {
+		via = fmt.Sprintf("%s, route %s", res.NodeName, res.Route)
+	}
+	printf("traceroute to %s via %s, %d hops max\n", res.IP, via, tracerouteArgs.maxHops)
+	for _, h := range res.Hops {
+		outln(formatTracerouteHop(h))
+	}
+	if !res.Reached {
+		return errors.New("destination not reached")
+	}
+	return nil
+}
+
+func formatTracerouteHop(h *ipnstate.TracerouteHop) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%2d  ", h.TTL)
+	if h.IP == "" {
+		sb.WriteString("*")
+		if h.Err != "" {
+			fmt.Fprintf(&sb, " (%s)", h.Err)
+		}
+	} else {
+		if h.NodeName != "" {
+			fmt.Fprintf(&sb, "%s (%s)", h.NodeName, h.IP)
+		} else {
+			sb.WriteString(h.IP)
+		}
+		latency := time.Duration(h.LatencySeconds * float64(time.Second)).Round(100 * time.Microsecond)
+		fmt.Fprintf(&sb, "  %v", latency)
+		if h.Unreachable {
+			sb.WriteString(" !U")
+		}
+	}
+	if h.Note != "" {
+		fmt.Fprintf(&sb, "  [%s]", h.Note)
+	}
+	return sb.String()
+}
@@ -0,0 +1,256 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/tailcfg"
+)
+
+var troubleshootCmd = &ffcli.Command{
+	Name:       "troubleshoot",
+	ShortUsage: "troubleshoot <hostname-or-IP>",
+	ShortHelp:  "Diagnose why a peer can't be reached",
+	LongHelp: strings.TrimSpace(`
+
+The 'tailscale troubleshoot' command runs a series of checks against a
+target peer, stopping at the first one that fails, and then explains
+where the path to the peer appears to break. It exits with a non-zero
+status if it finds the path broken.
+
+The checks, in order, are:
+
+  - that tailscaled is running and logged in
+  - that the target resolves to an IP, and (for names) whether the
+    system resolver agrees with Tailscale
+  - that a peer in the network map owns the IP, or routes to it as a
+    subnet router or exit node, and whether that peer is online
+  - that both sides have a home DERP region to relay through
+  - a disco ping, which shows whether the peer is reachable over DERP
+    or a direct UDP path
+  - a TSMP ping, which shows whether WireGuard packets get through
+  - an ICMP ping, which shows whether the peer's packet filter lets
+    this node's traffic in
+
+`),
+	Exec: runTroubleshoot,
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("troubleshoot")
+		fs.DurationVar(&troubleshootArgs.timeout, "timeout", 5*time.Second, "timeout for each ping")
+		return fs
+	})(),
+}
+
+var troubleshootArgs struct {
+	timeout time.Duration
+}
+
+// troubleshootPingAttempts is the number of disco pings sent before
+// concluding that a peer is unreachable. The first ones may be lost
+// while the peer's endpoints are discovered.
+const troubleshootPingAttempts = 3
+
+func runTroubleshoot(ctx context.Context, args []string) error {
+	if len(args) != 1 || args[0] == "" {
+		return errors.New("usage: troubleshoot <hostname-or-IP>")
+	}
+	target := args[0]
+
+	step := func(name, format string, a ...any) {
+		printf("%-10s %s\n", name+":", fmt.Sprintf(format, a...))
+	}
+	conclude := func(format string, a ...any) error {
+		outln()
+		printf("Conclusion: %s\n", fmt.Sprintf(format, a...))
+		return nil
+	}
+	// fail is like conclude, for when the path to the target is
+	// broken. It returns an error so the command exits non-zero.
+	fail := func(format string, a ...any) error {
+		conclude(format, a...)
+		return fmt.Errorf("no working path to %s", target)
+	}
+
+	st, err := localClient.Status(ctx)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	if description, ok := isRunningOrStarting(st); !ok {
+		step("tailscale", "FAIL: %s", description)
+		return fail("Tailscale is not running on this machine, so no peer is reachable. Run 'tailscale up' first.")
+	}
+	step("tailscale", "ok (%s)", st.BackendState)
+	for _, h := range st.Health {
+		step("health", "warning: %s", h)
+	}
+
+	ipStr, self, err := tailscaleIPFromArg(ctx, target)
+	if err != nil {
+		step("resolve", "FAIL: %v", err)
+		return fail("%q is not a known peer and does not resolve in DNS. Check the name, or whether the node has been removed or renamed.", target)
+	}
+	if self {
+		step("resolve", "ok (%s is this machine)", ipStr)
+		return conclude("%q is this machine; there is no path to troubleshoot.", target)
+	}
+	ip, err := netip.ParseAddr(ipStr)
+	if err != nil {
+		step("resolve", "FAIL: %v", err)
+		return fail("%q resolved to %q, which is not an IP address.", target, ipStr)
+	}
+	step("resolve", "ok (%v)", ip)
+
+	if net.ParseIP(target) == nil {
+		addrs, err := net.DefaultResolver.LookupHost(ctx, target)
+		switch {
+		case err != nil:
+			step("dns", "warning: the system resolver can't resolve %q (%v); programs other than tailscale will fail to find it by name", target, err)
+		case !strSliceContains(addrs, ipStr):
+			step("dns", "warning: the system resolver maps %q to %v, not %v; MagicDNS may not be in use", target, addrs, ip)
+		default:
+			step("dns", "ok")
+		}
+	}
+
+	ps, how := troubleshootPeerForIP(st, ip)
+	if ps == nil {
+		step("route", "FAIL: no peer owns or routes %v", ip)
+		if tsaddr.IsTailscaleIP(ip) {
+			return fail("No peer with IP %v is in this node's network map. The node may have been deleted, not shared with you, or hidden by the ACL policy.", ip)
+		}
+		return fail("No peer advertises an approved route to %v and no exit node is in use. Check that the subnet route is advertised and approved, and that this node accepts routes (--accept-routes).", ip)
+	}
+	name := dnsOrQuoteHostname(st, ps)
+	step("route", "ok (%s %s)", how, name)
+	if !ps.Online {
+		seen := "never"
+		if !ps.LastSeen.IsZero() {
+			seen = ps.LastSeen.Format(time.RFC3339)
+		}
+		step("peer", "warning: %s is offline according to the control server (last seen %s)", name, seen)
+	} else {
+		step("peer", "ok (%s is online)", name)
+	}
+
+	switch {
+	case st.Self.Relay == "":
+		step("derp", "warning: this node has no home DERP region; relayed connections are impossible")
+	case ps.Relay == "":
+		step("derp", "warning: %s has no home DERP region; relayed connections are impossible", name)
+	default:
+		step("derp", "ok (this node uses DERP %q, %s uses %q)", st.Self.Relay, name, ps.Relay)
+	}
+
+	var disco *ipnstate.PingResult
+	for i := 0; i < troubleshootPingAttempts && disco == nil; i++ {
+		pr, err := troubleshootPing(ctx, ip, tailcfg.PingDisco)
+		if err != nil {
+			step("disco", "attempt %d: %v", i+1, err)
+			continue
+		}
+		disco = pr
+	}
+	if disco == nil {
+		if !ps.Online {
+			return fail("%s is offline and did not answer disco pings. Start Tailscale on it, or check that it has network access.", name)
+		}
+		return fail("%s did not answer disco pings over DERP or UDP even though the control server thinks it is online. tailscaled on the peer may be stuck, or both DERP and UDP are blocked on one side.", name)
+	}
+	direct := disco.Endpoint != ""
+	if direct {
+		step("disco", "ok (direct via %s in %v)", disco.Endpoint, pingLatency(disco))
+	} else {
+		step("disco", "ok (relayed via DERP(%s) in %v)", disco.DERPRegionCode, pingLatency(disco))
+	}
+
+	if pr, err := troubleshootPing(ctx, ip, tailcfg.PingTSMP); err != nil {
+		step("wireguard", "FAIL: TSMP ping: %v", err)
+		return fail("%s answers disco pings but WireGuard packets do not get through. The WireGuard session may be stale or the keys may disagree; try running 'tailscale down' and 'tailscale up' on both sides.", name)
+	} else {
+		step("wireguard", "ok (TSMP pong in %v)", pingLatency(pr))
+	}
+
+	if pr, err := troubleshootPing(ctx, ip, tailcfg.PingICMP); err != nil {
+		step("filter", "FAIL: ICMP ping: %v", err)
+		return fail("WireGuard works, but %s did not answer an ICMP ping. The tailnet's ACL policy may not allow this node to reach %v, or the host firewall on %s drops the traffic.", name, ip, name)
+	} else {
+		step("filter", "ok (ICMP pong in %v)", pingLatency(pr))
+	}
+
+	if !direct {
+		return conclude("%s is reachable, but only through a DERP relay, which is slower. A direct path may still be forming; if it never does, a firewall or NAT on one side is blocking UDP. 'tailscale netcheck' on both sides can show which.", name)
+	}
+	return conclude("The Tailscale path to %s works. If an application still can't connect, check that the service is listening and that the host firewall on %s allows its port.", name, name)
+}
+
+// troubleshootPing sends a single ping of the given type to ip.
+// A ping result carrying an error is returned as an error.
+func troubleshootPing(ctx context.Context, ip netip.Addr, typ tailcfg.PingType) (*ipnstate.PingResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, troubleshootArgs.timeout)
+	defer cancel()
+	pr, err := localClient.Ping(ctx, ip, typ)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, errors.New("timed out")
+		}
+		return nil, err
+	}
+	if pr.Err != "" {
+		return nil, errors.New(pr.Err)
+	}
+	return pr, nil
+}
+
+func pingLatency(pr *ipnstate.PingResult) time.Duration {
+	return time.Duration(pr.LatencySeconds * float64(time.Second)).Round(time.Millisecond)
+}
+
+// troubleshootPeerForIP returns the peer that handles traffic to ip,
+// along with a description of how: whether it owns ip, routes to it
+// as a subnet router, or is the exit node in use. It returns nil if
+// no peer handles ip.
+func troubleshootPeerForIP(st *ipnstate.Status, ip netip.Addr) (ps *ipnstate.PeerStatus, how string) {
+	for _, p := range st.Peer {
+		for _, a := range p.TailscaleIPs {
+			if a == ip {
+				return p, "owned by"
+			}
+		}
+	}
+	var best netip.Prefix
+	for _, p := range st.Peer {
+		if p.PrimaryRoutes == nil {
+			continue
+		}
+		for i := 0; i < p.PrimaryRoutes.Len(); i++ {
+			r := p.PrimaryRoutes.At(i)
+			if r.Contains(ip) && r.Bits() > best.Bits() {
+				ps, best = p, r
+			}
+		}
+	}
+	if ps != nil {
+		return ps, fmt.Sprintf("%v routed by", best)
+	}
+	if st.ExitNodeStatus != nil && !tsaddr.IsTailscaleIP(ip) {
+		for _, p := range st.Peer {
+			if p.ID == st.ExitNodeStatus.ID {
+				return p, "via exit node"
+			}
+		}
+	}
+	return nil, ""
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cli
+
+import (
+	"net/netip"
+	"testing"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/types/key"
+	"tailscale.com/types/views"
+)
+
+func TestTroubleshootPeerForIP(t *testing.T) {
+	routes := func(s ...string) *views.IPPrefixSlice {
+		var pfxs []netip.Prefix
+		for _, p := range s {
+			pfxs = append(pfxs, netip.MustParsePrefix(p))
+		}
+		v := views.IPPrefixSliceOf(pfxs)
+		return &v
+	}
+	owner := &ipnstate.PeerStatus{
+		ID:           "owner",
+		TailscaleIPs: []netip.Addr{netip.MustParseAddr("100.64.0.1")},
+	}
+	wide := &ipnstate.PeerStatus{
+		ID:            "wide",
+		TailscaleIPs:  []netip.Addr{netip.MustParseAddr("100.64.0.2")},
+		PrimaryRoutes: routes("10.0.0.0/8"),
+	}
+	narrow := &ipnstate.PeerStatus{
+		ID:            "narrow",
+		TailscaleIPs:  []netip.Addr{netip.MustParseAddr("100.64.0.3")},
+		PrimaryRoutes: routes("10.1.0.0/16"),
+	}
+	exit := &ipnstate.PeerStatus{
+		ID:           "exit",
+		TailscaleIPs: []netip.Addr{netip.MustParseAddr("100.64.0.4")},
+	}
+	st := &ipnstate.Status{
+		Peer: map[key.NodePublic]*ipnstate.PeerStatus{
+			key.NewNode().Public(): owner,
+			key.NewNode().Public(): wide,
+			key.NewNode().Public(): narrow,
+			key.NewNode().Public(): exit,
+		},
+	}
+
+	tests := []struct {
+		ip       string
+		exitNode bool
+		want     string // peer ID, or empty for none
+	}{
+		{"100.64.0.1", false, "owner"},
+		{"100.64.0.9", true, ""},
+		{"10.2.3.4", false, "wide"},
+		{"10.1.2.3", false, "narrow"},
+		{"8.8.8.8", false, ""},
+		{"8.8.8.8", true, "exit"},
+	}
+	for _, tt := range tests {
+		st.ExitNodeStatus = nil
+		if tt.exitNode {
+			st.ExitNodeStatus = &ipnstate.ExitNodeStatus{ID: "exit", Online: true}
+		}
+		ps, how := troubleshootPeerForIP(st, netip.MustParseAddr(tt.ip))
+		var got string
+		if ps != nil {
+			got = string(ps.ID)
+		}
+		if got != tt.want {
+			t.Errorf("troubleshootPeerForIP(%s, exit=%v) = %q (%s); want %q", tt.ip, tt.exitNode, got, how, tt.want)
+		}
+	}
+}
@@ -18,6 +18,7 @@
 	"reflect"
 	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -98,20 +99,44 @@ func newUpFlagSet(goos string, upArgs *upArgsT) *flag.FlagSet {
 	upf.BoolVar(&upArgs.singleRoutes, "host-routes", true, "install host routes to other Tailscale nodes")
 	upf.StringVar(&upArgs.exitNodeIP, "exit-node", "", "Tailscale exit node (IP or base name) for internet traffic, or empty string to not use an exit node")
 	upf.BoolVar(&upArgs.exitNodeAllowLANAccess, "exit-node-allow-lan-access", false, "Allow direct access to the local network when routing traffic via an exit node")
+	upf.StringVar(&upArgs.exitNodeExclude, "exit-node-exclude", "", "comma-separated destination prefixes to route directly instead of via the exit node (e.g. \"192.0.2.0/24,2001:db8::/32\"); those overlapping subnet routes are ignored; Linux only")
 	upf.BoolVar(&upArgs.shieldsUp, "shields-up", false, "don't allow incoming connections")
+	upf.BoolVar(&upArgs.forceDERP, "force-derp", false, "relay all traffic to peers over DERP (TCP port 443) instead of direct UDP, to reproduce restrictive networks")
+	upf.StringVar(&upArgs.uplinkPolicy, "uplink-policy", "", "comma-separated <interface>:<mode> rules for reaching peers on multi-homed machines, where mode is \"prefer\", \"disco-only\" (no bulk traffic) or \"exclude\" (e.g. \"eth0:prefer,wwan0:disco-only\")")
+	upf.StringVar(&upArgs.syntheticMonitorPeer, "synthetic-monitor-peer", "", "peer (name or Tailscale IP) to resolve and disco-ping every minute, along with connecting to the control server, to record connectivity for health checks and bug reports; empty disables")
+	upf.StringVar(&upArgs.latencySLOs, "latency-slos", "", "comma-separated latency objectives to check every minute, raising a health warning with the evidence when the last 5 minutes miss one: \"rtt:<peer>[@p<quantile>]<<max>\" for disco ping round-trip times to a peer or \"dns[@p<quantile>]<<max>\" for upstream DNS lookups, at p90 by default (e.g. \"rtt:nas<50ms,dns@p99<100ms\")")
+	upf.StringVar(&upArgs.powerSaver, "power-saver", "", "when to stretch disco heartbeats and NAT keepalives to save battery and metered data, at the cost of slower failover: \"auto\" (on battery or a metered link, where the OS tells), \"on\" (always) or \"off\"; empty means off")
+	upf.BoolVar(&upArgs.allowRemoteDoctor, "allow-remote-doctor", false, "allow peers owned by the same user or granted the doctor-peer capability to run this node's doctor checks and see the results")
+	upf.BoolVar(&upArgs.allowSpeedTest, "allow-speedtest", false, "allow peers owned by the same user or granted the speedtest-peer capability to run throughput tests against this node")
+	upf.StringVar(&upArgs.doctorInterval, "doctor-interval", "", "how often to run the doctor checks unattended and keep their results for bug reports (e.g. \"24h\", at least \"1h\", or \"10m\" with --doctor-lightweight); empty disables")
+	upf.BoolVar(&upArgs.doctorLogResults, "doctor-log-results", false, "log the full results of scheduled doctor runs, not just a summary")
+	upf.BoolVar(&upArgs.doctorLightweight, "doctor-lightweight", false, "only run the doctor checks that don't probe servers or the network on schedule, permitting shorter intervals and keeping more runs")
 	upf.BoolVar(&upArgs.runSSH, "ssh", false, "run an SSH server, permitting access per tailnet admin's declared policy")
 	upf.StringVar(&upArgs.advertiseTags, "advertise-tags", "", "comma-separated ACL tags to request; each must start with \"tag:\" (e.g. \"tag:eng,tag:montreal,tag:ssh\")")
 	upf.StringVar(&upArgs.authKeyOrFile, "auth-key", "", `node authorization key; if it begins with "file:", then it's a path to a file containing the authkey`)
 	upf.StringVar(&upArgs.hostname, "hostname", "", "hostname to use instead of the one provided by the OS")
 	upf.StringVar(&upArgs.advertiseRoutes, "advertise-routes", "", "routes to advertise to other nodes (comma-separated, e.g. \"10.0.0.0/8,192.168.0.0/24\") or empty string to not advertise routes")
 	upf.BoolVar(&upArgs.advertiseDefaultRoute, "advertise-exit-node", false, "offer to be an exit node for internet traffic for the tailnet")
+	upf.BoolVar(&upArgs.readvertiseRoutes, "readvertise-routes", false, "when a local interface whose subnet is advertised with --advertise-routes moves to another subnet, such as after a DHCP change, advertise the new subnet instead")
 	if safesocket.GOOSUsesPeerCreds(goos) {
 		upf.StringVar(&upArgs.opUser, "operator", "", "Unix username to allow to operate on tailscaled without sudo")
 	}
+	upf.IntVar(&upArgs.derpSendQueue, "derp-send-queue", 0, "packets to queue for each DERP server before dropping, to bound memory use; 0 means the default (32)")
+	upf.IntVar(&upArgs.derpHomeRegion, "derp-home-region", 0, "ID of the DERP region to use as home regardless of latency (see 'tailscale debug derp-selection'); 0 means the lowest latency one")
+	upf.StringVar(&upArgs.derpAvoidRegions, "derp-avoid-regions", "", "comma-separated IDs of DERP regions not to use as home unless no other one works (e.g. \"1,4\"); peers using them are still reachable")
+	upf.StringVar(&upArgs.udpPortRange, "udp-port-range", "", "local UDP ports to use for direct connections and STUN, as a single port or an inclusive range (e.g. \"40000-40100\"); empty string means any port")
+	if goos == "linux" {
+		upf.IntVar(&upArgs.recvBatchSize, "recv-batch-size", 0, "UDP packets to read per system call, each with a 64 KiB buffer; lower it to save memory, or 1 to disable batching; 0 means the default (8)")
+	}
+	if goos == "linux" || goos == "windows" {
+		upf.IntVar(&upArgs.routeMetric, "route-metric", 0, "metric of the routes Tailscale installs (route priority on Linux, interface metric on Windows); lower wins; 0 means the OS default")
+	}
 	switch goos {
 	case "linux":
 		upf.BoolVar(&upArgs.snat, "snat-subnet-routes", true, "source NAT traffic to local routes advertised with --advertise-routes")
 		upf.StringVar(&upArgs.netfilterMode, "netfilter-mode", defaultNetfilterMode(), "netfilter mode (one of on, nodivert, off)")
+		upf.BoolVar(&upArgs.clampMSS, "clamp-mss", false, "clamp the TCP MSS of connections forwarded as a subnet router or exit node to the path MTU")
+		upf.BoolVar(&upArgs.autoMTU, "auto-mtu", true, "lower the MTU of the Tailscale interface when a direct path to a peer drops full-size packets")
 	case "windows":
 		upf.BoolVar(&upArgs.forceDaemon, "unattended", false, "run in \"Unattended Mode\" where Tailscale keeps running even after the current GUI user logs out (Windows-only)")
 	}
@@ -136,15 +161,35 @@ type upArgsT struct {
 	singleRoutes           bool
 	exitNodeIP             string
 	exitNodeAllowLANAccess bool
+	exitNodeExclude        string
 	shieldsUp              bool
+	forceDERP              bool
+	uplinkPolicy           string
+	syntheticMonitorPeer   string
+	latencySLOs            string
+	powerSaver             string
+	allowRemoteDoctor      bool
+	allowSpeedTest         bool
+	doctorInterval         string
+	doctorLogResults       bool
+	doctorLightweight      bool
+	recvBatchSize          int
+	derpSendQueue          int
+	derpHomeRegion         int
+	derpAvoidRegions       string
+	udpPortRange           string
 	runSSH                 bool
 	forceReauth            bool
 	forceDaemon            bool
 	advertiseRoutes        string
 	advertiseDefaultRoute  bool
+	readvertiseRoutes      bool
 	advertiseTags          string
 	snat                   bool
+	clampMSS               bool
+	autoMTU                bool
 	netfilterMode          string
+	routeMetric            int
 	authKeyOrFile          string // "secret" or "file:/path/to/secret"
 	hostname               string
 	opUser                 string
@@ -288,6 +333,23 @@ func prefsFromUpArgs(upArgs upArgsT, warnf logger.Logf, st *ipnstate.Status, goo
 		return nil, fmt.Errorf("--exit-node-allow-lan-access can only be used with --exit-node")
 	}
 
+	var exitNodeExclude []netip.Prefix
+	if upArgs.exitNodeExclude != "" {
+		if upArgs.exitNodeIP == "" {
+			return nil, fmt.Errorf("--exit-node-exclude can only be used with --exit-node")
+		}
+		for _, s := range strings.Split(upArgs.exitNodeExclude, ",") {
+			ipp, err := netip.ParsePrefix(s)
+			if err != nil {
+				return nil, fmt.Errorf("%q is not a valid IP address or CIDR prefix", s)
+			}
+			if ipp != ipp.Masked() {
+				return nil, fmt.Errorf("%s has non-address bits set; expected %s", ipp, ipp.Masked())
+			}
+			exitNodeExclude = append(exitNodeExclude, ipp)
+		}
+	}
+
 	var tags []string
 	if upArgs.advertiseTags != "" {
 		tags = strings.Split(upArgs.advertiseTags, ",")
@@ -299,6 +361,29 @@ func prefsFromUpArgs(upArgs upArgsT, warnf logger.Logf, st *ipnstate.Status, goo
 		}
 	}
 
+	var uplinkPolicy []string
+	if upArgs.uplinkPolicy != "" {
+		uplinkPolicy = strings.Split(upArgs.uplinkPolicy, ",")
+		if _, err := preftype.ParseUplinkPolicy(uplinkPolicy); err != nil {
+			return nil, err
+		}
+	}
+
+	var latencySLOs []string
+	if upArgs.latencySLOs != "" {
+		latencySLOs = strings.Split(upArgs.latencySLOs, ",")
+		for _, s := range latencySLOs {
+			if _, err := preftype.ParseLatencySLO(s); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	powerSaver, err := preftype.ParsePowerSaverMode(upArgs.powerSaver)
+	if err != nil {
+		return nil, err
+	}
+
 	if len(upArgs.hostname) > 256 {
 		return nil, fmt.Errorf("hostname too long: %d bytes (max 256)", len(upArgs.hostname))
 	}
@@ -319,18 +404,64 @@ func prefsFromUpArgs(upArgs upArgsT, warnf logger.Logf, st *ipnstate.Status, goo
 	}
 
 	prefs.ExitNodeAllowLANAccess = upArgs.exitNodeAllowLANAccess
+	prefs.ExitNodeExcludeRoutes = exitNodeExclude
 	prefs.CorpDNS = upArgs.acceptDNS
 	prefs.AllowSingleHosts = upArgs.singleRoutes
 	prefs.ShieldsUp = upArgs.shieldsUp
+	prefs.ForceDERP = upArgs.forceDERP
+	prefs.UplinkPolicy = uplinkPolicy
+	prefs.SyntheticMonitorPeer = upArgs.syntheticMonitorPeer
+	prefs.LatencySLOs = latencySLOs
+	prefs.PowerSaver = string(powerSaver)
+	prefs.AllowRemoteDoctor = upArgs.allowRemoteDoctor
+	prefs.AllowSpeedTest = upArgs.allowSpeedTest
+	prefs.DoctorInterval = upArgs.doctorInterval
+	prefs.DoctorLogResults = upArgs.doctorLogResults
+	prefs.DoctorLightweight = upArgs.doctorLightweight
 	prefs.RunSSH = upArgs.runSSH
 	prefs.AdvertiseRoutes = routes
+	prefs.ReadvertiseRoutes = upArgs.readvertiseRoutes
 	prefs.AdvertiseTags = tags
 	prefs.Hostname = upArgs.hostname
 	prefs.ForceDaemon = upArgs.forceDaemon
 	prefs.OperatorUser = upArgs.opUser
+	if upArgs.routeMetric < 0 {
+		return nil, fmt.Errorf("invalid value --route-metric=%d; must not be negative", upArgs.routeMetric)
+	}
+	prefs.RouteMetric = upArgs.routeMetric
+	if upArgs.recvBatchSize < 0 {
+		return nil, fmt.Errorf("invalid value --recv-batch-size=%d; must not be negative", upArgs.recvBatchSize)
+	}
+	prefs.RecvBatchSize = upArgs.recvBatchSize
+	if upArgs.derpSendQueue < 0 {
+		return nil, fmt.Errorf("invalid value --derp-send-queue=%d; must not be negative", upArgs.derpSendQueue)
+	}
+	prefs.DERPSendQueue = upArgs.derpSendQueue
+	if upArgs.derpHomeRegion < 0 {
+		return nil, fmt.Errorf("invalid value --derp-home-region=%d; must not be negative", upArgs.derpHomeRegion)
+	}
+	prefs.DERPHomeRegion = upArgs.derpHomeRegion
+	if upArgs.derpAvoidRegions != "" {
+		for _, s := range strings.Split(upArgs.derpAvoidRegions, ",") {
+			id, err := strconv.Atoi(s)
+			if err != nil || id <= 0 {
+				return nil, fmt.Errorf("invalid DERP region ID %q in --derp-avoid-regions", s)
+			}
+			if id == upArgs.derpHomeRegion {
+				return nil, fmt.Errorf("DERP region %d can't be both --derp-home-region and in --derp-avoid-regions", id)
+			}
+			prefs.DERPAvoidRegions = append(prefs.DERPAvoidRegions, id)
+		}
+	}
+	if _, err := preftype.ParsePortRange(upArgs.udpPortRange); err != nil {
+		return nil, fmt.Errorf("invalid value --udp-port-range=%q: %w", upArgs.udpPortRange, err)
+	}
+	prefs.UDPPortRange = upArgs.udpPortRange
 
 	if goos == "linux" {
 		prefs.NoSNAT = !upArgs.snat
+		prefs.ClampMSS = upArgs.clampMSS
+		prefs.NoAutoMTU = !upArgs.autoMTU
 
 		switch upArgs.netfilterMode {
 		case "on":
@@ -740,9 +871,29 @@ func init() {
 	addPrefFlagMapping("hostname", "Hostname")
 	addPrefFlagMapping("login-server", "ControlURL")
 	addPrefFlagMapping("netfilter-mode", "NetfilterMode")
+	addPrefFlagMapping("route-metric", "RouteMetric")
 	addPrefFlagMapping("shields-up", "ShieldsUp")
 	addPrefFlagMapping("snat-subnet-routes", "NoSNAT")
+	addPrefFlagMapping("readvertise-routes", "ReadvertiseRoutes")
+	addPrefFlagMapping("clamp-mss", "ClampMSS")
+	addPrefFlagMapping("auto-mtu", "NoAutoMTU")
 	addPrefFlagMapping("exit-node-allow-lan-access", "ExitNodeAllowLANAccess")
+	addPrefFlagMapping("exit-node-exclude", "ExitNodeExcludeRoutes")
+	addPrefFlagMapping("force-derp", "ForceDERP")
+	addPrefFlagMapping("uplink-policy", "UplinkPolicy")
+	addPrefFlagMapping("synthetic-monitor-peer", "SyntheticMonitorPeer")
+	addPrefFlagMapping("latency-slos", "LatencySLOs")
+	addPrefFlagMapping("power-saver", "PowerSaver")
+	addPrefFlagMapping("allow-remote-doctor", "AllowRemoteDoctor")
+	addPrefFlagMapping("allow-speedtest", "AllowSpeedTest")
+	addPrefFlagMapping("doctor-interval", "DoctorInterval")
+	addPrefFlagMapping("doctor-log-results", "DoctorLogResults")
+	addPrefFlagMapping("doctor-lightweight", "DoctorLightweight")
+	addPrefFlagMapping("recv-batch-size", "RecvBatchSize")
+	addPrefFlagMapping("derp-send-queue", "DERPSendQueue")
+	addPrefFlagMapping("derp-home-region", "DERPHomeRegion")
+	addPrefFlagMapping("derp-avoid-regions", "DERPAvoidRegions")
+	addPrefFlagMapping("udp-port-range", "UDPPortRange")
 	addPrefFlagMapping("unattended", "ForceDaemon")
 	addPrefFlagMapping("operator", "OperatorUser")
 	addPrefFlagMapping("ssh", "RunSSH")
@@ -907,7 +1058,11 @@ func applyImplicitPrefs(prefs, oldPrefs *ipn.Prefs, env upCheckEnv) {
 
 func flagAppliesToOS(flag, goos string) bool {
 	switch flag {
-	case "netfilter-mode", "snat-subnet-routes":
+	case "netfilter-mode", "snat-subnet-routes", "clamp-mss", "auto-mtu":
+		return goos == "linux"
+	case "route-metric":
+		return goos == "linux" || goos == "windows"
+	case "recv-batch-size":
 		return goos == "linux"
 	case "unattended":
 		return goos == "windows"
@@ -955,10 +1110,56 @@ func prefsToFlags(env upCheckEnv, prefs *ipn.Prefs) (flagVal map[string]any) {
 			set(prefs.CorpDNS)
 		case "shields-up":
 			set(prefs.ShieldsUp)
+		case "force-derp":
+			set(prefs.ForceDERP)
+		case "uplink-policy":
+			set(strings.Join(prefs.UplinkPolicy, ","))
+		case "synthetic-monitor-peer":
+			set(prefs.SyntheticMonitorPeer)
+		case "latency-slos":
+			set(strings.Join(prefs.LatencySLOs, ","))
+		case "power-saver":
+			set(prefs.PowerSaver)
+		case "allow-remote-doctor":
+			set(prefs.AllowRemoteDoctor)
+		case "allow-speedtest":
+			set(prefs.AllowSpeedTest)
+		case "doctor-interval":
+			set(prefs.DoctorInterval)
+		case "doctor-log-results":
+			set(prefs.DoctorLogResults)
+		case "doctor-lightweight":
+			set(prefs.DoctorLightweight)
+		case "recv-batch-size":
+			set(prefs.RecvBatchSize)
+		case "derp-send-queue":
+			set(prefs.DERPSendQueue)
+		case "derp-home-region":
+			set(prefs.DERPHomeRegion)
+		case "derp-avoid-regions":
+			var sb strings.Builder
+			for i, id := range prefs.DERPAvoidRegions {
+				if i > 0 {
+					sb.WriteByte(',')
+				}
+				sb.WriteString(strconv.Itoa(id))
+			}
+			set(sb.String())
+		case "udp-port-range":
+			set(prefs.UDPPortRange)
 		case "exit-node":
 			set(exitNodeIPStr())
 		case "exit-node-allow-lan-access":
 			set(prefs.ExitNodeAllowLANAccess)
+		case "exit-node-exclude":
+			var sb strings.Builder
+			for i, r := range prefs.ExitNodeExcludeRoutes {
+				if i > 0 {
+					sb.WriteByte(',')
+				}
+				sb.WriteString(r.String())
+			}
+			set(sb.String())
 		case "advertise-tags":
 			set(strings.Join(prefs.AdvertiseTags, ","))
 		case "hostname":
@@ -976,10 +1177,18 @@ func prefsToFlags(env upCheckEnv, prefs *ipn.Prefs) (flagVal map[string]any) {
 			set(sb.String())
 		case "advertise-exit-node":
 			set(hasExitNodeRoutes(prefs.AdvertiseRoutes))
+		case "readvertise-routes":
+			set(prefs.ReadvertiseRoutes)
 		case "snat-subnet-routes":
 			set(!prefs.NoSNAT)
+		case "clamp-mss":
+			set(prefs.ClampMSS)
+		case "auto-mtu":
+			set(!prefs.NoAutoMTU)
 		case "netfilter-mode":
 			set(prefs.NetfilterMode.String())
+		case "route-metric":
+			set(prefs.RouteMetric)
 		case "unattended":
 			set(prefs.ForceDaemon)
 		}
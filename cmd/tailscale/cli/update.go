@@ -0,0 +1,203 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cli
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/clientupdate"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/version"
+)
+
+var updateCmd = &ffcli.Command{
+	Name:       "update",
+	ShortUsage: "update [flags]",
+	ShortHelp:  "Update Tailscale to the latest or a given version",
+	LongHelp: strings.TrimSpace(`
+The 'tailscale update' command updates Tailscale using the package manager
+it was installed with (apt or yum on Linux, pkg on FreeBSD), or the MSI
+installer on Windows. It must be run as root or as an Administrator.
+
+After updating, it waits for tailscaled to come back up running the new
+version and in its previous state, and reinstalls the previous version
+if it doesn't.
+`),
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("update")
+		fs.StringVar(&updateArgs.track, "track", "", `release track to update from, "stable" or "unstable"; default is the track of the installed version`)
+		fs.StringVar(&updateArgs.version, "version", "", "version to install, which may be older than the installed one; default is the latest on --track")
+		fs.BoolVar(&updateArgs.yes, "yes", false, "update without asking for confirmation")
+		fs.BoolVar(&updateArgs.dryRun, "dry-run", false, "print the version that would be installed, without installing it")
+		return fs
+	})(),
+	Exec: runUpdate,
+}
+
+var updateArgs struct {
+	track   string
+	version string
+	yes     bool
+	dryRun  bool
+}
+
+// updateHealthTimeout is how long tailscaled has to come back up after
+// an update before it's rolled back.
+const updateHealthTimeout = 2 * time.Minute
+
+func runUpdate(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("too many non-flag arguments: %q", args)
+	}
+	var track clientupdate.Track
+	if updateArgs.track != "" {
+		var err error
+		track, err = clientupdate.ParseTrack(updateArgs.track)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The running tailscaled's version is the one being updated. If it's
+	// not running, fall back to this CLI's version.
+	before, err := localClient.StatusWithoutPeers(ctx)
+	if err != nil {
+		printf("tailscaled isn't reachable (%v); not checking its health after updating\n", err)
+	}
+	current := version.Short
+	if before != nil {
+		current, _, _ = strings.Cut(before.Version, "-")
+	}
+	if track == "" {
+		track = clientupdate.TrackOf(current)
+	}
+
+	target := updateArgs.version
+	if target == "" {
+		var res *clientupdate.CheckResult
+		if before != nil {
+			res, err = localClient.CheckUpdate(ctx, track)
+		} else {
+			res, err = clientupdate.Check(ctx, track)
+		}
+		if err != nil {
+			return err
+		}
+		target = res.Latest
+	}
+	if target == current {
+		printf("Already running %s.\n", current)
+		return nil
+	}
+	if updateArgs.dryRun {
+		printf("Would update from %s to %s (%s track).\n", current, target, track)
+		return nil
+	}
+	if !updateArgs.yes && !confirm(fmt.Sprintf("Update from %s to %s (%s track)?", current, target, track)) {
+		return errAborted
+	}
+
+	if err := installVersion(ctx, target, track); err != nil {
+		return err
+	}
+	if before == nil {
+		printf("Updated to %s.\n", target)
+		return nil
+	}
+	if err := waitUpdated(ctx, target, before); err != nil {
+		printf("tailscaled failed its health check after updating: %v\n", err)
+		if strings.Contains(current, "-") || strings.HasPrefix(current, "date.") {
+			// Development builds aren't in the package repositories.
+			return fmt.Errorf("can't roll back to %s automatically: %w", current, err)
+		}
+		printf("Rolling back to %s ...\n", current)
+		if rerr := installVersion(ctx, current, clientupdate.TrackOf(current)); rerr != nil {
+			return fmt.Errorf("rolling back to %s: %v (after update failed: %w)", current, rerr, err)
+		}
+		return fmt.Errorf("rolled back to %s after update to %s failed: %w", current, target, err)
+	}
+	printf("Updated to %s.\n", target)
+	return nil
+}
+
+// installVersion installs version v of Tailscale from track.
+func installVersion(ctx context.Context, v string, track clientupdate.Track) error {
+	err := clientupdate.Update(ctx, clientupdate.Args{
+		Version: v,
+		Track:   track,
+		Logf: func(format string, a ...any) {
+			printf(format+"\n", a...)
+		},
+		Stdout: Stdout,
+		Stderr: Stderr,
+		// On Windows, a copy of this CLI runs the installer. Run it
+		// with these same flags, installing v without asking again.
+		ReexecArgs: append(os.Args[1:len(os.Args):len(os.Args)], "--yes", "--version="+v, "--track="+string(track)),
+	})
+	if errors.Is(err, clientupdate.ErrUnsupported) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("installing %s: %w", v, err)
+	}
+	return nil
+}
+
+// waitUpdated waits for tailscaled to be running version v and, if it
+// was running before the update according to its status before, to be
+// running again.
+func waitUpdated(ctx context.Context, v string, before *ipnstate.Status) error {
+	ctx, cancel := context.WithTimeout(ctx, updateHealthTimeout)
+	defer cancel()
+	lastErr := errors.New("tailscaled didn't come back up")
+	for {
+		st, err := localClient.StatusWithoutPeers(ctx)
+		switch {
+		case err != nil:
+			lastErr = err
+		case versionShort(st.Version) != v:
+			lastErr = fmt.Errorf("tailscaled is running version %s, not %s", st.Version, v)
+		case before.BackendState == "Running" && st.BackendState != "Running":
+			lastErr = fmt.Errorf("tailscaled is %s, not Running", st.BackendState)
+			if len(st.Health) > 0 {
+				lastErr = fmt.Errorf("%w: %s", lastErr, strings.Join(st.Health, "; "))
+			}
+		default:
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return lastErr
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// versionShort returns the short form of the version v of a release
+// build ("1.32.2-t1234abcd-g5678" to "1.32.2").
+func versionShort(v string) string {
+	short, _, _ := strings.Cut(v, "-")
+	return short
+}
+
+// confirm asks the user the yes/no question q, returning whether they
+// answered yes.
+func confirm(q string) bool {
+	printf("%s [y/N] ", q)
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
@@ -25,10 +25,13 @@
 	"os"
 	"os/exec"
 	"runtime"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/preftype"
 	"tailscale.com/util/groupmember"
@@ -41,14 +44,19 @@
 //go:embed web.css
 var webCSS string
 
+//go:embed web-diagnostics.html
+var webDiagnosticsHTML string
+
 //go:embed auth-redirect.html
 var authenticationRedirectHTML string
 
-var tmpl *template.Template
+var tmpl, diagTmpl *template.Template
 
 func init() {
 	tmpl = template.Must(template.New("web.html").Parse(webHTML))
 	template.Must(tmpl.New("web.css").Parse(webCSS))
+	diagTmpl = template.Must(template.New("web-diagnostics.html").Parse(webDiagnosticsHTML))
+	template.Must(diagTmpl.New("web.css").Parse(webCSS))
 }
 
 type tmplData struct {
@@ -61,6 +69,26 @@ type tmplData struct {
 	AdvertiseRoutes   string
 }
 
+// diagTmplData is the data for the diagnostics page.
+type diagTmplData struct {
+	Status      *ipnstate.Status
+	Diag        *ipnstate.Diagnostics
+	DERPLatency []diagLatency
+	Peers       []diagPeer
+}
+
+type diagLatency struct {
+	Region  string // DERPLatency key, such as "1-v4"
+	Latency time.Duration
+}
+
+type diagPeer struct {
+	Name string
+	IP   string
+	OS   string
+	Path string // how traffic to the peer flows; see peerPathString
+}
+
 var webCmd = &ffcli.Command{
 	Name:       "web",
 	ShortUsage: "web [flags]",
@@ -331,6 +359,11 @@ func webHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if r.URL.Path == "/diagnostics" || r.URL.Path == "/diagnostics/" {
+		serveWebDiagnostics(w, r)
+		return
+	}
+
 	if r.Method == "POST" {
 		defer r.Body.Close()
 		var postData struct {
@@ -417,6 +450,72 @@ func webHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(buf.Bytes())
 }
 
+// serveWebDiagnostics renders the diagnostics page: health, netcheck
+// results, the path to each peer, and the output of the doctor checks
+// and the route table, so users without a CLI can gather what support
+// asks for.
+func serveWebDiagnostics(w http.ResponseWriter, r *http.Request) {
+	st, err := localClient.Status(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	diag, err := localClient.Diagnostics(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data := diagTmplData{
+		Status: st,
+		Diag:   diag,
+	}
+	if diag.NetInfo != nil {
+		for k, v := range diag.NetInfo.DERPLatency {
+			d := time.Duration(v * float64(time.Second)).Round(time.Millisecond / 10)
+			data.DERPLatency = append(data.DERPLatency, diagLatency{k, d})
+		}
+		sort.Slice(data.DERPLatency, func(i, j int) bool {
+			return data.DERPLatency[i].Latency < data.DERPLatency[j].Latency
+		})
+	}
+	var peers []*ipnstate.PeerStatus
+	for _, k := range st.Peers() {
+		peers = append(peers, st.Peer[k])
+	}
+	ipnstate.SortPeers(peers)
+	for _, ps := range peers {
+		data.Peers = append(data.Peers, diagPeer{
+			Name: dnsOrQuoteHostname(st, ps),
+			IP:   firstIPString(ps.TailscaleIPs),
+			OS:   ps.OS,
+			Path: peerPathString(ps),
+		})
+	}
+
+	buf := new(bytes.Buffer)
+	if err := diagTmpl.Execute(buf, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(buf.Bytes())
+}
+
+// peerPathString describes how traffic to ps currently flows, in the
+// terms used by "tailscale status".
+func peerPathString(ps *ipnstate.PeerStatus) string {
+	switch {
+	case !ps.Online:
+		return "offline"
+	case !ps.Active:
+		return "idle"
+	case ps.CurAddr != "":
+		return "direct " + ps.CurAddr
+	case ps.Relay != "":
+		return fmt.Sprintf("relay %q", ps.Relay)
+	}
+	return "active"
+}
+
 // TODO(crawshaw): some of this is very similar to the code in 'tailscale up', can we share anything?
 func tailscaleUp(ctx context.Context, prefs *ipn.Prefs, forceReauth bool) (authURL string, retErr error) {
 	if prefs == nil {
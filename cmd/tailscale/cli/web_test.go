@@ -4,7 +4,15 @@
 
 package cli
 
-import "testing"
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+)
 
 func TestUrlOfListenAddr(t *testing.T) {
 	tests := []struct {
@@ -42,3 +50,45 @@ func TestUrlOfListenAddr(t *testing.T) {
 		})
 	}
 }
+
+func TestWebDiagnosticsTemplate(t *testing.T) {
+	data := diagTmplData{
+		Status: &ipnstate.Status{
+			BackendState: "Running",
+			Health:       []string{"not in map poll"},
+		},
+		Diag: &ipnstate.Diagnostics{
+			NetInfo: &tailcfg.NetInfo{WorkingUDP: "true", PreferredDERP: 1},
+			Doctor:  []string{"derp: region 1 ok"},
+			Routes:  "default via 10.0.0.1 dev eth0",
+		},
+		DERPLatency: []diagLatency{{"1-v4", 12 * time.Millisecond}},
+		Peers:       []diagPeer{{Name: "peer", IP: "100.64.0.2", OS: "linux", Path: "direct 1.2.3.4:41641"}},
+	}
+	var buf bytes.Buffer
+	if err := diagTmpl.Execute(&buf, data); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"not in map poll", "direct 1.2.3.4:41641", "region 1 ok", "default via 10.0.0.1", "12ms"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("output doesn't contain %q", want)
+		}
+	}
+}
+
+func TestPeerPathString(t *testing.T) {
+	tests := []struct {
+		ps   ipnstate.PeerStatus
+		want string
+	}{
+		{ipnstate.PeerStatus{}, "offline"},
+		{ipnstate.PeerStatus{Online: true}, "idle"},
+		{ipnstate.PeerStatus{Online: true, Active: true, Relay: "nyc"}, `relay "nyc"`},
+		{ipnstate.PeerStatus{Online: true, Active: true, Relay: "nyc", CurAddr: "1.2.3.4:41641"}, "direct 1.2.3.4:41641"},
+	}
+	for _, tt := range tests {
+		if got := peerPathString(&tt.ps); got != tt.want {
+			t.Errorf("peerPathString(%+v) = %q; want %q", tt.ps, got, tt.want)
+		}
+	}
+}
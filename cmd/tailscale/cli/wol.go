@@ -0,0 +1,83 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+var wolCmd = &ffcli.Command{
+	Name:       "wol",
+	ShortUsage: "wol [--via=<hostname-or-IP>] <hostname-or-IP>",
+	ShortHelp:  "Wake a sleeping peer with Wake-on-LAN",
+	LongHelp: strings.TrimSpace(`
+
+The 'tailscale wol' command wakes a sleeping peer by having a node on
+the same LAN send Wake-on-LAN magic packets to the MAC addresses the
+peer reported before it went to sleep.
+
+Unless --via is given, this machine sends the packets itself if it's
+on the peer's LAN, and otherwise uses the first online peer that
+appears to be. The node sending the packets must be owned by the same
+user, or grant the wake-on-lan capability in the tailnet policy.
+
+`),
+	Exec: runWoL,
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("wol")
+		fs.StringVar(&wolArgs.via, "via", "", "hostname or IP of the peer to send the packets from")
+		return fs
+	})(),
+}
+
+var wolArgs struct {
+	via string
+}
+
+func runWoL(ctx context.Context, args []string) error {
+	if len(args) != 1 || args[0] == "" {
+		return errors.New("usage: wol [--via=<hostname-or-IP>] <hostname-or-IP>")
+	}
+	ip, err := peerIPFromArg(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	var via netip.Addr
+	if wolArgs.via != "" {
+		via, err = peerIPFromArg(ctx, wolArgs.via)
+		if err != nil {
+			return fmt.Errorf("--via: %w", err)
+		}
+	}
+	res, err := localClient.WakeOnLAN(ctx, ip, via)
+	if err != nil {
+		return err
+	}
+	from := "this machine"
+	if res.Relay != "" {
+		from = res.Relay
+	}
+	printf("sent Wake-on-LAN packets for %s from %s (%s)\n", strings.Join(res.MACs, ", "), from, strings.Join(res.SentFrom, ", "))
+	return nil
+}
+
+// peerIPFromArg resolves arg to the Tailscale IP of a peer.
+func peerIPFromArg(ctx context.Context, arg string) (netip.Addr, error) {
+	ipStr, self, err := tailscaleIPFromArg(ctx, arg)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	if self {
+		return netip.Addr{}, fmt.Errorf("%v is this machine", arg)
+	}
+	return netip.ParseAddr(ipStr)
+}
@@ -0,0 +1,46 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.19
+// +build go1.19
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+
+	"tailscale.com/doctor/preflight"
+)
+
+// preflightConfig returns the configuration that the preflight checks
+// validate the environment for, per the command-line flags.
+func preflightConfig() preflight.Config {
+	return preflight.Config{
+		TUNName:    args.tunname,
+		StatePath:  statePathOrDefault(),
+		StateDir:   ipnServerOpts().VarRoot,
+		SocketPath: args.socketpath,
+		Port:       args.port,
+	}
+}
+
+// runPreflight runs the preflight checks for --preflight and prints
+// their report as JSON to stdout. It returns the exit status: 0 if no
+// check failed, 1 if one did, or 2 if the report couldn't be written.
+func runPreflight() int {
+	rep := preflight.Run(context.Background(), preflightConfig())
+	e := json.NewEncoder(os.Stdout)
+	e.SetIndent("", "\t")
+	if err := e.Encode(rep); err != nil {
+		log.Printf("preflight: %v", err)
+		return 2
+	}
+	if !rep.OK {
+		return 1
+	}
+	return 0
+}
@@ -16,6 +16,8 @@
 	"net/http"
 	"net/http/httputil"
 	"strings"
+
+	"tailscale.com/util/crashreport"
 )
 
 // httpProxyHandler returns an HTTP proxy http.Handler using the
@@ -69,14 +71,14 @@ func httpProxyHandler(dialer func(ctx context.Context, netw, addr string) (net.C
 		}
 
 		errc := make(chan error, 1)
-		go func() {
+		crashreport.Go(func() {
 			_, err := io.Copy(cc, c)
 			errc <- err
-		}()
-		go func() {
+		})
+		crashreport.Go(func() {
 			_, err := io.Copy(c, clientSrc)
 			errc <- err
-		}()
+		})
 		<-errc
 	})
 }
@@ -0,0 +1,135 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.19
+// +build go1.19
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+
+	"tailscale.com/net/netns"
+	"tailscale.com/safesocket"
+	"tailscale.com/types/logger"
+	"tailscale.com/types/nettype"
+	"tailscale.com/util/systemd"
+)
+
+// Names of the sockets that systemd may pass to tailscaled, either from
+// a .socket unit's FileDescriptorName= or from the file descriptor
+// store of a previous run (see --systemd-fdstore).
+const (
+	localAPISocketName = "localapi"
+	udp4SocketName     = "udp4"
+	udp6SocketName     = "udp6"
+)
+
+// localAPIListener returns the listener for the LocalAPI: the socket
+// that systemd passed, if any, or else a new one at args.socketpath,
+// which is stored with systemd per --systemd-fdstore.
+func localAPIListener(logf logger.Logf) (net.Listener, error) {
+	if f := systemd.ListenFile(localAPISocketName); f != nil {
+		defer f.Close()
+		ln, err := net.FileListener(f)
+		if err == nil {
+			logf("using LocalAPI socket passed by systemd")
+			return ln, nil
+		}
+		logf("LocalAPI socket passed by systemd: %v; listening on %s instead", err, args.socketpath)
+	}
+	ln, _, err := safesocket.Listen(args.socketpath, safesocket.WindowsLocalPort)
+	if err != nil {
+		return nil, fmt.Errorf("safesocket.Listen: %v", err)
+	}
+	if !args.fdStore {
+		return ln, nil
+	}
+	ul, ok := ln.(*net.UnixListener)
+	if !ok {
+		return ln, nil
+	}
+	f, err := ul.File()
+	if err != nil {
+		logf("storing LocalAPI socket with systemd: %v", err)
+		return ln, nil
+	}
+	defer f.Close()
+	if err := systemd.StoreFiles(localAPISocketName, f); err != nil {
+		logf("storing LocalAPI socket with systemd: %v", err)
+		return ln, nil
+	}
+	// The next run gets the socket from systemd, so its path must
+	// outlive this one.
+	ul.SetUnlinkOnClose(false)
+	return ln, nil
+}
+
+// udpSocketFiles are the UDP sockets that passedPacketConns returns
+// duplicates of, by network.
+var udpSocketFiles = map[string]*os.File{}
+
+// passedPacketConns returns the IPv4 and IPv6 UDP sockets for the
+// engine to use instead of binding its own, or nil for either if it
+// should bind its own. They're those that systemd passed, if any, or
+// else, per --systemd-fdstore, new ones on args.port that are stored
+// with systemd, so that they survive a restart along with the peer
+// traffic queued on them.
+//
+// It must be called after netns is configured.
+func passedPacketConns(logf logger.Logf) (pc4, pc6 nettype.PacketConn) {
+	return passedPacketConn(logf, "udp4", udp4SocketName), passedPacketConn(logf, "udp6", udp6SocketName)
+}
+
+func passedPacketConn(logf logger.Logf, network, name string) nettype.PacketConn {
+	f := udpSocketFiles[network]
+	if f == nil {
+		f = systemd.ListenFile(name)
+		if f == nil && args.fdStore {
+			f = bindAndStore(logf, network, name)
+		}
+		if f == nil {
+			return nil
+		}
+		udpSocketFiles[network] = f
+	}
+	// Each engine gets its own duplicate, as it closes it.
+	pc, err := net.FilePacketConn(f)
+	if err != nil {
+		logf("%s socket passed by systemd: %v", network, err)
+		return nil
+	}
+	upc, ok := pc.(*net.UDPConn)
+	if !ok {
+		logf("%s socket passed by systemd is a %T, not UDP", network, pc)
+		pc.Close()
+		return nil
+	}
+	return upc
+}
+
+// bindAndStore binds a UDP socket for network on args.port, stores it
+// with systemd under name and returns it, or returns nil on failure.
+func bindAndStore(logf logger.Logf, network, name string) *os.File {
+	pc, err := netns.Listener(logf).ListenPacket(context.Background(), network, fmt.Sprintf(":%d", args.port))
+	if err != nil {
+		logf("binding %s socket to store with systemd: %v", network, err)
+		return nil
+	}
+	defer pc.Close()
+	f, err := pc.(*net.UDPConn).File()
+	if err != nil {
+		logf("storing %s socket with systemd: %v", network, err)
+		return nil
+	}
+	if err := systemd.StoreFiles(name, f); err != nil {
+		logf("storing %s socket with systemd: %v", network, err)
+		f.Close()
+		return nil
+	}
+	return f
+}
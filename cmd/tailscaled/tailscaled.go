@@ -45,11 +45,12 @@
 	"tailscale.com/net/tsdial"
 	"tailscale.com/net/tstun"
 	"tailscale.com/paths"
-	"tailscale.com/safesocket"
 	"tailscale.com/tsweb"
 	"tailscale.com/types/flagtype"
 	"tailscale.com/types/logger"
 	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/crashreport"
+	"tailscale.com/util/leakwatch"
 	"tailscale.com/util/multierr"
 	"tailscale.com/util/osshare"
 	"tailscale.com/version"
@@ -113,6 +114,10 @@ func defaultTunName() string {
 	verbose        int
 	socksAddr      string // listen address for SOCKS5 server
 	httpProxyAddr  string // listen address for HTTP proxy server
+	derpMap        string // file path or URL of a DERP map overriding control's
+	fdStore        bool   // keep sockets open across restarts with systemd's file descriptor store
+	preflight      bool   // validate the environment and exit
+	doctorChecks   string // directory of external doctor checks
 }
 
 var (
@@ -143,6 +148,10 @@ func main() {
 	flag.StringVar(&args.statedir, "statedir", "", "path to directory for storage of config state, TLS certs, temporary incoming Taildrop files, etc. If empty, it's derived from --state when possible.")
 	flag.StringVar(&args.socketpath, "socket", paths.DefaultTailscaledSocket(), "path of the service unix socket")
 	flag.StringVar(&args.birdSocketPath, "bird-socket", "", "path of the bird unix socket")
+	flag.StringVar(&args.derpMap, "derp-map", "", "optional path or http(s) URL of a JSON DERP map to use instead of the one from the control server; it is re-read periodically and changes are applied live. For testing self-hosted DERP servers.")
+	flag.BoolVar(&args.fdStore, "systemd-fdstore", false, "store the LocalAPI and UDP sockets with systemd so they stay open when tailscaled restarts, such as for an upgrade; requires FileDescriptorStoreMax=3 and RuntimeDirectoryPreserve=restart in the unit")
+	flag.BoolVar(&args.preflight, "preflight", false, "check that the environment is fit to run tailscaled with the other flags given (TUN device, state directory, UDP port, sysctls, other tailscaled processes), print a JSON report and exit; the exit status is 1 if a check failed")
+	flag.StringVar(&args.doctorChecks, "doctor-checks-dir", "", "optional directory of executables to run as site-defined checks along with the built-in ones of 'tailscale doctor'; each must print a JSON result to stdout, and must not be writable by group or others")
 	flag.BoolVar(&printVersion, "version", false, "print version information and exit")
 
 	if len(os.Args) > 0 && filepath.Base(os.Args[0]) == "tailscale" && beCLI != nil {
@@ -199,6 +208,10 @@ func main() {
 		args.statepath = paths.DefaultTailscaledStateFile()
 	}
 
+	if args.preflight {
+		os.Exit(runPreflight())
+	}
+
 	if beWindowsSubprocess() {
 		return
 	}
@@ -362,7 +375,7 @@ func run() error {
 				debugMux.HandleFunc("/debug/magicsock", mc.ServeHTTPDebug)
 			}
 		}
-		go runDebugServer(debugMux, args.debug)
+		crashreport.Go(func() { runDebugServer(debugMux, args.debug) })
 	}
 
 	ns, err := newNetstack(logf, dialer, e)
@@ -383,19 +396,19 @@ func run() error {
 	}
 	if socksListener != nil || httpProxyListener != nil {
 		if httpProxyListener != nil {
-			hs := &http.Server{Handler: httpProxyHandler(dialer.UserDial)}
-			go func() {
+			hs := &http.Server{Handler: httpProxyHandler(dialer.ProxyDial("http"))}
+			crashreport.Go(func() {
 				log.Fatalf("HTTP proxy exited: %v", hs.Serve(httpProxyListener))
-			}()
+			})
 		}
 		if socksListener != nil {
 			ss := &socks5.Server{
 				Logf:   logger.WithPrefix(logf, "socks5: "),
-				Dialer: dialer.UserDial,
+				Dialer: dialer.ProxyDial("socks5"),
 			}
-			go func() {
+			crashreport.Go(func() {
 				log.Fatalf("SOCKS5 server exited: %v", ss.Serve(socksListener))
-			}()
+			})
 		}
 	}
 
@@ -410,7 +423,7 @@ func run() error {
 	// tailscaled. The default action is to terminate the process, we
 	// want to keep running.
 	signal.Ignore(syscall.SIGPIPE)
-	go func() {
+	crashreport.Go(func() {
 		select {
 		case s := <-interrupt:
 			logf("tailscaled got signal %v; shutting down", s)
@@ -418,7 +431,7 @@ func run() error {
 		case <-ctx.Done():
 			// continue
 		}
-	}()
+	})
 
 	opts := ipnServerOpts()
 
@@ -430,6 +443,21 @@ func run() error {
 	if err != nil {
 		return fmt.Errorf("ipnserver.New: %w", err)
 	}
+	if args.derpMap != "" {
+		if err := srv.LocalBackend().SetDERPMapSource(args.derpMap); err != nil {
+			return fmt.Errorf("--derp-map: %w", err)
+		}
+	}
+	srv.LocalBackend().SetPreflightConfig(preflightConfig())
+	if args.doctorChecks != "" {
+		srv.LocalBackend().SetDoctorChecksDir(args.doctorChecks)
+	}
+	srv.LocalBackend().SetLogLeveler(pol.Logtail)
+	srv.LocalBackend().SetRecentLogWriter(pol.Logtail)
+	lw := leakwatch.New(logf)
+	defer lw.Close()
+	crashreport.SetWriter(srv.LocalBackend().WriteCrashReport)
+	defer crashreport.Handle()
 	ns.SetLocalBackend(srv.LocalBackend())
 	if err := ns.Start(); err != nil {
 		log.Fatalf("failed to start netstack: %v", err)
@@ -439,9 +467,9 @@ func run() error {
 		debugMux.HandleFunc("/debug/ipn", srv.ServeHTMLStatus)
 	}
 
-	ln, _, err := safesocket.Listen(args.socketpath, safesocket.WindowsLocalPort)
+	ln, err := localAPIListener(logf)
 	if err != nil {
-		return fmt.Errorf("safesocket.Listen: %v", err)
+		return err
 	}
 	defer dialer.Close()
 
@@ -498,6 +526,7 @@ func tryEngine(logf logger.Logf, linkMon *monitor.Mon, dialer *tsdial.Dialer, na
 
 	useNetstack = name == "userspace-networking"
 	netns.SetEnabled(!useNetstack)
+	conf.PacketConn4, conf.PacketConn6 = passedPacketConns(logf)
 
 	if args.birdSocketPath != "" && createBIRDClient != nil {
 		log.Printf("Connecting to BIRD at %s ...", args.birdSocketPath)
@@ -42,6 +42,7 @@
 	"tailscale.com/net/tstun"
 	"tailscale.com/safesocket"
 	"tailscale.com/types/logger"
+	"tailscale.com/util/crashreport"
 	"tailscale.com/util/winutil"
 	"tailscale.com/version"
 	"tailscale.com/wf"
@@ -107,7 +108,7 @@ func (service *ipnService) Execute(args []string, r <-chan svc.ChangeRequest, ch
 
 	ctx, cancel := context.WithCancel(context.Background())
 	doneCh := make(chan struct{})
-	go func() {
+	crashreport.Go(func() {
 		defer close(doneCh)
 		args := []string{"/subproc", service.Policy.PublicID.String()}
 		// Make a logger without a date prefix, as filelogger
@@ -118,7 +119,7 @@ func (service *ipnService) Execute(args []string, r <-chan svc.ChangeRequest, ch
 		// output.
 		logger := log.New(log.Default().Writer(), "", 0)
 		ipnserver.BabysitProc(ctx, args, logger.Printf)
-	}()
+	})
 
 	changes <- svc.Status{State: svc.Running, Accepts: svcAccepts}
 	syslogf("Service running")
@@ -198,7 +199,7 @@ func beWindowsSubprocess() bool {
 	log.Printf("Program starting: v%v: %#v", version.Long, os.Args)
 	log.Printf("subproc mode: logid=%v", logid)
 
-	go func() {
+	crashreport.Go(func() {
 		b := make([]byte, 16)
 		for {
 			_, err := os.Stdin.Read(b)
@@ -206,7 +207,7 @@ func beWindowsSubprocess() bool {
 				log.Fatalf("stdin err (parent process died): %v", err)
 			}
 		}
-	}()
+	})
 
 	err := startIPNServer(context.Background(), logid)
 	if err != nil {
@@ -315,7 +316,7 @@ type engineOrError struct {
 	}
 	engErrc := make(chan engineOrError)
 	t0 := time.Now()
-	go func() {
+	crashreport.Go(func() {
 		const ms = time.Millisecond
 		for try := 1; ; try++ {
 			logf("tailscaled: getting engine... (try %v)", try)
@@ -340,7 +341,7 @@ type engineOrError struct {
 			}
 			<-timer.C
 		}
-	}()
+	})
 
 	// getEngine is called by ipnserver to get the engine. It's
 	// not called concurrently and is not called again once it
@@ -389,12 +390,12 @@ func handleSessionChange(chgRequest svc.ChangeRequest) {
 	}
 
 	log.Printf("Received WTS_SESSION_UNLOCK event, initiating DNS flush.")
-	go func() {
+	crashreport.Go(func() {
 		err := dns.Flush()
 		if err != nil {
 			log.Printf("Error flushing DNS on session unlock: %v", err)
 		}
-	}()
+	})
 }
 
 var (
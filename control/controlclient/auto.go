@@ -67,6 +67,7 @@ type Auto struct {
 	inPollNetMap    bool       // true if currently running a PollNetMap
 	inLiteMapUpdate bool       // true if a lite (non-streaming) map request is outstanding
 	inSendStatus    int        // number of sendStatus calls currently in progress
+	queuedUpdates   int        // Hostinfo/NetInfo/endpoint updates held back while offline
 	state           State
 
 	authCtx    context.Context // context used for auth requests
@@ -123,6 +124,17 @@ func (c *Auto) onHealthChange(sys health.Subsystem, err error) {
 	if sys == health.SysOverall {
 		return
 	}
+	if sys == health.SysUplink && err == nil {
+		c.mu.Lock()
+		n := c.queuedUpdates
+		c.queuedUpdates = 0
+		c.mu.Unlock()
+		if n > 0 {
+			// The new map request below carries the latest
+			// state, which covers all of them.
+			c.logf("controlclient: back online; sending %d queued updates", n)
+		}
+	}
 	c.logf("controlclient: restarting map request for %q health change to new state: %v", sys, err)
 	c.cancelMapSafely()
 }
@@ -163,7 +175,18 @@ func (c *Auto) Start() {
 // streaming response open), or start a new streaming one if necessary.
 //
 // It should be called whenever there's something new to tell the server.
+//
+// While the node is offline, the update is queued instead, and sent when
+// the uplink health check recovers.
 func (c *Auto) sendNewMapRequest() {
+	if r := health.OfflineReason(); r != "" {
+		c.mu.Lock()
+		c.queuedUpdates++
+		c.mu.Unlock()
+		c.logf("[v1] offline (%s); queuing map update", r)
+		return
+	}
+
 	c.mu.Lock()
 
 	// If we're not already streaming a netmap, or if we're already stuck
@@ -442,7 +465,9 @@ func (c *Auto) mapRoutine() {
 			err = fmt.Errorf("%s: %w", msg, err)
 			// don't send status updates for context errors,
 			// since context cancelation is always on purpose.
-			if ctx.Err() == nil {
+			// Nor while offline, which health reports as such,
+			// as every request fails then.
+			if ctx.Err() == nil && health.OfflineReason() == "" {
 				c.sendStatus("mapRoutine1", err, "", nil)
 			}
 		}
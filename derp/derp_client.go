@@ -34,6 +34,9 @@ type Client struct {
 	canAckPings bool
 	isProber    bool
 
+	readTimeout  time.Duration // if non-zero, how long Recv waits for a frame
+	writeTimeout time.Duration // if non-zero, max time a send may block
+
 	wmu  sync.Mutex // hold while writing to bw
 	bw   *bufio.Writer
 	rate *rate.Limiter // if non-nil, rate limiter to use
@@ -58,8 +61,15 @@ type clientOpt struct {
 	ServerPub   key.NodePublic
 	CanAckPings bool
 	IsProber    bool
+
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
 }
 
+// defaultReadTimeout is how long Recv waits for a frame from the
+// server by default. The server sends keep-alives every 60 seconds.
+const defaultReadTimeout = 120 * time.Second
+
 // MeshKey returns a ClientOpt to pass to the DERP server during connect to get
 // access to join the mesh.
 //
@@ -82,6 +92,20 @@ func CanAckPings(v bool) ClientOpt {
 	return clientOptFunc(func(o *clientOpt) { o.CanAckPings = v })
 }
 
+// ReadTimeout returns a ClientOpt to set how long Recv waits for a
+// frame from the server before failing. Zero means the default of
+// 120 seconds.
+func ReadTimeout(d time.Duration) ClientOpt {
+	return clientOptFunc(func(o *clientOpt) { o.ReadTimeout = d })
+}
+
+// WriteTimeout returns a ClientOpt to set how long a write to the
+// server may block before the connection is closed. Zero means
+// writes may block indefinitely.
+func WriteTimeout(d time.Duration) ClientOpt {
+	return clientOptFunc(func(o *clientOpt) { o.WriteTimeout = d })
+}
+
 func NewClient(privateKey key.NodePrivate, nc Conn, brw *bufio.ReadWriter, logf logger.Logf, opts ...ClientOpt) (*Client, error) {
 	var opt clientOpt
 	for _, o := range opts {
@@ -104,6 +128,9 @@ func newClient(privateKey key.NodePrivate, nc Conn, brw *bufio.ReadWriter, logf
 		meshKey:     opt.MeshKey,
 		canAckPings: opt.CanAckPings,
 		isProber:    opt.IsProber,
+
+		readTimeout:  opt.ReadTimeout,
+		writeTimeout: opt.WriteTimeout,
 	}
 	if opt.ServerPub.IsZero() {
 		if err := c.recvServerKey(); err != nil {
@@ -213,6 +240,7 @@ func (c *Client) send(dstKey key.NodePublic, pkt []byte) (ret error) {
 
 	c.wmu.Lock()
 	defer c.wmu.Unlock()
+	defer c.startWriteTimer()()
 	if c.rate != nil {
 		pktLen := frameHeaderLen + key.NodePublicRawLen + len(pkt)
 		if !c.rate.AllowN(time.Now(), pktLen) {
@@ -265,6 +293,20 @@ func (c *Client) ForwardPacket(srcKey, dstKey key.NodePublic, pkt []byte) (err e
 
 func (c *Client) writeTimeoutFired() { c.nc.Close() }
 
+// startWriteTimer arranges for the connection to be closed if the
+// caller's write doesn't complete within c.writeTimeout. It returns a
+// func to stop the timer. If no write timeout is configured, it's a
+// no-op.
+//
+// c.wmu must be held.
+func (c *Client) startWriteTimer() (stop func()) {
+	if c.writeTimeout <= 0 {
+		return func() {}
+	}
+	timer := time.AfterFunc(c.writeTimeout, c.writeTimeoutFired)
+	return func() { timer.Stop() }
+}
+
 func (c *Client) SendPing(data [8]byte) error {
 	return c.sendPingOrPong(framePing, data)
 }
@@ -276,6 +318,7 @@ func (c *Client) SendPong(data [8]byte) error {
 func (c *Client) sendPingOrPong(typ frameType, data [8]byte) error {
 	c.wmu.Lock()
 	defer c.wmu.Unlock()
+	defer c.startWriteTimer()()
 	if err := writeFrameHeader(c.bw, typ, 8); err != nil {
 		return err
 	}
@@ -441,7 +484,11 @@ func (ServerRestartingMessage) msg() {}
 //
 // Once Recv returns an error, the Client is dead forever.
 func (c *Client) Recv() (m ReceivedMessage, err error) {
-	return c.recvTimeout(120 * time.Second)
+	timeout := c.readTimeout
+	if timeout <= 0 {
+		timeout = defaultReadTimeout
+	}
+	return c.recvTimeout(timeout)
 }
 
 func (c *Client) recvTimeout(timeout time.Duration) (m ReceivedMessage, err error) {
@@ -54,6 +54,12 @@ type Client struct {
 	MeshKey   string             // optional; for trusted clients
 	IsProber  bool               // optional; for probers to optional declare themselves as such
 
+	// ReadTimeout and WriteTimeout optionally bound how long reads
+	// from and writes to the DERP server may block. Zero means the
+	// derp package defaults. See derp.ReadTimeout and derp.WriteTimeout.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
 	privateKey key.NodePrivate
 	logf       logger.Logf
 	dialer     func(ctx context.Context, network, addr string) (net.Conn, error)
@@ -310,6 +316,8 @@ func (c *Client) connect(ctx context.Context, caller string) (client *derp.Clien
 			derp.MeshKey(c.MeshKey),
 			derp.CanAckPings(c.canAckPings),
 			derp.IsProber(c.IsProber),
+			derp.ReadTimeout(c.ReadTimeout),
+			derp.WriteTimeout(c.WriteTimeout),
 		)
 		if err != nil {
 			return nil, 0, err
@@ -442,6 +450,8 @@ func (c *Client) connect(ctx context.Context, caller string) (client *derp.Clien
 		derp.ServerPublicKey(serverPub),
 		derp.CanAckPings(c.canAckPings),
 		derp.IsProber(c.IsProber),
+		derp.ReadTimeout(c.ReadTimeout),
+		derp.WriteTimeout(c.WriteTimeout),
 	)
 	if err != nil {
 		return nil, 0, err
@@ -6,6 +6,7 @@
 
 import (
 	"context"
+	"errors"
 	"sync"
 	"time"
 
@@ -14,6 +15,34 @@
 	"tailscale.com/types/logger"
 )
 
+// NewMeshClient returns a client for s, a DERP server with a mesh key
+// (see derp.Server.SetMeshKey), to mesh with the DERP server at
+// serverURL, such as "https://derp2.example.com/derp". Its URL dialer
+// can be set before it's run with RunMeshLoop.
+func NewMeshClient(s *derp.Server, serverURL string, logf logger.Logf) (*Client, error) {
+	if !s.HasMeshKey() {
+		return nil, errors.New("derphttp: meshing requires a mesh key")
+	}
+	c, err := NewClient(s.PrivateKey(), serverURL, logf)
+	if err != nil {
+		return nil, err
+	}
+	c.MeshKey = s.MeshKey()
+	return c, nil
+}
+
+// RunMeshLoop runs c, from NewMeshClient, until ctx is done, having s
+// forward packets for the clients of the server c connects to over c as
+// they come and go. It returns early if c connects to s itself, so a
+// list of servers to mesh with can include s.
+//
+// infoLogf is as for RunWatchConnectionLoop.
+func (c *Client) RunMeshLoop(ctx context.Context, s *derp.Server, infoLogf logger.Logf) {
+	add := func(k key.NodePublic) { s.AddPacketForwarder(k, c) }
+	remove := func(k key.NodePublic) { s.RemovePacketForwarder(k, c) }
+	c.RunWatchConnectionLoop(ctx, s.PublicKey(), infoLogf, add, remove)
+}
+
 // RunWatchConnectionLoop loops until ctx is done, sending WatchConnectionChanges and subscribing to
 // connection changes.
 //
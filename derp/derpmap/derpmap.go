@@ -0,0 +1,117 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package derpmap loads and validates DERP maps supplied locally,
+// rather than by the control plane, so that self-hosted DERP servers
+// can be tested without control changes.
+package derpmap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"tailscale.com/tailcfg"
+)
+
+// maxSize is the maximum size of a DERP map that Fetch will read.
+const maxSize = 4 << 20
+
+// IsURL reports whether src refers to an http or https URL, rather
+// than a local file.
+func IsURL(src string) bool {
+	return strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://")
+}
+
+// Fetch returns the raw contents of the DERP map at src, which is
+// either a local file path or an http(s) URL.
+func Fetch(ctx context.Context, src string) ([]byte, error) {
+	if !IsURL(src) {
+		return os.ReadFile(src)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", src, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", src, res.Status)
+	}
+	return io.ReadAll(io.LimitReader(res.Body, maxSize))
+}
+
+// Parse decodes a JSON DERP map and validates it.
+func Parse(b []byte) (*tailcfg.DERPMap, error) {
+	dm := new(tailcfg.DERPMap)
+	if err := json.Unmarshal(b, dm); err != nil {
+		return nil, fmt.Errorf("decoding DERP map: %w", err)
+	}
+	if err := Validate(dm); err != nil {
+		return nil, err
+	}
+	return dm, nil
+}
+
+// Load fetches the DERP map at src and parses it.
+func Load(ctx context.Context, src string) (*tailcfg.DERPMap, error) {
+	b, err := Fetch(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(b)
+}
+
+// Validate reports whether dm is well formed: every region must have a
+// positive RegionID matching its map key and at least one node, and
+// every node must have a unique, non-empty name and a hostname, and
+// belong to the region that contains it.
+func Validate(dm *tailcfg.DERPMap) error {
+	if dm == nil || len(dm.Regions) == 0 {
+		return errors.New("DERP map has no regions")
+	}
+	names := map[string]bool{}
+	for _, id := range dm.RegionIDs() {
+		r := dm.Regions[id]
+		if r == nil {
+			return fmt.Errorf("region %d is null", id)
+		}
+		if id <= 0 {
+			return fmt.Errorf("region %d: RegionID must be positive", id)
+		}
+		if r.RegionID != id {
+			return fmt.Errorf("region %d: has RegionID %d", id, r.RegionID)
+		}
+		if len(r.Nodes) == 0 {
+			return fmt.Errorf("region %d: no nodes", id)
+		}
+		for i, n := range r.Nodes {
+			if n == nil {
+				return fmt.Errorf("region %d: node %d is null", id, i)
+			}
+			if n.Name == "" {
+				return fmt.Errorf("region %d: node %d has no Name", id, i)
+			}
+			if names[n.Name] {
+				return fmt.Errorf("region %d: duplicate node name %q", id, n.Name)
+			}
+			names[n.Name] = true
+			if n.RegionID != id {
+				return fmt.Errorf("node %q: has RegionID %d, want %d", n.Name, n.RegionID, id)
+			}
+			if n.HostName == "" {
+				return fmt.Errorf("node %q: no HostName", n.Name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package derpmap
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testMap = `{"Regions": {"900": {"RegionID": 900, "RegionCode": "test", "Nodes": [
+	{"Name": "900a", "RegionID": 900, "HostName": "derp.example.com"}
+]}}}`
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		wantErr string
+	}{
+		{"good", testMap, ""},
+		{"bad-json", `{`, "decoding DERP map"},
+		{"empty", `{}`, "no regions"},
+		{"key-mismatch", `{"Regions": {"900": {"RegionID": 901, "Nodes": [{"Name": "a", "RegionID": 901, "HostName": "h"}]}}}`, "has RegionID 901"},
+		{"no-nodes", `{"Regions": {"900": {"RegionID": 900}}}`, "no nodes"},
+		{"no-hostname", `{"Regions": {"900": {"RegionID": 900, "Nodes": [{"Name": "a", "RegionID": 900}]}}}`, "no HostName"},
+		{"node-region", `{"Regions": {"900": {"RegionID": 900, "Nodes": [{"Name": "a", "RegionID": 1, "HostName": "h"}]}}}`, "want 900"},
+		{"dup-name", `{"Regions": {
+			"900": {"RegionID": 900, "Nodes": [{"Name": "a", "RegionID": 900, "HostName": "h"}]},
+			"901": {"RegionID": 901, "Nodes": [{"Name": "a", "RegionID": 901, "HostName": "h"}]}}}`, "duplicate node name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dm, err := Parse([]byte(tt.in))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if dm.Regions[900].Nodes[0].HostName != "derp.example.com" {
+					t.Errorf("unexpected map: %+v", dm)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v; want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "derpmap.json")
+	if err := os.WriteFile(path, []byte(testMap), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(ctx, path); err != nil {
+		t.Errorf("file: %v", err)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/derpmap.json" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(testMap))
+	}))
+	defer ts.Close()
+	if _, err := Load(ctx, ts.URL+"/derpmap.json"); err != nil {
+		t.Errorf("URL: %v", err)
+	}
+	if _, err := Load(ctx, ts.URL+"/missing"); err == nil {
+		t.Error("missing URL: got nil error")
+	}
+}
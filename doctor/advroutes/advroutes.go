@@ -0,0 +1,97 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package advroutes provides a doctor.Check that flags the routes
+// advertised by a subnet router that no longer correspond to any of
+// its local interfaces, such as after one moved to another subnet.
+package advroutes
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"tailscale.com/doctor"
+	"tailscale.com/net/interfaces"
+	"tailscale.com/types/logger"
+)
+
+// Check is a doctor.Check that matches the advertised routes against
+// the subnets of the local interfaces.
+type Check struct {
+	// Routes are the advertised routes, per the AdvertiseRoutes pref.
+	Routes []netip.Prefix
+}
+
+func (Check) Name() string {
+	return "advertised-routes"
+}
+
+// Categories implements doctor.Categorized.
+func (Check) Categories() []doctor.Category {
+	return []doctor.Category{doctor.CategoryNetwork}
+}
+
+// Lightweight implements doctor.Lightweight: it only reads the local
+// interfaces' addresses.
+func (Check) Lightweight() bool { return true }
+
+func (c Check) Run(ctx context.Context, logf logger.Logf) error {
+	var routes []netip.Prefix
+	for _, r := range c.Routes {
+		if r.Bits() > 0 { // not an exit route
+			routes = append(routes, r)
+		}
+	}
+	if len(routes) == 0 {
+		logf("no subnet routes advertised")
+		return nil
+	}
+	st, err := interfaces.GetState()
+	if err != nil {
+		return err
+	}
+	unmatched := matchRoutes(routes, st.Subnets(), logf)
+	if len(unmatched) == 0 {
+		return nil
+	}
+	var s []string
+	for _, r := range unmatched {
+		s = append(s, r.String())
+	}
+	doctor.Report(ctx, doctor.SeverityWarning,
+		fmt.Sprintf("advertised route(s) %s match no local interface's subnet; unless they're reached through a gateway, peers can't reach them", strings.Join(s, ", ")),
+		s)
+	doctor.Remediate(ctx, "check the addresses of the interfaces the routes were for, then update --advertise-routes (or set --readvertise-routes to follow address changes)")
+	return nil
+}
+
+// matchRoutes logs the interface of each of routes, per subnets, the
+// subnets of the local interfaces by name, and returns the routes that
+// overlap none of them.
+func matchRoutes(routes []netip.Prefix, subnets map[string][]netip.Prefix, logf logger.Logf) []netip.Prefix {
+	names := make([]string, 0, len(subnets))
+	for name := range subnets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var unmatched []netip.Prefix
+Routes:
+	for _, r := range routes {
+		for _, name := range names {
+			for _, sub := range subnets[name] {
+				if sub.Overlaps(r) {
+					logf("%v: on %s (%v)", r, name, sub)
+					continue Routes
+				}
+			}
+		}
+		logf("%v: on no local interface", r)
+		unmatched = append(unmatched, r)
+	}
+	return unmatched
+}
@@ -0,0 +1,37 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package advroutes
+
+import (
+	"fmt"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestMatchRoutes(t *testing.T) {
+	pfx := netip.MustParsePrefix
+	subnets := map[string][]netip.Prefix{
+		"eth0": {pfx("203.0.113.0/24")},
+		"eth1": {pfx("192.168.1.0/24"), pfx("2001:db8::/64")},
+	}
+	routes := []netip.Prefix{pfx("192.168.1.0/24"), pfx("192.168.1.10/32"), pfx("10.20.0.0/16"), pfx("2001:db8::/48")}
+	var lines []string
+	got := matchRoutes(routes, subnets, func(format string, args ...any) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	})
+	if want := []netip.Prefix{pfx("10.20.0.0/16")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unmatched = %v; want %v", got, want)
+	}
+	wantLines := []string{
+		"192.168.1.0/24: on eth1 (192.168.1.0/24)",
+		"192.168.1.10/32: on eth1 (192.168.1.0/24)",
+		"10.20.0.0/16: on no local interface",
+		"2001:db8::/48: on eth1 (2001:db8::/64)",
+	}
+	if !reflect.DeepEqual(lines, wantLines) {
+		t.Errorf("logged %q; want %q", lines, wantLines)
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package derp provides a doctor.Check that verifies that the regions
+// in a DERP map are reachable.
+package derp
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"tailscale.com/doctor"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/multierr"
+)
+
+// probeTimeout is how long to wait for each DERP node to answer.
+const probeTimeout = 5 * time.Second
+
+// Check is a doctor.Check that probes every DERP node in a DERP map
+// and reports an error for each region with no reachable nodes.
+type Check struct {
+	// DERPMap is the map to check. If nil, the check is skipped.
+	DERPMap *tailcfg.DERPMap
+}
+
+func (Check) Name() string {
+	return "derp"
+}
+
+// Categories implements doctor.Categorized.
+func (Check) Categories() []doctor.Category {
+	return []doctor.Category{doctor.CategoryNetwork, doctor.CategoryPerformance}
+}
+
+func (c Check) Run(ctx context.Context, logf logger.Logf) error {
+	if c.DERPMap == nil {
+		logf("no DERP map; skipping")
+		return nil
+	}
+	ids := c.DERPMap.RegionIDs()
+	var total, done int
+	for _, id := range ids {
+		for _, n := range c.DERPMap.Regions[id].Nodes {
+			if !n.STUNOnly {
+				total++
+			}
+		}
+	}
+	var errs []error
+	for _, id := range ids {
+		r := c.DERPMap.Regions[id]
+		ok := false
+		for _, n := range r.Nodes {
+			if n.STUNOnly {
+				continue
+			}
+			doctor.ReportProgress(ctx, done, total, fmt.Sprintf("probing region %d (%s) node %s", id, r.RegionCode, n.Name))
+			done++
+			start := time.Now()
+			if err := probeNode(ctx, n); err != nil {
+				logf("region %d (%s): node %s: %v", id, r.RegionCode, n.Name, err)
+				continue
+			}
+			logf("region %d (%s): node %s reachable in %v", id, r.RegionCode, n.Name, time.Since(start).Round(time.Millisecond))
+			ok = true
+		}
+		if !ok {
+			errs = append(errs, fmt.Errorf("region %d (%s) unreachable", id, r.RegionCode))
+		}
+	}
+	return multierr.New(errs...)
+}
+
+// probeNode fetches the /derp/probe endpoint of n.
+func probeNode(ctx context.Context, n *tailcfg.DERPNode) error {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	host := n.HostName
+	if n.DERPPort != 0 {
+		host = net.JoinHostPort(host, strconv.Itoa(n.DERPPort))
+	}
+	tr := &http.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: n.InsecureForTests,
+		},
+	}
+	defer tr.CloseIdleConnections()
+
+	req, err := http.NewRequestWithContext(ctx, "HEAD", "https://"+host+"/derp/probe", nil)
+	if err != nil {
+		return err
+	}
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package derp
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"tailscale.com/tailcfg"
+)
+
+func TestCheck(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/derp/probe" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	host, port, err := net.SplitHostPort(ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	portNum, _ := strconv.Atoi(port)
+
+	node := func(region int, name string) *tailcfg.DERPNode {
+		return &tailcfg.DERPNode{
+			Name:             name,
+			RegionID:         region,
+			HostName:         host,
+			DERPPort:         portNum,
+			InsecureForTests: true,
+		}
+	}
+	dead := node(901, "901a")
+	dead.DERPPort = 1 // nothing listening
+
+	dm := &tailcfg.DERPMap{
+		Regions: map[int]*tailcfg.DERPRegion{
+			900: {RegionID: 900, RegionCode: "up", Nodes: []*tailcfg.DERPNode{node(900, "900a")}},
+			901: {RegionID: 901, RegionCode: "down", Nodes: []*tailcfg.DERPNode{dead}},
+		},
+	}
+	err = Check{DERPMap: dm}.Run(context.Background(), t.Logf)
+	if err == nil {
+		t.Fatal("got nil error; want region 901 unreachable")
+	}
+	if got, want := err.Error(), "region 901 (down) unreachable"; got != want {
+		t.Errorf("error = %q; want %q", got, want)
+	}
+
+	delete(dm.Regions, 901)
+	if err := (Check{DERPMap: dm}).Run(context.Background(), t.Logf); err != nil {
+		t.Errorf("all up: %v", err)
+	}
+}
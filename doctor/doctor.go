@@ -0,0 +1,994 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package doctor contains more in-depth healthchecks that can be run to aid in
+// diagnosing Tailscale issues.
+package doctor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tailscale.com/types/logger"
+)
+
+// Check is the interface defining a singular check.
+//
+// A check should log information that it gathers using the provided log
+// function, and should attempt to make as much progress as possible in error
+// conditions.
+type Check interface {
+	// Name should return a name describing this check, in lower-kebab-case
+	// (i.e. "my-check", not "MyCheck" or "my_check").
+	Name() string
+	// Run executes the check, logging diagnostic information to the
+	// provided logger function.
+	Run(context.Context, logger.Logf) error
+}
+
+// Platformer is implemented by Checks that can only run on some
+// systems, or with some privileges.
+type Platformer interface {
+	// Platforms returns what the check requires of the system it
+	// runs on.
+	Platforms() Requirements
+}
+
+// Lightweight is implemented by Checks that can be lightweight: they
+// only inspect local state, such as the OS's configuration, without
+// probing servers or the network, so they're cheap enough to run often.
+type Lightweight interface {
+	// Lightweight reports whether the check is lightweight.
+	Lightweight() bool
+}
+
+// IsLightweight reports whether c implements Lightweight and is
+// lightweight.
+func IsLightweight(c Check) bool {
+	l, ok := c.(Lightweight)
+	return ok && l.Lightweight()
+}
+
+// Category is an area that checks look into, such as DNS, for callers
+// to run only the checks of the area they're debugging.
+type Category string
+
+const (
+	// CategoryNetwork is for checks of connectivity, such as to DERP
+	// or peers, and of the local network configuration.
+	CategoryNetwork Category = "network"
+	// CategoryDNS is for checks of DNS configuration and resolution.
+	CategoryDNS Category = "dns"
+	// CategoryFirewall is for checks of local firewall rules and the
+	// settings that filter packets, such as rp_filter.
+	CategoryFirewall Category = "firewall"
+	// CategoryPlatform is for checks of the OS and tailscaled's own
+	// setup, such as network adapters, profiles and local state.
+	CategoryPlatform Category = "platform"
+	// CategoryPerformance is for checks of what affects throughput or
+	// latency, such as the MTU.
+	CategoryPerformance Category = "performance"
+	// CategoryExternal is for the checks defined by the site, which
+	// doctor/external runs. What they look into is up to the site.
+	CategoryExternal Category = "external"
+)
+
+// Categories are the known categories, in the order they're listed.
+var Categories = []Category{CategoryNetwork, CategoryDNS, CategoryFirewall, CategoryPlatform, CategoryPerformance, CategoryExternal}
+
+// ParseCategory returns the category named s, or an error if it isn't
+// one of Categories.
+func ParseCategory(s string) (Category, error) {
+	for _, c := range Categories {
+		if string(c) == s {
+			return c, nil
+		}
+	}
+	names := make([]string, len(Categories))
+	for i, c := range Categories {
+		names[i] = string(c)
+	}
+	return "", fmt.Errorf("unknown check category %q; want one of %s", s, strings.Join(names, ", "))
+}
+
+// Categorized is implemented by Checks that belong to categories, to
+// be selected by WithOnlyCategories. A check can be in several, such
+// as a DERP check in both CategoryNetwork and CategoryPerformance.
+type Categorized interface {
+	// Categories returns the categories of the check.
+	Categories() []Category
+}
+
+// CategoriesOf returns the categories of c, if it implements
+// Categorized.
+func CategoriesOf(c Check) []Category {
+	if cc, ok := c.(Categorized); ok {
+		return cc.Categories()
+	}
+	return nil
+}
+
+// InCategory returns c, put in the given categories, such as for a
+// check made by CheckFunc.
+func InCategory(c Check, cats ...Category) Check {
+	return categorizedCheck{c, cats}
+}
+
+// categorizedCheck is the Check returned by InCategory.
+type categorizedCheck struct {
+	Check
+	cats []Category
+}
+
+func (c categorizedCheck) Categories() []Category { return c.cats }
+func (c categorizedCheck) Lightweight() bool      { return IsLightweight(c.Check) }
+
+func (c categorizedCheck) Platforms() Requirements {
+	if p, ok := c.Check.(Platformer); ok {
+		return p.Platforms()
+	}
+	return Requirements{}
+}
+
+func (c categorizedCheck) DependsOn() []string {
+	if d, ok := c.Check.(Dependent); ok {
+		return d.DependsOn()
+	}
+	return nil
+}
+
+// Dependent is implemented by Checks that only make sense if others
+// pass, such as probing DERP latency only if DNS resolution works.
+// RunChecks and RunChecksResults run such a check after the checks it
+// depends on, and skip it if any of them fail, with a Skipped reason
+// starting "dependency failed". Dependencies that aren't among the
+// checks run, such as because of WithOnly, are ignored.
+type Dependent interface {
+	// DependsOn returns the names of the checks that the check
+	// depends on.
+	DependsOn() []string
+}
+
+// Requirements are what a Check requires of the system it runs on.
+// The zero value requires nothing.
+type Requirements struct {
+	// OS, if non-empty, are the values of runtime.GOOS the check
+	// runs on.
+	OS []string
+	// Root is whether the check must run as root. It's ignored on
+	// Windows.
+	Root bool
+	// NetAdmin is whether the check needs the CAP_NET_ADMIN
+	// capability, such as to list firewall rules. It's ignored on
+	// platforms other than Linux.
+	NetAdmin bool
+}
+
+// unmet returns why r isn't met on a system running goos, or the
+// empty string if it is.
+func (r Requirements) unmet(goos string, root, netAdmin bool) string {
+	if len(r.OS) > 0 && !slicesContains(r.OS, goos) {
+		return "requires " + strings.Join(r.OS, " or ")
+	}
+	if r.Root && goos != "windows" && !root {
+		return "requires root"
+	}
+	if r.NetAdmin && goos == "linux" && !netAdmin {
+		return "requires CAP_NET_ADMIN"
+	}
+	return ""
+}
+
+func slicesContains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Available returns the empty string if c can run on this system, or
+// else why not, such as "requires root".
+func Available(c Check) string {
+	p, ok := c.(Platformer)
+	if !ok {
+		return ""
+	}
+	return p.Platforms().unmet(runtime.GOOS, os.Geteuid() == 0, haveNetAdmin())
+}
+
+// Severity is how serious the outcome of a check is.
+type Severity string
+
+const (
+	// SeverityOK means the check found nothing wrong.
+	SeverityOK Severity = "ok"
+	// SeverityInfo means the check found something worth knowing,
+	// such as a non-default setting, that isn't a problem.
+	SeverityInfo Severity = "info"
+	// SeverityWarning means the check found something that may cause
+	// problems, but didn't fail.
+	SeverityWarning Severity = "warning"
+	// SeverityError means the check failed.
+	SeverityError Severity = "error"
+	// SeveritySkipped means the check didn't run on this system.
+	SeveritySkipped Severity = "skipped"
+)
+
+// rank orders severities from least to most serious.
+func (s Severity) rank() int {
+	switch s {
+	case SeverityInfo:
+		return 1
+	case SeverityWarning:
+		return 2
+	case SeverityError:
+		return 3
+	}
+	return 0
+}
+
+// severityOrder is the order severities are summarized in.
+var severityOrder = []Severity{SeverityOK, SeverityInfo, SeverityWarning, SeverityError, SeveritySkipped}
+
+// severitySummary returns how many of res have each severity, such as
+// "9 ok, 1 info, 2 warning, 3 skipped". Severities that none have are
+// left out.
+func severitySummary(res []Result) string {
+	counts := make(map[Severity]int)
+	for _, r := range res {
+		counts[r.Severity]++
+	}
+	var parts []string
+	for _, sev := range severityOrder {
+		if n := counts[sev]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, sev))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Report records the outcome of the check whose Run was passed ctx: its
+// severity, a one-line summary for people, and optionally a detail
+// payload for programs, which should marshal to JSON. If called more
+// than once, the most severe report wins; a later one of the same
+// severity replaces an earlier one.
+//
+// A check that doesn't call Report gets SeverityOK, or SeverityError
+// with its error as the summary if it returns one. Like AddFinding, it
+// does nothing if ctx didn't come from RunChecks or RunChecksResults.
+func Report(ctx context.Context, sev Severity, summary string, detail any) {
+	r, ok := ctx.Value(recorderKey{}).(*recorder)
+	if !ok {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if sev.rank() < r.severity.rank() {
+		return
+	}
+	r.severity = sev
+	r.summary = summary
+	r.detail = detail
+}
+
+// Remediate records an actionable step to fix what the check whose Run
+// was passed ctx found, such as "set net.ipv4.ip_forward to 1", for
+// callers to show next to its failure or warning rather than leaving
+// people to work it out from the log. It can be called more than once;
+// the steps are kept in order. Like Report, it does nothing if ctx
+// didn't come from RunChecks or RunChecksResults.
+func Remediate(ctx context.Context, step string) {
+	r, ok := ctx.Value(recorderKey{}).(*recorder)
+	if !ok {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.addRemediationLocked(step)
+}
+
+// WithRemediation returns err annotated with an actionable step to fix
+// it, for checks to return. The error's text is err's; RunChecks and
+// RunChecksResults add step to the check's Result.Remediation, as if
+// passed to Remediate. It returns nil if err is nil.
+func WithRemediation(err error, step string) error {
+	if err == nil {
+		return nil
+	}
+	return &remediationError{err: err, step: step}
+}
+
+// remediationError is the error returned by WithRemediation.
+type remediationError struct {
+	err  error
+	step string
+}
+
+func (e *remediationError) Error() string { return e.err.Error() }
+func (e *remediationError) Unwrap() error { return e.err }
+
+// errRemediations returns the steps that err was annotated with by
+// WithRemediation, outermost first.
+func errRemediations(err error) []string {
+	var ret []string
+	var re *remediationError
+	for errors.As(err, &re) {
+		ret = append(ret, re.step)
+		err = re.err
+	}
+	return ret
+}
+
+// RunChecks runs a list of checks in parallel, along with the registered
+// ones (see Register), and logs any returned errors after all checks
+// have returned, followed by the known issues their findings match and
+// how many checks had each severity.
+// Checks that can't run on this system, per Available, are logged as
+// skipped instead.
+//
+// If checks include any made by WithOnly, only the checks they name
+// run, and the names that match no check are logged as skipped. If they
+// include any made by WithOnlyCategories, only the checks in those
+// categories run. If they include any made by WithRedaction,
+// everything the checks log is redacted before it's logged. If they
+// include any made by WithProgress, the checks' progress is passed to
+// them as they run. If they include any made by WithMaxParallel, at
+// most that many checks run at once.
+//
+// If ctx is done before all the checks finish, the checks that hadn't
+// started are skipped as not run, and those still running are marked
+// CutShort; RunChecks logs both, as listed by Interrupted, before the
+// summary.
+//
+// It also returns the results of each check, in the same order as
+// checks and then the registered ones, for callers that want them in
+// structured form. Like RunChecksResults, it counts the runs and
+// failures of each check, and the time they took, in client metrics
+// named after the check, such as "doctor_check_rp_filter_fail".
+func RunChecks(ctx context.Context, log logger.Logf, checks ...Check) []Result {
+	rd := newRedactor(checks)
+	progress := progressFunc(checks, rd)
+	limit := parallelLimit(checks)
+	checks, unknown := selectChecks(withRegistered(checks))
+	if len(checks) == 0 && len(unknown) == 0 {
+		return nil
+	}
+	res := runChecks(ctx, log, checks, rd, progress, limit)
+	for _, name := range unknown {
+		log("check %s: skipped: %s", name, unknownCheck)
+		res = append(res, unknownResult(name))
+	}
+	for _, r := range res {
+		if r.Err != nil {
+			log("check %s: %v", r.Name, r.Err)
+		}
+		for _, step := range r.Remediation {
+			log("check %s: to fix: %s", r.Name, step)
+		}
+	}
+	for _, fp := range ResultFingerprints(res) {
+		log("known issue: %s; see %s", fp.Name, fp.URL)
+	}
+	if notRun, cutShort := Interrupted(res); len(notRun) > 0 || len(cutShort) > 0 {
+		if len(notRun) > 0 {
+			log("interrupted: not run: %s", strings.Join(notRun, ", "))
+		}
+		if len(cutShort) > 0 {
+			log("interrupted: cut short: %s", strings.Join(cutShort, ", "))
+		}
+	}
+	log("summary: %s", severitySummary(res))
+	return res
+}
+
+// Result is the outcome of running a single Check.
+type Result struct {
+	// Name is the name of the check.
+	Name string
+	// Severity is how serious the outcome is.
+	Severity Severity
+	// Summary is a one-line description of the outcome. It's empty if
+	// the check passed without reporting one.
+	Summary string
+	// Detail, if non-nil, is the structured data the check reported
+	// with Report, such as the rules it inspected.
+	Detail any
+	// Log are the lines the check logged, without the name prefix
+	// that RunChecks adds.
+	Log []string
+	// Err is the error the check returned, if any.
+	Err error
+	// Skipped, if non-empty, is why the check didn't run on this
+	// system, such as "requires root".
+	Skipped string
+	// Findings are the findings the check reported with AddFinding.
+	Findings []Finding
+	// Remediation are the actionable steps to fix what the check
+	// found, from Remediate and WithRemediation, in order.
+	Remediation []string
+	// CutShort is whether the check was still running when the
+	// context it ran with was done, so its outcome may be incomplete.
+	CutShort bool
+}
+
+// notRun is the start of why a check is skipped when the context
+// passed to RunChecks or RunChecksResults was done before it started.
+const notRun = "not run"
+
+// Interrupted returns the names of the checks of results that didn't
+// run, or were cut short, because the context they ran with was done,
+// in the order of results.
+func Interrupted(results []Result) (notStarted, cutShort []string) {
+	for _, r := range results {
+		switch {
+		case strings.HasPrefix(r.Skipped, notRun):
+			notStarted = append(notStarted, r.Name)
+		case r.CutShort:
+			cutShort = append(cutShort, r.Name)
+		}
+	}
+	return notStarted, cutShort
+}
+
+// ResultFingerprints returns the known issues that the findings of
+// results match.
+func ResultFingerprints(results []Result) []Fingerprint {
+	var all []Finding
+	for _, r := range results {
+		all = append(all, r.Findings...)
+	}
+	return MatchFingerprints(all)
+}
+
+// RunChecksResults runs a list of checks in parallel, along with the
+// registered ones, like RunChecks, but returns what each check logged
+// and returned instead of logging it. The results are in the same order
+// as checks and then the registered ones, followed by a skipped result
+// for each name passed to WithOnly that matches no check. Like
+// RunChecks, if ctx is done first, the results are partial; see
+// Interrupted.
+func RunChecksResults(ctx context.Context, checks ...Check) []Result {
+	rd := newRedactor(checks)
+	progress := progressFunc(checks, rd)
+	limit := parallelLimit(checks)
+	checks, unknown := selectChecks(withRegistered(checks))
+	res := runChecks(ctx, nil, checks, rd, progress, limit)
+	for _, name := range unknown {
+		res = append(res, unknownResult(name))
+	}
+	return res
+}
+
+// WithOnly returns a pseudo-check that, passed to RunChecks or
+// RunChecksResults along with the other checks, limits the checks that
+// run to those with the given names, such as so that support can ask
+// for a single check without the output of all the others. If passed
+// more than once, the checks named by any of them run. It does nothing
+// when run itself.
+func WithOnly(names ...string) Check {
+	return onlyChecks(names)
+}
+
+// onlyChecks is the Check returned by WithOnly.
+type onlyChecks []string
+
+func (onlyChecks) Name() string                           { return "" }
+func (onlyChecks) Run(context.Context, logger.Logf) error { return nil }
+
+// WithOnlyLightweight returns a pseudo-check that, passed to RunChecks
+// or RunChecksResults along with the other checks, limits the checks
+// that run to the lightweight ones (see Lightweight), such as for runs
+// on a schedule. If passed along with WithOnly, the checks must be
+// both named and lightweight. It does nothing when run itself.
+func WithOnlyLightweight() Check {
+	return onlyLightweight{}
+}
+
+// onlyLightweight is the Check returned by WithOnlyLightweight.
+type onlyLightweight struct{}
+
+func (onlyLightweight) Name() string                           { return "" }
+func (onlyLightweight) Run(context.Context, logger.Logf) error { return nil }
+
+// WithOnlyCategories returns a pseudo-check that, passed to RunChecks
+// or RunChecksResults along with the other checks, limits the checks
+// that run to those in any of the given categories (see Categorized),
+// such as so that someone debugging DNS only sees the DNS checks. If
+// passed more than once, the checks in any of their categories run. If
+// passed along with WithOnly or WithOnlyLightweight, the checks must
+// match those too. It does nothing when run itself.
+func WithOnlyCategories(cats ...Category) Check {
+	return onlyCategories(cats)
+}
+
+// onlyCategories is the Check returned by WithOnlyCategories.
+type onlyCategories []Category
+
+func (onlyCategories) Name() string                           { return "" }
+func (onlyCategories) Run(context.Context, logger.Logf) error { return nil }
+
+// inAnyCategory reports whether c is in any of cats.
+func inAnyCategory(c Check, cats []Category) bool {
+	for _, have := range CategoriesOf(c) {
+		for _, want := range cats {
+			if have == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WithMaxParallel returns a pseudo-check that, passed to RunChecks or
+// RunChecksResults along with the other checks, limits how many checks
+// run at once to n, such as so that a run doesn't starve a router or
+// other small device; 1 runs them one after the other. A check waiting
+// for those it depends on doesn't count. If passed more than once, the
+// lowest limit wins. A limit less than 1 means no limit, as if it
+// wasn't passed. It does nothing when run itself.
+func WithMaxParallel(n int) Check {
+	return maxParallel(n)
+}
+
+// maxParallel is the Check returned by WithMaxParallel.
+type maxParallel int
+
+func (maxParallel) Name() string                           { return "" }
+func (maxParallel) Run(context.Context, logger.Logf) error { return nil }
+
+// parallelLimit returns the lowest limit passed to WithMaxParallel in
+// checks, or 0 if there's none.
+func parallelLimit(checks []Check) int {
+	limit := 0
+	for _, c := range checks {
+		if n, ok := c.(maxParallel); ok && n > 0 && (limit == 0 || int(n) < limit) {
+			limit = int(n)
+		}
+	}
+	return limit
+}
+
+// isPseudoCheck reports whether c is one of the pseudo-checks that
+// select the other checks or change how they run, rather than a check
+// itself.
+func isPseudoCheck(c Check) bool {
+	switch c.(type) {
+	case onlyChecks, onlyLightweight, onlyCategories, withRedaction, withProgress, maxParallel:
+		return true
+	}
+	return false
+}
+
+// unknownCheck is why a name passed to WithOnly is skipped when no
+// check has it.
+const unknownCheck = "no such check"
+
+func unknownResult(name string) Result {
+	return Result{Name: name, Severity: SeveritySkipped, Summary: unknownCheck, Skipped: unknownCheck}
+}
+
+// selectChecks removes the pseudo-checks made by WithOnly,
+// WithOnlyLightweight, WithOnlyCategories, WithRedaction, WithProgress
+// and WithMaxParallel from checks and, if there were any, the checks
+// they exclude. It also returns the names given to WithOnly that no
+// check has, in the order given. A named check that isn't lightweight,
+// or in the categories, isn't unknown; it's just not selected.
+func selectChecks(checks []Check) (selected []Check, unknown []string) {
+	var only []string
+	var cats []Category
+	var lightweight, pseudo bool
+	for _, c := range checks {
+		switch c := c.(type) {
+		case onlyChecks:
+			only = append(only, c...)
+			pseudo = true
+		case onlyLightweight:
+			lightweight = true
+			pseudo = true
+		case onlyCategories:
+			cats = append(cats, c...)
+			pseudo = true
+		case withRedaction, withProgress, maxParallel:
+			pseudo = true
+		}
+	}
+	if !pseudo {
+		return checks, nil
+	}
+	have := make(map[string]bool)
+	for _, c := range checks {
+		if isPseudoCheck(c) {
+			continue
+		}
+		if only != nil && !slicesContains(only, c.Name()) {
+			continue
+		}
+		have[c.Name()] = true
+		if lightweight && !IsLightweight(c) {
+			continue
+		}
+		if cats != nil && !inAnyCategory(c, cats) {
+			continue
+		}
+		selected = append(selected, c)
+	}
+	for _, name := range only {
+		if !have[name] && !slicesContains(unknown, name) {
+			unknown = append(unknown, name)
+		}
+	}
+	return selected, unknown
+}
+
+// runChecks runs checks in parallel, at most maxParallel at once if
+// it's positive, and returns their results, in the same order. A check
+// that depends on others (see Dependent) waits for them, and is
+// skipped if any of them failed. If log is non-nil, each
+// check's lines are also logged to it as they're logged, prefixed with
+// the check name, as are the checks that are skipped. If rd is non-nil,
+// everything the checks log and return is redacted by it first. If
+// progress is non-nil, it's passed each check's start, once any
+// dependencies are done, its progress and its finish. Once ctx is done,
+// the checks that haven't started are skipped as not run, and those
+// running are marked CutShort when they return; runChecks waits
+// cutShortGrace for them, and then returns without those that haven't,
+// which are marked CutShort as they were.
+func runChecks(ctx context.Context, log logger.Logf, checks []Check, rd *redactor, progress func(Progress), maxParallel int) []Result {
+	res := make([]Result, len(checks))
+	var sem chan struct{} // limits checks running at once, if non-nil
+	if maxParallel > 0 {
+		sem = make(chan struct{}, maxParallel)
+	}
+	deps := dependencies(checks)
+	done := make([]chan struct{}, len(checks))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+	// mus guard res and running, so that they can be read while checks
+	// that ignore ctx are still running; see partialResults.
+	mus := make([]sync.Mutex, len(checks))
+	running := make([]bool, len(checks))
+	var returned atomic.Bool // whether runChecks returned without waiting
+	var wg sync.WaitGroup
+	wg.Add(len(checks))
+	for i, check := range checks {
+		go func(i int, r *Result, c Check) {
+			defer wg.Done()
+			defer close(done[i])
+
+			mu := &mus[i]
+			mu.Lock()
+			r.Name = c.Name()
+			mu.Unlock()
+			if progress != nil {
+				defer progress(Progress{Check: c.Name(), Finished: true})
+			}
+			skip := func(why string) {
+				mu.Lock()
+				defer mu.Unlock()
+				r.Skipped = why
+				r.Severity = SeveritySkipped
+				r.Summary = why
+				if log != nil {
+					log("check %s: skipped: %s", c.Name(), why)
+				}
+			}
+			if why := Available(c); why != "" {
+				skip(why)
+				return
+			}
+			var failed []string
+			for _, j := range deps[i] {
+				<-done[j]
+			}
+			if err := ctx.Err(); err != nil {
+				skip(notRun + ": " + err.Error())
+				return
+			}
+			for _, j := range deps[i] {
+				if dr := &res[j]; dr.Severity == SeverityError || strings.HasPrefix(dr.Skipped, dependencyFailed) {
+					failed = append(failed, dr.Name)
+				}
+			}
+			if len(failed) > 0 {
+				skip(dependencyFailed + ": " + strings.Join(failed, ", "))
+				return
+			}
+			var plog logger.Logf
+			if log != nil {
+				plog = logger.WithPrefix(log, c.Name()+": ")
+			}
+			ctx, rec := withRecorder(ctx, c.Name())
+			rec.progress = progress
+			if sem != nil {
+				select {
+				case sem <- struct{}{}:
+				case <-ctx.Done():
+					skip(notRun + ": " + ctx.Err().Error())
+					return
+				}
+				if err := ctx.Err(); err != nil {
+					<-sem // ctx was done while it waited too
+					skip(notRun + ": " + err.Error())
+					return
+				}
+			}
+			if progress != nil {
+				progress(Progress{Check: c.Name()})
+			}
+			mu.Lock()
+			running[i] = true
+			mu.Unlock()
+			start := time.Now()
+			err := c.Run(ctx, func(format string, args ...any) {
+				line := rd.redact(fmt.Sprintf(format, args...))
+				if plog != nil {
+					plog("%s", line)
+				}
+				// Checks may log from several goroutines.
+				mu.Lock()
+				defer mu.Unlock()
+				r.Log = append(r.Log, line)
+			})
+			d := time.Since(start)
+			if sem != nil {
+				<-sem
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			r.Err = err
+			for _, step := range errRemediations(err) {
+				Remediate(ctx, step)
+			}
+			rec.fill(r)
+			if err != nil {
+				if r.Severity != SeverityError {
+					r.Summary = err.Error()
+				}
+				r.Severity = SeverityError
+			}
+			if r.Severity == "" {
+				r.Severity = SeverityOK
+			}
+			if ctx.Err() != nil {
+				// What it found, or failed on, may be down to it being
+				// cut short, so it's not counted in the metrics.
+				r.CutShort = true
+				if log != nil && !returned.Load() {
+					log("check %s: cut short: %v", c.Name(), ctx.Err())
+				}
+			} else {
+				recordMetrics(r, d)
+			}
+			rd.redactResult(r)
+		}(i, &res[i], check)
+	}
+	allDone := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(allDone)
+	}()
+	select {
+	case <-allDone:
+		return res
+	case <-ctx.Done():
+	}
+	// Give the checks that heed ctx a moment to return, but don't wait
+	// on those that don't.
+	select {
+	case <-allDone:
+		return res
+	case <-time.After(cutShortGrace):
+	}
+	returned.Store(true)
+	return partialResults(ctx, log, checks, res, mus, running, done)
+}
+
+// cutShortGrace is how long runChecks waits for the checks still
+// running to return once its ctx is done, before returning without
+// them.
+const cutShortGrace = time.Second
+
+// partialResults returns a copy of res, the results of runChecks of
+// checks, for when its ctx is done and some checks haven't returned;
+// done[i] is closed once check i has. Those still running, as running
+// tells, are marked CutShort, and those that hadn't started are
+// skipped as not run. The checks go on in the background, writing to
+// res under mus.
+func partialResults(ctx context.Context, log logger.Logf, checks []Check, res []Result, mus []sync.Mutex, running []bool, done []chan struct{}) []Result {
+	ret := make([]Result, len(res))
+	for i := range res {
+		select {
+		case <-done[i]:
+			ret[i] = res[i]
+			continue
+		default:
+		}
+		mus[i].Lock()
+		r := res[i]
+		r.Log = append([]string(nil), r.Log...)
+		wasRunning := running[i]
+		mus[i].Unlock()
+		if !wasRunning {
+			name := checks[i].Name()
+			why := notRun + ": " + ctx.Err().Error()
+			ret[i] = Result{Name: name, Severity: SeveritySkipped, Summary: why, Skipped: why}
+			if log != nil {
+				log("check %s: skipped: %s", name, why)
+			}
+			continue
+		}
+		r.Err = ctx.Err()
+		r.Severity = SeverityError
+		r.Summary = "still running: " + ctx.Err().Error()
+		r.CutShort = true
+		if log != nil {
+			log("check %s: cut short: still running: %v", r.Name, ctx.Err())
+		}
+		ret[i] = r
+	}
+	return ret
+}
+
+// dependencyFailed is the start of why a check is skipped when a check
+// it depends on failed or was itself skipped for that reason.
+const dependencyFailed = "dependency failed"
+
+// dependencies returns the indexes in checks of the checks that each
+// of checks depends on (see Dependent). Names that no check has are
+// ignored, as they weren't selected or don't exist, and so are
+// dependencies that would form a cycle, so that no check waits on
+// itself.
+func dependencies(checks []Check) [][]int {
+	idx := make(map[string]int, len(checks))
+	for i, c := range checks {
+		idx[c.Name()] = i
+	}
+	deps := make([][]int, len(checks))
+	var reaches func(from, to int) bool
+	reaches = func(from, to int) bool {
+		if from == to {
+			return true
+		}
+		for _, j := range deps[from] {
+			if reaches(j, to) {
+				return true
+			}
+		}
+		return false
+	}
+	for i, c := range checks {
+		d, ok := c.(Dependent)
+		if !ok {
+			continue
+		}
+		for _, name := range d.DependsOn() {
+			if j, ok := idx[name]; ok && !reaches(j, i) {
+				deps[i] = append(deps[i], j)
+			}
+		}
+	}
+	return deps
+}
+
+var (
+	registeredMu sync.Mutex
+	registered   []Check
+)
+
+// Register adds c to the checks that RunChecks and RunChecksResults run
+// alongside those they're passed, such as by "tailscale bugreport
+// --diagnose" and the web UI's diagnostics page, with its results in the
+// same output. A check passed to them takes the place of a registered
+// one with the same name.
+//
+// It's for packages to provide checks of their own subsystem without
+// the callers of RunChecks having to know about them, and for programs
+// that embed Tailscale, such as with tsnet, to add checks specific to
+// their device. It should be called before the checks first run,
+// typically from an init function. A panic in c's Run is reported as
+// its error rather than crashing the program.
+//
+// It panics if a check with the same name was already registered.
+func Register(c Check) {
+	registeredMu.Lock()
+	defer registeredMu.Unlock()
+	for _, r := range registered {
+		if r.Name() == c.Name() {
+			panic(fmt.Sprintf("doctor: check %q registered twice", c.Name()))
+		}
+	}
+	registered = append(registered, recoverCheck{c})
+}
+
+// Registered returns the checks added by Register, in the order they
+// were added.
+func Registered() []Check {
+	registeredMu.Lock()
+	defer registeredMu.Unlock()
+	return append([]Check(nil), registered...)
+}
+
+// withRegistered returns checks followed by the registered checks
+// whose names differ from those of checks.
+func withRegistered(checks []Check) []Check {
+	reg := Registered()
+	if len(reg) == 0 {
+		return checks
+	}
+	passed := make(map[string]bool, len(checks))
+	for _, c := range checks {
+		passed[c.Name()] = true
+	}
+	ret := append([]Check(nil), checks...)
+	for _, c := range reg {
+		if !passed[c.Name()] {
+			ret = append(ret, c)
+		}
+	}
+	return ret
+}
+
+// recoverCheck is a Check that turns a panic in the Check it wraps into
+// an error.
+type recoverCheck struct {
+	Check
+}
+
+func (c recoverCheck) Run(ctx context.Context, log logger.Logf) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return c.Check.Run(ctx, log)
+}
+
+func (c recoverCheck) Platforms() Requirements {
+	if p, ok := c.Check.(Platformer); ok {
+		return p.Platforms()
+	}
+	return Requirements{}
+}
+
+func (c recoverCheck) Lightweight() bool { return IsLightweight(c.Check) }
+
+func (c recoverCheck) Categories() []Category { return CategoriesOf(c.Check) }
+
+func (c recoverCheck) DependsOn() []string {
+	if d, ok := c.Check.(Dependent); ok {
+		return d.DependsOn()
+	}
+	return nil
+}
+
+// CheckFunc creates a Check from a name and a function.
+func CheckFunc(name string, run func(context.Context, logger.Logf) error) Check {
+	return checkFunc{name: name, run: run}
+}
+
+// LightweightCheckFunc is like CheckFunc, but creates a lightweight
+// Check (see Lightweight).
+func LightweightCheckFunc(name string, run func(context.Context, logger.Logf) error) Check {
+	return checkFunc{name: name, run: run, lightweight: true}
+}
+
+type checkFunc struct {
+	name        string
+	run         func(context.Context, logger.Logf) error
+	lightweight bool
+}
+
+func (c checkFunc) Name() string                                   { return c.name }
+func (c checkFunc) Run(ctx context.Context, log logger.Logf) error { return c.run(ctx, log) }
+func (c checkFunc) Lightweight() bool                              { return c.lightweight }
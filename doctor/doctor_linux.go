@@ -0,0 +1,39 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package doctor
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// capNetAdmin is the bit of CAP_NET_ADMIN in a capability set.
+const capNetAdmin = 12
+
+// haveNetAdmin reports whether the process has CAP_NET_ADMIN in its
+// effective capability set.
+func haveNetAdmin() bool {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		// Can't tell; let the check try.
+		return true
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := s.Text()
+		if !strings.HasPrefix(line, "CapEff:") {
+			continue
+		}
+		caps, err := strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(line, "CapEff:")), 16, 64)
+		if err != nil {
+			return true
+		}
+		return caps&(1<<capNetAdmin) != 0
+	}
+	return true
+}
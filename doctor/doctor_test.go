@@ -0,0 +1,638 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package doctor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/clientmetric"
+)
+
+func TestRunChecks(t *testing.T) {
+	c := qt.New(t)
+	var (
+		mu    sync.Mutex
+		lines []string
+	)
+	logf := func(format string, args ...any) {
+		mu.Lock()
+		defer mu.Unlock()
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+
+	ctx := context.Background()
+	RunChecks(ctx, logf,
+		testCheck1{},
+		CheckFunc("testcheck2", func(_ context.Context, log logger.Logf) error {
+			log("check 2")
+			return nil
+		}),
+	)
+
+	mu.Lock()
+	defer mu.Unlock()
+	c.Assert(lines, qt.Contains, "testcheck1: check 1")
+	c.Assert(lines, qt.Contains, "testcheck2: check 2")
+}
+
+func TestRunChecksResults(t *testing.T) {
+	c := qt.New(t)
+	res := RunChecksResults(context.Background(),
+		testCheck1{},
+		CheckFunc("testcheck2", func(_ context.Context, log logger.Logf) error {
+			log("check %d", 2)
+			return errors.New("failed")
+		}),
+	)
+	c.Assert(res, qt.HasLen, 2)
+	c.Assert(res[0].Name, qt.Equals, "testcheck1")
+	c.Assert(res[0].Log, qt.DeepEquals, []string{"check 1"})
+	c.Assert(res[0].Err, qt.IsNil)
+	c.Assert(res[1].Name, qt.Equals, "testcheck2")
+	c.Assert(res[1].Log, qt.DeepEquals, []string{"check 2"})
+	c.Assert(res[1].Err, qt.ErrorMatches, "failed")
+	c.Assert(res[0].Severity, qt.Equals, SeverityOK)
+	c.Assert(res[1].Severity, qt.Equals, SeverityError)
+	c.Assert(res[1].Summary, qt.Equals, "failed")
+}
+
+func TestReport(t *testing.T) {
+	c := qt.New(t)
+	type detail struct{ Rules int }
+	var lines []string
+	res := RunChecks(context.Background(), func(format string, args ...any) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	},
+		CheckFunc("warn", func(ctx context.Context, _ logger.Logf) error {
+			Report(ctx, SeverityWarning, "3 stale rules", detail{Rules: 3})
+			Report(ctx, SeverityInfo, "ignored", nil)
+			Report(ctx, SeverityOK, "ignored", nil)
+			return nil
+		}),
+		CheckFunc("info", func(ctx context.Context, _ logger.Logf) error {
+			Report(ctx, SeverityOK, "nothing", nil)
+			Report(ctx, SeverityInfo, "knob set", nil)
+			return nil
+		}),
+		CheckFunc("fail", func(ctx context.Context, log logger.Logf) error {
+			log("looking")
+			Report(ctx, SeverityWarning, "odd", nil)
+			return errors.New("broken")
+		}),
+		nowhereCheck{},
+	)
+	c.Assert(res, qt.HasLen, 4)
+	c.Assert(res[0].Severity, qt.Equals, SeverityWarning)
+	c.Assert(res[0].Summary, qt.Equals, "3 stale rules")
+	c.Assert(res[0].Detail, qt.Equals, detail{Rules: 3})
+	c.Assert(res[1].Severity, qt.Equals, SeverityInfo)
+	c.Assert(res[1].Summary, qt.Equals, "knob set")
+	c.Assert(res[2].Severity, qt.Equals, SeverityError)
+	c.Assert(res[2].Summary, qt.Equals, "broken")
+	c.Assert(res[2].Log, qt.DeepEquals, []string{"looking"})
+	c.Assert(res[3].Severity, qt.Equals, SeveritySkipped)
+	c.Assert(lines, qt.Contains, "fail: looking")
+	c.Assert(lines, qt.Contains, "check fail: broken")
+	c.Assert(lines[len(lines)-1], qt.Equals, "summary: 1 info, 1 warning, 1 error, 1 skipped")
+}
+
+func TestRemediation(t *testing.T) {
+	c := qt.New(t)
+	var lines []string
+	res := RunChecks(context.Background(), func(format string, args ...any) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	},
+		CheckFunc("warn", func(ctx context.Context, _ logger.Logf) error {
+			Report(ctx, SeverityWarning, "rp_filter is strict", nil)
+			Remediate(ctx, "set rp_filter to 2")
+			Remediate(ctx, "set rp_filter to 2")
+			return nil
+		}),
+		CheckFunc("fail", func(ctx context.Context, _ logger.Logf) error {
+			Remediate(ctx, "enable net.ipv4.ip_forward")
+			err := WithRemediation(errors.New("blocked"), "open UDP 41641")
+			return fmt.Errorf("port: %w", WithRemediation(err, "check the egress firewall"))
+		}),
+		CheckFunc("pass", func(ctx context.Context, _ logger.Logf) error {
+			return WithRemediation(nil, "nothing to do")
+		}),
+	)
+	c.Assert(res, qt.HasLen, 3)
+	c.Assert(res[0].Remediation, qt.DeepEquals, []string{"set rp_filter to 2"})
+	c.Assert(res[1].Err, qt.ErrorMatches, "port: blocked")
+	c.Assert(res[1].Remediation, qt.DeepEquals, []string{"enable net.ipv4.ip_forward", "check the egress firewall", "open UDP 41641"})
+	c.Assert(res[2].Err, qt.IsNil)
+	c.Assert(res[2].Remediation, qt.IsNil)
+	c.Assert(lines, qt.Contains, "check fail: to fix: open UDP 41641")
+}
+
+func TestWithOnly(t *testing.T) {
+	c := qt.New(t)
+	var lines []string
+	ran := make(chan string, 3)
+	check := func(name string) Check {
+		return CheckFunc(name, func(context.Context, logger.Logf) error {
+			ran <- name
+			return nil
+		})
+	}
+	res := RunChecks(context.Background(), func(format string, args ...any) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}, check("routetable"), check("dns"), check("derp"), WithOnly("dns", "nope"), WithOnly("routetable"))
+	close(ran)
+	var names []string
+	for name := range ran {
+		names = append(names, name)
+	}
+	c.Assert(names, qt.HasLen, 2)
+	c.Assert(names, qt.Not(qt.Contains), "derp")
+	c.Assert(res, qt.HasLen, 3)
+	c.Assert(res[0].Name, qt.Equals, "routetable")
+	c.Assert(res[1].Name, qt.Equals, "dns")
+	c.Assert(res[2].Name, qt.Equals, "nope")
+	c.Assert(res[2].Severity, qt.Equals, SeveritySkipped)
+	c.Assert(lines, qt.DeepEquals, []string{"check nope: skipped: no such check", "summary: 2 ok, 1 skipped"})
+}
+
+func TestWithOnlyLightweight(t *testing.T) {
+	c := qt.New(t)
+	run := func(context.Context, logger.Logf) error { return nil }
+	checks := []Check{
+		CheckFunc("derp", run),
+		LightweightCheckFunc("mtu", run),
+		recoverCheck{LightweightCheckFunc("rpfilter", run)},
+		LightweightCheckFunc("routetable", run),
+	}
+	names := func(res []Result) (ret []string) {
+		for _, r := range res {
+			ret = append(ret, r.Name)
+		}
+		return ret
+	}
+	res := RunChecksResults(context.Background(), append(checks, WithOnlyLightweight())...)
+	c.Assert(names(res), qt.DeepEquals, []string{"mtu", "rpfilter", "routetable"})
+
+	// A named check that isn't lightweight is left out, not unknown.
+	res = RunChecksResults(context.Background(), append(checks, WithOnlyLightweight(), WithOnly("derp", "mtu"))...)
+	c.Assert(names(res), qt.DeepEquals, []string{"mtu"})
+}
+
+func TestWithOnlyCategories(t *testing.T) {
+	c := qt.New(t)
+	run := func(context.Context, logger.Logf) error { return nil }
+	checks := []Check{
+		InCategory(CheckFunc("derp", run), CategoryNetwork, CategoryPerformance),
+		InCategory(LightweightCheckFunc("dns-manager", run), CategoryDNS, CategoryPlatform),
+		recoverCheck{InCategory(LightweightCheckFunc("rpfilter", run), CategoryFirewall)},
+		CheckFunc("uncategorized", run),
+	}
+	names := func(res []Result) (ret []string) {
+		for _, r := range res {
+			ret = append(ret, r.Name)
+		}
+		return ret
+	}
+	res := RunChecksResults(context.Background(), append(checks, WithOnlyCategories(CategoryFirewall))...)
+	c.Assert(names(res), qt.DeepEquals, []string{"rpfilter"})
+
+	res = RunChecksResults(context.Background(), append(checks, WithOnlyCategories(CategoryPerformance), WithOnlyCategories(CategoryDNS))...)
+	c.Assert(names(res), qt.DeepEquals, []string{"derp", "dns-manager"})
+
+	// The other selections apply too; a named check that isn't in the
+	// categories is left out, not unknown.
+	res = RunChecksResults(context.Background(), append(checks, WithOnlyCategories(CategoryNetwork, CategoryDNS), WithOnlyLightweight())...)
+	c.Assert(names(res), qt.DeepEquals, []string{"dns-manager"})
+	res = RunChecksResults(context.Background(), append(checks, WithOnlyCategories(CategoryDNS), WithOnly("derp", "dns-manager"))...)
+	c.Assert(names(res), qt.DeepEquals, []string{"dns-manager"})
+}
+
+func TestParseCategory(t *testing.T) {
+	c := qt.New(t)
+	for _, cat := range Categories {
+		got, err := ParseCategory(string(cat))
+		c.Assert(err, qt.IsNil)
+		c.Assert(got, qt.Equals, cat)
+	}
+	_, err := ParseCategory("dsn")
+	c.Assert(err, qt.ErrorMatches, `unknown check category "dsn"; want one of network, dns, firewall, platform, performance, external`)
+}
+
+func TestRedaction(t *testing.T) {
+	c := qt.New(t)
+	var lines []string
+	check := CheckFunc("routes", func(ctx context.Context, logf logger.Logf) error {
+		logf("10.0.0.0/8 via 192.168.1.1 dev eth0 (aa:bb:cc:dd:ee:ff)")
+		logf("fd7a:115c:a1e0::1/128 via fe80::1%%eth0; lo 127.0.0.1")
+		Report(ctx, SeverityWarning, "gateway 192.168.1.1 is unreachable", map[string]string{"Gateway": "192.168.1.1"})
+		Remediate(ctx, "ping 192.168.1.1")
+		return errors.New("no route to 100.101.102.103")
+	})
+	r, err := ParseRedaction("all,prefixes")
+	c.Assert(err, qt.IsNil)
+	res := RunChecks(context.Background(), func(format string, args ...any) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}, check, WithRedaction(r))
+	c.Assert(res, qt.HasLen, 1)
+
+	gw := regexp.MustCompile(`ip4:[0-9a-f]{8}`).FindString(res[0].Log[0])
+	c.Assert(gw, qt.Not(qt.Equals), "")
+	c.Assert(res[0].Log, qt.HasLen, 2)
+	c.Assert(res[0].Log[0], qt.Equals, "10.0.0.0/8 via "+gw+" dev eth0 (xx:xx:xx:xx:xx:xx)")
+	c.Assert(res[0].Log[1], qt.Matches, `ip6:[0-9a-f]{8}/128 via fe80::1%eth0; lo 127\.0\.0\.1`)
+	c.Assert(res[0].Summary, qt.Matches, `no route to ip4:[0-9a-f]{8}`)
+	c.Assert(res[0].Remediation, qt.DeepEquals, []string{"ping " + gw})
+	c.Assert(string(res[0].Detail.(json.RawMessage)), qt.Equals, `{"Gateway":"`+gw+`"}`)
+	for _, line := range lines {
+		c.Assert(line, qt.Not(qt.Contains), "192.168.1.1")
+		c.Assert(line, qt.Not(qt.Contains), "100.101.102.103")
+	}
+	c.Assert(lines, qt.Contains, "routes: 10.0.0.0/8 via "+gw+" dev eth0 (xx:xx:xx:xx:xx:xx)")
+
+	// Without redaction, nothing changes.
+	res = RunChecksResults(context.Background(), check, WithRedaction(Redaction{}))
+	c.Assert(res[0].Err, qt.ErrorMatches, "no route to 100.101.102.103")
+	c.Assert(res[0].Detail, qt.DeepEquals, map[string]string{"Gateway": "192.168.1.1"})
+
+	_, err = ParseRedaction("prefixes")
+	c.Assert(err, qt.IsNotNil)
+	_, err = ParseRedaction("ips,hostnames")
+	c.Assert(err, qt.IsNotNil)
+	c.Assert(r.String(), qt.Equals, "ips,macs,prefixes")
+}
+
+func TestProgress(t *testing.T) {
+	c := qt.New(t)
+	var got []Progress // calls are serialized
+	check := CheckFunc("derp", func(ctx context.Context, logf logger.Logf) error {
+		ReportProgress(ctx, 0, 2, "probing 192.0.2.1")
+		ReportProgress(ctx, 1, 2, "probing 192.0.2.2")
+		return nil
+	})
+	r, err := ParseRedaction("ips")
+	c.Assert(err, qt.IsNil)
+	RunChecksResults(context.Background(), check, nowhereCheck{}, WithRedaction(r), WithProgress(func(p Progress) {
+		got = append(got, p)
+	}))
+
+	byCheck := map[string][]Progress{}
+	for _, p := range got {
+		byCheck[p.Check] = append(byCheck[p.Check], p)
+	}
+	c.Assert(byCheck["nowhere"], qt.DeepEquals, []Progress{{Check: "nowhere", Finished: true}})
+	derp := byCheck["derp"]
+	c.Assert(derp, qt.HasLen, 4)
+	c.Assert(derp[0], qt.DeepEquals, Progress{Check: "derp"})
+	c.Assert(derp[1].Done, qt.Equals, 0)
+	c.Assert(derp[1].Total, qt.Equals, 2)
+	c.Assert(derp[1].What, qt.Matches, `probing ip4:[0-9a-f]{8}`)
+	c.Assert(derp[2].Done, qt.Equals, 1)
+	c.Assert(derp[3], qt.DeepEquals, Progress{Check: "derp", Finished: true})
+
+	// Outside of RunChecks, and without WithProgress, it does nothing.
+	ReportProgress(context.Background(), 1, 2, "nothing")
+	RunChecksResults(context.Background(), check)
+}
+
+type testCheck1 struct{}
+
+func (t testCheck1) Name() string { return "testcheck1" }
+func (t testCheck1) Run(_ context.Context, log logger.Logf) error {
+	log("check 1")
+	return nil
+}
+
+func TestRegister(t *testing.T) {
+	c := qt.New(t)
+	defer func(old []Check) { registered = old }(registered)
+	registered = nil
+
+	Register(CheckFunc("vendor-check", func(_ context.Context, log logger.Logf) error {
+		log("fan ok")
+		return nil
+	}))
+	Register(CheckFunc("vendor-panic", func(context.Context, logger.Logf) error {
+		panic("boom")
+	}))
+	c.Assert(func() { Register(CheckFunc("vendor-check", nil)) }, qt.PanicMatches, `doctor: check "vendor-check" registered twice`)
+
+	res := RunChecksResults(context.Background())
+	c.Assert(res, qt.HasLen, 2)
+	c.Assert(res[0].Name, qt.Equals, "vendor-check")
+	c.Assert(res[0].Log, qt.DeepEquals, []string{"fan ok"})
+	c.Assert(res[0].Err, qt.IsNil)
+	c.Assert(res[1].Name, qt.Equals, "vendor-panic")
+	c.Assert(res[1].Err, qt.ErrorMatches, "panic: boom")
+
+	// A passed check replaces a registered one of the same name.
+	res = RunChecksResults(context.Background(), CheckFunc("vendor-panic", func(context.Context, logger.Logf) error {
+		return nil
+	}))
+	c.Assert(res, qt.HasLen, 2)
+	c.Assert(res[0].Name, qt.Equals, "vendor-panic")
+	c.Assert(res[0].Err, qt.IsNil)
+	c.Assert(res[1].Name, qt.Equals, "vendor-check")
+}
+
+func TestRequirements(t *testing.T) {
+	tests := []struct {
+		r        Requirements
+		goos     string
+		root     bool
+		netAdmin bool
+		want     string
+	}{
+		{Requirements{}, "linux", false, false, ""},
+		{Requirements{OS: []string{"linux"}}, "linux", false, false, ""},
+		{Requirements{OS: []string{"linux", "windows"}}, "darwin", true, true, "requires linux or windows"},
+		{Requirements{Root: true}, "darwin", false, false, "requires root"},
+		{Requirements{Root: true}, "darwin", true, false, ""},
+		{Requirements{Root: true}, "windows", false, false, ""},
+		{Requirements{NetAdmin: true}, "linux", false, false, "requires CAP_NET_ADMIN"},
+		{Requirements{NetAdmin: true}, "linux", false, true, ""},
+		{Requirements{NetAdmin: true}, "darwin", false, false, ""},
+	}
+	for _, tt := range tests {
+		if got := tt.r.unmet(tt.goos, tt.root, tt.netAdmin); got != tt.want {
+			t.Errorf("%+v.unmet(%q, root=%v, netAdmin=%v) = %q; want %q", tt.r, tt.goos, tt.root, tt.netAdmin, got, tt.want)
+		}
+	}
+}
+
+// nowhereCheck is a Check that can't run on any OS.
+type nowhereCheck struct{ testCheck1 }
+
+func (nowhereCheck) Name() string { return "nowhere" }
+func (nowhereCheck) Platforms() Requirements {
+	return Requirements{OS: []string{"plan10"}}
+}
+
+func TestRunChecksSkipped(t *testing.T) {
+	c := qt.New(t)
+	res := RunChecksResults(context.Background(), nowhereCheck{}, recoverCheck{nowhereCheck{}})
+	for _, r := range res {
+		c.Assert(r.Skipped, qt.Equals, "requires plan10")
+		c.Assert(r.Log, qt.IsNil)
+	}
+
+	var lines []string
+	RunChecks(context.Background(), func(format string, args ...any) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}, nowhereCheck{})
+	c.Assert(lines, qt.DeepEquals, []string{"check nowhere: skipped: requires plan10", "summary: 1 skipped"})
+}
+
+type dependentCheck struct {
+	Check
+	deps []string
+}
+
+func (c dependentCheck) DependsOn() []string { return c.deps }
+
+func TestDependsOn(t *testing.T) {
+	c := qt.New(t)
+	var mu sync.Mutex
+	var ran []string
+	check := func(name string, err error, deps ...string) Check {
+		return dependentCheck{CheckFunc(name, func(context.Context, logger.Logf) error {
+			mu.Lock()
+			defer mu.Unlock()
+			ran = append(ran, name)
+			return err
+		}), deps}
+	}
+	res := RunChecksResults(context.Background(),
+		check("dep-transitive", nil, "dep-dependent"),
+		check("dep-dependent", nil, "dep-fails", "dep-passes"),
+		check("dep-fails", errors.New("failed")),
+		check("dep-passes", nil),
+		check("dep-after-pass", nil, "dep-passes", "no-such-check"),
+		check("dep-cycle-1", nil, "dep-cycle-2"),
+		check("dep-cycle-2", nil, "dep-cycle-1"),
+	)
+	c.Assert(res, qt.HasLen, 7)
+	c.Assert(res[0].Skipped, qt.Equals, "dependency failed: dep-dependent")
+	c.Assert(res[1].Skipped, qt.Equals, "dependency failed: dep-fails")
+	c.Assert(res[1].Severity, qt.Equals, SeveritySkipped)
+	for _, r := range res[2:] {
+		c.Assert(r.Skipped, qt.Equals, "", qt.Commentf("%s", r.Name))
+	}
+	mu.Lock()
+	c.Assert(ran, qt.HasLen, 5)
+	c.Assert(indexOf(ran, "dep-after-pass") > indexOf(ran, "dep-passes"), qt.IsTrue)
+	mu.Unlock()
+
+	var lines []string
+	RunChecks(context.Background(), func(format string, args ...any) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}, check("dep-fails", errors.New("failed")), recoverCheck{check("dep-dependent", nil, "dep-fails")})
+	c.Assert(lines, qt.Contains, "check dep-dependent: skipped: dependency failed: dep-fails")
+}
+
+func indexOf(s []string, v string) int {
+	for i, e := range s {
+		if e == v {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestWithMaxParallel(t *testing.T) {
+	c := qt.New(t)
+	var mu sync.Mutex
+	var running, most int
+	var ran []string
+	check := func(name string, deps ...string) Check {
+		return dependentCheck{CheckFunc(name, func(context.Context, logger.Logf) error {
+			mu.Lock()
+			running++
+			if running > most {
+				most = running
+			}
+			ran = append(ran, name)
+			mu.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+			return nil
+		}), deps}
+	}
+	checks := func() []Check {
+		return []Check{
+			check("par-dependent", "par-4"),
+			check("par-1"),
+			check("par-2"),
+			check("par-3"),
+			check("par-4"),
+		}
+	}
+
+	for _, limit := range []int{1, 2} {
+		most, ran = 0, nil
+		res := RunChecksResults(context.Background(), append(checks(), WithMaxParallel(limit+1), WithMaxParallel(limit))...)
+		c.Assert(res, qt.HasLen, 5)
+		c.Assert(most, qt.Equals, limit)
+		c.Assert(ran, qt.HasLen, 5)
+		c.Assert(indexOf(ran, "par-dependent") > indexOf(ran, "par-4"), qt.IsTrue)
+	}
+}
+
+func TestInterrupted(t *testing.T) {
+	c := qt.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var finished, started bool
+	progress := WithProgress(func(p Progress) {
+		// Cancel once int-done is finished and int-slow running.
+		switch {
+		case p.Check == "int-done" && p.Finished:
+			finished = true
+		case p.Check == "int-slow" && !p.Finished:
+			started = true
+		}
+		if finished && started {
+			cancel()
+		}
+	})
+	slow := CheckFunc("int-slow", func(ctx context.Context, logf logger.Logf) error {
+		logf("waiting")
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	done := CheckFunc("int-done", func(context.Context, logger.Logf) error { return nil })
+	dependent := dependentCheck{CheckFunc("int-dependent", func(context.Context, logger.Logf) error { return nil }), []string{"int-slow"}}
+
+	var mu sync.Mutex
+	var lines []string
+	res := RunChecks(ctx, func(format string, args ...any) {
+		mu.Lock()
+		defer mu.Unlock()
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}, slow, done, dependent, progress)
+	c.Assert(res, qt.HasLen, 3)
+	c.Assert(res[0].CutShort, qt.IsTrue)
+	c.Assert(res[0].Log, qt.DeepEquals, []string{"waiting"})
+	c.Assert(res[1].CutShort, qt.IsFalse)
+	c.Assert(res[1].Severity, qt.Equals, SeverityOK)
+	c.Assert(res[2].Skipped, qt.Equals, "not run: context canceled")
+	c.Assert(res[2].CutShort, qt.IsFalse)
+
+	notRun, cutShort := Interrupted(res)
+	c.Assert(notRun, qt.DeepEquals, []string{"int-dependent"})
+	c.Assert(cutShort, qt.DeepEquals, []string{"int-slow"})
+	c.Assert(lines, qt.Contains, "check int-slow: cut short: context canceled")
+	c.Assert(lines, qt.Contains, "check int-dependent: skipped: not run: context canceled")
+	c.Assert(lines[len(lines)-3:], qt.DeepEquals, []string{
+		"interrupted: not run: int-dependent",
+		"interrupted: cut short: int-slow",
+		"summary: 1 ok, 1 error, 1 skipped",
+	})
+
+	// No checks start once ctx is done.
+	res = RunChecksResults(ctx, done, slow)
+	notRun, cutShort = Interrupted(res)
+	c.Assert(notRun, qt.DeepEquals, []string{"int-done", "int-slow"})
+	c.Assert(cutShort, qt.HasLen, 0)
+}
+
+func TestInterruptedIgnoresContext(t *testing.T) {
+	c := qt.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	release := make(chan struct{})
+	defer close(release)
+	stuck := CheckFunc("int-stuck", func(ctx context.Context, logf logger.Logf) error {
+		logf("waiting")
+		cancel()
+		<-release
+		return nil
+	})
+	dependent := dependentCheck{CheckFunc("int-stuck-dependent", func(context.Context, logger.Logf) error { return nil }), []string{"int-stuck"}}
+
+	start := time.Now()
+	res := RunChecksResults(ctx, stuck, dependent)
+	c.Assert(time.Since(start) < 10*time.Second, qt.IsTrue)
+	c.Assert(res, qt.HasLen, 2)
+	c.Assert(res[0].Name, qt.Equals, "int-stuck")
+	c.Assert(res[0].CutShort, qt.IsTrue)
+	c.Assert(res[0].Severity, qt.Equals, SeverityError)
+	c.Assert(res[0].Log, qt.DeepEquals, []string{"waiting"})
+	c.Assert(res[1].Name, qt.Equals, "int-stuck-dependent")
+	c.Assert(res[1].Skipped, qt.Equals, "not run: context canceled")
+
+	notRun, cutShort := Interrupted(res)
+	c.Assert(notRun, qt.DeepEquals, []string{"int-stuck-dependent"})
+	c.Assert(cutShort, qt.DeepEquals, []string{"int-stuck"})
+}
+
+func TestCheckMetrics(t *testing.T) {
+	c := qt.New(t)
+	value := func(name string) int64 {
+		for _, m := range clientmetric.Metrics() {
+			if m.Name() == name {
+				return m.Value()
+			}
+		}
+		return -1
+	}
+	pass := CheckFunc("metrics-pass", func(context.Context, logger.Logf) error { return nil })
+	fail := CheckFunc("metrics-fail", func(context.Context, logger.Logf) error { return errors.New("failed") })
+	RunChecksResults(context.Background(), pass, fail)
+	RunChecksResults(context.Background(), pass, nowhereCheck{})
+	RunChecksResults(context.Background(),
+		CheckFunc(ExternalCheckPrefix+"corp-proxy", func(context.Context, logger.Logf) error { return nil }),
+		CheckFunc(ExternalCheckPrefix+"corp-dns", func(context.Context, logger.Logf) error { return errors.New("failed") }))
+
+	c.Assert(value("doctor_check_metrics_pass_pass"), qt.Equals, int64(2))
+	c.Assert(value("doctor_check_metrics_pass_fail"), qt.Equals, int64(0))
+	c.Assert(value("doctor_check_metrics_fail_pass"), qt.Equals, int64(0))
+	c.Assert(value("doctor_check_metrics_fail_fail"), qt.Equals, int64(1))
+	c.Assert(value("doctor_check_metrics_fail_ms"), qt.Not(qt.Equals), int64(-1))
+	// Skipped checks didn't run, so have no metrics.
+	c.Assert(value("doctor_check_nowhere_pass"), qt.Equals, int64(-1))
+	// External checks share theirs.
+	c.Assert(value("doctor_check_ext_pass"), qt.Equals, int64(1))
+	c.Assert(value("doctor_check_ext_fail"), qt.Equals, int64(1))
+	c.Assert(value("doctor_check_ext_corp_proxy_pass"), qt.Equals, int64(-1))
+}
+
+func TestFingerprints(t *testing.T) {
+	c := qt.New(t)
+	res := RunChecksResults(context.Background(),
+		CheckFunc("rp", func(ctx context.Context, _ logger.Logf) error {
+			AddFinding(ctx, "rp_filter", "strict")
+			return nil
+		}),
+		CheckFunc("routes", func(ctx context.Context, _ logger.Logf) error {
+			AddFinding(ctx, "routes", "asymmetric")
+			return nil
+		}),
+	)
+	c.Assert(res[0].Findings, qt.DeepEquals, []Finding{{Check: "rp", Key: "rp_filter", Value: "strict"}})
+	fps := ResultFingerprints(res)
+	c.Assert(fps, qt.HasLen, 1)
+	c.Assert(fps[0].Name, qt.Equals, "rp_filter strict + asymmetric routes")
+
+	c.Assert(MatchFingerprints([]Finding{{Key: "rp_filter", Value: "loose"}, {Key: "routes", Value: "asymmetric"}}), qt.HasLen, 0)
+
+	var lines []string
+	RunChecks(context.Background(), func(format string, args ...any) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}, CheckFunc("firewall", func(ctx context.Context, _ logger.Logf) error {
+		AddFinding(ctx, "firewall-backends", "mixed")
+		return nil
+	}))
+	c.Assert(lines, qt.Contains, "known issue: firewall rules split across iptables backends; see https://tailscale.com/kb/1023/troubleshooting")
+}
@@ -0,0 +1,313 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package external provides doctor.Checks defined by the site: an
+// executable in a directory is run as a check, so that environment
+// specific diagnostics, such as reaching an internal proxy, show up in
+// the doctor report along with the built-in ones.
+//
+// An external check is run with no arguments and must write a JSON
+// Output to its standard output. What it writes to standard error is
+// logged. It fails if it exits with a non-zero status without writing
+// an Output, writes something else, or runs for longer than Timeout.
+//
+// As tailscaled runs checks with its own privileges, a check is only
+// run if no one but root, or the user tailscaled runs as, can change it
+// or the directories above it. On Windows, that's LocalSystem,
+// Administrators and TrustedInstaller.
+package external
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/doctor"
+	"tailscale.com/types/logger"
+)
+
+// Timeout is how long an external check may run.
+const Timeout = 30 * time.Second
+
+// maxOutput is the most an external check may write to its standard
+// output or error. Beyond it, standard output is invalid and standard
+// error is cut.
+const maxOutput = 1 << 20
+
+// Output is the result of an external check, as the JSON it writes to
+// its standard output.
+type Output struct {
+	// Severity is the outcome: "ok", "info", "warning" or "error".
+	// Empty means "ok".
+	Severity doctor.Severity `json:",omitempty"`
+
+	// Summary is a one-line description of the outcome.
+	Summary string `json:",omitempty"`
+
+	// Detail is structured data about the outcome, if any.
+	Detail json.RawMessage `json:",omitempty"`
+
+	// Log are lines to log, such as what the check looked at.
+	Log []string `json:",omitempty"`
+
+	// Remediation are actionable steps to fix what the check found,
+	// in order.
+	Remediation []string `json:",omitempty"`
+}
+
+// Checks returns a check for each executable in dir, in the order of
+// their names. If dir can't be read, it returns a check that fails
+// with why, so that a misconfigured directory shows up in the report.
+func Checks(dir string) []doctor.Check {
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		return []doctor.Check{doctor.InCategory(doctor.CheckFunc("external-checks", func(context.Context, logger.Logf) error {
+			return err
+		}), doctor.CategoryExternal)}
+	}
+	var ret []doctor.Check
+	for _, ent := range ents {
+		path := filepath.Join(dir, ent.Name())
+		fi, err := os.Stat(path) // follow symlinks
+		if err != nil || !fi.Mode().IsRegular() || !isExecutable(fi) {
+			continue
+		}
+		ret = append(ret, Check{Path: path})
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name() < ret[j].Name() })
+	return ret
+}
+
+// isExecutable reports whether the file fi describes can be run as a
+// check.
+func isExecutable(fi os.FileInfo) bool {
+	if runtime.GOOS == "windows" {
+		switch strings.ToLower(filepath.Ext(fi.Name())) {
+		case ".exe", ".bat", ".cmd":
+			return true
+		}
+		return false
+	}
+	return fi.Mode().Perm()&0111 != 0
+}
+
+// Check is a doctor.Check that runs an executable.
+type Check struct {
+	// Path is the path of the executable.
+	Path string
+}
+
+// Name returns "ext-" followed by the base name of the executable,
+// without its extension, in lower-kebab-case, such as "ext-corp-proxy"
+// for "corp_proxy.sh".
+func (c Check) Name() string {
+	base := filepath.Base(c.Path)
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return '-'
+	}, base)
+	return doctor.ExternalCheckPrefix + strings.Trim(name, "-")
+}
+
+// Categories implements doctor.Categorized: all external checks are in
+// doctor.CategoryExternal, whatever they look into.
+func (Check) Categories() []doctor.Category {
+	return []doctor.Category{doctor.CategoryExternal}
+}
+
+func (c Check) Run(ctx context.Context, logf logger.Logf) error {
+	f, err := openChecked(c.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+	stdout, stderr, runErr := run(ctx, command(f))
+	for _, line := range strings.Split(strings.TrimSpace(string(stderr)), "\n") {
+		if line != "" {
+			logf("stderr: %s", line)
+		}
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %v", Timeout)
+	}
+
+	out, err := parseOutput(stdout)
+	if err != nil {
+		if runErr != nil {
+			return runErr
+		}
+		return err
+	}
+	for _, line := range out.Log {
+		logf("%s", line)
+	}
+	for _, step := range out.Remediation {
+		doctor.Remediate(ctx, step)
+	}
+	var detail any
+	if len(out.Detail) > 0 {
+		detail = out.Detail
+	}
+	if out.Severity == doctor.SeverityError {
+		doctor.Report(ctx, out.Severity, out.Summary, detail)
+		if out.Summary == "" {
+			return errors.New("check failed")
+		}
+		return errors.New(out.Summary)
+	}
+	if out.Summary != "" || detail != nil {
+		doctor.Report(ctx, out.Severity, out.Summary, detail)
+	}
+	return nil
+}
+
+// drainTimeout is how long run waits, once an external check has
+// exited, for whatever it started to close its standard output and
+// error.
+const drainTimeout = time.Second
+
+// run runs cmd and returns what it wrote to its standard output and
+// error. It returns once cmd has exited, or been killed because ctx is
+// done, even if something cmd started still holds its standard output
+// or error open, which would make exec.Cmd.Wait wait for it.
+func run(ctx context.Context, cmd *exec.Cmd) (stdout, stderr []byte, err error) {
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer outR.Close()
+	errR, errW, err := os.Pipe()
+	if err != nil {
+		outW.Close()
+		return nil, nil, err
+	}
+	defer errR.Close()
+	cmd.Stdout = outW
+	cmd.Stderr = errW
+	err = cmd.Start()
+	outW.Close()
+	errW.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		mu             sync.Mutex // guards outBuf and errBuf
+		outBuf, errBuf bytes.Buffer
+	)
+	copied := make(chan bool, 2)
+	for _, p := range []struct {
+		r   *os.File
+		buf *bytes.Buffer
+	}{{outR, &outBuf}, {errR, &errBuf}} {
+		w := &limitedWriter{w: &lockedWriter{mu: &mu, w: p.buf}, n: maxOutput}
+		go func(r *os.File) {
+			io.Copy(w, r)
+			copied <- true
+		}(p.r)
+	}
+
+	waited := make(chan error, 1)
+	go func() { waited <- cmd.Wait() }()
+	select {
+	case err = <-waited:
+	case <-ctx.Done():
+		killProcessGroup(cmd.Process)
+		err = <-waited
+	}
+
+	drain := time.NewTimer(drainTimeout)
+	defer drain.Stop()
+	for n := 0; n < 2; n++ {
+		select {
+		case <-copied:
+		case <-drain.C:
+			// Something the check started outlived it and
+			// holds on to its output. It goes too.
+			killProcessGroup(cmd.Process)
+			n = 2
+		}
+	}
+	outR.Close()
+	errR.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	return append([]byte(nil), outBuf.Bytes()...), append([]byte(nil), errBuf.Bytes()...), err
+}
+
+// parseOutput parses the standard output of an external check.
+func parseOutput(b []byte) (*Output, error) {
+	if len(bytes.TrimSpace(b)) == 0 {
+		return nil, errors.New("no output")
+	}
+	out := new(Output)
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	if err := d.Decode(out); err != nil {
+		return nil, fmt.Errorf("invalid output: %w", err)
+	}
+	if d.More() {
+		return nil, errors.New("invalid output: more than one JSON value")
+	}
+	switch out.Severity {
+	case "":
+		out.Severity = doctor.SeverityOK
+	case doctor.SeverityOK, doctor.SeverityInfo, doctor.SeverityWarning, doctor.SeverityError:
+	default:
+		return nil, fmt.Errorf("invalid output: unknown severity %q", out.Severity)
+	}
+	return out, nil
+}
+
+// limitedWriter is an io.Writer that passes on at most n bytes to w
+// and discards the rest, so that a runaway check can't use up memory.
+type limitedWriter struct {
+	w io.Writer
+	n int
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if len(p) > lw.n {
+		p = p[:lw.n]
+	}
+	lw.n -= len(p)
+	if _, err := lw.w.Write(p); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// lockedWriter is an io.Writer that writes to w with mu held.
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (lw *lockedWriter) Write(p []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	return lw.w.Write(p)
+}
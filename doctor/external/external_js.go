@@ -0,0 +1,19 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package external
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+func openChecked(path string) (*os.File, error) {
+	return nil, errors.New("external checks not supported on js")
+}
+
+func command(f *os.File) *exec.Cmd { panic("unreachable") }
+
+func killProcessGroup(p *os.Process) {}
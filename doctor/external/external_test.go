@@ -0,0 +1,154 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package external
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/doctor"
+)
+
+func TestName(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/etc/tailscale/doctor.d/corp_proxy.sh", "ext-corp-proxy"},
+		{"/etc/tailscale/doctor.d/VPN Split.exe", "ext-vpn-split"},
+		{"/etc/tailscale/doctor.d/-dns-", "ext-dns"},
+	}
+	for _, tt := range tests {
+		if got := (Check{Path: tt.path}).Name(); got != tt.want {
+			t.Errorf("Name of %q = %q; want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestParseOutput(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    *Output
+		wantErr string
+	}{
+		{in: `{}`, want: &Output{Severity: doctor.SeverityOK}},
+		{
+			in:   `{"Severity":"warning","Summary":"proxy slow","Log":["took 3s"],"Remediation":["restart the proxy"]}`,
+			want: &Output{Severity: doctor.SeverityWarning, Summary: "proxy slow", Log: []string{"took 3s"}, Remediation: []string{"restart the proxy"}},
+		},
+		{in: "", wantErr: "no output"},
+		{in: "all good", wantErr: "invalid output"},
+		{in: `{"Status":"ok"}`, wantErr: "invalid output"},
+		{in: `{} {}`, wantErr: "more than one JSON value"},
+		{in: `{"Severity":"skipped"}`, wantErr: `unknown severity "skipped"`},
+	}
+	for _, tt := range tests {
+		got, err := parseOutput([]byte(tt.in))
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseOutput(%q) error = %v; want %q", tt.in, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseOutput(%q): %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseOutput(%q) = %+v; want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestChecks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses shell scripts")
+	}
+	dir := t.TempDir()
+	script := func(name, body string, perm os.FileMode) {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), perm); err != nil {
+			t.Fatal(err)
+		}
+		// Not subject to the umask.
+		if err := os.Chmod(path, perm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	script("proxy.sh", `echo "checking" >&2; echo '{"Severity":"warning","Summary":"proxy slow","Detail":{"ms":3000},"Remediation":["restart the proxy"]}'`, 0755)
+	script("fails", `echo '{"Severity":"error","Summary":"no route to corp"}'`, 0755)
+	script("exits", `echo "boom" >&2; exit 3`, 0755)
+	script("writable", `echo '{}'`, 0775)
+	script("not-executable", `echo '{}'`, 0644)
+	script("leaves-child", `sleep 60 & echo '{"Summary":"started"}'`, 0755)
+
+	checks := Checks(dir)
+	var names []string
+	for _, c := range checks {
+		names = append(names, c.Name())
+	}
+	if want := []string{"ext-exits", "ext-fails", "ext-leaves-child", "ext-proxy", "ext-writable"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("checks = %q; want %q", names, want)
+	}
+	for _, c := range append(checks, Checks(filepath.Join(dir, "missing"))...) {
+		if cats := doctor.CategoriesOf(c); !reflect.DeepEqual(cats, []doctor.Category{doctor.CategoryExternal}) {
+			t.Errorf("%s categories = %q; want external", c.Name(), cats)
+		}
+	}
+
+	start := time.Now()
+	res := doctor.RunChecksResults(context.Background(), checks...)
+	if d := time.Since(start); d > 10*time.Second {
+		t.Errorf("checks took %v; something left running held them up", d)
+	}
+	byName := map[string]doctor.Result{}
+	for _, r := range res {
+		byName[r.Name] = r
+	}
+	if r := byName["ext-proxy"]; r.Severity != doctor.SeverityWarning || r.Summary != "proxy slow" ||
+		!reflect.DeepEqual(r.Remediation, []string{"restart the proxy"}) || !reflect.DeepEqual(r.Log, []string{"stderr: checking"}) {
+		t.Errorf("ext-proxy: %+v", r)
+	}
+	if r := byName["ext-fails"]; r.Severity != doctor.SeverityError || r.Summary != "no route to corp" {
+		t.Errorf("ext-fails: %+v", r)
+	}
+	if r := byName["ext-exits"]; r.Severity != doctor.SeverityError || r.Summary != "exit status 3" || !reflect.DeepEqual(r.Log, []string{"stderr: boom"}) {
+		t.Errorf("ext-exits: %+v", r)
+	}
+	if r := byName["ext-writable"]; r.Severity != doctor.SeverityError || len(r.Remediation) != 1 || !strings.HasPrefix(r.Remediation[0], "chmod go-w ") {
+		t.Errorf("ext-writable: %+v", r)
+	}
+
+	if r := byName["ext-leaves-child"]; r.Severity != doctor.SeverityOK || r.Summary != "started" {
+		t.Errorf("ext-leaves-child: %+v", r)
+	}
+
+	// A check in a directory others can write to isn't run.
+	open := filepath.Join(dir, "open")
+	if err := os.Mkdir(open, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(open, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(open, "check"), []byte("#!/bin/sh\necho '{}'\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	res = doctor.RunChecksResults(context.Background(), Checks(open)...)
+	if len(res) != 1 || res[0].Severity != doctor.SeverityError || !reflect.DeepEqual(res[0].Remediation, []string{"chmod go-w " + open}) {
+		t.Errorf("check in writable directory: %+v", res)
+	}
+
+	res = doctor.RunChecksResults(context.Background(), Checks(filepath.Join(dir, "missing"))...)
+	if len(res) != 1 || res[0].Name != "external-checks" || res[0].Severity != doctor.SeverityError {
+		t.Errorf("missing directory: %+v", res)
+	}
+}
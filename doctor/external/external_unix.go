@@ -0,0 +1,121 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !windows && !js
+
+package external
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"syscall"
+
+	"tailscale.com/doctor"
+)
+
+// openChecked opens the external check at path, after checking that no
+// one but root or the user tailscaled runs as can change it: it and
+// every directory above it must be owned by one of them and not be
+// writable by group or others. A directory with the sticky bit, such as
+// /tmp, may be writable by others, as they can't replace what's in it
+// that they don't own.
+func openChecked(path string) (*os.File, error) {
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return nil, err
+	}
+	real, err = filepath.Abs(real)
+	if err != nil {
+		return nil, err
+	}
+	for dir := filepath.Dir(real); ; dir = filepath.Dir(dir) {
+		fi, err := os.Lstat(dir)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkOwner(path, dir, fi); err != nil {
+			return nil, err
+		}
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+	// Nothing above it can change now, so real is what was checked;
+	// then check what was opened rather than real again.
+	f, err := os.OpenFile(real, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		f.Close()
+		return nil, fmt.Errorf("not running %s: not a regular file", path)
+	}
+	if err := checkOwner(path, real, fi); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// checkOwner returns an error if fi, of name, which is path or one of
+// the directories above it, can be changed by anyone but root or the
+// user tailscaled runs as.
+func checkOwner(path, name string, fi os.FileInfo) error {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("not running %s: can't tell who owns %s", path, name)
+	}
+	if st.Uid != 0 && int(st.Uid) != os.Geteuid() {
+		return doctor.WithRemediation(
+			fmt.Errorf("not running %s: %s is owned by uid %d", path, name, st.Uid),
+			"chown root "+name)
+	}
+	if fi.Mode().Perm()&0022 != 0 && !(fi.IsDir() && fi.Mode()&os.ModeSticky != 0) {
+		if !fi.IsDir() {
+			return doctor.WithRemediation(
+				fmt.Errorf("not running %s: it's writable by group or others", path),
+				"chmod go-w "+name)
+		}
+		return doctor.WithRemediation(
+			fmt.Errorf("not running %s: %s is writable by group or others", path, name),
+			"chmod go-w "+name)
+	}
+	return nil
+}
+
+// command returns the command that runs f, which openChecked opened.
+//
+// On Linux, it runs f itself, as the check's file descriptor 3, rather
+// than whatever is at its path by now; that works for scripts too, as
+// their interpreter opens the path it's given, which is still open.
+// Elsewhere, /dev/fd can't be relied on for that, and it runs f's path,
+// which openChecked made sure only root can change.
+//
+// The check runs in its own process group, so that what it starts can
+// be killed along with it.
+func command(f *os.File) *exec.Cmd {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "linux" {
+		cmd = exec.Command("/proc/self/fd/3")
+		cmd.ExtraFiles = []*os.File{f}
+	} else {
+		cmd = exec.Command(f.Name())
+	}
+	cmd.Args[0] = f.Name()
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return cmd
+}
+
+// killProcessGroup kills p and everything in its process group.
+func killProcessGroup(p *os.Process) {
+	syscall.Kill(-p.Pid, syscall.SIGKILL)
+}
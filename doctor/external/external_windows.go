@@ -0,0 +1,180 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package external
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	accessAllowedACEType = 0x0  // ACCESS_ALLOWED_ACE_TYPE
+	fileDeleteChild      = 0x40 // FILE_DELETE_CHILD
+
+	// dirWriteRights are the rights on a directory that let someone
+	// replace what's in it, or give themselves the rights to.
+	dirWriteRights = fileDeleteChild | windows.DELETE | windows.WRITE_DAC | windows.WRITE_OWNER |
+		windows.GENERIC_WRITE | windows.GENERIC_ALL
+	// fileWriteRights are the rights on a file that let someone change
+	// it, or give themselves the rights to.
+	fileWriteRights = dirWriteRights | windows.FILE_WRITE_DATA | windows.FILE_APPEND_DATA
+)
+
+// trustedInstallerSID is the SID of TrustedInstaller, which owns system
+// files and directories.
+const trustedInstallerSID = "S-1-5-80-956008885-3418522649-1831038044-1853292631-2271478464"
+
+// openChecked opens the external check at path, after checking that no
+// one but LocalSystem, Administrators, TrustedInstaller or the user
+// tailscaled runs as can change it or the directories above it. It's
+// opened without sharing write or delete access, so that it can't be
+// changed or replaced while it's open.
+func openChecked(path string) (*os.File, error) {
+	trusted, err := trustedSIDs()
+	if err != nil {
+		return nil, err
+	}
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return nil, err
+	}
+	real, err = filepath.Abs(real)
+	if err != nil {
+		return nil, err
+	}
+	for dir := filepath.Dir(real); ; dir = filepath.Dir(dir) {
+		sd, err := windows.GetNamedSecurityInfo(dir, windows.SE_FILE_OBJECT,
+			windows.OWNER_SECURITY_INFORMATION|windows.DACL_SECURITY_INFORMATION)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkOwner(path, dir, sd, trusted, dirWriteRights); err != nil {
+			return nil, err
+		}
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+
+	name, err := windows.UTF16PtrFromString(real)
+	if err != nil {
+		return nil, err
+	}
+	h, err := windows.CreateFile(name, windows.GENERIC_READ, windows.FILE_SHARE_READ, nil,
+		windows.OPEN_EXISTING, windows.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: real, Err: err}
+	}
+	f := os.NewFile(uintptr(h), real)
+	sd, err := windows.GetSecurityInfo(h, windows.SE_FILE_OBJECT,
+		windows.OWNER_SECURITY_INFORMATION|windows.DACL_SECURITY_INFORMATION)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := checkOwner(path, real, sd, trusted, fileWriteRights); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// trustedSIDs returns who may own or change an external check and the
+// directories above it.
+func trustedSIDs() ([]*windows.SID, error) {
+	var sids []*windows.SID
+	for _, t := range []windows.WELL_KNOWN_SID_TYPE{windows.WinLocalSystemSid, windows.WinBuiltinAdministratorsSid} {
+		sid, err := windows.CreateWellKnownSid(t)
+		if err != nil {
+			return nil, err
+		}
+		sids = append(sids, sid)
+	}
+	sid, err := windows.StringToSid(trustedInstallerSID)
+	if err != nil {
+		return nil, err
+	}
+	sids = append(sids, sid)
+	u, err := windows.GetCurrentProcessToken().GetTokenUser()
+	if err != nil {
+		return nil, err
+	}
+	return append(sids, u.User.Sid), nil
+}
+
+func isTrusted(sid *windows.SID, trusted []*windows.SID) bool {
+	for _, t := range trusted {
+		if sid.Equals(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// aceHeader is an ACE_HEADER.
+type aceHeader struct {
+	aceType  uint8
+	aceFlags uint8
+	aceSize  uint16
+}
+
+// accessAllowedACE is an ACCESS_ALLOWED_ACE, whose SID starts at sidStart.
+type accessAllowedACE struct {
+	header   aceHeader
+	mask     windows.ACCESS_MASK
+	sidStart uint32
+}
+
+// checkOwner returns an error if sd, of name, which is path or one of
+// the directories above it, is owned by someone not trusted or lets
+// someone not trusted have any of writeRights.
+func checkOwner(path, name string, sd *windows.SECURITY_DESCRIPTOR, trusted []*windows.SID, writeRights windows.ACCESS_MASK) error {
+	owner, _, err := sd.Owner()
+	if err != nil {
+		return err
+	}
+	if !isTrusted(owner, trusted) {
+		return fmt.Errorf("not running %s: %s is owned by %v", path, name, owner)
+	}
+	dacl, _, err := sd.DACL()
+	if err != nil {
+		return err
+	}
+	if dacl == nil {
+		return fmt.Errorf("not running %s: %s has no DACL, so anyone can change it", path, name)
+	}
+	// ACL is followed by AceCount ACEs.
+	aceCount := *(*uint16)(unsafe.Add(unsafe.Pointer(dacl), 4))
+	ace := unsafe.Add(unsafe.Pointer(dacl), 8)
+	for i := 0; i < int(aceCount); i++ {
+		h := (*aceHeader)(ace)
+		if h.aceType == accessAllowedACEType && h.aceFlags&windows.INHERIT_ONLY_ACE == 0 {
+			a := (*accessAllowedACE)(ace)
+			sid := (*windows.SID)(unsafe.Pointer(&a.sidStart))
+			if a.mask&writeRights != 0 && !isTrusted(sid, trusted) {
+				return fmt.Errorf("not running %s: %v can change %s", path, sid, name)
+			}
+		}
+		ace = unsafe.Add(ace, h.aceSize)
+	}
+	return nil
+}
+
+// command returns the command that runs f, which openChecked opened.
+// f's path can't change while f is open.
+func command(f *os.File) *exec.Cmd {
+	return exec.Command(f.Name())
+}
+
+// killProcessGroup kills p. What it started may live on, but run stops
+// waiting for them.
+func killProcessGroup(p *os.Process) {
+	p.Kill()
+}
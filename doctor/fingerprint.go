@@ -0,0 +1,158 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package doctor
+
+import (
+	"context"
+	"sync"
+)
+
+// Finding is a structured fact that a check found about the system,
+// such as the rp_filter mode, as opposed to the free-form lines it
+// logs. Findings from several checks are matched against Fingerprints
+// to identify known issues.
+type Finding struct {
+	// Check is the name of the check that reported the finding.
+	Check string
+	// Key names what was found, such as "rp_filter". Keys are shared
+	// between checks, so that a fingerprint can match a finding
+	// whichever check reported it.
+	Key string
+	// Value is what was found for Key, such as "strict".
+	Value string
+}
+
+type recorderKey struct{}
+
+// recorder collects what one check run reports with AddFinding,
+// Report and Remediate.
+type recorder struct {
+	check string
+
+	// progress, if non-nil, is passed what the check reports with
+	// ReportProgress.
+	progress func(Progress)
+
+	mu          sync.Mutex
+	findings    []Finding
+	severity    Severity
+	summary     string
+	detail      any
+	remediation []string
+}
+
+func withRecorder(ctx context.Context, check string) (context.Context, *recorder) {
+	r := &recorder{check: check}
+	return context.WithValue(ctx, recorderKey{}, r), r
+}
+
+// fill sets the fields of res from what the check reported.
+func (r *recorder) fill(res *Result) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res.Findings = append([]Finding(nil), r.findings...)
+	res.Severity = r.severity
+	res.Summary = r.summary
+	res.Detail = r.detail
+	res.Remediation = append([]string(nil), r.remediation...)
+}
+
+// addRemediationLocked adds step to the check's remediation, unless
+// it's already there. r.mu must be held.
+func (r *recorder) addRemediationLocked(step string) {
+	for _, s := range r.remediation {
+		if s == step {
+			return
+		}
+	}
+	r.remediation = append(r.remediation, step)
+}
+
+// AddFinding records a finding for the check whose Run was passed ctx.
+// It does nothing if ctx didn't come from RunChecks or
+// RunChecksResults, such as when a check is run directly.
+func AddFinding(ctx context.Context, key, value string) {
+	r, ok := ctx.Value(recorderKey{}).(*recorder)
+	if !ok {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.findings = append(r.findings, Finding{Check: r.check, Key: key, Value: value})
+}
+
+// Condition is a finding that a Fingerprint requires.
+type Condition struct {
+	Key string
+	// Value, if non-empty, is the value the finding must have.
+	// Otherwise any value matches.
+	Value string
+}
+
+func (c Condition) match(fs []Finding) bool {
+	for _, f := range fs {
+		if f.Key == c.Key && (c.Value == "" || f.Value == c.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Fingerprint is a known issue, identified by a combination of
+// findings.
+type Fingerprint struct {
+	// Name is a short description of the issue.
+	Name string
+	// URL is a knowledge base article about the issue.
+	URL string
+	// Conditions are the findings that must all be present.
+	Conditions []Condition
+}
+
+// Fingerprints are the known issues that MatchFingerprints looks for.
+var Fingerprints = []Fingerprint{
+	{
+		Name: "rp_filter strict + asymmetric routes",
+		URL:  "https://tailscale.com/kb/1019/subnets",
+		Conditions: []Condition{
+			{Key: "rp_filter", Value: "strict"},
+			{Key: "routes", Value: "asymmetric"},
+		},
+	},
+	{
+		Name: "systemd-resolved not honoring split DNS",
+		URL:  "https://tailscale.com/kb/1188/linux-dns",
+		Conditions: []Condition{
+			{Key: "dns-manager", Value: "systemd-resolved"},
+			{Key: "magicdns-lookup", Value: "failed"},
+		},
+	},
+	{
+		Name: "firewall rules split across iptables backends",
+		URL:  "https://tailscale.com/kb/1023/troubleshooting",
+		Conditions: []Condition{
+			{Key: "firewall-backends", Value: "mixed"},
+		},
+	},
+}
+
+// MatchFingerprints returns the Fingerprints whose conditions are all
+// met by fs.
+func MatchFingerprints(fs []Finding) []Fingerprint {
+	var ret []Fingerprint
+	for _, fp := range Fingerprints {
+		ok := len(fp.Conditions) > 0
+		for _, c := range fp.Conditions {
+			if !c.match(fs) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			ret = append(ret, fp)
+		}
+	}
+	return ret
+}
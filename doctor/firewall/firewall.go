@@ -0,0 +1,139 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package firewall provides a doctor.Check that detects which Linux
+// firewall backend the host uses, and whether Tailscale's rules are
+// installed in a backend compatible with the host's own rules.
+package firewall
+
+import (
+	"bufio"
+	"strings"
+
+	"tailscale.com/doctor"
+	"tailscale.com/types/preftype"
+)
+
+// Check is a doctor.Check that reports the firewall backends in use
+// on the host. It only does anything on Linux.
+//
+// Linux has two netfilter backends: the legacy x_tables one, used by
+// iptables-legacy, and nf_tables, used both by iptables-nft and by
+// native nft rulesets. Tailscale installs its rules with whichever
+// backend the iptables binary uses. If the host's own rules live in the
+// other backend, packets pass through both and one set of rules can
+// shadow the other, typically by dropping Tailscale traffic.
+type Check struct {
+	// NetfilterMode is the netfilter mode Tailscale is configured
+	// with. When it's NetfilterOff, Tailscale installs no rules, so
+	// there is nothing to be incompatible with.
+	NetfilterMode preftype.NetfilterMode
+}
+
+func (Check) Name() string {
+	return "firewall"
+}
+
+// Categories implements doctor.Categorized.
+func (Check) Categories() []doctor.Category {
+	return []doctor.Category{doctor.CategoryFirewall}
+}
+
+// Lightweight implements doctor.Lightweight: it only lists the local
+// firewall rules.
+func (Check) Lightweight() bool { return true }
+
+// Platforms implements doctor.Platformer. Listing the rules of either
+// backend needs CAP_NET_ADMIN.
+func (Check) Platforms() doctor.Requirements {
+	return doctor.Requirements{OS: []string{"linux"}, NetAdmin: true}
+}
+
+// Backend modes, as reported in parentheses by "iptables --version".
+const (
+	modeLegacy = "legacy"
+	modeNFT    = "nf_tables"
+)
+
+// parseIptablesMode returns the backend named in the output of
+// "iptables --version", such as "iptables v1.8.7 (nf_tables)". Old
+// versions that predate the nf_tables backend don't name one, and
+// are always legacy.
+func parseIptablesMode(out string) string {
+	out = strings.TrimSpace(out)
+	if !strings.HasPrefix(out, "iptables ") {
+		return ""
+	}
+	i := strings.LastIndexByte(out, '(')
+	if i == -1 || !strings.HasSuffix(out, ")") {
+		return modeLegacy
+	}
+	return out[i+1 : len(out)-1]
+}
+
+// ruleCounts summarizes the output of iptables-save.
+type ruleCounts struct {
+	total     int // rules ("-A" lines)
+	tailscale int // rules in or jumping to Tailscale's ts-* chains
+}
+
+func (c ruleCounts) others() int { return c.total - c.tailscale }
+
+// countRules counts the rules in iptables-save output.
+func countRules(save string) ruleCounts {
+	var c ruleCounts
+	sc := bufio.NewScanner(strings.NewReader(save))
+	for sc.Scan() {
+		line := sc.Text()
+		if !strings.HasPrefix(line, "-A ") {
+			continue
+		}
+		c.total++
+		if strings.HasPrefix(line, "-A ts-") || strings.Contains(line, " -j ts-") {
+			c.tailscale++
+		}
+	}
+	return c
+}
+
+// iptablesNFTTables are the nftables tables created by iptables-nft
+// and ip6tables-nft; any other table belongs to a native nft ruleset
+// such as firewalld's or nftables.service's.
+var iptablesNFTTables = map[string]bool{
+	"ip filter":    true,
+	"ip nat":       true,
+	"ip mangle":    true,
+	"ip raw":       true,
+	"ip security":  true,
+	"ip6 filter":   true,
+	"ip6 nat":      true,
+	"ip6 mangle":   true,
+	"ip6 raw":      true,
+	"ip6 security": true,
+}
+
+// IsIptablesNFTTable reports whether the nftables table t, of the form
+// "<family> <name>", is one that iptables-nft or ip6tables-nft create.
+func IsIptablesNFTTable(t string) bool {
+	return iptablesNFTTables[t]
+}
+
+// nativeNFTTables returns the tables in the output of "nft list
+// tables" that weren't created by iptables-nft.
+func nativeNFTTables(out string) []string {
+	var ret []string
+	sc := bufio.NewScanner(strings.NewReader(out))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if !strings.HasPrefix(line, "table ") {
+			continue
+		}
+		t := strings.TrimPrefix(line, "table ")
+		if iptablesNFTTables[t] {
+			continue
+		}
+		ret = append(ret, t)
+	}
+	return ret
+}
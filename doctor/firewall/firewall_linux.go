@@ -0,0 +1,103 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package firewall
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"tailscale.com/doctor"
+	"tailscale.com/types/logger"
+	"tailscale.com/types/preftype"
+)
+
+func (c Check) Run(ctx context.Context, logf logger.Logf) error {
+	out, err := exec.CommandContext(ctx, "iptables", "--version").Output()
+	if err != nil {
+		if c.NetfilterMode == preftype.NetfilterOff {
+			logf("iptables not found, but netfilter mode is off")
+			return nil
+		}
+		return fmt.Errorf("running iptables: %w", err)
+	}
+	mode := parseIptablesMode(string(out))
+	if mode == "" {
+		return fmt.Errorf("unrecognized iptables version %q", strings.TrimSpace(string(out)))
+	}
+	logf("iptables, and so Tailscale, uses the %s backend", mode)
+
+	legacy, haveLegacy := saveRules(ctx, logf, "iptables-legacy-save")
+	nft, haveNFT := saveRules(ctx, logf, "iptables-nft-save")
+	if !haveLegacy && !haveNFT {
+		// Only one variant is installed, without a suffix; it's the
+		// one iptables itself uses.
+		rc, ok := saveRules(ctx, logf, "iptables-save")
+		if !ok {
+			return errors.New("can't list iptables rules")
+		}
+		if mode == modeLegacy {
+			legacy = rc
+		} else {
+			nft = rc
+		}
+	}
+	var native []string
+	if out, err := exec.CommandContext(ctx, "nft", "list", "tables").Output(); err == nil {
+		native = nativeNFTTables(string(out))
+	}
+	logf("legacy backend: %d rules (%d Tailscale)", legacy.total, legacy.tailscale)
+	logf("nf_tables backend: %d iptables-nft rules (%d Tailscale); native nft tables: %q", nft.total, nft.tailscale, native)
+
+	var problems []string
+	hostNFT := nft.others() > 0 || len(native) > 0
+	if legacy.others() > 0 && hostNFT {
+		doctor.AddFinding(ctx, "firewall-backends", "mixed")
+		problems = append(problems, "the host has firewall rules in both the legacy and nf_tables backends; packets pass through both, and rules in one may drop traffic the other allows")
+	}
+	if c.NetfilterMode != preftype.NetfilterOff {
+		ours, other, otherMode := nft, legacy, modeLegacy
+		if mode == modeLegacy {
+			ours, other, otherMode = legacy, nft, modeNFT
+		}
+		if ours.tailscale == 0 {
+			// Not necessarily a problem: there are no rules with
+			// userspace networking either.
+			logf("netfilter mode is %v but there are no Tailscale rules in the %s backend", c.NetfilterMode, mode)
+		}
+		if other.tailscale > 0 {
+			problems = append(problems, fmt.Sprintf("stale Tailscale rules exist in the %s backend, probably from when the host used it", otherMode))
+		}
+		switch {
+		case mode == modeLegacy && hostNFT && legacy.others() == 0:
+			problems = append(problems, "Tailscale's rules are in the legacy backend but the host's firewall uses nf_tables; switch iptables to iptables-nft (e.g. with update-alternatives) and restart tailscaled")
+		case mode == modeNFT && legacy.others() > 0 && !hostNFT:
+			problems = append(problems, "Tailscale's rules are in the nf_tables backend but the host's firewall uses legacy iptables; switch iptables to iptables-legacy (e.g. with update-alternatives) and restart tailscaled")
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	for _, p := range problems {
+		logf("problem: %s", p)
+	}
+	return fmt.Errorf("%d firewall problem(s) found", len(problems))
+}
+
+// saveRules runs the named iptables-save variant and counts its rules.
+// It reports false if the command isn't installed or fails.
+func saveRules(ctx context.Context, logf logger.Logf, cmd string) (ruleCounts, bool) {
+	if _, err := exec.LookPath(cmd); err != nil {
+		return ruleCounts{}, false
+	}
+	out, err := exec.CommandContext(ctx, cmd).Output()
+	if err != nil {
+		logf("%s: %v", cmd, err)
+		return ruleCounts{}, false
+	}
+	return countRules(string(out)), true
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package firewall
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseIptablesMode(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"iptables v1.8.7 (nf_tables)\n", modeNFT},
+		{"iptables v1.8.7 (legacy)\n", modeLegacy},
+		{"iptables v1.6.1\n", modeLegacy},
+		{"bogus", ""},
+	}
+	for _, tt := range tests {
+		if got := parseIptablesMode(tt.in); got != tt.want {
+			t.Errorf("parseIptablesMode(%q) = %q; want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCountRules(t *testing.T) {
+	const save = `# Generated by iptables-save v1.8.7 on Mon Sep 12 10:00:00 2022
+*filter
+:INPUT ACCEPT [0:0]
+:ts-input - [0:0]
+-A INPUT -j ts-input
+-A INPUT -p tcp --dport 22 -j ACCEPT
+-A ts-input -i lo -s 100.101.102.103/32 -j ACCEPT
+COMMIT
+`
+	got := countRules(save)
+	want := ruleCounts{total: 3, tailscale: 2}
+	if got != want {
+		t.Errorf("countRules = %+v; want %+v", got, want)
+	}
+	if got.others() != 1 {
+		t.Errorf("others = %d; want 1", got.others())
+	}
+}
+
+func TestNativeNFTTables(t *testing.T) {
+	const out = `table ip filter
+table ip nat
+table inet firewalld
+table ip6 filter
+table bridge filter
+`
+	got := nativeNFTTables(out)
+	want := []string{"inet firewalld", "bridge filter"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("nativeNFTTables = %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,344 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package ipv6only provides a doctor.Check that detects IPv6-only
+// networks, along with the NAT64/DNS64 and CLAT translation they may
+// offer, and reports whether the control server, DERP and peers can be
+// reached through them.
+package ipv6only
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"sort"
+
+	"tailscale.com/doctor"
+	"tailscale.com/net/interfaces"
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/multierr"
+)
+
+var (
+	// clatRange is the IPv4 range reserved for the IPv4 side of a
+	// 464XLAT CLAT (RFC 7335). Android and iOS both use it.
+	clatRange = netip.MustParsePrefix("192.0.0.0/29")
+
+	// ipv4OnlyAddrs are the well-known addresses of ipv4only.arpa,
+	// which DNS64 synthesizes into the local NAT64 prefix (RFC 7050).
+	ipv4OnlyAddrs = []netip.Addr{
+		netip.MustParseAddr("192.0.0.170"),
+		netip.MustParseAddr("192.0.0.171"),
+	}
+)
+
+// Check is a doctor.Check that reports on IPv6-only connectivity.
+// On networks with native IPv4 it does nothing.
+type Check struct {
+	// ControlURL is the URL of the control server.
+	ControlURL string
+
+	// DERPMap is the DERP map to check. If nil, DERP is not checked.
+	DERPMap *tailcfg.DERPMap
+
+	// Resolver, if non-nil, is used for DNS lookups instead of
+	// net.DefaultResolver.
+	Resolver *net.Resolver
+}
+
+func (Check) Name() string {
+	return "ipv6-only"
+}
+
+// Categories implements doctor.Categorized.
+func (Check) Categories() []doctor.Category {
+	return []doctor.Category{doctor.CategoryNetwork, doctor.CategoryDNS}
+}
+
+func (c Check) Run(ctx context.Context, logf logger.Logf) error {
+	st, err := interfaces.GetState()
+	if err != nil {
+		return err
+	}
+	fam := addrFamilies(st)
+	switch {
+	case fam.v4:
+		logf("native IPv4 available; not an IPv6-only network")
+		return nil
+	case !fam.v6:
+		logf("no usable IPv4 or IPv6 address; skipping")
+		return nil
+	}
+	logf("no native IPv4 address; this looks like an IPv6-only network")
+
+	res := c.Resolver
+	if res == nil {
+		res = net.DefaultResolver
+	}
+	n := &network{clat: fam.clat != ""}
+	if n.clat {
+		logf("CLAT present on %s; IPv4 literals are translated", fam.clat)
+	} else {
+		logf("no CLAT found; IPv4 literals are unreachable")
+	}
+	n.nat64, err = discoverNAT64(ctx, res)
+	switch {
+	case err != nil:
+		logf("NAT64 discovery: %v", err)
+	case len(n.nat64) == 0:
+		logf("no NAT64/DNS64 found; IPv4-only hostnames are unreachable")
+	default:
+		logf("NAT64/DNS64 present with prefix %v", n.nat64)
+	}
+
+	var errs []error
+	if c.ControlURL != "" {
+		if err := c.checkControl(ctx, logf, res, n); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.DERPMap != nil {
+		if err := c.checkDERP(ctx, logf, res, n); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if n.clat {
+		logf("peers: direct connections to IPv4-only peers go through the CLAT")
+	} else {
+		logf("peers: IPv4-only peers can only be reached through DERP")
+	}
+	return multierr.New(errs...)
+}
+
+func (c Check) checkControl(ctx context.Context, logf logger.Logf, res *net.Resolver, n *network) error {
+	u, err := url.Parse(c.ControlURL)
+	if err != nil {
+		return fmt.Errorf("control: %w", err)
+	}
+	how, err := n.hostPath(ctx, res, u.Hostname())
+	if err != nil {
+		return fmt.Errorf("control server %s unreachable: %w", u.Hostname(), err)
+	}
+	logf("control server %s reachable %s", u.Hostname(), how)
+	return nil
+}
+
+func (c Check) checkDERP(ctx context.Context, logf logger.Logf, res *net.Resolver, n *network) error {
+	var usable int
+	for _, id := range c.DERPMap.RegionIDs() {
+		r := c.DERPMap.Regions[id]
+		var how string
+		var lastErr error
+		for _, node := range r.Nodes {
+			if node.STUNOnly {
+				continue
+			}
+			how, lastErr = n.derpNodePath(ctx, res, node)
+			if lastErr == nil {
+				break
+			}
+		}
+		if how == "" {
+			if lastErr == nil {
+				lastErr = errors.New("no DERP nodes")
+			}
+			logf("region %d (%s) unreachable: %v", id, r.RegionCode, lastErr)
+			continue
+		}
+		usable++
+		logf("region %d (%s) reachable %s", id, r.RegionCode, how)
+	}
+	if usable == 0 {
+		return errors.New("no DERP region is reachable over IPv6, NAT64 or CLAT")
+	}
+	return nil
+}
+
+// network describes the IPv4 translation available on an IPv6-only
+// network.
+type network struct {
+	clat  bool           // a CLAT translates IPv4 literals
+	nat64 []netip.Prefix // NAT64 prefixes announced by DNS64
+}
+
+// hostPath reports how host will be reached, looking up its IPv6
+// addresses as the system resolver (and so any DNS64) returns them.
+func (n *network) hostPath(ctx context.Context, res *net.Resolver, host string) (string, error) {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return n.addrPath(ip)
+	}
+	ips, _ := res.LookupNetIP(ctx, "ip6", host)
+	for _, ip := range ips {
+		if !n.isNAT64(ip) {
+			return "over native IPv6", nil
+		}
+	}
+	if len(ips) > 0 {
+		return "through NAT64", nil
+	}
+	if n.clat {
+		return "through the CLAT", nil
+	}
+	return "", errors.New("it has no IPv6 address and there is no NAT64 or CLAT")
+}
+
+// addrPath is like hostPath, for an IP literal.
+func (n *network) addrPath(ip netip.Addr) (string, error) {
+	switch {
+	case ip.Is6() && n.isNAT64(ip):
+		return "through NAT64", nil
+	case ip.Is6():
+		return "over native IPv6", nil
+	case n.clat:
+		return "through the CLAT", nil
+	}
+	return "", fmt.Errorf("%v is an IPv4 literal and there is no CLAT", ip)
+}
+
+// derpNodePath reports how node will be reached, following the rules
+// derphttp uses to pick the addresses it dials: an IPv6 literal is
+// dialed directly, an empty IPv6 field means node's hostname is looked
+// up, and anything else disables IPv6 for the node.
+func (n *network) derpNodePath(ctx context.Context, res *net.Resolver, node *tailcfg.DERPNode) (string, error) {
+	if ip, err := netip.ParseAddr(node.IPv6); err == nil && ip.Is6() {
+		return n.addrPath(ip)
+	}
+	if node.IPv6 == "" {
+		return n.hostPath(ctx, res, node.HostName)
+	}
+	if ip, err := netip.ParseAddr(node.IPv4); err == nil && ip.Is4() {
+		return n.addrPath(ip)
+	}
+	if node.IPv4 == "" && n.clat {
+		return "through the CLAT", nil
+	}
+	return "", fmt.Errorf("node %s has IPv6 disabled and no CLAT is present", node.Name)
+}
+
+func (n *network) isNAT64(ip netip.Addr) bool {
+	for _, pfx := range n.nat64 {
+		if pfx.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// families is the result of addrFamilies.
+type families struct {
+	v4   bool   // a usable IPv4 address outside the CLAT range
+	v6   bool   // a global IPv6 address
+	clat string // the name of an interface with a CLAT address, if any
+}
+
+// addrFamilies reports which address families st's interfaces have,
+// ignoring interfaces that are down or belong to Tailscale.
+func addrFamilies(st *interfaces.State) families {
+	var ret families
+	names := make([]string, 0, len(st.InterfaceIPs))
+	for name := range st.InterfaceIPs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if iface, ok := st.Interface[name]; !ok || iface.Interface == nil || !iface.IsUp() {
+			continue
+		}
+		pfxs := st.InterfaceIPs[name]
+		if isTailscale(pfxs) {
+			continue
+		}
+		for _, pfx := range pfxs {
+			ip := pfx.Addr()
+			switch {
+			case ip.IsLoopback() || ip.IsLinkLocalUnicast():
+			case clatRange.Contains(ip):
+				if ret.clat == "" {
+					ret.clat = name
+				}
+			case ip.Is4():
+				ret.v4 = true
+			case ip.IsGlobalUnicast() && !ip.IsPrivate():
+				ret.v6 = true
+			}
+		}
+	}
+	return ret
+}
+
+func isTailscale(pfxs []netip.Prefix) bool {
+	for _, pfx := range pfxs {
+		if tsaddr.IsTailscaleIP(pfx.Addr()) {
+			return true
+		}
+	}
+	return false
+}
+
+// discoverNAT64 returns the NAT64 prefixes in use, found by looking up
+// the synthesized IPv6 addresses of ipv4only.arpa (RFC 7050). It returns
+// no prefixes, and no error, if the network has no DNS64.
+func discoverNAT64(ctx context.Context, res *net.Resolver) ([]netip.Prefix, error) {
+	ips, err := res.LookupNetIP(ctx, "ip6", "ipv4only.arpa")
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var ret []netip.Prefix
+	for _, ip := range ips {
+		pfx, ok := nat64Prefix(ip)
+		if !ok {
+			continue
+		}
+		dup := false
+		for _, p := range ret {
+			dup = dup || p == pfx
+		}
+		if !dup {
+			ret = append(ret, pfx)
+		}
+	}
+	return ret, nil
+}
+
+// nat64Offsets maps each NAT64 prefix length allowed by RFC 6052 to the
+// byte offsets of the embedded IPv4 address. Byte 8 (bits 64-71) is
+// always zero and skipped.
+var nat64Offsets = []struct {
+	bits int
+	idx  [4]int
+}{
+	{96, [4]int{12, 13, 14, 15}},
+	{64, [4]int{9, 10, 11, 12}},
+	{56, [4]int{7, 9, 10, 11}},
+	{48, [4]int{6, 7, 9, 10}},
+	{40, [4]int{5, 6, 7, 9}},
+	{32, [4]int{4, 5, 6, 7}},
+}
+
+// nat64Prefix returns the NAT64 prefix that ip was synthesized from, if
+// ip embeds one of the well-known ipv4only.arpa addresses.
+func nat64Prefix(ip netip.Addr) (netip.Prefix, bool) {
+	if !ip.Is6() || ip.Is4In6() {
+		return netip.Prefix{}, false
+	}
+	b := ip.As16()
+	for _, o := range nat64Offsets {
+		v4 := netip.AddrFrom4([4]byte{b[o.idx[0]], b[o.idx[1]], b[o.idx[2]], b[o.idx[3]]})
+		for _, want := range ipv4OnlyAddrs {
+			if v4 == want {
+				return netip.PrefixFrom(ip, o.bits).Masked(), true
+			}
+		}
+	}
+	return netip.Prefix{}, false
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipv6only
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+
+	"tailscale.com/net/interfaces"
+	"tailscale.com/tailcfg"
+)
+
+func TestNAT64Prefix(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string // empty if not synthesized
+	}{
+		{"64:ff9b::c000:aa", "64:ff9b::/96"},
+		{"64:ff9b::c000:ab", "64:ff9b::/96"},
+		{"2001:db8:1:2::c000:aa", "2001:db8:1:2::/96"},
+		{"2001:db8:1:2:c0:0:aa00:0", "2001:db8:1:2::/64"},
+		{"2001:db8:1c0:0:aa::", "2001:db8:100::/40"},
+		{"2001:db8:c000:aa::", "2001:db8::/32"},
+		{"64:ff9b::1.2.3.4", ""},
+		{"2001:db8::1", ""},
+		{"192.0.0.170", ""},
+		{"::ffff:192.0.0.170", ""},
+	}
+	for _, tt := range tests {
+		got, ok := nat64Prefix(netip.MustParseAddr(tt.ip))
+		if tt.want == "" {
+			if ok {
+				t.Errorf("nat64Prefix(%s) = %v; want none", tt.ip, got)
+			}
+			continue
+		}
+		if !ok || got != netip.MustParsePrefix(tt.want) {
+			t.Errorf("nat64Prefix(%s) = %v, %v; want %s", tt.ip, got, ok, tt.want)
+		}
+	}
+}
+
+func TestAddrFamilies(t *testing.T) {
+	iface := func(name string, up bool) interfaces.Interface {
+		var flags net.Flags
+		if up {
+			flags = net.FlagUp
+		}
+		return interfaces.Interface{Interface: &net.Interface{Name: name, Flags: flags}}
+	}
+	pfxs := func(ss ...string) []netip.Prefix {
+		var ret []netip.Prefix
+		for _, s := range ss {
+			ret = append(ret, netip.MustParsePrefix(s))
+		}
+		return ret
+	}
+	tests := []struct {
+		name string
+		st   *interfaces.State
+		want families
+	}{
+		{
+			name: "dual-stack",
+			st: &interfaces.State{
+				Interface:    map[string]interfaces.Interface{"eth0": iface("eth0", true)},
+				InterfaceIPs: map[string][]netip.Prefix{"eth0": pfxs("192.168.1.2/24", "2001:db8::2/64")},
+			},
+			want: families{v4: true, v6: true},
+		},
+		{
+			name: "ipv6-only-with-clat",
+			st: &interfaces.State{
+				Interface: map[string]interfaces.Interface{
+					"rmnet0":    iface("rmnet0", true),
+					"v4-rmnet0": iface("v4-rmnet0", true),
+				},
+				InterfaceIPs: map[string][]netip.Prefix{
+					"rmnet0":    pfxs("2001:db8::2/64", "fe80::1/64"),
+					"v4-rmnet0": pfxs("192.0.0.4/32"),
+				},
+			},
+			want: families{v6: true, clat: "v4-rmnet0"},
+		},
+		{
+			name: "ignores-down-and-tailscale",
+			st: &interfaces.State{
+				Interface: map[string]interfaces.Interface{
+					"eth0":       iface("eth0", true),
+					"eth1":       iface("eth1", false),
+					"tailscale0": iface("tailscale0", true),
+				},
+				InterfaceIPs: map[string][]netip.Prefix{
+					"eth0":       pfxs("2001:db8::2/64"),
+					"eth1":       pfxs("10.0.0.2/24"),
+					"tailscale0": pfxs("100.101.102.103/32"),
+				},
+			},
+			want: families{v6: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := addrFamilies(tt.st); got != tt.want {
+				t.Errorf("got %+v; want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDERPNodePath(t *testing.T) {
+	nat64 := []netip.Prefix{netip.MustParsePrefix("64:ff9b::/96")}
+	tests := []struct {
+		name    string
+		n       network
+		node    tailcfg.DERPNode
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "v6-literal",
+			node: tailcfg.DERPNode{IPv4: "1.2.3.4", IPv6: "2001:db8::1"},
+			want: "over native IPv6",
+		},
+		{
+			name: "v6-disabled-no-clat",
+			n:    network{nat64: nat64},
+			node: tailcfg.DERPNode{Name: "1a", IPv4: "1.2.3.4", IPv6: "none"},
+			// NAT64 alone can't help: the IPv4 literal is never
+			// looked up, so DNS64 doesn't get to synthesize it.
+			wantErr: true,
+		},
+		{
+			name: "v6-disabled-clat",
+			n:    network{clat: true},
+			node: tailcfg.DERPNode{IPv4: "1.2.3.4", IPv6: "none"},
+			want: "through the CLAT",
+		},
+		{
+			name: "v6-literal-in-nat64",
+			n:    network{nat64: nat64},
+			node: tailcfg.DERPNode{IPv6: "64:ff9b::102:304"},
+			want: "through NAT64",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.n.derpNodePath(context.Background(), net.DefaultResolver, &tt.node)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v; wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
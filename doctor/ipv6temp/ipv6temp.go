@@ -0,0 +1,122 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package ipv6temp provides a doctor.Check that reports on temporary
+// IPv6 addresses (RFC 8981 privacy extensions) and flags those that are
+// advertised as endpoints, as peers' direct paths to them break each
+// time the OS rotates them.
+package ipv6temp
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"runtime"
+	"sort"
+	"time"
+
+	"tailscale.com/doctor"
+	"tailscale.com/net/interfaces"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/multierr"
+)
+
+// fastRotation is the preferred lifetime below which temporary
+// addresses are considered to rotate rapidly. The usual default is a
+// day.
+const fastRotation = time.Hour
+
+// Check is a doctor.Check that reports on temporary IPv6 addresses.
+type Check struct {
+	// Endpoints are the endpoints advertised to peers.
+	Endpoints []tailcfg.Endpoint
+}
+
+func (Check) Name() string {
+	return "ipv6-temp-addrs"
+}
+
+// Categories implements doctor.Categorized.
+func (Check) Categories() []doctor.Category {
+	return []doctor.Category{doctor.CategoryNetwork}
+}
+
+// Lightweight implements doctor.Lightweight: it only reads the local
+// interfaces' addresses.
+func (Check) Lightweight() bool { return true }
+
+func (c Check) Run(ctx context.Context, logf logger.Logf) error {
+	if !interfaces.CanDetectTemporaryIPv6() {
+		logf("can't tell temporary IPv6 addresses apart on %s; skipping", runtime.GOOS)
+		return nil
+	}
+	tmp, err := interfaces.TemporaryIPv6Addrs()
+	if err != nil {
+		return err
+	}
+	if len(tmp) == 0 {
+		logf("no temporary IPv6 addresses")
+		return nil
+	}
+	st, err := interfaces.GetState()
+	if err != nil {
+		return err
+	}
+
+	ifOf := map[netip.Addr]string{}
+	lifetime := map[string]time.Duration{}
+	var names []string
+	for name, pfxs := range st.InterfaceIPs {
+		var n int
+		for _, pfx := range pfxs {
+			if tmp[pfx.Addr()] {
+				ifOf[pfx.Addr()] = name
+				n++
+			}
+		}
+		if n == 0 {
+			continue
+		}
+		names = append(names, name)
+		if d, ok := preferredLifetime(name); ok {
+			lifetime[name] = d
+			logf("%s: %d temporary address(es), each preferred for %v", name, n, d)
+		} else {
+			logf("%s: %d temporary address(es)", name, n)
+		}
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, ep := range temporaryEndpoints(c.Endpoints, tmp) {
+		name := ifOf[ep.Addr()]
+		if d, ok := lifetime[name]; ok && d < fastRotation {
+			errs = append(errs, fmt.Errorf("endpoint %v is a temporary address on %s, replaced every %v; peers lose their direct path each time", ep, name, d))
+		} else {
+			errs = append(errs, fmt.Errorf("endpoint %v is a temporary address; peers lose their direct path each time it's replaced", ep))
+		}
+	}
+	if len(errs) > 0 {
+		logf("no stable global IPv6 address to advertise instead; consider enabling stable (EUI-64 or RFC 7217) addresses")
+		return multierr.New(errs...)
+	}
+	for _, name := range names {
+		if d, ok := lifetime[name]; ok && d < fastRotation {
+			logf("%s rotates temporary addresses rapidly, but they're not advertised as endpoints", name)
+		}
+	}
+	return nil
+}
+
+// temporaryEndpoints returns the addresses of eps that are in tmp.
+func temporaryEndpoints(eps []tailcfg.Endpoint, tmp map[netip.Addr]bool) []netip.AddrPort {
+	var ret []netip.AddrPort
+	for _, ep := range eps {
+		if tmp[ep.Addr.Addr()] {
+			ret = append(ret, ep.Addr)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,27 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipv6temp
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// preferredLifetime returns how long the temporary addresses of the
+// named interface are preferred before a new one replaces them.
+func preferredLifetime(ifName string) (time.Duration, bool) {
+	b, err := os.ReadFile(filepath.Join("/proc/sys/net/ipv6/conf", ifName, "temp_prefered_lft"))
+	if err != nil {
+		return 0, false
+	}
+	secs, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil || secs <= 0 {
+		return 0, false
+	}
+	return time.Duration(secs) * time.Second, true
+}
@@ -0,0 +1,14 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package ipv6temp
+
+import "time"
+
+func preferredLifetime(ifName string) (time.Duration, bool) {
+	return 0, false
+}
@@ -0,0 +1,29 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipv6temp
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"tailscale.com/tailcfg"
+)
+
+func TestTemporaryEndpoints(t *testing.T) {
+	tmp := map[netip.Addr]bool{
+		netip.MustParseAddr("2001:db8::a1b2:c3d4:e5f6:718"): true,
+	}
+	eps := []tailcfg.Endpoint{
+		{Addr: netip.MustParseAddrPort("192.0.2.1:41641"), Type: tailcfg.EndpointSTUN},
+		{Addr: netip.MustParseAddrPort("[2001:db8::a1b2:c3d4:e5f6:718]:41641"), Type: tailcfg.EndpointSTUN},
+		{Addr: netip.MustParseAddrPort("[2001:db8::1c2a:3bff:fe4d:5e6f]:41641"), Type: tailcfg.EndpointLocal},
+	}
+	got := temporaryEndpoints(eps, tmp)
+	want := []netip.AddrPort{netip.MustParseAddrPort("[2001:db8::a1b2:c3d4:e5f6:718]:41641")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
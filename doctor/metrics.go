@@ -0,0 +1,92 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package doctor
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/util/clientmetric"
+)
+
+// ExternalCheckPrefix starts the names of the checks defined by the
+// site, which doctor/external runs.
+const ExternalCheckPrefix = "ext-"
+
+// checkMetrics are the client metrics of a check, so that which checks
+// are slow or commonly fail can be seen across many nodes.
+type checkMetrics struct {
+	pass *clientmetric.Metric // runs that didn't fail
+	fail *clientmetric.Metric // runs with SeverityError
+	ms   *clientmetric.Metric // total wall-clock milliseconds of all runs
+}
+
+var (
+	// The clientmetric package panics on publishing a metric twice,
+	// and checks are only known by name once they run, so the metrics
+	// of each check are published on its first run and kept here.
+	checkMetricsMu sync.Mutex
+	checkMetricsOf = map[string]*checkMetrics{} // by metricName of the check name
+)
+
+// metricsForCheck returns the metrics of the check named name,
+// publishing them if needed. Checks whose names differ only in
+// punctuation share metrics, and all external checks share the "ext"
+// ones, as their names are up to each site and would otherwise publish
+// any number of metrics. It returns nil if the metrics' names are
+// already taken by others, such as ones uploaded over the LocalAPI.
+func metricsForCheck(name string) *checkMetrics {
+	if strings.HasPrefix(name, ExternalCheckPrefix) {
+		name = strings.TrimSuffix(ExternalCheckPrefix, "-")
+	}
+	key := metricName(name)
+	checkMetricsMu.Lock()
+	defer checkMetricsMu.Unlock()
+	if m, ok := checkMetricsOf[key]; ok {
+		return m
+	}
+	prefix := "doctor_check_" + key
+	var m *checkMetrics
+	if !clientmetric.HasPublished(prefix+"_pass") && !clientmetric.HasPublished(prefix+"_fail") && !clientmetric.HasPublished(prefix+"_ms") {
+		m = &checkMetrics{
+			pass: clientmetric.NewCounter(prefix + "_pass"),
+			fail: clientmetric.NewCounter(prefix + "_fail"),
+			ms:   clientmetric.NewCounter(prefix + "_ms"),
+		}
+	}
+	checkMetricsOf[key] = m
+	return m
+}
+
+// metricName returns the check name as it appears in metric names,
+// which can only have letters, digits and underscores, such as
+// "ip_forwarding" for "ip-forwarding".
+func metricName(checkName string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, checkName)
+}
+
+// recordMetrics records the outcome of a run of a check that took d,
+// per its result r.
+func recordMetrics(r *Result, d time.Duration) {
+	if r.Name == "" {
+		return
+	}
+	m := metricsForCheck(r.Name)
+	if m == nil {
+		return
+	}
+	if r.Severity == SeverityError {
+		m.fail.Add(1)
+	} else {
+		m.pass.Add(1)
+	}
+	m.ms.Add(d.Milliseconds())
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package mssclamp provides a doctor.Check that detects when forwarded
+// TCP connections are likely breaking for lack of MSS clamping.
+//
+// Hosts behind a subnet router or exit node advertise an MSS based on
+// their own, usually larger, MTU, so their segments only fit the
+// Tailscale interface if path MTU discovery works. When the ICMP
+// messages it depends on are lost, connections stall after the
+// handshake.
+package mssclamp
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"tailscale.com/doctor"
+	"tailscale.com/net/interfaces"
+	"tailscale.com/types/logger"
+)
+
+const (
+	// defaultMTU is the MTU of the Tailscale interface when it can't
+	// be found, as in userspace-networking mode. It matches
+	// tstun.DefaultMTU.
+	defaultMTU = 1280
+
+	// wireguardOverhead is the most WireGuard adds to each packet:
+	// IPv6 and UDP headers, plus its own header and auth tag.
+	wireguardOverhead = 40 + 8 + 32
+)
+
+// Check is a doctor.Check for TCP MSS clamping problems.
+type Check struct {
+	// Forwarding is whether this node forwards traffic for peers as
+	// a subnet router or exit node.
+	Forwarding bool
+
+	// ClampMSS is whether the ClampMSS pref is on.
+	ClampMSS bool
+}
+
+func (Check) Name() string {
+	return "mss-clamp"
+}
+
+// Categories implements doctor.Categorized.
+func (Check) Categories() []doctor.Category {
+	return []doctor.Category{doctor.CategoryNetwork, doctor.CategoryPerformance}
+}
+
+// Lightweight implements doctor.Lightweight: it only compares local
+// interface MTUs.
+func (Check) Lightweight() bool { return true }
+
+func (c Check) Run(ctx context.Context, logf logger.Logf) error {
+	if !c.Forwarding {
+		logf("not a subnet router or exit node; skipping")
+		return nil
+	}
+	if c.ClampMSS {
+		logf("MSS clamping is on")
+		return nil
+	}
+
+	mtu := defaultMTU
+	var tsName string
+	if _, tsIf, err := interfaces.Tailscale(); err == nil && tsIf != nil {
+		mtu, tsName = tsIf.MTU, tsIf.Name
+	}
+	st, err := interfaces.GetState()
+	if err != nil {
+		return err
+	}
+	var names []string
+	for name := range st.Interface {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		iface := st.Interface[name]
+		if name == tsName || iface.Interface == nil || !iface.IsUp() || iface.IsLoopback() || iface.MTU <= mtu {
+			continue
+		}
+		logf("hosts on %s (MTU %d) need path MTU discovery to fit the Tailscale MTU of %d", name, iface.MTU, mtu)
+	}
+	if st.DefaultRouteInterface != "" {
+		if iface, ok := st.Interface[st.DefaultRouteInterface]; ok && iface.Interface != nil && iface.MTU < mtu+wireguardOverhead {
+			logf("uplink %s has MTU %d, below the %d needed to carry %d-byte Tailscale packets unfragmented",
+				iface.Name, iface.MTU, mtu+wireguardOverhead, mtu)
+		}
+	}
+
+	n, ok := fragFailures()
+	if !ok {
+		return nil
+	}
+	if n > 0 {
+		return doctor.WithRemediation(
+			fmt.Errorf("%d packets have been dropped since boot for being too big for their next hop; connections relying on path MTU discovery may be stalling", n),
+			"run 'tailscale up --clamp-mss'")
+	}
+	logf("no packets dropped for being too big; path MTU discovery appears to be working")
+	return nil
+}
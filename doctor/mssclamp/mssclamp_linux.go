@@ -0,0 +1,47 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mssclamp
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// fragFailures returns the number of packets the kernel dropped because
+// they needed fragmenting but had the Don't Fragment bit set, answering
+// each with an ICMP "fragmentation needed" message.
+func fragFailures() (int64, bool) {
+	b, err := os.ReadFile("/proc/net/snmp")
+	if err != nil {
+		return 0, false
+	}
+	return parseFragFails(string(b))
+}
+
+// parseFragFails returns the FragFails counter from the contents of
+// /proc/net/snmp, whose "Ip:" lines are a header line of counter names
+// followed by a line of their values.
+func parseFragFails(snmp string) (int64, bool) {
+	var names []string
+	for _, line := range strings.Split(snmp, "\n") {
+		f := strings.Fields(line)
+		if len(f) == 0 || f[0] != "Ip:" {
+			continue
+		}
+		if names == nil {
+			names = f
+			continue
+		}
+		for i, name := range names {
+			if name == "FragFails" && i < len(f) {
+				n, err := strconv.ParseInt(f[i], 10, 64)
+				return n, err == nil
+			}
+		}
+		return 0, false
+	}
+	return 0, false
+}
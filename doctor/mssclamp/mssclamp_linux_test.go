@@ -0,0 +1,22 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mssclamp
+
+import "testing"
+
+func TestParseFragFails(t *testing.T) {
+	const snmp = `Ip: Forwarding DefaultTTL InReceives InHdrErrors InAddrErrors ForwDatagrams InUnknownProtos InDiscards InDelivers OutRequests OutDiscards OutNoRoutes ReasmTimeout ReasmReqds ReasmOKs ReasmFails FragOKs FragFails FragCreates
+Ip: 1 64 2081519 0 6 71235 0 0 2009986 1961612 12 40 0 0 0 0 0 17 0
+Icmp: InMsgs InErrors InCsumErrors InDestUnreachs
+Icmp: 372 0 0 311
+`
+	got, ok := parseFragFails(snmp)
+	if !ok || got != 17 {
+		t.Errorf("parseFragFails = %d, %v; want 17, true", got, ok)
+	}
+	if _, ok := parseFragFails("Icmp: InMsgs\nIcmp: 1\n"); ok {
+		t.Errorf("parseFragFails without Ip lines = ok; want not ok")
+	}
+}
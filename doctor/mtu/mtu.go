@@ -0,0 +1,113 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package mtu provides a doctor.Check that compares the MTU of the
+// Tailscale interface with that of the uplink.
+//
+// Each packet sent over the Tailscale interface is wrapped by
+// WireGuard and sent over the uplink, so the uplink MTU must exceed
+// the Tailscale MTU by the WireGuard overhead. Otherwise full-size
+// packets are fragmented or, if fragmentation isn't permitted
+// somewhere along the path, silently dropped.
+package mtu
+
+import (
+	"context"
+	"fmt"
+
+	"tailscale.com/doctor"
+	"tailscale.com/net/interfaces"
+	"tailscale.com/types/logger"
+)
+
+const (
+	// defaultMTU is the MTU of the Tailscale interface when it can't
+	// be found, as in userspace-networking mode. It matches
+	// tstun.DefaultMTU.
+	defaultMTU = 1280
+
+	// minMTU is the smallest MTU IPv6 allows. Below it, IPv6 over the
+	// Tailscale interface doesn't work at all.
+	minMTU = 1280
+
+	// wireguardOverhead is the most WireGuard adds to each packet:
+	// IPv6 and UDP headers, plus its own header and auth tag. Over
+	// IPv4 it's 20 bytes less.
+	wireguardOverhead = 40 + 8 + 32
+)
+
+// Check is a doctor.Check for MTU mismatches between the Tailscale
+// interface and the uplink.
+type Check struct{}
+
+func (Check) Name() string {
+	return "mtu"
+}
+
+// Categories implements doctor.Categorized.
+func (Check) Categories() []doctor.Category {
+	return []doctor.Category{doctor.CategoryNetwork, doctor.CategoryPerformance}
+}
+
+// Lightweight implements doctor.Lightweight: it only compares local
+// interface MTUs.
+func (Check) Lightweight() bool { return true }
+
+func (Check) Run(ctx context.Context, logf logger.Logf) error {
+	tsMTU := defaultMTU
+	if _, tsIf, err := interfaces.Tailscale(); err == nil && tsIf != nil {
+		tsMTU = tsIf.MTU
+		logf("Tailscale interface %s has MTU %d", tsIf.Name, tsMTU)
+	} else {
+		logf("no Tailscale interface found; assuming MTU %d", tsMTU)
+	}
+
+	st, err := interfaces.GetState()
+	if err != nil {
+		return err
+	}
+	iface, ok := st.Interface[st.DefaultRouteInterface]
+	if !ok || iface.Interface == nil {
+		logf("no default route interface; skipping uplink comparison")
+		return checkMTU(logf, tsMTU, 0)
+	}
+	logf("uplink %s has MTU %d", iface.Name, iface.MTU)
+	return checkMTU(logf, tsMTU, iface.MTU)
+}
+
+// checkMTU compares a Tailscale interface MTU of tsMTU with an uplink
+// MTU of uplinkMTU, or zero if unknown.
+func checkMTU(logf logger.Logf, tsMTU, uplinkMTU int) error {
+	if tsMTU < minMTU {
+		return doctor.WithRemediation(
+			fmt.Errorf("Tailscale MTU %d is below the IPv6 minimum of %d; IPv6 over Tailscale will fail", tsMTU, minMTU),
+			fmt.Sprintf("set TS_DEBUG_MTU=%d or unset it", suggestMTU(uplinkMTU)))
+	}
+	if uplinkMTU == 0 {
+		return nil
+	}
+	need := tsMTU + wireguardOverhead
+	if uplinkMTU >= need {
+		logf("uplink MTU %d carries %d-byte Tailscale packets unfragmented", uplinkMTU, tsMTU)
+		if best := suggestMTU(uplinkMTU); best > tsMTU {
+			logf("the Tailscale MTU could be raised to %d with TS_DEBUG_MTU=%d", best, best)
+		}
+		return nil
+	}
+	if uplinkMTU-wireguardOverhead < minMTU {
+		return fmt.Errorf("uplink MTU %d is below the %d needed to carry %d-byte Tailscale packets, and too small for any MTU IPv6 allows; full-size packets will be fragmented or dropped", uplinkMTU, need, tsMTU)
+	}
+	return doctor.WithRemediation(
+		fmt.Errorf("uplink MTU %d is below the %d needed to carry %d-byte Tailscale packets; full-size packets will be fragmented or dropped", uplinkMTU, need, tsMTU),
+		fmt.Sprintf("set TS_DEBUG_MTU=%d", suggestMTU(uplinkMTU)))
+}
+
+// suggestMTU returns the largest Tailscale MTU that fits an uplink MTU
+// of uplinkMTU, but never less than minMTU.
+func suggestMTU(uplinkMTU int) int {
+	if m := uplinkMTU - wireguardOverhead; m > minMTU {
+		return m
+	}
+	return minMTU
+}
@@ -0,0 +1,49 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mtu
+
+import "testing"
+
+func TestCheckMTU(t *testing.T) {
+	tests := []struct {
+		name      string
+		tsMTU     int
+		uplinkMTU int
+		wantErr   bool
+	}{
+		{"default_ethernet", 1280, 1500, false},
+		{"default_pppoe", 1280, 1492, false},
+		{"unknown_uplink", 1280, 0, false},
+		{"raised_fits", 1420, 1500, false},
+		{"raised_too_far", 1500, 1500, true},
+		{"small_uplink", 1280, 1300, true},
+		{"below_ipv6_min", 1200, 1500, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkMTU(t.Logf, tt.tsMTU, tt.uplinkMTU)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkMTU(%d, %d) = %v; want error: %v", tt.tsMTU, tt.uplinkMTU, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSuggestMTU(t *testing.T) {
+	tests := []struct {
+		uplinkMTU int
+		want      int
+	}{
+		{1500, 1420},
+		{1492, 1412},
+		{1300, 1280},
+		{0, 1280},
+	}
+	for _, tt := range tests {
+		if got := suggestMTU(tt.uplinkMTU); got != tt.want {
+			t.Errorf("suggestMTU(%d) = %d; want %d", tt.uplinkMTU, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package portrange provides a doctor.Check that verifies that STUN
+// servers can be reached from ports of the UDPPortRange pref, as a
+// strict egress firewall might only allow some of them.
+package portrange
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"time"
+
+	"tailscale.com/doctor"
+	"tailscale.com/net/stun"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/logger"
+	"tailscale.com/types/preftype"
+)
+
+const (
+	// maxServers is the most STUN servers, each in a different DERP
+	// region, that each sampled port sends to.
+	maxServers = 3
+
+	// replyTimeout is how long each sampled port waits for replies.
+	replyTimeout = 2 * time.Second
+)
+
+// Check is a doctor.Check that sends STUN requests from a sample of the
+// ports of a port range. It does nothing if the range is unset.
+type Check struct {
+	// Range is the port range to check.
+	Range preftype.PortRange
+
+	// Current is the port tailscaled's own socket is bound to, which
+	// is skipped as it can't be bound again.
+	Current uint16
+
+	// DERPMap is the DERP map whose STUN servers are sent to.
+	DERPMap *tailcfg.DERPMap
+
+	// Resolver, if non-nil, is used for DNS lookups instead of
+	// net.DefaultResolver.
+	Resolver *net.Resolver
+}
+
+func (Check) Name() string {
+	return "udp-port-range"
+}
+
+// Categories implements doctor.Categorized.
+func (Check) Categories() []doctor.Category {
+	return []doctor.Category{doctor.CategoryNetwork, doctor.CategoryFirewall}
+}
+
+func (c Check) Run(ctx context.Context, logf logger.Logf) error {
+	if c.Range.IsZero() {
+		logf("no UDP port range set; skipping")
+		return nil
+	}
+	if c.DERPMap == nil {
+		logf("no DERP map; skipping")
+		return nil
+	}
+	servers := c.stunServers(ctx, logf)
+	if len(servers) == 0 {
+		return errors.New("no IPv4 STUN servers in the DERP map")
+	}
+
+	ports := samplePorts(c.Range, c.Current)
+	if len(ports) == 0 {
+		logf("the only port, %d, is in use by tailscaled; see 'tailscale netcheck' for its reachability", c.Current)
+		return nil
+	}
+	var checked, reachable int
+	for _, port := range ports {
+		pc, err := net.ListenPacket("udp4", ":"+strconv.Itoa(int(port)))
+		if err != nil {
+			logf("port %d: %v", port, err)
+			continue
+		}
+		checked++
+		got, err := probe(ctx, pc, servers)
+		pc.Close()
+		switch {
+		case err != nil:
+			logf("port %d: %v", port, err)
+		case len(got) == 0:
+			logf("port %d: no STUN replies; blocked?", port)
+		default:
+			reachable++
+			logf("port %d: STUN replies from %v", port, got)
+		}
+	}
+	switch {
+	case checked == 0:
+		return fmt.Errorf("could not bind any sampled port of range %v", c.Range)
+	case reachable == 0:
+		return doctor.WithRemediation(
+			fmt.Errorf("no STUN replies to any sampled port of range %v; an egress firewall may block it", c.Range),
+			fmt.Sprintf("allow outbound UDP from ports %v in the firewall", c.Range))
+	case reachable < checked:
+		logf("%d of %d sampled ports got STUN replies", reachable, checked)
+	}
+	return nil
+}
+
+// stunServer is a STUN server to probe.
+type stunServer struct {
+	region string
+	addr   netip.AddrPort
+}
+
+func (s stunServer) String() string {
+	return s.region
+}
+
+// stunServers returns the IPv4 STUN server of each of the first
+// maxServers DERP regions that have one.
+func (c Check) stunServers(ctx context.Context, logf logger.Logf) []stunServer {
+	res := c.Resolver
+	if res == nil {
+		res = net.DefaultResolver
+	}
+	var ret []stunServer
+	for _, id := range c.DERPMap.RegionIDs() {
+		if len(ret) == maxServers {
+			break
+		}
+		r := c.DERPMap.Regions[id]
+		for _, n := range r.Nodes {
+			if n.STUNPort < 0 || n.IPv4 == "none" {
+				continue
+			}
+			port := n.STUNPort
+			if port == 0 {
+				port = 3478
+			}
+			ip, err := netip.ParseAddr(n.IPv4)
+			if err != nil {
+				ips, err := res.LookupNetIP(ctx, "ip4", n.HostName)
+				if err != nil || len(ips) == 0 {
+					logf("region %d: looking up %s: %v", id, n.HostName, err)
+					continue
+				}
+				ip = ips[0].Unmap()
+			}
+			name := r.RegionCode
+			if name == "" {
+				name = strconv.Itoa(id)
+			}
+			ret = append(ret, stunServer{name, netip.AddrPortFrom(ip, uint16(port))})
+			break
+		}
+	}
+	return ret
+}
+
+// probe sends a STUN request from pc to each of servers and returns
+// those that replied before replyTimeout.
+func probe(ctx context.Context, pc net.PacketConn, servers []stunServer) ([]stunServer, error) {
+	txs := make(map[stun.TxID]stunServer, len(servers))
+	for _, s := range servers {
+		tx := stun.NewTxID()
+		txs[tx] = s
+		if _, err := pc.WriteTo(stun.Request(tx), net.UDPAddrFromAddrPort(s.addr)); err != nil {
+			return nil, err
+		}
+	}
+	deadline := time.Now().Add(replyTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	pc.SetReadDeadline(deadline)
+
+	var got []stunServer
+	buf := make([]byte, 1500)
+	for len(txs) > 0 {
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				break
+			}
+			return got, err
+		}
+		tx, _, err := stun.ParseResponse(buf[:n])
+		if err != nil {
+			continue
+		}
+		if s, ok := txs[tx]; ok {
+			delete(txs, tx)
+			got = append(got, s)
+		}
+	}
+	return got, nil
+}
+
+// samplePorts returns the ports of r to check: its first, middle and
+// last ports, without skip.
+func samplePorts(r preftype.PortRange, skip uint16) []uint16 {
+	var ret []uint16
+	for _, p := range []uint16{r.First, r.First + (r.Last-r.First)/2, r.Last} {
+		if p == skip || (len(ret) > 0 && ret[len(ret)-1] == p) {
+			continue
+		}
+		ret = append(ret, p)
+	}
+	return ret
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package portrange
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+
+	"tailscale.com/net/stun/stuntest"
+	"tailscale.com/types/preftype"
+)
+
+func TestSamplePorts(t *testing.T) {
+	tests := []struct {
+		r    preftype.PortRange
+		skip uint16
+		want []uint16
+	}{
+		{preftype.PortRange{First: 41641, Last: 41641}, 0, []uint16{41641}},
+		{preftype.PortRange{First: 41641, Last: 41641}, 41641, nil},
+		{preftype.PortRange{First: 40000, Last: 40001}, 0, []uint16{40000, 40001}},
+		{preftype.PortRange{First: 40000, Last: 40001}, 40000, []uint16{40001}},
+		{preftype.PortRange{First: 40000, Last: 40100}, 0, []uint16{40000, 40050, 40100}},
+		{preftype.PortRange{First: 40000, Last: 40100}, 40050, []uint16{40000, 40100}},
+	}
+	for _, tt := range tests {
+		got := samplePorts(tt.r, tt.skip)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("samplePorts(%v, %d) = %v; want %v", tt.r, tt.skip, got, tt.want)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	stunAddr, cleanup := stuntest.Serve(t)
+	defer cleanup()
+
+	// Find a free port to use as the range.
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := uint16(pc.LocalAddr().(*net.UDPAddr).Port)
+	pc.Close()
+
+	c := Check{
+		Range:   preftype.PortRange{First: port, Last: port},
+		DERPMap: stuntest.DERPMapOf(stunAddr.String()),
+	}
+	if err := c.Run(context.Background(), t.Logf); err != nil {
+		t.Errorf("Run: %v", err)
+	}
+}
@@ -0,0 +1,366 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package preflight provides doctor.Checks that validate the environment
+// tailscaled is about to run in: that it can create its TUN device,
+// write its state, bind its UDP port and socket, that no other
+// tailscaled is running, and that the sysctls it depends on are set.
+// They're run by "tailscaled --preflight" before it starts networking,
+// such as from container entrypoints, and from the LocalAPI.
+package preflight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/doctor"
+	"tailscale.com/types/logger"
+)
+
+// Config is the tailscaled configuration to validate the environment
+// for.
+type Config struct {
+	// TUNName is as tailscaled's --tun flag: a TUN device name, the
+	// string "userspace-networking", or a comma-separated list of
+	// either.
+	TUNName string
+	// StatePath is as tailscaled's --state flag. Only file paths are
+	// checked, not Kubernetes secrets or other stores.
+	StatePath string
+	// StateDir is as tailscaled's --statedir flag, or empty.
+	StateDir string
+	// SocketPath is the path of the LocalAPI unix socket, or empty.
+	SocketPath string
+	// Port is the UDP port to listen on, or zero if it's chosen
+	// automatically.
+	Port uint16
+	// Forwarding is whether the node forwards traffic for others, as
+	// a subnet router or exit node, which needs IP forwarding.
+	Forwarding bool
+	// Running is whether tailscaled is already running with this
+	// config, as when the checks are run from the LocalAPI, so that
+	// its own socket, port and process aren't reported as conflicts.
+	Running bool
+}
+
+// Paths of the system files the checks read, for tests.
+var (
+	devNetTun   = "/dev/net/tun"
+	procPath    = "/proc"
+	procSysPath = "/proc/sys"
+)
+
+// Checks returns the preflight checks for cfg.
+func Checks(cfg Config) []doctor.Check {
+	return []doctor.Check{
+		doctor.CheckFunc("tun", cfg.checkTUN),
+		doctor.CheckFunc("state-dir", cfg.checkStateDir),
+		doctor.CheckFunc("other-tailscaled", cfg.checkOtherTailscaled),
+		doctor.CheckFunc("sysctls", cfg.checkSysctls),
+		doctor.CheckFunc("udp-port", cfg.checkPort),
+	}
+}
+
+// Run runs the preflight checks for cfg, and only them, returning the
+// report.
+func Run(ctx context.Context, cfg Config) *apitype.PreflightReport {
+	checks := Checks(cfg)
+	var names []string
+	for _, c := range checks {
+		names = append(names, c.Name())
+	}
+	checks = append(checks, doctor.WithOnly(names...))
+	rep := &apitype.PreflightReport{OK: true}
+	for _, r := range doctor.RunChecksResults(ctx, checks...) {
+		cr := apitype.DoctorCheckResult{
+			Name:        r.Name,
+			Severity:    string(r.Severity),
+			Summary:     r.Summary,
+			Detail:      r.Detail,
+			Log:         r.Log,
+			Skipped:     r.Skipped,
+			Remediation: r.Remediation,
+		}
+		if r.Err != nil {
+			cr.Error = r.Err.Error()
+			rep.OK = false
+		}
+		rep.Checks = append(rep.Checks, cr)
+	}
+	return rep
+}
+
+// needsTUN reports whether cfg uses a TUN (or TAP) device.
+func (cfg Config) needsTUN() bool {
+	for _, name := range strings.Split(cfg.TUNName, ",") {
+		if name != "" && name != "userspace-networking" {
+			return true
+		}
+	}
+	return false
+}
+
+func (cfg Config) checkTUN(ctx context.Context, logf logger.Logf) error {
+	if !cfg.needsTUN() {
+		logf("userspace networking; no TUN device needed")
+		return nil
+	}
+	if runtime.GOOS != "linux" {
+		logf("TUN device %q; not checked on %s", cfg.TUNName, runtime.GOOS)
+		return nil
+	}
+	f, err := os.OpenFile(devNetTun, os.O_RDWR, 0)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return doctor.WithRemediation(
+			fmt.Errorf("%s doesn't exist; TUN device %q can't be created", devNetTun, cfg.TUNName),
+			"load the tun kernel module, or in a container pass it the /dev/net/tun device, or run with --tun=userspace-networking")
+	case errors.Is(err, fs.ErrPermission):
+		return doctor.WithRemediation(
+			fmt.Errorf("can't open %s: %v", devNetTun, err),
+			"run tailscaled as root or with CAP_NET_ADMIN, or with --tun=userspace-networking")
+	case err != nil:
+		return fmt.Errorf("can't open %s: %w", devNetTun, err)
+	}
+	defer f.Close()
+	if cfg.Running {
+		logf("%s can be opened; TUN device %q is this tailscaled's", devNetTun, cfg.TUNName)
+		return nil
+	}
+	// Anyone can usually open it; it's creating a device that takes
+	// privileges. Create a throwaway one rather than cfg.TUNName, which
+	// may be in use.
+	if err := createTUN(f); err != nil {
+		if errors.Is(err, fs.ErrPermission) {
+			return doctor.WithRemediation(
+				fmt.Errorf("can't create TUN device %q: %v", cfg.TUNName, err),
+				"run tailscaled as root or with CAP_NET_ADMIN, or with --tun=userspace-networking")
+		}
+		return fmt.Errorf("can't create TUN device %q: %w", cfg.TUNName, err)
+	}
+	logf("a TUN device can be created for %q", cfg.TUNName)
+	return nil
+}
+
+// stateDirs returns the directories tailscaled writes its state to.
+func (cfg Config) stateDirs() []string {
+	var dirs []string
+	if cfg.StateDir != "" {
+		dirs = append(dirs, cfg.StateDir)
+	}
+	if isFilePath(cfg.StatePath) {
+		if dir := filepath.Dir(cfg.StatePath); dir != cfg.StateDir {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// isFilePath reports whether the --state value p is a file path, rather
+// than empty or another kind of store, such as "mem:" or
+// "kube:<secret>".
+func isFilePath(p string) bool {
+	if p == "" {
+		return false
+	}
+	if filepath.IsAbs(p) {
+		return true
+	}
+	if i := strings.Index(p, ":"); i > 1 {
+		// A store prefix; a single letter is a Windows drive.
+		return false
+	}
+	return true
+}
+
+func (cfg Config) checkStateDir(ctx context.Context, logf logger.Logf) error {
+	dirs := cfg.stateDirs()
+	if len(dirs) == 0 {
+		logf("state isn't stored in files (--state=%q)", cfg.StatePath)
+		return nil
+	}
+	for _, dir := range dirs {
+		existing, err := checkWritableDir(dir)
+		if err != nil {
+			return doctor.WithRemediation(err,
+				fmt.Sprintf("make %s writable by the user tailscaled runs as, such as by mounting a writable volume there", existing))
+		}
+		if existing != dir {
+			logf("%s doesn't exist yet; %s, where it'll be created, is writable", dir, existing)
+		} else {
+			logf("%s is writable", dir)
+		}
+	}
+	if isFilePath(cfg.StatePath) {
+		f, err := os.OpenFile(cfg.StatePath, os.O_RDWR, 0)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			logf("%s doesn't exist yet; it'll be created", cfg.StatePath)
+		case err != nil:
+			return doctor.WithRemediation(
+				fmt.Errorf("state file %s can't be read and written: %v", cfg.StatePath, err),
+				fmt.Sprintf("fix the ownership or permissions of %s", cfg.StatePath))
+		default:
+			f.Close()
+			logf("%s can be read and written", cfg.StatePath)
+		}
+	}
+	return nil
+}
+
+// checkWritableDir returns an error if files can't be written to dir
+// or, if it doesn't exist yet, to existing, the closest directory above
+// it that does, where tailscaled would create it. It creates nothing
+// but a temporary file, which it removes, so that a mistyped path isn't
+// left behind.
+func checkWritableDir(dir string) (existing string, err error) {
+	existing = dir
+	for {
+		fi, err := os.Stat(existing)
+		if err == nil {
+			if !fi.IsDir() {
+				return existing, fmt.Errorf("can't create state directory %s: %s isn't a directory", dir, existing)
+			}
+			break
+		}
+		if !errors.Is(err, fs.ErrNotExist) || filepath.Dir(existing) == existing {
+			return existing, fmt.Errorf("can't create state directory %s: %w", dir, err)
+		}
+		existing = filepath.Dir(existing)
+	}
+	f, err := os.CreateTemp(existing, ".preflight-*")
+	if err != nil {
+		if existing != dir {
+			return existing, fmt.Errorf("state directory %s can't be created, as %s isn't writable: %w", dir, existing, err)
+		}
+		return existing, fmt.Errorf("state directory %s isn't writable: %w", dir, err)
+	}
+	f.Close()
+	return existing, os.Remove(f.Name())
+}
+
+func (cfg Config) checkOtherTailscaled(ctx context.Context, logf logger.Logf) error {
+	if runtime.GOOS == "linux" {
+		pids, err := otherTailscaleds()
+		if err != nil {
+			logf("can't list processes: %v", err)
+		} else if len(pids) > 0 {
+			return doctor.WithRemediation(
+				fmt.Errorf("another tailscaled is running (pid %s); they'd fight over the same network configuration", joinInts(pids)),
+				"stop the other tailscaled first")
+		}
+	}
+	if cfg.SocketPath == "" || cfg.Running || runtime.GOOS == "windows" {
+		return nil
+	}
+	c, err := net.Dial("unix", cfg.SocketPath)
+	if err != nil {
+		logf("nothing is listening on %s", cfg.SocketPath)
+		return nil
+	}
+	c.Close()
+	return doctor.WithRemediation(
+		fmt.Errorf("something is already listening on %s", cfg.SocketPath),
+		"stop the tailscaled listening there, or use another --socket")
+}
+
+// otherTailscaleds returns the pids of the tailscaled processes other
+// than this one, per procPath.
+func otherTailscaleds() ([]int, error) {
+	ents, err := os.ReadDir(procPath)
+	if err != nil {
+		return nil, err
+	}
+	self := os.Getpid()
+	var pids []int
+	for _, e := range ents {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil || pid == self {
+			continue
+		}
+		comm, err := os.ReadFile(filepath.Join(procPath, e.Name(), "comm"))
+		if err == nil && strings.TrimSpace(string(comm)) == "tailscaled" {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
+
+func joinInts(v []int) string {
+	s := make([]string, len(v))
+	for i, n := range v {
+		s[i] = strconv.Itoa(n)
+	}
+	return strings.Join(s, ", ")
+}
+
+func (cfg Config) checkSysctls(ctx context.Context, logf logger.Logf) error {
+	if runtime.GOOS != "linux" {
+		logf("no sysctls to check on %s", runtime.GOOS)
+		return nil
+	}
+	var off []string
+	for _, name := range []string{"net.ipv4.ip_forward", "net.ipv6.conf.all.forwarding"} {
+		v, err := readSysctl(name)
+		if err != nil {
+			logf("%s: %v", name, err)
+			continue
+		}
+		logf("%s = %s", name, v)
+		if v == "0" {
+			off = append(off, name)
+		}
+	}
+	if v, err := readSysctl("net.ipv6.conf.all.disable_ipv6"); err == nil && v != "0" {
+		logf("net.ipv6.conf.all.disable_ipv6 = %s", v)
+		doctor.Report(ctx, doctor.SeverityWarning, "IPv6 is disabled; the node's Tailscale IPv6 address won't work", nil)
+		doctor.Remediate(ctx, "set net.ipv6.conf.all.disable_ipv6=0")
+	}
+	if cfg.Forwarding && len(off) > 0 {
+		for _, name := range off {
+			doctor.Remediate(ctx, "set "+name+"=1")
+		}
+		return fmt.Errorf("%s off; traffic for advertised routes or as an exit node won't be forwarded", strings.Join(off, " and "))
+	}
+	return nil
+}
+
+// readSysctl returns the value of the named sysctl, such as
+// "net.ipv4.ip_forward", per procSysPath.
+func readSysctl(name string) (string, error) {
+	b, err := os.ReadFile(filepath.Join(procSysPath, strings.ReplaceAll(name, ".", "/")))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func (cfg Config) checkPort(ctx context.Context, logf logger.Logf) error {
+	switch {
+	case cfg.Port == 0:
+		logf("UDP port chosen automatically")
+		return nil
+	case cfg.Running:
+		logf("UDP port %d is in use by this tailscaled", cfg.Port)
+		return nil
+	}
+	pc, err := net.ListenPacket("udp", ":"+strconv.Itoa(int(cfg.Port)))
+	if err != nil {
+		return doctor.WithRemediation(
+			fmt.Errorf("can't listen on UDP port %d: %v", cfg.Port, err),
+			"stop what's using the port, or choose another with --port (0 picks one automatically)")
+	}
+	pc.Close()
+	logf("UDP port %d is free", cfg.Port)
+	return nil
+}
@@ -0,0 +1,24 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package preflight
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// createTUN creates a TUN device with f, an open /dev/net/tun, which
+// unlike opening it needs CAP_NET_ADMIN. The kernel picks the device's
+// name, and removes it when f is closed.
+func createTUN(f *os.File) error {
+	ifr, err := unix.NewIfreq("")
+	if err != nil {
+		return err
+	}
+	// Flags are stored as a uint16 in the ifreq union.
+	ifr.SetUint16(unix.IFF_TUN | unix.IFF_NO_PI)
+	return unix.IoctlIfreq(int(f.Fd()), unix.TUNSETIFF, ifr)
+}
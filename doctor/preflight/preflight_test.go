@@ -0,0 +1,132 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package preflight
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+func TestIsFilePath(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"", false},
+		{"/var/lib/tailscale/tailscaled.state", true},
+		{"tailscaled.state", true},
+		{"mem:", false},
+		{"kube:ts-state", false},
+		{"arn:aws:ssm:us-east-1:123456789012:parameter/ts", false},
+	}
+	for _, tt := range tests {
+		if got := isFilePath(tt.in); got != tt.want {
+			t.Errorf("isFilePath(%q) = %v; want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestOtherTailscaleds(t *testing.T) {
+	dir := t.TempDir()
+	defer func(old string) { procPath = old }(procPath)
+	procPath = dir
+	mustWrite := func(name, contents string) {
+		t.Helper()
+		name = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustWrite("1/comm", "systemd\n")
+	mustWrite("42/comm", "tailscaled\n")
+	mustWrite("43/comm", "tailscale\n")
+	mustWrite(filepath.Join(strconv.Itoa(os.Getpid()), "comm"), "tailscaled\n")
+	mustWrite("self/comm", "tailscaled\n")
+
+	got, err := otherTailscaleds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{42}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	defer func(old string) { procSysPath = old }(procSysPath)
+	procSysPath = filepath.Join(dir, "sys")
+	for name, v := range map[string]string{
+		"net/ipv4/ip_forward":            "0",
+		"net/ipv6/conf/all/forwarding":   "1",
+		"net/ipv6/conf/all/disable_ipv6": "0",
+	} {
+		p := filepath.Join(procSysPath, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(v+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pc, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	usedPort := uint16(pc.LocalAddr().(*net.UDPAddr).Port)
+
+	cfg := Config{
+		TUNName:    "userspace-networking",
+		StatePath:  filepath.Join(dir, "state", "tailscaled.state"),
+		Port:       usedPort,
+		Forwarding: true,
+	}
+	rep := Run(context.Background(), cfg)
+	if rep.OK {
+		t.Errorf("OK with port %d in use", usedPort)
+	}
+	got := map[string]string{}
+	for _, r := range rep.Checks {
+		got[r.Name] = r.Error
+	}
+	if got["state-dir"] != "" {
+		t.Errorf("state-dir failed: %v", got["state-dir"])
+	}
+	if _, err := os.Stat(filepath.Dir(cfg.StatePath)); !os.IsNotExist(err) {
+		t.Errorf("state-dir created %s: %v", filepath.Dir(cfg.StatePath), err)
+	}
+	if got["tun"] != "" {
+		t.Errorf("tun failed with userspace networking: %v", got["tun"])
+	}
+	if got["udp-port"] == "" {
+		t.Errorf("udp-port passed with port %d in use", usedPort)
+	}
+	if runtime.GOOS == "linux" && got["sysctls"] == "" {
+		t.Errorf("sysctls passed with IPv4 forwarding off")
+	}
+
+	cfg.Running = true
+	cfg.Forwarding = false
+	rep = Run(context.Background(), cfg)
+	for _, r := range rep.Checks {
+		if r.Name == "udp-port" && r.Error != "" {
+			t.Errorf("udp-port failed for the running tailscaled's own port: %v", r.Error)
+		}
+		if r.Name == "sysctls" && r.Error != "" {
+			t.Errorf("sysctls failed without forwarding: %v", r.Error)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package doctor
+
+import (
+	"context"
+	"sync"
+
+	"tailscale.com/types/logger"
+)
+
+// Progress is an update on the progress of a check, as passed to the
+// function given to WithProgress.
+type Progress struct {
+	// Check is the name of the check.
+	Check string
+	// Done and Total are how many steps of the check are done and how
+	// many there are in all, as reported with ReportProgress. Total
+	// is zero if the check didn't say.
+	Done, Total int
+	// What is what the check is doing, such as "probing region 3
+	// (nyc)", or empty.
+	What string
+	// Finished is whether the check finished or was skipped. It's
+	// set on the last update of each check.
+	Finished bool
+}
+
+// ReportProgress records the progress of the check whose Run was passed
+// ctx: done of total steps, such as DERP nodes probed, and what it's
+// doing now, such as "probing region 3 (nyc)", for callers that show
+// it (see WithProgress), so that a check that takes a while doesn't
+// appear hung. total is zero if unknown. Like Report, it does nothing
+// if ctx didn't come from RunChecks or RunChecksResults.
+func ReportProgress(ctx context.Context, done, total int, what string) {
+	r, ok := ctx.Value(recorderKey{}).(*recorder)
+	if !ok || r.progress == nil {
+		return
+	}
+	r.progress(Progress{Check: r.check, Done: done, Total: total, What: what})
+}
+
+// WithProgress returns a pseudo-check that, passed to RunChecks or
+// RunChecksResults along with the other checks, calls f as each check
+// starts, reports progress with ReportProgress and finishes, such as
+// so that a CLI can show a spinner or percentage. A check that's
+// skipped only finishes. Calls to f are serialized, and What is
+// redacted as per WithRedaction. If passed more than once, each f is
+// called. It does nothing when run itself.
+func WithProgress(f func(Progress)) Check {
+	return withProgress{f}
+}
+
+// withProgress is the Check returned by WithProgress.
+type withProgress struct {
+	f func(Progress)
+}
+
+func (withProgress) Name() string                           { return "" }
+func (withProgress) Run(context.Context, logger.Logf) error { return nil }
+
+// progressFunc returns the function to pass the progress of checks to,
+// for the WithProgress pseudo-checks in checks, or nil if there are
+// none. It serializes calls and redacts What with rd.
+func progressFunc(checks []Check, rd *redactor) func(Progress) {
+	var fs []func(Progress)
+	for _, c := range checks {
+		if c, ok := c.(withProgress); ok && c.f != nil {
+			fs = append(fs, c.f)
+		}
+	}
+	if len(fs) == 0 {
+		return nil
+	}
+	var mu sync.Mutex
+	return func(p Progress) {
+		p.What = rd.redact(p.What)
+		mu.Lock()
+		defer mu.Unlock()
+		for _, f := range fs {
+			f(p)
+		}
+	}
+}
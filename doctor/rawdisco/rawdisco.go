@@ -0,0 +1,166 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package rawdisco provides a doctor.Check that reports the network
+// namespace, VRFs, bonds and bridges tailscaled runs in, and whether
+// its raw socket disco listeners cover all of them.
+package rawdisco
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"tailscale.com/doctor"
+	"tailscale.com/net/interfaces"
+	"tailscale.com/net/netaddr"
+	"tailscale.com/types/logger"
+)
+
+// Check is a doctor.Check that reports on the raw disco listeners and
+// the L3 topology they listen in.
+//
+// On Linux, magicsock receives disco packets with raw sockets, so that
+// they arrive even when a host firewall drops them before the UDP
+// socket. A raw socket outside any VRF only sees the packets of
+// interfaces in a VRF if the raw_l3mdev_accept sysctl is on; otherwise
+// magicsock listens in each VRF too. Packets arriving on the members
+// of a bond, bridge or team are received as the master device's, in
+// the master's VRF.
+type Check struct {
+	// Listeners are the raw disco listeners, as returned by
+	// magicsock.Conn.RawDiscoListeners.
+	Listeners []string
+}
+
+func (Check) Name() string {
+	return "raw-disco"
+}
+
+// Categories implements doctor.Categorized.
+func (Check) Categories() []doctor.Category {
+	return []doctor.Category{doctor.CategoryNetwork, doctor.CategoryPlatform}
+}
+
+// Lightweight implements doctor.Lightweight: it only inspects local
+// listeners.
+func (Check) Lightweight() bool { return true }
+
+// Platforms implements doctor.Platformer.
+func (Check) Platforms() doctor.Requirements {
+	return doctor.Requirements{OS: []string{"linux"}}
+}
+
+func (c Check) Run(ctx context.Context, logf logger.Logf) error {
+	topo, err := interfaces.GetL3Topology()
+	if err != nil {
+		return err
+	}
+	switch v, ok := topo.InitNetns.Get(); {
+	case !ok:
+		logf("network namespace: %s", orUnknown(topo.Netns))
+	case v:
+		logf("network namespace: %s (the host's)", topo.Netns)
+	default:
+		logf("network namespace: %s (not the host's)", topo.Netns)
+	}
+	for _, vrf := range topo.VRFs {
+		logf("VRF %s (index %d): members %q", vrf.Name, vrf.Index, vrf.Members)
+	}
+	if len(topo.VRFs) > 0 {
+		logf("raw_l3mdev_accept: %v", topo.RawL3mdevAccept)
+	}
+	if len(c.Listeners) == 0 {
+		logf("no raw disco listeners; disco packets are only received on tailscaled's UDP sockets")
+	} else {
+		logf("raw disco listeners: %s", strings.Join(c.Listeners, ", "))
+	}
+	for _, m := range topo.L2Masters {
+		if vrf := topo.VRFOf(m.Name); vrf != "" {
+			logf("%v, in VRF %s", m, vrf)
+		} else {
+			logf("%v", m)
+		}
+	}
+	if missing := uncoveredVRFs(topo, c.Listeners); len(missing) > 0 {
+		return fmt.Errorf("no raw disco listener in VRF(s) %s; disco packets arriving there are only received if they reach tailscaled's UDP socket", strings.Join(missing, ", "))
+	}
+	if bad := addressedMembers(topo, interfaceAddrs); len(bad) > 0 {
+		doctor.Report(ctx, doctor.SeverityWarning, "IP addresses on enslaved interface(s) "+strings.Join(bad, ", ")+"; packets to them are received on the master device, not there", bad)
+	}
+	return nil
+}
+
+// addressedMembers returns the members of topo's L2 master devices that
+// have IP addresses other than link-local ones, per addrs, as "eth0 (of
+// bond0)". Such addresses are a misconfiguration: the member passes
+// what it receives to its master, so they appear to work for sending
+// only.
+func addressedMembers(topo *interfaces.L3Topology, addrs func(ifName string) []netip.Prefix) []string {
+	var ret []string
+	for _, m := range topo.L2Masters {
+		for _, mem := range m.Members {
+			for _, pfx := range addrs(mem) {
+				if !pfx.Addr().IsLinkLocalUnicast() {
+					ret = append(ret, fmt.Sprintf("%s (of %s)", mem, m.Name))
+					break
+				}
+			}
+		}
+	}
+	return ret
+}
+
+// interfaceAddrs returns the addresses of the named interface, or nil if
+// it can't be found.
+func interfaceAddrs(ifName string) []netip.Prefix {
+	ifc, err := net.InterfaceByName(ifName)
+	if err != nil {
+		return nil
+	}
+	addrs, err := ifc.Addrs()
+	if err != nil {
+		return nil
+	}
+	var ret []netip.Prefix
+	for _, a := range addrs {
+		if ipn, ok := a.(*net.IPNet); ok {
+			if pfx, ok := netaddr.FromStdIPNet(ipn); ok {
+				ret = append(ret, pfx)
+			}
+		}
+	}
+	return ret
+}
+
+// uncoveredVRFs returns the VRFs of topo that no raw disco listener
+// receives packets from, given that there's at least one listener.
+func uncoveredVRFs(topo *interfaces.L3Topology, listeners []string) []string {
+	if len(listeners) == 0 || topo.RawL3mdevAccept {
+		return nil
+	}
+	var ret []string
+	for _, vrf := range topo.VRFs {
+		covered := false
+		for _, l := range listeners {
+			if strings.HasSuffix(l, " vrf "+vrf.Name) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			ret = append(ret, vrf.Name)
+		}
+	}
+	return ret
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
@@ -0,0 +1,56 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rawdisco
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"tailscale.com/net/interfaces"
+)
+
+func TestUncoveredVRFs(t *testing.T) {
+	vrfs := []interfaces.VRF{{Name: "blue"}, {Name: "red"}}
+	tests := []struct {
+		name      string
+		topo      interfaces.L3Topology
+		listeners []string
+		want      []string
+	}{
+		{"no-vrfs", interfaces.L3Topology{}, []string{"ip4"}, nil},
+		{"l3mdev-accept", interfaces.L3Topology{VRFs: vrfs, RawL3mdevAccept: true}, []string{"ip4"}, nil},
+		{"no-listeners", interfaces.L3Topology{VRFs: vrfs}, nil, nil},
+		{"one-missing", interfaces.L3Topology{VRFs: vrfs}, []string{"ip4", "ip4 vrf blue", "ip6"}, []string{"red"}},
+		{"all-covered", interfaces.L3Topology{VRFs: vrfs}, []string{"ip4", "ip4 vrf blue", "ip4 vrf red"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := uncoveredVRFs(&tt.topo, tt.listeners); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddressedMembers(t *testing.T) {
+	topo := &interfaces.L3Topology{
+		L2Masters: []interfaces.L2Master{
+			{Name: "bond0", Kind: "bond", Members: []string{"eth0", "eth1"}},
+			{Name: "br0", Kind: "bridge", Members: []string{"eth2"}},
+		},
+	}
+	addrs := map[string][]netip.Prefix{
+		"bond0": {netip.MustParsePrefix("192.168.1.10/24")},
+		"eth0":  {netip.MustParsePrefix("fe80::1/64")},
+		"eth1":  {netip.MustParsePrefix("fe80::2/64"), netip.MustParsePrefix("10.0.0.5/8")},
+		"eth2":  {netip.MustParsePrefix("172.16.0.1/16")},
+	}
+	got := addressedMembers(topo, func(ifName string) []netip.Prefix { return addrs[ifName] })
+	want := []string{"eth1 (of bond0)", "eth2 (of br0)"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package doctor
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strings"
+
+	"tailscale.com/types/logger"
+)
+
+// Redaction is how the output of checks is redacted before it's logged
+// or returned, so that it can be shared without revealing the network
+// it came from. The zero value redacts nothing.
+type Redaction struct {
+	// HashIPs replaces IP addresses with a short hash of them, the same
+	// for the same address within a run, so that lines about the same
+	// address can still be told apart from lines about another.
+	// Loopback, link-local, multicast and unspecified addresses are
+	// kept, as they say nothing about the network.
+	HashIPs bool
+	// KeepPrefixes keeps the network prefixes of routes, such as
+	// "10.0.0.0/8", when HashIPs is set. Host addresses, including
+	// single-address prefixes such as "10.1.2.3/32", are still hashed.
+	KeepPrefixes bool
+	// StripMACs replaces MAC addresses with "xx:xx:xx:xx:xx:xx".
+	StripMACs bool
+}
+
+// IsZero reports whether r redacts nothing.
+func (r Redaction) IsZero() bool { return r == Redaction{} }
+
+// String returns r in the form accepted by ParseRedaction.
+func (r Redaction) String() string {
+	var s []string
+	if r.HashIPs {
+		s = append(s, "ips")
+	}
+	if r.StripMACs {
+		s = append(s, "macs")
+	}
+	if r.KeepPrefixes {
+		s = append(s, "prefixes")
+	}
+	return strings.Join(s, ",")
+}
+
+// ParseRedaction parses a comma-separated list of what to redact:
+// "ips" to hash IP addresses, "macs" to strip MAC addresses and
+// "prefixes" to keep route prefixes while hashing IPs, or "all" for
+// "ips,macs". An empty string redacts nothing.
+func ParseRedaction(s string) (Redaction, error) {
+	var r Redaction
+	for _, f := range strings.Split(s, ",") {
+		switch strings.TrimSpace(f) {
+		case "":
+		case "all":
+			r.HashIPs = true
+			r.StripMACs = true
+		case "ips":
+			r.HashIPs = true
+		case "macs":
+			r.StripMACs = true
+		case "prefixes":
+			r.KeepPrefixes = true
+		default:
+			return Redaction{}, fmt.Errorf("unknown redaction %q; want ips, macs, prefixes or all", f)
+		}
+	}
+	if r.KeepPrefixes && !r.HashIPs {
+		return Redaction{}, errors.New(`redaction "prefixes" needs "ips"`)
+	}
+	return r, nil
+}
+
+// WithRedaction returns a pseudo-check that, passed to RunChecks or
+// RunChecksResults along with the other checks, redacts everything the
+// checks log, report and return per r before it's logged or returned.
+// It doesn't change which checks run, and does nothing when run itself.
+func WithRedaction(r Redaction) Check {
+	return withRedaction(r)
+}
+
+// withRedaction is the Check returned by WithRedaction.
+type withRedaction Redaction
+
+func (withRedaction) Name() string                           { return "" }
+func (withRedaction) Run(context.Context, logger.Logf) error { return nil }
+
+// redactor redacts the output of one run of checks.
+type redactor struct {
+	Redaction
+	salt [16]byte // so that hashes can't be reversed by hashing guesses
+}
+
+// newRedactor returns the redactor for the WithRedaction pseudo-checks
+// in checks, or nil if there are none or they redact nothing. If there
+// are several, everything any of them redacts is redacted.
+func newRedactor(checks []Check) *redactor {
+	var r Redaction
+	for _, c := range checks {
+		if c, ok := c.(withRedaction); ok {
+			r.HashIPs = r.HashIPs || c.HashIPs
+			r.KeepPrefixes = r.KeepPrefixes || c.KeepPrefixes
+			r.StripMACs = r.StripMACs || c.StripMACs
+		}
+	}
+	if r.IsZero() {
+		return nil
+	}
+	rd := &redactor{Redaction: r}
+	rand.Read(rd.salt[:])
+	return rd
+}
+
+var (
+	macRx = regexp.MustCompile(`\b[0-9A-Fa-f]{2}[:-][0-9A-Fa-f]{2}(?:[:-][0-9A-Fa-f]{2}){4}\b`)
+	// ipRx matches what might be an IPv4 or IPv6 address, with an
+	// optional IPv6 zone and prefix length; candidates are validated
+	// with netip.
+	ipRx = regexp.MustCompile(`(?:\b\d{1,3}(?:\.\d{1,3}){3}\b|[0-9A-Fa-f]*:[0-9A-Fa-f:.]*:[0-9A-Fa-f.]*(?:%[\w.-]+)?)(?:/\d{1,3}\b)?`)
+)
+
+// redact returns s redacted per rd. A nil rd returns s unchanged.
+func (rd *redactor) redact(s string) string {
+	if rd == nil {
+		return s
+	}
+	if rd.StripMACs {
+		s = macRx.ReplaceAllString(s, "xx:xx:xx:xx:xx:xx")
+	}
+	if rd.HashIPs {
+		s = ipRx.ReplaceAllStringFunc(s, rd.redactIP)
+	}
+	return s
+}
+
+// redactIP returns the replacement for s, a match of ipRx.
+func (rd *redactor) redactIP(s string) string {
+	// An IPv6 match can take the punctuation after the address, as in
+	// "via fd7a::1." or "fd7a::1:".
+	if t := strings.TrimRight(s, ".:"); t != s && !strings.HasSuffix(s, "::") {
+		return rd.redactIP(t) + s[len(t):]
+	}
+	addrStr, bits, hasBits := strings.Cut(s, "/")
+	ip, err := netip.ParseAddr(addrStr)
+	if err != nil {
+		return s
+	}
+	if hasBits && rd.KeepPrefixes {
+		if p, err := netip.ParsePrefix(s); err == nil && p.Bits() < ip.BitLen() && p.Masked().Addr() == ip {
+			return s
+		}
+	}
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
+		return s
+	}
+	h := sha256.New()
+	h.Write(rd.salt[:])
+	b, _ := ip.WithZone("").MarshalBinary()
+	h.Write(b)
+	fam := "ip4"
+	if ip.Is6() && !ip.Is4In6() {
+		fam = "ip6"
+	}
+	ret := fam + ":" + hex.EncodeToString(h.Sum(nil)[:4])
+	if hasBits {
+		ret += "/" + bits
+	}
+	return ret
+}
+
+// redactResult redacts what r holds that a check produced, other than
+// its log, which is redacted as it's logged, and its findings, which are
+// matched against the known issues.
+func (rd *redactor) redactResult(r *Result) {
+	if rd == nil {
+		return
+	}
+	r.Summary = rd.redact(r.Summary)
+	for i, step := range r.Remediation {
+		r.Remediation[i] = rd.redact(step)
+	}
+	if r.Err != nil {
+		if s := rd.redact(r.Err.Error()); s != r.Err.Error() {
+			r.Err = errors.New(s)
+		}
+	}
+	if r.Detail != nil {
+		if j, err := json.Marshal(r.Detail); err == nil {
+			r.Detail = json.RawMessage(rd.redact(string(j)))
+		} else {
+			r.Detail = nil
+		}
+	}
+}
@@ -0,0 +1,259 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package report renders the results of a doctor run as a report to
+// share, such as in a support ticket: HTML, to attach or open in a
+// browser, or Markdown, to paste. A report has a table summarizing the
+// checks, then a section for each with the steps to fix what it found
+// and, collapsed, what it reported and logged.
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+)
+
+// Report is a doctor run to render, along with where it ran.
+type Report struct {
+	// Host is the name of the node the checks ran on, if known.
+	Host string
+
+	// Version is the Tailscale version of the node, if known.
+	Version string
+
+	// Time is when the run started, if known.
+	Time time.Time
+
+	// Checks are the results of each check, in the order shown.
+	Checks []apitype.DoctorCheckResult
+}
+
+// Status returns the short status of r: "pass", "info", "warn", "FAIL"
+// or "skip".
+func Status(r apitype.DoctorCheckResult) string {
+	switch {
+	case r.Skipped != "" || r.Severity == "skipped":
+		return "skip"
+	case r.Error != "" || r.Severity == "error":
+		return "FAIL"
+	case r.Severity == "warning":
+		return "warn"
+	case r.Severity == "info":
+		return "info"
+	}
+	return "pass"
+}
+
+// Counts returns how many of res had each status, such as "12 pass,
+// 1 warn, 1 FAIL".
+func Counts(res []apitype.DoctorCheckResult) string {
+	counts := map[string]int{}
+	for _, r := range res {
+		counts[Status(r)]++
+	}
+	var parts []string
+	for _, status := range []string{"pass", "info", "warn", "FAIL", "skip"} {
+		if n := counts[status]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, status))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// HTML writes rep to w as a standalone HTML page.
+func HTML(w io.Writer, rep *Report) error {
+	return htmlTemplate.Execute(w, rep.view())
+}
+
+// Markdown writes rep to w as GitHub-flavored Markdown.
+func Markdown(w io.Writer, rep *Report) error {
+	v := rep.view()
+	var table strings.Builder
+	table.WriteString("| Check | Status | Summary |\n| --- | --- | --- |")
+	for _, c := range v.Checks {
+		fmt.Fprintf(&table, "\n| %s | %s | %s |", mdEscape(c.Name), c.Status, mdEscape(c.Summary))
+	}
+	blocks := []string{
+		"# " + mdEscape(v.Title),
+		mdEscape(v.Line),
+		table.String(),
+	}
+	for _, c := range v.Checks {
+		blocks = append(blocks, fmt.Sprintf("## %s (%s)", mdEscape(c.Name), c.Status))
+		if c.Summary != "" {
+			blocks = append(blocks, mdEscape(c.Summary))
+		}
+		if c.Error != "" {
+			blocks = append(blocks, "Error: "+mdEscape(c.Error))
+		}
+		if len(c.Remediation) > 0 {
+			var steps []string
+			for i, step := range c.Remediation {
+				steps = append(steps, fmt.Sprintf("%d. %s", i+1, mdEscape(step)))
+			}
+			blocks = append(blocks, "To fix:", strings.Join(steps, "\n"))
+		}
+		if c.Detail != "" {
+			blocks = append(blocks, mdDetails("Detail", "json", c.Detail))
+		}
+		if len(c.Log) > 0 {
+			blocks = append(blocks, mdDetails(fmt.Sprintf("Log (%d lines)", len(c.Log)), "", strings.Join(c.Log, "\n")))
+		}
+	}
+	_, err := io.WriteString(w, strings.Join(blocks, "\n\n")+"\n")
+	return err
+}
+
+// mdDetails returns a collapsed section titled summary, with text in a
+// code block of the language lang.
+func mdDetails(summary, lang, text string) string {
+	// The fence must be longer than any run of backticks in text.
+	fence := "```"
+	for strings.Contains(text, fence) {
+		fence += "`"
+	}
+	return fmt.Sprintf("<details><summary>%s</summary>\n\n%s%s\n%s\n%s\n\n</details>", summary, fence, lang, text, fence)
+}
+
+// mdEscape escapes the characters of s that Markdown would otherwise
+// take as formatting, and its newlines, so that it can go in a table
+// cell.
+func mdEscape(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		switch r {
+		case '\\', '`', '*', '_', '[', ']', '<', '>', '|', '#':
+			sb.WriteByte('\\')
+		case '\n':
+			sb.WriteString("<br>")
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// view is what the templates render.
+type view struct {
+	Title  string
+	Line   string // when the run started and the counts of each status
+	Checks []checkView
+}
+
+type checkView struct {
+	Name        string
+	Anchor      string
+	Status      string
+	Summary     string
+	Error       string // if not already the summary
+	Remediation []string
+	Detail      string // indented JSON
+	Log         []string
+}
+
+func (rep *Report) view() view {
+	v := view{Title: "Tailscale doctor report"}
+	if rep.Host != "" {
+		v.Title += " for " + rep.Host
+	}
+	if rep.Version != "" {
+		v.Title += " (" + rep.Version + ")"
+	}
+	v.Line = Counts(rep.Checks) + "."
+	if len(rep.Checks) == 0 {
+		v.Line = "No checks ran."
+	}
+	if !rep.Time.IsZero() {
+		v.Line = "Run at " + rep.Time.Format(time.RFC3339) + ": " + v.Line
+	}
+	for _, r := range rep.Checks {
+		c := checkView{
+			Name:        r.Name,
+			Anchor:      "check-" + r.Name,
+			Status:      Status(r),
+			Summary:     r.Summary,
+			Remediation: r.Remediation,
+			Log:         r.Log,
+		}
+		switch {
+		case c.Summary == "" && r.Error != "":
+			c.Summary = r.Error
+		case c.Summary == "":
+			c.Summary = r.Skipped
+		}
+		if r.Error != c.Summary {
+			c.Error = r.Error
+		}
+		if r.Detail != nil {
+			if b, err := json.MarshalIndent(r.Detail, "", "  "); err == nil {
+				c.Detail = string(b)
+			}
+		}
+		v.Checks = append(v.Checks, c)
+	}
+	return v
+}
+
+var htmlTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; max-width: 60em; margin: 2em auto; padding: 0 1em; color: #222; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; vertical-align: top; }
+pre { background: #f6f8fa; padding: 0.6em; overflow-x: auto; }
+.pass { color: #1a7f37; }
+.info { color: #0969da; }
+.warn { color: #9a6700; }
+.FAIL { color: #cf222e; font-weight: bold; }
+.skip { color: #6e7781; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Line}}</p>
+<table>
+<tr><th>Check</th><th>Status</th><th>Summary</th></tr>
+{{- range .Checks}}
+<tr><td><a href="#{{.Anchor}}">{{.Name}}</a></td><td class="{{.Status}}">{{.Status}}</td><td>{{.Summary}}</td></tr>
+{{- end}}
+</table>
+{{- range .Checks}}
+<h2 id="{{.Anchor}}">{{.Name}} <span class="{{.Status}}">({{.Status}})</span></h2>
+{{- if .Summary}}
+<p>{{.Summary}}</p>
+{{- end}}
+{{- if .Error}}
+<p>Error: {{.Error}}</p>
+{{- end}}
+{{- with .Remediation}}
+<p>To fix:</p>
+<ol>
+{{- range .}}
+<li>{{.}}</li>
+{{- end}}
+</ol>
+{{- end}}
+{{- with .Detail}}
+<details><summary>Detail</summary><pre>{{.}}</pre></details>
+{{- end}}
+{{- with .Log}}
+<details><summary>Log ({{len .}} lines)</summary><pre>
+{{- range .}}
+{{.}}
+{{- end}}
+</pre></details>
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
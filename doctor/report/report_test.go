@@ -0,0 +1,93 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package report
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+)
+
+var testReport = &Report{
+	Host:    "nas",
+	Version: "1.33.0",
+	Time:    time.Date(2022, 10, 16, 9, 0, 0, 0, time.UTC),
+	Checks: []apitype.DoctorCheckResult{
+		{Name: "mtu", Severity: "ok"},
+		{
+			Name:        "rp-filter",
+			Severity:    "error",
+			Summary:     "strict rp_filter on eth0",
+			Error:       "strict rp_filter on eth0",
+			Detail:      map[string]string{"Interface": "eth0"},
+			Log:         []string{"eth0: rp_filter=1", "uses `sysctl`"},
+			Remediation: []string{"set net.ipv4.conf.eth0.rp_filter=2"},
+		},
+		{Name: "winadapters", Severity: "skipped", Skipped: "only on windows"},
+		{Name: "ext-proxy", Severity: "warning", Summary: "<proxy> | slow"},
+	},
+}
+
+func TestCounts(t *testing.T) {
+	if got, want := Counts(testReport.Checks), "1 pass, 1 warn, 1 FAIL, 1 skip"; got != want {
+		t.Errorf("Counts = %q; want %q", got, want)
+	}
+}
+
+func TestHTML(t *testing.T) {
+	var buf bytes.Buffer
+	if err := HTML(&buf, testReport); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.String()
+	for _, want := range []string{
+		"<title>Tailscale doctor report for nas (1.33.0)</title>",
+		"<p>Run at 2022-10-16T09:00:00Z: 1 pass, 1 warn, 1 FAIL, 1 skip.</p>",
+		`<tr><td><a href="#check-rp-filter">rp-filter</a></td><td class="FAIL">FAIL</td><td>strict rp_filter on eth0</td></tr>`,
+		`<tr><td><a href="#check-winadapters">winadapters</a></td><td class="skip">skip</td><td>only on windows</td></tr>`,
+		`<h2 id="check-rp-filter">rp-filter <span class="FAIL">(FAIL)</span></h2>`,
+		"<li>set net.ipv4.conf.eth0.rp_filter=2</li>",
+		"<details><summary>Detail</summary><pre>{\n  &#34;Interface&#34;: &#34;eth0&#34;\n}</pre></details>",
+		"<details><summary>Log (2 lines)</summary><pre>\neth0: rp_filter=1\nuses `sysctl`\n</pre></details>",
+		"<td>&lt;proxy&gt; | slow</td>",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "Error:") {
+		t.Errorf("error repeated after the summary:\n%s", got)
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Markdown(&buf, testReport); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.String()
+	for _, want := range []string{
+		"# Tailscale doctor report for nas (1.33.0)\n\nRun at 2022-10-16T09:00:00Z: 1 pass, 1 warn, 1 FAIL, 1 skip.\n",
+		"\n\n| Check | Status | Summary |\n| --- | --- | --- |\n| mtu | pass |  |\n| rp-filter | FAIL | strict rp\\_filter on eth0 |\n",
+		"| ext-proxy | warn | \\<proxy\\> \\| slow |\n",
+		"\n\n## mtu (pass)\n\n## rp-filter (FAIL)\n\nstrict rp\\_filter on eth0\n\nTo fix:\n\n1. set net.ipv4.conf.eth0.rp\\_filter=2\n\n",
+		"<details><summary>Detail</summary>\n\n```json\n{\n  \"Interface\": \"eth0\"\n}\n```\n\n</details>\n",
+		"<details><summary>Log (2 lines)</summary>\n\n```\neth0: rp_filter=1\nuses `sysctl`\n```\n\n</details>\n\n## winadapters (skip)",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+}
+
+func TestMarkdownFence(t *testing.T) {
+	got := mdDetails("Log", "", "before\n```\nafter")
+	if want := "<details><summary>Log</summary>\n\n````\nbefore\n```\nafter\n````\n\n</details>"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
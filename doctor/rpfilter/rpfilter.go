@@ -0,0 +1,137 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package rpfilter provides a doctor.Check that reports Linux's
+// reverse path filtering mode and flags it when it's likely dropping
+// forwarded or exit node traffic.
+//
+// With rp_filter in strict mode, the kernel drops packets that arrive
+// on an interface other than the one it would route replies out of.
+// Subnet routers, exit nodes and clients of exit nodes all route
+// asymmetrically, so strict mode silently drops some of their traffic.
+package rpfilter
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"tailscale.com/doctor"
+	"tailscale.com/net/interfaces"
+	"tailscale.com/types/logger"
+)
+
+// Check is a doctor.Check for reverse path filtering problems.
+type Check struct {
+	// Forwarding is whether this node forwards traffic for peers as
+	// a subnet router or exit node.
+	Forwarding bool
+
+	// ExitNode is whether this node routes its traffic via an exit
+	// node.
+	ExitNode bool
+}
+
+func (Check) Name() string {
+	return "rp-filter"
+}
+
+// Categories implements doctor.Categorized.
+func (Check) Categories() []doctor.Category {
+	return []doctor.Category{doctor.CategoryFirewall}
+}
+
+// Lightweight implements doctor.Lightweight: it only reads sysctls.
+func (Check) Lightweight() bool { return true }
+
+// Platforms implements doctor.Platformer.
+func (Check) Platforms() doctor.Requirements {
+	return doctor.Requirements{OS: []string{"linux"}}
+}
+
+// confDir is where the per-interface IPv4 sysctls are.
+var confDir = "/proc/sys/net/ipv4/conf"
+
+// Modes of rp_filter.
+const (
+	modeOff    = 0
+	modeStrict = 1
+	modeLoose  = 2
+)
+
+func modeString(m int) string {
+	switch m {
+	case modeOff:
+		return "off"
+	case modeStrict:
+		return "strict"
+	case modeLoose:
+		return "loose"
+	}
+	return fmt.Sprintf("unknown(%d)", m)
+}
+
+// Detail is the detail payload the check reports.
+type Detail struct {
+	// Interface is the interface whose mode was checked: the one
+	// with the default route, or "all".
+	Interface string
+	// Mode is the effective rp_filter mode of Interface: "off",
+	// "strict" or "loose".
+	Mode string
+}
+
+// readMode returns the rp_filter value of the interface named iface,
+// or of "all" interfaces.
+func readMode(iface string) (int, error) {
+	b, err := os.ReadFile(filepath.Join(confDir, iface, "rp_filter"))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(b)))
+}
+
+// effectiveMode returns the mode the kernel applies to an interface
+// with the rp_filter value iface when that of "all" is all: the larger
+// of the two.
+func effectiveMode(all, iface int) int {
+	if iface > all {
+		return iface
+	}
+	return all
+}
+
+func (c Check) Run(ctx context.Context, logf logger.Logf) error {
+	all, err := readMode("all")
+	if err != nil {
+		return err
+	}
+	mode, iface := all, "all"
+	if st, err := interfaces.GetState(); err == nil && st.DefaultRouteInterface != "" {
+		iface = st.DefaultRouteInterface
+		if m, err := readMode(iface); err == nil {
+			mode = effectiveMode(all, m)
+		} else {
+			logf("reading rp_filter of %s: %v", iface, err)
+		}
+	}
+	summary := fmt.Sprintf("rp_filter is %s on %s", modeString(mode), iface)
+	logf("%s", summary)
+	doctor.AddFinding(ctx, "rp_filter", modeString(mode))
+	doctor.Report(ctx, doctor.SeverityOK, summary, Detail{Interface: iface, Mode: modeString(mode)})
+
+	if !c.Forwarding && !c.ExitNode {
+		return nil
+	}
+	doctor.AddFinding(ctx, "routes", "asymmetric")
+	if mode == modeStrict {
+		return doctor.WithRemediation(
+			fmt.Errorf("strict rp_filter on %s may drop traffic routed via Tailscale", iface),
+			fmt.Sprintf("set net.ipv4.conf.all.rp_filter and net.ipv4.conf.%s.rp_filter to 2 (loose)", iface))
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpfilter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadMode(t *testing.T) {
+	defer func(old string) { confDir = old }(confDir)
+	confDir = t.TempDir()
+	for iface, v := range map[string]string{"all": "0\n", "eth0": "1\n"} {
+		if err := os.MkdirAll(filepath.Join(confDir, iface), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(confDir, iface, "rp_filter"), []byte(v), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	all, err := readMode("all")
+	if err != nil {
+		t.Fatal(err)
+	}
+	eth0, err := readMode("eth0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := modeString(effectiveMode(all, eth0)); got != "strict" {
+		t.Errorf("effective mode = %q; want strict", got)
+	}
+	if _, err := readMode("eth1"); err == nil {
+		t.Error("readMode of missing interface succeeded")
+	}
+}
+
+func TestEffectiveMode(t *testing.T) {
+	tests := []struct {
+		all, iface, want int
+	}{
+		{modeOff, modeOff, modeOff},
+		{modeOff, modeStrict, modeStrict},
+		{modeStrict, modeOff, modeStrict},
+		{modeStrict, modeLoose, modeLoose},
+		{modeLoose, modeStrict, modeLoose},
+	}
+	for _, tt := range tests {
+		if got := effectiveMode(tt.all, tt.iface); got != tt.want {
+			t.Errorf("effectiveMode(%d, %d) = %d; want %d", tt.all, tt.iface, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package splitdns provides a doctor.Check that verifies that the
+// system resolver sends MagicDNS names to Tailscale's resolver, as the
+// split DNS configuration Tailscale installed asks it to.
+package splitdns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"runtime"
+	"strings"
+	"time"
+
+	"tailscale.com/doctor"
+	"tailscale.com/net/dns/resolvconffile"
+	"tailscale.com/types/logger"
+)
+
+// lookupTimeout bounds the lookup of this node's name.
+const lookupTimeout = 5 * time.Second
+
+// stubResolver is the address systemd-resolved's stub listener puts in
+// /etc/resolv.conf.
+var stubResolver = netip.MustParseAddr("127.0.0.53")
+
+// Check is a doctor.Check that resolves this node's MagicDNS name with
+// the system resolver.
+type Check struct {
+	// MagicDNSName is this node's MagicDNS name. If empty, such as
+	// when MagicDNS or the CorpDNS pref is off, the check is skipped.
+	MagicDNSName string
+
+	// Addrs are this node's Tailscale IPs, which MagicDNSName should
+	// resolve to.
+	Addrs []netip.Addr
+
+	// resolvConf is the path of resolv.conf; if empty,
+	// resolvconffile.Path is used.
+	resolvConf string
+
+	// lookup, if non-nil, is used instead of the system resolver.
+	lookup func(context.Context, string) ([]netip.Addr, error)
+}
+
+func (Check) Name() string {
+	return "split-dns"
+}
+
+// Categories implements doctor.Categorized.
+func (Check) Categories() []doctor.Category {
+	return []doctor.Category{doctor.CategoryDNS}
+}
+
+func (c Check) Run(ctx context.Context, logf logger.Logf) error {
+	if c.MagicDNSName == "" {
+		logf("MagicDNS isn't in use; skipping")
+		return nil
+	}
+	if runtime.GOOS == "linux" {
+		if m := c.dnsManager(); m != "" {
+			logf("system resolver is %s", m)
+			doctor.AddFinding(ctx, "dns-manager", m)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+	lookup := c.lookup
+	if lookup == nil {
+		lookup = func(ctx context.Context, host string) ([]netip.Addr, error) {
+			return net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+		}
+	}
+	name := strings.TrimSuffix(c.MagicDNSName, ".")
+	addrs, err := lookup(ctx, name)
+	if err != nil {
+		doctor.AddFinding(ctx, "magicdns-lookup", "failed")
+		return fmt.Errorf("resolving %s with the system resolver: %w", name, err)
+	}
+	for _, a := range addrs {
+		for _, want := range c.Addrs {
+			if a.Unmap() == want {
+				logf("system resolver resolved %s to %v", name, a)
+				doctor.AddFinding(ctx, "magicdns-lookup", "ok")
+				return nil
+			}
+		}
+	}
+	doctor.AddFinding(ctx, "magicdns-lookup", "failed")
+	return fmt.Errorf("system resolver resolved %s to %v, not to this node's Tailscale IPs %v; it isn't sending MagicDNS names to Tailscale", name, addrs, c.Addrs)
+}
+
+// dnsManager returns the name of the local DNS manager that
+// resolv.conf points to, or the empty string if it's not one it
+// knows.
+func (c Check) dnsManager() string {
+	path := c.resolvConf
+	if path == "" {
+		path = resolvconffile.Path
+	}
+	conf, err := resolvconffile.ParseFile(path)
+	if err != nil {
+		return ""
+	}
+	for _, ns := range conf.Nameservers {
+		if ns == stubResolver {
+			return "systemd-resolved"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package splitdns
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"tailscale.com/doctor"
+)
+
+func TestCheck(t *testing.T) {
+	self := netip.MustParseAddr("100.64.0.1")
+	resolvConf := filepath.Join(t.TempDir(), "resolv.conf")
+	if err := os.WriteFile(resolvConf, []byte("nameserver 127.0.0.53\noptions edns0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		lookup       func(context.Context, string) ([]netip.Addr, error)
+		wantErr      bool
+		wantFindings []doctor.Finding
+	}{
+		{
+			name: "ok",
+			lookup: func(context.Context, string) ([]netip.Addr, error) {
+				return []netip.Addr{self}, nil
+			},
+			wantFindings: []doctor.Finding{{Check: "split-dns", Key: "magicdns-lookup", Value: "ok"}},
+		},
+		{
+			name: "nxdomain",
+			lookup: func(context.Context, string) ([]netip.Addr, error) {
+				return nil, errors.New("no such host")
+			},
+			wantErr:      true,
+			wantFindings: []doctor.Finding{{Check: "split-dns", Key: "magicdns-lookup", Value: "failed"}},
+		},
+		{
+			name: "wrong_addr",
+			lookup: func(context.Context, string) ([]netip.Addr, error) {
+				return []netip.Addr{netip.MustParseAddr("192.0.2.1")}, nil
+			},
+			wantErr:      true,
+			wantFindings: []doctor.Finding{{Check: "split-dns", Key: "magicdns-lookup", Value: "failed"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Check{
+				MagicDNSName: "foo.example.ts.net.",
+				Addrs:        []netip.Addr{self},
+				resolvConf:   resolvConf,
+				lookup:       tt.lookup,
+			}
+			res := doctor.RunChecksResults(context.Background(), c)
+			if gotErr := res[0].Err != nil; gotErr != tt.wantErr {
+				t.Errorf("error = %v; want error: %v", res[0].Err, tt.wantErr)
+			}
+			want := tt.wantFindings
+			if runtime.GOOS == "linux" {
+				want = append([]doctor.Finding{{Check: "split-dns", Key: "dns-manager", Value: "systemd-resolved"}}, want...)
+			}
+			if !reflect.DeepEqual(res[0].Findings, want) {
+				t.Errorf("findings = %+v; want %+v", res[0].Findings, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,399 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package srcaddr provides a doctor.Check that reports which IPv6
+// source address the OS selects for connections to the control server,
+// DERP servers and peers, and flags selections likely to cause
+// asymmetric failures: deprecated addresses, addresses of too small a
+// scope for the destination, and temporary addresses that peers don't
+// know as endpoints.
+//
+// Source address selection follows RFC 6724. The check evaluates the
+// RFC's rules itself too, and logs where the OS decided otherwise, as
+// OS policy or a routing rule may have overridden them.
+package srcaddr
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"sort"
+
+	"tailscale.com/doctor"
+	"tailscale.com/net/interfaces"
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/multierr"
+)
+
+// maxPeers is the most peer endpoints checked, to bound the output.
+const maxPeers = 20
+
+// Dest is a destination to check the source address selection for.
+type Dest struct {
+	Name string // what Addr is, such as a peer's name
+	Addr netip.AddrPort
+}
+
+// Check is a doctor.Check for IPv6 source address selection.
+type Check struct {
+	// ControlURL is the URL of the control server.
+	ControlURL string
+
+	// DERPMap is the DERP map whose servers are checked. If nil, DERP
+	// is not checked.
+	DERPMap *tailcfg.DERPMap
+
+	// Peers are the IPv6 endpoints of peers to check.
+	Peers []Dest
+
+	// Resolver, if non-nil, is used for DNS lookups instead of
+	// net.DefaultResolver.
+	Resolver *net.Resolver
+}
+
+func (Check) Name() string {
+	return "ipv6-source-addr"
+}
+
+// Categories implements doctor.Categorized.
+func (Check) Categories() []doctor.Category {
+	return []doctor.Category{doctor.CategoryNetwork}
+}
+
+func (c Check) Run(ctx context.Context, logf logger.Logf) error {
+	st, err := interfaces.GetState()
+	if err != nil {
+		return err
+	}
+	cands := candidates(st)
+	if len(cands) == 0 {
+		logf("no IPv6 addresses; skipping")
+		return nil
+	}
+	tmp, err := interfaces.TemporaryIPv6Addrs()
+	if err != nil {
+		logf("can't tell temporary addresses: %v", err)
+	}
+	dep, err := interfaces.DeprecatedIPv6Addrs()
+	if err != nil {
+		logf("can't tell deprecated addresses: %v", err)
+	}
+	for i := range cands {
+		cands[i].temporary = tmp[cands[i].addr]
+		cands[i].deprecated = dep[cands[i].addr]
+	}
+
+	var errs []error
+	for _, d := range c.dests(ctx, logf) {
+		src, err := systemSource(d.dst.Addr)
+		if err != nil {
+			logf("%s %v: no source address: %v", d.dst.Name, d.dst.Addr, err)
+			continue
+		}
+		sel, ok := findCandidate(cands, src)
+		if !ok {
+			logf("%s %v: source %v, not an address of an interface that's up", d.dst.Name, d.dst.Addr, src)
+			continue
+		}
+		logf("%s %v: source %v on %s%s", d.dst.Name, d.dst.Addr, src, sel.iface, sel.flagString())
+		if want := rfc6724Source(d.dst.Addr.Addr(), cands); want.addr.IsValid() && want.addr != src {
+			logf("%s %v: RFC 6724 would select %v on %s%s; OS policy or routes differ", d.dst.Name, d.dst.Addr, want.addr, want.iface, want.flagString())
+		}
+		for _, p := range problems(d.dst.Addr.Addr(), sel, d.peer) {
+			errs = append(errs, fmt.Errorf("%s %v: %s", d.dst.Name, d.dst.Addr, p))
+		}
+	}
+	return multierr.New(errs...)
+}
+
+type dest struct {
+	dst  Dest
+	peer bool
+}
+
+// dests returns the IPv6 destinations to check, without duplicates.
+func (c Check) dests(ctx context.Context, logf logger.Logf) []dest {
+	res := c.Resolver
+	if res == nil {
+		res = net.DefaultResolver
+	}
+	var ret []dest
+	seen := map[netip.AddrPort]bool{}
+	add := func(d Dest, peer bool) {
+		ip := d.Addr.Addr()
+		if !ip.Is6() || ip.Is4In6() || ip.IsLinkLocalUnicast() || tsaddr.IsTailscaleIP(ip) || seen[d.Addr] {
+			return
+		}
+		seen[d.Addr] = true
+		ret = append(ret, dest{d, peer})
+	}
+	lookup := func(name, host string) {
+		ips, err := res.LookupNetIP(ctx, "ip6", host)
+		if err != nil {
+			logf("%s: no IPv6 address: %v", name, err)
+		}
+		for _, ip := range ips {
+			add(Dest{name, netip.AddrPortFrom(ip.Unmap(), 443)}, false)
+		}
+	}
+
+	if c.ControlURL != "" {
+		if u, err := url.Parse(c.ControlURL); err == nil {
+			if ip, err := netip.ParseAddr(u.Hostname()); err == nil {
+				add(Dest{"control", netip.AddrPortFrom(ip, 443)}, false)
+			} else {
+				lookup("control", u.Hostname())
+			}
+		}
+	}
+	if c.DERPMap != nil {
+		for _, id := range c.DERPMap.RegionIDs() {
+			r := c.DERPMap.Regions[id]
+			for _, node := range r.Nodes {
+				if node.STUNOnly {
+					continue
+				}
+				name := fmt.Sprintf("DERP %s", node.HostName)
+				if ip, err := netip.ParseAddr(node.IPv6); err == nil {
+					add(Dest{name, netip.AddrPortFrom(ip, 443)}, false)
+				} else if node.IPv6 == "" {
+					lookup(name, node.HostName)
+				}
+				break // the first node is the one derphttp prefers
+			}
+		}
+	}
+	peers := append([]Dest(nil), c.Peers...)
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Name < peers[j].Name })
+	var n int
+	for _, p := range peers {
+		if n == maxPeers {
+			logf("only checking %d peer endpoints", maxPeers)
+			break
+		}
+		before := len(ret)
+		add(p, true)
+		if len(ret) > before {
+			n++
+		}
+	}
+	return ret
+}
+
+// systemSource returns the source address the OS selects for
+// connections to dst. Connecting a UDP socket selects it without
+// sending anything.
+func systemSource(dst netip.AddrPort) (netip.Addr, error) {
+	conn, err := net.DialUDP("udp6", nil, net.UDPAddrFromAddrPort(dst))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).AddrPort().Addr().WithZone(""), nil
+}
+
+// candidate is a source address candidate.
+type candidate struct {
+	addr       netip.Addr
+	bits       int // prefix length of addr's subnet
+	iface      string
+	temporary  bool
+	deprecated bool
+}
+
+func (c candidate) flagString() string {
+	switch {
+	case c.temporary && c.deprecated:
+		return " (temporary, deprecated)"
+	case c.temporary:
+		return " (temporary)"
+	case c.deprecated:
+		return " (deprecated)"
+	}
+	return ""
+}
+
+// candidates returns the IPv6 addresses of st's interfaces that are up,
+// other than Tailscale's.
+func candidates(st *interfaces.State) []candidate {
+	var ret []candidate
+	for name, pfxs := range st.InterfaceIPs {
+		if iface, ok := st.Interface[name]; !ok || iface.Interface == nil || !iface.IsUp() {
+			continue
+		}
+		for _, pfx := range pfxs {
+			ip := pfx.Addr()
+			if !ip.Is6() || ip.Is4In6() || tsaddr.IsTailscaleIP(ip) {
+				continue
+			}
+			ret = append(ret, candidate{addr: ip.WithZone(""), bits: pfx.Bits(), iface: name})
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].addr.Less(ret[j].addr) })
+	return ret
+}
+
+func findCandidate(cands []candidate, ip netip.Addr) (candidate, bool) {
+	for _, c := range cands {
+		if c.addr == ip {
+			return c, true
+		}
+	}
+	return candidate{}, false
+}
+
+// problems returns what's wrong with using src as the source address
+// for connections to dst. If peer, dst is a peer's endpoint.
+func problems(dst netip.Addr, src candidate, peer bool) []string {
+	var ret []string
+	if src.deprecated {
+		ret = append(ret, fmt.Sprintf("source %v is deprecated; new connections from it may fail once it expires", src.addr))
+	}
+	if scope(src.addr) < scope(dst) {
+		ret = append(ret, fmt.Sprintf("source %v has %s scope, too small to reach a %s destination", src.addr, scopeName(scope(src.addr)), scopeName(scope(dst))))
+	} else if label(src.addr) != label(dst) && label(src.addr) == labelULA {
+		ret = append(ret, fmt.Sprintf("source %v is a unique local address, which isn't routed to global destinations; replies won't come back unless a NAT66 translates it", src.addr))
+	}
+	if peer && src.temporary {
+		ret = append(ret, fmt.Sprintf("source %v is temporary, unlike the endpoints advertised to peers; packets arrive from an address the peer doesn't know, and stop when it rotates", src.addr))
+	}
+	return ret
+}
+
+// rfc6724Source returns the source address that RFC 6724 section 5
+// selects from cands for dst, or the zero candidate if cands is empty.
+// Rules 4 (home addresses), 5 (outgoing interface) and 7 (temporary
+// addresses, whose preference is configurable) are skipped, as they
+// depend on state and policy this package can't see.
+func rfc6724Source(dst netip.Addr, cands []candidate) candidate {
+	if len(cands) == 0 {
+		return candidate{}
+	}
+	best := cands[0]
+	for _, c := range cands[1:] {
+		if betterSource(dst, c, best) {
+			best = c
+		}
+	}
+	return best
+}
+
+// betterSource reports whether a is a better source address than b for
+// dst, per the rules rfc6724Source applies.
+func betterSource(dst netip.Addr, a, b candidate) bool {
+	// Rule 1: prefer same address.
+	if (a.addr == dst) != (b.addr == dst) {
+		return a.addr == dst
+	}
+	// Rule 2: prefer appropriate scope.
+	sa, sb, sd := scope(a.addr), scope(b.addr), scope(dst)
+	if sa < sb {
+		return sa >= sd
+	}
+	if sb < sa {
+		return sb < sd
+	}
+	// Rule 3: avoid deprecated addresses.
+	if a.deprecated != b.deprecated {
+		return !a.deprecated
+	}
+	// Rule 6: prefer matching label.
+	ld := label(dst)
+	if (label(a.addr) == ld) != (label(b.addr) == ld) {
+		return label(a.addr) == ld
+	}
+	// Rule 8: use longest matching prefix.
+	return commonPrefixLen(a, dst) > commonPrefixLen(b, dst)
+}
+
+// commonPrefixLen returns the number of leading bits c's address has
+// in common with dst, up to the length of c's subnet prefix.
+func commonPrefixLen(c candidate, dst netip.Addr) int {
+	a, d := c.addr.As16(), dst.As16()
+	n := 0
+	for i := 0; i < 16; i++ {
+		x := a[i] ^ d[i]
+		if x == 0 {
+			n += 8
+			continue
+		}
+		for x&0x80 == 0 {
+			n++
+			x <<= 1
+		}
+		break
+	}
+	if c.bits > 0 && n > c.bits {
+		n = c.bits
+	}
+	return n
+}
+
+// Address scopes, from RFC 4291 section 2.7.
+const (
+	scopeLinkLocal = 0x2
+	scopeSiteLocal = 0x5
+	scopeGlobal    = 0xe
+)
+
+var siteLocal = netip.MustParsePrefix("fec0::/10")
+
+// scope returns the scope of the unicast address ip, which RFC 6724
+// section 3.1 defines by its prefix.
+func scope(ip netip.Addr) int {
+	switch {
+	case ip.IsLoopback() || ip.IsLinkLocalUnicast():
+		return scopeLinkLocal
+	case siteLocal.Contains(ip):
+		return scopeSiteLocal
+	}
+	return scopeGlobal
+}
+
+func scopeName(s int) string {
+	switch s {
+	case scopeLinkLocal:
+		return "link-local"
+	case scopeSiteLocal:
+		return "site-local"
+	}
+	return "global"
+}
+
+// labelULA is the label of unique local addresses in the default
+// policy table.
+const labelULA = 13
+
+// policyLabels is the default policy table of RFC 6724 section 2.1,
+// without precedences, as only labels matter for source selection.
+var policyLabels = []struct {
+	pfx   netip.Prefix
+	label int
+}{
+	{netip.MustParsePrefix("::1/128"), 0},
+	{netip.MustParsePrefix("::/0"), 1},
+	{netip.MustParsePrefix("::ffff:0:0/96"), 4},
+	{netip.MustParsePrefix("2002::/16"), 2},
+	{netip.MustParsePrefix("2001::/32"), 5},
+	{netip.MustParsePrefix("fc00::/7"), labelULA},
+	{netip.MustParsePrefix("::/96"), 3},
+	{netip.MustParsePrefix("fec0::/10"), 11},
+	{netip.MustParsePrefix("3ffe::/16"), 12},
+}
+
+// label returns the label of ip's longest matching prefix in the
+// default policy table.
+func label(ip netip.Addr) int {
+	ret, bits := 1, -1
+	for _, p := range policyLabels {
+		if p.pfx.Contains(ip) && p.pfx.Bits() > bits {
+			ret, bits = p.label, p.pfx.Bits()
+		}
+	}
+	return ret
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package srcaddr
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestRFC6724Source(t *testing.T) {
+	cand := func(s string, bits int) candidate {
+		return candidate{addr: netip.MustParseAddr(s), bits: bits, iface: "eth0"}
+	}
+	linkLocal := cand("fe80::1", 64)
+	global := cand("2001:db8:1::10", 64)
+	otherGlobal := cand("2001:db8:2::10", 64)
+	ula := cand("fd00::10", 64)
+	deprecated := cand("2001:db8:1::20", 64)
+	deprecated.deprecated = true
+
+	tests := []struct {
+		name  string
+		dst   string
+		cands []candidate
+		want  candidate
+	}{
+		{"same-address", "2001:db8:2::10", []candidate{global, otherGlobal}, otherGlobal},
+		{"scope-global", "2001:db8:9::1", []candidate{linkLocal, global}, global},
+		{"scope-link-local", "fe80::2", []candidate{global, linkLocal}, linkLocal},
+		{"avoid-deprecated", "2001:db8:1::1", []candidate{deprecated, global}, global},
+		{"matching-label-ula", "fd00::1", []candidate{global, ula}, ula},
+		{"matching-label-global", "2001:db8:9::1", []candidate{ula, global}, global},
+		{"longest-prefix", "2001:db8:2::1", []candidate{global, otherGlobal}, otherGlobal},
+		{"empty", "2001:db8::1", nil, candidate{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rfc6724Source(netip.MustParseAddr(tt.dst), tt.cands)
+			if got != tt.want {
+				t.Errorf("got %v; want %v", got.addr, tt.want.addr)
+			}
+		})
+	}
+}
+
+func TestProblems(t *testing.T) {
+	tests := []struct {
+		name string
+		dst  string
+		src  candidate
+		peer bool
+		want int
+	}{
+		{"ok", "2001:db8::1", candidate{addr: netip.MustParseAddr("2001:db8:1::10")}, true, 0},
+		{"deprecated", "2001:db8::1", candidate{addr: netip.MustParseAddr("2001:db8:1::10"), deprecated: true}, false, 1},
+		{"link-local-to-global", "2001:db8::1", candidate{addr: netip.MustParseAddr("fe80::1")}, false, 1},
+		{"ula-to-global", "2001:db8::1", candidate{addr: netip.MustParseAddr("fd00::1")}, false, 1},
+		{"ula-to-ula", "fd00::2", candidate{addr: netip.MustParseAddr("fd00::1")}, false, 0},
+		{"temporary-to-control", "2001:db8::1", candidate{addr: netip.MustParseAddr("2001:db8:1::10"), temporary: true}, false, 0},
+		{"temporary-to-peer", "2001:db8::1", candidate{addr: netip.MustParseAddr("2001:db8:1::10"), temporary: true}, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := problems(netip.MustParseAddr(tt.dst), tt.src, tt.peer)
+			if len(got) != tt.want {
+				t.Errorf("got %q; want %d problems", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package stalestate provides a doctor.Check that looks for state left
+// behind by crashes or old installs, such as orphaned login profiles,
+// leftover TUN interfaces, stale pidfiles and dead unix sockets, which
+// can cause confusing startup failures.
+package stalestate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"tailscale.com/doctor"
+	"tailscale.com/ipn"
+	"tailscale.com/types/logger"
+)
+
+// minTempFileAge is how old a leftover temporary file must be before
+// it's considered stale rather than part of a write in progress.
+const minTempFileAge = time.Minute
+
+// KeyLister is the optional interface implemented by an ipn.StateStore
+// that can enumerate its keys.
+type KeyLister interface {
+	Keys() []ipn.StateKey
+}
+
+// StateDeleter is the optional interface implemented by an
+// ipn.StateStore that can remove keys.
+type StateDeleter interface {
+	DeleteState(ipn.StateKey) error
+}
+
+// Check is a doctor.Check that reports stale local state and, if
+// Clean is set, removes what can safely be removed.
+type Check struct {
+	// Store is the state store to inspect. If it doesn't implement
+	// KeyLister, profiles are not checked.
+	Store ipn.StateStore
+
+	// CurrentKey is the state key in use, which is never considered
+	// orphaned.
+	CurrentKey ipn.StateKey
+
+	// KeyExpiry is the expiry time of the current node key, or the
+	// zero time if it doesn't expire or is unknown.
+	KeyExpiry time.Time
+
+	// StateDir, if non-empty, is scanned for temporary files left
+	// behind by interrupted writes.
+	StateDir string
+
+	// SocketDir, if non-empty, is scanned for Tailscale unix sockets
+	// (those named "tailscale*") that nothing is listening on.
+	SocketDir string
+
+	// PIDFiles are the paths of pidfiles that init scripts may have
+	// written for tailscaled, such as /run/tailscaled.pid. Those that
+	// exist but name no running process are reported.
+	PIDFiles []string
+
+	// Clean specifies whether to remove orphaned profiles, leftover
+	// temporary files, stale pidfiles and dead sockets. Interfaces and
+	// expired keys are only ever reported.
+	Clean bool
+}
+
+func (Check) Name() string {
+	return "stale-state"
+}
+
+// Categories implements doctor.Categorized.
+func (Check) Categories() []doctor.Category {
+	return []doctor.Category{doctor.CategoryPlatform}
+}
+
+// Lightweight implements doctor.Lightweight: it only looks at local
+// files, interfaces and sockets.
+func (Check) Lightweight() bool { return true }
+
+func (c Check) Run(ctx context.Context, logf logger.Logf) error {
+	var found int
+	report := func(format string, args ...any) {
+		found++
+		logf(format, args...)
+	}
+
+	c.checkProfiles(report, logf)
+	if !c.KeyExpiry.IsZero() && time.Until(c.KeyExpiry) <= 0 {
+		report("node key expired at %v; log in again to renew it", c.KeyExpiry.Format(time.RFC3339))
+	}
+	c.checkInterfaces(report)
+	if c.StateDir != "" {
+		c.checkTempFiles(report, logf)
+	}
+	if c.SocketDir != "" && runtime.GOOS != "windows" {
+		c.checkSockets(report, logf)
+	}
+	c.checkPIDFiles(report, logf)
+
+	if found == 0 {
+		logf("no stale state found")
+		return nil
+	}
+	if c.Clean {
+		return nil
+	}
+	return doctor.WithRemediation(
+		fmt.Errorf("found %d stale item(s)", found),
+		"run 'tailscale debug clean-stale-state' to remove what can be removed")
+}
+
+// checkProfiles reports per-user profiles ("user-<uid>" keys) whose
+// user no longer exists, or whose prefs can't be parsed.
+func (c Check) checkProfiles(report, logf logger.Logf) {
+	kl, ok := c.Store.(KeyLister)
+	if !ok {
+		return
+	}
+	startKey := ""
+	if bs, err := c.Store.ReadState(ipn.ServerModeStartKey); err == nil {
+		startKey = string(bs)
+	}
+	keys := kl.Keys()
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	for _, k := range keys {
+		if !strings.HasPrefix(string(k), "user-") || k == c.CurrentKey || string(k) == startKey {
+			continue
+		}
+		uid := strings.TrimPrefix(string(k), "user-")
+		var why string
+		if _, err := user.LookupId(uid); err != nil {
+			var unknown user.UnknownUserIdError
+			if !errors.As(err, &unknown) {
+				continue
+			}
+			why = "user no longer exists"
+		} else if bs, err := c.Store.ReadState(k); err == nil {
+			if _, err := ipn.PrefsFromBytes(bs); err != nil {
+				why = fmt.Sprintf("prefs are corrupt: %v", err)
+			}
+		}
+		if why == "" {
+			continue
+		}
+		report("orphaned profile %q: %s", k, why)
+		if !c.Clean {
+			continue
+		}
+		d, ok := c.Store.(StateDeleter)
+		if !ok {
+			logf("cannot remove %q: store does not support deletion", k)
+			continue
+		}
+		if err := d.DeleteState(k); err != nil {
+			logf("removing %q: %v", k, err)
+		} else {
+			logf("removed %q", k)
+		}
+	}
+}
+
+// checkInterfaces reports Tailscale TUN interfaces that are down. The
+// TUN of a running tailscaled is always up, so a down one was most
+// likely left behind by an instance that crashed.
+func (c Check) checkInterfaces(report logger.Logf) {
+	ifs, err := net.Interfaces()
+	if err != nil {
+		return
+	}
+	for _, iface := range ifs {
+		if !strings.HasPrefix(iface.Name, "tailscale") || iface.Flags&net.FlagUp != 0 {
+			continue
+		}
+		report("interface %s is down and may be left over from a crashed tailscaled", iface.Name)
+	}
+}
+
+// checkTempFiles reports temporary files in c.StateDir left behind by
+// interrupted atomicfile writes.
+func (c Check) checkTempFiles(report, logf logger.Logf) {
+	ents, err := os.ReadDir(c.StateDir)
+	if err != nil {
+		return
+	}
+	for _, de := range ents {
+		if !de.Type().IsRegular() || !isAtomicTempFile(de.Name()) {
+			continue
+		}
+		fi, err := de.Info()
+		if err != nil || time.Since(fi.ModTime()) < minTempFileAge {
+			continue
+		}
+		path := filepath.Join(c.StateDir, de.Name())
+		report("leftover temporary file %s", path)
+		if c.Clean {
+			removeFile(logf, path)
+		}
+	}
+}
+
+// checkSockets reports Tailscale unix sockets in c.SocketDir that
+// refuse connections. Other sockets are left alone, as SocketDir may
+// be shared with other daemons.
+func (c Check) checkSockets(report, logf logger.Logf) {
+	ents, err := os.ReadDir(c.SocketDir)
+	if err != nil {
+		return
+	}
+	for _, de := range ents {
+		if de.Type()&fs.ModeSocket == 0 || !strings.HasPrefix(de.Name(), "tailscale") {
+			continue
+		}
+		path := filepath.Join(c.SocketDir, de.Name())
+		conn, err := net.DialTimeout("unix", path, time.Second)
+		if err == nil {
+			conn.Close()
+			continue
+		}
+		report("socket %s has no listener", path)
+		if c.Clean {
+			removeFile(logf, path)
+		}
+	}
+}
+
+// isAtomicTempFile reports whether name is that of a temporary file
+// from atomicfile.WriteFile: the name of the file being written,
+// ".tmp", and the random digits added by ioutil.TempFile.
+func isAtomicTempFile(name string) bool {
+	i := strings.LastIndex(name, ".tmp")
+	if i <= 0 || i+len(".tmp") == len(name) {
+		return false
+	}
+	for _, r := range name[i+len(".tmp"):] {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// checkPIDFiles reports those of c.PIDFiles that name a process that
+// isn't running, or that can't be parsed.
+func (c Check) checkPIDFiles(report, logf logger.Logf) {
+	for _, path := range c.PIDFiles {
+		bs, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(string(bs)))
+		switch {
+		case err != nil || pid <= 0:
+			report("pidfile %s is corrupt", path)
+		case !processRunning(pid):
+			report("pidfile %s names process %d, which isn't running", path, pid)
+		default:
+			continue
+		}
+		if c.Clean {
+			removeFile(logf, path)
+		}
+	}
+}
+
+// processRunning reports whether a process with the given pid exists.
+func processRunning(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		// On Windows, FindProcess fails for processes that don't
+		// exist. Elsewhere it always succeeds.
+		return false
+	}
+	defer p.Release()
+	// Signal 0 only checks for the process. On Windows it's not
+	// supported, but FindProcess already did the checking.
+	return !errors.Is(p.Signal(syscall.Signal(0)), os.ErrProcessDone)
+}
+
+func removeFile(logf logger.Logf, path string) {
+	if err := os.Remove(path); err != nil {
+		logf("removing %s: %v", path, err)
+	} else {
+		logf("removed %s", path)
+	}
+}
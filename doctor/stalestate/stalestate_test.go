@@ -0,0 +1,133 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stalestate
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/store/mem"
+)
+
+func TestCheck(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses unix sockets and numeric uids")
+	}
+	ctx := context.Background()
+
+	store := new(mem.Store)
+	// A uid that's very unlikely to exist.
+	const orphan = ipn.StateKey("user-2147483000")
+	store.WriteState(orphan, []byte(`{}`))
+	store.WriteState(ipn.GlobalDaemonStateKey, []byte(`{}`))
+
+	dir := t.TempDir()
+	tmp := filepath.Join(dir, "tailscaled.state.tmp123")
+	if err := os.WriteFile(tmp, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(tmp, old, old); err != nil {
+		t.Fatal(err)
+	}
+	fresh := filepath.Join(dir, "tailscaled.state.tmp456")
+	if err := os.WriteFile(fresh, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	// Not from atomicfile, so never touched however old.
+	other := filepath.Join(dir, "notes.tmp")
+	if err := os.WriteFile(other, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(other, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pids are at most 2^22 on Linux, and much less elsewhere.
+	stalePID := filepath.Join(dir, "stale.pid")
+	if err := os.WriteFile(stalePID, []byte("2147483000\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	livePID := filepath.Join(dir, "live.pid")
+	if err := os.WriteFile(livePID, []byte(strconv.Itoa(os.Getpid())+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	dead := filepath.Join(dir, "tailscaled-dead.sock")
+	ln, err := net.Listen("unix", dead)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+	live := filepath.Join(dir, "tailscaled.sock")
+	ln, err = net.Listen("unix", live)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	c := Check{
+		Store:      store,
+		CurrentKey: ipn.GlobalDaemonStateKey,
+		StateDir:   dir,
+		SocketDir:  dir,
+		PIDFiles:   []string{stalePID, livePID},
+	}
+	if err := c.Run(ctx, t.Logf); err == nil {
+		t.Fatal("got nil error; want stale state reported")
+	}
+	if _, err := store.ReadState(orphan); err != nil {
+		t.Errorf("orphaned profile removed without Clean: %v", err)
+	}
+
+	c.Clean = true
+	if err := c.Run(ctx, t.Logf); err != nil {
+		t.Fatalf("Clean: %v", err)
+	}
+	if _, err := store.ReadState(orphan); err != ipn.ErrStateNotExist {
+		t.Errorf("orphaned profile not removed: %v", err)
+	}
+	if _, err := store.ReadState(ipn.GlobalDaemonStateKey); err != nil {
+		t.Errorf("current profile removed: %v", err)
+	}
+	for _, f := range []string{tmp, dead, stalePID} {
+		if _, err := os.Stat(f); !os.IsNotExist(err) {
+			t.Errorf("%s not removed: %v", f, err)
+		}
+	}
+	for _, f := range []string{fresh, other, live, livePID} {
+		if _, err := os.Stat(f); err != nil {
+			t.Errorf("%s removed: %v", f, err)
+		}
+	}
+}
+
+func TestIsAtomicTempFile(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"tailscaled.state.tmp123456", true},
+		{"derpmap.cached.json.tmp1", true},
+		{"tailscaled.state", false},
+		{"tailscaled.state.tmp", false},
+		{"notes.tmp", false},
+		{"foo.tmpx1", false},
+		{".tmp123", false},
+	}
+	for _, tt := range tests {
+		if got := isAtomicTempFile(tt.name); got != tt.want {
+			t.Errorf("isAtomicTempFile(%q) = %v; want %v", tt.name, got, tt.want)
+		}
+	}
+}
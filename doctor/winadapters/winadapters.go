@@ -0,0 +1,149 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package winadapters provides a doctor.Check that inspects the
+// binding order, interface metrics and DNS registration settings of
+// Windows network adapters, flagging configurations that make Windows
+// prefer the wrong adapter for DNS or routing.
+package winadapters
+
+import (
+	"fmt"
+	"strings"
+
+	"tailscale.com/doctor"
+)
+
+// Check is a doctor.Check that reports on how Windows orders the
+// Tailscale adapter relative to the host's other adapters. It only does
+// anything on Windows.
+type Check struct {
+	// MagicDNSSuffix is the tailnet's MagicDNS suffix, which Tailscale
+	// may set as its adapter's connection-specific DNS suffix. It's
+	// empty if MagicDNS is off.
+	MagicDNSSuffix string
+}
+
+func (Check) Name() string {
+	return "windows-adapters"
+}
+
+// Categories implements doctor.Categorized.
+func (Check) Categories() []doctor.Category {
+	return []doctor.Category{doctor.CategoryPlatform, doctor.CategoryDNS}
+}
+
+// Lightweight implements doctor.Lightweight: it only reads the
+// adapter configuration.
+func (Check) Lightweight() bool { return true }
+
+// Platforms implements doctor.Platformer.
+func (Check) Platforms() doctor.Requirements {
+	return doctor.Requirements{OS: []string{"windows"}}
+}
+
+// adapter is the subset of a Windows adapter's configuration that the
+// check inspects.
+type adapter struct {
+	name      string // friendly name, as shown by ncpa.cpl
+	guid      string // "{...}", as in the binding order
+	tailscale bool
+	up        bool
+	gateway   bool // has a default gateway
+
+	has4, has6       bool   // IPv4 or IPv6 is enabled
+	metric4, metric6 uint32 // interface metrics
+
+	dnsSuffix      string // connection-specific DNS suffix
+	ddns           bool   // "Register this connection's addresses in DNS"
+	registerSuffix bool   // "Use this connection's DNS suffix in DNS registration"
+}
+
+func (a adapter) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%q", a.name)
+	if !a.up {
+		sb.WriteString(" down")
+	}
+	if a.has4 {
+		fmt.Fprintf(&sb, " metric4=%d", a.metric4)
+	}
+	if a.has6 {
+		fmt.Fprintf(&sb, " metric6=%d", a.metric6)
+	}
+	if a.gateway {
+		sb.WriteString(" gateway")
+	}
+	if a.dnsSuffix != "" {
+		fmt.Fprintf(&sb, " suffix=%q", a.dnsSuffix)
+	}
+	if a.ddns {
+		sb.WriteString(" ddns")
+		if a.registerSuffix {
+			sb.WriteString("+suffix")
+		}
+	}
+	return sb.String()
+}
+
+// problems returns the known-bad configurations among adapters.
+// bindOrder is the GUIDs of the adapters in binding order, and
+// oldWindows is whether the OS predates Windows 10, on which DNS still
+// prefers adapters by binding order rather than by metric.
+func (c Check) problems(adapters []adapter, bindOrder []string, oldWindows bool) []string {
+	var ts *adapter
+	for i := range adapters {
+		if adapters[i].tailscale {
+			ts = &adapters[i]
+			break
+		}
+	}
+	if ts == nil {
+		return []string{"no Tailscale adapter found"}
+	}
+
+	var ret []string
+	if ts.ddns {
+		what := "its Tailscale IPs"
+		if ts.registerSuffix {
+			what += " under its DNS suffix too"
+		}
+		ret = append(ret, fmt.Sprintf("the Tailscale adapter %q has \"Register this connection's addresses in DNS\" enabled, so Windows registers %s with the domain's DNS servers, and other LAN hosts may resolve this machine to an address they can't reach", ts.name, what))
+	}
+	if s := strings.TrimSuffix(ts.dnsSuffix, "."); s != "" && !strings.EqualFold(s, strings.TrimSuffix(c.MagicDNSSuffix, ".")) {
+		ret = append(ret, fmt.Sprintf("the Tailscale adapter %q has connection-specific DNS suffix %q, which Tailscale didn't set; Windows may send queries for names in it to Tailscale's resolver", ts.name, ts.dnsSuffix))
+	}
+
+	for _, a := range adapters {
+		if a.tailscale || !a.up || !a.gateway {
+			continue
+		}
+		if ts.has4 && a.has4 && a.metric4 <= ts.metric4 {
+			ret = append(ret, fmt.Sprintf("%q has IPv4 metric %d, not above the Tailscale adapter's %d; Windows may prefer it for DNS and for routes that overlap Tailscale's", a.name, a.metric4, ts.metric4))
+		}
+		if ts.has6 && a.has6 && a.metric6 <= ts.metric6 {
+			ret = append(ret, fmt.Sprintf("%q has IPv6 metric %d, not above the Tailscale adapter's %d; Windows may prefer it for DNS and for routes that overlap Tailscale's", a.name, a.metric6, ts.metric6))
+		}
+	}
+
+	if oldWindows {
+		byGUID := map[string]*adapter{}
+		for i := range adapters {
+			byGUID[strings.ToLower(adapters[i].guid)] = &adapters[i]
+		}
+		for _, g := range bindOrder {
+			a := byGUID[strings.ToLower(g)]
+			if a == nil || !a.up {
+				continue
+			}
+			if !a.tailscale && a.gateway {
+				ret = append(ret, fmt.Sprintf("%q is ahead of the Tailscale adapter in the binding order, so this version of Windows sends DNS queries to its resolvers first", a.name))
+			}
+			if a.tailscale || a.gateway {
+				break
+			}
+		}
+	}
+	return ret
+}
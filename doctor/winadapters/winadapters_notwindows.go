@@ -0,0 +1,18 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !windows
+// +build !windows
+
+package winadapters
+
+import (
+	"context"
+
+	"tailscale.com/types/logger"
+)
+
+func (c Check) Run(context.Context, logger.Logf) error {
+	return nil
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package winadapters
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProblems(t *testing.T) {
+	ts := adapter{
+		name:      "Tailscale",
+		guid:      "{TS}",
+		tailscale: true,
+		up:        true,
+		has4:      true,
+		metric4:   5,
+		dnsSuffix: "example.ts.net",
+	}
+	eth := adapter{
+		name:    "Ethernet",
+		guid:    "{ETH}",
+		up:      true,
+		gateway: true,
+		has4:    true,
+		metric4: 25,
+		has6:    true,
+		metric6: 1,
+	}
+	c := Check{MagicDNSSuffix: "example.ts.net."}
+
+	tests := []struct {
+		name       string
+		mod        func(ts, eth *adapter)
+		bindOrder  []string
+		oldWindows bool
+		want       []string // substrings of each problem, in order
+	}{
+		{
+			name: "ok",
+		},
+		{
+			name: "ddns",
+			mod: func(ts, eth *adapter) {
+				ts.ddns = true
+				ts.registerSuffix = true
+			},
+			want: []string{"under its DNS suffix too"},
+		},
+		{
+			name: "foreign_suffix",
+			mod: func(ts, eth *adapter) {
+				ts.dnsSuffix = "corp.example.com"
+			},
+			want: []string{`suffix "corp.example.com"`},
+		},
+		{
+			name: "metric",
+			mod: func(ts, eth *adapter) {
+				eth.metric4 = 5
+			},
+			want: []string{`"Ethernet" has IPv4 metric 5`},
+		},
+		{
+			name: "metric_down",
+			mod: func(ts, eth *adapter) {
+				eth.metric4 = 1
+				eth.up = false
+			},
+		},
+		{
+			name:      "binding_order_new_windows",
+			bindOrder: []string{"{ETH}", "{TS}"},
+		},
+		{
+			name:       "binding_order_old_windows",
+			bindOrder:  []string{"{eth}", "{TS}"},
+			oldWindows: true,
+			want:       []string{`"Ethernet" is ahead`},
+		},
+		{
+			name:       "binding_order_old_windows_ok",
+			bindOrder:  []string{"{TS}", "{ETH}"},
+			oldWindows: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts, eth := ts, eth
+			if tt.mod != nil {
+				tt.mod(&ts, &eth)
+			}
+			got := c.problems([]adapter{eth, ts}, tt.bindOrder, tt.oldWindows)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d problems %q; want %d", len(got), got, len(tt.want))
+			}
+			for i, w := range tt.want {
+				if !strings.Contains(got[i], w) {
+					t.Errorf("problem %d = %q; want it to contain %q", i, got[i], w)
+				}
+			}
+		})
+	}
+
+	if got := c.problems([]adapter{eth}, nil, false); len(got) != 1 || got[0] != "no Tailscale adapter found" {
+		t.Errorf("without Tailscale adapter: got %q", got)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package winadapters
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+	"golang.zx2c4.com/wireguard/windows/tunnel/winipcfg"
+	"tailscale.com/tsconst"
+	"tailscale.com/types/logger"
+)
+
+func (c Check) Run(ctx context.Context, logf logger.Logf) error {
+	ifs, err := winipcfg.GetAdaptersAddresses(windows.AF_UNSPEC, winipcfg.GAAFlagIncludeGateways)
+	if err != nil {
+		return fmt.Errorf("listing adapters: %w", err)
+	}
+	var adapters []adapter
+	for _, ifc := range ifs {
+		if ifc.IfType == winipcfg.IfTypeSoftwareLoopback {
+			continue
+		}
+		desc := ifc.Description()
+		a := adapter{
+			name: ifc.FriendlyName(),
+			guid: ifc.AdapterName(),
+			tailscale: strings.Contains(desc, tsconst.WintunInterfaceDesc) ||
+				strings.Contains(desc, tsconst.WintunInterfaceDesc0_14),
+			up:             ifc.OperStatus == winipcfg.IfOperStatusUp,
+			gateway:        ifc.FirstGatewayAddress != nil,
+			has4:           ifc.Flags&winipcfg.IPAAFlagIpv4Enabled != 0,
+			has6:           ifc.Flags&winipcfg.IPAAFlagIpv6Enabled != 0,
+			metric4:        ifc.Ipv4Metric,
+			metric6:        ifc.Ipv6Metric,
+			dnsSuffix:      ifc.DNSSuffix(),
+			ddns:           ifc.Flags&winipcfg.IPAAFlagDdnsEnabled != 0,
+			registerSuffix: ifc.Flags&winipcfg.IPAAFlagRegisterAdapterSuffix != 0,
+		}
+		logf("adapter %v", a)
+		adapters = append(adapters, a)
+	}
+
+	bindOrder, err := bindingOrder()
+	if err != nil {
+		logf("reading binding order: %v", err)
+	}
+
+	v := windows.RtlGetVersion()
+	oldWindows := v.MajorVersion < 10
+	if oldWindows {
+		var names []string
+		byGUID := map[string]string{}
+		for _, a := range adapters {
+			byGUID[strings.ToLower(a.guid)] = a.name
+		}
+		for _, g := range bindOrder {
+			if n, ok := byGUID[strings.ToLower(g)]; ok {
+				names = append(names, n)
+			}
+		}
+		logf("binding order: %q", names)
+	}
+
+	if ps := c.problems(adapters, bindOrder, oldWindows); len(ps) > 0 {
+		return errors.New(strings.Join(ps, "; "))
+	}
+	return nil
+}
+
+// bindingOrder returns the adapter GUIDs in TCP/IP's binding order.
+func bindingOrder() ([]string, error) {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, `SYSTEM\CurrentControlSet\Services\Tcpip\Linkage`, registry.READ)
+	if err != nil {
+		return nil, err
+	}
+	defer k.Close()
+	binds, _, err := k.GetStringsValue("Bind")
+	if err != nil {
+		return nil, err
+	}
+	// Entries are of the form `\Device\{GUID}`.
+	ret := make([]string, 0, len(binds))
+	for _, b := range binds {
+		ret = append(ret, strings.TrimPrefix(b, `\Device\`))
+	}
+	return ret, nil
+}
@@ -17,10 +17,14 @@
 package envknob
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"sort"
 	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"tailscale.com/types/opt"
 )
@@ -29,14 +33,26 @@
 	mu   sync.Mutex
 	set  = map[string]string{}
 	list []string
+
+	// regBool, regStr, regInt and regDur are the knobs registered
+	// with RegisterBool, RegisterString, RegisterInt and
+	// RegisterDuration, whose values Setenv updates.
+	regBool = map[string]*atomic.Bool{}
+	regStr  = map[string]*atomic.Pointer[string]{}
+	regInt  = map[string]*atomic.Int64{}
+	regDur  = map[string]*atomic.Int64{}
 )
 
 func noteEnv(k, v string) {
+	mu.Lock()
+	defer mu.Unlock()
+	noteEnvLocked(k, v)
+}
+
+func noteEnvLocked(k, v string) {
 	if v == "" {
 		return
 	}
-	mu.Lock()
-	defer mu.Unlock()
 	if _, ok := set[k]; !ok {
 		list = append(list, k)
 	}
@@ -139,6 +155,199 @@ func LookupInt(envVar string) (v int, ok bool) {
 	panic("unreachable")
 }
 
+// LookupDuration returns the time.Duration value of the named
+// environment value, as parsed by time.ParseDuration.
+// The ok result is whether a value was set.
+// If the value isn't a valid duration, it exits the program with a failure.
+func LookupDuration(envVar string) (v time.Duration, ok bool) {
+	val := os.Getenv(envVar)
+	if val == "" {
+		return 0, false
+	}
+	v, err := time.ParseDuration(val)
+	if err == nil {
+		noteEnv(envVar, val)
+		return v, true
+	}
+	log.Fatalf("invalid duration environment variable %s: %v", envVar, val)
+	panic("unreachable")
+}
+
+// RegisterBool returns a func that reports the boolean value of the
+// named environment variable, like Bool, but which can be changed at
+// runtime with Setenv. An invalid value exits the binary with a
+// failure.
+func RegisterBool(envVar string) func() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	p, ok := regBool[envVar]
+	if !ok {
+		p = new(atomic.Bool)
+		if val := os.Getenv(envVar); val != "" {
+			b, err := strconv.ParseBool(val)
+			if err != nil {
+				log.Fatalf("invalid boolean environment variable %s value %q", envVar, val)
+			}
+			noteEnvLocked(envVar, strconv.FormatBool(b))
+			p.Store(b)
+		}
+		regBool[envVar] = p
+	}
+	return p.Load
+}
+
+// RegisterString returns a func that returns the named environment
+// variable, like String, but which can be changed at runtime with
+// Setenv.
+func RegisterString(envVar string) func() string {
+	mu.Lock()
+	defer mu.Unlock()
+	p, ok := regStr[envVar]
+	if !ok {
+		val := os.Getenv(envVar)
+		noteEnvLocked(envVar, val)
+		p = new(atomic.Pointer[string])
+		p.Store(&val)
+		regStr[envVar] = p
+	}
+	return func() string { return *p.Load() }
+}
+
+// RegisterInt returns a func that returns the integer value of the
+// named environment variable, or zero if unset, like LookupInt, but
+// which can be changed at runtime with Setenv. An invalid value exits
+// the binary with a failure.
+func RegisterInt(envVar string) func() int {
+	mu.Lock()
+	defer mu.Unlock()
+	p, ok := regInt[envVar]
+	if !ok {
+		p = new(atomic.Int64)
+		if val := os.Getenv(envVar); val != "" {
+			v, err := strconv.ParseInt(val, 0, 64)
+			if err != nil {
+				log.Fatalf("invalid integer environment variable %s value %q", envVar, val)
+			}
+			noteEnvLocked(envVar, val)
+			p.Store(v)
+		}
+		regInt[envVar] = p
+	}
+	return func() int { return int(p.Load()) }
+}
+
+// RegisterDuration returns a func that returns the duration value of
+// the named environment variable, or zero if unset, like
+// LookupDuration, but which can be changed at runtime with Setenv. An
+// invalid value exits the binary with a failure.
+func RegisterDuration(envVar string) func() time.Duration {
+	mu.Lock()
+	defer mu.Unlock()
+	p, ok := regDur[envVar]
+	if !ok {
+		p = new(atomic.Int64)
+		if val := os.Getenv(envVar); val != "" {
+			v, err := time.ParseDuration(val)
+			if err != nil {
+				log.Fatalf("invalid duration environment variable %s value %q", envVar, val)
+			}
+			noteEnvLocked(envVar, val)
+			p.Store(int64(v))
+		}
+		regDur[envVar] = p
+	}
+	return func() time.Duration { return time.Duration(p.Load()) }
+}
+
+// Setenv sets the named environment variable, which must have been
+// registered with one of the Register funcs, to val, changing the value
+// its registered func returns. The empty string unsets it.
+func Setenv(envVar, val string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if p, ok := regBool[envVar]; ok {
+		var b bool
+		if val != "" {
+			var err error
+			b, err = strconv.ParseBool(val)
+			if err != nil {
+				return fmt.Errorf("invalid boolean value %q for %s", val, envVar)
+			}
+			val = strconv.FormatBool(b)
+		}
+		p.Store(b)
+	} else if p, ok := regStr[envVar]; ok {
+		p.Store(&val)
+	} else if p, ok := regInt[envVar]; ok {
+		var v int64
+		if val != "" {
+			var err error
+			v, err = strconv.ParseInt(val, 0, 64)
+			if err != nil {
+				return fmt.Errorf("invalid integer value %q for %s", val, envVar)
+			}
+		}
+		p.Store(v)
+	} else if p, ok := regDur[envVar]; ok {
+		var v time.Duration
+		if val != "" {
+			var err error
+			v, err = time.ParseDuration(val)
+			if err != nil {
+				return fmt.Errorf("invalid duration value %q for %s", val, envVar)
+			}
+		}
+		p.Store(int64(v))
+	} else {
+		return fmt.Errorf("%s can't be changed at runtime", envVar)
+	}
+	if val == "" {
+		os.Unsetenv(envVar)
+		if _, ok := set[envVar]; ok {
+			delete(set, envVar)
+			for i, k := range list {
+				if k == envVar {
+					list = append(list[:i:i], list[i+1:]...)
+					break
+				}
+			}
+		}
+		return nil
+	}
+	os.Setenv(envVar, val)
+	noteEnvLocked(envVar, val)
+	return nil
+}
+
+// Knob is a knob registered with one of the Register funcs.
+type Knob struct {
+	Name  string
+	Value string // empty if unset
+	Bool  bool   // whether it was registered with RegisterBool
+}
+
+// Registered returns the knobs registered with the Register funcs,
+// sorted by name, with their current values.
+func Registered() []Knob {
+	mu.Lock()
+	defer mu.Unlock()
+	ret := make([]Knob, 0, len(regBool)+len(regStr)+len(regInt)+len(regDur))
+	for k := range regBool {
+		ret = append(ret, Knob{Name: k, Value: set[k], Bool: true})
+	}
+	for k, p := range regStr {
+		ret = append(ret, Knob{Name: k, Value: *p.Load()})
+	}
+	for k := range regInt {
+		ret = append(ret, Knob{Name: k, Value: set[k]})
+	}
+	for k := range regDur {
+		ret = append(ret, Knob{Name: k, Value: set[k]})
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
+
 // UseWIPCode is whether TAILSCALE_USE_WIP_CODE is set to permit use
 // of Work-In-Progress code.
 func UseWIPCode() bool { return Bool("TAILSCALE_USE_WIP_CODE") }
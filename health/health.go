@@ -38,11 +38,13 @@
 	derpHomeRegion          int
 	derpRegionConnected     = map[int]bool{}
 	derpRegionHealthProblem = map[int]string{}
+	derpRegionCongestion    = map[int]string{}
 	derpRegionLastFrame     = map[int]time.Time{}
 	lastMapRequestHeard     time.Time // time we got a 200 from control for a MapRequest
 	ipnState                string
 	ipnWantRunning          bool
 	anyInterfaceUp          = true // until told otherwise
+	uplinkReached           bool   // whether a DERP or control server was ever reached
 	udp4Unbound             bool
 	controlHealth           []string
 	lastLoginErr            error
@@ -72,6 +74,52 @@
 	// the Windows network adapter's "category" (public, private, domain).
 	// If it's unhealthy, the Windows firewall rules won't match.
 	SysNetworkCategory = Subsystem("network-category")
+
+	// SysResourceLeak is the name of the subsystem that watches for
+	// goroutine and file descriptor leaks in the process.
+	SysResourceLeak = Subsystem("resource-leak")
+
+	// SysSynthetic is the name of the subsystem that periodically runs
+	// synthetic connectivity checks, if enabled.
+	SysSynthetic = Subsystem("synthetic-checks")
+
+	// SysPortMap is the name of the net/portmapper subsystem, which
+	// is unhealthy when a NAT-PMP, PCP or UPnP mapping stops renewing.
+	SysPortMap = Subsystem("portmap")
+
+	// SysLocalNetwork is the name of the subsystem that pings the
+	// local network's default gateway, which is unhealthy when the
+	// pings are lost or slow, such as on bad Wi-Fi.
+	SysLocalNetwork = Subsystem("local-network")
+
+	// SysLatencySLO is the name of the subsystem that checks the
+	// latencies against the objectives set by the LatencySLOs pref,
+	// which is unhealthy while any is violated.
+	SysLatencySLO = Subsystem("latency-slo")
+
+	// SysDERPRelay is the name of the subsystem that checks the
+	// private DERP relay run by a tsnet program, if any, which is
+	// unhealthy when the relay fails to answer a probe over its public
+	// address or its consistency check.
+	SysDERPRelay = Subsystem("derp-relay")
+
+	// SysAdvertisedRoutes is the name of the subsystem that watches
+	// the local interfaces of the routes advertised by a subnet router,
+	// which is unhealthy when one of those interfaces moved to another
+	// subnet, so that the advertised route no longer reaches it.
+	SysAdvertisedRoutes = Subsystem("advertised-routes")
+
+	// SysUplink is the name of the subsystem that's unhealthy when the
+	// node has no usable network uplink: no interface is up, or, having
+	// reached them before, it's connected to neither a DERP server nor
+	// the control server.
+	SysUplink = Subsystem("uplink")
+
+	// SysMTU is the name of the subsystem that's unhealthy when a
+	// direct path to a peer was found to silently drop packets of the
+	// full MTU of the Tailscale interface, whether or not the MTU
+	// could be lowered to get around it.
+	SysMTU = Subsystem("mtu")
 )
 
 type watchHandle byte
@@ -127,6 +175,37 @@ func SetNetworkCategoryHealth(err error) { set(SysNetworkCategory, err) }
 
 func NetworkCategoryHealth() error { return get(SysNetworkCategory) }
 
+// SetResourceLeakHealth sets the state of the goroutine and file
+// descriptor leak watchdog.
+func SetResourceLeakHealth(err error) { set(SysResourceLeak, err) }
+
+// SetSyntheticHealth sets the state of the synthetic connectivity
+// checks.
+func SetSyntheticHealth(err error) { set(SysSynthetic, err) }
+
+// SetPortMapHealth sets the state of the port mapping client's lease
+// renewals.
+func SetPortMapHealth(err error) { set(SysPortMap, err) }
+
+// SetLocalNetworkHealth sets the state of the latency and loss to the
+// local network's default gateway.
+func SetLocalNetworkHealth(err error) { set(SysLocalNetwork, err) }
+
+// SetLatencySLOHealth sets the state of the latency objectives.
+func SetLatencySLOHealth(err error) { set(SysLatencySLO, err) }
+
+// SetDERPRelayHealth sets the state of the private DERP relay run by a
+// tsnet program.
+func SetDERPRelayHealth(err error) { set(SysDERPRelay, err) }
+
+// SetAdvertisedRoutesHealth sets the state of the routes advertised
+// by a subnet router with respect to the local interfaces they're on.
+func SetAdvertisedRoutesHealth(err error) { set(SysAdvertisedRoutes, err) }
+
+// SetMTUHealth sets the state of the Tailscale interface MTU with
+// respect to the paths to peers.
+func SetMTUHealth(err error) { set(SysMTU, err) }
+
 func RegisterDebugHandler(typ string, h http.Handler) {
 	mu.Lock()
 	defer mu.Unlock()
@@ -202,9 +281,18 @@ func SetInPollNetMap(v bool) {
 	inMapPoll = v
 	if v {
 		inMapPollSince = time.Now()
+		uplinkReached = true
 	} else {
 		lastMapPollEndedAt = time.Now()
+		// The map poll is restarted for every new map request;
+		// only count it as lost once it's been gone for a while.
+		time.AfterFunc(mapPollGrace, func() {
+			mu.Lock()
+			defer mu.Unlock()
+			setUplinkLocked()
+		})
 	}
+	setUplinkLocked()
 }
 
 // GetInPollNetMap reports whether the client has an open
@@ -240,7 +328,10 @@ func SetDERPRegionConnectedState(region int, connected bool) {
 	mu.Lock()
 	defer mu.Unlock()
 	derpRegionConnected[region] = connected
-	selfCheckLocked()
+	if connected {
+		uplinkReached = true
+	}
+	setUplinkLocked()
 }
 
 // SetDERPRegionHealth sets or clears any problem associated with the
@@ -256,6 +347,20 @@ func SetDERPRegionHealth(region int, problem string) {
 	selfCheckLocked()
 }
 
+// SetDERPRegionCongested sets or clears a warning that the path to the
+// provided DERP region is congested: writes to it are stalling or its
+// keep-alives aren't being acknowledged promptly.
+func SetDERPRegionCongested(region int, problem string) {
+	mu.Lock()
+	defer mu.Unlock()
+	if problem == "" {
+		delete(derpRegionCongestion, region)
+	} else {
+		derpRegionCongestion[region] = problem
+	}
+	selfCheckLocked()
+}
+
 func NoteDERPRegionReceivedFrame(region int) {
 	mu.Lock()
 	defer mu.Unlock()
@@ -277,6 +382,50 @@ func SetAnyInterfaceUp(up bool) {
 	mu.Lock()
 	defer mu.Unlock()
 	anyInterfaceUp = up
+	setUplinkLocked()
+}
+
+// OfflineReason returns why the node has no usable network uplink, or
+// the empty string if it appears to have one.
+func OfflineReason() string {
+	mu.Lock()
+	defer mu.Unlock()
+	return offlineReasonLocked()
+}
+
+// mapPollGrace is how long after the map poll ended control still
+// counts as reachable.
+const mapPollGrace = 10 * time.Second
+
+func offlineReasonLocked() string {
+	switch {
+	case !anyInterfaceUp:
+		return "no network interface is up"
+	case uplinkReached && !anyDERPConnectedLocked() && !controlReachableLocked():
+		return "no DERP or control server is reachable"
+	}
+	return ""
+}
+
+func anyDERPConnectedLocked() bool {
+	for _, connected := range derpRegionConnected {
+		if connected {
+			return true
+		}
+	}
+	return false
+}
+
+func controlReachableLocked() bool {
+	return inMapPoll || time.Since(lastMapPollEndedAt) < mapPollGrace
+}
+
+func setUplinkLocked() {
+	var err error
+	if r := offlineReasonLocked(); r != "" {
+		err = errors.New("offline: " + r)
+	}
+	setLocked(SysUplink, err)
 	selfCheckLocked()
 }
 
@@ -328,8 +477,10 @@ func OverallError() error {
 var fakeErrForTesting = envknob.String("TS_DEBUG_FAKE_HEALTH_ERROR")
 
 func overallErrorLocked() error {
-	if !anyInterfaceUp {
-		return errors.New("network down")
+	if err := sysErr[SysUplink]; err != nil {
+		// Everything else failing follows from this; don't
+		// bury it among generic control and DERP errors.
+		return err
 	}
 	if !ipnWantRunning {
 		return fmt.Errorf("state=%v, wantRunning=%v", ipnState, ipnWantRunning)
@@ -372,7 +523,7 @@ func overallErrorLocked() error {
 		}
 	}
 	for sys, err := range sysErr {
-		if err == nil || sys == SysOverall {
+		if err == nil || sys == SysOverall || sys == SysUplink {
 			continue
 		}
 		errs = append(errs, fmt.Errorf("%v: %w", sys, err))
@@ -380,6 +531,9 @@ func overallErrorLocked() error {
 	for regionID, problem := range derpRegionHealthProblem {
 		errs = append(errs, fmt.Errorf("derp%d: %v", regionID, problem))
 	}
+	for regionID, problem := range derpRegionCongestion {
+		errs = append(errs, fmt.Errorf("derp%d: %v", regionID, problem))
+	}
 	for _, s := range controlHealth {
 		errs = append(errs, errors.New(s))
 	}
@@ -22,7 +22,12 @@ func (src *Prefs) Clone() *Prefs {
 	}
 	dst := new(Prefs)
 	*dst = *src
+	dst.ExitNodeExcludeRoutes = append(src.ExitNodeExcludeRoutes[:0:0], src.ExitNodeExcludeRoutes...)
+	dst.ExitNodeExcludeApps = append(src.ExitNodeExcludeApps[:0:0], src.ExitNodeExcludeApps...)
 	dst.AdvertiseTags = append(src.AdvertiseTags[:0:0], src.AdvertiseTags...)
+	dst.UplinkPolicy = append(src.UplinkPolicy[:0:0], src.UplinkPolicy...)
+	dst.LatencySLOs = append(src.LatencySLOs[:0:0], src.LatencySLOs...)
+	dst.DERPAvoidRegions = append(src.DERPAvoidRegions[:0:0], src.DERPAvoidRegions...)
 	dst.AdvertiseRoutes = append(src.AdvertiseRoutes[:0:0], src.AdvertiseRoutes...)
 	if dst.Persist != nil {
 		dst.Persist = new(persist.Persist)
@@ -39,6 +44,8 @@ func (src *Prefs) Clone() *Prefs {
 	ExitNodeID             tailcfg.StableNodeID
 	ExitNodeIP             netip.Addr
 	ExitNodeAllowLANAccess bool
+	ExitNodeExcludeRoutes  []netip.Prefix
+	ExitNodeExcludeApps    []string
 	CorpDNS                bool
 	RunSSH                 bool
 	WantRunning            bool
@@ -49,9 +56,28 @@ func (src *Prefs) Clone() *Prefs {
 	NotepadURLs            bool
 	ForceDaemon            bool
 	Egg                    bool
+	ForceDERP              bool
+	UplinkPolicy           []string
+	SyntheticMonitorPeer   string
+	LatencySLOs            []string
+	PowerSaver             string
+	AllowRemoteDoctor      bool
+	AllowSpeedTest         bool
+	DoctorInterval         string
+	DoctorLogResults       bool
+	DoctorLightweight      bool
+	RecvBatchSize          int
+	DERPSendQueue          int
+	DERPHomeRegion         int
+	DERPAvoidRegions       []int
+	UDPPortRange           string
 	AdvertiseRoutes        []netip.Prefix
+	ReadvertiseRoutes      bool
 	NoSNAT                 bool
+	ClampMSS               bool
+	NoAutoMTU              bool
 	NetfilterMode          preftype.NetfilterMode
+	RouteMetric            int
 	OperatorUser           string
 	Persist                *persist.Persist
 }{})
@@ -0,0 +1,214 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnlocal
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+
+	"tailscale.com/health"
+	"tailscale.com/ipn"
+)
+
+// routeIface is the local interface an advertised route was last seen
+// on, and that interface's subnet the route overlapped.
+type routeIface struct {
+	name   string
+	subnet netip.Prefix
+}
+
+// routeMove is an advertised route whose local interface moved to
+// another subnet, such as when its DHCP lease changed.
+type routeMove struct {
+	route  netip.Prefix
+	iface  string
+	subnet netip.Prefix // the interface's new subnet; invalid if it has none
+
+	// whole is whether route was the interface's whole subnet, which
+	// readvertising replaces with the new one. Narrower routes, such
+	// as to a single host, aren't widened.
+	whole bool
+}
+
+func (m routeMove) String() string {
+	if !m.subnet.IsValid() {
+		family := "IPv4"
+		if m.route.Addr().Is6() {
+			family = "IPv6"
+		}
+		return fmt.Sprintf("advertised route %v was on %s, which has no %s address anymore", m.route, m.iface, family)
+	}
+	return fmt.Sprintf("advertised route %v was on %s, which is now on %v", m.route, m.iface, m.subnet)
+}
+
+// advertisedRoutesState tracks the local interfaces of the routes in
+// the AdvertiseRoutes pref, to tell when they move to another subnet.
+// It's only kept in memory: a move while tailscaled wasn't running
+// goes unnoticed here, but the advertised-routes doctor check flags it.
+type advertisedRoutesState struct {
+	mu   sync.Mutex // held while checking, including readvertising
+	seen map[netip.Prefix]routeIface
+}
+
+// checkAdvertisedRoutes compares the advertised routes against the
+// subnets of the local interfaces, raising a health warning for those
+// whose interface moved to another subnet and, if the ReadvertiseRoutes
+// pref is set, advertising the new subnet instead.
+func (b *LocalBackend) checkAdvertisedRoutes() {
+	s := &b.advertisedRoutes
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b.mu.Lock()
+	var routes []netip.Prefix
+	var readvertise bool
+	if b.prefs != nil {
+		routes = append(routes, b.prefs.AdvertiseRoutes...)
+		readvertise = b.prefs.ReadvertiseRoutes
+	}
+	ifst := b.prevIfState
+	b.mu.Unlock()
+	if ifst == nil {
+		return
+	}
+
+	var moved []routeMove
+	s.seen, moved = movedRoutes(routes, ifst.Subnets(), s.seen)
+	health.SetAdvertisedRoutesHealth(movedRoutesError(moved, readvertise))
+	if !readvertise {
+		return
+	}
+	newRoutes, changed := readvertisedRoutes(routes, moved)
+	if !changed {
+		return
+	}
+	b.logf("readvertising routes %v as %v after interface address changes", routes, newRoutes)
+	if _, err := b.EditPrefs(&ipn.MaskedPrefs{
+		Prefs:              ipn.Prefs{AdvertiseRoutes: newRoutes},
+		AdvertiseRoutesSet: true,
+	}); err != nil {
+		b.logf("readvertising routes: %v", err)
+	}
+}
+
+// movedRoutes matches the advertised routes against subnets, the
+// subnets of the local interfaces by name as returned by
+// interfaces.State.Subnets. It returns the interface each route is on
+// now, to pass as seen next time, and the routes whose interface, per
+// seen, moved to another subnet, in order. A route moved if no subnet
+// of its interface overlaps it anymore, or if it was exactly the
+// interface's subnet and that was resized. Exit routes and routes that
+// were never on a local interface are ignored, as they may well be
+// reached through a gateway.
+func movedRoutes(routes []netip.Prefix, subnets map[string][]netip.Prefix, seen map[netip.Prefix]routeIface) (now map[netip.Prefix]routeIface, moved []routeMove) {
+	now = map[netip.Prefix]routeIface{}
+	for _, r := range routes {
+		if r.Bits() == 0 {
+			continue
+		}
+		prev, wasSeen := seen[r]
+		if wasSeen {
+			if sub, ok := overlappingSubnet(subnets[prev.name], r); ok {
+				if sub != prev.subnet && r == prev.subnet {
+					moved = append(moved, routeMove{route: r, iface: prev.name, subnet: sub, whole: true})
+					now[r] = prev
+				} else {
+					now[r] = routeIface{prev.name, sub}
+				}
+				continue
+			}
+		}
+		if ri, ok := subnetInterface(subnets, r); ok {
+			now[r] = ri
+			continue
+		}
+		if wasSeen {
+			m := routeMove{route: r, iface: prev.name, whole: r == prev.subnet}
+			for _, sub := range subnets[prev.name] {
+				if sub.Addr().Is4() == r.Addr().Is4() {
+					m.subnet = sub
+					break
+				}
+			}
+			moved = append(moved, m)
+			now[r] = prev
+		}
+	}
+	return now, moved
+}
+
+// overlappingSubnet returns the first of subnets that overlaps route.
+func overlappingSubnet(subnets []netip.Prefix, route netip.Prefix) (netip.Prefix, bool) {
+	for _, sub := range subnets {
+		if sub.Overlaps(route) {
+			return sub, true
+		}
+	}
+	return netip.Prefix{}, false
+}
+
+// subnetInterface returns the interface with a subnet overlapping
+// route, the first by name if there are several.
+func subnetInterface(subnets map[string][]netip.Prefix, route netip.Prefix) (routeIface, bool) {
+	names := make([]string, 0, len(subnets))
+	for name := range subnets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if sub, ok := overlappingSubnet(subnets[name], route); ok {
+			return routeIface{name, sub}, true
+		}
+	}
+	return routeIface{}, false
+}
+
+// readvertisedRoutes returns routes with those that moved and were
+// their interface's whole subnet replaced by its new subnet, where it
+// has one, and whether that changed anything.
+func readvertisedRoutes(routes []netip.Prefix, moved []routeMove) ([]netip.Prefix, bool) {
+	replace := map[netip.Prefix]netip.Prefix{}
+	for _, m := range moved {
+		if m.whole && m.subnet.IsValid() {
+			replace[m.route] = m.subnet
+		}
+	}
+	if len(replace) == 0 {
+		return routes, false
+	}
+	var ret []netip.Prefix
+	have := map[netip.Prefix]bool{}
+	for _, r := range routes {
+		if sub, ok := replace[r]; ok {
+			r = sub
+		}
+		if !have[r] {
+			have[r] = true
+			ret = append(ret, r)
+		}
+	}
+	return ret, true
+}
+
+// movedRoutesError returns the health warning for moved, or nil if it's
+// empty.
+func movedRoutesError(moved []routeMove, readvertise bool) error {
+	if len(moved) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(moved))
+	for i, m := range moved {
+		msgs[i] = m.String()
+	}
+	msg := strings.Join(msgs, "; ")
+	if !readvertise {
+		msg += "; update --advertise-routes, or set --readvertise-routes to do so automatically"
+	}
+	return errors.New(msg)
+}
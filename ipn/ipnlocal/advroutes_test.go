@@ -0,0 +1,90 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnlocal
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestMovedRoutes(t *testing.T) {
+	pfx := netip.MustParsePrefix
+	routes := []netip.Prefix{pfx("192.168.1.0/24"), pfx("192.168.1.10/32"), pfx("10.20.0.0/16"), pfx("0.0.0.0/0")}
+	seen, moved := movedRoutes(routes, map[string][]netip.Prefix{
+		"eth0": {pfx("203.0.113.0/24")},
+		"eth1": {pfx("192.168.1.0/24"), pfx("2001:db8::/64")},
+	}, nil)
+	if len(moved) != 0 {
+		t.Fatalf("moved = %v; want none", moved)
+	}
+	want := map[netip.Prefix]routeIface{
+		pfx("192.168.1.0/24"):  {"eth1", pfx("192.168.1.0/24")},
+		pfx("192.168.1.10/32"): {"eth1", pfx("192.168.1.0/24")},
+	}
+	if !reflect.DeepEqual(seen, want) {
+		t.Fatalf("seen = %v; want %v", seen, want)
+	}
+
+	tests := []struct {
+		name    string
+		subnets map[string][]netip.Prefix
+		want    []routeMove
+	}{
+		{
+			name:    "unchanged",
+			subnets: map[string][]netip.Prefix{"eth1": {pfx("192.168.1.0/24")}},
+		},
+		{
+			name:    "new-subnet",
+			subnets: map[string][]netip.Prefix{"eth1": {pfx("2001:db8::/64"), pfx("192.168.7.0/24")}},
+			want: []routeMove{
+				{pfx("192.168.1.0/24"), "eth1", pfx("192.168.7.0/24"), true},
+				{pfx("192.168.1.10/32"), "eth1", pfx("192.168.7.0/24"), false},
+			},
+		},
+		{
+			name:    "resized",
+			subnets: map[string][]netip.Prefix{"eth1": {pfx("192.168.0.0/23")}},
+			want:    []routeMove{{pfx("192.168.1.0/24"), "eth1", pfx("192.168.0.0/23"), true}},
+		},
+		{
+			name:    "no-address",
+			subnets: map[string][]netip.Prefix{"eth1": {pfx("2001:db8::/64")}},
+			want: []routeMove{
+				{route: pfx("192.168.1.0/24"), iface: "eth1", whole: true},
+				{route: pfx("192.168.1.10/32"), iface: "eth1"},
+			},
+		},
+		{
+			name:    "other-interface",
+			subnets: map[string][]netip.Prefix{"eth2": {pfx("192.168.1.0/24")}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, got := movedRoutes(routes, tt.subnets, seen)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("moved = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadvertisedRoutes(t *testing.T) {
+	pfx := netip.MustParsePrefix
+	routes := []netip.Prefix{pfx("192.168.1.0/24"), pfx("192.168.1.10/32"), pfx("10.20.0.0/16")}
+	moved := []routeMove{
+		{pfx("192.168.1.0/24"), "eth1", pfx("192.168.7.0/24"), true},
+		{pfx("192.168.1.10/32"), "eth1", pfx("192.168.7.0/24"), false},
+	}
+	got, changed := readvertisedRoutes(routes, moved)
+	if want := []netip.Prefix{pfx("192.168.7.0/24"), pfx("192.168.1.10/32"), pfx("10.20.0.0/16")}; !changed || !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, %v; want %v, true", got, changed, want)
+	}
+	if _, changed := readvertisedRoutes(routes, []routeMove{{route: pfx("192.168.1.0/24"), iface: "eth1", whole: true}}); changed {
+		t.Errorf("readvertised a route whose interface has no address")
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnlocal
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"tailscale.com/health"
+	"tailscale.com/ipn"
+	"tailscale.com/net/tstun"
+	"tailscale.com/types/key"
+	"tailscale.com/version"
+	"tailscale.com/wgengine"
+	"tailscale.com/wgengine/magicsock"
+)
+
+const (
+	// minMTUBlackHolePeers is how many peers' paths must drop packets
+	// of the Tailscale interface's MTU before it's lowered, so that
+	// one bad path doesn't lower it for every peer.
+	minMTUBlackHolePeers = 2
+
+	// autoMTURaiseAfter is how long the Tailscale interface's MTU
+	// stays lowered before the configured MTU is restored, to find
+	// out whether the black holes are still there. If they are,
+	// magicsock finds them again and it's lowered again.
+	autoMTURaiseAfter = 30 * time.Minute
+)
+
+// autoMTUState is the MTU of the Tailscale interface as lowered for
+// path MTU black holes found by magicsock, unless the NoAutoMTU pref is
+// set. It's only kept in memory: tailscaled starts with the configured
+// MTU, and a major link change or autoMTURaiseAfter restores it.
+type autoMTUState struct {
+	mu      sync.Mutex
+	lowered int  // MTU the interface was lowered to; zero if it wasn't
+	touched bool // whether it was ever lowered, so it's to be set back when not
+
+	// holes are the black holes found at the current MTU, by peer.
+	holes map[key.NodePublic]magicsock.MTUBlackHole
+	// raise, if non-nil, restores the configured MTU after
+	// autoMTURaiseAfter. raiseGen is bumped each time the MTU is
+	// lowered or restored, so that a raise that fires late is ignored.
+	raise    *time.Timer
+	raiseGen int
+}
+
+// restoreLocked forgets the lowered MTU and the black holes found at
+// it, as for the configured MTU to be restored.
+//
+// s.mu must be held.
+func (s *autoMTUState) restoreLocked() {
+	s.lowered = 0
+	s.holes = nil
+	s.raiseGen++
+	if s.raise != nil {
+		s.raise.Stop()
+		s.raise = nil
+	}
+}
+
+// configuredTunMTU returns the MTU the Tailscale interface was created
+// with.
+func (b *LocalBackend) configuredTunMTU() int {
+	if wgengine.IsNetstack(b.e) {
+		return tstun.DefaultMTU
+	}
+	return tstun.ConfiguredMTU()
+}
+
+// canLowerMTU reports whether the router can set the MTU of the
+// Tailscale interface.
+func (b *LocalBackend) canLowerMTU() bool {
+	return version.OS() == "linux" && !wgengine.IsNetstackRouter(b.e)
+}
+
+// updateAutoMTU tells magicsock the current MTU of the Tailscale
+// interface, restoring the configured one if the NoAutoMTU pref was
+// set since it was lowered. It returns the MTU for the router config:
+// zero to leave the interface alone if its MTU was never lowered.
+func (b *LocalBackend) updateAutoMTU(prefs *ipn.Prefs) (routerMTU int) {
+	s := &b.autoMTU
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	configured := b.configuredTunMTU()
+	if s.lowered != 0 && prefs.NoAutoMTU {
+		b.logf("auto-mtu: turned off; restoring MTU %d", configured)
+		s.restoreLocked()
+		health.SetMTUHealth(nil)
+	}
+	cur := configured
+	switch {
+	case s.lowered != 0:
+		cur, routerMTU = s.lowered, s.lowered
+	case s.touched:
+		routerMTU = configured
+	}
+	if mc, err := b.magicConn(); err == nil {
+		mc.SetTunMTU(cur)
+	}
+	return routerMTU
+}
+
+// resetAutoMTU restores the configured MTU of the Tailscale interface
+// and clears any MTU health warning, as a black hole found on the
+// previous network may not be on the new one.
+func (b *LocalBackend) resetAutoMTU() {
+	s := &b.autoMTU
+	s.mu.Lock()
+	lowered := s.lowered
+	s.restoreLocked()
+	s.mu.Unlock()
+	health.SetMTUHealth(nil)
+	if lowered != 0 {
+		b.logf("auto-mtu: major link change; restoring MTU %d", b.configuredTunMTU())
+		b.authReconfig()
+	}
+}
+
+// raiseAutoMTU restores the configured MTU of the Tailscale interface
+// autoMTURaiseAfter after it was lowered, unless it was lowered or
+// restored again since, as gen tells.
+func (b *LocalBackend) raiseAutoMTU(gen int) {
+	s := &b.autoMTU
+	s.mu.Lock()
+	if gen != s.raiseGen || s.lowered == 0 {
+		s.mu.Unlock()
+		return
+	}
+	s.restoreLocked()
+	s.mu.Unlock()
+	b.logf("auto-mtu: restoring MTU %d to see if the black holes are still there", b.configuredTunMTU())
+	health.SetMTUHealth(nil)
+	b.authReconfig()
+}
+
+// onMTUBlackHole is called by magicsock with each path MTU black hole
+// found. It lowers the MTU of the Tailscale interface to get around it,
+// once paths to minMTUBlackHolePeers peers drop packets of its MTU, if
+// it can and the NoAutoMTU pref allows it, and raises a health warning
+// either way.
+func (b *LocalBackend) onMTUBlackHole(bh magicsock.MTUBlackHole) {
+	b.mu.Lock()
+	autoMTU := b.prefs != nil && !b.prefs.NoAutoMTU
+	peer := bh.Peer.ShortString()
+	if b.netMap != nil {
+		for _, p := range b.netMap.Peers {
+			if p.Key == bh.Peer {
+				peer = p.ComputedName
+				break
+			}
+		}
+	}
+	b.mu.Unlock()
+
+	s := &b.autoMTU
+	s.mu.Lock()
+	cur := s.lowered
+	if cur == 0 {
+		cur = b.configuredTunMTU()
+	}
+	if bh.MTU != cur {
+		// Found before the last change of MTU.
+		s.mu.Unlock()
+		return
+	}
+	if s.holes == nil {
+		s.holes = make(map[key.NodePublic]magicsock.MTUBlackHole)
+	}
+	s.holes[bh.Peer] = bh
+	lowerTo, warn := mtuBlackHoleAction(bh, peer, s.holes, b.canLowerMTU(), autoMTU)
+	if lowerTo != 0 {
+		s.restoreLocked()
+		s.lowered = lowerTo
+		s.touched = true
+		gen := s.raiseGen
+		s.raise = time.AfterFunc(autoMTURaiseAfter, func() { b.raiseAutoMTU(gen) })
+	}
+	s.mu.Unlock()
+
+	b.logf("auto-mtu: %v", warn)
+	health.SetMTUHealth(warn)
+	if lowerTo != 0 {
+		b.authReconfig()
+	}
+}
+
+// mtuBlackHoleAction returns the MTU to lower the Tailscale interface
+// to for bh, a black hole on the path to the peer named peer, or zero
+// to leave it, along with the health warning to raise. holes are the
+// black holes found at the current MTU, bh's included, by peer;
+// canLower is whether the MTU can be lowered on this platform, and
+// autoMTU whether the NoAutoMTU pref allows it.
+//
+// The MTU is only lowered once paths to minMTUBlackHolePeers peers
+// are black holes, to the smallest MTU that fits all of them.
+func mtuBlackHoleAction(bh magicsock.MTUBlackHole, peer string, holes map[key.NodePublic]magicsock.MTUBlackHole, canLower, autoMTU bool) (lowerTo int, warn error) {
+	drops := fmt.Sprintf("the direct path to %s (%v) drops packets of the Tailscale interface's MTU of %d", peer, bh.Path, bh.MTU)
+	switch {
+	case bh.FitMTU == 0:
+		return 0, fmt.Errorf("%s, which can't be lowered any further; check the MTU of this network", drops)
+	case !canLower:
+		return 0, fmt.Errorf("%s; %d would fit, but the MTU can't be lowered automatically on this platform", drops, bh.FitMTU)
+	case !autoMTU:
+		return 0, fmt.Errorf("%s; %d would fit, but automatic MTU lowering is off; run 'tailscale up --auto-mtu' to turn it on", drops, bh.FitMTU)
+	}
+	fit := 0
+	for _, h := range holes {
+		if h.FitMTU != 0 && (fit == 0 || h.FitMTU < fit) {
+			fit = h.FitMTU
+		}
+	}
+	if len(holes) < minMTUBlackHolePeers {
+		return 0, fmt.Errorf("%s; %d would fit, but the MTU is only lowered once paths to %d peers drop such packets", drops, bh.FitMTU, minMTUBlackHolePeers)
+	}
+	return fit, fmt.Errorf("%s, as do paths to %d other peers, so it was lowered to %d; run 'tailscale up --auto-mtu=false' to turn this off", drops, len(holes)-1, fit)
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnlocal
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+
+	"tailscale.com/types/key"
+	"tailscale.com/wgengine/magicsock"
+)
+
+func TestMTUBlackHoleAction(t *testing.T) {
+	peer1, peer2 := key.NewNode().Public(), key.NewNode().Public()
+	bh := magicsock.MTUBlackHole{
+		Peer:   peer1,
+		Path:   netip.MustParseAddrPort("1.2.3.4:41641"),
+		MTU:    1420,
+		FitMTU: 1380,
+	}
+	other := magicsock.MTUBlackHole{
+		Peer:   peer2,
+		Path:   netip.MustParseAddrPort("5.6.7.8:41641"),
+		MTU:    1420,
+		FitMTU: 1360,
+	}
+	tests := []struct {
+		name      string
+		fitMTU    int
+		otherPeer bool
+		canLower  bool
+		autoMTU   bool
+		want      int
+		wantWarn  string
+	}{
+		{"lowered", 1380, true, true, true, 1360, "so it was lowered to 1360"},
+		{"one_peer", 1380, false, true, true, 0, "only lowered once paths to 2 peers"},
+		{"nothing_fits", 0, true, true, true, 0, "can't be lowered any further"},
+		{"unsupported", 1380, true, false, true, 0, "can't be lowered automatically"},
+		{"auto_mtu_off", 1380, true, true, false, 0, "--auto-mtu"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bh := bh
+			bh.FitMTU = tt.fitMTU
+			holes := map[key.NodePublic]magicsock.MTUBlackHole{peer1: bh}
+			if tt.otherPeer {
+				holes[peer2] = other
+			}
+			got, warn := mtuBlackHoleAction(bh, "peer1", holes, tt.canLower, tt.autoMTU)
+			if got != tt.want {
+				t.Errorf("lowerTo = %d; want %d", got, tt.want)
+			}
+			if warn == nil || !strings.Contains(warn.Error(), tt.wantWarn) || !strings.Contains(warn.Error(), "peer1 (1.2.3.4:41641)") {
+				t.Errorf("warning = %v; want one naming the path and containing %q", warn, tt.wantWarn)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnlocal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"tailscale.com/types/logger"
+	"tailscale.com/version"
+)
+
+const (
+	// crashReportDir is the directory, under TailscaleVarRoot, that
+	// crash reports are written to.
+	crashReportDir = "crash-reports"
+
+	// maxCrashReports is the number of crash reports kept on disk.
+	maxCrashReports = 5
+
+	// crashLogWindow is how far back the recent logs included in a
+	// crash report go.
+	crashLogWindow = 5 * time.Minute
+
+	// maxCrashLogSize bounds the recent logs in a crash report. The
+	// newest lines are kept.
+	maxCrashLogSize = 256 << 10
+)
+
+// crashReport is the on-disk format of a crash report.
+type crashReport struct {
+	Time    time.Time
+	Version string
+	OS      string
+	Panic   string
+	Stack   string
+	Health  []string `json:",omitempty"`
+	Logs    string   `json:",omitempty"` // recent log lines
+}
+
+// WriteCrashReport writes a crash report for the panic value p, with
+// the stack of the panicking goroutine, to the state directory. It's
+// meant to be passed to crashreport.SetWriter.
+func (b *LocalBackend) WriteCrashReport(p any, stack []byte) {
+	if path, err := b.writeCrashReport(p, stack); err != nil {
+		b.logf("crash report: %v", err)
+	} else if path != "" {
+		b.logf("crash report written to %s", path)
+	}
+}
+
+// writeCrashReport writes a crash report for the panic value p with
+// stack trace stack, returning its path. It returns an empty path if
+// there's no state directory.
+func (b *LocalBackend) writeCrashReport(p any, stack []byte) (path string, err error) {
+	dir := b.crashReportDir()
+	if dir == "" {
+		return "", nil
+	}
+	now := time.Now()
+	cr := &crashReport{
+		Time:    now.UTC(),
+		Version: version.Long,
+		OS:      runtime.GOOS + "/" + runtime.GOARCH,
+		Panic:   fmt.Sprint(p),
+		Stack:   string(stack),
+		Health:  healthWarnings(),
+	}
+	if b.recentLogs != nil {
+		var buf bytes.Buffer
+		if err := b.recentLogs.WriteRecent(&buf, now.Add(-crashLogWindow), ""); err == nil {
+			logs := buf.Bytes()
+			if len(logs) > maxCrashLogSize {
+				logs = logs[len(logs)-maxCrashLogSize:]
+			}
+			cr.Logs = string(logs)
+		}
+	}
+	return writeRotatedJSON(dir, "crash-", cr.Time, cr, maxCrashReports)
+}
+
+// crashReportDir returns the directory crash reports are kept in, or
+// the empty string if there's no state directory.
+func (b *LocalBackend) crashReportDir() string {
+	root := b.TailscaleVarRoot()
+	if root == "" {
+		return ""
+	}
+	return filepath.Join(root, crashReportDir)
+}
+
+// LogCrashReports logs the panic and stack of each crash report kept on
+// disk, oldest first, along with its path, so a bug report references
+// crashes that happened before it.
+func (b *LocalBackend) LogCrashReports(logf logger.Logf) {
+	dir := b.crashReportDir()
+	if dir == "" {
+		return
+	}
+	names, err := rotatedFiles(dir, "crash-")
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logf("%v", err)
+		}
+		return
+	}
+	for _, n := range names {
+		path := filepath.Join(dir, n)
+		var cr crashReport
+		bs, err := os.ReadFile(path)
+		if err == nil {
+			err = json.Unmarshal(bs, &cr)
+		}
+		if err != nil {
+			logf("%s: %v", path, err)
+			continue
+		}
+		logf("%s: %s crash in %s: %s", path, cr.Time.Format(time.RFC3339), cr.Version, cr.Panic)
+		for _, h := range cr.Health {
+			logf("%s: health: %s", n, h)
+		}
+		for _, line := range strings.Split(strings.TrimSpace(cr.Stack), "\n") {
+			logf("%s: %s", n, line)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnlocal
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"tailscale.com/derp/derpmap"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+	"tailscale.com/util/crashreport"
+)
+
+// derpMapReloadInterval is how often a DERP map source set with
+// SetDERPMapSource is re-read for changes.
+const derpMapReloadInterval = 30 * time.Second
+
+// derpMapFetchTimeout is how long reading a DERP map from a URL may
+// take.
+const derpMapFetchTimeout = 10 * time.Second
+
+// SetDERPMapSource makes b use the DERP map at src, a local file path
+// or http(s) URL, in place of the one supplied by the control plane.
+// The source is re-read periodically and changes are applied live; a
+// map that fails to load or validate is logged and ignored, leaving
+// the previous map in use.
+//
+// A local file is read right away, and SetDERPMapSource returns an
+// error if that fails. A URL is fetched in the background, so that
+// startup doesn't wait on a network that may not be up yet; until it
+// loads, the control plane's map is used. It must be called at most
+// once, before Start.
+func (b *LocalBackend) SetDERPMapSource(src string) error {
+	if derpmap.IsURL(src) {
+		crashreport.Go(func() { b.reloadDERPMapSource(src, nil) })
+		return nil
+	}
+	raw, err := derpmap.Fetch(b.ctx, src)
+	if err != nil {
+		return err
+	}
+	dm, err := derpmap.Parse(raw)
+	if err != nil {
+		return err
+	}
+	b.logf("using DERP map from %s (%d regions)", src, len(dm.Regions))
+	b.setDERPMapOverride(dm)
+	crashreport.Go(func() { b.reloadDERPMapSource(src, raw) })
+	return nil
+}
+
+// reloadDERPMapSource re-reads the DERP map at src every
+// derpMapReloadInterval until b is shut down, applying it whenever it
+// differs from last. If last is nil, it reads it right away first.
+func (b *LocalBackend) reloadDERPMapSource(src string, last []byte) {
+	t := time.NewTicker(derpMapReloadInterval)
+	defer t.Stop()
+	for first := last == nil; ; first = false {
+		if !first {
+			select {
+			case <-b.ctx.Done():
+				return
+			case <-t.C:
+			}
+		}
+		ctx, cancel := context.WithTimeout(b.ctx, derpMapFetchTimeout)
+		raw, err := derpmap.Fetch(ctx, src)
+		cancel()
+		if err != nil {
+			b.logf("reloading DERP map from %s: %v", src, err)
+			continue
+		}
+		if bytes.Equal(raw, last) {
+			continue
+		}
+		dm, err := derpmap.Parse(raw)
+		if err != nil {
+			b.logf("reloading DERP map from %s: %v; keeping previous map", src, err)
+			continue
+		}
+		last = raw
+		b.logf("DERP map from %s changed (%d regions)", src, len(dm.Regions))
+		b.setDERPMapOverride(dm)
+	}
+}
+
+// setDERPMapOverride stores dm as the DERP map override and pushes it
+// to the engine.
+func (b *LocalBackend) setDERPMapOverride(dm *tailcfg.DERPMap) {
+	b.derpMapOverride.Store(dm)
+	b.e.SetDERPMap(dm)
+}
+
+// derpMapToUse returns the DERP map override if one is set, and
+// otherwise fromControl.
+func (b *LocalBackend) derpMapToUse(fromControl *tailcfg.DERPMap) *tailcfg.DERPMap {
+	if dm := b.derpMapOverride.Load(); dm != nil {
+		return dm
+	}
+	return fromControl
+}
+
+// DERPSelection returns the home DERP region and how it was selected
+// from the latencies measured by the last netcheck.
+func (b *LocalBackend) DERPSelection() (*ipnstate.DERPSelection, error) {
+	mc, err := b.magicConn()
+	if err != nil {
+		return nil, err
+	}
+	return mc.DERPSelection(), nil
+}
@@ -0,0 +1,254 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnlocal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/atomicfile"
+	"tailscale.com/net/netcheck"
+	"tailscale.com/types/logger"
+)
+
+const (
+	// diagSnapshotDir is the directory, under TailscaleVarRoot, that
+	// diagnostic snapshots are written to.
+	diagSnapshotDir = "diag-snapshots"
+
+	// maxDiagSnapshots is the number of snapshots kept on disk. Older
+	// ones are removed as new ones are written.
+	maxDiagSnapshots = 5
+
+	// minDiagSnapshotInterval is the minimum time between snapshots,
+	// so a flapping health check doesn't churn the disk.
+	minDiagSnapshotInterval = 10 * time.Minute
+
+	// maxDiagEvents is the number of recent events kept for inclusion
+	// in snapshots.
+	maxDiagEvents = 50
+
+	// maxDiagRoutesSize bounds the route table output in a snapshot.
+	maxDiagRoutesSize = 32 << 10
+
+	// rotatedTimeLayout is the layout of the times in the names of
+	// the files written by writeRotatedJSON. It sorts chronologically.
+	rotatedTimeLayout = "20060102T150405Z"
+)
+
+// diagSnapshotter is the state used by LocalBackend to take diagnostic
+// snapshots when health degrades.
+type diagSnapshotter struct {
+	mu     sync.Mutex
+	events []diagEvent // most recent last, at most maxDiagEvents
+	last   time.Time   // when the last snapshot was taken
+}
+
+type diagEvent struct {
+	Time time.Time
+	Msg  string
+}
+
+// diagSnapshot is the on-disk format of a diagnostic snapshot.
+type diagSnapshot struct {
+	Time       time.Time
+	Reason     string           // the health error that triggered it
+	Netcheck   *netcheck.Report `json:",omitempty"`
+	Interfaces string           `json:",omitempty"`
+	Routes     string           `json:",omitempty"`
+	Events     []diagEvent
+}
+
+// noteDiagEvent records an event to be included in the next
+// diagnostic snapshot.
+func (b *LocalBackend) noteDiagEvent(format string, args ...any) {
+	ds := &b.diagSnap
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.events = append(ds.events, diagEvent{Time: time.Now(), Msg: fmt.Sprintf(format, args...)})
+	if n := len(ds.events); n > maxDiagEvents {
+		ds.events = append(ds.events[:0], ds.events[n-maxDiagEvents:]...)
+	}
+}
+
+// maybeTakeDiagSnapshot writes a diagnostic snapshot to disk, unless
+// there's no state directory or one was taken recently.
+func (b *LocalBackend) maybeTakeDiagSnapshot(reason string) {
+	dir := b.diagSnapshotDir()
+	if dir == "" {
+		return
+	}
+	ds := &b.diagSnap
+	ds.mu.Lock()
+	now := time.Now()
+	if !ds.last.IsZero() && now.Sub(ds.last) < minDiagSnapshotInterval {
+		ds.mu.Unlock()
+		return
+	}
+	ds.last = now
+	events := append([]diagEvent(nil), ds.events...)
+	ds.mu.Unlock()
+
+	snap := &diagSnapshot{
+		Time:   now.UTC(),
+		Reason: reason,
+		Events: events,
+	}
+	if mc, err := b.magicConn(); err == nil {
+		snap.Netcheck = mc.LastNetcheckReport()
+	}
+	if st := b.e.GetLinkMonitor().InterfaceState(); st != nil {
+		snap.Interfaces = st.String()
+	}
+	snap.Routes = diagRouteTable(b.ctx)
+
+	path, err := writeDiagSnapshot(dir, snap)
+	if err != nil {
+		b.logf("diag snapshot: %v", err)
+		return
+	}
+	b.logf("diag snapshot written to %s", path)
+}
+
+// diagSnapshotDir returns the directory snapshots are kept in, or the
+// empty string if there's no state directory.
+func (b *LocalBackend) diagSnapshotDir() string {
+	root := b.TailscaleVarRoot()
+	if root == "" {
+		return ""
+	}
+	return filepath.Join(root, diagSnapshotDir)
+}
+
+// writeDiagSnapshot writes snap to dir and removes all but the newest
+// maxDiagSnapshots snapshots in dir.
+func writeDiagSnapshot(dir string, snap *diagSnapshot) (path string, err error) {
+	return writeRotatedJSON(dir, "snapshot-", snap.Time, snap, maxDiagSnapshots)
+}
+
+// diagSnapshotFiles returns the names of the snapshots in dir, oldest
+// first.
+func diagSnapshotFiles(dir string) ([]string, error) {
+	return rotatedFiles(dir, "snapshot-")
+}
+
+// LogDiagSnapshots logs the diagnostic snapshots kept on disk, oldest
+// first, so a bug report includes the state at the time health last
+// degraded.
+func (b *LocalBackend) LogDiagSnapshots(logf logger.Logf) {
+	dir := b.diagSnapshotDir()
+	if dir == "" {
+		return
+	}
+	logRotatedFiles(logf, dir, "snapshot-")
+}
+
+// writeRotatedJSON writes v as indented JSON to a file in dir named
+// with prefix and t, and removes all but the newest keep such files.
+func writeRotatedJSON(dir, prefix string, t time.Time, v any, keep int) (path string, err error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	j, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return "", err
+	}
+	path = filepath.Join(dir, prefix+t.UTC().Format(rotatedTimeLayout)+".json")
+	if err := atomicfile.WriteFile(path, j, 0600); err != nil {
+		return "", err
+	}
+	names, err := rotatedFiles(dir, prefix)
+	if err != nil {
+		return path, nil
+	}
+	for len(names) > keep {
+		os.Remove(filepath.Join(dir, names[0]))
+		names = names[1:]
+	}
+	return path, nil
+}
+
+// rotatedFiles returns the names of the files in dir written by
+// writeRotatedJSON with prefix, oldest first.
+func rotatedFiles(dir, prefix string) ([]string, error) {
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, de := range ents {
+		if n := de.Name(); strings.HasPrefix(n, prefix) && strings.HasSuffix(n, ".json") {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names) // the timestamp format sorts chronologically
+	return names, nil
+}
+
+// logRotatedFiles logs the contents of the files in dir written by
+// writeRotatedJSON with prefix, oldest first.
+func logRotatedFiles(logf logger.Logf, dir, prefix string) {
+	names, err := rotatedFiles(dir, prefix)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logf("%v", err)
+		}
+		return
+	}
+	for _, n := range names {
+		bs, err := os.ReadFile(filepath.Join(dir, n))
+		if err != nil {
+			logf("%s: %v", n, err)
+			continue
+		}
+		for _, line := range strings.Split(strings.TrimSpace(string(bs)), "\n") {
+			logf("%s: %s", n, line)
+		}
+	}
+}
+
+// diagRouteTable returns the system's route table, as printed by the
+// platform's usual tool, truncated to maxDiagRoutesSize.
+func diagRouteTable(ctx context.Context) string {
+	var cmds [][]string
+	switch runtime.GOOS {
+	case "linux":
+		cmds = [][]string{
+			{"ip", "-4", "route", "show", "table", "all"},
+			{"ip", "-6", "route", "show", "table", "all"},
+		}
+	case "darwin", "freebsd", "openbsd":
+		cmds = [][]string{{"netstat", "-rn"}}
+	case "windows":
+		cmds = [][]string{{"route", "print"}}
+	default:
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	var sb strings.Builder
+	for _, c := range cmds {
+		out, err := exec.CommandContext(ctx, c[0], c[1:]...).CombinedOutput()
+		fmt.Fprintf(&sb, "$ %s\n", strings.Join(c, " "))
+		sb.Write(out)
+		if err != nil {
+			fmt.Fprintf(&sb, "error: %v\n", err)
+		}
+	}
+	s := sb.String()
+	if len(s) > maxDiagRoutesSize {
+		s = s[:maxDiagRoutesSize] + "\n[truncated]"
+	}
+	return s
+}
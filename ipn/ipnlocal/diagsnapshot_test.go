@@ -0,0 +1,71 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnlocal
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteDiagSnapshotRotation(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2022, 9, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < maxDiagSnapshots+2; i++ {
+		snap := &diagSnapshot{
+			Time:   start.Add(time.Duration(i) * time.Hour),
+			Reason: fmt.Sprintf("failure %d", i),
+		}
+		if _, err := writeDiagSnapshot(dir, snap); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names, err := diagSnapshotFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != maxDiagSnapshots {
+		t.Fatalf("got %d snapshots; want %d: %q", len(names), maxDiagSnapshots, names)
+	}
+	if want := "snapshot-20220901T140000Z.json"; names[0] != want {
+		t.Errorf("oldest snapshot = %q; want %q", names[0], want)
+	}
+}
+
+func TestNoteDiagEvent(t *testing.T) {
+	b := &LocalBackend{}
+	for i := 0; i < maxDiagEvents+10; i++ {
+		b.noteDiagEvent("event %d", i)
+	}
+	ev := b.diagSnap.events
+	if len(ev) != maxDiagEvents {
+		t.Fatalf("got %d events; want %d", len(ev), maxDiagEvents)
+	}
+	if got, want := ev[0].Msg, "event 10"; got != want {
+		t.Errorf("oldest event = %q; want %q", got, want)
+	}
+}
+
+func TestWriteCrashReport(t *testing.T) {
+	b := &LocalBackend{varRoot: t.TempDir(), logf: t.Logf}
+	path, err := b.writeCrashReport("boom", []byte("goroutine 1 [running]:\nmain.main()"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path == "" {
+		t.Fatal("no crash report written")
+	}
+	var lines []string
+	b.LogCrashReports(func(format string, args ...any) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	})
+	if len(lines) < 3 {
+		t.Fatalf("logged %d lines; want at least 3: %q", len(lines), lines)
+	}
+	if !strings.Contains(lines[0], "boom") || !strings.HasSuffix(lines[len(lines)-1], "main.main()") {
+		t.Errorf("unexpected log lines: %q", lines)
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnlocal
+
+import (
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/types/key"
+)
+
+// handshakeStaleAfter is how old the last WireGuard handshake with a
+// peer can be before its session is no longer used: WireGuard's
+// REJECT_AFTER_TIME.
+const handshakeStaleAfter = 180 * time.Second
+
+// updateDisconnectReasons sets the DisconnectReason of each peer in sb
+// that this node tried to reach but has no current WireGuard session
+// with. It must be called after everything else has added the peers to
+// sb.
+func (b *LocalBackend) updateDisconnectReasons(sb *ipnstate.StatusBuilder) {
+	b.mu.Lock()
+	var offline map[key.NodePublic]bool
+	if b.netMap != nil {
+		offline = make(map[key.NodePublic]bool, len(b.netMap.Peers))
+		for _, p := range b.netMap.Peers {
+			// A nil Online means control didn't say.
+			if p.Online != nil && !*p.Online {
+				offline[p.Key] = true
+			}
+		}
+	}
+	b.mu.Unlock()
+
+	now := time.Now()
+	sb.MutateStatus(func(st *ipnstate.Status) {
+		for pub, ps := range st.Peer {
+			ps.DisconnectReason = disconnectReason(now, ps, offline[pub])
+		}
+	})
+}
+
+// disconnectReason returns why there's no current WireGuard session
+// with the peer ps, or the empty string if there is one or this node
+// didn't try to reach the peer since its last handshake: an idle peer
+// has no session, and nothing's wrong with that. The peer is offline
+// if the control server reports it as such. ps.DisconnectReason is
+// DisconnectEndpointExpired if magicsock's disco pings on the direct
+// path to the peer went unanswered.
+func disconnectReason(now time.Time, ps *ipnstate.PeerStatus, offline bool) ipnstate.DisconnectReason {
+	if !ps.LastHandshake.IsZero() && now.Sub(ps.LastHandshake) < handshakeStaleAfter {
+		return ""
+	}
+	tried := ps.Active ||
+		!ps.LastWrite.IsZero() && ps.LastWrite.After(ps.LastHandshake) && now.Sub(ps.LastWrite) < handshakeStaleAfter
+	if !tried {
+		return ""
+	}
+	switch {
+	case offline:
+		return ipnstate.DisconnectPeerOffline
+	case ps.DisconnectReason == ipnstate.DisconnectEndpointExpired:
+		return ipnstate.DisconnectEndpointExpired
+	case ps.LastHandshake.IsZero():
+		return ipnstate.DisconnectNeverHandshake
+	}
+	return ipnstate.DisconnectHandshakeStale
+}
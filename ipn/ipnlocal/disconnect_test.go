@@ -0,0 +1,75 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnlocal
+
+import (
+	"testing"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+)
+
+func TestDisconnectReason(t *testing.T) {
+	now := time.Date(2022, 10, 16, 9, 0, 0, 0, time.UTC)
+	recent := now.Add(-time.Minute)
+	old := now.Add(-time.Hour)
+	tests := []struct {
+		name    string
+		ps      ipnstate.PeerStatus
+		offline bool
+		want    ipnstate.DisconnectReason
+	}{
+		{
+			name: "connected",
+			ps:   ipnstate.PeerStatus{LastHandshake: recent},
+		},
+		{
+			name:    "connected_despite_everything",
+			ps:      ipnstate.PeerStatus{LastHandshake: recent, Active: true, DisconnectReason: ipnstate.DisconnectEndpointExpired},
+			offline: true,
+		},
+		{
+			name:    "idle",
+			ps:      ipnstate.PeerStatus{LastHandshake: old, LastWrite: old},
+			offline: true,
+		},
+		{
+			name: "idle_never_handshake",
+			ps:   ipnstate.PeerStatus{},
+		},
+		{
+			name:    "offline",
+			ps:      ipnstate.PeerStatus{LastHandshake: old, Active: true},
+			offline: true,
+			want:    ipnstate.DisconnectPeerOffline,
+		},
+		{
+			name: "endpoint_expired",
+			ps:   ipnstate.PeerStatus{LastHandshake: old, Active: true, DisconnectReason: ipnstate.DisconnectEndpointExpired},
+			want: ipnstate.DisconnectEndpointExpired,
+		},
+		{
+			name: "never_handshake",
+			ps:   ipnstate.PeerStatus{Active: true},
+			want: ipnstate.DisconnectNeverHandshake,
+		},
+		{
+			name: "handshake_stale",
+			ps:   ipnstate.PeerStatus{LastHandshake: now.Add(-handshakeStaleAfter), LastWrite: recent},
+			want: ipnstate.DisconnectHandshakeStale,
+		},
+		{
+			name: "sent_before_last_handshake",
+			ps:   ipnstate.PeerStatus{LastHandshake: now.Add(-handshakeStaleAfter), LastWrite: now.Add(-handshakeStaleAfter - time.Second)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := disconnectReason(now, &tt.ps, tt.offline); got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
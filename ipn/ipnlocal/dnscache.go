@@ -0,0 +1,57 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnlocal
+
+import (
+	"errors"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/dns/resolver"
+	"tailscale.com/wgengine"
+)
+
+// dnsResolver returns the MagicDNS resolver.
+func (b *LocalBackend) dnsResolver() (*resolver.Resolver, error) {
+	re, ok := b.e.(wgengine.ResolvingEngine)
+	if !ok {
+		return nil, errors.New("no DNS resolver")
+	}
+	r, ok := re.GetResolver()
+	if !ok {
+		return nil, errors.New("no DNS resolver")
+	}
+	return r, nil
+}
+
+// DNSCache returns the contents of the MagicDNS forwarder's cache of
+// upstream responses.
+func (b *LocalBackend) DNSCache() (*ipnstate.DNSCache, error) {
+	r, err := b.dnsResolver()
+	if err != nil {
+		return nil, err
+	}
+	return r.CacheStatus(), nil
+}
+
+// DNSQueryStats returns the stats of the DNS queries that local clients
+// sent to MagicDNS at 100.100.100.100:53.
+func (b *LocalBackend) DNSQueryStats() (*ipnstate.DNSQueryStats, error) {
+	r, err := b.dnsResolver()
+	if err != nil {
+		return nil, err
+	}
+	return r.QueryStats(), nil
+}
+
+// FlushDNSCache drops the MagicDNS forwarder's cached responses.
+func (b *LocalBackend) FlushDNSCache() error {
+	r, err := b.dnsResolver()
+	if err != nil {
+		return err
+	}
+	r.FlushCache()
+	b.logf("flushed DNS cache")
+	return nil
+}
@@ -0,0 +1,352 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnlocal
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/doctor"
+	"tailscale.com/doctor/advroutes"
+	"tailscale.com/doctor/derp"
+	"tailscale.com/doctor/external"
+	"tailscale.com/doctor/firewall"
+	"tailscale.com/doctor/ipv6only"
+	"tailscale.com/doctor/ipv6temp"
+	"tailscale.com/doctor/mssclamp"
+	"tailscale.com/doctor/mtu"
+	"tailscale.com/doctor/portrange"
+	"tailscale.com/doctor/preflight"
+	"tailscale.com/doctor/rawdisco"
+	"tailscale.com/doctor/rpfilter"
+	"tailscale.com/doctor/splitdns"
+	"tailscale.com/doctor/srcaddr"
+	"tailscale.com/doctor/stalestate"
+	"tailscale.com/doctor/winadapters"
+	"tailscale.com/envknob"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/hostfw"
+	"tailscale.com/paths"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/logger"
+	"tailscale.com/types/preftype"
+)
+
+// doctorMaxParallel, if positive, limits how many doctor checks run at
+// once, such as to 1 on a small router that runs them one at a time.
+var doctorMaxParallel = envknob.RegisterInt("TS_DOCTOR_MAX_PARALLEL")
+
+// Doctor runs in-depth diagnostic checks, logging their results to logf,
+// and returns them.
+//
+// If profile is non-empty, it names the state key of a stored profile
+// whose prefs are checked in addition to those of the active profile.
+// If only is non-empty, only the checks it names run, and if cats is
+// non-empty, only the checks in those categories. What the checks log
+// and return is redacted per redact. Runs of all checks, unredacted,
+// are kept in the state store to diff; see DoctorRuns.
+func (b *LocalBackend) Doctor(ctx context.Context, logf logger.Logf, profile ipn.StateKey, only []string, cats []doctor.Category, redact doctor.Redaction) []doctor.Result {
+	checks := b.doctorChecks(profile)
+	if len(only) > 0 {
+		checks = append(checks, doctor.WithOnly(only...))
+	}
+	if len(cats) > 0 {
+		checks = append(checks, doctor.WithOnlyCategories(cats...))
+	}
+	checks = append(checks, doctor.WithRedaction(redact))
+	start := time.Now().UTC()
+	res := doctor.RunChecks(ctx, logf, checks...)
+	if keepDoctorRun(only, cats, redact) {
+		b.recordDoctorRun(&apitype.DoctorRun{Time: start, Checks: doctorCheckResults(res)})
+	}
+	return res
+}
+
+// Diagnostics runs the doctor checks and gathers the other information
+// shown on the diagnostics page of the web UI.
+func (b *LocalBackend) Diagnostics(ctx context.Context) *ipnstate.Diagnostics {
+	d := &ipnstate.Diagnostics{Time: time.Now()}
+	if mc, err := b.magicConn(); err == nil {
+		d.NetInfo = mc.LastNetInfo()
+	}
+
+	var mu sync.Mutex // checks run concurrently
+	doctor.RunChecks(ctx, func(format string, args ...any) {
+		mu.Lock()
+		defer mu.Unlock()
+		d.Doctor = append(d.Doctor, fmt.Sprintf(format, args...))
+	}, b.doctorChecks("")...)
+
+	d.Routes = diagRouteTable(ctx)
+	return d
+}
+
+func (b *LocalBackend) doctorChecks(profile ipn.StateKey) []doctor.Check {
+	dm := b.DERPMap()
+	if dm == nil {
+		// Not connected; a local override can still be checked.
+		dm = b.derpMapOverride.Load()
+	}
+	b.mu.Lock()
+	var nfMode preftype.NetfilterMode
+	var forwarding, clampMSS, exitNode, corpDNS bool
+	var controlURL, magicDNSSuffix, portRange, dnsName string
+	var peerEndpoints []srcaddr.Dest
+	var selfAddrs []netip.Addr
+	var advRoutes []netip.Prefix
+	if b.prefs != nil {
+		nfMode = b.prefs.NetfilterMode
+		controlURL = b.prefs.ControlURLOrDefault()
+		portRange = b.prefs.UDPPortRange
+		forwarding = len(b.prefs.AdvertiseRoutes) > 0
+		advRoutes = append(advRoutes, b.prefs.AdvertiseRoutes...)
+		clampMSS = b.prefs.ClampMSS
+		exitNode = !b.prefs.ExitNodeID.IsZero() || b.prefs.ExitNodeIP.IsValid()
+		corpDNS = b.prefs.CorpDNS
+	}
+	if b.netMap != nil {
+		magicDNSSuffix = b.netMap.MagicDNSSuffix()
+		if corpDNS && b.netMap.DNS.Proxied {
+			dnsName = b.netMap.Name
+			for _, pfx := range b.netMap.Addresses {
+				selfAddrs = append(selfAddrs, pfx.Addr())
+			}
+		}
+		for _, p := range b.netMap.Peers {
+			for _, ep := range p.Endpoints {
+				if ap, err := netip.ParseAddrPort(ep); err == nil && ap.Addr().Is6() {
+					peerEndpoints = append(peerEndpoints, srcaddr.Dest{Name: p.ComputedName, Addr: ap})
+				}
+			}
+		}
+	}
+	b.mu.Unlock()
+	udpPort := b.udpPort()
+	var endpoints []tailcfg.Endpoint
+	var rawDiscoListeners []string
+	if mc, err := b.magicConn(); err == nil {
+		endpoints = mc.LastEndpoints()
+		rawDiscoListeners = mc.RawDiscoListeners()
+	}
+	// An invalid range is reported by the profiles check.
+	pr, _ := preftype.ParsePortRange(portRange)
+
+	checks := []doctor.Check{
+		advroutes.Check{Routes: advRoutes},
+		derp.Check{DERPMap: dm},
+		firewall.Check{NetfilterMode: nfMode},
+		hostfw.Check{Port: udpPort},
+		ipv6only.Check{ControlURL: controlURL, DERPMap: dm},
+		ipv6temp.Check{Endpoints: endpoints},
+		mssclamp.Check{Forwarding: forwarding, ClampMSS: clampMSS},
+		mtu.Check{},
+		portrange.Check{Range: pr, Current: udpPort, DERPMap: dm},
+		rawdisco.Check{Listeners: rawDiscoListeners},
+		rpfilter.Check{Forwarding: forwarding, ExitNode: exitNode},
+		splitdns.Check{MagicDNSName: dnsName, Addrs: selfAddrs},
+		srcaddr.Check{ControlURL: controlURL, DERPMap: dm, Peers: peerEndpoints},
+		winadapters.Check{MagicDNSSuffix: magicDNSSuffix},
+		b.staleStateCheck(false),
+		doctor.InCategory(doctor.LightweightCheckFunc("profiles", func(ctx context.Context, logf logger.Logf) error {
+			return b.checkProfiles(logf, profile)
+		}), doctor.CategoryPlatform),
+	}
+	if dir := b.doctorChecksDir.Load(); dir != "" {
+		checks = append(checks, external.Checks(dir)...)
+	}
+	if n := doctorMaxParallel(); n > 0 {
+		checks = append(checks, doctor.WithMaxParallel(n))
+	}
+	return checks
+}
+
+// SetDoctorChecksDir sets the directory of the site's external doctor
+// checks, executables that are run along with the built-in checks. See
+// package doctor/external.
+func (b *LocalBackend) SetDoctorChecksDir(dir string) {
+	b.doctorChecksDir.Store(dir)
+}
+
+// DebugCleanStaleState removes stale local state found by the
+// stale-state doctor check, logging what it does.
+func (b *LocalBackend) DebugCleanStaleState(ctx context.Context) error {
+	c := b.staleStateCheck(true)
+	return c.Run(ctx, logger.WithPrefix(b.logf, c.Name()+": "))
+}
+
+// SetPreflightConfig sets the tailscaled configuration that Preflight
+// validates the environment for.
+func (b *LocalBackend) SetPreflightConfig(cfg preflight.Config) {
+	b.preflightConfig.Store(cfg)
+}
+
+// Preflight runs the preflight checks, as "tailscaled --preflight" does
+// before starting, against the running tailscaled's configuration.
+func (b *LocalBackend) Preflight(ctx context.Context) *apitype.PreflightReport {
+	cfg := b.preflightConfig.Load()
+	cfg.Running = true
+	b.mu.Lock()
+	if b.prefs != nil {
+		cfg.Forwarding = len(b.prefs.AdvertiseRoutes) > 0
+	}
+	b.mu.Unlock()
+	return preflight.Run(ctx, cfg)
+}
+
+func (b *LocalBackend) staleStateCheck(clean bool) stalestate.Check {
+	c := stalestate.Check{
+		Store:    b.store,
+		StateDir: b.TailscaleVarRoot(),
+		Clean:    clean,
+	}
+	if sock := paths.DefaultTailscaledSocket(); sock != "" {
+		c.SocketDir = filepath.Dir(sock)
+	}
+	if runtime.GOOS == "linux" {
+		// Written by the OpenRC script, and by similar ones for
+		// other init systems.
+		c.PIDFiles = []string{"/run/tailscaled.pid"}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c.CurrentKey = b.stateKey
+	if b.netMap != nil {
+		c.KeyExpiry = b.netMap.Expiry
+	}
+	return c
+}
+
+// checkProfiles logs the active profile and, for each other stored
+// profile, how its prefs differ from the active ones. Every profile's
+// prefs are validated as if they were about to be applied.
+//
+// If only is non-empty, other profiles besides only are skipped. It
+// must be a profile's state key: only profiles are read from the store.
+func (b *LocalBackend) checkProfiles(logf logger.Logf, only ipn.StateKey) error {
+	if only != "" && !isProfileStateKey(only) {
+		return fmt.Errorf("%q is not a profile state key", only)
+	}
+	b.mu.Lock()
+	active := b.stateKey
+	var activePrefs *ipn.Prefs
+	if b.prefs != nil {
+		activePrefs = b.prefs.Clone()
+	}
+	b.mu.Unlock()
+
+	if active == "" {
+		logf("active profile: none (prefs supplied by the frontend)")
+	} else {
+		logf("active profile: %q (%s)", active, prefsLogin(activePrefs))
+	}
+	var errs []string
+	if activePrefs != nil {
+		if err := b.CheckPrefs(activePrefs); err != nil {
+			errs = append(errs, fmt.Sprintf("%q: %v", active, err))
+		}
+	}
+
+	var keys []ipn.StateKey
+	if only != "" {
+		keys = []ipn.StateKey{only}
+	} else if ks, ok := b.ProfileKeys(); ok {
+		keys = ks
+	} else {
+		logf("state store can't list profiles; only the active one is checked")
+	}
+	for _, k := range keys {
+		if k == active {
+			continue
+		}
+		bs, err := b.store.ReadState(k)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%q: %v", k, err))
+			continue
+		}
+		p, err := ipn.PrefsFromBytes(bs)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%q: %v", k, err))
+			continue
+		}
+		logf("profile %q (%s)", k, prefsLogin(p))
+		if activePrefs != nil {
+			for _, d := range prefsDiff(activePrefs, p) {
+				logf("profile %q: %s", k, d)
+			}
+		}
+		if err := b.CheckPrefs(p); err != nil {
+			errs = append(errs, fmt.Sprintf("%q: %v", k, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid prefs: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// ProfileKeys returns the sorted state keys of the stored profiles. It
+// reports false if the state store can't list its keys.
+func (b *LocalBackend) ProfileKeys() ([]ipn.StateKey, bool) {
+	kl, ok := b.store.(stalestate.KeyLister)
+	if !ok {
+		return nil, false
+	}
+	var keys []ipn.StateKey
+	for _, k := range kl.Keys() {
+		if isProfileStateKey(k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys, true
+}
+
+// isProfileStateKey reports whether k holds a profile's prefs, rather
+// than other state such as the machine key.
+func isProfileStateKey(k ipn.StateKey) bool {
+	return k == ipn.GlobalDaemonStateKey || strings.HasPrefix(string(k), "user-")
+}
+
+func prefsLogin(p *ipn.Prefs) string {
+	switch {
+	case p == nil:
+		return "no prefs"
+	case p.Persist == nil || p.Persist.LoginName == "":
+		return "not logged in"
+	}
+	return p.Persist.LoginName
+}
+
+// prefsDiff describes the fields that differ between the active
+// prefs and other, one field per element. Persist is skipped, as it
+// holds private keys.
+func prefsDiff(active, other *ipn.Prefs) []string {
+	var ret []string
+	av := reflect.ValueOf(active).Elem()
+	ov := reflect.ValueOf(other).Elem()
+	t := av.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Name == "Persist" || !f.IsExported() {
+			continue
+		}
+		a, o := av.Field(i).Interface(), ov.Field(i).Interface()
+		if !reflect.DeepEqual(a, o) {
+			ret = append(ret, fmt.Sprintf("%s: %v (active: %v)", f.Name, o, a))
+		}
+	}
+	return ret
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnlocal
+
+import (
+	"reflect"
+	"testing"
+
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/store/mem"
+	"tailscale.com/types/persist"
+)
+
+func TestPrefsDiff(t *testing.T) {
+	a := ipn.NewPrefs()
+	a.Persist = &persist.Persist{LoginName: "a@example.com"}
+	b := a.Clone()
+	b.Persist = &persist.Persist{LoginName: "b@example.com"}
+	if got := prefsDiff(a, b); len(got) != 0 {
+		t.Errorf("differing only in Persist: got %q; want no diff", got)
+	}
+
+	b.ShieldsUp = true
+	b.Hostname = "foo"
+	want := []string{
+		"ShieldsUp: true (active: false)",
+		"Hostname: foo (active: )",
+	}
+	if got := prefsDiff(a, b); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+func TestIsProfileStateKey(t *testing.T) {
+	for k, want := range map[ipn.StateKey]bool{
+		ipn.GlobalDaemonStateKey: true,
+		"user-1234":              true,
+		ipn.MachineKeyStateKey:   false,
+		ipn.ServerModeStartKey:   false,
+	} {
+		if got := isProfileStateKey(k); got != want {
+			t.Errorf("isProfileStateKey(%q) = %v; want %v", k, got, want)
+		}
+	}
+}
+
+// readCountingStore is an ipn.StateStore that counts its reads.
+type readCountingStore struct {
+	mem.Store
+	reads int
+}
+
+func (s *readCountingStore) ReadState(k ipn.StateKey) ([]byte, error) {
+	s.reads++
+	return s.Store.ReadState(k)
+}
+
+func TestCheckProfilesOnlyProfiles(t *testing.T) {
+	store := new(readCountingStore)
+	store.WriteState(ipn.MachineKeyStateKey, []byte("privkey:secret"))
+	b := &LocalBackend{store: store}
+	if err := b.checkProfiles(t.Logf, ipn.MachineKeyStateKey); err == nil {
+		t.Error("got nil error for the machine key; want an error")
+	}
+	if store.reads != 0 {
+		t.Errorf("store read %d times; want none", store.reads)
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnlocal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/doctor"
+	"tailscale.com/ipn"
+)
+
+// peerDoctorTimeout bounds how long a peer's doctor checks may take,
+// including the round trip to ask for them.
+const peerDoctorTimeout = time.Minute
+
+// allowsRemoteDoctor reports whether the AllowRemoteDoctor pref
+// permits peers to run this node's doctor checks.
+func (b *LocalBackend) allowsRemoteDoctor() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.prefs != nil && b.prefs.AllowRemoteDoctor
+}
+
+// doctorResults runs the doctor checks and returns their results for
+// a peer.
+func (b *LocalBackend) doctorResults(ctx context.Context) []apitype.DoctorCheckResult {
+	return b.DoctorResults(ctx, "", nil, nil, doctor.Redaction{}, nil)
+}
+
+// DoctorResults runs the doctor checks like Doctor, with the same
+// profile, only, cats and redact parameters, but returns what each
+// check logged and found instead of logging it. If progress is
+// non-nil, it's called as the checks start, progress and finish; see
+// doctor.WithProgress.
+func (b *LocalBackend) DoctorResults(ctx context.Context, profile ipn.StateKey, only []string, cats []doctor.Category, redact doctor.Redaction, progress func(doctor.Progress)) []apitype.DoctorCheckResult {
+	checks := b.doctorChecks(profile)
+	if len(only) > 0 {
+		checks = append(checks, doctor.WithOnly(only...))
+	}
+	if len(cats) > 0 {
+		checks = append(checks, doctor.WithOnlyCategories(cats...))
+	}
+	checks = append(checks, doctor.WithRedaction(redact))
+	if progress != nil {
+		checks = append(checks, doctor.WithProgress(progress))
+	}
+	start := time.Now().UTC()
+	res := doctorCheckResults(doctor.RunChecksResults(ctx, checks...))
+	if keepDoctorRun(only, cats, redact) {
+		b.recordDoctorRun(&apitype.DoctorRun{Time: start, Checks: res})
+	}
+	return res
+}
+
+// doctorCheckResults converts res to the form sent to peers and
+// LocalAPI clients, and kept on disk.
+func doctorCheckResults(res []doctor.Result) []apitype.DoctorCheckResult {
+	ret := make([]apitype.DoctorCheckResult, len(res))
+	for i, r := range res {
+		ret[i] = apitype.DoctorCheckResult{
+			Name:        r.Name,
+			Severity:    string(r.Severity),
+			Summary:     r.Summary,
+			Detail:      r.Detail,
+			Log:         r.Log,
+			Skipped:     r.Skipped,
+			Remediation: r.Remediation,
+			CutShort:    r.CutShort,
+		}
+		if r.Err != nil {
+			ret[i].Error = r.Err.Error()
+		}
+	}
+	return ret
+}
+
+// DoctorPeer asks the peer with Tailscale IP ip to run its doctor
+// checks and return the results. The peer must have the
+// AllowRemoteDoctor pref set, and either be owned by the same user or
+// grant this node the tailcfg.CapabilityDoctorPeer capability.
+func (b *LocalBackend) DoctorPeer(ctx context.Context, ip netip.Addr) (*apitype.PeerDoctorResponse, error) {
+	nm := b.NetMap()
+	if nm == nil {
+		return nil, errors.New("no netmap")
+	}
+	peer, ok := nm.PeerByTailscaleIP(ip)
+	if !ok {
+		return nil, fmt.Errorf("no peer found with Tailscale IP %v", ip)
+	}
+	base := peerAPIBase(nm, peer)
+	if base == "" {
+		return nil, fmt.Errorf("%s has no peer API", peer.ComputedName)
+	}
+	ctx, cancel := context.WithTimeout(ctx, peerDoctorTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", base+"/v0/doctor", nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := b.Dialer().PeerAPIHTTPClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s: %s", peer.ComputedName, res.Status, strings.TrimSpace(string(body)))
+	}
+	ret := &apitype.PeerDoctorResponse{Peer: peer.ComputedName}
+	if err := json.Unmarshal(body, &ret.Checks); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
@@ -0,0 +1,270 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnlocal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/doctor"
+	"tailscale.com/ipn"
+)
+
+// maxStoredDoctorRuns is the number of doctor runs, scheduled or asked
+// for, kept in the state store to diff.
+const maxStoredDoctorRuns = 10
+
+// maxStoredDoctorRunsSize is the most bytes of doctor runs kept in the
+// state store. Some stores are small: a Kubernetes Secret holds at
+// most 1MiB, for all of the state.
+const maxStoredDoctorRunsSize = 64 << 10
+
+// recordDoctorRun keeps run in the state store, dropping the oldest
+// runs beyond maxStoredDoctorRuns or maxStoredDoctorRunsSize. What the
+// checks logged isn't kept, to bound the size of the state.
+func (b *LocalBackend) recordDoctorRun(run *apitype.DoctorRun) {
+	stored := *run
+	stored.Checks = make([]apitype.DoctorCheckResult, len(run.Checks))
+	for i, c := range run.Checks {
+		c.Log = nil
+		stored.Checks[i] = c
+	}
+
+	b.doctorRunsMu.Lock()
+	defer b.doctorRunsMu.Unlock()
+	runs, err := b.storedDoctorRuns()
+	if err != nil {
+		b.logf("doctor runs: dropping unreadable runs: %v", err)
+	}
+	runs = append(runs, stored)
+	if len(runs) > maxStoredDoctorRuns {
+		runs = runs[len(runs)-maxStoredDoctorRuns:]
+	}
+	var bs []byte
+	for {
+		bs, err = json.Marshal(runs)
+		if err != nil {
+			b.logf("doctor runs: %v", err)
+			return
+		}
+		if len(bs) <= maxStoredDoctorRunsSize {
+			break
+		}
+		if len(runs) == 1 {
+			b.logf("doctor runs: not keeping run of %d bytes; the most is %d", len(bs), maxStoredDoctorRunsSize)
+			return
+		}
+		runs = runs[1:]
+	}
+	if err := b.store.WriteState(ipn.DoctorRunsStateKey, bs); err != nil {
+		b.logf("doctor runs: %v", err)
+	}
+}
+
+// keepDoctorRun reports whether a doctor run asked for with the only,
+// cats and redact parameters of Doctor is kept in the state store. Runs
+// of only some checks would show the others as gone when diffed, and
+// redacted ones every address as changed, so only full, unredacted
+// runs are kept.
+func keepDoctorRun(only []string, cats []doctor.Category, redact doctor.Redaction) bool {
+	return len(only) == 0 && len(cats) == 0 && redact.IsZero()
+}
+
+// DoctorRuns returns the doctor runs kept in the state store, oldest
+// first.
+func (b *LocalBackend) DoctorRuns() ([]apitype.DoctorRun, error) {
+	b.doctorRunsMu.Lock()
+	defer b.doctorRunsMu.Unlock()
+	return b.storedDoctorRuns()
+}
+
+// storedDoctorRuns reads the doctor runs from the state store.
+// b.doctorRunsMu must be held.
+func (b *LocalBackend) storedDoctorRuns() ([]apitype.DoctorRun, error) {
+	bs, err := b.store.ReadState(ipn.DoctorRunsStateKey)
+	if errors.Is(err, ipn.ErrStateNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var runs []apitype.DoctorRun
+	if err := json.Unmarshal(bs, &runs); err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+// DiffDoctorRuns returns what changed between the doctor runs kept in
+// the state store that selA and selB select, as per findDoctorRun.
+func (b *LocalBackend) DiffDoctorRuns(selA, selB string) (*apitype.DoctorRunDiff, error) {
+	runs, err := b.DoctorRuns()
+	if err != nil {
+		return nil, err
+	}
+	ra, err := findDoctorRun(runs, selA)
+	if err != nil {
+		return nil, err
+	}
+	rb, err := findDoctorRun(runs, selB)
+	if err != nil {
+		return nil, err
+	}
+	return &apitype.DoctorRunDiff{
+		A:       ra.Time,
+		B:       rb.Time,
+		Changes: diffDoctorRuns(ra, rb),
+	}, nil
+}
+
+// findDoctorRun returns the run of runs, oldest first, that sel
+// selects: an index, counting back from the newest if negative (so
+// "-1" is the newest), or a time in RFC 3339 format or a date such as
+// "2022-10-04", selecting the newest run at or before it. A date is
+// the end of that day in UTC.
+func findDoctorRun(runs []apitype.DoctorRun, sel string) (*apitype.DoctorRun, error) {
+	if len(runs) == 0 {
+		return nil, errors.New("no doctor runs kept")
+	}
+	if i, err := strconv.Atoi(sel); err == nil {
+		if i < 0 {
+			i += len(runs)
+		}
+		if i < 0 || i >= len(runs) {
+			return nil, fmt.Errorf("doctor run %s out of range; there are %d", sel, len(runs))
+		}
+		return &runs[i], nil
+	}
+	t, err := time.Parse(time.RFC3339, sel)
+	if err != nil {
+		d, derr := time.Parse("2006-01-02", sel)
+		if derr != nil {
+			return nil, fmt.Errorf("invalid doctor run %q: want an index, an RFC 3339 time or a date", sel)
+		}
+		t = d.Add(24*time.Hour - time.Nanosecond)
+	}
+	for i := len(runs) - 1; i >= 0; i-- {
+		if !runs[i].Time.After(t) {
+			return &runs[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no doctor run at or before %v", t.Format(time.RFC3339))
+}
+
+// diffDoctorRuns returns the changes from run a to run b, by check in
+// the order of b, then those of checks only in a.
+func diffDoctorRuns(a, b *apitype.DoctorRun) []apitype.DoctorCheckChange {
+	var ret []apitype.DoctorCheckChange
+	old := make(map[string]*apitype.DoctorCheckResult, len(a.Checks))
+	for i := range a.Checks {
+		old[a.Checks[i].Name] = &a.Checks[i]
+	}
+	for i := range b.Checks {
+		cb := &b.Checks[i]
+		ca, ok := old[cb.Name]
+		if !ok {
+			ret = append(ret, apitype.DoctorCheckChange{Check: cb.Name, New: doctorOutcome(cb)})
+			continue
+		}
+		delete(old, cb.Name)
+		field := func(name, old, new string) {
+			if old != new {
+				ret = append(ret, apitype.DoctorCheckChange{Check: cb.Name, Field: name, Old: old, New: new})
+			}
+		}
+		field("severity", ca.Severity, cb.Severity)
+		field("summary", ca.Summary, cb.Summary)
+		field("error", ca.Error, cb.Error)
+		field("skipped", ca.Skipped, cb.Skipped)
+
+		da, db := flattenDetail(ca.Detail), flattenDetail(cb.Detail)
+		for _, k := range unionKeys(da, db) {
+			name := "detail"
+			if k != "" {
+				name += "." + k
+			}
+			field(name, da[k], db[k])
+		}
+	}
+	for i := range a.Checks {
+		if ca, ok := old[a.Checks[i].Name]; ok {
+			ret = append(ret, apitype.DoctorCheckChange{Check: ca.Name, Old: doctorOutcome(ca)})
+		}
+	}
+	return ret
+}
+
+// doctorOutcome returns the severity of the result of c and its
+// summary, if any, such as "warning: strict mode".
+func doctorOutcome(c *apitype.DoctorCheckResult) string {
+	sev := c.Severity
+	if sev == "" {
+		sev = "ok"
+	}
+	if c.Summary == "" {
+		return sev
+	}
+	return sev + ": " + c.Summary
+}
+
+// flattenDetail returns the values in detail, the Detail of a doctor
+// check's result, by their dotted path of JSON object keys, such as
+// "Routes.Default", or the empty path if it's not an object. Values
+// other than objects, including arrays, are formatted as JSON. Fresh
+// results have Go values as details, and stored ones JSON, so both
+// are flattened by way of JSON.
+func flattenDetail(detail any) map[string]string {
+	if detail == nil {
+		return nil
+	}
+	bs, err := json.Marshal(detail)
+	if err != nil {
+		return map[string]string{"": fmt.Sprintf("<%v>", err)}
+	}
+	d := json.NewDecoder(bytes.NewReader(bs))
+	d.UseNumber()
+	var v any
+	if err := d.Decode(&v); err != nil {
+		return map[string]string{"": fmt.Sprintf("<%v>", err)}
+	}
+	ret := map[string]string{}
+	var walk func(path string, v any)
+	walk = func(path string, v any) {
+		if m, ok := v.(map[string]any); ok {
+			for k, v := range m {
+				if path != "" {
+					k = path + "." + k
+				}
+				walk(k, v)
+			}
+			return
+		}
+		bs, _ := json.Marshal(v)
+		ret[path] = string(bs)
+	}
+	walk("", v)
+	return ret
+}
+
+// unionKeys returns the keys of a and b, sorted.
+func unionKeys(a, b map[string]string) []string {
+	var ret []string
+	for k := range a {
+		ret = append(ret, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			ret = append(ret, k)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
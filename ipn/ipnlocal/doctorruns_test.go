@@ -0,0 +1,134 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnlocal
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn/store/mem"
+)
+
+func TestRecordDoctorRun(t *testing.T) {
+	b := &LocalBackend{store: new(mem.Store), logf: t.Logf}
+	start := time.Date(2022, 10, 1, 9, 0, 0, 0, time.UTC)
+	for i := 0; i < maxStoredDoctorRuns+3; i++ {
+		b.recordDoctorRun(&apitype.DoctorRun{
+			Time:   start.Add(time.Duration(i) * 24 * time.Hour),
+			Checks: []apitype.DoctorCheckResult{{Name: "mtu", Log: []string{"logged"}}},
+		})
+	}
+	runs, err := b.DoctorRuns()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != maxStoredDoctorRuns {
+		t.Fatalf("got %d runs; want %d", len(runs), maxStoredDoctorRuns)
+	}
+	if want := start.Add(3 * 24 * time.Hour); !runs[0].Time.Equal(want) {
+		t.Errorf("oldest run at %v; want %v", runs[0].Time, want)
+	}
+	if log := runs[0].Checks[0].Log; log != nil {
+		t.Errorf("stored run kept the log %q", log)
+	}
+
+	// Big runs push out old ones sooner.
+	big := strings.Repeat("x", maxStoredDoctorRunsSize/5)
+	b.recordDoctorRun(&apitype.DoctorRun{
+		Time:   start.Add(100 * 24 * time.Hour),
+		Checks: []apitype.DoctorCheckResult{{Name: "mtu", Summary: big}, {Name: "dns", Summary: big}},
+	})
+	b.recordDoctorRun(&apitype.DoctorRun{
+		Time:   start.Add(101 * 24 * time.Hour),
+		Checks: []apitype.DoctorCheckResult{{Name: "mtu", Summary: big}, {Name: "dns", Summary: big}},
+	})
+	if runs, _ = b.DoctorRuns(); len(runs) != 2 {
+		t.Errorf("got %d runs after two big ones; want 2", len(runs))
+	}
+	b.recordDoctorRun(&apitype.DoctorRun{
+		Time:   start.Add(102 * 24 * time.Hour),
+		Checks: []apitype.DoctorCheckResult{{Name: "mtu", Summary: strings.Repeat("x", maxStoredDoctorRunsSize)}},
+	})
+	if runs, _ = b.DoctorRuns(); len(runs) != 2 || !runs[1].Time.Equal(start.Add(101*24*time.Hour)) {
+		t.Errorf("too big a run was kept, or the others dropped: %d runs", len(runs))
+	}
+}
+
+func TestFindDoctorRun(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2022, 10, d, 9, 0, 0, 0, time.UTC) }
+	runs := []apitype.DoctorRun{{Time: day(3)}, {Time: day(4)}, {Time: day(6)}}
+	tests := []struct {
+		sel     string
+		want    time.Time
+		wantErr bool
+	}{
+		{sel: "0", want: day(3)},
+		{sel: "-1", want: day(6)},
+		{sel: "-3", want: day(3)},
+		{sel: "3", wantErr: true},
+		{sel: "-4", wantErr: true},
+		{sel: "2022-10-05", want: day(4)},
+		{sel: "2022-10-06", want: day(6)},
+		{sel: "2022-10-06T08:00:00Z", want: day(4)},
+		{sel: "2022-10-02", wantErr: true},
+		{sel: "tuesday", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := findDoctorRun(runs, tt.sel)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("findDoctorRun(%q) = %v; want error", tt.sel, got.Time)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("findDoctorRun(%q): %v", tt.sel, err)
+			continue
+		}
+		if !got.Time.Equal(tt.want) {
+			t.Errorf("findDoctorRun(%q) = %v; want %v", tt.sel, got.Time, tt.want)
+		}
+	}
+	if _, err := findDoctorRun(nil, "-1"); err == nil {
+		t.Error("findDoctorRun of no runs succeeded")
+	}
+}
+
+func TestDiffDoctorRuns(t *testing.T) {
+	type routes struct {
+		Default string
+		DNS     []string
+	}
+	a := &apitype.DoctorRun{Checks: []apitype.DoctorCheckResult{
+		{Name: "mtu", Severity: "ok"},
+		{Name: "routes", Severity: "ok", Detail: routes{Default: "eth0", DNS: []string{"192.0.2.53"}}},
+		{Name: "firewall", Severity: "skipped", Skipped: "requires root"},
+	}}
+	// Stored runs have JSON details, as if read back from the state
+	// store.
+	b := &apitype.DoctorRun{Checks: []apitype.DoctorCheckResult{
+		{Name: "dns-manager", Severity: "ok"},
+		{Name: "mtu", Severity: "warning", Summary: "too small"},
+		{Name: "routes", Severity: "ok", Detail: map[string]any{"Default": "wlan0"}},
+	}}
+	got := diffDoctorRuns(a, b)
+	want := []apitype.DoctorCheckChange{
+		{Check: "dns-manager", New: "ok"},
+		{Check: "mtu", Field: "severity", Old: "ok", New: "warning"},
+		{Check: "mtu", Field: "summary", New: "too small"},
+		{Check: "routes", Field: "detail.DNS", Old: `["192.0.2.53"]`},
+		{Check: "routes", Field: "detail.Default", Old: `"eth0"`, New: `"wlan0"`},
+		{Check: "firewall", Old: "skipped"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diff:\n got: %+v\nwant: %+v", got, want)
+	}
+	if got := diffDoctorRuns(a, a); len(got) != 0 {
+		t.Errorf("diff of a run with itself: %+v", got)
+	}
+}
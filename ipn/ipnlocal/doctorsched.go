@@ -0,0 +1,311 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnlocal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/doctor"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/crashreport"
+)
+
+const (
+	// doctorRunsDir is the directory, under TailscaleVarRoot, that the
+	// results of scheduled doctor runs are written to.
+	doctorRunsDir = "doctor-runs"
+
+	// maxDoctorRuns is the number of scheduled doctor runs kept on
+	// disk, two weeks' worth when run daily.
+	maxDoctorRuns = 14
+
+	// maxLightDoctorRuns is the number of scheduled runs of only the
+	// lightweight checks kept on disk, three days' worth when run
+	// hourly. Bug reports log them as a line each.
+	maxLightDoctorRuns = 72
+
+	// minDoctorInterval is the shortest allowed DoctorInterval pref.
+	// The checks probe DERP servers and the like, so running them more
+	// often than this would be abusive.
+	minDoctorInterval = time.Hour
+
+	// minLightDoctorInterval is the shortest allowed DoctorInterval
+	// pref with DoctorLightweight, whose checks only inspect local
+	// state.
+	minLightDoctorInterval = 10 * time.Minute
+
+	// doctorStartDelay is the minimum time after starting up or
+	// changing the schedule before a scheduled doctor run, so it
+	// doesn't race with connecting.
+	doctorStartDelay = 5 * time.Minute
+
+	// doctorRunTimeout bounds each scheduled doctor run.
+	doctorRunTimeout = 5 * time.Minute
+)
+
+// doctorScheduler is a running schedule of doctor runs, as per the
+// DoctorInterval and DoctorLightweight prefs.
+type doctorScheduler struct {
+	interval    time.Duration
+	lightweight bool
+	stop        chan struct{} // closed to stop the schedule
+}
+
+// filePrefix returns the prefix of the names of the files the runs
+// of s are written to, which are rotated separately for lightweight
+// runs.
+func (s *doctorScheduler) filePrefix() string {
+	if s.lightweight {
+		return "light-"
+	}
+	return "run-"
+}
+
+// parseDoctorInterval parses the DoctorInterval pref, which can be
+// shorter if lightweight, as per the DoctorLightweight pref. The empty
+// string means no scheduled runs and returns zero.
+func parseDoctorInterval(s string, lightweight bool) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid doctor interval %q: %w", s, err)
+	}
+	min := minDoctorInterval
+	if lightweight {
+		min = minLightDoctorInterval
+	}
+	if d < min {
+		if lightweight {
+			return 0, fmt.Errorf("lightweight doctor interval %v must be at least %v", d, min)
+		}
+		return 0, fmt.Errorf("doctor interval %v must be at least %v (or %v with only lightweight checks)", d, min, minLightDoctorInterval)
+	}
+	return d, nil
+}
+
+// updateDoctorSchedule starts, stops or restarts the scheduled doctor
+// runs to run every interval, or never if interval is zero. If
+// lightweight, only the lightweight checks run.
+func (b *LocalBackend) updateDoctorSchedule(interval time.Duration, lightweight bool) {
+	b.doctorSchedMu.Lock()
+	defer b.doctorSchedMu.Unlock()
+	if s := b.doctorSched; s != nil {
+		if s.interval == interval && s.lightweight == lightweight {
+			return
+		}
+		b.logf("stopping scheduled doctor runs")
+		close(s.stop)
+		b.doctorSched = nil
+	}
+	if interval > 0 {
+		if lightweight {
+			b.logf("scheduling lightweight doctor runs every %v", interval)
+		} else {
+			b.logf("scheduling doctor runs every %v", interval)
+		}
+		s := &doctorScheduler{
+			interval:    interval,
+			lightweight: lightweight,
+			stop:        make(chan struct{}),
+		}
+		b.doctorSched = s
+		crashreport.Go(func() { b.runDoctorSchedule(s) })
+	}
+}
+
+func (b *LocalBackend) runDoctorSchedule(s *doctorScheduler) {
+	ctx, cancel := context.WithCancel(b.ctx)
+	defer cancel()
+	crashreport.Go(func() {
+		select {
+		case <-s.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	})
+
+	// Runs are spaced from the last one on disk, so restarts don't
+	// cause extra or skipped runs.
+	last := b.lastDoctorRun(s.filePrefix())
+	for {
+		t := time.NewTimer(doctorRunDelay(last, time.Now(), s.interval))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+		last = time.Now()
+		b.runScheduledDoctor(ctx, s)
+	}
+}
+
+// doctorRunDelay returns how long to wait before the next doctor run
+// at time now, given the time of the last one (the zero time if none)
+// and the interval between runs.
+func doctorRunDelay(last, now time.Time, interval time.Duration) time.Duration {
+	d := last.Add(interval).Sub(now)
+	if d < doctorStartDelay {
+		d = doctorStartDelay
+	}
+	return d
+}
+
+// runScheduledDoctor runs the doctor checks for s, logs a summary and
+// writes the results to disk and the state store. As per the DoctorLogResults pref, it also
+// logs each check's results.
+func (b *LocalBackend) runScheduledDoctor(ctx context.Context, s *doctorScheduler) {
+	ctx, cancel := context.WithTimeout(ctx, doctorRunTimeout)
+	defer cancel()
+	run := &apitype.DoctorRun{Time: time.Now().UTC(), Scheduled: true, Lightweight: s.lightweight}
+	checks := b.doctorChecks("")
+	if s.lightweight {
+		checks = append(checks, doctor.WithOnlyLightweight())
+	}
+	run.Checks = doctorCheckResults(doctor.RunChecksResults(ctx, checks...))
+	if ctx.Err() == context.Canceled {
+		// Stopped or shutting down; the results are likely bogus.
+		return
+	}
+
+	b.mu.Lock()
+	logResults := b.prefs != nil && b.prefs.DoctorLogResults
+	b.mu.Unlock()
+
+	var failed []string
+	for _, c := range run.Checks {
+		if c.Error != "" {
+			failed = append(failed, c.Name)
+		}
+		if !logResults {
+			continue
+		}
+		if c.Skipped != "" {
+			b.logf("doctor run: check %s: skipped: %s", c.Name, c.Skipped)
+		}
+		for _, line := range c.Log {
+			b.logf("doctor run: %s: %s", c.Name, line)
+		}
+		if c.Error != "" {
+			b.logf("doctor run: check %s: %s", c.Name, c.Error)
+		}
+	}
+	if len(failed) > 0 {
+		b.logf("scheduled doctor run: %d of %d checks failed: %s", len(failed), len(run.Checks), strings.Join(failed, ", "))
+	} else {
+		b.logf("scheduled doctor run: all %d checks passed", len(run.Checks))
+	}
+	if !s.lightweight {
+		// Lightweight runs would show the other checks as gone
+		// when diffed against full ones; see keepDoctorRun.
+		b.recordDoctorRun(run)
+	}
+
+	dir := b.doctorRunsDir()
+	if dir == "" {
+		return
+	}
+	keep := maxDoctorRuns
+	if s.lightweight {
+		keep = maxLightDoctorRuns
+	}
+	if _, err := writeRotatedJSON(dir, s.filePrefix(), run.Time, run, keep); err != nil {
+		b.logf("scheduled doctor run: %v", err)
+	}
+}
+
+// doctorRunsDir returns the directory scheduled doctor runs are kept
+// in, or the empty string if there's no state directory.
+func (b *LocalBackend) doctorRunsDir() string {
+	root := b.TailscaleVarRoot()
+	if root == "" {
+		return ""
+	}
+	return filepath.Join(root, doctorRunsDir)
+}
+
+// lastDoctorRun returns the time of the newest scheduled doctor run
+// kept on disk in files named with prefix, or the zero time if there
+// are none.
+func (b *LocalBackend) lastDoctorRun(prefix string) time.Time {
+	dir := b.doctorRunsDir()
+	if dir == "" {
+		return time.Time{}
+	}
+	names, err := rotatedFiles(dir, prefix)
+	if err != nil || len(names) == 0 {
+		return time.Time{}
+	}
+	return doctorRunTime(names[len(names)-1], prefix)
+}
+
+// doctorRunTime returns the time in the file name of a scheduled
+// doctor run, named with prefix, or the zero time if it doesn't have
+// one.
+func doctorRunTime(name, prefix string) time.Time {
+	ts := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".json")
+	t, err := time.Parse(rotatedTimeLayout, ts)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// LogDoctorRuns logs the scheduled doctor runs kept on disk, oldest
+// first, so a bug report includes diagnostics from around the time of
+// intermittent failures. The more numerous lightweight runs are logged
+// as a line each, naming the checks that didn't pass.
+func (b *LocalBackend) LogDoctorRuns(logf logger.Logf) {
+	dir := b.doctorRunsDir()
+	if dir == "" {
+		return
+	}
+	logRotatedFiles(logf, dir, "run-")
+
+	names, err := rotatedFiles(dir, "light-")
+	if err != nil {
+		return
+	}
+	for _, n := range names {
+		bs, err := os.ReadFile(filepath.Join(dir, n))
+		if err != nil {
+			logf("%s: %v", n, err)
+			continue
+		}
+		var run apitype.DoctorRun
+		if err := json.Unmarshal(bs, &run); err != nil {
+			logf("%s: %v", n, err)
+			continue
+		}
+		logf("%s: %s", n, doctorRunSummary(run.Checks))
+	}
+}
+
+// doctorRunSummary returns a one-line summary of the results of a
+// doctor run, such as "12 ok; warning: mtu: ...; failed: dns-manager: ...".
+func doctorRunSummary(checks []apitype.DoctorCheckResult) string {
+	var ok int
+	var problems []string
+	for _, c := range checks {
+		switch {
+		case c.Error != "":
+			problems = append(problems, fmt.Sprintf("failed: %s: %s", c.Name, c.Error))
+		case c.Severity == string(doctor.SeverityWarning):
+			problems = append(problems, fmt.Sprintf("warning: %s: %s", c.Name, c.Summary))
+		case c.Skipped == "":
+			ok++
+		}
+	}
+	return strings.Join(append([]string{fmt.Sprintf("%d ok", ok)}, problems...), "; ")
+}
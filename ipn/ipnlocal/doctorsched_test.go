@@ -0,0 +1,99 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnlocal
+
+import (
+	"testing"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+)
+
+func TestParseDoctorInterval(t *testing.T) {
+	tests := []struct {
+		in          string
+		lightweight bool
+		want        time.Duration
+		wantErr     bool
+	}{
+		{in: "", want: 0},
+		{in: "24h", want: 24 * time.Hour},
+		{in: "1h", want: time.Hour},
+		{in: "30m", wantErr: true},
+		{in: "30m", lightweight: true, want: 30 * time.Minute},
+		{in: "10m", lightweight: true, want: 10 * time.Minute},
+		{in: "1m", lightweight: true, wantErr: true},
+		{in: "daily", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseDoctorInterval(tt.in, tt.lightweight)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseDoctorInterval(%q, %v) error = %v; want error: %v", tt.in, tt.lightweight, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseDoctorInterval(%q, %v) = %v; want %v", tt.in, tt.lightweight, got, tt.want)
+		}
+	}
+}
+
+func TestDoctorRunDelay(t *testing.T) {
+	now := time.Date(2022, 9, 1, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	tests := []struct {
+		name string
+		last time.Time
+		want time.Duration
+	}{
+		{"never", time.Time{}, doctorStartDelay},
+		{"recent", now.Add(-time.Hour), 23 * time.Hour},
+		{"overdue", now.Add(-2 * day), doctorStartDelay},
+		{"almost_due", now.Add(-day + time.Minute), doctorStartDelay},
+	}
+	for _, tt := range tests {
+		if got := doctorRunDelay(tt.last, now, day); got != tt.want {
+			t.Errorf("%s: doctorRunDelay = %v; want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLastDoctorRun(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2022, 9, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < maxDoctorRuns+2; i++ {
+		run := &apitype.DoctorRun{Time: start.Add(time.Duration(i) * 24 * time.Hour)}
+		if _, err := writeRotatedJSON(dir, "run-", run.Time, run, maxDoctorRuns); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names, err := rotatedFiles(dir, "run-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != maxDoctorRuns {
+		t.Fatalf("got %d runs; want %d", len(names), maxDoctorRuns)
+	}
+	want := start.Add(time.Duration(maxDoctorRuns+1) * 24 * time.Hour)
+	if got := doctorRunTime(names[len(names)-1], "run-"); !got.Equal(want) {
+		t.Errorf("newest run time = %v; want %v", got, want)
+	}
+	if got := doctorRunTime("run-bogus.json", "run-"); !got.IsZero() {
+		t.Errorf("doctorRunTime of bogus name = %v; want zero", got)
+	}
+}
+
+func TestDoctorRunSummary(t *testing.T) {
+	got := doctorRunSummary([]apitype.DoctorCheckResult{
+		{Name: "mtu", Severity: "ok"},
+		{Name: "rpfilter", Severity: "warning", Summary: "strict mode"},
+		{Name: "firewall", Severity: "skipped", Skipped: "requires root"},
+		{Name: "dns-manager", Severity: "error", Summary: "no resolv.conf", Error: "no resolv.conf"},
+		{Name: "profiles", Severity: "ok"},
+	})
+	want := "2 ok; warning: rpfilter: strict mode; failed: dns-manager: no resolv.conf"
+	if got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
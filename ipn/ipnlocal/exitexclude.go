@@ -0,0 +1,85 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnlocal
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"runtime"
+
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/util/multierr"
+)
+
+// checkExitNodeExcludePrefs validates the ExitNodeExcludeRoutes and
+// ExitNodeExcludeApps prefs of p. Overlaps with peers' subnet routes
+// aren't errors, as those change with the netmap; they're reported by
+// exitNodeExclusions instead.
+func checkExitNodeExcludePrefs(p *ipn.Prefs) error {
+	var errs []error
+	for _, r := range p.ExitNodeExcludeRoutes {
+		switch {
+		case !r.IsValid():
+			errs = append(errs, errors.New("invalid exit node exclusion"))
+		case r != r.Masked():
+			errs = append(errs, fmt.Errorf("exit node exclusion %v has non-address bits set; expected %v", r, r.Masked()))
+		case r.Bits() == 0:
+			errs = append(errs, fmt.Errorf("exit node exclusion %v would exclude all traffic; turn off the exit node instead", r))
+		case overlapsTailscaleRange(r):
+			errs = append(errs, fmt.Errorf("exit node exclusion %v overlaps Tailscale's addresses", r))
+		}
+	}
+	if len(p.ExitNodeExcludeApps) > 0 && runtime.GOOS != "android" {
+		errs = append(errs, fmt.Errorf("excluding applications from the tunnel isn't supported on %s", runtime.GOOS))
+	}
+	return multierr.New(errs...)
+}
+
+func overlapsTailscaleRange(r netip.Prefix) bool {
+	return r.Overlaps(tsaddr.CGNATRange()) || r.Overlaps(tsaddr.TailscaleULARange())
+}
+
+// exitNodeExclusions returns the prefixes of excl, each marked Dropped
+// if it can't be routed outside the tunnel: if it's invalid, or
+// overlaps Tailscale's addresses or one of subnetRoutes, the peers'
+// routes. Excluding those would cut off peers instead of the internet.
+func exitNodeExclusions(excl, subnetRoutes []netip.Prefix) []ipnstate.ExcludedRoute {
+	ret := make([]ipnstate.ExcludedRoute, 0, len(excl))
+	for _, r := range excl {
+		er := ipnstate.ExcludedRoute{Route: r}
+		switch {
+		case !r.IsValid() || r != r.Masked() || r.Bits() == 0:
+			er.Dropped = "invalid prefix"
+		case overlapsTailscaleRange(r):
+			er.Dropped = "overlaps Tailscale's addresses"
+		default:
+			for _, sr := range subnetRoutes {
+				if sr.Bits() == 0 || overlapsTailscaleRange(sr) {
+					// The exit node's own routes, and peers'
+					// Tailscale IPs.
+					continue
+				}
+				if sr.Overlaps(r) {
+					er.Dropped = fmt.Sprintf("overlaps subnet route %v", sr)
+					break
+				}
+			}
+		}
+		ret = append(ret, er)
+	}
+	return ret
+}
+
+// ExitNodeExclusions returns the ExitNodeExcludeRoutes pref's
+// prefixes, as last applied while using an exit node, with those that
+// weren't excluded marked Dropped. It returns nil without an exit node.
+func (b *LocalBackend) ExitNodeExclusions() []ipnstate.ExcludedRoute {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]ipnstate.ExcludedRoute(nil), b.exitNodeExclusions...)
+}
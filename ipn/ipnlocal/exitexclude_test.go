@@ -0,0 +1,74 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnlocal
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"tailscale.com/ipn/ipnstate"
+)
+
+func TestExitNodeExclusions(t *testing.T) {
+	pfx := netip.MustParsePrefix
+	routes := func(s ...string) (ret []netip.Prefix) {
+		for _, r := range s {
+			ret = append(ret, pfx(r))
+		}
+		return ret
+	}
+	subnetRoutes := routes("0.0.0.0/0", "::/0", "100.64.0.0/10", "10.0.0.0/24")
+
+	tests := []struct {
+		name string
+		excl []netip.Prefix
+		want []ipnstate.ExcludedRoute
+	}{
+		{
+			name: "none",
+			want: []ipnstate.ExcludedRoute{},
+		},
+		{
+			name: "applied",
+			excl: routes("192.0.2.0/24", "2001:db8::/32"),
+			want: []ipnstate.ExcludedRoute{
+				{Route: pfx("192.0.2.0/24")},
+				{Route: pfx("2001:db8::/32")},
+			},
+		},
+		{
+			name: "overlaps_subnet_route",
+			excl: routes("10.0.0.0/8"),
+			want: []ipnstate.ExcludedRoute{
+				{Route: pfx("10.0.0.0/8"), Dropped: "overlaps subnet route 10.0.0.0/24"},
+			},
+		},
+		{
+			name: "overlaps_tailscale",
+			excl: routes("100.100.0.0/16", "fd7a:115c:a1e0::/64"),
+			want: []ipnstate.ExcludedRoute{
+				{Route: pfx("100.100.0.0/16"), Dropped: "overlaps Tailscale's addresses"},
+				{Route: pfx("fd7a:115c:a1e0::/64"), Dropped: "overlaps Tailscale's addresses"},
+			},
+		},
+		{
+			name: "invalid",
+			excl: routes("192.0.2.1/24", "0.0.0.0/0"),
+			want: []ipnstate.ExcludedRoute{
+				{Route: pfx("192.0.2.1/24"), Dropped: "invalid prefix"},
+				{Route: pfx("0.0.0.0/0"), Dropped: "invalid prefix"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := exitNodeExclusions(tt.excl, subnetRoutes)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v; want %+v", got, tt.want)
+			}
+		})
+	}
+}
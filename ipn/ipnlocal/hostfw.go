@@ -0,0 +1,39 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnlocal
+
+import (
+	"context"
+
+	"tailscale.com/net/hostfw"
+)
+
+// udpPort returns the UDP port magicsock listens on, or 0 if unknown.
+func (b *LocalBackend) udpPort() uint16 {
+	mc, err := b.magicConn()
+	if err != nil {
+		return 0
+	}
+	return mc.LocalPort()
+}
+
+// HostFirewallPlan returns the host firewall rules that allow inbound
+// UDP to tailscaled's port, and whether they're already installed.
+func (b *LocalBackend) HostFirewallPlan(ctx context.Context) (*hostfw.Plan, error) {
+	return hostfw.NewPlan(ctx, b.udpPort())
+}
+
+// ApplyHostFirewall adds the rules of HostFirewallPlan to the host
+// firewall, if they're not installed yet, and returns the updated plan.
+func (b *LocalBackend) ApplyHostFirewall(ctx context.Context) (*hostfw.Plan, error) {
+	p, err := b.HostFirewallPlan(ctx)
+	if err != nil || p.Installed {
+		return p, err
+	}
+	if err := p.Apply(ctx, b.logf); err != nil {
+		return nil, err
+	}
+	return b.HostFirewallPlan(ctx)
+}
@@ -0,0 +1,186 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnlocal
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"tailscale.com/health"
+	"tailscale.com/types/logger"
+	"tailscale.com/types/preftype"
+	"tailscale.com/util/latencyhist"
+	"tailscale.com/util/multierr"
+)
+
+const (
+	// latencySLOInterval is how often the latency SLOs are evaluated.
+	latencySLOInterval = time.Minute
+
+	// latencySLOWindow is the window of latencies each evaluation
+	// covers.
+	latencySLOWindow = 5 * time.Minute
+
+	// latencySLOMinSamples is the fewest latencies over the window an
+	// SLO is evaluated on. With fewer, it's left as it was, until
+	// latencySLOWindow after it was last evaluated; then a violation
+	// expires, as the latencies it was based on have.
+	latencySLOMinSamples = 5
+)
+
+// latencySLOs is the state of the latency SLOs set by the LatencySLOs
+// pref.
+type latencySLOs struct {
+	mu sync.Mutex
+	// violated is the evidence of each SLO, by its pref string, that
+	// was violated as of the last evaluation.
+	violated map[string]string
+	// evaluated is when each SLO, by its pref string, was last
+	// evaluated on enough samples.
+	evaluated map[string]time.Time
+	// last is the result of the last evaluation of each SLO, by its
+	// pref string, for bug reports.
+	last map[string]string
+}
+
+// runLatencySLOs evaluates the LatencySLOs pref every
+// latencySLOInterval, until b shuts down.
+func (b *LocalBackend) runLatencySLOs() {
+	t := time.NewTicker(latencySLOInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-t.C:
+		}
+		b.evalLatencySLOs(time.Now())
+	}
+}
+
+// evalLatencySLOs evaluates the LatencySLOs pref against the latencies
+// observed in the latencySLOWindow before now, logging the SLOs that
+// became violated or met again and setting the health state with the
+// evidence of those violated.
+func (b *LocalBackend) evalLatencySLOs(now time.Time) {
+	b.mu.Lock()
+	var prefs []string
+	if b.prefs != nil {
+		prefs = b.prefs.LatencySLOs
+	}
+	nm := b.netMap
+	b.mu.Unlock()
+
+	s := &b.latencySLOs
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	violated := map[string]string{}
+	evaluated := map[string]time.Time{}
+	last := map[string]string{}
+	for _, ps := range prefs {
+		o, err := preftype.ParseLatencySLO(ps)
+		if err != nil {
+			last[ps] = err.Error()
+			continue
+		}
+		var snap latencyhist.Snapshot
+		var what string
+		switch o.Metric {
+		case preftype.LatencyRTT:
+			what = "RTT to " + o.Peer
+			if nm == nil {
+				last[ps] = "no network map"
+				continue
+			}
+			peer, _, err := syntheticPeer(nm, o.Peer)
+			if err != nil {
+				last[ps] = err.Error()
+				continue
+			}
+			mc, err := b.magicConn()
+			if err != nil {
+				last[ps] = err.Error()
+				continue
+			}
+			snap, _ = mc.PeerRTTs(peer.Key, now, latencySLOWindow)
+		case preftype.LatencyDNS:
+			what = "DNS lookup latency"
+			r, err := b.dnsResolver()
+			if err != nil {
+				last[ps] = err.Error()
+				continue
+			}
+			snap = r.LookupLatencies(now, latencySLOWindow)
+		}
+		if snap.Count() < latencySLOMinSamples {
+			last[ps] = fmt.Sprintf("only %d samples in the last %v", snap.Count(), latencySLOWindow)
+			// Too few to tell; leave it as it was, for a while.
+			t, ok := s.evaluated[ps]
+			if !ok || now.Sub(t) >= latencySLOWindow {
+				if _, ok := s.violated[ps]; ok {
+					b.logf("latency SLO %s no longer violated: no evaluation in %v", o, latencySLOWindow)
+				}
+				continue
+			}
+			evaluated[ps] = t
+			if ev, ok := s.violated[ps]; ok {
+				violated[ps] = ev
+			}
+			continue
+		}
+		evaluated[ps] = now
+		got := snap.Quantile(o.Quantile)
+		ev := fmt.Sprintf("p%s %s was %v over %d samples in the last %v",
+			strconv.FormatFloat(o.Quantile*100, 'f', -1, 64), what,
+			got.Round(time.Millisecond), snap.Count(), latencySLOWindow)
+		if got > o.Max {
+			ev += fmt.Sprintf(", above %v", o.Max)
+			violated[ps] = ev
+			if _, ok := s.violated[ps]; !ok {
+				b.logf("latency SLO %s violated: %s", o, ev)
+			}
+		} else if _, ok := s.violated[ps]; ok {
+			b.logf("latency SLO %s met again: %s", o, ev)
+		}
+		last[ps] = ev
+	}
+	s.violated = violated
+	s.evaluated = evaluated
+	s.last = last
+
+	var errs []error
+	for _, ps := range sortedKeys(violated) {
+		errs = append(errs, fmt.Errorf("latency SLO %s violated: %s", ps, violated[ps]))
+	}
+	health.SetLatencySLOHealth(multierr.New(errs...))
+}
+
+// LogLatencySLOs logs the result of the last evaluation of each latency
+// SLO, for a bug report.
+func (b *LocalBackend) LogLatencySLOs(logf logger.Logf) {
+	s := &b.latencySLOs
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.last) == 0 {
+		logf("none")
+		return
+	}
+	for _, ps := range sortedKeys(s.last) {
+		logf("%s: %s", ps, s.last[ps])
+	}
+}
+
+// sortedKeys returns the keys of m, sorted.
+func sortedKeys(m map[string]string) []string {
+	ret := make([]string, 0, len(m))
+	for k := range m {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
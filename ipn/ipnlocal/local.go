@@ -27,6 +27,7 @@
 	"golang.org/x/exp/slices"
 	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/control/controlclient"
+	"tailscale.com/doctor/preflight"
 	"tailscale.com/envknob"
 	"tailscale.com/health"
 	"tailscale.com/hostinfo"
@@ -35,8 +36,10 @@
 	"tailscale.com/ipn/policy"
 	"tailscale.com/net/dns"
 	"tailscale.com/net/dnsfallback"
+	"tailscale.com/net/gwmon"
 	"tailscale.com/net/interfaces"
 	"tailscale.com/net/netutil"
+	"tailscale.com/net/synthmon"
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/net/tsdial"
 	"tailscale.com/paths"
@@ -52,11 +55,13 @@
 	"tailscale.com/types/persist"
 	"tailscale.com/types/preftype"
 	"tailscale.com/types/views"
+	"tailscale.com/util/crashreport"
 	"tailscale.com/util/deephash"
 	"tailscale.com/util/dnsname"
 	"tailscale.com/util/multierr"
 	"tailscale.com/util/osshare"
 	"tailscale.com/util/systemd"
+	"tailscale.com/util/watchdog"
 	"tailscale.com/version"
 	"tailscale.com/version/distro"
 	"tailscale.com/wgengine"
@@ -122,6 +127,7 @@ type LocalBackend struct {
 	backendLogID          string
 	unregisterLinkMon     func()
 	unregisterHealthWatch func()
+	unregisterWatchdog    func()
 	portpoll              *portlist.Poller // may be nil
 	portpollOnce          sync.Once        // guards starting readPoller
 	gotPortPollRes        chan struct{}    // closed upon first readPoller result
@@ -129,11 +135,89 @@ type LocalBackend struct {
 	newDecompressor       func() (controlclient.Decompressor, error)
 	varRoot               string // or empty if SetVarRoot never called
 	sshAtomicBool         atomic.Bool
-	shutdownCalled        bool // if Shutdown has been called
+	clampMSSAtomicBool    atomic.Bool
+	speedTestRunning      atomic.Bool // whether a peer's speedtest is being served
+	shutdownCalled        bool        // if Shutdown has been called
 
 	filterAtomic            atomic.Pointer[filter.Filter]
 	containsViaIPFuncAtomic syncs.AtomicValue[func(netip.Addr) bool]
 
+	// derpMapOverride, if non-nil, is a locally supplied DERP map
+	// used in place of the one from the control plane.
+	// See SetDERPMapSource.
+	derpMapOverride syncs.AtomicValue[*tailcfg.DERPMap]
+
+	// preflightConfig is the tailscaled configuration the preflight
+	// checks validate. See SetPreflightConfig.
+	preflightConfig syncs.AtomicValue[preflight.Config]
+
+	// doctorChecksDir, if non-empty, is the directory of the site's
+	// external doctor checks. See SetDoctorChecksDir.
+	doctorChecksDir syncs.AtomicValue[string]
+
+	// logLeveler, if non-nil, is used to change log verbosity at
+	// runtime. See SetLogLeveler.
+	logLeveler LogLeveler
+
+	// recentLogs, if non-nil, keeps recent log lines in memory.
+	// See SetRecentLogWriter.
+	recentLogs RecentLogWriter
+
+	// diagSnap records recent events and takes diagnostic snapshots
+	// when health degrades. See diagsnapshot.go.
+	diagSnap diagSnapshotter
+
+	// netmapUpdates counts the netmaps whose changes were logged by
+	// logNetmapChanges.
+	netmapUpdates atomic.Int64
+
+	// synthMu guards synthMon, the synthetic monitor, which is nil
+	// unless enabled by the SyntheticMonitorPeer pref. See synthmon.go.
+	synthMu  sync.Mutex
+	synthMon *synthmon.Monitor
+
+	// doctorSchedMu guards doctorSched, the scheduled doctor runs,
+	// which is nil unless enabled by the DoctorInterval pref. See
+	// doctorsched.go.
+	doctorSchedMu sync.Mutex
+	doctorSched   *doctorScheduler
+
+	// doctorRunsMu serializes updates of the doctor runs kept in the
+	// state store. See doctorruns.go.
+	doctorRunsMu sync.Mutex
+
+	// peerHist is the persisted connectivity history of each peer,
+	// sampled by runPeerHistory, which peerHistOnce guards starting.
+	// See peerhistory.go.
+	peerHist     peerHistory
+	peerHistOnce sync.Once
+
+	// gwMon monitors the latency and loss to the default gateway, for
+	// the "local network degraded" health warning. It's nil on mobile,
+	// where the OS doesn't permit pinging. gwMonOnce guards starting it.
+	gwMon     *gwmon.Monitor
+	gwMonOnce sync.Once
+
+	// latencySLOs is the state of the latency SLOs set by the
+	// LatencySLOs pref, evaluated by runLatencySLOs, which
+	// latencySLOsOnce guards starting. See latencyslo.go.
+	latencySLOs     latencySLOs
+	latencySLOsOnce sync.Once
+
+	// powerSaver is the state of power saver mode, as per the
+	// PowerSaver pref, re-evaluated by runPowerSaver, which
+	// powerSaverOnce guards starting. See powersaver.go.
+	powerSaver     powerSaverState
+	powerSaverOnce sync.Once
+
+	// advertisedRoutes tracks the local interfaces of the routes in
+	// the AdvertiseRoutes pref. See advroutes.go.
+	advertisedRoutes advertisedRoutesState
+
+	// autoMTU is the MTU of the Tailscale interface as lowered for
+	// path MTU black holes. See automtu.go.
+	autoMTU autoMTUState
+
 	// The mutex protects the following elements.
 	mu             sync.Mutex
 	filterHash     deephash.Sum
@@ -167,6 +251,7 @@ type LocalBackend struct {
 	interact         bool
 	egg              bool
 	prevIfState      *interfaces.State
+	linkChanges      []ipnstate.NetInterfacesChange
 	peerAPIServer    *peerAPIServer // or nil
 	peerAPIListeners []*peerAPIListener
 	loginFlags       controlclient.LoginFlags
@@ -187,6 +272,10 @@ type LocalBackend struct {
 	directFileRoot          string
 	directFileDoFinalRename bool // false on macOS, true on several NAS platforms
 
+	// exitNodeExclusions are the ExitNodeExcludeRoutes as last applied
+	// by routerConfig; nil without an exit node.
+	exitNodeExclusions []ipnstate.ExcludedRoute
+
 	// statusLock must be held before calling statusChanged.Wait() or
 	// statusChanged.Broadcast().
 	statusLock    sync.Mutex
@@ -237,6 +326,10 @@ func NewLocalBackend(logf logger.Logf, logid string, store ipn.StateStore, diale
 		loginFlags:     loginFlags,
 	}
 
+	if !version.IsMobile() {
+		b.gwMon = gwmon.New(logf)
+	}
+
 	// Default filter blocks everything and logs nothing, until Start() is called.
 	b.setFilter(filter.NewAllowNone(logf, &netipx.IPSet{}))
 
@@ -251,6 +344,11 @@ func NewLocalBackend(logf logger.Logf, logid string, store ipn.StateStore, diale
 	b.unregisterLinkMon = linkMon.RegisterChangeCallback(b.linkChange)
 
 	b.unregisterHealthWatch = health.RegisterWatcher(b.onHealthChange)
+	b.unregisterWatchdog = watchdog.RegisterRestartWatcher(b.onWatchdogRestart)
+
+	if mc, err := b.magicConn(); err == nil {
+		mc.SetMTUBlackHoleCallback(b.onMTUBlackHole)
+	}
 
 	wiredPeerAPIPort := false
 	if ig, ok := e.(wgengine.InternalsGetter); ok {
@@ -310,7 +408,21 @@ func (b *LocalBackend) linkChange(major bool, ifst *interfaces.State) {
 
 	hadPAC := b.prevIfState.HasPAC()
 	b.prevIfState = ifst
+	b.linkChanges = append(b.linkChanges, ipnstate.NetInterfacesChange{
+		Time:  time.Now(),
+		Major: major,
+		State: ifst.String(),
+	})
+	if n := len(b.linkChanges); n > maxLinkChanges {
+		b.linkChanges = append(b.linkChanges[:0], b.linkChanges[n-maxLinkChanges:]...)
+	}
+	if major {
+		b.noteDiagEvent("major link change: %v", ifst)
+		crashreport.Go(b.resetAutoMTU)
+	}
 	b.maybePauseControlClientLocked()
+	crashreport.Go(b.updatePowerSaver)
+	crashreport.Go(b.checkAdvertisedRoutes)
 
 	// If the PAC-ness of the network changed, reconfig wireguard+route to
 	// add/remove subnets.
@@ -320,7 +432,7 @@ func (b *LocalBackend) linkChange(major bool, ifst *interfaces.State) {
 		case ipn.NoState, ipn.Stopped:
 			// Do nothing.
 		default:
-			go b.authReconfig()
+			crashreport.Go(b.authReconfig)
 		}
 	}
 
@@ -332,19 +444,82 @@ func (b *LocalBackend) linkChange(major bool, ifst *interfaces.State) {
 		want := len(b.netMap.Addresses)
 		if len(b.peerAPIListeners) < want {
 			b.logf("linkChange: peerAPIListeners too low; trying again")
-			go b.initPeerAPIListener()
+			crashreport.Go(b.initPeerAPIListener)
 		}
 	}
 }
 
+// maxLinkChanges is the number of recent link changes kept in
+// LocalBackend.linkChanges and reported by NetInterfaces.
+const maxLinkChanges = 10
+
+// NetInterfaces returns the network interfaces as seen by the link
+// monitor, along with the most recent link changes.
+func (b *LocalBackend) NetInterfaces() *ipnstate.NetInterfaces {
+	b.mu.Lock()
+	changes := append([]ipnstate.NetInterfacesChange(nil), b.linkChanges...)
+	b.mu.Unlock()
+
+	ret := &ipnstate.NetInterfaces{RecentChanges: changes}
+	st := b.e.GetLinkMonitor().InterfaceState()
+	if st == nil {
+		return ret
+	}
+	ret.DefaultRouteInterface = st.DefaultRouteInterface
+	ret.HaveV4 = st.HaveV4
+	ret.HaveV6 = st.HaveV6
+	ret.IsExpensive = st.IsExpensive
+	ret.HTTPProxy = st.HTTPProxy
+	ret.PAC = st.PAC
+	for name, iface := range st.Interface {
+		ni := ipnstate.NetInterface{
+			Name:  name,
+			Desc:  iface.Desc,
+			Addrs: st.InterfaceIPs[name],
+			Class: string(st.Class(name)),
+		}
+		if iface.Interface != nil {
+			ni.Index = iface.Index
+			ni.MTU = iface.MTU
+			ni.Flags = iface.Flags.String()
+			if len(iface.HardwareAddr) > 0 {
+				ni.HardwareAddr = iface.HardwareAddr.String()
+			}
+		}
+		ret.Interfaces = append(ret.Interfaces, ni)
+	}
+	sort.Slice(ret.Interfaces, func(i, j int) bool {
+		return ret.Interfaces[i].Index < ret.Interfaces[j].Index
+	})
+	return ret
+}
+
 func (b *LocalBackend) onHealthChange(sys health.Subsystem, err error) {
 	if err == nil {
 		b.logf("health(%q): ok", sys)
+		b.noteDiagEvent("health(%q): ok", sys)
 	} else {
 		b.logf("health(%q): error: %v", sys, err)
+		b.noteDiagEvent("health(%q): error: %v", sys, err)
+	}
+	if sys == health.SysOverall && err != nil {
+		b.maybeTakeDiagSnapshot(err.Error())
 	}
 }
 
+// onWatchdogRestart records a subsystem the watchdog found wedged.
+func (b *LocalBackend) onWatchdogRestart(ev watchdog.Event) {
+	what := "restarting it"
+	switch {
+	case ev.ReportOnly:
+		what = "it can't be restarted"
+	case !ev.Restarted:
+		what = "not restarting it (TS_DEBUG_WATCHDOG_NO_RESTART)"
+	}
+	b.logf("watchdog: %s made no progress in %v; %s (#%d)", ev.Name, ev.Stuck.Round(time.Second), what, ev.Restarts)
+	b.noteDiagEvent("watchdog: %s wedged for %v; %s (#%d)", ev.Name, ev.Stuck.Round(time.Second), what, ev.Restarts)
+}
+
 // Shutdown halts the backend and all its sub-components. The backend
 // can no longer be used after Shutdown returns.
 func (b *LocalBackend) Shutdown() {
@@ -372,6 +547,12 @@ func (b *LocalBackend) Shutdown() {
 
 	b.unregisterLinkMon()
 	b.unregisterHealthWatch()
+	b.unregisterWatchdog()
+	b.updateSyntheticMonitor("")
+	b.updateDoctorSchedule(0, false)
+	if err := b.peerHist.save(time.Now(), true); err != nil {
+		b.logf("peer history: %v", err)
+	}
 	if cc != nil {
 		cc.Shutdown()
 	}
@@ -413,6 +594,8 @@ func (b *LocalBackend) StatusWithoutPeers() *ipnstate.Status {
 func (b *LocalBackend) UpdateStatus(sb *ipnstate.StatusBuilder) {
 	b.e.UpdateStatus(sb)
 	b.updateStatus(sb, b.populatePeerStatusLocked)
+	b.updatePowerSaverStatus(sb)
+	b.updateDisconnectReasons(sb)
 }
 
 // updateStatus populates sb with status.
@@ -425,16 +608,9 @@ func (b *LocalBackend) updateStatus(sb *ipnstate.StatusBuilder, extraLocked func
 		s.Version = version.Long
 		s.BackendState = b.state.String()
 		s.AuthURL = b.authURLSticky
-		if err := health.OverallError(); err != nil {
-			switch e := err.(type) {
-			case multierr.Error:
-				for _, err := range e.Errors() {
-					s.Health = append(s.Health, err.Error())
-				}
-			default:
-				s.Health = append(s.Health, err.Error())
-			}
-		}
+		s.Health = append(s.Health, healthWarnings()...)
+		s.Offline = health.OfflineReason()
+		s.ExitNodeExclusions = append([]ipnstate.ExcludedRoute(nil), b.exitNodeExclusions...)
 		if m := b.sshOnButUnusableHealthCheckMessageLocked(); m != "" {
 			s.Health = append(s.Health, m)
 		}
@@ -539,6 +715,7 @@ func (b *LocalBackend) populatePeerStatusLocked(sb *ipnstate.StatusBuilder) {
 			ExitNode:       p.StableID != "" && p.StableID == b.prefs.ExitNodeID,
 			ExitNodeOption: exitNodeOption,
 			SSH_HostKeys:   p.Hostinfo.SSH_HostKeys().AsSlice(),
+			ConnHistory:    b.peerHist.get(p.StableID),
 		})
 	}
 }
@@ -727,10 +904,13 @@ func (b *LocalBackend) setClientStatus(st controlclient.Status) {
 			} else {
 				b.logf("[v1] netmap diff:\n%v", diff)
 			}
+			if debugNetmapChanges() {
+				b.logNetmapChanges(st.NetMap.ChangesFrom(netMap))
+			}
 		}
 
 		b.e.SetNetworkMap(st.NetMap)
-		b.e.SetDERPMap(st.NetMap.DERPMap)
+		b.e.SetDERPMap(b.derpMapToUse(st.NetMap.DERPMap))
 
 		// Update our cached DERP map
 		dnsfallback.UpdateCache(st.NetMap.DERPMap)
@@ -961,6 +1141,7 @@ func (b *LocalBackend) Start(opts ipn.Options) error {
 	hostinfo.FrontendLogID = opts.FrontendLogID
 	hostinfo.Userspace.Set(wgengine.IsNetstack(b.e))
 	hostinfo.UserspaceRouter.Set(wgengine.IsNetstackRouter(b.e))
+	hostinfo.WoLMACs = getWoLMACs()
 
 	if b.cc != nil {
 		// TODO(apenwarr): avoid the need to reinit controlclient.
@@ -1024,10 +1205,25 @@ func (b *LocalBackend) Start(opts ipn.Options) error {
 	b.updateFilterLocked(nil, nil)
 	b.mu.Unlock()
 
+	b.peerHistOnce.Do(func() {
+		crashreport.Go(b.runPeerHistory)
+	})
+	b.latencySLOsOnce.Do(func() {
+		crashreport.Go(b.runLatencySLOs)
+	})
+	b.powerSaverOnce.Do(func() {
+		crashreport.Go(b.runPowerSaver)
+	})
+	if b.gwMon != nil {
+		b.gwMonOnce.Do(func() {
+			crashreport.Go(func() { b.gwMon.Run(b.ctx) })
+		})
+	}
+
 	if b.portpoll != nil {
 		b.portpollOnce.Do(func() {
-			go b.portpoll.Run(b.ctx)
-			go b.readPoller()
+			crashreport.Go(func() { b.portpoll.Run(b.ctx) })
+			crashreport.Go(b.readPoller)
 
 			// Give the poller a second to get results to
 			// prevent it from restarting our map poll
@@ -1216,7 +1412,7 @@ func (b *LocalBackend) updateFilterLocked(netMap *netmap.NetworkMap, prefs *ipn.
 	}
 
 	if b.sshServer != nil {
-		go b.sshServer.OnPolicyChange()
+		crashreport.Go(b.sshServer.OnPolicyChange)
 	}
 }
 
@@ -1749,10 +1945,11 @@ func (b *LocalBackend) loadStateLocked(key ipn.StateKey, prefs *ipn.Prefs) (err
 	return nil
 }
 
-// setAtomicValuesFromPrefs populates sshAtomicBool and containsViaIPFuncAtomic
-// from the prefs p, which may be nil.
+// setAtomicValuesFromPrefs populates sshAtomicBool, clampMSSAtomicBool
+// and containsViaIPFuncAtomic from the prefs p, which may be nil.
 func (b *LocalBackend) setAtomicValuesFromPrefs(p *ipn.Prefs) {
 	b.sshAtomicBool.Store(p != nil && p.RunSSH && canSSH)
+	b.clampMSSAtomicBool.Store(p != nil && p.ClampMSS)
 
 	if p == nil {
 		b.containsViaIPFuncAtomic.Store(tsaddr.NewContainsIPFunc(nil))
@@ -1940,6 +2137,34 @@ func (b *LocalBackend) checkPrefsLocked(p *ipn.Prefs) error {
 	if err := b.checkSSHPrefsLocked(p); err != nil {
 		errs = append(errs, err)
 	}
+	if p.RouteMetric < 0 {
+		errs = append(errs, fmt.Errorf("route metric %d must not be negative", p.RouteMetric))
+	}
+	if p.RecvBatchSize < 0 || p.RecvBatchSize > magicsock.MaxRecvBatchSize {
+		errs = append(errs, fmt.Errorf("receive batch size %d must be between 0 and %d", p.RecvBatchSize, magicsock.MaxRecvBatchSize))
+	}
+	if p.DERPSendQueue < 0 || p.DERPSendQueue > magicsock.MaxDERPSendQueue {
+		errs = append(errs, fmt.Errorf("DERP send queue %d must be between 0 and %d", p.DERPSendQueue, magicsock.MaxDERPSendQueue))
+	}
+	if p.DERPHomeRegion < 0 {
+		errs = append(errs, fmt.Errorf("invalid DERP home region %d", p.DERPHomeRegion))
+	}
+	for _, id := range p.DERPAvoidRegions {
+		if id <= 0 {
+			errs = append(errs, fmt.Errorf("invalid DERP region %d to avoid", id))
+		} else if id == p.DERPHomeRegion {
+			errs = append(errs, fmt.Errorf("DERP region %d can't be both the home region and avoided", id))
+		}
+	}
+	if _, err := preftype.ParsePortRange(p.UDPPortRange); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := parseDoctorInterval(p.DoctorInterval, p.DoctorLightweight); err != nil {
+		errs = append(errs, err)
+	}
+	if err := checkExitNodeExcludePrefs(p); err != nil {
+		errs = append(errs, err)
+	}
 	return multierr.New(errs...)
 }
 
@@ -2022,7 +2247,8 @@ func (b *LocalBackend) EditPrefs(mp *ipn.MaskedPrefs) (*ipn.Prefs, error) {
 	if mp.EggSet {
 		mp.EggSet = false
 		b.egg = true
-		go b.doSetHostinfoFilterServices(b.hostinfo.Clone())
+		hi := b.hostinfo.Clone()
+		crashreport.Go(func() { b.doSetHostinfoFilterServices(hi) })
 	}
 	p0 := b.prefs.Clone()
 	p1 := b.prefs.Clone()
@@ -2095,7 +2321,7 @@ func (b *LocalBackend) setPrefsLockedOnEntry(caller string, newp *ipn.Prefs) {
 
 	if oldp.ShouldSSHBeRunning() && !newp.ShouldSSHBeRunning() {
 		if b.sshServer != nil {
-			go b.sshServer.Shutdown()
+			crashreport.Go(b.sshServer.Shutdown)
 			b.sshServer = nil
 		}
 	}
@@ -2128,7 +2354,7 @@ func (b *LocalBackend) setPrefsLockedOnEntry(caller string, newp *ipn.Prefs) {
 	}
 
 	if netMap != nil {
-		b.e.SetDERPMap(netMap.DERPMap)
+		b.e.SetDERPMap(b.derpMapToUse(netMap.DERPMap))
 	}
 
 	if !oldp.WantRunning && newp.WantRunning {
@@ -2170,7 +2396,8 @@ func (b *LocalBackend) ServePeerAPIConnection(remote, local netip.AddrPort, c ne
 	defer b.mu.Unlock()
 	for _, pln := range b.peerAPIListeners {
 		if pln.ip == local.Addr() {
-			go pln.ServeConn(remote, c)
+			pln := pln
+			crashreport.Go(func() { pln.ServeConn(remote, c) })
 			return
 		}
 	}
@@ -2288,6 +2515,32 @@ func (b *LocalBackend) authReconfig() {
 		return
 	}
 
+	if mc, err := b.magicConn(); err == nil {
+		mc.SetForceDERP(prefs.ForceDERP)
+		mc.SetDERPRegionPrefs(prefs.DERPHomeRegion, prefs.DERPAvoidRegions)
+		uplinks, err := preftype.ParseUplinkPolicy(prefs.UplinkPolicy)
+		if err != nil {
+			b.logf("ignoring invalid uplink policy: %v", err)
+		}
+		mc.SetUplinkPolicy(uplinks)
+		if err := mc.SetBufferLimits(prefs.RecvBatchSize, prefs.DERPSendQueue); err != nil {
+			b.logf("ignoring invalid buffer limits: %v", err)
+		}
+		portRange, err := preftype.ParsePortRange(prefs.UDPPortRange)
+		if err != nil {
+			b.logf("ignoring invalid UDP port range: %v", err)
+		}
+		mc.SetPortRange(portRange)
+	}
+	b.updateSyntheticMonitor(prefs.SyntheticMonitorPeer)
+	doctorInterval, err := parseDoctorInterval(prefs.DoctorInterval, prefs.DoctorLightweight)
+	if err != nil {
+		b.logf("ignoring invalid doctor interval: %v", err)
+	}
+	b.updateDoctorSchedule(doctorInterval, prefs.DoctorLightweight)
+	b.updatePowerSaver()
+	crashreport.Go(b.checkAdvertisedRoutes)
+
 	var flags netmap.WGConfigFlags
 	if prefs.RouteAll {
 		flags |= netmap.AllowSubnetRoutes
@@ -2319,6 +2572,7 @@ func (b *LocalBackend) authReconfig() {
 
 	oneCGNATRoute := shouldUseOneCGNATRoute(nm, b.logf, version.OS())
 	rcfg := b.routerConfig(cfg, prefs, oneCGNATRoute)
+	rcfg.MTU = b.updateAutoMTU(prefs)
 	dcfg := dnsConfigForNetmap(nm, prefs, b.logf, version.OS())
 
 	err = b.e.Reconfig(cfg, rcfg, dcfg, nm.Debug)
@@ -2330,6 +2584,24 @@ func (b *LocalBackend) authReconfig() {
 	b.initPeerAPIListener()
 }
 
+// healthWarnings returns the current health problems, one per string.
+func healthWarnings() []string {
+	err := health.OverallError()
+	if err == nil {
+		return nil
+	}
+	var ret []string
+	switch e := err.(type) {
+	case multierr.Error:
+		for _, err := range e.Errors() {
+			ret = append(ret, err.Error())
+		}
+	default:
+		ret = append(ret, err.Error())
+	}
+	return ret
+}
+
 // shouldUseOneCGNATRoute reports whether we should prefer to make one big
 // CGNAT /10 route rather than a /32 per peer.
 //
@@ -2490,6 +2762,17 @@ func dnsConfigForNetmap(nm *netmap.NetworkMap, prefs *ipn.Prefs, logf logger.Log
 			dcfg.Routes[fqdn] = append(dcfg.Routes[fqdn], r)
 		}
 	}
+	for suffix, strategy := range nm.DNS.RouteStrategies {
+		fqdn, err := dnsname.ToFQDN(suffix)
+		if err != nil {
+			logf("[unexpected] non-FQDN route strategy suffix %q", suffix)
+			continue
+		}
+		if dcfg.RouteStrategies == nil {
+			dcfg.RouteStrategies = map[dnsname.FQDN]dnstype.Strategy{}
+		}
+		dcfg.RouteStrategies[fqdn] = strategy
+	}
 
 	// Set FallbackResolvers as the default resolvers in the
 	// scenarios that can't handle a purely split-DNS config. See
@@ -2680,11 +2963,12 @@ func (b *LocalBackend) initPeerAPIListener() {
 		}
 		pln.urlStr = "http://" + net.JoinHostPort(a.Addr().String(), strconv.Itoa(pln.port))
 		b.logf("peerapi: serving on %s", pln.urlStr)
-		go pln.serve()
+		crashreport.Go(pln.serve)
 		b.peerAPIListeners = append(b.peerAPIListeners, pln)
 	}
 
-	go b.doSetHostinfoFilterServices(b.hostinfo.Clone())
+	hi := b.hostinfo.Clone()
+	crashreport.Go(func() { b.doSetHostinfoFilterServices(hi) })
 }
 
 // magicDNSRootDomains returns the subset of nm.DNS.Domains that are the search domains for MagicDNS.
@@ -2773,14 +3057,18 @@ func (b *LocalBackend) routerConfig(cfg *wgcfg.Config, prefs *ipn.Prefs, oneCGNA
 		LocalAddrs:       unmapIPPrefixes(cfg.Addresses),
 		SubnetRoutes:     unmapIPPrefixes(prefs.AdvertiseRoutes),
 		SNATSubnetRoutes: !prefs.NoSNAT,
+		ClampMSS:         prefs.ClampMSS,
 		NetfilterMode:    prefs.NetfilterMode,
 		Routes:           peerRoutes(cfg.Peers, singleRouteThreshold),
+		RouteMetric:      prefs.RouteMetric,
 	}
 
 	if distro.Get() == distro.Synology {
 		// Issue 1995: we don't use iptables on Synology.
 		rs.NetfilterMode = preftype.NetfilterOff
 	}
+	subnetRoutes := rs.Routes
+	var exclusions []ipnstate.ExcludedRoute
 
 	// Sanity check: we expect the control server to program both a v4
 	// and a v6 default route, if default routing is on. Fill in
@@ -2822,7 +3110,25 @@ func (b *LocalBackend) routerConfig(cfg *wgcfg.Config, prefs *ipn.Prefs, oneCGNA
 			}
 			b.logf("allowing exit node access to local IPs: %v", rs.LocalRoutes)
 		}
+		exclusions = exitNodeExclusions(prefs.ExitNodeExcludeRoutes, subnetRoutes)
+		for i, e := range exclusions {
+			switch {
+			case runtime.GOOS != "linux":
+				// Only the Linux router installs LocalRoutes as
+				// routes around the tunnel; elsewhere they only
+				// open the firewall, or are ignored.
+				exclusions[i].Dropped = "not supported on " + runtime.GOOS
+			case e.Dropped != "":
+				b.logf("not excluding %v from exit node: %s", e.Route, e.Dropped)
+			default:
+				rs.LocalRoutes = append(rs.LocalRoutes, e.Route)
+				rs.Routes = removePrefix(rs.Routes, e.Route)
+			}
+		}
 	}
+	b.mu.Lock()
+	b.exitNodeExclusions = exclusions
+	b.mu.Unlock()
 
 	if tsaddr.PrefixesContainsFunc(rs.LocalAddrs, tsaddr.PrefixIs4) {
 		rs.Routes = append(rs.Routes, netip.PrefixFrom(tsaddr.TailscaleServiceIP(), 32))
@@ -2831,6 +3137,17 @@ func (b *LocalBackend) routerConfig(cfg *wgcfg.Config, prefs *ipn.Prefs, oneCGNA
 	return rs
 }
 
+// removePrefix returns pp without p, which it may modify.
+func removePrefix(pp []netip.Prefix, p netip.Prefix) []netip.Prefix {
+	ret := pp[:0]
+	for _, q := range pp {
+		if q != p {
+			ret = append(ret, q)
+		}
+	}
+	return ret
+}
+
 func unmapIPPrefix(ipp netip.Prefix) netip.Prefix {
 	return netip.PrefixFrom(ipp.Addr().Unmap(), ipp.Bits())
 }
@@ -3039,7 +3356,7 @@ func (b *LocalBackend) requestEngineStatusAndWait() {
 	b.logf("requestEngineStatusAndWait")
 
 	b.statusLock.Lock()
-	go b.e.RequestStatus()
+	crashreport.Go(b.e.RequestStatus)
 	b.logf("requestEngineStatusAndWait: waiting...")
 	b.statusChanged.Wait() // temporarily releases lock while waiting
 	b.logf("requestEngineStatusAndWait: got status update.")
@@ -3059,7 +3376,7 @@ func (b *LocalBackend) ResetForClientDisconnect() {
 	b.logf("LocalBackend.ResetForClientDisconnect")
 
 	if b.cc != nil {
-		go b.cc.Shutdown()
+		crashreport.Go(b.cc.Shutdown)
 		b.cc = nil
 	}
 	b.stateKey = ""
@@ -3075,6 +3392,10 @@ func (b *LocalBackend) ResetForClientDisconnect() {
 
 func (b *LocalBackend) ShouldRunSSH() bool { return b.sshAtomicBool.Load() && canSSH }
 
+// ShouldClampMSS reports whether the TCP MSS of forwarded connections
+// should be clamped, per the ClampMSS pref.
+func (b *LocalBackend) ShouldClampMSS() bool { return b.clampMSSAtomicBool.Load() }
+
 // ShouldHandleViaIP reports whether whether ip is an IPv6 address in the
 // Tailscale ULA's v6 "via" range embedding an IPv4 address to be forwarded to
 // by Tailscale.
@@ -3444,7 +3765,7 @@ func (b *LocalBackend) DERPMap() *tailcfg.DERPMap {
 	if b.netMap == nil {
 		return nil
 	}
-	return b.netMap.DERPMap
+	return b.derpMapToUse(b.netMap.DERPMap)
 }
 
 // OfferingExitNode reports whether b is currently offering exit node
@@ -3556,6 +3877,85 @@ func (b *LocalBackend) DebugReSTUN() error {
 	return nil
 }
 
+// SetPeerPathPin restricts the paths used to reach the peer with the
+// Tailscale IP ip. A zero pin removes any restriction.
+func (b *LocalBackend) SetPeerPathPin(ip netip.Addr, pin ipnstate.PathPin) error {
+	b.mu.Lock()
+	n, ok := b.nodeByAddr[ip]
+	b.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("no peer with Tailscale IP %v", ip)
+	}
+	mc, err := b.magicConn()
+	if err != nil {
+		return err
+	}
+	return mc.SetPeerPathPin(n.Key, pin)
+}
+
+// LocalEndpoints returns the UDP endpoints this node advertises, to
+// import on a peer with ImportPeerEndpoints.
+func (b *LocalBackend) LocalEndpoints() (*ipnstate.NodeEndpoints, error) {
+	b.mu.Lock()
+	nm := b.netMap
+	b.mu.Unlock()
+	if nm == nil {
+		return nil, errors.New("no netmap")
+	}
+	mc, err := b.magicConn()
+	if err != nil {
+		return nil, err
+	}
+	ret := &ipnstate.NodeEndpoints{
+		NodeKey:   nm.NodeKey,
+		Endpoints: mc.LocalEndpoints(),
+	}
+	for _, pfx := range nm.Addresses {
+		if pfx.IsSingleIP() {
+			ret.TailscaleIPs = append(ret.TailscaleIPs, pfx.Addr())
+		}
+	}
+	return ret, nil
+}
+
+// ImportPeerEndpoints adds eps to the endpoints of the peer with the
+// Tailscale IP ip, replacing those imported before, for debugging.
+// Empty eps removes them.
+func (b *LocalBackend) ImportPeerEndpoints(ip netip.Addr, eps []netip.AddrPort) error {
+	b.mu.Lock()
+	n, ok := b.nodeByAddr[ip]
+	b.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("no peer with Tailscale IP %v", ip)
+	}
+	mc, err := b.magicConn()
+	if err != nil {
+		return err
+	}
+	mc.ImportPeerEndpoints(n.Key, eps)
+	return nil
+}
+
+// FirstContact returns the trace of the most recent connection setup to
+// the peer with the Tailscale IP ip after it had no recent traffic.
+func (b *LocalBackend) FirstContact(ip netip.Addr) (*ipnstate.FirstContactTrace, error) {
+	b.mu.Lock()
+	n, ok := b.nodeByAddr[ip]
+	b.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no peer with Tailscale IP %v", ip)
+	}
+	mc, err := b.magicConn()
+	if err != nil {
+		return nil, err
+	}
+	t, ok := mc.FirstContact(n.Key)
+	if !ok {
+		return nil, fmt.Errorf("no first contact with %v traced since tailscaled started", ip)
+	}
+	return t, nil
+}
+
 func (b *LocalBackend) magicConn() (*magicsock.Conn, error) {
 	ig, ok := b.e.(wgengine.InternalsGetter)
 	if !ok {
@@ -3568,6 +3968,26 @@ func (b *LocalBackend) magicConn() (*magicsock.Conn, error) {
 	return mc, nil
 }
 
+// DebugRouterState returns the routing configuration the engine last
+// applied to the OS, and the routes that failed to be added or removed.
+func (b *LocalBackend) DebugRouterState() (*ipnstate.RouterState, error) {
+	rg, ok := b.e.(wgengine.RouterStateGetter)
+	if !ok {
+		return nil, errors.New("engine doesn't report its router state")
+	}
+	return rg.RouterState(), nil
+}
+
+// DebugFilterStats returns how many packets the current packet filter
+// accepted by each of its rules and dropped for each reason.
+func (b *LocalBackend) DebugFilterStats() (*ipnstate.FilterStats, error) {
+	f := b.e.GetFilter()
+	if f == nil {
+		return nil, errors.New("no packet filter installed")
+	}
+	return f.Stats(), nil
+}
+
 // DoNoiseRequest sends a request to URL over the the control plane
 // Noise connection.
 func (b *LocalBackend) DoNoiseRequest(req *http.Request) (*http.Response, error) {
@@ -3638,3 +4058,13 @@ func (b *LocalBackend) handleQuad100Port80Conn(w http.ResponseWriter, r *http.Re
 	}
 	io.WriteString(w, "</ul>\n")
 }
+
+// LogGatewayMonitor logs a summary of the latency and loss to the
+// default gateway, for a bug report.
+func (b *LocalBackend) LogGatewayMonitor(logf logger.Logf) {
+	if b.gwMon == nil {
+		logf("disabled")
+		return
+	}
+	b.gwMon.LogSummary(logf)
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnlocal
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// LogLeveler is the interface implemented by loggers whose verbosity can
+// be changed at runtime, per component. *logtail.Logger implements it.
+type LogLeveler interface {
+	SetVerbosityLevel(level int)
+	SetComponentVerbosityLevel(component string, level int)
+	VerbosityLevels() (level int, components map[string]int)
+}
+
+// LogLevelAll is the component name that SetLogLevel takes to mean
+// the default verbosity of all components.
+const LogLevelAll = "all"
+
+// SetLogLeveler sets the logger whose verbosity is changed by
+// SetLogLevel.
+//
+// It should only be called before the LocalBackend is used.
+func (b *LocalBackend) SetLogLeveler(l LogLeveler) {
+	b.logLeveler = l
+}
+
+// SetLogLevel sets the log verbosity of component, such as "magicsock"
+// or "dns", or of all components if component is LogLevelAll.
+// A negative level removes a component's override, reverting it to the
+// default verbosity.
+func (b *LocalBackend) SetLogLevel(component string, level int) error {
+	if b.logLeveler == nil {
+		return errors.New("log levels can't be changed in this build")
+	}
+	if component == "" {
+		return errors.New("empty component")
+	}
+	if component == LogLevelAll {
+		if level < 0 {
+			level = 0
+		}
+		b.logLeveler.SetVerbosityLevel(level)
+	} else {
+		b.logLeveler.SetComponentVerbosityLevel(component, level)
+	}
+	b.logf("log level of %s set to %d", component, level)
+	return nil
+}
+
+// LogLevels returns the current log verbosity of each component with
+// its own level, plus that of LogLevelAll.
+func (b *LocalBackend) LogLevels() (map[string]int, error) {
+	if b.logLeveler == nil {
+		return nil, errors.New("log levels can't be changed in this build")
+	}
+	level, m := b.logLeveler.VerbosityLevels()
+	m[LogLevelAll] = level
+	return m, nil
+}
+
+// RecentLogWriter is the interface implemented by loggers that keep
+// recent log lines in memory. *logtail.Logger implements it.
+type RecentLogWriter interface {
+	WriteRecent(w io.Writer, since time.Time, component string) error
+}
+
+// SetRecentLogWriter sets the logger whose recent lines are returned by
+// WriteRecentLogs.
+//
+// It should only be called before the LocalBackend is used.
+func (b *LocalBackend) SetRecentLogWriter(l RecentLogWriter) {
+	b.recentLogs = l
+}
+
+// WriteRecentLogs writes the log lines kept in memory that were logged
+// at or after since to w. If component is non-empty, only that
+// component's lines are written.
+func (b *LocalBackend) WriteRecentLogs(w io.Writer, since time.Time, component string) error {
+	if b.recentLogs == nil {
+		return errors.New("recent logs aren't kept in this build")
+	}
+	return b.recentLogs.WriteRecent(w, since, component)
+}
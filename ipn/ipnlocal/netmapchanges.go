@@ -0,0 +1,34 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnlocal
+
+import (
+	"tailscale.com/envknob"
+	"tailscale.com/types/netmap"
+)
+
+// debugNetmapChanges reports whether the changes between consecutive
+// netmaps from the control server are logged and recorded as events for
+// diagnostic snapshots, so that a sudden change in behavior can be tied
+// to the control update that caused it. It can be turned on at runtime
+// with "tailscale debug set-knob TS_DEBUG_NETMAP_CHANGES=true".
+var debugNetmapChanges = envknob.RegisterBool("TS_DEBUG_NETMAP_CHANGES")
+
+// logNetmapChanges logs changes, those between the last netmap and a
+// new one, one per line, numbering the update they came in so that the
+// lines of one update can be told apart from the next, and records
+// them as events for diagnostic snapshots.
+func (b *LocalBackend) logNetmapChanges(changes []netmap.Change) {
+	n := b.netmapUpdates.Add(1)
+	if len(changes) == 0 {
+		b.logf("netmap update %d: no changes", n)
+		return
+	}
+	b.logf("netmap update %d: %d changes", n, len(changes))
+	for _, c := range changes {
+		b.logf("netmap update %d: %v", n, c)
+		b.noteDiagEvent("netmap update %d: %v", n, c)
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnlocal
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/interfaces"
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/types/netmap"
+)
+
+// NetmapRoutes returns the subnet routes and exit nodes that peers in
+// the current network map offer, whether this node uses each and, if
+// not, why.
+func (b *LocalBackend) NetmapRoutes() ([]*ipnstate.NetmapRoute, error) {
+	b.mu.Lock()
+	nm := b.netMap
+	prefs := b.prefs.Clone()
+	ifState := b.prevIfState
+	b.mu.Unlock()
+	if nm == nil {
+		return nil, errors.New("no netmap")
+	}
+	return netmapRoutes(nm, prefs, localNetworks(ifState)), nil
+}
+
+// localNetworks returns the prefixes of the non-Tailscale networks that
+// this node's interfaces are on.
+func localNetworks(st *interfaces.State) []netip.Prefix {
+	if st == nil {
+		return nil
+	}
+	var ret []netip.Prefix
+	for _, pfxs := range st.InterfaceIPs {
+		for _, pfx := range pfxs {
+			ip := pfx.Addr()
+			if ip.IsLoopback() || ip.IsLinkLocalUnicast() || tsaddr.IsTailscaleIP(ip) {
+				continue
+			}
+			ret = append(ret, pfx.Masked())
+		}
+	}
+	return ret
+}
+
+// netmapRoutes implements NetmapRoutes. localNets are the networks this
+// node is on.
+func netmapRoutes(nm *netmap.NetworkMap, prefs *ipn.Prefs, localNets []netip.Prefix) []*ipnstate.NetmapRoute {
+	// primaryFor maps each approved route to the name of its router.
+	primaryFor := map[netip.Prefix]string{}
+	for _, p := range nm.Peers {
+		for _, r := range p.AllowedIPs {
+			if !isPeerAddress(p.Addresses, r) {
+				primaryFor[r] = p.ComputedName
+			}
+		}
+	}
+
+	var ret []*ipnstate.NetmapRoute
+	for _, p := range nm.Peers {
+		routes := map[netip.Prefix]*ipnstate.NetmapRoute{}
+		get := func(r netip.Prefix) *ipnstate.NetmapRoute {
+			nr, ok := routes[r]
+			if !ok {
+				nr = &ipnstate.NetmapRoute{
+					Route:    r,
+					NodeName: p.ComputedName,
+					ExitNode: r.Bits() == 0,
+				}
+				routes[r] = nr
+			}
+			return nr
+		}
+		if p.Hostinfo.Valid() {
+			rips := p.Hostinfo.RoutableIPs()
+			for i := 0; i < rips.Len(); i++ {
+				get(rips.At(i)).Advertised = true
+			}
+		}
+		for _, r := range p.AllowedIPs {
+			if !isPeerAddress(p.Addresses, r) {
+				get(r).Approved = true
+			}
+		}
+
+		offline := p.Online != nil && !*p.Online
+		for _, nr := range routes {
+			switch {
+			case !nr.Approved:
+				if other, ok := primaryFor[nr.Route]; ok {
+					nr.Reason = fmt.Sprintf("%s is the primary router for it", other)
+				} else {
+					nr.Reason = "not approved by the tailnet admin"
+				}
+			case nr.ExitNode:
+				if prefs.ExitNodeID != p.StableID {
+					nr.Reason = "exit node not selected"
+				} else {
+					nr.Used = true
+				}
+			case !prefs.RouteAll:
+				nr.Reason = "subnet routes not accepted (--accept-routes is off)"
+			default:
+				nr.Used = true
+			}
+			var notes []string
+			if nr.Used && offline {
+				notes = append(notes, "router is offline")
+			}
+			if !nr.ExitNode {
+				for _, ln := range localNets {
+					if ln.Overlaps(nr.Route) {
+						notes = append(notes, fmt.Sprintf("overlaps local network %v", ln))
+						break
+					}
+				}
+			}
+			nr.Note = strings.Join(notes, "; ")
+			ret = append(ret, nr)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		a, b := ret[i], ret[j]
+		if a.NodeName != b.NodeName {
+			return a.NodeName < b.NodeName
+		}
+		if a.Route.Addr() != b.Route.Addr() {
+			return a.Route.Addr().Less(b.Route.Addr())
+		}
+		return a.Route.Bits() < b.Route.Bits()
+	})
+	return ret
+}
+
+// isPeerAddress reports whether r is one of a peer's own addresses
+// rather than a route it's a router for.
+func isPeerAddress(addrs []netip.Prefix, r netip.Prefix) bool {
+	for _, a := range addrs {
+		if a == r {
+			return true
+		}
+	}
+	return false
+}
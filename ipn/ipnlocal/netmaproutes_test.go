@@ -0,0 +1,94 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnlocal
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+)
+
+func TestNetmapRoutes(t *testing.T) {
+	pfx := netip.MustParsePrefix
+	routes := func(s ...string) (ret []netip.Prefix) {
+		for _, r := range s {
+			ret = append(ret, pfx(r))
+		}
+		return ret
+	}
+	offline := false
+	nm := &netmap.NetworkMap{
+		Peers: []*tailcfg.Node{
+			{
+				ComputedName: "exit",
+				StableID:     "exit-id",
+				Addresses:    routes("100.64.0.1/32"),
+				AllowedIPs:   routes("100.64.0.1/32", "0.0.0.0/0", "::/0"),
+				Hostinfo:     (&tailcfg.Hostinfo{RoutableIPs: routes("0.0.0.0/0", "::/0")}).View(),
+			},
+			{
+				ComputedName: "router-a",
+				Addresses:    routes("100.64.0.2/32"),
+				AllowedIPs:   routes("100.64.0.2/32", "10.0.0.0/24"),
+				Hostinfo:     (&tailcfg.Hostinfo{RoutableIPs: routes("10.0.0.0/24", "10.1.0.0/24")}).View(),
+				Online:       &offline,
+			},
+			{
+				ComputedName: "router-b",
+				Addresses:    routes("100.64.0.3/32"),
+				AllowedIPs:   routes("100.64.0.3/32", "192.168.1.0/24"),
+				Hostinfo:     (&tailcfg.Hostinfo{RoutableIPs: routes("10.0.0.0/24", "192.168.1.0/24")}).View(),
+			},
+		},
+	}
+	localNets := routes("192.168.1.0/24")
+
+	tests := []struct {
+		name  string
+		prefs *ipn.Prefs
+		want  []*ipnstate.NetmapRoute
+	}{
+		{
+			name:  "accept-routes",
+			prefs: &ipn.Prefs{RouteAll: true},
+			want: []*ipnstate.NetmapRoute{
+				{Route: pfx("0.0.0.0/0"), NodeName: "exit", ExitNode: true, Advertised: true, Approved: true, Reason: "exit node not selected"},
+				{Route: pfx("::/0"), NodeName: "exit", ExitNode: true, Advertised: true, Approved: true, Reason: "exit node not selected"},
+				{Route: pfx("10.0.0.0/24"), NodeName: "router-a", Advertised: true, Approved: true, Used: true, Note: "router is offline"},
+				{Route: pfx("10.1.0.0/24"), NodeName: "router-a", Advertised: true, Reason: "not approved by the tailnet admin"},
+				{Route: pfx("10.0.0.0/24"), NodeName: "router-b", Advertised: true, Reason: "router-a is the primary router for it"},
+				{Route: pfx("192.168.1.0/24"), NodeName: "router-b", Advertised: true, Approved: true, Used: true, Note: "overlaps local network 192.168.1.0/24"},
+			},
+		},
+		{
+			name:  "exit-node",
+			prefs: &ipn.Prefs{ExitNodeID: "exit-id"},
+			want: []*ipnstate.NetmapRoute{
+				{Route: pfx("0.0.0.0/0"), NodeName: "exit", ExitNode: true, Advertised: true, Approved: true, Used: true},
+				{Route: pfx("::/0"), NodeName: "exit", ExitNode: true, Advertised: true, Approved: true, Used: true},
+				{Route: pfx("10.0.0.0/24"), NodeName: "router-a", Advertised: true, Approved: true, Reason: "subnet routes not accepted (--accept-routes is off)"},
+				{Route: pfx("10.1.0.0/24"), NodeName: "router-a", Advertised: true, Reason: "not approved by the tailnet admin"},
+				{Route: pfx("10.0.0.0/24"), NodeName: "router-b", Advertised: true, Reason: "router-a is the primary router for it"},
+				{Route: pfx("192.168.1.0/24"), NodeName: "router-b", Advertised: true, Approved: true, Reason: "subnet routes not accepted (--accept-routes is off)", Note: "overlaps local network 192.168.1.0/24"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := netmapRoutes(nm, tt.prefs, localNets)
+			if !reflect.DeepEqual(got, tt.want) {
+				for _, r := range got {
+					t.Logf("got %+v", *r)
+				}
+				t.Errorf("mismatch")
+			}
+		})
+	}
+}
@@ -31,7 +31,6 @@
 	"unicode"
 	"unicode/utf8"
 
-	"github.com/kortschak/wol"
 	"golang.org/x/net/dns/dnsmessage"
 	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/health"
@@ -44,6 +43,7 @@
 	"tailscale.com/net/netutil"
 	"tailscale.com/tailcfg"
 	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/crashreport"
 	"tailscale.com/wgengine"
 	"tailscale.com/wgengine/filter"
 )
@@ -524,7 +524,8 @@ func (pln *peerAPIListener) ServeConn(src netip.AddrPort, c net.Conn) {
 	if addH2C != nil {
 		addH2C(httpServer)
 	}
-	go httpServer.Serve(netutil.NewOneConnListener(c, pln.ln.Addr()))
+	ln := netutil.NewOneConnListener(c, pln.ln.Addr())
+	crashreport.Go(func() { httpServer.Serve(ln) })
 }
 
 // peerAPIHandler serves the Peer API for a source specific client.
@@ -571,6 +572,12 @@ func (h *peerAPIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	case "/v0/interfaces":
 		h.handleServeInterfaces(w, r)
 		return
+	case "/v0/doctor":
+		h.handleDoctor(w, r)
+		return
+	case "/v0/speedtest":
+		h.handleSpeedTest(w, r)
+		return
 	}
 	who := h.peerUser.DisplayName
 	fmt.Fprintf(w, `<html>
@@ -693,6 +700,18 @@ func (h *peerAPIHandler) canWakeOnLAN() bool {
 	return h.isSelf || h.peerHasCap(tailcfg.CapabilityWakeOnLAN)
 }
 
+// canDoctor reports whether h can run this node's doctor checks.
+// The AllowRemoteDoctor pref must also be set.
+func (h *peerAPIHandler) canDoctor() bool {
+	return h.isSelf || h.peerHasCap(tailcfg.CapabilityDoctorPeer)
+}
+
+// canSpeedTest reports whether h can run a throughput test against
+// this node. The AllowSpeedTest pref must also be set.
+func (h *peerAPIHandler) canSpeedTest() bool {
+	return h.isSelf || h.peerHasCap(tailcfg.CapabilitySpeedTestPeer)
+}
+
 func (h *peerAPIHandler) peerHasCap(wantCap string) bool {
 	for _, hasCap := range h.ps.b.PeerCaps(h.remoteAddr.Addr()) {
 		if hasCap == wantCap {
@@ -914,42 +933,78 @@ func (h *peerAPIHandler) handleWakeOnLAN(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "bad 'mac' param", http.StatusBadRequest)
 		return
 	}
-	var password []byte // TODO(bradfitz): support?
-	st, err := interfaces.GetState()
-	if err != nil {
-		http.Error(w, "failed to get interfaces state", http.StatusInternalServerError)
-		return
-	}
 	var res struct {
 		SentTo []string
 		Errors []string
 	}
-	for ifName, ips := range st.InterfaceIPs {
-		for _, ip := range ips {
-			if ip.Addr().IsLoopback() || ip.Addr().Is6() {
-				continue
-			}
-			local := &net.UDPAddr{
-				IP:   ip.Addr().AsSlice(),
-				Port: 0,
-			}
-			remote := &net.UDPAddr{
-				IP:   net.IPv4bcast,
-				Port: 0,
-			}
-			if err := wol.Wake(mac, password, local, remote); err != nil {
-				res.Errors = append(res.Errors, err.Error())
-			} else {
-				res.SentTo = append(res.SentTo, ifName)
-			}
-			break // one per interface is enough
-		}
+	res.SentTo, res.Errors = sendWoL(mac)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *peerAPIHandler) handleDoctor(w http.ResponseWriter, r *http.Request) {
+	if !h.canDoctor() {
+		http.Error(w, "denied; no doctor access", http.StatusForbidden)
+		return
+	}
+	if !h.ps.b.allowsRemoteDoctor() {
+		http.Error(w, "denied; remote doctor not allowed by this node's prefs", http.StatusForbidden)
+		return
 	}
-	sort.Strings(res.SentTo)
+	if r.Method != "POST" {
+		http.Error(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+	h.logf("running doctor checks for %v (%v)", h.peerNode.ComputedName, h.remoteAddr.Addr())
+	res := h.ps.b.doctorResults(r.Context())
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(res)
 }
 
+// handleSpeedTest switches the connection to the net/speedtest
+// protocol and serves a throughput test to the peer.
+func (h *peerAPIHandler) handleSpeedTest(w http.ResponseWriter, r *http.Request) {
+	if !h.canSpeedTest() {
+		http.Error(w, "denied; no speedtest access", http.StatusForbidden)
+		return
+	}
+	if !h.ps.b.allowsSpeedTest() {
+		http.Error(w, "denied; speedtest not allowed by this node's prefs", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+	if !strings.EqualFold(r.Header.Get("Upgrade"), speedTestUpgrade) {
+		http.Error(w, "missing Upgrade: "+speedTestUpgrade, http.StatusBadRequest)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "can't hijack connection", http.StatusInternalServerError)
+		return
+	}
+	b := h.ps.b
+	if !b.speedTestRunning.CompareAndSwap(false, true) {
+		http.Error(w, "another speedtest is running", http.StatusServiceUnavailable)
+		return
+	}
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		b.speedTestRunning.Store(false)
+		h.logf("speedtest hijack: %v", err)
+		return
+	}
+	fmt.Fprintf(brw, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: %s\r\n\r\n", speedTestUpgrade)
+	if err := brw.Flush(); err != nil {
+		conn.Close()
+		b.speedTestRunning.Store(false)
+		return
+	}
+	b.serveSpeedTest(conn, h.peerNode.ComputedName)
+}
+
 func (h *peerAPIHandler) replyToDNSQueries() bool {
 	if h.isSelf {
 		// If the peer is owned by the same user, just allow it
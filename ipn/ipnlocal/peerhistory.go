@@ -0,0 +1,204 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnlocal
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"tailscale.com/atomicfile"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/wgengine/magicsock"
+)
+
+const (
+	// peerHistoryFile is the file, under TailscaleVarRoot, that the
+	// peer connectivity history is kept in.
+	peerHistoryFile = "peer-history.json"
+
+	// peerHistoryInterval is how often the paths to active peers are
+	// sampled into the history.
+	peerHistoryInterval = time.Minute
+
+	// peerHistorySaveInterval is the most often the history is
+	// written to disk, besides on shutdown.
+	peerHistorySaveInterval = 10 * time.Minute
+
+	// peerHistoryMaxAge is how long the history of a peer is kept
+	// after packets were last sent to it.
+	peerHistoryMaxAge = 30 * 24 * time.Hour
+
+	// peerHistoryRTTWeight is the inverse of the weight of each new
+	// direct path latency in PeerConnHistory.TypicalRTT.
+	peerHistoryRTTWeight = 8
+)
+
+// peerHistory is the persisted connectivity history of each peer. See
+// ipnstate.PeerConnHistory.
+type peerHistory struct {
+	mu    sync.Mutex
+	path  string // file to persist to, or empty to not persist
+	peers map[tailcfg.StableNodeID]*ipnstate.PeerConnHistory
+	dirty bool      // whether peers changed since the last save
+	saved time.Time // when peers were last saved
+}
+
+// load reads the history persisted at path, which it's saved to from
+// then on. A missing or corrupt file starts an empty history.
+func (h *peerHistory) load(path string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.path = path
+	bs, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var peers map[tailcfg.StableNodeID]*ipnstate.PeerConnHistory
+	if err := json.Unmarshal(bs, &peers); err != nil {
+		return err
+	}
+	// Keep what was noted before loading, which is newer.
+	for id, ph := range h.peers {
+		peers[id] = ph
+	}
+	h.peers = peers
+	return nil
+}
+
+// note records that packets were being sent to the peer id over path
+// at time now.
+func (h *peerHistory) note(now time.Time, id tailcfg.StableNodeID, path magicsock.PeerPath) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ph := h.peers[id]
+	if ph == nil {
+		ph = &ipnstate.PeerConnHistory{Since: now}
+		if h.peers == nil {
+			h.peers = make(map[tailcfg.StableNodeID]*ipnstate.PeerConnHistory)
+		}
+		h.peers[id] = ph
+	}
+	h.dirty = true
+	ph.LastActive = now
+	if !path.Direct {
+		if ph.LastDERPOnlyStart.IsZero() || !ph.LastDERPOnlyEnd.IsZero() {
+			ph.LastDERPOnlyStart = now
+			ph.LastDERPOnlyEnd = time.Time{}
+		}
+		return
+	}
+	ph.LastDirect = now
+	if !ph.LastDERPOnlyStart.IsZero() && ph.LastDERPOnlyEnd.IsZero() {
+		ph.LastDERPOnlyEnd = now
+	}
+	if path.Latency > 0 {
+		if ph.TypicalRTT == 0 {
+			ph.TypicalRTT = path.Latency
+		} else {
+			ph.TypicalRTT += (path.Latency - ph.TypicalRTT) / peerHistoryRTTWeight
+		}
+	}
+}
+
+// get returns a copy of the history of the peer id, or nil if it has
+// none.
+func (h *peerHistory) get(id tailcfg.StableNodeID) *ipnstate.PeerConnHistory {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ph := h.peers[id]
+	if ph == nil {
+		return nil
+	}
+	ret := *ph
+	return &ret
+}
+
+// save writes the history to disk, if it changed and, unless force,
+// it wasn't saved in the last peerHistorySaveInterval. Peers that
+// weren't active in the last peerHistoryMaxAge are dropped first.
+func (h *peerHistory) save(now time.Time, force bool) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.path == "" || !h.dirty || (!force && now.Sub(h.saved) < peerHistorySaveInterval) {
+		return nil
+	}
+	for id, ph := range h.peers {
+		if now.Sub(ph.LastActive) > peerHistoryMaxAge {
+			delete(h.peers, id)
+		}
+	}
+	j, err := json.MarshalIndent(h.peers, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(h.path), 0700); err != nil {
+		return err
+	}
+	if err := atomicfile.WriteFile(h.path, j, 0600); err != nil {
+		return err
+	}
+	h.dirty = false
+	h.saved = now
+	return nil
+}
+
+// runPeerHistory samples the paths to active peers into b.peerHist
+// every peerHistoryInterval, until b shuts down.
+func (b *LocalBackend) runPeerHistory() {
+	if root := b.TailscaleVarRoot(); root != "" {
+		if err := b.peerHist.load(filepath.Join(root, peerHistoryFile)); err != nil {
+			b.logf("peer history: %v", err)
+		}
+	}
+	t := time.NewTicker(peerHistoryInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-t.C:
+		}
+		now := time.Now()
+		b.samplePeerHistory(now)
+		if err := b.peerHist.save(now, false); err != nil {
+			b.logf("peer history: %v", err)
+		}
+	}
+}
+
+// samplePeerHistory notes the path in use to each active peer.
+func (b *LocalBackend) samplePeerHistory(now time.Time) {
+	mc, err := b.magicConn()
+	if err != nil {
+		return
+	}
+	paths := mc.ActivePeerPaths()
+	if len(paths) == 0 {
+		return
+	}
+	b.mu.Lock()
+	nm := b.netMap
+	b.mu.Unlock()
+	if nm == nil {
+		return
+	}
+	ids := make(map[key.NodePublic]tailcfg.StableNodeID, len(nm.Peers))
+	for _, p := range nm.Peers {
+		ids[p.Key] = p.StableID
+	}
+	for k, path := range paths {
+		if id := ids[k]; id != "" {
+			b.peerHist.note(now, id, path)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnlocal
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/wgengine/magicsock"
+)
+
+func TestPeerHistory(t *testing.T) {
+	t0 := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	at := func(min int) time.Time { return t0.Add(time.Duration(min) * time.Minute) }
+	derp := magicsock.PeerPath{}
+	direct := func(ms int) magicsock.PeerPath {
+		return magicsock.PeerPath{Direct: true, Latency: time.Duration(ms) * time.Millisecond}
+	}
+
+	var h peerHistory
+	path := filepath.Join(t.TempDir(), peerHistoryFile)
+	if err := h.load(path); err != nil {
+		t.Fatal(err)
+	}
+	if h.get("n1") != nil {
+		t.Fatal("history of unknown peer")
+	}
+
+	h.note(at(0), "n1", derp)
+	h.note(at(1), "n1", derp)
+	h.note(at(2), "n1", direct(16))
+	h.note(at(3), "n1", direct(32))
+	h.note(at(4), "n1", derp)
+	want := &ipnstate.PeerConnHistory{
+		Since:             at(0),
+		LastActive:        at(4),
+		LastDirect:        at(3),
+		LastDERPOnlyStart: at(4),
+		TypicalRTT:        18 * time.Millisecond,
+	}
+	if got := h.get("n1"); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v; want %+v", got, want)
+	}
+
+	h.note(at(5), "n2", derp)
+	if err := h.save(at(5), false); err != nil {
+		t.Fatal(err)
+	}
+	var h2 peerHistory
+	if err := h2.load(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := h2.get("n1"); !reflect.DeepEqual(got, want) {
+		t.Errorf("after reload, got %+v; want %+v", got, want)
+	}
+
+	// Peers inactive for too long are dropped when saving.
+	h2.note(at(6), "n2", direct(10))
+	if err := h2.save(at(5).Add(peerHistoryMaxAge), true); err != nil {
+		t.Fatal(err)
+	}
+	if h2.get("n1") != nil {
+		t.Error("stale peer n1 kept")
+	}
+	if h2.get("n2") == nil {
+		t.Error("peer n2 dropped")
+	}
+}
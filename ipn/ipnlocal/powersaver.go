@@ -0,0 +1,139 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnlocal
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/types/preftype"
+	"tailscale.com/util/powerstate"
+)
+
+// powerSaverInterval is how often the battery state is checked for the
+// PowerSaver pref's "auto" mode. Link changes are acted on right away.
+const powerSaverInterval = time.Minute
+
+// powerSaverState is the state of power saver mode, as set by the
+// PowerSaver pref.
+type powerSaverState struct {
+	mu     sync.Mutex
+	mode   preftype.PowerSaverMode
+	active bool
+	reason string // why it's active or not, for status
+}
+
+// runPowerSaver re-evaluates power saver mode every powerSaverInterval,
+// until b shuts down.
+func (b *LocalBackend) runPowerSaver() {
+	t := time.NewTicker(powerSaverInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-t.C:
+		}
+		b.updatePowerSaver()
+	}
+}
+
+// updatePowerSaver turns magicsock's power saver mode on or off as per
+// the PowerSaver pref and, in its "auto" mode, whether the machine is
+// on battery or its default route is over a metered link. It logs when
+// that changes.
+func (b *LocalBackend) updatePowerSaver() {
+	b.mu.Lock()
+	var pref string
+	if b.prefs != nil {
+		pref = b.prefs.PowerSaver
+	}
+	var defIf string
+	if b.prevIfState != nil {
+		defIf = b.prevIfState.DefaultRouteInterface
+	}
+	b.mu.Unlock()
+
+	mc, err := b.magicConn()
+	if err != nil {
+		return
+	}
+	mode, err := preftype.ParsePowerSaverMode(pref)
+	if err != nil {
+		// Validated by the CLI; treat anything else as off.
+		mode = preftype.PowerSaverOff
+	}
+	var onBattery bool
+	if mode == preftype.PowerSaverAuto {
+		onBattery, _ = powerstate.OnBattery()
+	}
+	active, reason := powerSaverWanted(mode, onBattery, mc.LinkExpensive(), defIf)
+
+	s := &b.powerSaver
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if active != s.active {
+		if active {
+			b.logf("power saver on: %s", reason)
+		} else if reason != "" {
+			b.logf("power saver off: %s", reason)
+		} else {
+			b.logf("power saver off")
+		}
+	}
+	s.mode, s.active, s.reason = mode, active, reason
+	mc.SetPowerSaver(active)
+}
+
+// powerSaverWanted reports whether power saver mode should be active in
+// mode, given whether the machine is on battery, whether the platform
+// said the link is expensive and the name of the default route's
+// interface, and why, for people.
+func powerSaverWanted(mode preftype.PowerSaverMode, onBattery, linkExpensive bool, defaultIf string) (active bool, reason string) {
+	switch mode {
+	case preftype.PowerSaverOn:
+		return true, "always on"
+	case preftype.PowerSaverAuto:
+		var reasons []string
+		if onBattery {
+			reasons = append(reasons, "on battery")
+		}
+		if linkExpensive {
+			reasons = append(reasons, "metered link")
+		} else if powerstate.IsMeteredInterface(defaultIf) {
+			reasons = append(reasons, "metered link "+defaultIf)
+		}
+		if len(reasons) == 0 {
+			return false, "not on battery or a metered link"
+		}
+		return true, strings.Join(reasons, ", ")
+	}
+	return false, ""
+}
+
+// updatePowerSaverStatus adds the state of power saver mode and what
+// it saved to sb, unless the PowerSaver pref is off.
+func (b *LocalBackend) updatePowerSaverStatus(sb *ipnstate.StatusBuilder) {
+	s := &b.powerSaver
+	s.mu.Lock()
+	if s.mode == preftype.PowerSaverOff {
+		s.mu.Unlock()
+		return
+	}
+	ps := &ipnstate.PowerSaverStatus{
+		Mode:   s.mode.String(),
+		Active: s.active,
+		Reason: s.reason,
+	}
+	s.mu.Unlock()
+	if mc, err := b.magicConn(); err == nil {
+		ps.HeartbeatsSaved, ps.BytesSaved, ps.ReSTUNsSaved = mc.PowerSaverSavings()
+	}
+	sb.MutateStatus(func(st *ipnstate.Status) {
+		st.PowerSaver = ps
+	})
+}
@@ -0,0 +1,35 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnlocal
+
+import (
+	"testing"
+
+	"tailscale.com/types/preftype"
+)
+
+func TestPowerSaverWanted(t *testing.T) {
+	tests := []struct {
+		mode          preftype.PowerSaverMode
+		onBattery     bool
+		linkExpensive bool
+		defaultIf     string
+		want          bool
+		wantReason    string
+	}{
+		{mode: preftype.PowerSaverOff, onBattery: true, linkExpensive: true, want: false, wantReason: ""},
+		{mode: preftype.PowerSaverOn, defaultIf: "eth0", want: true, wantReason: "always on"},
+		{mode: preftype.PowerSaverAuto, defaultIf: "eth0", want: false, wantReason: "not on battery or a metered link"},
+		{mode: preftype.PowerSaverAuto, onBattery: true, defaultIf: "wlan0", want: true, wantReason: "on battery"},
+		{mode: preftype.PowerSaverAuto, linkExpensive: true, want: true, wantReason: "metered link"},
+		{mode: preftype.PowerSaverAuto, onBattery: true, defaultIf: "wwan0", want: true, wantReason: "on battery, metered link wwan0"},
+	}
+	for _, tt := range tests {
+		got, reason := powerSaverWanted(tt.mode, tt.onBattery, tt.linkExpensive, tt.defaultIf)
+		if got != tt.want || reason != tt.wantReason {
+			t.Errorf("powerSaverWanted(%v, %v, %v, %q) = %v, %q; want %v, %q", tt.mode, tt.onBattery, tt.linkExpensive, tt.defaultIf, got, reason, tt.want, tt.wantReason)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnlocal
+
+import (
+	"runtime"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/version"
+)
+
+// StateSnapshot returns a redacted snapshot of the node's prefs, network
+// map and health, for support to reproduce its configuration. See
+// ipn.StateSnapshot.
+func (b *LocalBackend) StateSnapshot() *ipn.StateSnapshot {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := &ipn.StateSnapshot{
+		Version:          ipn.StateSnapshotVersion,
+		Time:             time.Now(),
+		TailscaleVersion: version.Long,
+		OS:               runtime.GOOS,
+		BackendState:     b.state.String(),
+		NetMap:           ipn.SummarizeNetMap(b.netMap),
+		Health:           healthWarnings(),
+	}
+	if b.prefs != nil {
+		s.Prefs = b.prefs.Clone()
+		s.Prefs.Persist = nil
+		// Neither is needed to reproduce the configuration, and
+		// they name a private control server and a local user.
+		s.Prefs.ControlURL = ""
+		s.Prefs.OperatorUser = ""
+	}
+	return s
+}
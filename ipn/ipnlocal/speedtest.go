@@ -0,0 +1,168 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnlocal
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/net/speedtest"
+	"tailscale.com/tailcfg"
+	"tailscale.com/util/crashreport"
+)
+
+// speedTestUpgrade is the HTTP Upgrade protocol a peer's /v0/speedtest
+// handler switches to, after which the connection speaks the
+// net/speedtest protocol.
+const speedTestUpgrade = "tailscale-speedtest"
+
+// allowsSpeedTest reports whether the AllowSpeedTest pref permits
+// peers to run throughput tests against this node.
+func (b *LocalBackend) allowsSpeedTest() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.prefs != nil && b.prefs.AllowSpeedTest
+}
+
+// SpeedTestPeer runs a throughput test against the peer with Tailscale
+// IP ip, downloading and then uploading for d each. The peer serves the
+// test itself. It must have the AllowSpeedTest pref set, and either be
+// owned by the same user or grant this node the
+// tailcfg.CapabilitySpeedTestPeer capability.
+func (b *LocalBackend) SpeedTestPeer(ctx context.Context, ip netip.Addr, d time.Duration) (*apitype.PeerSpeedTestResponse, error) {
+	if d < speedtest.MinDuration || d > speedtest.MaxDuration {
+		return nil, fmt.Errorf("duration %v must be between %v and %v", d, speedtest.MinDuration, speedtest.MaxDuration)
+	}
+	nm := b.NetMap()
+	if nm == nil {
+		return nil, errors.New("no netmap")
+	}
+	peer, ok := nm.PeerByTailscaleIP(ip)
+	if !ok {
+		return nil, fmt.Errorf("no peer found with Tailscale IP %v", ip)
+	}
+	base := peerAPIBase(nm, peer)
+	if base == "" {
+		return nil, fmt.Errorf("%s has no peer API", peer.ComputedName)
+	}
+
+	ret := &apitype.PeerSpeedTestResponse{Peer: peer.ComputedName}
+	var err error
+	ret.Download, err = b.speedTestPeerDirection(ctx, base, speedtest.Download, d)
+	if err != nil {
+		return nil, fmt.Errorf("download: %w", err)
+	}
+	ret.Upload, err = b.speedTestPeerDirection(ctx, base, speedtest.Upload, d)
+	if err != nil {
+		return nil, fmt.Errorf("upload: %w", err)
+	}
+	ret.Path = b.peerPath(peer)
+	return ret, nil
+}
+
+// speedTestPeerDirection runs one direction of a throughput test
+// against the peer API at base.
+func (b *LocalBackend) speedTestPeerDirection(ctx context.Context, base string, dir speedtest.Direction, d time.Duration) (apitype.SpeedTestResult, error) {
+	res := apitype.SpeedTestResult{Retransmits: -1}
+	req, err := http.NewRequestWithContext(ctx, "POST", base+"/v0/speedtest", nil)
+	if err != nil {
+		return res, err
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", speedTestUpgrade)
+
+	conn, err := b.Dialer().PeerAPITransport().DialContext(ctx, "tcp", req.URL.Host)
+	if err != nil {
+		return res, err
+	}
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	crashreport.Go(func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	})
+
+	if err := req.Write(conn); err != nil {
+		return res, err
+	}
+	// The peer sends nothing after switching protocols until we send
+	// the test config, so the bufio.Reader can't swallow test data.
+	hres, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return res, err
+	}
+	if hres.StatusCode != http.StatusSwitchingProtocols {
+		body, _ := io.ReadAll(io.LimitReader(hres.Body, 1<<10))
+		return res, fmt.Errorf("%s: %s", hres.Status, strings.TrimSpace(string(body)))
+	}
+
+	before, haveBefore := speedtest.Retransmits(conn)
+	results, err := speedtest.RunClientConn(conn, dir, d)
+	if err != nil {
+		if ctx.Err() != nil {
+			return res, ctx.Err()
+		}
+		return res, err
+	}
+	for _, r := range results {
+		if r.Total {
+			res.Bytes = int64(r.Bytes)
+			res.Duration = r.Interval()
+		}
+	}
+	if dir == speedtest.Upload && haveBefore {
+		if after, ok := speedtest.Retransmits(conn); ok {
+			res.Retransmits = after - before
+		}
+	}
+	return res, nil
+}
+
+// peerPath describes how packets to peer currently flow: "direct" and
+// its endpoint, or "DERP" and the relay's region code. It returns the
+// empty string if unknown.
+func (b *LocalBackend) peerPath(peer *tailcfg.Node) string {
+	ps, ok := b.Status().Peer[peer.Key]
+	if !ok {
+		return ""
+	}
+	switch {
+	case ps.CurAddr != "":
+		return "direct " + ps.CurAddr
+	case ps.Relay != "":
+		return "DERP(" + ps.Relay + ")"
+	}
+	return ""
+}
+
+// serveSpeedTest serves a throughput test to the peer on conn, which
+// has switched to the speedtest protocol, and closes it. The caller
+// must have set b.speedTestRunning, which is cleared when done.
+func (b *LocalBackend) serveSpeedTest(conn net.Conn, peerName string) {
+	defer b.speedTestRunning.Store(false)
+	defer conn.Close()
+	b.logf("serving speedtest to %v", peerName)
+	before, haveBefore := speedtest.Retransmits(conn)
+	if err := speedtest.ServeConn(conn); err != nil {
+		b.logf("speedtest to %v: %v", peerName, err)
+		return
+	}
+	if after, ok := speedtest.Retransmits(conn); ok && haveBefore {
+		b.logf("speedtest to %v done; %d TCP retransmits", peerName, after-before)
+	}
+}
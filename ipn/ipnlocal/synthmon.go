@@ -0,0 +1,204 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnlocal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/ipn"
+	"tailscale.com/net/synthmon"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/logger"
+	"tailscale.com/types/netmap"
+	"tailscale.com/wgengine"
+)
+
+// updateSyntheticMonitor starts or stops the synthetic monitor as per
+// the SyntheticMonitorPeer pref, peer. The checks themselves follow
+// later changes to the pref.
+func (b *LocalBackend) updateSyntheticMonitor(peer string) {
+	b.synthMu.Lock()
+	defer b.synthMu.Unlock()
+	switch {
+	case peer != "" && b.synthMon == nil:
+		b.logf("starting synthetic monitor")
+		b.synthMon = synthmon.New(b.logf, b.syntheticChecks)
+	case peer == "" && b.synthMon != nil:
+		b.logf("stopping synthetic monitor")
+		b.synthMon.Close()
+		b.synthMon = nil
+	}
+}
+
+// LogSyntheticMonitor logs a summary of the synthetic monitor's recent
+// results, if it's enabled, for a bug report.
+func (b *LocalBackend) LogSyntheticMonitor(logf logger.Logf) {
+	b.synthMu.Lock()
+	defer b.synthMu.Unlock()
+	if b.synthMon != nil {
+		b.synthMon.LogSummary(logf)
+	}
+}
+
+// syntheticChecks returns the checks for the synthetic monitor to run:
+// connecting to the control server and, as per the SyntheticMonitorPeer
+// pref, resolving the peer's MagicDNS name and disco-pinging it. It
+// returns none while the backend isn't running.
+func (b *LocalBackend) syntheticChecks() []synthmon.Check {
+	b.mu.Lock()
+	state := b.state
+	prefs := b.prefs
+	nm := b.netMap
+	b.mu.Unlock()
+	if state != ipn.Running || prefs == nil || prefs.SyntheticMonitorPeer == "" || nm == nil {
+		return nil
+	}
+
+	controlURL := prefs.ControlURLOrDefault()
+	checks := []synthmon.Check{{
+		Name: "control",
+		Run: func(ctx context.Context) error {
+			return b.checkControlReachable(ctx, controlURL)
+		},
+	}}
+
+	peer, ip, err := syntheticPeer(nm, prefs.SyntheticMonitorPeer)
+	if err != nil {
+		failed := func(context.Context) error { return err }
+		return append(checks, synthmon.Check{Name: "disco-ping", Run: failed})
+	}
+	checks = append(checks, synthmon.Check{
+		Name: "disco-ping",
+		Run: func(ctx context.Context) error {
+			pr, err := b.Ping(ctx, ip, tailcfg.PingDisco)
+			if err != nil {
+				return err
+			}
+			if pr.Err != "" {
+				return errors.New(pr.Err)
+			}
+			return nil
+		},
+	})
+	if nm.DNS.Proxied {
+		checks = append(checks, synthmon.Check{
+			Name: "magicdns",
+			Run: func(ctx context.Context) error {
+				return b.checkMagicDNS(ctx, peer.Name, ip)
+			},
+		})
+	}
+	return checks
+}
+
+// syntheticPeer returns the peer in nm named by nameOrIP, a MagicDNS
+// name (fully qualified or not) or Tailscale IP, and its Tailscale IP.
+func syntheticPeer(nm *netmap.NetworkMap, nameOrIP string) (peer *tailcfg.Node, ip netip.Addr, err error) {
+	if ip, err := netip.ParseAddr(nameOrIP); err == nil {
+		peer, ok := nm.PeerByTailscaleIP(ip)
+		if !ok {
+			return nil, ip, fmt.Errorf("no peer with IP %v", ip)
+		}
+		return peer, ip, nil
+	}
+	name := strings.TrimSuffix(nameOrIP, ".")
+	for _, p := range nm.Peers {
+		fqdn := strings.TrimSuffix(p.Name, ".")
+		if fqdn != name && p.ComputedName != name && !strings.HasPrefix(fqdn, name+".") {
+			continue
+		}
+		for _, a := range p.Addresses {
+			if a.IsSingleIP() && (!ip.IsValid() || a.Addr().Is4()) {
+				ip = a.Addr()
+			}
+		}
+		if !ip.IsValid() {
+			return nil, ip, fmt.Errorf("peer %q has no Tailscale IP", name)
+		}
+		return p, ip, nil
+	}
+	return nil, ip, fmt.Errorf("no peer named %q", name)
+}
+
+// checkMagicDNS resolves name with the MagicDNS resolver, returning an
+// error unless one of the answers is ip.
+func (b *LocalBackend) checkMagicDNS(ctx context.Context, name string, ip netip.Addr) error {
+	re, ok := b.e.(wgengine.ResolvingEngine)
+	if !ok {
+		return errors.New("no DNS resolver")
+	}
+	r, ok := re.GetResolver()
+	if !ok {
+		return errors.New("no DNS resolver")
+	}
+	typ := "a"
+	if ip.Is6() {
+		typ = "aaaa"
+	}
+	res, err := r.Query(ctx, dnsQueryForName(name, typ), netip.AddrPort{})
+	if err != nil {
+		return err
+	}
+	var p dnsmessage.Parser
+	hdr, err := p.Start(res)
+	if err != nil {
+		return err
+	}
+	if hdr.RCode != dnsmessage.RCodeSuccess {
+		return fmt.Errorf("resolving %s: %v", name, hdr.RCode)
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return err
+	}
+	answers, err := p.AllAnswers()
+	if err != nil {
+		return err
+	}
+	for _, a := range answers {
+		var got netip.Addr
+		switch rr := a.Body.(type) {
+		case *dnsmessage.AResource:
+			got = netip.AddrFrom4(rr.A)
+		case *dnsmessage.AAAAResource:
+			got = netip.AddrFrom16(rr.AAAA)
+		}
+		if got == ip {
+			return nil
+		}
+	}
+	return fmt.Errorf("resolving %s: %v not in %d answers", name, ip, len(answers))
+}
+
+// checkControlReachable fetches the control server's public keys from
+// controlURL over a new connection.
+func (b *LocalBackend) checkControlReachable(ctx context.Context, controlURL string) error {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.DialContext = b.dialer.SystemDial
+	tr.DisableKeepAlives = true
+	defer tr.CloseIdleConnections()
+
+	keyURL := fmt.Sprintf("%v/key?v=%d", controlURL, tailcfg.CurrentCapabilityVersion)
+	req, err := http.NewRequestWithContext(ctx, "GET", keyURL, nil)
+	if err != nil {
+		return err
+	}
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+	if res.StatusCode != 200 {
+		return fmt.Errorf("%s: %v", keyURL, res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnlocal
+
+import (
+	"net/netip"
+	"testing"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+)
+
+func TestSyntheticPeer(t *testing.T) {
+	v4 := netip.MustParseAddr("100.64.0.2")
+	v6 := netip.MustParseAddr("fd7a:115c:a1e0::2")
+	nm := &netmap.NetworkMap{
+		Peers: []*tailcfg.Node{
+			{
+				ID:        1,
+				Name:      "foo.example.ts.net.",
+				Addresses: []netip.Prefix{netip.PrefixFrom(v6, 128), netip.PrefixFrom(v4, 32)},
+			},
+			{
+				ID:           2,
+				Name:         "bar.other.ts.net.",
+				ComputedName: "bar.other.ts.net",
+			},
+		},
+	}
+	tests := []struct {
+		arg     string
+		wantID  tailcfg.NodeID
+		wantIP  netip.Addr
+		wantErr bool
+	}{
+		{arg: "foo", wantID: 1, wantIP: v4},
+		{arg: "foo.example.ts.net", wantID: 1, wantIP: v4},
+		{arg: "foo.example.ts.net.", wantID: 1, wantIP: v4},
+		{arg: "100.64.0.2", wantID: 1, wantIP: v4},
+		{arg: "fd7a:115c:a1e0::2", wantID: 1, wantIP: v6},
+		{arg: "bar.other.ts.net", wantErr: true}, // no addresses
+		{arg: "baz", wantErr: true},
+		{arg: "100.64.0.9", wantErr: true},
+	}
+	for _, tt := range tests {
+		peer, ip, err := syntheticPeer(nm, tt.arg)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%q: got peer %v; want error", tt.arg, peer.ID)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.arg, err)
+			continue
+		}
+		if peer.ID != tt.wantID || ip != tt.wantIP {
+			t.Errorf("%q: got peer %v, IP %v; want %v, %v", tt.arg, peer.ID, ip, tt.wantID, tt.wantIP)
+		}
+	}
+}
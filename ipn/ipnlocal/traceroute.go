@@ -0,0 +1,125 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnlocal
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+)
+
+// MaxTracerouteHops is the largest number of hops Traceroute probes.
+const MaxTracerouteHops = 30
+
+// Traceroute traces the route to ip through the tunnel, sending probes
+// with increasing TTLs until ip replies, a router reports it
+// unreachable, or maxHops probes have been sent. The hops are annotated
+// with the tailnet nodes they belong to and which of them is the subnet
+// router or exit node forwarding to ip.
+func (b *LocalBackend) Traceroute(ctx context.Context, ip netip.Addr, maxHops int) (*ipnstate.TracerouteResult, error) {
+	if maxHops <= 0 || maxHops > MaxTracerouteHops {
+		return nil, fmt.Errorf("max hops must be between 1 and %d", MaxTracerouteHops)
+	}
+	pip, ok := b.e.PeerForIP(ip)
+	if !ok {
+		return nil, fmt.Errorf("no tailnet route to %v", ip)
+	}
+	if pip.IsSelf {
+		return nil, fmt.Errorf("%v is a local Tailscale IP", ip)
+	}
+	node := pip.Node
+	res := &ipnstate.TracerouteResult{
+		IP:       ip.String(),
+		NodeName: node.ComputedName,
+	}
+	var nodeIP netip.Addr
+	for _, a := range node.Addresses {
+		if a.IsSingleIP() && a.Addr().BitLen() == ip.BitLen() {
+			nodeIP = a.Addr()
+			break
+		}
+	}
+	if nodeIP.IsValid() {
+		res.NodeIP = nodeIP.String()
+	}
+	if nodeIP != ip {
+		res.Route = pip.Route.String()
+	}
+
+	b.mu.Lock()
+	names := tailnetIPNames(b.netMap)
+	b.mu.Unlock()
+
+	forwarderTTL := 0 // TTL of the hop at which the forwarding node replied
+	for ttl := 1; ttl <= maxHops; ttl++ {
+		ch := make(chan *ipnstate.TracerouteHop, 1)
+		b.e.TraceHop(ip, uint8(ttl), func(h *ipnstate.TracerouteHop) {
+			ch <- h
+		})
+		var h *ipnstate.TracerouteHop
+		select {
+		case h = <-ch:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		res.Hops = append(res.Hops, h)
+
+		hopIP, _ := netip.ParseAddr(h.IP)
+		switch {
+		case !hopIP.IsValid():
+			if ttl == 1 && res.Route != "" {
+				h.Note = fmt.Sprintf("no reply from %s; routers forwarding in userspace don't report hops", node.ComputedName)
+			}
+		case hopIP == nodeIP && res.Route != "":
+			forwarderTTL = ttl
+			h.NodeName = node.ComputedName
+			if pip.Route.Bits() == 0 {
+				h.Note = "exit node"
+			} else {
+				h.Note = "subnet router for " + res.Route
+			}
+		default:
+			h.NodeName = names[hopIP]
+			if forwarderTTL != 0 && !h.Reached {
+				h.Note = "beyond " + node.ComputedName
+			}
+		}
+		if h.Reached {
+			res.Reached = true
+			break
+		}
+		if h.Unreachable {
+			break
+		}
+	}
+	return res, nil
+}
+
+// tailnetIPNames returns the names of the nodes in nm by their
+// Tailscale IPs.
+func tailnetIPNames(nm *netmap.NetworkMap) map[netip.Addr]string {
+	ret := map[netip.Addr]string{}
+	if nm == nil {
+		return ret
+	}
+	add := func(n *tailcfg.Node) {
+		for _, a := range n.Addresses {
+			if a.IsSingleIP() {
+				ret[a.Addr()] = n.ComputedName
+			}
+		}
+	}
+	if nm.SelfNode != nil {
+		add(nm.SelfNode)
+	}
+	for _, p := range nm.Peers {
+		add(p)
+	}
+	return ret
+}
@@ -0,0 +1,265 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnlocal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kortschak/wol"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/envknob"
+	"tailscale.com/net/interfaces"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+)
+
+// maxWoLMACs is the maximum number of MAC addresses put in
+// Hostinfo.WoLMACs.
+const maxWoLMACs = 10
+
+// getWoLMACs returns the MAC addresses that other nodes can send
+// Wake-on-LAN packets to in order to wake this machine, for
+// Hostinfo.WoLMACs.
+//
+// By default those are the MACs of the usable Ethernet-like
+// interfaces. The TS_WOL_MACS environment variable overrides that with
+// a comma-separated list, or "none" to report none.
+func getWoLMACs() []string {
+	switch runtime.GOOS {
+	case "ios", "android", "js":
+		return nil
+	}
+	if v := envknob.String("TS_WOL_MACS"); v != "" {
+		if v == "none" {
+			return nil
+		}
+		var ret []string
+		for _, s := range strings.Split(v, ",") {
+			mac, err := net.ParseMAC(strings.TrimSpace(s))
+			if err != nil || len(mac) != 6 {
+				continue
+			}
+			ret = append(ret, mac.String())
+		}
+		return ret
+	}
+	st, err := interfaces.GetState()
+	if err != nil {
+		return nil
+	}
+	var ret []string
+	for name, iface := range st.Interface {
+		if st.Class(name) != interfaces.ClassUsable || len(iface.HardwareAddr) != 6 {
+			continue
+		}
+		ret = append(ret, iface.HardwareAddr.String())
+	}
+	sort.Strings(ret)
+	if len(ret) > maxWoLMACs {
+		ret = ret[:maxWoLMACs]
+	}
+	return ret
+}
+
+// sendWoL broadcasts a Wake-on-LAN magic packet for mac from each
+// local interface with an IPv4 address. It returns the names of the
+// interfaces it was sent from and any errors.
+func sendWoL(mac net.HardwareAddr) (sentFrom, errs []string) {
+	var password []byte // TODO(bradfitz): support?
+	st, err := interfaces.GetState()
+	if err != nil {
+		return nil, []string{"failed to get interfaces state"}
+	}
+	for ifName, ips := range st.InterfaceIPs {
+		for _, ip := range ips {
+			if ip.Addr().IsLoopback() || ip.Addr().Is6() {
+				continue
+			}
+			local := &net.UDPAddr{
+				IP:   ip.Addr().AsSlice(),
+				Port: 0,
+			}
+			remote := &net.UDPAddr{
+				IP:   net.IPv4bcast,
+				Port: 0,
+			}
+			if err := wol.Wake(mac, password, local, remote); err != nil {
+				errs = append(errs, err.Error())
+			} else {
+				sentFrom = append(sentFrom, ifName)
+			}
+			break // one per interface is enough
+		}
+	}
+	sort.Strings(sentFrom)
+	return sentFrom, errs
+}
+
+// WakeOnLAN wakes the peer with Tailscale IP ip by having a node on its
+// LAN send Wake-on-LAN packets to the MAC addresses it last reported.
+//
+// If via is valid, it's the Tailscale IP of the node to relay through.
+// Otherwise, this node sends the packets itself if it appears to be on
+// the same LAN as the peer, or else it tries each online peer that does.
+// Relays must be owned by the same user or grant the
+// tailcfg.CapabilityWakeOnLAN capability.
+func (b *LocalBackend) WakeOnLAN(ctx context.Context, ip, via netip.Addr) (*apitype.WakeOnLANResponse, error) {
+	nm := b.NetMap()
+	if nm == nil {
+		return nil, errors.New("no netmap")
+	}
+	target, ok := nm.PeerByTailscaleIP(ip)
+	if !ok {
+		return nil, fmt.Errorf("no peer found with Tailscale IP %v", ip)
+	}
+	if !target.Hostinfo.Valid() || target.Hostinfo.WoLMACs().Len() == 0 {
+		return nil, fmt.Errorf("%s has not reported any Wake-on-LAN MAC addresses", target.ComputedName)
+	}
+	macs := target.Hostinfo.WoLMACs().AsSlice()
+	res := &apitype.WakeOnLANResponse{MACs: macs}
+
+	var relays []*tailcfg.Node
+	if via.IsValid() {
+		relay, ok := nm.PeerByTailscaleIP(via)
+		if !ok {
+			return nil, fmt.Errorf("no peer found with Tailscale IP %v", via)
+		}
+		relays = []*tailcfg.Node{relay}
+	} else {
+		if nm.SelfNode != nil && sameLAN(nm.SelfNode.Endpoints, target.Endpoints) {
+			for _, s := range macs {
+				mac, err := net.ParseMAC(s)
+				if err != nil {
+					continue
+				}
+				sentFrom, _ := sendWoL(mac)
+				res.SentFrom = append(res.SentFrom, sentFrom...)
+			}
+			if len(res.SentFrom) > 0 {
+				return res, nil
+			}
+		}
+		relays = wolRelayCandidates(nm, target)
+		if len(relays) == 0 {
+			return nil, fmt.Errorf("no online peer appears to be on the same LAN as %s; use --via to pick one", target.ComputedName)
+		}
+	}
+
+	var errs []string
+	for _, relay := range relays {
+		sentFrom, err := b.wakeOnLANVia(ctx, nm, relay, macs)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", relay.ComputedName, err))
+			continue
+		}
+		res.Relay = relay.ComputedName
+		res.SentFrom = sentFrom
+		return res, nil
+	}
+	return nil, fmt.Errorf("no relay could send Wake-on-LAN packets: %s", strings.Join(errs, "; "))
+}
+
+// wakeOnLANVia asks relay's peerapi to send Wake-on-LAN packets to
+// each of macs, returning the relay's interfaces they were sent from.
+func (b *LocalBackend) wakeOnLANVia(ctx context.Context, nm *netmap.NetworkMap, relay *tailcfg.Node, macs []string) (sentFrom []string, err error) {
+	base := peerAPIBase(nm, relay)
+	if base == "" {
+		return nil, errors.New("no peer API")
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	seen := map[string]bool{}
+	for _, mac := range macs {
+		req, err := http.NewRequestWithContext(ctx, "POST", base+"/v0/wol?mac="+url.QueryEscape(mac), nil)
+		if err != nil {
+			return nil, err
+		}
+		res, err := b.Dialer().PeerAPIHTTPClient().Do(req)
+		if err != nil {
+			return nil, err
+		}
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s: %s", res.Status, strings.TrimSpace(string(body)))
+		}
+		var wres struct {
+			SentTo []string
+			Errors []string
+		}
+		if err := json.Unmarshal(body, &wres); err != nil {
+			return nil, err
+		}
+		if len(wres.SentTo) == 0 {
+			return nil, fmt.Errorf("relay sent no packets: %s", strings.Join(wres.Errors, "; "))
+		}
+		for _, ifName := range wres.SentTo {
+			if !seen[ifName] {
+				seen[ifName] = true
+				sentFrom = append(sentFrom, ifName)
+			}
+		}
+	}
+	return sentFrom, nil
+}
+
+// wolRelayCandidates returns the online peers in nm, other than target,
+// that appear to be on the same LAN as target and run a peerapi.
+func wolRelayCandidates(nm *netmap.NetworkMap, target *tailcfg.Node) []*tailcfg.Node {
+	var ret []*tailcfg.Node
+	for _, p := range nm.Peers {
+		if p.ID == target.ID || p.Online == nil || !*p.Online {
+			continue
+		}
+		if peerAPIBase(nm, p) == "" || !sameLAN(p.Endpoints, target.Endpoints) {
+			continue
+		}
+		ret = append(ret, p)
+	}
+	return ret
+}
+
+// sameLAN reports whether two nodes with endpoints a and b (as found in
+// tailcfg.Node.Endpoints) appear to be on the same LAN: either they have
+// the same public IP address, and so are behind the same NAT, or they
+// have private IPv4 addresses in the same /24.
+func sameLAN(a, b []string) bool {
+	for _, sa := range a {
+		ea, err := netip.ParseAddrPort(sa)
+		if err != nil {
+			continue
+		}
+		for _, sb := range b {
+			eb, err := netip.ParseAddrPort(sb)
+			if err != nil {
+				continue
+			}
+			ia, ib := ea.Addr(), eb.Addr()
+			switch {
+			case ia.Is4() && ia.IsPrivate():
+				if ib.Is4() && ib.IsPrivate() && netip.PrefixFrom(ia, 24).Masked().Contains(ib) {
+					return true
+				}
+			case ia.IsGlobalUnicast() && !ia.IsPrivate() && ia.Is4():
+				if ia == ib {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnlocal
+
+import "testing"
+
+func TestSameLAN(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []string
+		want bool
+	}{
+		{
+			name: "same-nat",
+			a:    []string{"203.0.113.5:41641", "192.168.1.10:41641"},
+			b:    []string{"203.0.113.5:12345", "10.0.0.3:41641"},
+			want: true,
+		},
+		{
+			name: "same-private-24",
+			a:    []string{"192.168.1.10:41641"},
+			b:    []string{"192.168.1.77:41641"},
+			want: true,
+		},
+		{
+			name: "different-private-24",
+			a:    []string{"192.168.1.10:41641"},
+			b:    []string{"192.168.2.10:41641"},
+			want: false,
+		},
+		{
+			name: "different-public",
+			a:    []string{"203.0.113.5:41641"},
+			b:    []string{"198.51.100.9:41641"},
+			want: false,
+		},
+		{
+			name: "same-public-v6-is-not-lan",
+			a:    []string{"[2001:db8::1]:41641"},
+			b:    []string{"[2001:db8::1]:41641"},
+			want: false,
+		},
+		{
+			name: "bogus",
+			a:    []string{"bogus"},
+			b:    []string{"bogus"},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sameLAN(tt.a, tt.b); got != tt.want {
+				t.Errorf("sameLAN = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
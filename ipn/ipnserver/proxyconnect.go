@@ -17,6 +17,7 @@
 
 	"tailscale.com/logpolicy"
 	"tailscale.com/types/logger"
+	"tailscale.com/util/crashreport"
 )
 
 // handleProxyConnectConn handles a CONNECT request to
@@ -65,13 +66,13 @@ func (s *Server) handleProxyConnectConn(ctx context.Context, br *bufio.Reader, c
 	io.WriteString(c, "HTTP/1.1 200 OK\r\n\r\n")
 
 	errc := make(chan error, 2)
-	go func() {
+	crashreport.Go(func() {
 		_, err := io.Copy(c, back)
 		errc <- err
-	}()
-	go func() {
+	})
+	crashreport.Go(func() {
 		_, err := io.Copy(back, br)
 		errc <- err
-	}()
+	})
 	<-errc
 }
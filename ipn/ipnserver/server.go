@@ -45,6 +45,7 @@
 	"tailscale.com/smallzstd"
 	"tailscale.com/tka"
 	"tailscale.com/types/logger"
+	"tailscale.com/util/crashreport"
 	"tailscale.com/util/groupmember"
 	"tailscale.com/util/pidowner"
 	"tailscale.com/util/systemd"
@@ -214,10 +215,10 @@ func lookupUserFromID(logf logger.Logf, uid string) (*user.User, error) {
 func (s *Server) blockWhileInUse(conn io.Reader, ci connIdentity) {
 	s.logf("blocking client while server in use; connIdentity=%v", ci)
 	connDone := make(chan struct{})
-	go func() {
+	crashreport.Go(func() {
 		io.Copy(ioutil.Discard, conn)
 		close(connDone)
-	}()
+	})
 	ch := make(chan struct{}, 1)
 	s.registerDisconnectSub(ch, true)
 	defer s.registerDisconnectSub(ch, false)
@@ -263,6 +264,8 @@ func bufferIsConnect(br *bufio.Reader) bool {
 }
 
 func (s *Server) serveConn(ctx context.Context, c net.Conn, logf logger.Logf) {
+	defer crashreport.Handle()
+
 	// First see if it's an HTTP request.
 	br := bufio.NewReader(c)
 	c.SetReadDeadline(time.Now().Add(time.Second))
@@ -493,9 +496,38 @@ func isAllDigit(s string) bool {
 // connection. It's intended to give your non-root webserver access
 // (www-data, caddy, nginx, etc) to certs.
 func (s *Server) connCanFetchCerts(ci connIdentity) bool {
+	return connUIDMatches(ci, "TS_PERMIT_CERT_UID")
+}
+
+// connCanDiag reports whether ci is allowed to use the sensitive
+// read-only diagnostic LocalAPI handlers (logs, metrics, packet path
+// stats and the like) when it wouldn't otherwise be able to.
+//
+// Those handlers normally require write access, which on Unix means
+// root or the operator user. Setting TS_PERMIT_DIAG_UID to the userid
+// of the peer connection lets the operator consent to another user,
+// such as the one running a GUI, collecting them.
+func (s *Server) connCanDiag(ci connIdentity) bool {
+	return connUIDMatches(ci, "TS_PERMIT_DIAG_UID")
+}
+
+// connCanProfile reports whether ci is allowed to use the profiling
+// LocalAPI handlers (CPU and memory profiles and goroutine dumps), and
+// the read-only diagnostic ones, when it wouldn't otherwise be able to.
+//
+// Profiles can expose process memory, so they're granted separately
+// from connCanDiag, by setting TS_PERMIT_PROFILE_UID.
+func (s *Server) connCanProfile(ci connIdentity) bool {
+	return connUIDMatches(ci, "TS_PERMIT_PROFILE_UID")
+}
+
+// connUIDMatches reports whether ci is a Unix socket connection from
+// the user named by the environment variable envVar, as a numeric
+// userid or username.
+func connUIDMatches(ci connIdentity, envVar string) bool {
 	if ci.IsUnixSock && ci.Creds != nil {
 		connUID, ok := ci.Creds.UserID()
-		if ok && connUID == userIDFromString(envknob.String("TS_PERMIT_CERT_UID")) {
+		if ok && connUID == userIDFromString(envknob.String(envVar)) {
 			return true
 		}
 	}
@@ -672,7 +704,7 @@ func Run(ctx context.Context, logf logger.Logf, ln net.Listener, store ipn.State
 
 	// When the context is closed or when we return, whichever is first, close our listener
 	// and all open connections.
-	go func() {
+	crashreport.Go(func() {
 		select {
 		case <-ctx.Done():
 		case <-runDone:
@@ -683,7 +715,7 @@ func Run(ctx context.Context, logf logger.Logf, ln net.Listener, store ipn.State
 		}
 		serverMu.Unlock()
 		ln.Close()
-	}()
+	})
 	logf("Listening on %v", ln.Addr())
 
 	var serverModeUser *user.User
@@ -728,12 +760,12 @@ func Run(ctx context.Context, logf logger.Logf, ln net.Listener, store ipn.State
 			}
 			logf("ipnserver%d: getEngine failed again: %v", i, err)
 			errMsg := err.Error()
-			go func() {
+			crashreport.Go(func() {
 				defer c.Close()
 				bs := ipn.NewBackendServer(logf, nil, jsonNotifier(c, logf))
 				bs.SendErrorMessage(errMsg)
 				time.Sleep(time.Second)
-			}()
+			})
 		}
 		if err := ctx.Err(); err != nil {
 			return err
@@ -851,14 +883,14 @@ func (s *Server) Run(ctx context.Context, ln net.Listener) error {
 
 	// When the context is closed or when we return, whichever is first, close our listener
 	// and all open connections.
-	go func() {
+	crashreport.Go(func() {
 		select {
 		case <-ctx.Done():
 		case <-runDone:
 		}
 		s.stopAll()
 		ln.Close()
-	}()
+	})
 
 	if s.autostartStateKey != "" {
 		s.bs.GotCommand(ctx, &ipn.Command{
@@ -908,7 +940,7 @@ func BabysitProc(ctx context.Context, args []string, logf logger.Logf) {
 	}
 
 	done := make(chan struct{})
-	go func() {
+	crashreport.Go(func() {
 		interrupt := make(chan os.Signal, 1)
 		signal.Notify(interrupt, syscall.SIGINT, syscall.SIGTERM)
 		var sig os.Signal
@@ -925,7 +957,7 @@ func BabysitProc(ctx context.Context, args []string, logf logger.Logf) {
 		proc.mu.Lock()
 		proc.p.Signal(sig)
 		proc.mu.Unlock()
-	}()
+	})
 
 	bo := backoff.NewBackoff("BabysitProc", logf, 30*time.Second)
 
@@ -1067,6 +1099,8 @@ func (s *Server) localhostHandler(ci connIdentity) http.Handler {
 	lah := localapi.NewHandler(s.b, s.logf, s.backendLogID)
 	lah.PermitRead, lah.PermitWrite = s.localAPIPermissions(ci)
 	lah.PermitCert = s.connCanFetchCerts(ci)
+	lah.PermitDiag = s.connCanDiag(ci)
+	lah.PermitProfile = s.connCanProfile(ci)
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if strings.HasPrefix(r.URL.Path, "/localapi/") {
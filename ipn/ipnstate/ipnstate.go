@@ -19,6 +19,7 @@
 	"time"
 
 	"tailscale.com/tailcfg"
+	"tailscale.com/types/filterstats"
 	"tailscale.com/types/key"
 	"tailscale.com/types/views"
 	"tailscale.com/util/dnsname"
@@ -42,11 +43,64 @@ type Status struct {
 	// If nil, an exit node is not in use.
 	ExitNodeStatus *ExitNodeStatus `json:"ExitNodeStatus,omitempty"`
 
+	// ExitNodeExclusions are the ExitNodeExcludeRoutes pref's prefixes,
+	// while an exit node is in use, with those that aren't applied
+	// marked Dropped.
+	ExitNodeExclusions []ExcludedRoute `json:",omitempty"`
+
+	// RouteMetric is the effective metric of the routes Tailscale has
+	// installed (the route priority on Linux, the interface metric on
+	// Windows), or nil if it's unknown or not applicable.
+	RouteMetric *int `json:",omitempty"`
+
+	// ForceDERP is whether the ForceDERP pref is on, so all peer
+	// traffic is relayed over DERP instead of direct UDP.
+	ForceDERP bool `json:",omitempty"`
+
+	// DERPRegions are the DERP regions this node is connected to
+	// (over TCP port 443), sorted by region ID.
+	DERPRegions []DERPRegionStatus `json:",omitempty"`
+
+	// DERPFallbacks are the most recent changes of peers between
+	// direct UDP and DERP, oldest first.
+	DERPFallbacks []DERPFallback `json:",omitempty"`
+
+	// Uplinks are the network interfaces named in the UplinkPolicy
+	// pref, in policy order.
+	Uplinks []UplinkStatus `json:",omitempty"`
+
+	// DampenedRoutes are the subnet routes held out of the system
+	// routing table because their subnet router keeps withdrawing and
+	// re-advertising them.
+	DampenedRoutes []DampenedRoute `json:",omitempty"`
+
+	// PowerSaver is the state of power saver mode, or nil if the
+	// PowerSaver pref is off.
+	PowerSaver *PowerSaverStatus `json:",omitempty"`
+
+	// UDPPort is the local port of the IPv4 UDP socket used for direct
+	// connections and STUN, if known.
+	UDPPort uint16 `json:",omitempty"`
+
+	// UDPPortRange is the UDPPortRange pref that UDPPort was chosen
+	// from, if any.
+	UDPPortRange string `json:",omitempty"`
+
+	// UDPPortSuggestion, if non-empty, suggests a UDPPortRange pref
+	// to use because UDP appears blocked from UDPPort but not from
+	// other local ports.
+	UDPPortSuggestion string `json:",omitempty"`
+
 	// Health contains health check problems.
 	// Empty means everything is good. (or at least that no known
 	// problems are detected)
 	Health []string
 
+	// Offline, if non-empty, is why the node has no usable network
+	// uplink. Updates to the coordination server are queued until
+	// it's back.
+	Offline string `json:",omitempty"`
+
 	// This field is the legacy name of CurrentTailnet.MagicDNSSuffix.
 	//
 	// Deprecated: use CurrentTailnet.MagicDNSSuffix instead.
@@ -155,12 +209,19 @@ type PeerStatus struct {
 	CurAddr string // one of Addrs, or unique if roaming
 	Relay   string // DERP region
 
+	// Uplink is the local network interface that CurAddr is reached
+	// over. It's only set when an uplink policy is in use.
+	Uplink string `json:",omitempty"`
+
 	RxBytes        int64
 	TxBytes        int64
 	Created        time.Time // time registered with tailcontrol
 	LastWrite      time.Time // time last packet sent
 	LastSeen       time.Time // last seen to tailcontrol; only present if offline
 	LastHandshake  time.Time // with local wireguard
+	LastDiscoPing  time.Time // last disco ping sent to the node
+	LastDiscoPong  time.Time // last disco pong received from the node
+	CurAddrSince   time.Time // when CurAddr became the path in use
 	Online         bool      // whether node is connected to the control plane
 	KeepAlive      bool
 	ExitNode       bool // true if this is the currently selected exit node.
@@ -196,6 +257,134 @@ type PeerStatus struct {
 	// InEngine means that this peer is tracked by the wireguard engine.
 	// In theory, all of InNetworkMap and InMagicSock and InEngine should all be true.
 	InEngine bool
+
+	// PathPin, if non-nil, is the local restriction on which paths
+	// are used to reach this peer. See PathPin.
+	PathPin *PathPin `json:",omitempty"`
+
+	// ImportedEndpoints are the endpoints of this peer that were
+	// imported by hand for debugging, with "tailscale debug endpoints
+	// import", rather than learned from the network map or the peer.
+	ImportedEndpoints []netip.AddrPort `json:",omitempty"`
+
+	// ConnHistory, if non-nil, is how connections to this peer have
+	// fared, including before tailscaled last restarted.
+	ConnHistory *PeerConnHistory `json:",omitempty"`
+
+	// DisconnectReason, if non-empty, is why there's no current
+	// WireGuard session with this peer, although this node recently
+	// tried to reach it. It's empty for peers with a session, and for
+	// idle ones.
+	DisconnectReason DisconnectReason `json:",omitempty"`
+}
+
+// DisconnectReason classifies why there's no current WireGuard session
+// with a peer.
+type DisconnectReason string
+
+const (
+	// DisconnectPeerOffline is when the control server reports the
+	// peer as not connected to it.
+	DisconnectPeerOffline DisconnectReason = "peer-offline"
+
+	// DisconnectEndpointExpired is when the direct path to the peer
+	// expired and the disco pings sent on it since got no pong.
+	DisconnectEndpointExpired DisconnectReason = "endpoint-expired"
+
+	// DisconnectNeverHandshake is when no WireGuard handshake with the
+	// peer ever completed.
+	DisconnectNeverHandshake DisconnectReason = "never-handshake"
+
+	// DisconnectHandshakeStale is when the last WireGuard handshake
+	// with the peer is too old for its session to still be used.
+	DisconnectHandshakeStale DisconnectReason = "handshake-stale"
+)
+
+// PeerConnHistory is the persisted history of the paths used to reach
+// a peer while packets were being sent to it. It distinguishes peers
+// never reached directly from those whose direct path broke recently.
+type PeerConnHistory struct {
+	// Since is when the history of the peer began.
+	Since time.Time
+
+	// LastActive is when packets were last being sent to the peer.
+	LastActive time.Time
+
+	// LastDirect is when the peer was last reached over a direct
+	// path, or the zero time if it never was since Since.
+	LastDirect time.Time `json:",omitempty"`
+
+	// LastDERPOnlyStart is when the most recent period during which
+	// the peer was only reached via DERP began, or the zero time if
+	// there was none.
+	LastDERPOnlyStart time.Time `json:",omitempty"`
+
+	// LastDERPOnlyEnd is when that period ended, by reaching the peer
+	// directly, or the zero time if it's ongoing.
+	LastDERPOnlyEnd time.Time `json:",omitempty"`
+
+	// TypicalRTT is a moving average of the round-trip time over
+	// direct paths, or zero if unknown.
+	TypicalRTT time.Duration `json:",omitempty"`
+}
+
+// PathPin restricts which paths are used to reach a peer, overriding
+// the usual best-path selection. It's a local debugging aid for
+// networks where the path that looks best is actually broken (for
+// example, a middlebox that passes disco pings but eats WireGuard
+// traffic). It is not persisted across restarts.
+//
+// The zero value imposes no restriction.
+type PathPin struct {
+	// DERPOnly, if true, forces all traffic to the peer over DERP.
+	DERPOnly bool `json:",omitempty"`
+
+	// Endpoint, if valid, is the only direct UDP endpoint that may be
+	// used to reach the peer. It need not be one of the peer's
+	// advertised endpoints. DERP is still used until the endpoint
+	// answers a disco ping.
+	Endpoint netip.AddrPort `json:",omitempty"`
+
+	// ForbidInterfaces are names of local network interfaces that
+	// must not be used to reach the peer. Direct endpoints within any
+	// of these interfaces' subnets are never used.
+	ForbidInterfaces []string `json:",omitempty"`
+}
+
+// IsZero reports whether p imposes no restriction.
+func (p PathPin) IsZero() bool {
+	return !p.DERPOnly && !p.Endpoint.IsValid() && len(p.ForbidInterfaces) == 0
+}
+
+// String returns a short human-readable summary of p.
+func (p PathPin) String() string {
+	var parts []string
+	if p.DERPOnly {
+		parts = append(parts, "derp-only")
+	}
+	if p.Endpoint.IsValid() {
+		parts = append(parts, "endpoint="+p.Endpoint.String())
+	}
+	if len(p.ForbidInterfaces) > 0 {
+		parts = append(parts, "forbid="+strings.Join(p.ForbidInterfaces, ","))
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, " ")
+}
+
+// NodeEndpoints are the UDP endpoints a node advertises, as exported
+// by "tailscale debug endpoints export" to import on a peer by hand.
+type NodeEndpoints struct {
+	// NodeKey is the node's public key.
+	NodeKey key.NodePublic
+
+	// TailscaleIPs are the node's Tailscale IP addresses.
+	TailscaleIPs []netip.Addr
+
+	// Endpoints are the node's endpoints and how it found each.
+	Endpoints []tailcfg.Endpoint
 }
 
 type StatusBuilder struct {
@@ -327,6 +516,9 @@ func (sb *StatusBuilder) AddPeer(peer key.NodePublic, st *PeerStatus) {
 	if v := st.CurAddr; v != "" {
 		e.CurAddr = v
 	}
+	if v := st.Uplink; v != "" {
+		e.Uplink = v
+	}
 	if v := st.RxBytes; v != 0 {
 		e.RxBytes = v
 	}
@@ -345,6 +537,15 @@ func (sb *StatusBuilder) AddPeer(peer key.NodePublic, st *PeerStatus) {
 	if v := st.LastWrite; !v.IsZero() {
 		e.LastWrite = v
 	}
+	if v := st.LastDiscoPing; !v.IsZero() {
+		e.LastDiscoPing = v
+	}
+	if v := st.LastDiscoPong; !v.IsZero() {
+		e.LastDiscoPong = v
+	}
+	if v := st.CurAddrSince; !v.IsZero() {
+		e.CurAddrSince = v
+	}
 	if st.Online {
 		e.Online = true
 	}
@@ -372,6 +573,18 @@ func (sb *StatusBuilder) AddPeer(peer key.NodePublic, st *PeerStatus) {
 	if st.Active {
 		e.Active = true
 	}
+	if v := st.PathPin; v != nil {
+		e.PathPin = v
+	}
+	if v := st.ImportedEndpoints; v != nil {
+		e.ImportedEndpoints = v
+	}
+	if v := st.ConnHistory; v != nil {
+		e.ConnHistory = v
+	}
+	if v := st.DisconnectReason; v != "" {
+		e.DisconnectReason = v
+	}
 }
 
 type StatusUpdater interface {
@@ -568,6 +781,377 @@ func (pr *PingResult) ToPingResponse(pingType tailcfg.PingType) *tailcfg.PingRes
 	}
 }
 
+// TracerouteResult is the result of an in-tunnel traceroute to IP.
+type TracerouteResult struct {
+	IP string // traceroute destination
+
+	// NodeIP and NodeName identify the peer that IP is routed to: IP's
+	// own node, or the subnet router or exit node that forwards to it.
+	NodeIP   string
+	NodeName string
+
+	// Route is the node's route that IP matches, if IP isn't one of
+	// the node's own addresses: a subnet route, or "0.0.0.0/0" or
+	// "::/0" for an exit node.
+	Route string `json:",omitempty"`
+
+	Hops []*TracerouteHop
+
+	// Reached is whether IP replied to one of the probes.
+	Reached bool
+}
+
+// TracerouteHop is the reply to a traceroute probe with a given TTL.
+type TracerouteHop struct {
+	TTL int
+
+	// IP is the address that replied to the probe: IP itself, or a
+	// router on the way to it. It's empty if none replied.
+	IP             string `json:",omitempty"`
+	LatencySeconds float64
+
+	// Reached is whether the reply was from the traceroute's
+	// destination, ending the traceroute.
+	Reached bool `json:",omitempty"`
+
+	// Unreachable is whether the reply was an ICMP Destination
+	// Unreachable error rather than Time Exceeded, ending the
+	// traceroute.
+	Unreachable bool `json:",omitempty"`
+
+	// NodeName is the name of the tailnet node that IP belongs to, if
+	// any.
+	NodeName string `json:",omitempty"`
+
+	// Note describes the hop's role in the tailnet, such as that it's
+	// the subnet router or exit node forwarding to the destination.
+	Note string `json:",omitempty"`
+
+	Err string `json:",omitempty"`
+}
+
+// NetInterfaces is tailscaled's view of the machine's network
+// interfaces, as used for link change detection and endpoint
+// discovery. It's returned by the "tailscale debug interfaces"
+// subcommand.
+type NetInterfaces struct {
+	DefaultRouteInterface string
+	HaveV4                bool   // a usable IPv4 address is on an up, non-Tailscale interface
+	HaveV6                bool   // likewise, for IPv6
+	IsExpensive           bool   // e.g. LTE rather than Wi-Fi
+	HTTPProxy             string `json:",omitempty"`
+	PAC                   string `json:",omitempty"` // proxy autoconfig URL
+
+	Interfaces []NetInterface
+
+	// RecentChanges are the most recent link changes tailscaled
+	// saw, oldest first.
+	RecentChanges []NetInterfacesChange `json:",omitempty"`
+}
+
+// NetInterface is a network interface in NetInterfaces.
+type NetInterface struct {
+	Name         string
+	Index        int
+	MTU          int
+	Flags        string
+	HardwareAddr string `json:",omitempty"`
+	Desc         string `json:",omitempty"` // extra description (Windows only)
+	Addrs        []netip.Prefix
+
+	// Class is how tailscaled classifies the interface, such as
+	// "usable" or "tailscale". See interfaces.Class.
+	Class string
+}
+
+// NetInterfacesChange is a link change in NetInterfaces.
+type NetInterfacesChange struct {
+	Time  time.Time
+	Major bool   // whether it was a major change, triggering a rebind
+	State string // summary of the interface state after the change
+}
+
+// DERPRegionStatus is the state of a DERP region in Status.
+type DERPRegionStatus struct {
+	RegionID   int
+	RegionCode string
+	Home       bool // whether it's this node's home region
+
+	// RelayedPeers is the number of active peers whose traffic is
+	// currently relayed through the region rather than sent over
+	// direct UDP.
+	RelayedPeers int `json:",omitempty"`
+}
+
+// DERPFallback is a change of a peer's path between direct UDP and
+// DERP.
+type DERPFallback struct {
+	Time   time.Time
+	Peer   key.NodePublic
+	Region string // DERP region code of the peer; empty if none
+	Direct bool   // whether the peer is now reached over direct UDP
+	Addr   string `json:",omitempty"` // the direct UDP address, if Direct
+}
+
+// DampenedRoute is a subnet route held down by route flap damping.
+type DampenedRoute struct {
+	Route      netip.Prefix
+	Reinstalls int       // times reinstalled within the damping window
+	Until      time.Time // when it's reinstalled, unless it flaps again
+}
+
+// RouterState is the OS routing configuration that tailscaled last
+// applied, and what of it failed, as returned by the LocalAPI's
+// debug-router-state handler.
+type RouterState struct {
+	// Routes are the routes into the Tailscale interface, as last
+	// configured, before any route flap damping.
+	Routes []netip.Prefix
+	// LocalRoutes are the routes kept out of the Tailscale interface.
+	LocalRoutes []netip.Prefix `json:",omitempty"`
+	// LocalAddrs are the addresses of the Tailscale interface.
+	LocalAddrs []netip.Prefix
+	// RouteMetric is the metric of the installed routes, if known.
+	RouteMetric *int `json:",omitempty"`
+	// DampenedRoutes are the routes held out by route flap damping.
+	DampenedRoutes []DampenedRoute `json:",omitempty"`
+	// ErrorsTracked is whether the router records which routes it
+	// failed to add or remove. Only the Linux router does.
+	ErrorsTracked bool
+	// Errors are the routes and addresses the router failed to add or
+	// remove the last time it was configured.
+	Errors []RouteError `json:",omitempty"`
+}
+
+// RouteError is a route or address that the router failed to add or
+// remove.
+type RouteError struct {
+	Kind  string // "route", "localRoute" or "addr"
+	Op    string // "add" or "del"
+	Route netip.Prefix
+	Err   string
+}
+
+// FilterStats is how many packets the packet filter accepted by each of
+// its rules and dropped for each reason, as returned by the LocalAPI's
+// debug-filter handler.
+type FilterStats = filterstats.Stats
+
+// FilterRuleStats is how many packets a packet filter rule accepted.
+type FilterRuleStats = filterstats.RuleStats
+
+// FilterDropStats is how many packets the packet filter dropped in one
+// direction for one reason.
+type FilterDropStats = filterstats.DropStats
+
+// DERPSelection is how the home DERP region was selected.
+type DERPSelection struct {
+	// Home is the ID of the home DERP region, or 0 if none.
+	Home int
+
+	// Reason is why Home was selected, such as "pinned" or "lowest
+	// latency".
+	Reason string
+
+	// Pinned is the DERPHomeRegion pref, if set.
+	Pinned int `json:",omitempty"`
+
+	// Avoided is the DERPAvoidRegions pref.
+	Avoided []int `json:",omitempty"`
+
+	// Regions are the DERP map's regions as measured by the last
+	// netcheck, lowest latency first, then those that didn't reply.
+	Regions []DERPRegionLatency
+
+	// AsOf is when the netcheck that Regions are from ran.
+	AsOf time.Time
+}
+
+// DERPRegionLatency is the latency to a DERP region.
+type DERPRegionLatency struct {
+	ID   int
+	Code string
+
+	// Latency is the lowest latency to the region over any protocol,
+	// or zero if it didn't reply.
+	Latency time.Duration `json:",omitempty"`
+
+	// Avoided is whether the region is in the DERPAvoidRegions pref.
+	Avoided bool `json:",omitempty"`
+}
+
+// DNSCache is the contents of the MagicDNS forwarder's cache of
+// upstream responses.
+type DNSCache struct {
+	// MaxEntries is how many responses are cached at most, or zero if
+	// caching is disabled.
+	MaxEntries int
+
+	// MaxTTL is the longest a response is cached for, whatever its TTL.
+	MaxTTL time.Duration
+
+	// Hits and Misses are how many forwarded queries were and weren't
+	// answered from the cache since tailscaled started.
+	Hits   int64
+	Misses int64
+
+	// Entries are the cached responses, soonest to expire first.
+	Entries []DNSCacheEntry
+}
+
+// DNSCacheEntry is a response in the DNSCache.
+type DNSCacheEntry struct {
+	Name    string // the question's FQDN, lowercase
+	Type    string // the question's type, such as "AAAA"
+	RCode   string // the response code, such as "Success" or "NameError"
+	Answers int    // number of answer records
+
+	// Expires is when the response is dropped from the cache.
+	Expires time.Time
+}
+
+// DNSQueryStats are the stats of the DNS queries that local clients
+// sent to 100.100.100.100:53, or its IPv6 equivalent, since tailscaled
+// started.
+type DNSQueryStats struct {
+	// Since is when the first query was sent, or the zero time if
+	// none was.
+	Since time.Time
+
+	// Queries is how many queries were sent.
+	Queries int64
+
+	// Errors are how many queries failed, by class: "closed",
+	// "queue-full", "malformed", "timeout", "upstream", "servfail" or
+	// "refused". NXDOMAIN isn't an error.
+	Errors map[string]int64 `json:",omitempty"`
+
+	// P50, P90 and P99 are estimates of the quantiles of the time
+	// queries took to answer over the last LatencyWindow.
+	LatencyWindow time.Duration
+	P50, P90, P99 time.Duration
+
+	// Clients are the stats of each client, by its IP address, those
+	// that sent the most queries first. Only the clients that queried
+	// most recently are kept.
+	Clients []DNSQueryClient
+}
+
+// DNSQueryClient is the stats of the DNS queries of one client in
+// DNSQueryStats.
+type DNSQueryClient struct {
+	Addr netip.Addr
+
+	// Queries and Errors are how many queries the client sent and
+	// how many of them failed.
+	Queries int64
+	Errors  int64
+
+	// ErrorClasses are Errors by class, as in DNSQueryStats.Errors,
+	// and LastError the class of the last one.
+	ErrorClasses map[string]int64 `json:",omitempty"`
+	LastError    string           `json:",omitempty"`
+
+	// TotalLatency is the sum of the times the queries took to
+	// answer. Those dropped with "queue-full" aren't counted.
+	TotalLatency time.Duration
+
+	// Last is when the client last sent a query.
+	Last time.Time
+}
+
+// MeanLatency returns the mean time the client's queries took to
+// answer, or zero if none was answered.
+func (c *DNSQueryClient) MeanLatency() time.Duration {
+	n := c.Queries - c.ErrorClasses["queue-full"]
+	if n <= 0 {
+		return 0
+	}
+	return c.TotalLatency / time.Duration(n)
+}
+
+// ExcludedRoute is a destination prefix excluded from the exit node.
+type ExcludedRoute struct {
+	Route netip.Prefix
+
+	// Dropped, if non-empty, is why Route isn't excluded, such as
+	// that it overlaps a peer's subnet route.
+	Dropped string `json:",omitempty"`
+}
+
+// FirstContactTrace is a trace of the setup of a connection to a peer
+// that had no recent traffic, from the first packet sent to it until
+// the first data came back.
+type FirstContactTrace struct {
+	Peer   key.NodePublic
+	Start  time.Time
+	Stages []FirstContactStage // in the order reached
+
+	// Complete is whether data came back from the peer. If not, and
+	// InProgress is false, the attempt timed out.
+	Complete   bool `json:",omitempty"`
+	InProgress bool `json:",omitempty"`
+}
+
+// FirstContactStage is a stage of a FirstContactTrace.
+type FirstContactStage struct {
+	// Name is one of "peer-config", "handshake-init", "disco-ping",
+	// "derp-send", "handshake-response", "direct-path" or
+	// "first-data".
+	Name    string
+	Elapsed time.Duration // since the trace started
+	Detail  string        `json:",omitempty"` // the address or DERP region involved, if any
+}
+
+// UplinkStatus is the state of a network interface named in the
+// UplinkPolicy pref.
+type UplinkStatus struct {
+	Interface string
+	Mode      string // "prefer", "disco-only" or "exclude"
+	Up        bool   // whether the interface exists and is up
+
+	// Bound is whether magicsock's UDP sockets are bound to the
+	// interface.
+	Bound bool `json:",omitempty"`
+}
+
+// PowerSaverStatus is the state of power saver mode, which stretches
+// disco heartbeats and NAT keepalives as per the PowerSaver pref.
+type PowerSaverStatus struct {
+	Mode   string // "auto" or "on"
+	Active bool   // whether heartbeats and keepalives are stretched now
+
+	// Reason is why it's active or not, such as "on battery" or
+	// "metered link wwan0".
+	Reason string `json:",omitempty"`
+
+	// HeartbeatsSaved and ReSTUNsSaved are the disco heartbeats
+	// and periodic STUN runs skipped since tailscaled started, and
+	// BytesSaved about how many bytes the heartbeats would have
+	// taken.
+	HeartbeatsSaved int64 `json:",omitempty"`
+	BytesSaved      int64 `json:",omitempty"`
+	ReSTUNsSaved    int64 `json:",omitempty"`
+}
+
+// Diagnostics is the diagnostic information that support usually asks
+// for, beyond what's in Status. It's shown on the diagnostics page of
+// the web UI.
+type Diagnostics struct {
+	Time time.Time
+
+	// NetInfo is the result of the most recent netcheck, or nil if
+	// none has completed yet.
+	NetInfo *tailcfg.NetInfo `json:",omitempty"`
+
+	// Doctor is the output of the doctor checks, one line per entry.
+	Doctor []string
+
+	// Routes is the system route table, as printed by the platform's
+	// usual tool.
+	Routes string `json:",omitempty"`
+}
+
 func SortPeers(peers []*PeerStatus) {
 	sort.Slice(peers, func(i, j int) bool { return sortKey(peers[i]) < sortKey(peers[j]) })
 }
@@ -586,3 +1170,30 @@ func sortKey(ps *PeerStatus) string {
 	raw := ps.PublicKey.Raw32()
 	return string(raw[:])
 }
+
+// NetmapRoute is a subnet route or exit node that a peer in the network
+// map advertises or is approved for, and whether this node uses it.
+type NetmapRoute struct {
+	Route    netip.Prefix
+	NodeName string // DNS name base or (possibly not unique) hostname
+
+	// ExitNode is whether Route is a default route, offered by an
+	// exit node.
+	ExitNode bool `json:",omitempty"`
+
+	// Advertised is whether the peer advertises Route, and Approved
+	// whether the tailnet's admin approved it with the peer as the
+	// (primary) router for it.
+	Advertised bool
+	Approved   bool
+
+	// Used is whether this node routes traffic for Route to the peer.
+	Used bool
+
+	// Reason says why Route isn't used, if it isn't.
+	Reason string `json:",omitempty"`
+
+	// Note is a caveat about Route even if it's used, such as that
+	// it overlaps one of this node's local networks.
+	Note string `json:",omitempty"`
+}
@@ -25,14 +25,21 @@
 	"time"
 
 	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/clientupdate"
+	"tailscale.com/doctor"
+	"tailscale.com/envknob"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnlocal"
 	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/logtail"
+	"tailscale.com/net/hostfw"
 	"tailscale.com/net/netutil"
+	"tailscale.com/net/pktpath"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tka"
 	"tailscale.com/types/logger"
 	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/crashreport"
 	"tailscale.com/util/mak"
 	"tailscale.com/version"
 )
@@ -76,16 +83,49 @@ type Handler struct {
 	// cert fetching access.
 	PermitCert bool
 
+	// PermitDiag is whether the client is additionally granted
+	// access to the sensitive read-only diagnostic handlers: logs,
+	// metrics, packet path stats, debug endpoints and knobs, and
+	// first contact traces. Diagnostics such as doctor results only
+	// need PermitRead.
+	PermitDiag bool
+
+	// PermitProfile is whether the client is additionally granted
+	// access to the handlers that can expose process memory: CPU and
+	// memory profiles and goroutine dumps. It implies PermitDiag.
+	PermitProfile bool
+
 	b            *ipnlocal.LocalBackend
 	logf         logger.Logf
 	backendLogID string
 }
 
+// permitDiag reports whether the sensitive read-only diagnostic
+// handlers are allowed. See PermitDiag.
+func (h *Handler) permitDiag() bool {
+	return h.PermitWrite || (h.PermitRead && (h.PermitDiag || h.PermitProfile))
+}
+
+// permitProfile reports whether the profiling handlers are allowed.
+// See PermitProfile.
+func (h *Handler) permitProfile() bool {
+	return h.PermitWrite || (h.PermitRead && h.PermitProfile)
+}
+
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if h.b == nil {
 		http.Error(w, "server has no local backend", http.StatusInternalServerError)
 		return
 	}
+	// net/http recovers panics in handlers, so report them here
+	// rather than letting them crash tailscaled.
+	defer func() {
+		if p := recover(); p != nil {
+			crashreport.Report(p)
+			h.logf("localapi: panic serving %s: %v", r.URL.Path, p)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+		}
+	}()
 	w.Header().Set("Tailscale-Version", version.Long)
 	if h.RequiredPassword != "" {
 		_, pass, ok := r.BasicAuth()
@@ -127,6 +167,8 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		h.servePrefs(w, r)
 	case "/localapi/v0/ping":
 		h.servePing(w, r)
+	case "/localapi/v0/traceroute":
+		h.serveTraceroute(w, r)
 	case "/localapi/v0/check-prefs":
 		h.serveCheckPrefs(w, r)
 	case "/localapi/v0/check-ip-forwarding":
@@ -139,10 +181,64 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		h.serveSetDNS(w, r)
 	case "/localapi/v0/derpmap":
 		h.serveDERPMap(w, r)
+	case "/localapi/v0/interfaces":
+		h.serveInterfaces(w, r)
+	case "/localapi/v0/diagnostics":
+		h.serveDiagnostics(w, r)
 	case "/localapi/v0/metrics":
 		h.serveMetrics(w, r)
+	case "/localapi/v0/update/check":
+		h.serveUpdateCheck(w, r)
 	case "/localapi/v0/debug":
 		h.serveDebug(w, r)
+	case "/localapi/v0/debug-netmap-routes":
+		h.serveNetmapRoutes(w, r)
+	case "/localapi/v0/debug-derp-selection":
+		h.serveDERPSelection(w, r)
+	case "/localapi/v0/debug-dns-cache":
+		h.serveDNSCache(w, r)
+	case "/localapi/v0/debug-dns-queries":
+		h.serveDNSQueries(w, r)
+	case "/localapi/v0/debug-proxy-conns":
+		h.serveProxyConns(w, r)
+	case "/localapi/v0/debug-router-state":
+		h.serveRouterState(w, r)
+	case "/localapi/v0/debug-filter":
+		h.serveFilterStats(w, r)
+	case "/localapi/v0/debug-packet-path-stats":
+		h.servePacketPathStats(w, r)
+	case "/localapi/v0/host-firewall":
+		h.serveHostFirewall(w, r)
+	case "/localapi/v0/state-snapshot":
+		h.serveStateSnapshot(w, r)
+	case "/localapi/v0/first-contact":
+		h.serveFirstContact(w, r)
+	case "/localapi/v0/profiles":
+		h.serveProfiles(w, r)
+	case "/localapi/v0/path-pin":
+		h.servePathPin(w, r)
+	case "/localapi/v0/debug-endpoints":
+		h.serveDebugEndpoints(w, r)
+	case "/localapi/v0/exit-node-exclusions":
+		h.serveExitNodeExclusions(w, r)
+	case "/localapi/v0/log-level":
+		h.serveLogLevel(w, r)
+	case "/localapi/v0/debug-knobs":
+		h.serveDebugKnobs(w, r)
+	case "/localapi/v0/logs":
+		h.serveLogs(w, r)
+	case "/localapi/v0/wol":
+		h.serveWakeOnLAN(w, r)
+	case "/localapi/v0/doctor":
+		h.serveDoctor(w, r)
+	case "/localapi/v0/doctor-peer":
+		h.serveDoctorPeer(w, r)
+	case "/localapi/v0/doctor-runs":
+		h.serveDoctorRuns(w, r)
+	case "/localapi/v0/preflight":
+		h.servePreflight(w, r)
+	case "/localapi/v0/speedtest":
+		h.serveSpeedTest(w, r)
 	case "/localapi/v0/set-expiry-sooner":
 		h.serveSetExpirySooner(w, r)
 	case "/localapi/v0/dial":
@@ -158,157 +254,924 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	case "/":
 		io.WriteString(w, "tailscaled\n")
 	default:
-		http.Error(w, "404 not found", 404)
+		http.Error(w, "404 not found", 404)
+	}
+}
+
+// serveIDToken handles requests to get an OIDC ID token.
+func (h *Handler) serveIDToken(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "id-token access denied", http.StatusForbidden)
+		return
+	}
+	nm := h.b.NetMap()
+	if nm == nil {
+		http.Error(w, "no netmap", http.StatusServiceUnavailable)
+		return
+	}
+	aud := strings.TrimSpace(r.FormValue("aud"))
+	if len(aud) == 0 {
+		http.Error(w, "no audience requested", http.StatusBadRequest)
+		return
+	}
+	req := &tailcfg.TokenRequest{
+		CapVersion: tailcfg.CurrentCapabilityVersion,
+		Audience:   aud,
+		NodeKey:    nm.NodeKey,
+	}
+	b, err := json.Marshal(req)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	httpReq, err := http.NewRequest("POST", "https://unused/machine/id-token", bytes.NewReader(b))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	resp, err := h.b.DoNoiseRequest(httpReq)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer resp.Body.Close()
+	w.WriteHeader(resp.StatusCode)
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+}
+
+func (h *Handler) serveBugReport(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "bugreport access denied", http.StatusForbidden)
+		return
+	}
+	redact, err := doctor.ParseRedaction(r.FormValue("redact"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cats, err := doctorCategories(r.FormValue("categories"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	logMarker := fmt.Sprintf("BUG-%v-%v-%v", h.backendLogID, time.Now().UTC().Format("20060102150405Z"), randHex(8))
+	h.logf("user bugreport: %s", logMarker)
+	if note := r.FormValue("note"); len(note) > 0 {
+		h.logf("user bugreport note: %s", note)
+	}
+	h.b.LogDiagSnapshots(logger.WithPrefix(h.logf, "diag snapshot: "))
+	h.b.LogCrashReports(logger.WithPrefix(h.logf, "crash report: "))
+	h.b.LogSyntheticMonitor(logger.WithPrefix(h.logf, "synthetic checks: "))
+	h.b.LogGatewayMonitor(logger.WithPrefix(h.logf, "gateway monitor: "))
+	h.b.LogLatencySLOs(logger.WithPrefix(h.logf, "latency SLOs: "))
+	h.b.LogDoctorRuns(logger.WithPrefix(h.logf, "doctor runs: "))
+	if defBool(r.FormValue("diagnose"), false) {
+		var only []string
+		if v := r.FormValue("checks"); v != "" {
+			only = strings.Split(v, ",")
+		}
+		res := h.b.Doctor(r.Context(), logger.WithPrefix(h.logf, "diag: "), ipn.StateKey(r.FormValue("profile")), only, cats, redact)
+		logDoctorSummary(logger.WithPrefix(h.logf, "diag summary: "), res)
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintln(w, logMarker)
+}
+
+// logDoctorSummary logs a JSON line with the severity and summary of
+// each doctor check in res, so that bug reports can be triaged without
+// parsing the checks' log lines.
+func logDoctorSummary(logf logger.Logf, res []doctor.Result) {
+	sum := make([]apitype.DoctorCheckResult, len(res))
+	for i, r := range res {
+		sum[i] = apitype.DoctorCheckResult{
+			Name:        r.Name,
+			Severity:    string(r.Severity),
+			Summary:     r.Summary,
+			Detail:      r.Detail,
+			Remediation: r.Remediation,
+		}
+	}
+	j, err := json.Marshal(sum)
+	if err != nil {
+		logf("%v", err)
+		return
+	}
+	logf("%s", j)
+}
+
+func (h *Handler) serveWhoIs(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "whois access denied", http.StatusForbidden)
+		return
+	}
+	b := h.b
+	var ipp netip.AddrPort
+	if v := r.FormValue("addr"); v != "" {
+		var err error
+		ipp, err = netip.ParseAddrPort(v)
+		if err != nil {
+			http.Error(w, "invalid 'addr' parameter", 400)
+			return
+		}
+	} else {
+		http.Error(w, "missing 'addr' parameter", 400)
+		return
+	}
+	n, u, ok := b.WhoIs(ipp)
+	if !ok {
+		http.Error(w, "no match for IP:port", 404)
+		return
+	}
+	res := &apitype.WhoIsResponse{
+		Node:        n,
+		UserProfile: &u,
+		Caps:        b.PeerCaps(ipp.Addr()),
+	}
+	j, err := json.MarshalIndent(res, "", "\t")
+	if err != nil {
+		http.Error(w, "JSON encoding error", 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(j)
+}
+
+func (h *Handler) serveGoroutines(w http.ResponseWriter, r *http.Request) {
+	// Require write or profile access out of paranoia that the
+	// goroutine dump (at least its arguments) might contain something
+	// sensitive.
+	if !h.permitProfile() {
+		http.Error(w, "goroutine dump access denied", http.StatusForbidden)
+		return
+	}
+	buf := make([]byte, 2<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write(buf)
+}
+
+func (h *Handler) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	// Require write or diag access out of paranoia that the metrics
+	// might contain something sensitive.
+	if !h.permitDiag() {
+		http.Error(w, "metric access denied", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	clientmetric.WritePrometheusExpositionFormat(w)
+	writeRuntimeMetrics(w)
+}
+
+// writeRuntimeMetrics writes Go runtime statistics to w in the
+// Prometheus text exposition format.
+func writeRuntimeMetrics(w io.Writer) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	for _, m := range []struct {
+		name, typ string
+		v         uint64
+	}{
+		{"go_goroutines", "gauge", uint64(runtime.NumGoroutine())},
+		{"go_memstats_heap_alloc_bytes", "gauge", ms.HeapAlloc},
+		{"go_memstats_heap_objects", "gauge", ms.HeapObjects},
+		{"go_memstats_heap_sys_bytes", "gauge", ms.HeapSys},
+		{"go_memstats_sys_bytes", "gauge", ms.Sys},
+		{"go_memstats_mallocs_total", "counter", ms.Mallocs},
+		{"go_gc_cycles_total", "counter", uint64(ms.NumGC)},
+		{"go_gc_pause_ns_total", "counter", ms.PauseTotalNs},
+	} {
+		fmt.Fprintf(w, "# TYPE %s %s\n%s %d\n", m.name, m.typ, m.name, m.v)
+	}
+}
+
+func (h *Handler) serveDebug(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "debug access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	action := r.FormValue("action")
+	var err error
+	switch action {
+	case "rebind":
+		err = h.b.DebugRebind()
+	case "restun":
+		err = h.b.DebugReSTUN()
+	case "clean-stale-state":
+		err = h.b.DebugCleanStaleState(r.Context())
+	case "":
+		err = fmt.Errorf("missing parameter 'action'")
+	default:
+		err = fmt.Errorf("unknown action %q", action)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	io.WriteString(w, "done\n")
+}
+
+// servePathPin sets the path pin for a peer. It expects a POST with an
+// "ip" parameter naming the peer's Tailscale IP and a JSON-encoded
+// ipnstate.PathPin body.
+func (h *Handler) servePathPin(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "path pin access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	ip, err := netip.ParseAddr(r.FormValue("ip"))
+	if err != nil {
+		http.Error(w, "invalid 'ip' parameter", 400)
+		return
+	}
+	var pin ipnstate.PathPin
+	if err := json.NewDecoder(r.Body).Decode(&pin); err != nil {
+		http.Error(w, "invalid JSON body", 400)
+		return
+	}
+	if err := h.b.SetPeerPathPin(ip, pin); err != nil {
+		writeErrorJSON(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct{}{})
+}
+
+// serveDebugEndpoints returns this node's endpoints as an
+// ipnstate.NodeEndpoints on GET. On POST, it imports the endpoints of
+// a peer: it expects an "ip" parameter naming the peer's Tailscale IP
+// and a JSON-encoded list of ip:ports body, empty to remove those
+// imported before.
+func (h *Handler) serveDebugEndpoints(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		if !h.permitDiag() {
+			http.Error(w, "debug-endpoints access denied", http.StatusForbidden)
+			return
+		}
+		eps, err := h.b.LocalEndpoints()
+		if err != nil {
+			writeErrorJSON(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(eps)
+	case "POST":
+		if !h.PermitWrite {
+			http.Error(w, "debug-endpoints access denied", http.StatusForbidden)
+			return
+		}
+		ip, err := netip.ParseAddr(r.FormValue("ip"))
+		if err != nil {
+			http.Error(w, "invalid 'ip' parameter", 400)
+			return
+		}
+		var eps []netip.AddrPort
+		if err := json.NewDecoder(r.Body).Decode(&eps); err != nil {
+			http.Error(w, "invalid JSON body", 400)
+			return
+		}
+		if err := h.b.ImportPeerEndpoints(ip, eps); err != nil {
+			writeErrorJSON(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct{}{})
+	default:
+		http.Error(w, "want GET or POST", http.StatusMethodNotAllowed)
+	}
+}
+
+// serveExitNodeExclusions returns the effective exit node exclusions
+// on GET. On POST, it sets the ExitNodeExcludeRoutes and
+// ExitNodeExcludeApps prefs from a JSON-encoded
+// apitype.ExitNodeExclusions body and returns the prefs.
+func (h *Handler) serveExitNodeExclusions(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "exit node exclusions access denied", http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		ex := h.b.ExitNodeExclusions()
+		if ex == nil {
+			ex = []ipnstate.ExcludedRoute{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ex)
+	case "POST":
+		if !h.PermitWrite {
+			http.Error(w, "exit node exclusions write access denied", http.StatusForbidden)
+			return
+		}
+		var req apitype.ExitNodeExclusions
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid JSON body", 400)
+			return
+		}
+		prefs, err := h.b.EditPrefs(&ipn.MaskedPrefs{
+			Prefs: ipn.Prefs{
+				ExitNodeExcludeRoutes: req.Routes,
+				ExitNodeExcludeApps:   req.Apps,
+			},
+			ExitNodeExcludeRoutesSet: true,
+			ExitNodeExcludeAppsSet:   true,
+		})
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(resJSON{Error: err.Error()})
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(prefs)
+	default:
+		http.Error(w, "unsupported method", http.StatusMethodNotAllowed)
+	}
+}
+
+// serveFirstContact returns the trace of the most recent connection
+// setup to the peer with the Tailscale IP in the "ip" parameter.
+func (h *Handler) serveFirstContact(w http.ResponseWriter, r *http.Request) {
+	if !h.permitDiag() {
+		http.Error(w, "first-contact access denied", http.StatusForbidden)
+		return
+	}
+	ip, err := netip.ParseAddr(r.FormValue("ip"))
+	if err != nil {
+		http.Error(w, "invalid 'ip' parameter", 400)
+		return
+	}
+	t, err := h.b.FirstContact(ip)
+	if err != nil {
+		writeErrorJSON(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+}
+
+// serveProfiles returns the state keys of the stored profiles.
+func (h *Handler) serveProfiles(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "profiles access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "want GET", 400)
+		return
+	}
+	keys, ok := h.b.ProfileKeys()
+	if !ok {
+		writeErrorJSON(w, errors.New("state store can't list profiles"))
+		return
+	}
+	if keys == nil {
+		keys = []ipn.StateKey{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// serveHostFirewall returns the host firewall rules that allow inbound
+// UDP to tailscaled's port on GET, and adds them to the host firewall on
+// POST.
+func (h *Handler) serveHostFirewall(w http.ResponseWriter, r *http.Request) {
+	var p *hostfw.Plan
+	var err error
+	switch r.Method {
+	case "GET":
+		if !h.PermitRead {
+			http.Error(w, "host-firewall access denied", http.StatusForbidden)
+			return
+		}
+		p, err = h.b.HostFirewallPlan(r.Context())
+	case "POST":
+		if !h.PermitWrite {
+			http.Error(w, "host-firewall access denied", http.StatusForbidden)
+			return
+		}
+		p, err = h.b.ApplyHostFirewall(r.Context())
+	default:
+		http.Error(w, "want GET or POST", 400)
+		return
+	}
+	if err != nil {
+		writeErrorJSON(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
+
+// serveLogLevel returns the log verbosity of each component on GET, and
+// on POST sets that of the "component" parameter to the "level"
+// parameter, as parsed by logtail.ParseVerbosityLevel.
+func (h *Handler) serveLogLevel(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		if !h.PermitRead {
+			http.Error(w, "log-level access denied", http.StatusForbidden)
+			return
+		}
+	case "POST":
+		if !h.PermitWrite {
+			http.Error(w, "log-level access denied", http.StatusForbidden)
+			return
+		}
+		level, err := logtail.ParseVerbosityLevel(r.FormValue("level"))
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		if err := h.b.SetLogLevel(r.FormValue("component"), level); err != nil {
+			writeErrorJSON(w, err)
+			return
+		}
+	default:
+		http.Error(w, "want GET or POST", http.StatusMethodNotAllowed)
+		return
+	}
+	levels, err := h.b.LogLevels()
+	if err != nil {
+		writeErrorJSON(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(levels)
+}
+
+// serveNetmapRoutes writes the subnet routes and exit nodes in the
+// netmap and whether they're used.
+func (h *Handler) serveNetmapRoutes(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "netmap routes access denied", http.StatusForbidden)
+		return
+	}
+	routes, err := h.b.NetmapRoutes()
+	if err != nil {
+		writeErrorJSON(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(routes)
+}
+
+// serveDERPSelection writes the home DERP region and how it was
+// selected.
+func (h *Handler) serveDERPSelection(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "DERP selection access denied", http.StatusForbidden)
+		return
+	}
+	sel, err := h.b.DERPSelection()
+	if err != nil {
+		writeErrorJSON(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(sel)
+}
+
+// serveDNSCache writes the contents of the MagicDNS forwarder's
+// cache, first flushing it for POST requests.
+func (h *Handler) serveDNSCache(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		if !h.PermitRead {
+			http.Error(w, "DNS cache access denied", http.StatusForbidden)
+			return
+		}
+	case "POST":
+		if !h.PermitWrite {
+			http.Error(w, "DNS cache flush access denied", http.StatusForbidden)
+			return
+		}
+		if err := h.b.FlushDNSCache(); err != nil {
+			writeErrorJSON(w, err)
+			return
+		}
+	default:
+		http.Error(w, "want GET or POST", 400)
+		return
+	}
+	st, err := h.b.DNSCache()
+	if err != nil {
+		writeErrorJSON(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(st)
+}
+
+// serveDNSQueries writes the stats of the DNS queries that local clients
+// sent to MagicDNS, by client.
+func (h *Handler) serveDNSQueries(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "DNS query stats access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "want GET", 400)
+		return
+	}
+	st, err := h.b.DNSQueryStats()
+	if err != nil {
+		writeErrorJSON(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(st)
+}
+
+// serveProxyConns writes the recent connections of the outbound SOCKS5
+// and HTTP proxies.
+func (h *Handler) serveProxyConns(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "proxy conns access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "want GET", 400)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(h.b.Dialer().ProxyConns())
+}
+
+// serveRouterState writes the routing configuration last applied to the
+// OS and the routes that failed to be added or removed.
+func (h *Handler) serveRouterState(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "router state access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "want GET", 400)
+		return
+	}
+	st, err := h.b.DebugRouterState()
+	if err != nil {
+		writeErrorJSON(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(st)
+}
+
+func (h *Handler) serveFilterStats(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "filter stats access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "want GET", 400)
+		return
+	}
+	st, err := h.b.DebugFilterStats()
+	if err != nil {
+		writeErrorJSON(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(st)
+}
+
+// servePacketPathStats measures the packet path for the "duration"
+// parameter, at most a minute, and writes the pktpath.Stats.
+func (h *Handler) servePacketPathStats(w http.ResponseWriter, r *http.Request) {
+	if !h.permitDiag() {
+		http.Error(w, "debug access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "want GET", 400)
+		return
+	}
+	d, err := time.ParseDuration(r.FormValue("duration"))
+	if err != nil || d <= 0 || d > time.Minute {
+		http.Error(w, "invalid 'duration' parameter", 400)
+		return
+	}
+	st, err := pktpath.Collect(r.Context(), d)
+	if err != nil {
+		writeErrorJSON(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(st)
+}
+
+// serveDebugKnobs lists the debug knobs that can be changed at runtime
+// and their current values on GET, and sets the knob named by the
+// "name" parameter to the "value" parameter on POST. An empty value
+// unsets the knob.
+func (h *Handler) serveDebugKnobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		if !h.permitDiag() {
+			http.Error(w, "debug-knobs access denied", http.StatusForbidden)
+			return
+		}
+	case "POST":
+		if !h.PermitWrite {
+			http.Error(w, "debug-knobs access denied", http.StatusForbidden)
+			return
+		}
+		name, val := r.FormValue("name"), r.FormValue("value")
+		if err := envknob.Setenv(name, val); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		h.logf("debug knob %s set to %q", name, val)
+	default:
+		http.Error(w, "want GET or POST", http.StatusMethodNotAllowed)
+		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(envknob.Registered())
 }
 
-// serveIDToken handles requests to get an OIDC ID token.
-func (h *Handler) serveIDToken(w http.ResponseWriter, r *http.Request) {
+// serveLogs writes the log lines tailscaled keeps in memory. The
+// optional "since" parameter is a duration limiting them to the most
+// recent ones, and "component" limits them to one logging component.
+func (h *Handler) serveLogs(w http.ResponseWriter, r *http.Request) {
+	if !h.permitDiag() {
+		http.Error(w, "logs access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "want GET", 400)
+		return
+	}
+	var since time.Time
+	if v := r.FormValue("since"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			http.Error(w, "invalid 'since' parameter", 400)
+			return
+		}
+		since = time.Now().Add(-d)
+	}
+	var buf bytes.Buffer
+	if err := h.b.WriteRecentLogs(&buf, since, r.FormValue("component")); err != nil {
+		writeErrorJSON(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+func (h *Handler) serveWakeOnLAN(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitWrite {
-		http.Error(w, "id-token access denied", http.StatusForbidden)
+		http.Error(w, "wake-on-lan access denied", http.StatusForbidden)
 		return
 	}
-	nm := h.b.NetMap()
-	if nm == nil {
-		http.Error(w, "no netmap", http.StatusServiceUnavailable)
+	if r.Method != "POST" {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
 		return
 	}
-	aud := strings.TrimSpace(r.FormValue("aud"))
-	if len(aud) == 0 {
-		http.Error(w, "no audience requested", http.StatusBadRequest)
+	ip, err := netip.ParseAddr(r.FormValue("ip"))
+	if err != nil {
+		http.Error(w, "invalid 'ip' parameter", 400)
 		return
 	}
-	req := &tailcfg.TokenRequest{
-		CapVersion: tailcfg.CurrentCapabilityVersion,
-		Audience:   aud,
-		NodeKey:    nm.NodeKey,
+	var via netip.Addr
+	if v := r.FormValue("via"); v != "" {
+		via, err = netip.ParseAddr(v)
+		if err != nil {
+			http.Error(w, "invalid 'via' parameter", 400)
+			return
+		}
 	}
-	b, err := json.Marshal(req)
+	res, err := h.b.WakeOnLAN(r.Context(), ip, via)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		writeErrorJSON(w, err)
 		return
 	}
-	httpReq, err := http.NewRequest("POST", "https://unused/machine/id-token", bytes.NewReader(b))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// serveDoctor runs the doctor checks, or with the "checks" parameter
+// only those it names, comma-separated, or with the "categories"
+// parameter only those in the categories it names (see
+// doctor.ParseCategory), and writes their results, redacted per the
+// "redact" parameter (see doctor.ParseRedaction).
+// With the "progress" parameter, it instead streams newline-delimited
+// apitype.DoctorStreamMessages: the checks' progress as they run,
+// then their results.
+func (h *Handler) serveDoctor(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "doctor access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	var only []string
+	if v := r.FormValue("checks"); v != "" {
+		only = strings.Split(v, ",")
+	}
+	cats, err := doctorCategories(r.FormValue("categories"))
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	resp, err := h.b.DoNoiseRequest(httpReq)
+	redact, err := doctor.ParseRedaction(r.FormValue("redact"))
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	defer resp.Body.Close()
-	w.WriteHeader(resp.StatusCode)
-	if _, err := io.Copy(w, resp.Body); err != nil {
-		http.Error(w, err.Error(), 500)
+	profile := ipn.StateKey(r.FormValue("profile"))
+	if progress, _ := strconv.ParseBool(r.FormValue("progress")); progress {
+		h.serveDoctorProgress(w, r, profile, only, cats, redact)
 		return
 	}
+	res := h.b.DoctorResults(r.Context(), profile, only, cats, redact, nil)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
 }
 
-func (h *Handler) serveBugReport(w http.ResponseWriter, r *http.Request) {
-	if !h.PermitRead {
-		http.Error(w, "bugreport access denied", http.StatusForbidden)
-		return
+// doctorCategories parses v, the comma-separated doctor check
+// categories of the "categories" parameter.
+func doctorCategories(v string) ([]doctor.Category, error) {
+	if v == "" {
+		return nil, nil
+	}
+	var cats []doctor.Category
+	for _, s := range strings.Split(v, ",") {
+		c, err := doctor.ParseCategory(s)
+		if err != nil {
+			return nil, err
+		}
+		cats = append(cats, c)
 	}
+	return cats, nil
+}
 
-	logMarker := fmt.Sprintf("BUG-%v-%v-%v", h.backendLogID, time.Now().UTC().Format("20060102150405Z"), randHex(8))
-	h.logf("user bugreport: %s", logMarker)
-	if note := r.FormValue("note"); len(note) > 0 {
-		h.logf("user bugreport note: %s", note)
+// serveDoctorProgress is the part of serveDoctor that streams the
+// progress of the checks, then their results.
+func (h *Handler) serveDoctorProgress(w http.ResponseWriter, r *http.Request, profile ipn.StateKey, only []string, cats []doctor.Category, redact doctor.Redaction) {
+	f, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	send := func(m apitype.DoctorStreamMessage) {
+		if err := e.Encode(m); err != nil {
+			return
+		}
+		if f != nil {
+			f.Flush()
+		}
 	}
-	w.Header().Set("Content-Type", "text/plain")
-	fmt.Fprintln(w, logMarker)
+	// The doctor package serializes calls to the progress func.
+	res := h.b.DoctorResults(r.Context(), profile, only, cats, redact, func(p doctor.Progress) {
+		send(apitype.DoctorStreamMessage{Progress: &apitype.DoctorProgress{
+			Check:    p.Check,
+			Done:     p.Done,
+			Total:    p.Total,
+			What:     p.What,
+			Finished: p.Finished,
+		}})
+	})
+	send(apitype.DoctorStreamMessage{Results: res})
 }
 
-func (h *Handler) serveWhoIs(w http.ResponseWriter, r *http.Request) {
+// serveDoctorRuns writes the doctor runs kept in the state store,
+// oldest first, or with the "a" or "b" parameters, what changed
+// between the two runs they select (by default, the last two). See
+// ipnlocal.LocalBackend.DiffDoctorRuns.
+func (h *Handler) serveDoctorRuns(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitRead {
-		http.Error(w, "whois access denied", http.StatusForbidden)
+		http.Error(w, "doctor-runs access denied", http.StatusForbidden)
 		return
 	}
-	b := h.b
-	var ipp netip.AddrPort
-	if v := r.FormValue("addr"); v != "" {
-		var err error
-		ipp, err = netip.ParseAddrPort(v)
+	if r.Method != "GET" {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+	var res any
+	a, b := r.FormValue("a"), r.FormValue("b")
+	if a == "" && b == "" {
+		runs, err := h.b.DoctorRuns()
 		if err != nil {
-			http.Error(w, "invalid 'addr' parameter", 400)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		res = runs
 	} else {
-		http.Error(w, "missing 'addr' parameter", 400)
-		return
+		if a == "" {
+			a = "-2"
+		}
+		if b == "" {
+			b = "-1"
+		}
+		diff, err := h.b.DiffDoctorRuns(a, b)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		res = diff
 	}
-	n, u, ok := b.WhoIs(ipp)
-	if !ok {
-		http.Error(w, "no match for IP:port", 404)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *Handler) servePreflight(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "preflight access denied", http.StatusForbidden)
 		return
 	}
-	res := &apitype.WhoIsResponse{
-		Node:        n,
-		UserProfile: &u,
-		Caps:        b.PeerCaps(ipp.Addr()),
-	}
-	j, err := json.MarshalIndent(res, "", "\t")
-	if err != nil {
-		http.Error(w, "JSON encoding error", 500)
+	if r.Method != "POST" {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
 		return
 	}
+	res := h.b.Preflight(r.Context())
 	w.Header().Set("Content-Type", "application/json")
-	w.Write(j)
+	json.NewEncoder(w).Encode(res)
 }
 
-func (h *Handler) serveGoroutines(w http.ResponseWriter, r *http.Request) {
-	// Require write access out of paranoia that the goroutine dump
-	// (at least its arguments) might contain something sensitive.
+func (h *Handler) serveDoctorPeer(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitWrite {
-		http.Error(w, "goroutine dump access denied", http.StatusForbidden)
+		http.Error(w, "doctor-peer access denied", http.StatusForbidden)
 		return
 	}
-	buf := make([]byte, 2<<20)
-	buf = buf[:runtime.Stack(buf, true)]
-	w.Header().Set("Content-Type", "text/plain")
-	w.Write(buf)
-}
-
-func (h *Handler) serveMetrics(w http.ResponseWriter, r *http.Request) {
-	// Require write access out of paranoia that the metrics
-	// might contain something sensitive.
-	if !h.PermitWrite {
-		http.Error(w, "metric access denied", http.StatusForbidden)
+	if r.Method != "POST" {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
 		return
 	}
-	w.Header().Set("Content-Type", "text/plain")
-	clientmetric.WritePrometheusExpositionFormat(w)
+	ip, err := netip.ParseAddr(r.FormValue("ip"))
+	if err != nil {
+		http.Error(w, "invalid 'ip' parameter", 400)
+		return
+	}
+	res, err := h.b.DoctorPeer(r.Context(), ip)
+	if err != nil {
+		writeErrorJSON(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
 }
 
-func (h *Handler) serveDebug(w http.ResponseWriter, r *http.Request) {
+// serveSpeedTest runs a throughput test against the peer with the
+// Tailscale IP in the "ip" parameter, for the "duration" parameter in
+// each direction.
+func (h *Handler) serveSpeedTest(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitWrite {
-		http.Error(w, "debug access denied", http.StatusForbidden)
+		http.Error(w, "speedtest access denied", http.StatusForbidden)
 		return
 	}
 	if r.Method != "POST" {
 		http.Error(w, "POST required", http.StatusMethodNotAllowed)
 		return
 	}
-	action := r.FormValue("action")
-	var err error
-	switch action {
-	case "rebind":
-		err = h.b.DebugRebind()
-	case "restun":
-		err = h.b.DebugReSTUN()
-	case "":
-		err = fmt.Errorf("missing parameter 'action'")
-	default:
-		err = fmt.Errorf("unknown action %q", action)
+	ip, err := netip.ParseAddr(r.FormValue("ip"))
+	if err != nil {
+		http.Error(w, "invalid 'ip' parameter", 400)
+		return
 	}
+	d, err := time.ParseDuration(r.FormValue("duration"))
 	if err != nil {
-		http.Error(w, err.Error(), 400)
+		http.Error(w, "invalid 'duration' parameter", 400)
 		return
 	}
-	w.Header().Set("Content-Type", "text/plain")
-	io.WriteString(w, "done\n")
+	res, err := h.b.SpeedTestPeer(r.Context(), ip, d)
+	if err != nil {
+		writeErrorJSON(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
 }
 
 // serveProfileFunc is the implementation of Handler.serveProfile, after auth,
@@ -316,9 +1179,9 @@ func (h *Handler) serveDebug(w http.ResponseWriter, r *http.Request) {
 var serveProfileFunc func(http.ResponseWriter, *http.Request)
 
 func (h *Handler) serveProfile(w http.ResponseWriter, r *http.Request) {
-	// Require write access out of paranoia that the profile dump
-	// might contain something sensitive.
-	if !h.PermitWrite {
+	// Require write or profile access out of paranoia that the
+	// profile dump might contain something sensitive.
+	if !h.permitProfile() {
 		http.Error(w, "profile access denied", http.StatusForbidden)
 		return
 	}
@@ -632,6 +1495,83 @@ func (h *Handler) serveDERPMap(w http.ResponseWriter, r *http.Request) {
 	e.Encode(h.b.DERPMap())
 }
 
+func (h *Handler) serveInterfaces(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "interfaces access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "want GET", 400)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(h.b.NetInterfaces())
+}
+
+func (h *Handler) serveDiagnostics(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "diagnostics access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "want GET", 400)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(h.b.Diagnostics(r.Context()))
+}
+
+// serveStateSnapshot returns a redacted snapshot of the node's prefs,
+// network map and health. See ipn.StateSnapshot.
+func (h *Handler) serveStateSnapshot(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "state snapshot access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "want GET", 400)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(h.b.StateSnapshot())
+}
+
+// serveUpdateCheck reports whether a newer version of Tailscale is
+// available on the release track given by the optional "track" query
+// parameter, which defaults to the running version's track.
+func (h *Handler) serveUpdateCheck(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "update check access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "want GET", 400)
+		return
+	}
+	track := clientupdate.TrackOf(version.Short)
+	if v := r.FormValue("track"); v != "" {
+		var err error
+		track, err = clientupdate.ParseTrack(v)
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+	}
+	res, err := clientupdate.Check(r.Context(), track)
+	if err != nil {
+		writeErrorJSON(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
 // serveSetExpirySooner sets the expiry date on the current machine, specified
 // by an `expiry` unix timestamp as POST or query param.
 func (h *Handler) serveSetExpirySooner(w http.ResponseWriter, r *http.Request) {
@@ -691,6 +1631,35 @@ func (h *Handler) servePing(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(res)
 }
 
+// serveTraceroute traces the route through the tunnel to the "ip"
+// parameter, probing at most "max" hops (default 16).
+func (h *Handler) serveTraceroute(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "want POST", 400)
+		return
+	}
+	ip, err := netip.ParseAddr(r.FormValue("ip"))
+	if err != nil {
+		http.Error(w, "invalid or missing 'ip' parameter", 400)
+		return
+	}
+	maxHops := 16
+	if v := r.FormValue("max"); v != "" {
+		maxHops, err = strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "invalid 'max' parameter", 400)
+			return
+		}
+	}
+	res, err := h.b.Traceroute(r.Context(), ip, maxHops)
+	if err != nil {
+		writeErrorJSON(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
 func (h *Handler) serveDial(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "POST required", http.StatusMethodNotAllowed)
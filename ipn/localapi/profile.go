@@ -13,8 +13,25 @@
 import (
 	"net/http"
 	"net/http/pprof"
+	"runtime"
+	"strconv"
+	"sync"
 )
 
+const (
+	// mutexProfileFraction is the mutex profile fraction used while a
+	// mutex profile is being captured. See runtime.SetMutexProfileFraction.
+	mutexProfileFraction = 5
+
+	// blockProfileRate is the block profile rate used while a block
+	// profile is being captured. See runtime.SetBlockProfileRate.
+	blockProfileRate = 10000 // ns
+)
+
+// contentionProfileMu is held while a mutex or block profile is being
+// captured, as those change process-wide profiling rates.
+var contentionProfileMu sync.Mutex
+
 func init() {
 	serveProfileFunc = serveProfile
 }
@@ -24,6 +41,24 @@ func serveProfile(w http.ResponseWriter, r *http.Request) {
 	switch name {
 	case "profile":
 		pprof.Profile(w, r)
+	case "mutex", "block":
+		// Mutex and block profiles are off by default, so turn them
+		// on for the duration of a delta profile.
+		if sec, _ := strconv.Atoi(r.FormValue("seconds")); sec > 0 {
+			if !contentionProfileMu.TryLock() {
+				http.Error(w, "a mutex or block profile is already running", http.StatusConflict)
+				return
+			}
+			defer contentionProfileMu.Unlock()
+			if name == "mutex" {
+				prev := runtime.SetMutexProfileFraction(mutexProfileFraction)
+				defer runtime.SetMutexProfileFraction(prev)
+			} else {
+				runtime.SetBlockProfileRate(blockProfileRate)
+				defer runtime.SetBlockProfileRate(0)
+			}
+		}
+		pprof.Handler(name).ServeHTTP(w, r)
 	default:
 		pprof.Handler(name).ServeHTTP(w, r)
 	}
@@ -105,6 +105,20 @@ type Prefs struct {
 	// routed directly or via the exit node.
 	ExitNodeAllowLANAccess bool
 
+	// ExitNodeExcludeRoutes are destination prefixes routed directly
+	// instead of via the exit node, when one is in use. Prefixes that
+	// overlap the tailnet's addresses or peers' subnet routes aren't
+	// excluded; Status reports why.
+	//
+	// Linux only.
+	ExitNodeExcludeRoutes []netip.Prefix `json:",omitempty"`
+
+	// ExitNodeExcludeApps are the applications, by package name, whose
+	// traffic bypasses the tunnel, exit node or not.
+	//
+	// Android only: applied by the app's VpnService.
+	ExitNodeExcludeApps []string `json:",omitempty"`
+
 	// CorpDNS specifies whether to install the Tailscale network's
 	// DNS configuration, if it exists.
 	CorpDNS bool
@@ -166,6 +180,98 @@ type Prefs struct {
 	// Egg is a optional debug flag.
 	Egg bool
 
+	// ForceDERP specifies whether to disable direct UDP paths to
+	// peers, relaying all traffic over DERP (TCP port 443) as if UDP
+	// were blocked. It's meant for reproducing the behavior of
+	// restrictive networks.
+	ForceDERP bool `json:",omitempty"`
+
+	// UplinkPolicy restricts which local network interfaces are used
+	// to reach peers directly on machines with more than one. Each
+	// element is of the form "<interface>:<mode>", as parsed by
+	// preftype.ParseUplinkPolicy. Interfaces without a rule are used
+	// as usual.
+	UplinkPolicy []string `json:",omitempty"`
+
+	// SyntheticMonitorPeer, if non-empty, enables the synthetic
+	// monitor, which periodically resolves the MagicDNS name of and
+	// disco-pings this peer, given as a name or Tailscale IP, and
+	// connects to the control server, recording the results for health
+	// checks and bug reports.
+	SyntheticMonitorPeer string `json:",omitempty"`
+
+	// LatencySLOs are local latency objectives, such as "rtt:nas<50ms"
+	// or "dns<100ms", as parsed by preftype.ParseLatencySLO. They're
+	// checked against the latencies of the last few minutes, raising
+	// a health warning with the measured latency while any is
+	// violated.
+	LatencySLOs []string `json:",omitempty"`
+
+	// PowerSaver is when to stretch disco heartbeats and NAT
+	// keepalives to save battery and metered data: "" (never),
+	// "auto" (on battery or a metered link) or "on" (always), as
+	// parsed by preftype.ParsePowerSaverMode.
+	PowerSaver string `json:",omitempty"`
+
+	// AllowRemoteDoctor specifies whether peers may ask this node to
+	// run its doctor checks and send back the results over the peer
+	// API. Peers must also be owned by the same user or be granted
+	// the tailcfg.CapabilityDoctorPeer capability.
+	AllowRemoteDoctor bool `json:",omitempty"`
+
+	// AllowSpeedTest specifies whether peers may run throughput tests
+	// against this node over the peer API. Peers must also be owned
+	// by the same user or be granted the
+	// tailcfg.CapabilitySpeedTestPeer capability.
+	AllowSpeedTest bool `json:",omitempty"`
+
+	// DoctorInterval, if non-empty, is how often to run the doctor
+	// checks unattended, as parsed by time.ParseDuration (e.g. "24h").
+	// It must be at least an hour. The results of recent runs are kept
+	// in the state directory and included in bug reports.
+	DoctorInterval string `json:",omitempty"`
+
+	// DoctorLogResults specifies whether scheduled doctor runs log the
+	// full results of each check, rather than just a summary.
+	DoctorLogResults bool `json:",omitempty"`
+
+	// DoctorLightweight specifies whether scheduled doctor runs only
+	// run the lightweight checks, which inspect local state without
+	// probing servers or the network. It permits a DoctorInterval as
+	// short as ten minutes, and more runs are kept, so that bug
+	// reports include a finer-grained history.
+	DoctorLightweight bool `json:",omitempty"`
+
+	// RecvBatchSize, if non-zero, is the number of UDP packets read
+	// from the network per system call on Linux, instead of the default
+	// of 8. Each packet of a batch has its own 64 KiB buffer, for each
+	// of IPv4 and IPv6, so low-memory devices can lower it; 1 disables
+	// batching.
+	RecvBatchSize int `json:",omitempty"`
+
+	// DERPSendQueue, if non-zero, is the number of packets queued for
+	// each DERP server before further ones are dropped, instead of the
+	// default of 32.
+	DERPSendQueue int `json:",omitempty"`
+
+	// DERPHomeRegion, if non-zero, is the ID of the DERP region to use
+	// as the home region regardless of latency, such as to keep
+	// relayed traffic in one jurisdiction. It's ignored if the DERP
+	// map has no such region.
+	DERPHomeRegion int `json:",omitempty"`
+
+	// DERPAvoidRegions are the IDs of DERP regions not to pick as the
+	// home region, such as known-bad ones, unless no other region
+	// works. Peers whose home region is avoided are still reached
+	// through it.
+	DERPAvoidRegions []int `json:",omitempty"`
+
+	// UDPPortRange, if non-empty, restricts the local UDP ports used
+	// for direct connections and STUN to a single port ("41641") or an
+	// inclusive range ("40000-40100"), for networks whose egress
+	// firewalls only allow certain source ports.
+	UDPPortRange string `json:",omitempty"`
+
 	// The following block of options only have an effect on Linux.
 
 	// AdvertiseRoutes specifies CIDR prefixes to advertise into the
@@ -173,6 +279,13 @@ type Prefs struct {
 	// node.
 	AdvertiseRoutes []netip.Prefix
 
+	// ReadvertiseRoutes specifies whether to update AdvertiseRoutes
+	// when a local interface whose subnet is advertised moves to
+	// another subnet, such as after its DHCP lease changes, replacing
+	// the route with the interface's new subnet. Either way, such a
+	// move raises a health warning.
+	ReadvertiseRoutes bool `json:",omitempty"`
+
 	// NoSNAT specifies whether to source NAT traffic going to
 	// destinations in AdvertiseRoutes. The default is to apply source
 	// NAT, which makes the traffic appear to come from the router
@@ -185,10 +298,35 @@ type Prefs struct {
 	// Linux-only.
 	NoSNAT bool
 
+	// ClampMSS specifies whether to clamp the TCP MSS of connections
+	// forwarded through this node as a subnet router or exit node, so
+	// their segments fit the path MTU without relying on path MTU
+	// discovery, which often fails behind small-MTU uplinks.
+	//
+	// Linux-only: applied with netfilter, or by netstack in
+	// userspace-networking mode.
+	ClampMSS bool `json:",omitempty"`
+
+	// NoAutoMTU specifies whether not to lower the MTU of the
+	// Tailscale interface when direct paths to peers are found to
+	// silently drop full-size packets. Such black holes are still
+	// reported as a health warning.
+	//
+	// Linux-only.
+	NoAutoMTU bool `json:",omitempty"`
+
 	// NetfilterMode specifies how much to manage netfilter rules for
 	// Tailscale, if at all.
 	NetfilterMode preftype.NetfilterMode
 
+	// RouteMetric, if non-zero, is the metric given to the routes
+	// that Tailscale installs: the route priority on Linux, and the
+	// interface metric on Windows. Lower values win. Zero means to
+	// use the OS default.
+	//
+	// Linux and Windows only.
+	RouteMetric int `json:",omitempty"`
+
 	// OperatorUser is the local machine user name who is allowed to
 	// operate tailscaled without being root or using sudo.
 	OperatorUser string `json:",omitempty"`
@@ -211,6 +349,8 @@ type MaskedPrefs struct {
 	ExitNodeIDSet             bool `json:",omitempty"`
 	ExitNodeIPSet             bool `json:",omitempty"`
 	ExitNodeAllowLANAccessSet bool `json:",omitempty"`
+	ExitNodeExcludeRoutesSet  bool `json:",omitempty"`
+	ExitNodeExcludeAppsSet    bool `json:",omitempty"`
 	CorpDNSSet                bool `json:",omitempty"`
 	RunSSHSet                 bool `json:",omitempty"`
 	WantRunningSet            bool `json:",omitempty"`
@@ -221,9 +361,28 @@ type MaskedPrefs struct {
 	NotepadURLsSet            bool `json:",omitempty"`
 	ForceDaemonSet            bool `json:",omitempty"`
 	EggSet                    bool `json:",omitempty"`
+	ForceDERPSet              bool `json:",omitempty"`
+	UplinkPolicySet           bool `json:",omitempty"`
+	SyntheticMonitorPeerSet   bool `json:",omitempty"`
+	LatencySLOsSet            bool `json:",omitempty"`
+	PowerSaverSet             bool `json:",omitempty"`
+	AllowRemoteDoctorSet      bool `json:",omitempty"`
+	AllowSpeedTestSet         bool `json:",omitempty"`
+	DoctorIntervalSet         bool `json:",omitempty"`
+	DoctorLogResultsSet       bool `json:",omitempty"`
+	DoctorLightweightSet      bool `json:",omitempty"`
+	RecvBatchSizeSet          bool `json:",omitempty"`
+	DERPSendQueueSet          bool `json:",omitempty"`
+	DERPHomeRegionSet         bool `json:",omitempty"`
+	DERPAvoidRegionsSet       bool `json:",omitempty"`
+	UDPPortRangeSet           bool `json:",omitempty"`
 	AdvertiseRoutesSet        bool `json:",omitempty"`
+	ReadvertiseRoutesSet      bool `json:",omitempty"`
 	NoSNATSet                 bool `json:",omitempty"`
+	ClampMSSSet               bool `json:",omitempty"`
+	NoAutoMTUSet              bool `json:",omitempty"`
 	NetfilterModeSet          bool `json:",omitempty"`
+	RouteMetricSet            bool `json:",omitempty"`
 	OperatorUserSet           bool `json:",omitempty"`
 }
 
@@ -313,23 +472,86 @@ func (p *Prefs) pretty(goos string) string {
 	if p.ShieldsUp {
 		sb.WriteString("shields=true ")
 	}
+	if p.ForceDERP {
+		sb.WriteString("forcederp=true ")
+	}
+	if len(p.UplinkPolicy) > 0 {
+		fmt.Fprintf(&sb, "uplinks=%s ", strings.Join(p.UplinkPolicy, ","))
+	}
+	if p.SyntheticMonitorPeer != "" {
+		fmt.Fprintf(&sb, "synthmon=%s ", p.SyntheticMonitorPeer)
+	}
+	if len(p.LatencySLOs) > 0 {
+		fmt.Fprintf(&sb, "slos=%s ", strings.Join(p.LatencySLOs, ","))
+	}
+	if p.PowerSaver != "" {
+		fmt.Fprintf(&sb, "powersaver=%s ", p.PowerSaver)
+	}
+	if p.AllowRemoteDoctor {
+		sb.WriteString("remotedoctor=true ")
+	}
+	if p.AllowSpeedTest {
+		sb.WriteString("speedtest=true ")
+	}
+	if p.DoctorInterval != "" {
+		fmt.Fprintf(&sb, "doctor=%s ", p.DoctorInterval)
+		if p.DoctorLogResults {
+			sb.WriteString("doctorlog=true ")
+		}
+		if p.DoctorLightweight {
+			sb.WriteString("doctorlight=true ")
+		}
+	}
+	if p.RecvBatchSize != 0 {
+		fmt.Fprintf(&sb, "recvbatch=%d ", p.RecvBatchSize)
+	}
+	if p.DERPSendQueue != 0 {
+		fmt.Fprintf(&sb, "derpqueue=%d ", p.DERPSendQueue)
+	}
+	if p.DERPHomeRegion != 0 {
+		fmt.Fprintf(&sb, "derphome=%d ", p.DERPHomeRegion)
+	}
+	if len(p.DERPAvoidRegions) > 0 {
+		fmt.Fprintf(&sb, "derpavoid=%v ", p.DERPAvoidRegions)
+	}
+	if p.UDPPortRange != "" {
+		fmt.Fprintf(&sb, "ports=%s ", p.UDPPortRange)
+	}
 	if p.ExitNodeIP.IsValid() {
 		fmt.Fprintf(&sb, "exit=%v lan=%t ", p.ExitNodeIP, p.ExitNodeAllowLANAccess)
 	} else if !p.ExitNodeID.IsZero() {
 		fmt.Fprintf(&sb, "exit=%v lan=%t ", p.ExitNodeID, p.ExitNodeAllowLANAccess)
 	}
+	if len(p.ExitNodeExcludeRoutes) > 0 {
+		fmt.Fprintf(&sb, "exclude=%v ", p.ExitNodeExcludeRoutes)
+	}
+	if len(p.ExitNodeExcludeApps) > 0 {
+		fmt.Fprintf(&sb, "excludeapps=%s ", strings.Join(p.ExitNodeExcludeApps, ","))
+	}
 	if len(p.AdvertiseRoutes) > 0 || goos == "linux" {
 		fmt.Fprintf(&sb, "routes=%v ", p.AdvertiseRoutes)
 	}
+	if p.ReadvertiseRoutes {
+		sb.WriteString("readvertise=true ")
+	}
 	if len(p.AdvertiseRoutes) > 0 || p.NoSNAT {
 		fmt.Fprintf(&sb, "snat=%v ", !p.NoSNAT)
 	}
+	if p.ClampMSS {
+		fmt.Fprintf(&sb, "clampmss=true ")
+	}
+	if p.NoAutoMTU {
+		sb.WriteString("automtu=false ")
+	}
 	if len(p.AdvertiseTags) > 0 {
 		fmt.Fprintf(&sb, "tags=%s ", strings.Join(p.AdvertiseTags, ","))
 	}
 	if goos == "linux" {
 		fmt.Fprintf(&sb, "nf=%v ", p.NetfilterMode)
 	}
+	if p.RouteMetric != 0 {
+		fmt.Fprintf(&sb, "metric=%d ", p.RouteMetric)
+	}
 	if p.ControlURL != "" && p.ControlURL != DefaultControlURL {
 		fmt.Fprintf(&sb, "url=%q ", p.ControlURL)
 	}
@@ -371,18 +593,39 @@ func (p *Prefs) Equals(p2 *Prefs) bool {
 		p.ExitNodeID == p2.ExitNodeID &&
 		p.ExitNodeIP == p2.ExitNodeIP &&
 		p.ExitNodeAllowLANAccess == p2.ExitNodeAllowLANAccess &&
+		compareIPNets(p.ExitNodeExcludeRoutes, p2.ExitNodeExcludeRoutes) &&
+		compareStrings(p.ExitNodeExcludeApps, p2.ExitNodeExcludeApps) &&
 		p.CorpDNS == p2.CorpDNS &&
 		p.RunSSH == p2.RunSSH &&
 		p.WantRunning == p2.WantRunning &&
 		p.LoggedOut == p2.LoggedOut &&
 		p.NotepadURLs == p2.NotepadURLs &&
 		p.ShieldsUp == p2.ShieldsUp &&
+		p.ForceDERP == p2.ForceDERP &&
+		compareStrings(p.UplinkPolicy, p2.UplinkPolicy) &&
+		p.SyntheticMonitorPeer == p2.SyntheticMonitorPeer &&
+		compareStrings(p.LatencySLOs, p2.LatencySLOs) &&
+		p.PowerSaver == p2.PowerSaver &&
+		p.AllowRemoteDoctor == p2.AllowRemoteDoctor &&
+		p.AllowSpeedTest == p2.AllowSpeedTest &&
+		p.DoctorInterval == p2.DoctorInterval &&
+		p.DoctorLogResults == p2.DoctorLogResults &&
+		p.DoctorLightweight == p2.DoctorLightweight &&
+		p.RecvBatchSize == p2.RecvBatchSize &&
+		p.DERPSendQueue == p2.DERPSendQueue &&
+		p.DERPHomeRegion == p2.DERPHomeRegion &&
+		compareInts(p.DERPAvoidRegions, p2.DERPAvoidRegions) &&
+		p.UDPPortRange == p2.UDPPortRange &&
 		p.NoSNAT == p2.NoSNAT &&
+		p.ClampMSS == p2.ClampMSS &&
+		p.NoAutoMTU == p2.NoAutoMTU &&
 		p.NetfilterMode == p2.NetfilterMode &&
+		p.RouteMetric == p2.RouteMetric &&
 		p.OperatorUser == p2.OperatorUser &&
 		p.Hostname == p2.Hostname &&
 		p.ForceDaemon == p2.ForceDaemon &&
 		compareIPNets(p.AdvertiseRoutes, p2.AdvertiseRoutes) &&
+		p.ReadvertiseRoutes == p2.ReadvertiseRoutes &&
 		compareStrings(p.AdvertiseTags, p2.AdvertiseTags) &&
 		p.Persist.Equals(p2.Persist)
 }
@@ -411,6 +654,18 @@ func compareStrings(a, b []string) bool {
 	return true
 }
 
+func compareInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 // NewPrefs returns the default preferences to use.
 func NewPrefs() *Prefs {
 	// Provide default values for options which might be missing
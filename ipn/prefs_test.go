@@ -43,6 +43,8 @@ func TestPrefsEqual(t *testing.T) {
 		"ExitNodeID",
 		"ExitNodeIP",
 		"ExitNodeAllowLANAccess",
+		"ExitNodeExcludeRoutes",
+		"ExitNodeExcludeApps",
 		"CorpDNS",
 		"RunSSH",
 		"WantRunning",
@@ -53,9 +55,28 @@ func TestPrefsEqual(t *testing.T) {
 		"NotepadURLs",
 		"ForceDaemon",
 		"Egg",
+		"ForceDERP",
+		"UplinkPolicy",
+		"SyntheticMonitorPeer",
+		"LatencySLOs",
+		"PowerSaver",
+		"AllowRemoteDoctor",
+		"AllowSpeedTest",
+		"DoctorInterval",
+		"DoctorLogResults",
+		"DoctorLightweight",
+		"RecvBatchSize",
+		"DERPSendQueue",
+		"DERPHomeRegion",
+		"DERPAvoidRegions",
+		"UDPPortRange",
 		"AdvertiseRoutes",
+		"ReadvertiseRoutes",
 		"NoSNAT",
+		"ClampMSS",
+		"NoAutoMTU",
 		"NetfilterMode",
+		"RouteMetric",
 		"OperatorUser",
 		"Persist",
 	}
@@ -192,6 +213,16 @@ func TestPrefsEqual(t *testing.T) {
 			&Prefs{NoSNAT: true},
 			true,
 		},
+		{
+			&Prefs{ClampMSS: true},
+			&Prefs{ClampMSS: false},
+			false,
+		},
+		{
+			&Prefs{NoAutoMTU: true},
+			&Prefs{NoAutoMTU: false},
+			false,
+		},
 
 		{
 			&Prefs{Hostname: "android-host01"},
@@ -251,6 +282,11 @@ func TestPrefsEqual(t *testing.T) {
 			&Prefs{AdvertiseRoutes: nets("192.168.0.0/24", "10.1.0.0/16")},
 			true,
 		},
+		{
+			&Prefs{ReadvertiseRoutes: true},
+			&Prefs{ReadvertiseRoutes: false},
+			false,
+		},
 
 		{
 			&Prefs{NetfilterMode: preftype.NetfilterOff},
@@ -263,6 +299,123 @@ func TestPrefsEqual(t *testing.T) {
 			true,
 		},
 
+		{
+			&Prefs{ForceDERP: false},
+			&Prefs{ForceDERP: true},
+			false,
+		},
+		{
+			&Prefs{ExitNodeExcludeRoutes: nets("10.1.0.0/16")},
+			&Prefs{ExitNodeExcludeRoutes: nets("10.2.0.0/16")},
+			false,
+		},
+		{
+			&Prefs{ExitNodeExcludeRoutes: nets("10.1.0.0/16")},
+			&Prefs{ExitNodeExcludeRoutes: nets("10.1.0.0/16")},
+			true,
+		},
+		{
+			&Prefs{ExitNodeExcludeApps: []string{"com.example.bank"}},
+			&Prefs{},
+			false,
+		},
+		{
+			&Prefs{UplinkPolicy: []string{"eth0:prefer"}},
+			&Prefs{UplinkPolicy: []string{"eth0:exclude"}},
+			false,
+		},
+		{
+			&Prefs{UplinkPolicy: []string{"eth0:prefer"}},
+			&Prefs{UplinkPolicy: []string{"eth0:prefer"}},
+			true,
+		},
+		{
+			&Prefs{SyntheticMonitorPeer: "foo"},
+			&Prefs{SyntheticMonitorPeer: "bar"},
+			false,
+		},
+		{
+			&Prefs{LatencySLOs: []string{"dns<100ms"}},
+			&Prefs{LatencySLOs: []string{"dns<200ms"}},
+			false,
+		},
+		{
+			&Prefs{LatencySLOs: []string{"rtt:nas<50ms", "dns<100ms"}},
+			&Prefs{LatencySLOs: []string{"rtt:nas<50ms", "dns<100ms"}},
+			true,
+		},
+		{
+			&Prefs{PowerSaver: "auto"},
+			&Prefs{PowerSaver: ""},
+			false,
+		},
+		{
+			&Prefs{AllowRemoteDoctor: true},
+			&Prefs{AllowRemoteDoctor: false},
+			false,
+		},
+		{
+			&Prefs{AllowSpeedTest: true},
+			&Prefs{AllowSpeedTest: false},
+			false,
+		},
+		{
+			&Prefs{DoctorInterval: "24h"},
+			&Prefs{DoctorInterval: "12h"},
+			false,
+		},
+		{
+			&Prefs{DoctorInterval: "24h", DoctorLogResults: true},
+			&Prefs{DoctorInterval: "24h"},
+			false,
+		},
+		{
+			&Prefs{DoctorInterval: "1h", DoctorLightweight: true},
+			&Prefs{DoctorInterval: "1h"},
+			false,
+		},
+		{
+			&Prefs{RecvBatchSize: 1},
+			&Prefs{RecvBatchSize: 0},
+			false,
+		},
+		{
+			&Prefs{DERPSendQueue: 8},
+			&Prefs{DERPSendQueue: 8},
+			true,
+		},
+		{
+			&Prefs{DERPHomeRegion: 1},
+			&Prefs{DERPHomeRegion: 2},
+			false,
+		},
+		{
+			&Prefs{DERPAvoidRegions: []int{1, 2}},
+			&Prefs{DERPAvoidRegions: []int{1}},
+			false,
+		},
+		{
+			&Prefs{DERPAvoidRegions: []int{1, 2}},
+			&Prefs{DERPAvoidRegions: []int{1, 2}},
+			true,
+		},
+		{
+			&Prefs{UDPPortRange: "40000-40100"},
+			&Prefs{UDPPortRange: "41641"},
+			false,
+		},
+
+		{
+			&Prefs{RouteMetric: 0},
+			&Prefs{RouteMetric: 100},
+			false,
+		},
+		{
+			&Prefs{RouteMetric: 100},
+			&Prefs{RouteMetric: 100},
+			true,
+		},
+
 		{
 			&Prefs{Persist: &persist.Persist{}},
 			&Prefs{Persist: &persist.Persist{LoginName: "dave"}},
@@ -437,6 +590,109 @@ func TestPrefsPretty(t *testing.T) {
 			"linux",
 			`Prefs{ra=false mesh=false dns=false want=false exit=myNodeABC lan=true routes=[] nf=off Persist=nil}`,
 		},
+		{
+			Prefs{
+				ForceDERP: true,
+			},
+			"linux",
+			`Prefs{ra=false mesh=false dns=false want=false forcederp=true routes=[] nf=off Persist=nil}`,
+		},
+		{
+			Prefs{
+				UplinkPolicy: []string{"eth0:prefer", "wwan0:disco-only"},
+			},
+			"linux",
+			`Prefs{ra=false mesh=false dns=false want=false uplinks=eth0:prefer,wwan0:disco-only routes=[] nf=off Persist=nil}`,
+		},
+		{
+			Prefs{
+				SyntheticMonitorPeer: "foo",
+			},
+			"linux",
+			`Prefs{ra=false mesh=false dns=false want=false synthmon=foo routes=[] nf=off Persist=nil}`,
+		},
+		{
+			Prefs{
+				LatencySLOs: []string{"rtt:nas<50ms", "dns<100ms"},
+			},
+			"linux",
+			`Prefs{ra=false mesh=false dns=false want=false slos=rtt:nas<50ms,dns<100ms routes=[] nf=off Persist=nil}`,
+		},
+		{
+			Prefs{
+				AdvertiseRoutes:   []netip.Prefix{netip.MustParsePrefix("192.168.1.0/24")},
+				ReadvertiseRoutes: true,
+			},
+			"linux",
+			`Prefs{ra=false mesh=false dns=false want=false routes=[192.168.1.0/24] readvertise=true snat=true nf=off Persist=nil}`,
+		},
+		{
+			Prefs{
+				PowerSaver: "auto",
+			},
+			"linux",
+			`Prefs{ra=false mesh=false dns=false want=false powersaver=auto routes=[] nf=off Persist=nil}`,
+		},
+		{
+			Prefs{
+				AllowRemoteDoctor: true,
+			},
+			"linux",
+			`Prefs{ra=false mesh=false dns=false want=false remotedoctor=true routes=[] nf=off Persist=nil}`,
+		},
+		{
+			Prefs{
+				AllowSpeedTest: true,
+			},
+			"linux",
+			`Prefs{ra=false mesh=false dns=false want=false speedtest=true routes=[] nf=off Persist=nil}`,
+		},
+		{
+			Prefs{
+				DoctorInterval:   "24h",
+				DoctorLogResults: true,
+			},
+			"linux",
+			`Prefs{ra=false mesh=false dns=false want=false doctor=24h doctorlog=true routes=[] nf=off Persist=nil}`,
+		},
+		{
+			Prefs{
+				DoctorInterval:    "1h",
+				DoctorLightweight: true,
+			},
+			"linux",
+			`Prefs{ra=false mesh=false dns=false want=false doctor=1h doctorlight=true routes=[] nf=off Persist=nil}`,
+		},
+		{
+			Prefs{
+				RecvBatchSize: 1,
+				DERPSendQueue: 8,
+			},
+			"linux",
+			`Prefs{ra=false mesh=false dns=false want=false recvbatch=1 derpqueue=8 routes=[] nf=off Persist=nil}`,
+		},
+		{
+			Prefs{
+				DERPHomeRegion:   1,
+				DERPAvoidRegions: []int{2, 3},
+			},
+			"linux",
+			`Prefs{ra=false mesh=false dns=false want=false derphome=1 derpavoid=[2 3] routes=[] nf=off Persist=nil}`,
+		},
+		{
+			Prefs{
+				UDPPortRange: "40000-40100",
+			},
+			"linux",
+			`Prefs{ra=false mesh=false dns=false want=false ports=40000-40100 routes=[] nf=off Persist=nil}`,
+		},
+		{
+			Prefs{
+				RouteMetric: 100,
+			},
+			"linux",
+			`Prefs{ra=false mesh=false dns=false want=false routes=[] nf=off metric=100 Persist=nil}`,
+		},
 		{
 			Prefs{
 				ExitNodeAllowLANAccess: true,
@@ -444,6 +700,14 @@ func TestPrefsPretty(t *testing.T) {
 			"linux",
 			`Prefs{ra=false mesh=false dns=false want=false routes=[] nf=off Persist=nil}`,
 		},
+		{
+			Prefs{
+				ExitNodeID:            tailcfg.StableNodeID("myNodeABC"),
+				ExitNodeExcludeRoutes: []netip.Prefix{netip.MustParsePrefix("10.1.0.0/16")},
+			},
+			"linux",
+			`Prefs{ra=false mesh=false dns=false want=false exit=myNodeABC lan=false exclude=[10.1.0.0/16] routes=[] nf=off Persist=nil}`,
+		},
 		{
 			Prefs{
 				Hostname: "foo",
@@ -0,0 +1,166 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipn
+
+import (
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+)
+
+// StateSnapshotVersion is the version of the StateSnapshot format
+// written by this version of Tailscale. It's incremented when fields
+// change meaning or are removed; adding fields doesn't change it.
+const StateSnapshotVersion = 1
+
+// StateSnapshot is a redacted copy of a node's configuration and state,
+// for reproducing it elsewhere, such as in an integration test. It never
+// contains private keys, and contains no public keys or user login
+// names either.
+type StateSnapshot struct {
+	Version          int // StateSnapshotVersion when written
+	Time             time.Time
+	TailscaleVersion string
+	OS               string
+	BackendState     string
+
+	// Prefs are the node's prefs, without Persist, ControlURL and
+	// OperatorUser.
+	Prefs *Prefs
+
+	// NetMap summarizes the node's current network map, or is nil if
+	// it doesn't have one.
+	NetMap *NetMapSummary `json:",omitempty"`
+
+	// Health contains the node's health check problems.
+	Health []string `json:",omitempty"`
+}
+
+// NetMapSummary is the part of a network map that's included in a
+// StateSnapshot.
+type NetMapSummary struct {
+	Name              string // the node's DNS name
+	Domain            string // the tailnet name
+	Addresses         []netip.Prefix
+	Capabilities      []string `json:",omitempty"`
+	DNS               tailcfg.DNSConfig
+	Peers             []PeerSummary `json:",omitempty"`
+	PacketFilterRules int           // number of packet filter matches
+	SSHPolicyRules    int           `json:",omitempty"`
+	DERPRegions       []int         `json:",omitempty"` // region IDs, sorted
+	CollectServices   bool          `json:",omitempty"`
+}
+
+// PeerSummary describes a peer in a NetMapSummary.
+type PeerSummary struct {
+	ID            tailcfg.StableNodeID
+	Name          string // DNS name
+	OS            string `json:",omitempty"`
+	Addresses     []netip.Prefix
+	AllowedIPs    []netip.Prefix
+	PrimaryRoutes []netip.Prefix `json:",omitempty"`
+	Tags          []string       `json:",omitempty"`
+	Capabilities  []string       `json:",omitempty"`
+	DERPRegion    int            `json:",omitempty"` // home DERP region ID, or zero
+	Online        *bool          `json:",omitempty"`
+	Shared        bool           `json:",omitempty"` // shared in from another tailnet
+}
+
+// SummarizeNetMap returns the summary of nm for a StateSnapshot, or nil
+// if nm is nil.
+func SummarizeNetMap(nm *netmap.NetworkMap) *NetMapSummary {
+	if nm == nil {
+		return nil
+	}
+	s := &NetMapSummary{
+		Name:              nm.Name,
+		Domain:            nm.Domain,
+		Addresses:         nm.Addresses,
+		DNS:               nm.DNS,
+		PacketFilterRules: len(nm.PacketFilter),
+		CollectServices:   nm.CollectServices,
+	}
+	if nm.SelfNode != nil {
+		s.Capabilities = nm.SelfNode.Capabilities
+	}
+	if nm.SSHPolicy != nil {
+		s.SSHPolicyRules = len(nm.SSHPolicy.Rules)
+	}
+	if nm.DERPMap != nil {
+		s.DERPRegions = nm.DERPMap.RegionIDs()
+	}
+	for _, p := range nm.Peers {
+		ps := PeerSummary{
+			ID:            p.StableID,
+			Name:          p.Name,
+			Addresses:     p.Addresses,
+			AllowedIPs:    p.AllowedIPs,
+			PrimaryRoutes: p.PrimaryRoutes,
+			Tags:          p.Tags,
+			Capabilities:  p.Capabilities,
+			Online:        p.Online,
+			Shared:        !p.Sharer.IsZero(),
+		}
+		if p.Hostinfo.Valid() {
+			ps.OS = p.Hostinfo.OS()
+		}
+		if ip, port, ok := strings.Cut(p.DERP, ":"); ok && ip == tailcfg.DerpMagicIP {
+			ps.DERPRegion, _ = strconv.Atoi(port)
+		}
+		s.Peers = append(s.Peers, ps)
+	}
+	return s
+}
+
+// ImportPrefs returns the edits that apply the snapshot's prefs to
+// another node. Prefs tied to the original machine or its login, such
+// as the control server, operator user, hostname, advertised tags and
+// whether it's running, are left unchanged, as are those that grant others access, such as
+// AllowRemoteDoctor and AllowSpeedTest. It returns nil if the snapshot has no prefs.
+func (s *StateSnapshot) ImportPrefs() *MaskedPrefs {
+	if s.Prefs == nil {
+		return nil
+	}
+	mp := &MaskedPrefs{
+		Prefs: *s.Prefs.Clone(),
+
+		RouteAllSet:               true,
+		AllowSingleHostsSet:       true,
+		ExitNodeIDSet:             true,
+		ExitNodeIPSet:             true,
+		ExitNodeAllowLANAccessSet: true,
+		ExitNodeExcludeRoutesSet:  true,
+		ExitNodeExcludeAppsSet:    true,
+		CorpDNSSet:                true,
+		RunSSHSet:                 true,
+		ShieldsUpSet:              true,
+		ForceDERPSet:              true,
+		UplinkPolicySet:           true,
+		SyntheticMonitorPeerSet:   true,
+		LatencySLOsSet:            true,
+		PowerSaverSet:             true,
+		DoctorIntervalSet:         true,
+		DoctorLogResultsSet:       true,
+		DoctorLightweightSet:      true,
+		RecvBatchSizeSet:          true,
+		DERPSendQueueSet:          true,
+		DERPHomeRegionSet:         true,
+		DERPAvoidRegionsSet:       true,
+		UDPPortRangeSet:           true,
+		AdvertiseRoutesSet:        true,
+		ReadvertiseRoutesSet:      true,
+		NoSNATSet:                 true,
+		ClampMSSSet:               true,
+		NoAutoMTUSet:              true,
+		NetfilterModeSet:          true,
+		RouteMetricSet:            true,
+	}
+	mp.Prefs.Persist = nil
+	return mp
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipn
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/types/netmap"
+	"tailscale.com/types/persist"
+)
+
+func TestSummarizeNetMap(t *testing.T) {
+	if got := SummarizeNetMap(nil); got != nil {
+		t.Errorf("SummarizeNetMap(nil) = %+v; want nil", got)
+	}
+	self := netip.MustParsePrefix("100.64.0.1/32")
+	peer := netip.MustParsePrefix("100.64.0.2/32")
+	nm := &netmap.NetworkMap{
+		Name:      "foo.example.ts.net.",
+		Domain:    "example.com",
+		Addresses: []netip.Prefix{self},
+		Peers: []*tailcfg.Node{
+			{
+				StableID:   "n1",
+				Name:       "bar.example.ts.net.",
+				Key:        key.NewNode().Public(),
+				Addresses:  []netip.Prefix{peer},
+				AllowedIPs: []netip.Prefix{peer},
+				DERP:       "127.3.3.40:3",
+			},
+			{
+				StableID: "n2",
+				Name:     "baz.other.ts.net.",
+				Sharer:   5,
+			},
+		},
+	}
+	got := SummarizeNetMap(nm)
+	want := &NetMapSummary{
+		Name:      "foo.example.ts.net.",
+		Domain:    "example.com",
+		Addresses: []netip.Prefix{self},
+		Peers: []PeerSummary{
+			{
+				ID:         "n1",
+				Name:       "bar.example.ts.net.",
+				Addresses:  []netip.Prefix{peer},
+				AllowedIPs: []netip.Prefix{peer},
+				DERPRegion: 3,
+			},
+			{
+				ID:     "n2",
+				Name:   "baz.other.ts.net.",
+				Shared: true,
+			},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v; want %+v", got, want)
+	}
+}
+
+func TestStateSnapshotImportPrefs(t *testing.T) {
+	if mp := (&StateSnapshot{}).ImportPrefs(); mp != nil {
+		t.Errorf("ImportPrefs without prefs = %v; want nil", mp.Pretty())
+	}
+
+	p := NewPrefs()
+	p.ControlURL = "https://control.example.com"
+	p.Hostname = "foo"
+	p.ShieldsUp = true
+	p.WantRunning = true
+	p.Persist = &persist.Persist{LoginName: "user@example.com"}
+	snap := &StateSnapshot{Version: StateSnapshotVersion, Prefs: p}
+
+	mp := snap.ImportPrefs()
+	if mp.Persist != nil {
+		t.Error("ImportPrefs kept Persist")
+	}
+	if p.Persist == nil {
+		t.Error("ImportPrefs modified the snapshot's Persist")
+	}
+	got := NewPrefs()
+	got.ControlURL = "http://localhost:8080"
+	got.ApplyEdits(mp)
+	if !got.ShieldsUp {
+		t.Errorf("imported prefs = %v; want ShieldsUp from snapshot", got.Pretty())
+	}
+	if got.ControlURL != "http://localhost:8080" || got.Hostname != "" || got.WantRunning {
+		t.Errorf("imported prefs = %v; want ControlURL, Hostname and WantRunning unchanged", got.Pretty())
+	}
+
+	// Each pref must be either imported or deliberately left alone, so
+	// new prefs get a decision.
+	notImported := map[string]bool{
+		"ControlURLSet":    true,
+		"WantRunningSet":   true,
+		"LoggedOutSet":     true,
+		"NotepadURLsSet":   true,
+		"ForceDaemonSet":   true,
+		"EggSet":           true,
+		"OperatorUserSet":  true,
+		"HostnameSet":      true,
+		"AdvertiseTagsSet": true,
+
+		"AllowRemoteDoctorSet": true,
+		"AllowSpeedTestSet":    true,
+	}
+	mv := reflect.ValueOf(mp).Elem()
+	mt := mv.Type()
+	for i := 1; i < mt.NumField(); i++ {
+		name := mt.Field(i).Name
+		if set := mv.Field(i).Bool(); set == notImported[name] {
+			t.Errorf("%s: imported = %v; update ImportPrefs or this test", name, set)
+		}
+	}
+}
@@ -38,6 +38,11 @@
 	// NLKeyStateKey is the key under which we store the nodes'
 	// network-lock node key, in its key.NLPrivate.MarshalText representation.
 	NLKeyStateKey = StateKey("_nl-node-key")
+
+	// DoctorRunsStateKey is the key under which we store the last
+	// runs of the doctor checks, as a JSON array of
+	// apitype.DoctorRun, oldest first.
+	DoctorRunsStateKey = StateKey("_doctor-runs")
 )
 
 // StateStore persists state, and produces it back on request.
@@ -48,6 +48,25 @@ func (s *Store) WriteState(id ipn.StateKey, bs []byte) error {
 	return nil
 }
 
+// Keys returns the keys of all stored state, in no particular order.
+func (s *Store) Keys() []ipn.StateKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ret := make([]ipn.StateKey, 0, len(s.cache))
+	for k := range s.cache {
+		ret = append(ret, k)
+	}
+	return ret
+}
+
+// DeleteState removes the state associated with id, if any.
+func (s *Store) DeleteState(id ipn.StateKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.cache, id)
+	return nil
+}
+
 // LoadFromJSON attempts to unmarshal json content into the
 // in-memory cache.
 func (s *Store) LoadFromJSON(data []byte) error {
@@ -188,3 +188,29 @@ func (s *FileStore) WriteState(id ipn.StateKey, bs []byte) error {
 	}
 	return atomicfile.WriteFile(s.path, bs, 0600)
 }
+
+// Keys returns the keys of all stored state, in no particular order.
+func (s *FileStore) Keys() []ipn.StateKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ret := make([]ipn.StateKey, 0, len(s.cache))
+	for k := range s.cache {
+		ret = append(ret, k)
+	}
+	return ret
+}
+
+// DeleteState removes the state associated with id, if any.
+func (s *FileStore) DeleteState(id ipn.StateKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.cache[id]; !ok {
+		return nil
+	}
+	delete(s.cache, id)
+	bs, err := json.MarshalIndent(s.cache, "", "  ")
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(s.path, bs, 0600)
+}
@@ -178,3 +178,31 @@ func TestFileStore(t *testing.T) {
 		}
 	}
 }
+
+func TestFileStoreDeleteState(t *testing.T) {
+	tstest.PanicOnLog()
+
+	path := filepath.Join(t.TempDir(), "test-file-store.conf")
+	store, err := NewFileStore(nil, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := store.(*FileStore)
+	fs.WriteState("foo", []byte("bar"))
+	fs.WriteState("baz", []byte("quux"))
+	if err := fs.DeleteState("foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The deletion must be persisted.
+	store, err = NewFileStore(nil, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.ReadState("foo"); err != ipn.ErrStateNotExist {
+		t.Errorf("reading deleted key: got %v, want ErrStateNotExist", err)
+	}
+	if got := store.(*FileStore).Keys(); len(got) != 1 || got[0] != "baz" {
+		t.Errorf("Keys = %q; want [baz]", got)
+	}
+}
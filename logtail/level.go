@@ -0,0 +1,128 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package logtail
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+)
+
+// maxComponentLen is the longest log line prefix considered a
+// component name by logComponent.
+const maxComponentLen = 40
+
+// ParseVerbosityLevel parses a verbosity level as used by
+// SetVerbosityLevel and SetComponentVerbosityLevel: either a number,
+// or one of "info" (0), "verbose" (1) or "debug" (2).
+//
+// "default" parses as -1, which removes a component's override.
+func ParseVerbosityLevel(s string) (int, error) {
+	switch s {
+	case "default":
+		return -1, nil
+	case "info":
+		return 0, nil
+	case "verbose":
+		return 1, nil
+	case "debug":
+		return 2, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid log level %q; want info, verbose, debug, default or a number", s)
+	}
+	return v, nil
+}
+
+// SetComponentVerbosityLevel sets the verbosity level written to stderr
+// for log lines of component, overriding the level set by
+// SetVerbosityLevel. A negative level removes the override.
+//
+// A log line's component is the prefix before its first ": ", such as
+// "magicsock" or "dns". Setting the level of "derphttp" also applies to
+// lines from more specific components like "derphttp.Client.Recv".
+func (l *Logger) SetComponentVerbosityLevel(component string, level int) {
+	l.componentMu.Lock()
+	defer l.componentMu.Unlock()
+	old := l.componentLevels.Load()
+	m := make(map[string]int)
+	if old != nil {
+		for k, v := range *old {
+			m[k] = v
+		}
+	}
+	if level < 0 {
+		delete(m, component)
+	} else {
+		m[component] = level
+	}
+	if len(m) == 0 {
+		l.componentLevels.Store(nil)
+		return
+	}
+	l.componentLevels.Store(&m)
+}
+
+// VerbosityLevels returns the verbosity level written to stderr and the
+// per-component overrides of it.
+func (l *Logger) VerbosityLevels() (level int, components map[string]int) {
+	components = map[string]int{}
+	if m := l.componentLevels.Load(); m != nil {
+		for k, v := range *m {
+			components[k] = v
+		}
+	}
+	return int(atomic.LoadInt64(&l.stderrLevel)), components
+}
+
+// stderrLevelFor returns the maximum verbosity level of buf (with its
+// level marker already removed) that's written to stderr.
+func (l *Logger) stderrLevelFor(buf []byte) int64 {
+	if m := l.componentLevels.Load(); m != nil {
+		if c := logComponent(buf); c != nil {
+			if v, ok := componentLevel(*m, c); ok {
+				return int64(v)
+			}
+		}
+	}
+	return atomic.LoadInt64(&l.stderrLevel)
+}
+
+// componentLevel returns the level in m for component c, or for the
+// closest of its parents (the parts of c before each '.').
+func componentLevel(m map[string]int, c []byte) (int, bool) {
+	for {
+		if v, ok := m[string(c)]; ok {
+			return v, true
+		}
+		i := bytes.LastIndexByte(c, '.')
+		if i == -1 {
+			return 0, false
+		}
+		c = c[:i]
+	}
+}
+
+// logComponent returns the component of the log line buf, which is the
+// text before its first ": ", or nil if that doesn't look like a
+// component name.
+func logComponent(buf []byte) []byte {
+	i := bytes.Index(buf, []byte(": "))
+	if i <= 0 || i > maxComponentLen {
+		return nil
+	}
+	c := buf[:i]
+	for _, b := range c {
+		switch {
+		case 'a' <= b && b <= 'z', 'A' <= b && b <= 'Z', '0' <= b && b <= '9':
+		case b == '-' || b == '_' || b == '.':
+		default:
+			return nil
+		}
+	}
+	return c
+}
@@ -175,6 +175,11 @@ type Logger struct {
 	writeLock    sync.Mutex // guards increments of procSequence
 	procSequence uint64
 
+	componentMu     sync.Mutex                     // guards writes to componentLevels
+	componentLevels atomic.Pointer[map[string]int] // or nil; see SetComponentVerbosityLevel
+
+	recent recentLogs // for WriteRecent
+
 	shutdownStart chan struct{} // closed when shutdown begins
 	shutdownDone  chan struct{} // closed when shutdown complete
 }
@@ -654,7 +659,8 @@ func (l *Logger) Write(buf []byte) (int, error) {
 		return 0, nil
 	}
 	level, buf := parseAndRemoveLogLevel(buf)
-	if l.stderr != nil && l.stderr != ioutil.Discard && int64(level) <= atomic.LoadInt64(&l.stderrLevel) {
+	l.recordRecent(level, buf)
+	if l.stderr != nil && l.stderr != ioutil.Discard && int64(level) <= l.stderrLevelFor(buf) {
 		if buf[len(buf)-1] == '\n' {
 			l.stderr.Write(buf)
 		} else {
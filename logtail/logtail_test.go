@@ -8,6 +8,7 @@
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -241,6 +242,102 @@ func TestLoggerWriteLength(t *testing.T) {
 	}
 }
 
+func TestComponentVerbosityLevel(t *testing.T) {
+	var stderr bytes.Buffer
+	lg := &Logger{
+		timeNow: time.Now,
+		buffer:  NewMemoryBuffer(4096),
+		stderr:  &stderr,
+	}
+	lg.SetComponentVerbosityLevel("magicsock", 2)
+	lg.SetComponentVerbosityLevel("derphttp", 1)
+	for _, s := range []string{
+		"[v1] magicsock: one",
+		"[v2] magicsock: two",
+		"[v1] dns: three",
+		"[v1] derphttp.Client.Recv: four",
+		"[v2] derphttp.Client.Recv: five",
+		"six",
+	} {
+		lg.Write([]byte(s + "\n"))
+	}
+	want := "magicsock: one\nmagicsock: two\nderphttp.Client.Recv: four\nsix\n"
+	if got := stderr.String(); got != want {
+		t.Errorf("stderr = %q; want %q", got, want)
+	}
+
+	lg.SetComponentVerbosityLevel("magicsock", -1)
+	if _, m := lg.VerbosityLevels(); len(m) != 1 || m["derphttp"] != 1 {
+		t.Errorf("VerbosityLevels = %v; want only derphttp=1", m)
+	}
+}
+
+func TestWriteRecent(t *testing.T) {
+	now := time.Unix(1000, 0).UTC()
+	lg := &Logger{
+		timeNow: func() time.Time { return now },
+		buffer:  NewMemoryBuffer(4096),
+	}
+	lg.Write([]byte("magicsock: old\n"))
+	now = now.Add(time.Minute)
+	lg.Write([]byte("[v1] magicsock: new\n"))
+	lg.Write([]byte("dns: other\n"))
+
+	var buf bytes.Buffer
+	if err := lg.WriteRecent(&buf, now, "magicsock"); err != nil {
+		t.Fatal(err)
+	}
+	want := "1970-01-01T00:17:40.000Z [v1] magicsock: new\n"
+	if got := buf.String(); got != want {
+		t.Errorf("WriteRecent = %q; want %q", got, want)
+	}
+}
+
+func TestRecentLogsEviction(t *testing.T) {
+	var r recentLogs
+	for i := 0; i < 100; i++ {
+		r.add(time.Time{}, 0, []byte(fmt.Sprintf("line %02d\n", i)), 70)
+	}
+	if got := len(r.entries) - r.start; got != 10 {
+		t.Errorf("kept %d entries; want 10", got)
+	}
+	if got := r.entries[len(r.entries)-1].text; got != "line 99" {
+		t.Errorf("newest entry = %q; want %q", got, "line 99")
+	}
+}
+
+func TestLogComponent(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"magicsock: foo", "magicsock"},
+		{"derphttp.Client.Recv: foo: bar", "derphttp.Client.Recv"},
+		{"no component here", ""},
+		{"two words: foo", ""},
+		{": foo", ""},
+	}
+	for _, tt := range tests {
+		if got := string(logComponent([]byte(tt.in))); got != tt.want {
+			t.Errorf("logComponent(%q) = %q; want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseVerbosityLevel(t *testing.T) {
+	for s, want := range map[string]int{"default": -1, "info": 0, "verbose": 1, "debug": 2, "3": 3} {
+		got, err := ParseVerbosityLevel(s)
+		if err != nil || got != want {
+			t.Errorf("ParseVerbosityLevel(%q) = %v, %v; want %v", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "loud", "-1"} {
+		if _, err := ParseVerbosityLevel(s); err == nil {
+			t.Errorf("ParseVerbosityLevel(%q) succeeded; want error", s)
+		}
+	}
+}
+
 func TestParseAndRemoveLogLevel(t *testing.T) {
 	tests := []struct {
 		log       string
@@ -0,0 +1,109 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package logtail
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxRecentBytes is the approximate memory budget for the recent
+	// log entries kept by a Logger, so they can be read back locally
+	// with WriteRecent even when uploads are disabled.
+	maxRecentBytes = 4 << 20
+
+	// maxRecentBytesLowMem is maxRecentBytes for Config.LowMemory.
+	maxRecentBytesLowMem = 256 << 10
+)
+
+// recentLogs is a bounded, in-memory ring of recent log entries.
+type recentLogs struct {
+	mu      sync.Mutex
+	entries []recentEntry // oldest first, from index start
+	start   int
+	size    int // total len of entries[start:] texts
+}
+
+type recentEntry struct {
+	t     time.Time
+	level int
+	text  string // without the level marker or a trailing newline
+}
+
+// add records a log line, evicting the oldest entries beyond max bytes.
+func (r *recentLogs) add(t time.Time, level int, buf []byte, max int) {
+	text := string(bytes.TrimRight(buf, "\n"))
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, recentEntry{t, level, text})
+	r.size += len(text)
+	for r.size > max && r.start < len(r.entries)-1 {
+		r.size -= len(r.entries[r.start].text)
+		r.entries[r.start] = recentEntry{}
+		r.start++
+	}
+	if r.start > len(r.entries)/2 {
+		n := copy(r.entries, r.entries[r.start:])
+		for i := n; i < len(r.entries); i++ {
+			r.entries[i] = recentEntry{}
+		}
+		r.entries = r.entries[:n]
+		r.start = 0
+	}
+}
+
+// recordRecent keeps a copy of the log line buf (with its level
+// marker already removed) for WriteRecent.
+func (l *Logger) recordRecent(level int, buf []byte) {
+	max := maxRecentBytes
+	if l.lowMem {
+		max = maxRecentBytesLowMem
+	}
+	l.recent.add(l.timeNow(), level, buf, max)
+}
+
+// WriteRecent writes the log lines kept in memory that were logged at
+// or after since to w, oldest first, one per line prefixed by its time.
+// If component is non-empty, only lines of that component or of one of
+// its more specific components (see SetComponentVerbosityLevel) are
+// written.
+func (l *Logger) WriteRecent(w io.Writer, since time.Time, component string) error {
+	r := &l.recent
+	r.mu.Lock()
+	entries := append([]recentEntry(nil), r.entries[r.start:]...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, e := range entries {
+		if e.t.Before(since) {
+			continue
+		}
+		if component != "" && !componentMatches(logComponent([]byte(e.text)), component) {
+			continue
+		}
+		bw.WriteString(e.t.Format("2006-01-02T15:04:05.000Z07:00"))
+		if e.level > 0 {
+			bw.WriteString(" [v")
+			bw.WriteByte(byte('0' + e.level))
+			bw.WriteByte(']')
+		}
+		bw.WriteByte(' ')
+		bw.WriteString(e.text)
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+// componentMatches reports whether the log line component c is want,
+// or one of want's more specific components.
+func componentMatches(c []byte, want string) bool {
+	s := string(c)
+	return s == want || strings.HasPrefix(s, want+".")
+}
@@ -31,6 +31,10 @@ type Config struct {
 	// A Routes entry with no resolvers means the route should be
 	// authoritatively answered using the contents of Hosts.
 	Routes map[dnsname.FQDN][]*dnstype.Resolver
+	// RouteStrategies maps Routes keys to how queries are sent to
+	// their resolvers. The key "." is for DefaultResolvers. Routes
+	// without an entry use dnstype.StrategyRace.
+	RouteStrategies map[dnsname.FQDN]dnstype.Strategy
 	// SearchDomains are DNS suffixes to try when expanding
 	// single-label queries.
 	SearchDomains []dnsname.FQDN
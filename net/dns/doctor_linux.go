@@ -0,0 +1,29 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dns
+
+import (
+	"context"
+
+	"tailscale.com/doctor"
+	"tailscale.com/types/logger"
+)
+
+func init() {
+	doctor.Register(doctor.InCategory(doctor.LightweightCheckFunc("dns-manager", runDoctorCheck), doctor.CategoryDNS, doctor.CategoryPlatform))
+}
+
+// runDoctorCheck logs which DNS manager NewOSConfigurator would pick
+// on this system, and the evidence it's picked from.
+func runDoctorCheck(ctx context.Context, logf logger.Logf) error {
+	mode, err := dnsMode(logf, systemOSConfigEnv())
+	if err != nil {
+		return err
+	}
+	logf("DNS manager: %s", mode)
+	doctor.AddFinding(ctx, "dns-manager", mode)
+	doctor.Report(ctx, doctor.SeverityOK, "DNS is managed with "+mode, nil)
+	return nil
+}
@@ -0,0 +1,330 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package resolver
+
+import (
+	"container/list"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/envknob"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/util/dnsname"
+)
+
+const (
+	// cacheDefaultSize is how many responses the forwarder caches,
+	// unless TS_DNS_CACHE_SIZE says otherwise.
+	cacheDefaultSize = 1000
+
+	// cacheDefaultMaxTTL is the longest a response is cached for,
+	// whatever its TTL, unless TS_DNS_CACHE_MAX_TTL says otherwise.
+	cacheDefaultMaxTTL = 24 * time.Hour
+
+	// cacheMaxNegativeTTL is the longest an NXDOMAIN or empty
+	// response is cached for. It's short so that a name that's
+	// created, or a resolver that recovers, is noticed soon.
+	cacheMaxNegativeTTL = 5 * time.Minute
+)
+
+// cacheSize returns how many responses to cache. Zero disables caching.
+func cacheSize() int {
+	if v, ok := envknob.LookupInt("TS_DNS_CACHE_SIZE"); ok {
+		if v < 0 {
+			return 0
+		}
+		return v
+	}
+	return cacheDefaultSize
+}
+
+// cacheMaxTTL returns the longest to cache a response for.
+func cacheMaxTTL() time.Duration {
+	if v, ok := envknob.LookupDuration("TS_DNS_CACHE_MAX_TTL"); ok && v > 0 {
+		return v
+	}
+	return cacheDefaultMaxTTL
+}
+
+// cacheKey is the part of a query that determines its response.
+type cacheKey struct {
+	name  dnsname.FQDN // lowercase
+	typ   dns.Type
+	class dns.Class
+	cd    bool // the Checking Disabled bit, for responses DNSSEC validation failed for
+
+	edns     bool   // whether the query has an OPT record
+	ednsSize uint16 // the UDP payload size of the OPT record, which the response is truncated to
+	do       bool   // the DNSSEC OK bit of the OPT record, for responses with DNSSEC records
+}
+
+// flagCD is the Checking Disabled bit of the fourth byte of a DNS
+// message, which golang.org/x/net/dns/dnsmessage doesn't parse.
+const flagCD = 0x10
+
+// cacheEntry is the container/list element type.
+type cacheEntry struct {
+	key     cacheKey
+	msg     dns.Message // the response; its TTLs are as of stored
+	stored  time.Time
+	expires time.Time
+}
+
+// dnsCache is an LRU cache of upstream responses to forwarded
+// queries, kept until their TTL expires.
+type dnsCache struct {
+	maxEntries int // zero disables caching
+	maxTTL     time.Duration
+
+	mu sync.Mutex
+	ll *list.List
+	m  map[cacheKey]*list.Element // of *cacheEntry
+}
+
+func newDNSCache(maxEntries int, maxTTL time.Duration) *dnsCache {
+	return &dnsCache{
+		maxEntries: maxEntries,
+		maxTTL:     maxTTL,
+		ll:         list.New(),
+		m:          make(map[cacheKey]*list.Element),
+	}
+}
+
+// cacheKeyForQuery returns the cache key for query, reporting false if
+// its response mustn't be cached, such as when it has more than one
+// question.
+func cacheKeyForQuery(query []byte) (k cacheKey, ok bool) {
+	var p dns.Parser
+	hdr, err := p.Start(query)
+	if err != nil || hdr.Response || hdr.OpCode != 0 {
+		return k, false
+	}
+	q, err := p.Question()
+	if err != nil {
+		return k, false
+	}
+	if _, err := p.Question(); err != dns.ErrSectionDone {
+		return k, false
+	}
+	name, err := dnsname.ToFQDN(rawNameToLower(q.Name.Data[:q.Name.Length]))
+	if err != nil {
+		return k, false
+	}
+	k = cacheKey{name: name, typ: q.Type, class: q.Class, cd: query[3]&flagCD != 0}
+	if err := p.SkipAllAnswers(); err != nil {
+		return k, false
+	}
+	if err := p.SkipAllAuthorities(); err != nil {
+		return k, false
+	}
+	for {
+		h, err := p.AdditionalHeader()
+		if err == dns.ErrSectionDone {
+			break
+		}
+		if err != nil {
+			return k, false
+		}
+		if h.Type == dns.TypeOPT {
+			k.edns = true
+			k.ednsSize = uint16(h.Class)
+			k.do = h.DNSSECAllowed()
+		}
+		if err := p.SkipAdditional(); err != nil {
+			return k, false
+		}
+	}
+	return k, true
+}
+
+// get returns the cached response for k to query as of now, with the
+// query's ID and question and the TTLs reduced by the time it's been
+// cached.
+func (c *dnsCache) get(now time.Time, k cacheKey, query []byte) (res []byte, ok bool) {
+	if c.maxEntries == 0 {
+		return nil, false
+	}
+	msg, ok := c.lookup(now, k)
+	if !ok {
+		metricDNSFwdCacheMiss.Add(1)
+		return nil, false
+	}
+	var p dns.Parser
+	hdr, err := p.Start(query)
+	if err != nil {
+		return nil, false
+	}
+	qs, err := p.AllQuestions()
+	if err != nil {
+		return nil, false
+	}
+	msg.ID = hdr.ID
+	msg.RecursionDesired = hdr.RecursionDesired
+	// Echo the question as asked, as some clients randomize the case
+	// of names and expect it back.
+	msg.Questions = qs
+	res, err = msg.Pack()
+	if err != nil {
+		return nil, false
+	}
+	metricDNSFwdCacheHit.Add(1)
+	return res, true
+}
+
+// lookup returns a copy of the unexpired response cached for k, with
+// its TTLs as of now.
+func (c *dnsCache) lookup(now time.Time, k cacheKey) (msg dns.Message, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.m[k]
+	if !ok {
+		return msg, false
+	}
+	ent := e.Value.(*cacheEntry)
+	if !now.Before(ent.expires) {
+		c.removeElementLocked(e)
+		return msg, false
+	}
+	c.ll.MoveToFront(e)
+	age := uint32(now.Sub(ent.stored) / time.Second)
+	msg = ent.msg
+	msg.Answers = ageResources(ent.msg.Answers, age)
+	msg.Authorities = ageResources(ent.msg.Authorities, age)
+	msg.Additionals = ageResources(ent.msg.Additionals, age)
+	return msg, true
+}
+
+// ageResources returns a copy of rs with their TTLs reduced by age
+// seconds.
+func ageResources(rs []dns.Resource, age uint32) []dns.Resource {
+	if len(rs) == 0 {
+		return nil
+	}
+	ret := append([]dns.Resource(nil), rs...)
+	for i := range ret {
+		h := &ret[i].Header
+		if h.Type == dns.TypeOPT {
+			// The TTL of an OPT record holds flags instead.
+			continue
+		}
+		if h.TTL > age {
+			h.TTL -= age
+		} else {
+			h.TTL = 0
+		}
+	}
+	return ret
+}
+
+// put caches res, the response to the query with key k received at
+// now, if it's cacheable.
+func (c *dnsCache) put(now time.Time, k cacheKey, res []byte) {
+	if c.maxEntries == 0 {
+		return
+	}
+	var msg dns.Message
+	if err := msg.Unpack(res); err != nil {
+		return
+	}
+	ttl := c.ttl(&msg)
+	if ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ent := &cacheEntry{key: k, msg: msg, stored: now, expires: now.Add(ttl)}
+	if e, ok := c.m[k]; ok {
+		e.Value = ent
+		c.ll.MoveToFront(e)
+		return
+	}
+	c.m[k] = c.ll.PushFront(ent)
+	for c.ll.Len() > c.maxEntries {
+		c.removeElementLocked(c.ll.Back())
+	}
+}
+
+// ttl returns how long to cache msg for, or zero if it mustn't be.
+//
+// Responses with answers are cached for their lowest TTL. NXDOMAIN and
+// empty responses are cached as RFC 2308 says, for the lower of the
+// SOA record's TTL and minimum, if the authority section has one.
+func (c *dnsCache) ttl(msg *dns.Message) time.Duration {
+	if msg.Truncated {
+		return 0
+	}
+	var ttl time.Duration
+	switch {
+	case msg.RCode == dns.RCodeSuccess && len(msg.Answers) > 0:
+		ttl = c.maxTTL
+		for _, r := range msg.Answers {
+			ttl = minDuration(ttl, time.Duration(r.Header.TTL)*time.Second)
+		}
+	case msg.RCode == dns.RCodeSuccess, msg.RCode == dns.RCodeNameError:
+		for _, r := range msg.Authorities {
+			soa, ok := r.Body.(*dns.SOAResource)
+			if !ok {
+				continue
+			}
+			ttl = minDuration(time.Duration(r.Header.TTL)*time.Second, time.Duration(soa.MinTTL)*time.Second)
+			ttl = minDuration(ttl, minDuration(c.maxTTL, cacheMaxNegativeTTL))
+			break
+		}
+	}
+	return ttl
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func (c *dnsCache) removeElementLocked(e *list.Element) {
+	c.ll.Remove(e)
+	delete(c.m, e.Value.(*cacheEntry).key)
+}
+
+// flush drops all cached responses.
+func (c *dnsCache) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	c.m = make(map[cacheKey]*list.Element)
+}
+
+// status returns the unexpired cached responses as of now, soonest to
+// expire first.
+func (c *dnsCache) status(now time.Time) *ipnstate.DNSCache {
+	st := &ipnstate.DNSCache{
+		MaxEntries: c.maxEntries,
+		MaxTTL:     c.maxTTL,
+		Hits:       metricDNSFwdCacheHit.Value(),
+		Misses:     metricDNSFwdCacheMiss.Value(),
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for e := c.ll.Front(); e != nil; e = e.Next() {
+		ent := e.Value.(*cacheEntry)
+		if !now.Before(ent.expires) {
+			continue
+		}
+		st.Entries = append(st.Entries, ipnstate.DNSCacheEntry{
+			Name:    string(ent.key.name),
+			Type:    strings.TrimPrefix(ent.key.typ.String(), "Type"),
+			RCode:   strings.TrimPrefix(ent.msg.RCode.String(), "RCode"),
+			Answers: len(ent.msg.Answers),
+			Expires: ent.expires,
+		})
+	}
+	sort.Slice(st.Entries, func(i, j int) bool {
+		return st.Entries[i].Expires.Before(st.Entries[j].Expires)
+	})
+	return st
+}
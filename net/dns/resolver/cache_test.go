@@ -0,0 +1,131 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package resolver
+
+import (
+	"testing"
+	"time"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+)
+
+func TestDNSCache(t *testing.T) {
+	t0 := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	at := func(sec int) time.Time { return t0.Add(time.Duration(sec) * time.Second) }
+
+	name := dns.MustNewName("test.example.com.")
+	response := func(rcode dns.RCode, answerTTL, soaTTL uint32) []byte {
+		msg := dns.Message{
+			Header:    dns.Header{ID: 1, Response: true, RCode: rcode},
+			Questions: []dns.Question{{Name: name, Type: dns.TypeA, Class: dns.ClassINET}},
+		}
+		if answerTTL > 0 {
+			msg.Answers = []dns.Resource{{
+				Header: dns.ResourceHeader{Name: name, Type: dns.TypeA, Class: dns.ClassINET, TTL: answerTTL},
+				Body:   &dns.AResource{A: [4]byte{1, 2, 3, 4}},
+			}}
+		}
+		if soaTTL > 0 {
+			zone := dns.MustNewName("example.com.")
+			msg.Authorities = []dns.Resource{{
+				Header: dns.ResourceHeader{Name: zone, Type: dns.TypeSOA, Class: dns.ClassINET, TTL: soaTTL},
+				Body:   &dns.SOAResource{NS: zone, MBox: zone, MinTTL: 60},
+			}}
+		}
+		bs, err := msg.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return bs
+	}
+
+	query := dnspacket("test.example.com.", dns.TypeA, noEdns)
+	k, ok := cacheKeyForQuery(query)
+	if !ok {
+		t.Fatal("query not cacheable")
+	}
+	ednsKey, _ := cacheKeyForQuery(dnspacket("TEST.example.com.", dns.TypeA, 1232))
+	if want := (cacheKey{name: k.name, typ: k.typ, class: k.class, edns: true, ednsSize: 1232}); ednsKey != want {
+		t.Errorf("EDNS key = %+v; want %+v", ednsKey, want)
+	}
+	// The DO and CD bits get responses with and without DNSSEC
+	// records and validation, so they're part of the key too.
+	doQuery := dnspacket("test.example.com.", dns.TypeA, 1232)
+	doQuery[len(doQuery)-4] |= 0x80 // the DO bit, first in the third byte of the OPT record's TTL
+	doKey, _ := cacheKeyForQuery(doQuery)
+	if want := (cacheKey{name: k.name, typ: k.typ, class: k.class, edns: true, ednsSize: 1232, do: true}); doKey != want {
+		t.Errorf("DO key = %+v; want %+v", doKey, want)
+	}
+	cdQuery := dnspacket("test.example.com.", dns.TypeA, noEdns)
+	cdQuery[3] |= flagCD
+	cdKey, _ := cacheKeyForQuery(cdQuery)
+	if want := (cacheKey{name: k.name, typ: k.typ, class: k.class, cd: true}); cdKey != want {
+		t.Errorf("CD key = %+v; want %+v", cdKey, want)
+	}
+
+	// get returns the cached answer with the query's ID and the TTL
+	// reduced by its age, until it expires.
+	c := newDNSCache(2, time.Hour)
+	c.put(at(0), k, response(dns.RCodeSuccess, 30, 0))
+	res, ok := c.get(at(10), k, query)
+	if !ok {
+		t.Fatal("cached answer missing")
+	}
+	var msg dns.Message
+	if err := msg.Unpack(res); err != nil {
+		t.Fatal(err)
+	}
+	if msg.ID != 0 {
+		t.Errorf("ID = %d; want the query's, 0", msg.ID)
+	}
+	if len(msg.Answers) != 1 || msg.Answers[0].Header.TTL != 20 {
+		t.Errorf("answers = %+v; want one with TTL 20", msg.Answers)
+	}
+	if _, ok := c.get(at(30), k, query); ok {
+		t.Error("expired answer returned")
+	}
+
+	// NXDOMAIN is cached for the lower of the SOA's TTL and minimum,
+	// and only if there's an SOA.
+	c.put(at(0), k, response(dns.RCodeNameError, 0, 0))
+	if _, ok := c.get(at(0), k, query); ok {
+		t.Error("NXDOMAIN without SOA cached")
+	}
+	c.put(at(0), k, response(dns.RCodeNameError, 0, 3600))
+	if _, ok := c.get(at(59), k, query); !ok {
+		t.Error("NXDOMAIN with SOA not cached")
+	}
+	if _, ok := c.get(at(60), k, query); ok {
+		t.Error("NXDOMAIN cached past the SOA minimum")
+	}
+
+	// SERVFAIL isn't cached.
+	c.put(at(0), k, response(dns.RCodeServerFailure, 0, 3600))
+	if _, ok := c.get(at(0), k, query); ok {
+		t.Error("SERVFAIL cached")
+	}
+
+	// The least recently used response is evicted past maxEntries.
+	k2, k3 := k, k
+	k2.typ, k3.typ = dns.TypeAAAA, dns.TypeTXT
+	c.put(at(0), k, response(dns.RCodeSuccess, 300, 0))
+	c.put(at(0), k2, response(dns.RCodeSuccess, 300, 0))
+	c.get(at(1), k, query)
+	c.put(at(2), k3, response(dns.RCodeSuccess, 300, 0))
+	if _, ok := c.lookup(at(3), k2); ok {
+		t.Error("least recently used response kept")
+	}
+	if _, ok := c.lookup(at(3), k); !ok {
+		t.Error("recently used response evicted")
+	}
+	if st := c.status(at(3)); len(st.Entries) != 2 {
+		t.Errorf("status has %d entries; want 2", len(st.Entries))
+	}
+
+	c.flush()
+	if _, ok := c.lookup(at(3), k); ok {
+		t.Error("response kept after flush")
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"tailscale.com/doctor"
+	"tailscale.com/types/logger"
+)
+
+func init() {
+	doctor.Register(doctor.InCategory(doctor.LightweightCheckFunc("dns-upstreams", runDoctorCheck), doctor.CategoryDNS))
+}
+
+// runDoctorCheck logs how each upstream resolver that queries were
+// forwarded to has been answering, warning about unhealthy ones.
+func runDoctorCheck(ctx context.Context, logf logger.Logf) error {
+	st := upstreams.status()
+	if len(st) == 0 {
+		logf("no queries forwarded upstream")
+		doctor.Report(ctx, doctor.SeverityOK, "no queries forwarded upstream", nil)
+		return nil
+	}
+	var unhealthy []string
+	for _, h := range st {
+		state := "healthy"
+		if !h.Healthy {
+			state = "unhealthy"
+			unhealthy = append(unhealthy, h.Addr)
+		}
+		msg := fmt.Sprintf("%s: %s; %d answered, %d failed", h.Addr, state, h.Answers, h.Failures)
+		if h.RTT != 0 {
+			msg += fmt.Sprintf("; rtt %v", h.RTT.Round(time.Millisecond))
+		}
+		if h.ConsecutiveFailures > 0 {
+			msg += fmt.Sprintf("; last %d failed, last error: %s", h.ConsecutiveFailures, h.LastError)
+		}
+		logf("%s", msg)
+	}
+	if len(unhealthy) > 0 {
+		doctor.Report(ctx, doctor.SeverityWarning, "unresponsive DNS resolvers: "+strings.Join(unhealthy, ", "), st)
+	} else {
+		doctor.Report(ctx, doctor.SeverityOK, fmt.Sprintf("%d DNS resolvers healthy", len(st)), st)
+	}
+	return nil
+}
@@ -17,11 +17,13 @@
 	"net/http"
 	"net/netip"
 	"net/url"
+	"reflect"
 	"runtime"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	dns "golang.org/x/net/dns/dnsmessage"
@@ -37,6 +39,8 @@
 	"tailscale.com/types/nettype"
 	"tailscale.com/util/cloudenv"
 	"tailscale.com/util/dnsname"
+	"tailscale.com/util/latencyhist"
+	"tailscale.com/util/watchdog"
 	"tailscale.com/version"
 	"tailscale.com/wgengine/monitor"
 )
@@ -165,6 +169,7 @@ func clampEDNSSize(packet []byte, maxSize uint16) {
 type route struct {
 	Suffix    dnsname.FQDN
 	Resolvers []resolverAndDelay
+	Strategy  dnstype.Strategy // empty means dnstype.StrategyRace
 }
 
 // resolverAndDelay is an upstream DNS resolver and a delay for how
@@ -187,6 +192,10 @@ type forwarder struct {
 	linkSel ForwardLinkSelector // TODO(bradfitz): remove this when tsdial.Dialer absords it
 	dialer  *tsdial.Dialer
 	dohSem  chan struct{}
+	hb      *watchdog.Heartbeat   // busy while forwarding queries
+	cache   *dnsCache             // responses to queries routed by suffix
+	health  *upstreamTracker      // health of the upstreams queried
+	lookups latencyhist.Histogram // time to the first upstream answer
 
 	ctx       context.Context    // good until Close
 	ctxCancel context.CancelFunc // closes ctx
@@ -244,16 +253,37 @@ func newForwarder(logf logger.Logf, linkMon *monitor.Mon, linkSel ForwardLinkSel
 		linkSel: linkSel,
 		dialer:  dialer,
 		dohSem:  make(chan struct{}, maxDoHInFlight(runtime.GOOS)),
+		cache:   newDNSCache(cacheSize(), cacheMaxTTL()),
+		health:  upstreams,
 	}
 	f.ctx, f.ctxCancel = context.WithCancel(context.Background())
+	f.hb = watchdog.Register("dns-forwarder", fwdWedgeTimeout, f.resetDoHClients)
 	return f
 }
 
+// fwdWedgeTimeout is how long queries may be in flight without any of
+// them finishing before the watchdog restarts the forwarder's DoH
+// clients.
+const fwdWedgeTimeout = time.Minute
+
 func (f *forwarder) Close() error {
 	f.ctxCancel()
+	f.hb.Close()
 	return nil
 }
 
+// resetDoHClients drops the DoH clients, so that later queries dial new
+// connections rather than waiting on ones that stopped answering.
+func (f *forwarder) resetDoHClients() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.logf("no query finished in %v; resetting %d DoH clients", fwdWedgeTimeout, len(f.dohClient))
+	for _, c := range f.dohClient {
+		c.CloseIdleConnections()
+	}
+	f.dohClient = nil
+}
+
 // resolversWithDelays maps from a set of DNS server names to a slice of a type
 // that included a startDelay, upgrading any well-known DoH (DNS-over-HTTP)
 // servers in the process, insert a DoH lookup first before UDP fallbacks.
@@ -338,7 +368,7 @@ func cloudResolvers() []resolverAndDelay {
 // Resolver.SetConfig on reconfig.
 //
 // The memory referenced by routesBySuffix should not be modified.
-func (f *forwarder) setRoutes(routesBySuffix map[dnsname.FQDN][]*dnstype.Resolver) {
+func (f *forwarder) setRoutes(routesBySuffix map[dnsname.FQDN][]*dnstype.Resolver, strategies map[dnsname.FQDN]dnstype.Strategy) {
 	routes := make([]route, 0, len(routesBySuffix))
 
 	cloudHostFallback := cloudResolvers()
@@ -352,6 +382,7 @@ func (f *forwarder) setRoutes(routesBySuffix map[dnsname.FQDN][]*dnstype.Resolve
 			routes = append(routes, route{
 				Suffix:    suffix,
 				Resolvers: resolversWithDelays(rs),
+				Strategy:  strategies[suffix],
 			})
 		}
 	}
@@ -372,6 +403,10 @@ func (f *forwarder) setRoutes(routesBySuffix map[dnsname.FQDN][]*dnstype.Resolve
 
 	f.mu.Lock()
 	defer f.mu.Unlock()
+	if !reflect.DeepEqual(routes, f.routes) {
+		// Cached responses may be from resolvers no longer in use.
+		f.cache.flush()
+	}
 	f.routes = routes
 	f.cloudHostFallback = cloudHostFallback
 }
@@ -526,6 +561,11 @@ func (f *forwarder) send(ctx context.Context, fq *forwardQuery, rr resolverAndDe
 
 var errServerFailure = errors.New("response code indicates server issue")
 
+// errSlowUpstream is the failure recorded for an upstream resolver that
+// didn't answer before one that was queried at least minHedgeDelay
+// after it. Losing a closer race than that isn't held against it.
+var errSlowUpstream = errors.New("slower to answer than an upstream queried later")
+
 func (f *forwarder) sendUDP(ctx context.Context, fq *forwardQuery, rr resolverAndDelay) (ret []byte, err error) {
 	ipp, ok := rr.name.IPPort()
 	if !ok {
@@ -620,18 +660,19 @@ func (f *forwarder) sendUDP(ctx context.Context, fq *forwardQuery, rr resolverAn
 	return out, nil
 }
 
-// resolvers returns the resolvers to use for domain.
-func (f *forwarder) resolvers(domain dnsname.FQDN) []resolverAndDelay {
+// resolvers returns the resolvers to use for domain, and how to query
+// them.
+func (f *forwarder) resolvers(domain dnsname.FQDN) ([]resolverAndDelay, dnstype.Strategy) {
 	f.mu.Lock()
 	routes := f.routes
 	cloudHostFallback := f.cloudHostFallback
 	f.mu.Unlock()
 	for _, route := range routes {
 		if route.Suffix == "." || route.Suffix.Contains(domain) {
-			return route.Resolvers
+			return route.Resolvers, route.Strategy
 		}
 	}
-	return cloudHostFallback // or nil if no fallback
+	return cloudHostFallback, "" // or nil if no fallback
 }
 
 // forwardQuery is information and state about a forwarded DNS query that's
@@ -668,6 +709,8 @@ type forwardQuery struct {
 // node DNS proxy queries), otherwise f.resolvers is used.
 func (f *forwarder) forwardWithDestChan(ctx context.Context, query packet, responseChan chan<- packet, resolvers ...resolverAndDelay) error {
 	metricDNSFwd.Add(1)
+	f.hb.Busy()
+	defer f.hb.Idle()
 	domain, err := nameFromQuery(query.bs)
 	if err != nil {
 		metricDNSFwdErrorName.Add(1)
@@ -705,8 +748,33 @@ func (f *forwarder) forwardWithDestChan(ctx context.Context, query packet, respo
 
 	clampEDNSSize(query.bs, maxResponseBytes)
 
+	// Only cache responses from the resolvers routed to by suffix, as
+	// explicit ones (for exit node DNS proxy queries) can answer
+	// differently.
+	var ck cacheKey
+	useCache := false
 	if len(resolvers) == 0 {
-		resolvers = f.resolvers(domain)
+		ck, useCache = cacheKeyForQuery(query.bs)
+	}
+	if useCache {
+		if res, ok := f.cache.get(time.Now(), ck, query.bs); ok {
+			select {
+			case <-ctx.Done():
+				metricDNSFwdErrorContext.Add(1)
+				return ctx.Err()
+			case responseChan <- packet{res, query.addr}:
+				metricDNSFwdSuccess.Add(1)
+				return nil
+			}
+		}
+	}
+
+	if len(resolvers) == 0 {
+		var strategy dnstype.Strategy
+		resolvers, strategy = f.resolvers(domain)
+		if strategy == dnstype.StrategyFastest {
+			resolvers = f.health.order(time.Now(), resolvers)
+		}
 		if len(resolvers) == 0 {
 			metricDNSFwdErrorNoUpstream.Add(1)
 			f.logf("no upstream resolvers set, returning SERVFAIL")
@@ -733,21 +801,45 @@ func (f *forwarder) forwardWithDestChan(ctx context.Context, query packet, respo
 	}
 	defer fq.closeOnCtxDone.Close()
 
+	start := time.Now()
 	resc := make(chan []byte, 1) // it's fine buffered or not
 	errc := make(chan error, 1)  // it's fine buffered or not too
+	// Each failure starts one of the delayed resolvers right away,
+	// rather than after its delay.
+	failed := make(chan struct{}, len(resolvers))
+	// winnerSent is when the query was sent to the resolver that
+	// answered first, in Unix nanoseconds.
+	var winnerSent atomic.Int64
 	for i := range resolvers {
 		go func(rr *resolverAndDelay) {
 			if rr.startDelay > 0 {
 				timer := time.NewTimer(rr.startDelay)
 				select {
 				case <-timer.C:
+				case <-failed:
+					timer.Stop()
 				case <-ctx.Done():
 					timer.Stop()
 					return
 				}
 			}
+			sent := time.Now()
 			resb, err := f.send(ctx, fq, *rr)
-			if err != nil {
+			if err == nil {
+				winnerSent.CompareAndSwap(0, sent.UnixNano())
+				f.health.noteAnswer(rr.name.Addr, time.Now(), time.Since(sent))
+			} else {
+				switch ctxErr := ctx.Err(); {
+				case ctxErr == nil:
+					f.health.noteFailure(rr.name.Addr, time.Now(), err)
+					failed <- struct{}{}
+				case errors.Is(ctxErr, context.DeadlineExceeded):
+					f.health.noteFailure(rr.name.Addr, time.Now(), ctxErr)
+				case winnerSent.Load()-sent.UnixNano() >= int64(minHedgeDelay):
+					// A resolver queried well after it
+					// answered first.
+					f.health.noteFailure(rr.name.Addr, time.Now(), errSlowUpstream)
+				}
 				select {
 				case errc <- err:
 				case <-ctx.Done():
@@ -766,6 +858,10 @@ func (f *forwarder) forwardWithDestChan(ctx context.Context, query packet, respo
 	for {
 		select {
 		case v := <-resc:
+			f.lookups.Observe(time.Since(start))
+			if useCache {
+				f.cache.put(time.Now(), ck, v)
+			}
 			select {
 			case <-ctx.Done():
 				metricDNSFwdErrorContext.Add(1)
@@ -0,0 +1,223 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/latencyhist"
+)
+
+const (
+	// maxQueryClients is how many clients the stats of the queries
+	// to 100.100.100.100:53 are kept for. Beyond it, the client that
+	// queried least recently is forgotten.
+	maxQueryClients = 256
+
+	// slowQuery is how long a query must take to be counted as slow.
+	slowQuery = time.Second
+
+	// queryLatencyWindow is the window of the latency quantiles in
+	// the query stats.
+	queryLatencyWindow = latencyhist.MaxWindow
+)
+
+// The classes of errors counted in the query stats.
+const (
+	queryErrClosed    = "closed"     // the resolver is shut down
+	queryErrQueueFull = "queue-full" // too many queries in flight
+	queryErrMalformed = "malformed"  // not a valid query
+	queryErrTimeout   = "timeout"    // no upstream answered in time
+	queryErrUpstream  = "upstream"   // forwarding failed otherwise
+	queryErrServFail  = "servfail"   // answered with SERVFAIL
+	queryErrRefused   = "refused"    // answered with REFUSED
+)
+
+// queryStats counts the queries sent to the resolver by local clients,
+// those to 100.100.100.100:53 or its IPv6 equivalent, by client, with
+// the classes of errors and their latency, so that a broken DNS path
+// can be told apart from one broken client or upstream. The zero value
+// is ready to use.
+type queryStats struct {
+	latency latencyhist.Histogram // has its own lock
+
+	mu      sync.Mutex
+	since   time.Time // of the first query
+	queries int64
+	errors  map[string]int64 // by class
+	clients map[netip.Addr]*ipnstate.DNSQueryClient
+}
+
+// record counts a query from client that took d and failed with
+// errClass, or succeeded if it's empty. The latency of queries dropped
+// with queryErrQueueFull isn't counted.
+func (s *queryStats) record(now time.Time, client netip.Addr, d time.Duration, errClass string) {
+	if m := queryErrMetrics[errClass]; m != nil {
+		m.Add(1)
+	}
+	dropped := errClass == queryErrQueueFull
+	if !dropped {
+		metricDNSQueryLocalLatency.Add(d.Milliseconds())
+		if d >= slowQuery {
+			metricDNSQueryLocalSlow.Add(1)
+		}
+		s.latency.ObserveAt(now, d)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.since.IsZero() {
+		s.since = now
+	}
+	s.queries++
+	c, ok := s.clients[client]
+	if !ok {
+		if len(s.clients) >= maxQueryClients {
+			s.evictLocked()
+		}
+		if s.clients == nil {
+			s.clients = make(map[netip.Addr]*ipnstate.DNSQueryClient)
+		}
+		c = &ipnstate.DNSQueryClient{Addr: client}
+		s.clients[client] = c
+	}
+	c.Queries++
+	if !dropped {
+		c.TotalLatency += d
+	}
+	c.Last = now
+	if errClass == "" {
+		return
+	}
+	if s.errors == nil {
+		s.errors = make(map[string]int64)
+	}
+	s.errors[errClass]++
+	c.Errors++
+	if c.ErrorClasses == nil {
+		c.ErrorClasses = make(map[string]int64)
+	}
+	c.ErrorClasses[errClass]++
+	c.LastError = errClass
+}
+
+// evictLocked forgets the client that queried least recently.
+// s.mu must be held.
+func (s *queryStats) evictLocked() {
+	var oldest *ipnstate.DNSQueryClient
+	for _, c := range s.clients {
+		if oldest == nil || c.Last.Before(oldest.Last) {
+			oldest = c
+		}
+	}
+	if oldest != nil {
+		delete(s.clients, oldest.Addr)
+	}
+}
+
+// status returns the stats as of now, with the clients that queried
+// most first.
+func (s *queryStats) status(now time.Time) *ipnstate.DNSQueryStats {
+	snap := s.latency.Snapshot(now, queryLatencyWindow)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ret := &ipnstate.DNSQueryStats{
+		Since:         s.since,
+		Queries:       s.queries,
+		LatencyWindow: queryLatencyWindow,
+		P50:           snap.Quantile(0.5),
+		P90:           snap.Quantile(0.9),
+		P99:           snap.Quantile(0.99),
+	}
+	for class, n := range s.errors {
+		if ret.Errors == nil {
+			ret.Errors = make(map[string]int64)
+		}
+		ret.Errors[class] = n
+	}
+	for _, c := range s.clients {
+		cc := *c
+		if c.ErrorClasses != nil {
+			cc.ErrorClasses = make(map[string]int64, len(c.ErrorClasses))
+			for class, n := range c.ErrorClasses {
+				cc.ErrorClasses[class] = n
+			}
+		}
+		ret.Clients = append(ret.Clients, cc)
+	}
+	sort.Slice(ret.Clients, func(i, j int) bool {
+		a, b := ret.Clients[i], ret.Clients[j]
+		if a.Queries != b.Queries {
+			return a.Queries > b.Queries
+		}
+		return a.Addr.Less(b.Addr)
+	})
+	return ret
+}
+
+// queryErrClass returns the class of error of a query that Query
+// answered with resp and err, or the empty string if it succeeded.
+// NXDOMAIN isn't an error.
+func queryErrClass(resp []byte, err error) string {
+	switch {
+	case err == nil:
+		var p dns.Parser
+		h, err := p.Start(resp)
+		if err != nil {
+			return ""
+		}
+		switch h.RCode {
+		case dns.RCodeServerFailure:
+			return queryErrServFail
+		case dns.RCodeRefused:
+			return queryErrRefused
+		case dns.RCodeFormatError:
+			return queryErrMalformed
+		}
+		return ""
+	case errors.Is(err, net.ErrClosed):
+		return queryErrClosed
+	case errors.Is(err, errNotQuery):
+		return queryErrMalformed
+	case errors.Is(err, context.DeadlineExceeded):
+		return queryErrTimeout
+	}
+	return queryErrUpstream
+}
+
+// QueryStats returns the stats of the queries that local clients sent
+// to the resolver, at 100.100.100.100:53 or its IPv6 equivalent.
+func (r *Resolver) QueryStats() *ipnstate.DNSQueryStats {
+	return r.queries.status(time.Now())
+}
+
+// NoteQueryDropped counts a query from client that was dropped before
+// it reached the resolver, because too many were in flight, in the
+// stats returned by QueryStats.
+func (r *Resolver) NoteQueryDropped(client netip.AddrPort) {
+	r.queries.record(time.Now(), client.Addr(), 0, queryErrQueueFull)
+}
+
+var (
+	metricDNSQueryLocalLatency = clientmetric.NewCounter("dns_query_local_latency_ms")
+	metricDNSQueryLocalSlow    = clientmetric.NewCounter("dns_query_local_slow")
+
+	queryErrMetrics = map[string]*clientmetric.Metric{
+		queryErrMalformed: clientmetric.NewCounter("dns_query_local_error_malformed"),
+		queryErrTimeout:   clientmetric.NewCounter("dns_query_local_error_timeout"),
+		queryErrUpstream:  clientmetric.NewCounter("dns_query_local_error_upstream"),
+		queryErrServFail:  clientmetric.NewCounter("dns_query_local_error_servfail"),
+		queryErrRefused:   clientmetric.NewCounter("dns_query_local_error_refused"),
+	}
+)
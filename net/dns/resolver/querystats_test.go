@@ -0,0 +1,98 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+)
+
+func TestQueryErrClass(t *testing.T) {
+	response := func(rcode dns.RCode) []byte {
+		msg := dns.Message{Header: dns.Header{ID: 1, Response: true, RCode: rcode}}
+		bs, err := msg.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return bs
+	}
+	tests := []struct {
+		resp []byte
+		err  error
+		want string
+	}{
+		{response(dns.RCodeSuccess), nil, ""},
+		{response(dns.RCodeNameError), nil, ""},
+		{response(dns.RCodeServerFailure), nil, "servfail"},
+		{response(dns.RCodeRefused), nil, "refused"},
+		{response(dns.RCodeFormatError), nil, "malformed"},
+		{nil, net.ErrClosed, "closed"},
+		{nil, errNotQuery, "malformed"},
+		{nil, fmt.Errorf("forwarding: %w", context.DeadlineExceeded), "timeout"},
+		{response(dns.RCodeServerFailure), errServerFailure, "upstream"},
+		{nil, errors.New("no upstream"), "upstream"},
+	}
+	for _, tt := range tests {
+		if got := queryErrClass(tt.resp, tt.err); got != tt.want {
+			t.Errorf("queryErrClass(%v) = %q; want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestQueryStats(t *testing.T) {
+	t0 := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	a := netip.MustParseAddr("100.64.0.1")
+	b := netip.MustParseAddr("127.0.0.1")
+
+	var s queryStats
+	s.record(t0, a, 10*time.Millisecond, "")
+	s.record(t0.Add(time.Second), b, 30*time.Millisecond, "")
+	s.record(t0.Add(2*time.Second), b, 50*time.Millisecond, queryErrTimeout)
+	s.record(t0.Add(3*time.Second), b, 0, queryErrQueueFull)
+
+	st := s.status(t0.Add(4 * time.Second))
+	if !st.Since.Equal(t0) || st.Queries != 4 {
+		t.Errorf("since %v, %d queries; want %v, 4", st.Since, st.Queries, t0)
+	}
+	if want := map[string]int64{"timeout": 1, "queue-full": 1}; !reflect.DeepEqual(st.Errors, want) {
+		t.Errorf("errors = %v; want %v", st.Errors, want)
+	}
+	if len(st.Clients) != 2 {
+		t.Fatalf("got %d clients; want 2", len(st.Clients))
+	}
+	cb := st.Clients[0]
+	if cb.Addr != b || cb.Queries != 3 || cb.Errors != 2 || cb.LastError != "queue-full" {
+		t.Errorf("busiest client = %+v", cb)
+	}
+	if got, want := cb.MeanLatency(), 40*time.Millisecond; got != want {
+		t.Errorf("mean latency = %v; want %v", got, want)
+	}
+	if st.P50 <= 0 || st.P99 > 50*time.Millisecond {
+		t.Errorf("latency p50 %v, p99 %v", st.P50, st.P99)
+	}
+
+	// Forget the client that queried least recently.
+	for i := 0; i < maxQueryClients-1; i++ {
+		c := netip.AddrFrom4([4]byte{100, 64, 1, byte(i)})
+		s.record(t0.Add(time.Minute), c, time.Millisecond, "")
+	}
+	st = s.status(t0.Add(time.Minute))
+	if len(st.Clients) != maxQueryClients {
+		t.Fatalf("got %d clients; want %d", len(st.Clients), maxQueryClients)
+	}
+	for _, c := range st.Clients {
+		if c.Addr == a {
+			t.Errorf("least recent client %v kept", a)
+		}
+	}
+}
@@ -24,6 +24,7 @@
 	"time"
 
 	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/net/dns/resolvconffile"
 	"tailscale.com/net/netaddr"
 	"tailscale.com/net/tsaddr"
@@ -34,6 +35,7 @@
 	"tailscale.com/util/clientmetric"
 	"tailscale.com/util/cloudenv"
 	"tailscale.com/util/dnsname"
+	"tailscale.com/util/latencyhist"
 	"tailscale.com/wgengine/monitor"
 )
 
@@ -69,6 +71,9 @@ type Config struct {
 	// Queries only match the most specific suffix.
 	// To register a "default route", add an entry for ".".
 	Routes map[dnsname.FQDN][]*dnstype.Resolver
+	// RouteStrategies maps Routes keys to how queries are sent to
+	// their resolvers, if not dnstype.StrategyRace.
+	RouteStrategies map[dnsname.FQDN]dnstype.Strategy
 	// LocalHosts is a map of FQDNs to corresponding IPs.
 	Hosts map[dnsname.FQDN][]netip.Addr
 	// LocalDomains is a list of DNS name suffixes that should not be
@@ -184,6 +189,8 @@ type Resolver struct {
 	saveConfigForTests func(cfg Config) // used in tests to capture resolver config
 	// forwarder forwards requests to upstream nameservers.
 	forwarder *forwarder
+	// queries are the stats of the queries from local clients.
+	queries queryStats
 
 	// closed signals all goroutines to stop.
 	closed chan struct{}
@@ -237,7 +244,7 @@ func (r *Resolver) SetConfig(cfg Config) error {
 		}
 	}
 
-	r.forwarder.setRoutes(cfg.Routes)
+	r.forwarder.setRoutes(cfg.Routes, cfg.RouteStrategies)
 
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -261,12 +268,41 @@ func (r *Resolver) Close() {
 	r.forwarder.Close()
 }
 
+// CacheStatus returns the contents of the cache of responses to
+// forwarded queries.
+func (r *Resolver) CacheStatus() *ipnstate.DNSCache {
+	return r.forwarder.cache.status(time.Now())
+}
+
+// FlushCache drops all cached responses to forwarded queries.
+func (r *Resolver) FlushCache() {
+	r.forwarder.cache.flush()
+}
+
+// LookupLatencies returns the times it took to get answers to
+// forwarded queries from the upstream resolvers in the window before
+// now. Cached answers aren't counted.
+func (r *Resolver) LookupLatencies(now time.Time, window time.Duration) latencyhist.Snapshot {
+	return r.forwarder.lookups.Snapshot(now, window)
+}
+
 // dnsQueryTimeout is not intended to be user-visible (the users
 // DNS resolver will retry well before that), just put an upper
 // bound on per-query resource usage.
 const dnsQueryTimeout = 10 * time.Second
 
+// Query answers the DNS query bs from the local client at from, sent to
+// 100.100.100.100:53 or its IPv6 equivalent, and counts it in the
+// stats returned by QueryStats.
 func (r *Resolver) Query(ctx context.Context, bs []byte, from netip.AddrPort) ([]byte, error) {
+	start := time.Now()
+	resp, err := r.query(ctx, bs, from)
+	now := time.Now()
+	r.queries.record(now, from.Addr(), now.Sub(start), queryErrClass(resp, err))
+	return resp, err
+}
+
+func (r *Resolver) query(ctx context.Context, bs []byte, from netip.AddrPort) ([]byte, error) {
 	metricDNSQueryLocal.Add(1)
 	select {
 	case <-r.closed:
@@ -1250,6 +1286,8 @@ func unARPA(a string) (ipStr string, ok bool) {
 	metricDNSFwdSuccess              = clientmetric.NewCounter("dns_query_fwd_success")
 	metricDNSFwdErrorContext         = clientmetric.NewCounter("dns_query_fwd_error_context")
 	metricDNSFwdErrorContextGotError = clientmetric.NewCounter("dns_query_fwd_error_context_got_error")
+	metricDNSFwdCacheHit             = clientmetric.NewCounter("dns_query_fwd_cache_hit")
+	metricDNSFwdCacheMiss            = clientmetric.NewCounter("dns_query_fwd_cache_miss")
 
 	metricDNSFwdErrorType      = clientmetric.NewCounter("dns_query_fwd_error_type")
 	metricDNSFwdErrorParseAddr = clientmetric.NewCounter("dns_query_fwd_error_parse_addr")
@@ -0,0 +1,187 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package resolver
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// upstreamMaxFailures is how many queries in a row an upstream
+	// resolver can fail before it's considered unhealthy.
+	upstreamMaxFailures = 3
+
+	// upstreamProbeInterval is how often an unhealthy upstream is
+	// sent a query alongside the healthy ones anyway, to notice when
+	// it recovers.
+	upstreamProbeInterval = 30 * time.Second
+
+	// upstreamRTTWeight is the inverse of the weight of each new
+	// round-trip time in UpstreamHealth.RTT.
+	upstreamRTTWeight = 4
+
+	// The StrategyFastest hedge delays, after which the next upstream
+	// is also queried, are twice the RTT of the one before it, within
+	// these bounds. Upstreams that never answered get the maximum.
+	minHedgeDelay = 100 * time.Millisecond
+	maxHedgeDelay = time.Second
+)
+
+// UpstreamHealth is how an upstream resolver has been answering the
+// queries forwarded to it.
+type UpstreamHealth struct {
+	Addr string // the dnstype.Resolver.Addr
+
+	// Healthy is whether the upstream failed fewer than
+	// upstreamMaxFailures queries in a row.
+	Healthy bool
+
+	// RTT is the smoothed time the upstream takes to answer, or zero
+	// if it never answered.
+	RTT time.Duration `json:",omitempty"`
+
+	Answers             int64 // queries answered
+	Failures            int64 // queries failed or timed out
+	ConsecutiveFailures int   // failures since the last answer
+
+	LastAnswer  time.Time `json:",omitempty"`
+	LastFailure time.Time `json:",omitempty"`
+	LastError   string    `json:",omitempty"`
+
+	lastProbe time.Time // when last probed while unhealthy
+}
+
+// upstreamTracker tracks the health of upstream resolvers, keyed by
+// their addresses.
+type upstreamTracker struct {
+	mu sync.Mutex
+	m  map[string]*UpstreamHealth
+}
+
+// upstreams is the health of the upstreams of all forwarders, which
+// the dns-upstreams doctor check reports.
+var upstreams = new(upstreamTracker)
+
+func (t *upstreamTracker) getLocked(addr string) *UpstreamHealth {
+	h, ok := t.m[addr]
+	if !ok {
+		h = &UpstreamHealth{Addr: addr, Healthy: true}
+		if t.m == nil {
+			t.m = make(map[string]*UpstreamHealth)
+		}
+		t.m[addr] = h
+	}
+	return h
+}
+
+// noteAnswer records that addr answered a query at now after rtt.
+func (t *upstreamTracker) noteAnswer(addr string, now time.Time, rtt time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h := t.getLocked(addr)
+	h.Answers++
+	h.ConsecutiveFailures = 0
+	h.Healthy = true
+	h.LastAnswer = now
+	if h.RTT == 0 {
+		h.RTT = rtt
+	} else {
+		h.RTT += (rtt - h.RTT) / upstreamRTTWeight
+	}
+}
+
+// noteFailure records that addr failed a query at now with err.
+func (t *upstreamTracker) noteFailure(addr string, now time.Time, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h := t.getLocked(addr)
+	h.Failures++
+	h.ConsecutiveFailures++
+	h.Healthy = h.ConsecutiveFailures < upstreamMaxFailures
+	h.LastFailure = now
+	h.LastError = err.Error()
+}
+
+// order returns rs reordered and with start delays for
+// dnstype.StrategyFastest as of now: the healthy upstreams, fastest
+// first, each started a hedge delay after the one before it, then the
+// unhealthy ones. Unhealthy upstreams due a probe are started
+// immediately instead. If no upstream is healthy, rs is returned
+// unchanged, so that they're all raced.
+func (t *upstreamTracker) order(now time.Time, rs []resolverAndDelay) []resolverAndDelay {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	type cand struct {
+		rr  resolverAndDelay
+		rtt time.Duration
+	}
+	var healthy, probed, unhealthy []cand
+	for _, rr := range rs {
+		h := t.getLocked(rr.name.Addr)
+		switch {
+		case h.Healthy:
+			healthy = append(healthy, cand{rr, h.RTT})
+		case now.Sub(h.lastProbe) >= upstreamProbeInterval:
+			h.lastProbe = now
+			probed = append(probed, cand{rr, h.RTT})
+		default:
+			unhealthy = append(unhealthy, cand{rr, h.RTT})
+		}
+	}
+	if len(healthy) == 0 {
+		return rs
+	}
+	// Upstreams that never answered go first, to learn their RTT.
+	sort.SliceStable(healthy, func(i, j int) bool {
+		return healthy[i].rtt < healthy[j].rtt
+	})
+	ret := make([]resolverAndDelay, 0, len(rs))
+	var delay time.Duration
+	for _, c := range healthy {
+		c.rr.startDelay = delay
+		ret = append(ret, c.rr)
+		delay += hedgeDelay(c.rtt)
+	}
+	for _, c := range probed {
+		c.rr.startDelay = 0
+		ret = append(ret, c.rr)
+	}
+	for _, c := range unhealthy {
+		c.rr.startDelay = delay
+		ret = append(ret, c.rr)
+		delay += maxHedgeDelay
+	}
+	return ret
+}
+
+// hedgeDelay returns how long to wait for an upstream with the given
+// RTT to answer before also querying the next one.
+func hedgeDelay(rtt time.Duration) time.Duration {
+	if rtt == 0 {
+		return maxHedgeDelay
+	}
+	d := 2 * rtt
+	if d < minHedgeDelay {
+		return minHedgeDelay
+	}
+	if d > maxHedgeDelay {
+		return maxHedgeDelay
+	}
+	return d
+}
+
+// status returns the health of each upstream, sorted by address.
+func (t *upstreamTracker) status() []UpstreamHealth {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ret := make([]UpstreamHealth, 0, len(t.m))
+	for _, h := range t.m {
+		ret = append(ret, *h)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Addr < ret[j].Addr })
+	return ret
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package resolver
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"tailscale.com/types/dnstype"
+)
+
+func TestUpstreamOrder(t *testing.T) {
+	t0 := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	rs := resolversWithDelays([]*dnstype.Resolver{
+		{Addr: "10.0.0.1"},
+		{Addr: "10.0.0.2"},
+		{Addr: "10.0.0.3"},
+	})
+	order := func(tr *upstreamTracker, now time.Time) (got []string) {
+		for _, rr := range tr.order(now, rs) {
+			got = append(got, rr.name.Addr+"@"+rr.startDelay.String())
+		}
+		return got
+	}
+	check := func(name string, got []string, want ...string) {
+		t.Helper()
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %q; want %q", name, got, want)
+		}
+	}
+
+	var tr upstreamTracker
+	check("unknown", order(&tr, t0), "10.0.0.1@0s", "10.0.0.2@1s", "10.0.0.3@2s")
+
+	tr.noteAnswer("10.0.0.1", t0, 80*time.Millisecond)
+	tr.noteAnswer("10.0.0.2", t0, 20*time.Millisecond)
+	tr.noteAnswer("10.0.0.3", t0, 300*time.Millisecond)
+	check("fastest first", order(&tr, t0), "10.0.0.2@0s", "10.0.0.1@100ms", "10.0.0.3@260ms")
+
+	fail := errors.New("timeout")
+	for i := 0; i < upstreamMaxFailures; i++ {
+		tr.noteFailure("10.0.0.2", t0, fail)
+	}
+	check("unhealthy probed", order(&tr, t0), "10.0.0.1@0s", "10.0.0.3@160ms", "10.0.0.2@0s")
+	check("unhealthy last", order(&tr, t0.Add(time.Second)), "10.0.0.1@0s", "10.0.0.3@160ms", "10.0.0.2@760ms")
+	check("probed again", order(&tr, t0.Add(upstreamProbeInterval)), "10.0.0.1@0s", "10.0.0.3@160ms", "10.0.0.2@0s")
+
+	tr.noteAnswer("10.0.0.2", t0, 20*time.Millisecond)
+	check("recovered", order(&tr, t0), "10.0.0.2@0s", "10.0.0.1@100ms", "10.0.0.3@260ms")
+
+	for _, addr := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		for i := 0; i < upstreamMaxFailures; i++ {
+			tr.noteFailure(addr, t0, fail)
+		}
+	}
+	check("all unhealthy", order(&tr, t0), "10.0.0.1@0s", "10.0.0.2@0s", "10.0.0.3@0s")
+
+	st := tr.status()
+	if len(st) != 3 || st[1].Addr != "10.0.0.2" || st[1].Healthy || st[1].ConsecutiveFailures != upstreamMaxFailures || st[1].LastError != "timeout" {
+		t.Errorf("status = %+v", st)
+	}
+}
@@ -0,0 +1,287 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package gwmon periodically pings the local network's default gateway
+// and keeps a history of the latency and loss, so that problems with
+// the local network, such as bad Wi-Fi, can be told apart from those of
+// Tailscale.
+package gwmon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+
+	"tailscale.com/health"
+	"tailscale.com/net/interfaces"
+	"tailscale.com/net/ping"
+	"tailscale.com/types/logger"
+)
+
+const (
+	// roundInterval is how often the gateway is pinged.
+	roundInterval = 20 * time.Second
+
+	// pingsPerRound is how many pings are sent each round, one after
+	// the other.
+	pingsPerRound = 3
+
+	// pingTimeout is how long to wait for each reply before counting
+	// the ping as lost.
+	pingTimeout = time.Second
+
+	// historyPeriod is how long samples are kept for.
+	historyPeriod = time.Hour
+
+	// maxSamples is the number of samples kept.
+	maxSamples = pingsPerRound * int(historyPeriod/roundInterval)
+
+	// degradedWindow is how far back the samples that decide whether
+	// the local network is degraded go.
+	degradedWindow = 5 * time.Minute
+
+	// minDegradedSamples is the fewest samples in degradedWindow for
+	// the local network to be considered degraded, so that a couple of
+	// lost pings after the gateway changed don't raise a warning.
+	minDegradedSamples = 5 * pingsPerRound
+
+	// degradedLoss and degradedLatency are the packet loss and median
+	// latency to the gateway at or above which the local network is
+	// considered degraded. A healthy LAN or Wi-Fi network loses
+	// nothing and answers within a few milliseconds.
+	degradedLoss    = 0.2
+	degradedLatency = 100 * time.Millisecond
+)
+
+// Sample is the outcome of one ping of the gateway.
+type Sample struct {
+	Time time.Time
+	RTT  time.Duration `json:",omitempty"` // zero if Lost
+	Lost bool          `json:",omitempty"`
+}
+
+// Stats summarize the samples of a period.
+type Stats struct {
+	Gateway netip.Addr
+	Period  time.Duration // how far back the samples go
+	Samples int
+	Lost    int
+	P50     time.Duration // median RTT of the pings not lost
+	Max     time.Duration // highest RTT of the pings not lost
+}
+
+// Loss returns the fraction of the pings that were lost, from 0 to 1.
+func (s Stats) Loss() float64 {
+	if s.Samples == 0 {
+		return 0
+	}
+	return float64(s.Lost) / float64(s.Samples)
+}
+
+// Monitor pings the default gateway every roundInterval, recording the
+// latency and loss. While they're too high, it raises a "local network
+// degraded" health warning.
+type Monitor struct {
+	logf logger.Logf
+
+	// gateway returns the current IPv4 default gateway.
+	gateway func() (netip.Addr, bool)
+	// pingRound pings gw pingsPerRound times, returning the samples
+	// with their times unset. It returns an error if gw can't be
+	// pinged at all, such as when it's not permitted.
+	pingRound func(ctx context.Context, gw netip.Addr) ([]Sample, error)
+
+	mu       sync.Mutex
+	gw       netip.Addr
+	samples  []Sample // oldest first
+	answered bool     // whether gw ever answered a ping
+	lastErr  string   // of the last pingRound, to log changes only
+}
+
+// New returns a new Monitor, which doesn't ping until Run.
+func New(logf logger.Logf) *Monitor {
+	return &Monitor{
+		logf: logger.WithPrefix(logf, "gwmon: "),
+		gateway: func() (netip.Addr, bool) {
+			gw, _, ok := interfaces.LikelyHomeRouterIP()
+			return gw, ok
+		},
+		pingRound: pingGateway,
+	}
+}
+
+// Run pings the gateway until ctx is done, then clears m's health
+// warning.
+func (m *Monitor) Run(ctx context.Context) {
+	defer health.SetLocalNetworkHealth(nil)
+	t := time.NewTicker(roundInterval)
+	defer t.Stop()
+	for {
+		m.round(ctx, time.Now())
+		health.SetLocalNetworkHealth(m.healthError(time.Now()))
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// round pings the gateway once, recording the samples as taken at now.
+// The history is dropped when the gateway changes, as it's of another
+// network.
+func (m *Monitor) round(ctx context.Context, now time.Time) {
+	gw, ok := m.gateway()
+	m.mu.Lock()
+	if !ok {
+		gw = netip.Addr{}
+	}
+	if gw != m.gw {
+		if gw.IsValid() {
+			m.logf("gateway now %v", gw)
+		}
+		m.gw = gw
+		m.samples = nil
+		m.answered = false
+	}
+	m.mu.Unlock()
+	if !gw.IsValid() {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, pingsPerRound*pingTimeout+time.Second)
+	defer cancel()
+	ss, err := m.pingRound(ctx, gw)
+	if ctx.Err() != nil && ctx.Err() != context.DeadlineExceeded {
+		return // stopped
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if errStr := errString(err); errStr != m.lastErr {
+		if err != nil {
+			m.logf("can't ping gateway %v: %v", gw, err)
+		}
+		m.lastErr = errStr
+	}
+	if err != nil || gw != m.gw {
+		return
+	}
+	for _, s := range ss {
+		s.Time = now
+		m.samples = append(m.samples, s)
+		m.answered = m.answered || !s.Lost
+	}
+	if len(m.samples) > maxSamples {
+		m.samples = append(m.samples[:0], m.samples[len(m.samples)-maxSamples:]...)
+	}
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// pingGateway is the default Monitor.pingRound, sending ICMP echo
+// requests, which requires privileges on most platforms.
+func pingGateway(ctx context.Context, gw netip.Addr) ([]Sample, error) {
+	if !gw.Is4() {
+		return nil, errors.New("only IPv4 gateways can be pinged")
+	}
+	p, err := ping.New(ctx, logger.Discard)
+	if err != nil {
+		return nil, err
+	}
+	defer p.Close()
+	dst := &net.IPAddr{IP: gw.AsSlice()}
+	var ret []Sample
+	for i := 0; i < pingsPerRound; i++ {
+		pctx, cancel := context.WithTimeout(ctx, pingTimeout)
+		rtt, err := p.Send(pctx, dst, nil)
+		cancel()
+		if ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			ret = append(ret, Sample{Lost: true})
+		} else {
+			ret = append(ret, Sample{RTT: rtt})
+		}
+	}
+	return ret, nil
+}
+
+// Stats returns the stats of the samples of the last period as of now.
+func (m *Monitor) Stats(now time.Time, period time.Duration) Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st := Stats{Gateway: m.gw, Period: period}
+	var rtts []time.Duration
+	for _, s := range m.samples {
+		if now.Sub(s.Time) >= period {
+			continue
+		}
+		st.Samples++
+		if s.Lost {
+			st.Lost++
+		} else {
+			rtts = append(rtts, s.RTT)
+		}
+	}
+	if len(rtts) > 0 {
+		sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+		st.P50 = rtts[len(rtts)/2]
+		st.Max = rtts[len(rtts)-1]
+	}
+	return st
+}
+
+// healthError returns an error describing how the local network is
+// degraded as of now, or nil if it isn't. A gateway that never
+// answered doesn't count as degraded, as many are set not to answer
+// pings at all.
+func (m *Monitor) healthError(now time.Time) error {
+	m.mu.Lock()
+	answered := m.answered
+	m.mu.Unlock()
+	if !answered {
+		return nil
+	}
+	st := m.Stats(now, degradedWindow)
+	if st.Samples < minDegradedSamples {
+		return nil
+	}
+	if st.Loss() < degradedLoss && st.P50 < degradedLatency {
+		return nil
+	}
+	return fmt.Errorf("local network degraded: %s; problems reaching peers may be due to the LAN or Wi-Fi rather than Tailscale", st)
+}
+
+// String returns a one-line summary of st, such as "0% loss to gateway
+// 192.168.1.1 over 5m0s, latency p50 2ms max 9ms".
+func (st Stats) String() string {
+	s := fmt.Sprintf("%.0f%% loss to gateway %v over %v", 100*st.Loss(), st.Gateway, st.Period)
+	if st.Lost < st.Samples {
+		s += fmt.Sprintf(", latency p50 %v max %v", st.P50.Round(time.Millisecond/10), st.Max.Round(time.Millisecond/10))
+	}
+	return s
+}
+
+// LogSummary logs the stats of the last degradedWindow and of the whole
+// history to logf, for bug reports.
+func (m *Monitor) LogSummary(logf logger.Logf) {
+	now := time.Now()
+	if st := m.Stats(now, historyPeriod); st.Samples == 0 {
+		logf("no samples")
+	} else {
+		logf("%v; %v", m.Stats(now, degradedWindow), st)
+	}
+}
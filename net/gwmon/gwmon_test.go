@@ -0,0 +1,114 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gwmon
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestMonitor(t *testing.T) {
+	t0 := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	gw := netip.MustParseAddr("192.168.1.1")
+	var (
+		rtt     time.Duration
+		lost    int // of each round's pings
+		pingErr error
+	)
+	m := &Monitor{
+		logf:    t.Logf,
+		gateway: func() (netip.Addr, bool) { return gw, gw.IsValid() },
+		pingRound: func(ctx context.Context, gw netip.Addr) ([]Sample, error) {
+			if pingErr != nil {
+				return nil, pingErr
+			}
+			ss := make([]Sample, pingsPerRound)
+			for i := range ss {
+				if i < lost {
+					ss[i].Lost = true
+				} else {
+					ss[i].RTT = rtt
+				}
+			}
+			return ss, nil
+		},
+	}
+	ctx := context.Background()
+	now := t0
+	rounds := func(n int) {
+		for i := 0; i < n; i++ {
+			now = now.Add(roundInterval)
+			m.round(ctx, now)
+		}
+	}
+	wantDegraded := func(name string, want bool) {
+		t.Helper()
+		err := m.healthError(now)
+		if got := err != nil; got != want {
+			t.Errorf("%s: degraded = %v (%v); want %v", name, got, err, want)
+		}
+	}
+
+	rtt = 2 * time.Millisecond
+	rounds(20)
+	wantDegraded("healthy", false)
+	if st := m.Stats(now, degradedWindow); st.Samples != 15*pingsPerRound || st.Lost != 0 || st.P50 != rtt || st.Gateway != gw {
+		t.Errorf("stats = %+v", st)
+	}
+
+	lost = 1
+	rounds(15)
+	wantDegraded("lossy", true)
+	if st := m.Stats(now, degradedWindow); st.Loss() < 0.33 || st.Loss() > 0.34 {
+		t.Errorf("loss = %v; want 1/3", st.Loss())
+	}
+
+	// A new gateway is a new network, with its history to be learned.
+	gw = netip.MustParseAddr("10.0.0.1")
+	lost = 0
+	rtt = 300 * time.Millisecond
+	rounds(1)
+	wantDegraded("new gateway", false)
+	rounds(4)
+	wantDegraded("slow", true)
+
+	// Failing to ping at all is no evidence either way.
+	rtt = 2 * time.Millisecond
+	pingErr = errors.New("operation not permitted")
+	rounds(15)
+	wantDegraded("can't ping", false)
+	if st := m.Stats(now, historyPeriod); st.Samples != 5*pingsPerRound {
+		t.Errorf("samples = %d; want %d", st.Samples, 5*pingsPerRound)
+	}
+
+	// Nor is a gateway that doesn't answer pings, until it has.
+	pingErr = nil
+	gw = netip.MustParseAddr("10.0.0.2")
+	lost = pingsPerRound
+	rounds(15)
+	wantDegraded("never answered", false)
+	lost = 0
+	rounds(1)
+	lost = pingsPerRound
+	rounds(14)
+	wantDegraded("stopped answering", true)
+	lost = 0
+
+	gw = netip.Addr{}
+	rounds(1)
+	if st := m.Stats(now, historyPeriod); st.Samples != 0 {
+		t.Errorf("samples without gateway = %d; want 0", st.Samples)
+	}
+
+	pingErr = nil
+	gw = netip.MustParseAddr("192.168.1.1")
+	rounds(2 * maxSamples / pingsPerRound)
+	if len(m.samples) != maxSamples {
+		t.Errorf("kept %d samples; want %d", len(m.samples), maxSamples)
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package hostfw generates, and on request applies, the host firewall
+// rules that allow inbound UDP to tailscaled's port, which direct
+// (non-DERP) connections to this machine need.
+//
+// Tailscale doesn't otherwise touch the host's own firewall, so
+// nothing here runs unless a user asks for it.
+package hostfw
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"tailscale.com/doctor"
+	"tailscale.com/types/logger"
+)
+
+// Backends, as used in Plan.Backend.
+const (
+	BackendFirewalld = "firewalld"
+	BackendNftables  = "nftables"
+	BackendIptables  = "iptables"
+	BackendPF        = "pf"
+	BackendWindows   = "windows" // Windows Defender Firewall
+)
+
+// ruleComment identifies the rules added by this package, where the
+// backend supports comments or names.
+const ruleComment = "tailscale-direct"
+
+// ErrNoFirewall is returned by NewPlan when no host firewall that could
+// block tailscaled's port is detected.
+var ErrNoFirewall = errors.New("no host firewall detected")
+
+// Plan is the set of host firewall rules that allow inbound UDP to
+// tailscaled's port.
+type Plan struct {
+	Backend string
+	Port    uint16
+
+	// Commands are the commands that add the rules, in order.
+	Commands []Command
+
+	// Installed is whether the rules already exist.
+	Installed bool
+
+	// Notes are things the user needs to know or do themselves for the
+	// rules to take effect.
+	Notes []string `json:",omitempty"`
+}
+
+// Command is a command that changes the host firewall.
+type Command struct {
+	Args  []string
+	Stdin string `json:",omitempty"`
+}
+
+// String returns c as a shell command.
+func (c Command) String() string {
+	var sb strings.Builder
+	for i, a := range c.Args {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(shellQuote(a))
+	}
+	if c.Stdin != "" {
+		sb.WriteString(" <<'EOF'\n")
+		sb.WriteString(c.Stdin)
+		if !strings.HasSuffix(c.Stdin, "\n") {
+			sb.WriteByte('\n')
+		}
+		sb.WriteString("EOF")
+	}
+	return sb.String()
+}
+
+// shellQuote quotes s for a POSIX shell, if needed.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_=/.,:+@%") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// NewPlan detects the host firewall and returns the plan that allows
+// inbound UDP to port through it. It returns ErrNoFirewall if no
+// firewall is detected.
+func NewPlan(ctx context.Context, port uint16) (*Plan, error) {
+	if port == 0 {
+		return nil, errors.New("tailscaled has no UDP port")
+	}
+	return newPlan(ctx, port)
+}
+
+// Apply runs p's commands. It must run as root, or as an Administrator
+// on Windows.
+func (p *Plan) Apply(ctx context.Context, logf logger.Logf) error {
+	for _, c := range p.Commands {
+		logf("hostfw: running %s", c)
+		cmd := exec.CommandContext(ctx, c.Args[0], c.Args[1:]...)
+		if c.Stdin != "" {
+			cmd.Stdin = strings.NewReader(c.Stdin)
+		}
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("%s: %w: %s", c.Args[0], err, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
+
+// Check is a doctor.Check that verifies the host firewall allows
+// inbound UDP to tailscaled's port.
+type Check struct {
+	// Port is tailscaled's UDP port.
+	Port uint16
+}
+
+func (Check) Name() string {
+	return "host-firewall"
+}
+
+// Categories implements doctor.Categorized.
+func (Check) Categories() []doctor.Category {
+	return []doctor.Category{doctor.CategoryFirewall}
+}
+
+// Lightweight implements doctor.Lightweight: it only reads the host
+// firewall's rules.
+func (Check) Lightweight() bool { return true }
+
+// Platforms implements doctor.Platformer. Reading the firewall's rules
+// needs CAP_NET_ADMIN on Linux, and root for pf.
+func (Check) Platforms() doctor.Requirements {
+	switch runtime.GOOS {
+	case "darwin", "freebsd":
+		return doctor.Requirements{Root: true}
+	}
+	return doctor.Requirements{NetAdmin: true}
+}
+
+func (c Check) Run(ctx context.Context, logf logger.Logf) error {
+	p, err := NewPlan(ctx, c.Port)
+	if errors.Is(err, ErrNoFirewall) {
+		logf("no host firewall detected")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if p.Installed {
+		logf("%s allows UDP port %d", p.Backend, p.Port)
+		return nil
+	}
+	for _, cmd := range p.Commands {
+		logf("missing rule, added by: %s", cmd)
+	}
+	return fmt.Errorf("%s may block direct connections to UDP port %d; run 'tailscale debug host-firewall' to add the rules", p.Backend, p.Port)
+}
+
+// run runs the command name with args, returning its combined output.
+func run(ctx context.Context, name string, args ...string) (string, error) {
+	out, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+	return string(out), err
+}
+
+// have reports whether the command name is installed.
+func have(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package hostfw
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"tailscale.com/doctor/firewall"
+)
+
+func newPlan(ctx context.Context, port uint16) (*Plan, error) {
+	if out, err := run(ctx, "firewall-cmd", "--state"); err == nil && strings.TrimSpace(out) == "running" {
+		return firewalldPlan(ctx, port), nil
+	}
+	if have("nft") {
+		out, err := run(ctx, "nft", "list", "chains")
+		if err == nil {
+			if chains := nftInputChains(out); len(chains) > 0 {
+				return nftPlan(ctx, port, chains), nil
+			}
+		}
+	}
+	if have("iptables") {
+		out, err := run(ctx, "iptables", "-S", "INPUT")
+		if err == nil && !iptablesInputOpen(out) {
+			return iptablesPlan(ctx, port), nil
+		}
+	}
+	return nil, ErrNoFirewall
+}
+
+func firewalldPlan(ctx context.Context, port uint16) *Plan {
+	portSpec := fmt.Sprintf("--add-port=%d/udp", port)
+	p := &Plan{
+		Backend: BackendFirewalld,
+		Port:    port,
+		Commands: []Command{
+			{Args: []string{"firewall-cmd", portSpec}},
+			{Args: []string{"firewall-cmd", "--permanent", portSpec}},
+		},
+	}
+	_, err := run(ctx, "firewall-cmd", fmt.Sprintf("--query-port=%d/udp", port))
+	p.Installed = err == nil
+	return p
+}
+
+// nftChain is an nftables base chain.
+type nftChain struct {
+	family, table, chain string
+}
+
+// nftInputChains returns the filter chains hooked to input in the
+// output of "nft list chains", excluding those of iptables-nft, whose
+// rules iptablesPlan manages.
+func nftInputChains(out string) []nftChain {
+	var ret []nftChain
+	var family, table, chain string
+	sc := bufio.NewScanner(strings.NewReader(out))
+	for sc.Scan() {
+		f := strings.Fields(sc.Text())
+		switch {
+		case len(f) >= 3 && f[0] == "table":
+			family, table, chain = f[1], f[2], ""
+		case len(f) >= 2 && f[0] == "chain":
+			chain = f[1]
+		case len(f) >= 5 && f[0] == "type" && f[1] == "filter" && f[2] == "hook" && f[3] == "input":
+			if chain == "" || firewall.IsIptablesNFTTable(family+" "+table) {
+				continue
+			}
+			ret = append(ret, nftChain{family, table, chain})
+		}
+	}
+	return ret
+}
+
+func nftPlan(ctx context.Context, port uint16, chains []nftChain) *Plan {
+	p := &Plan{
+		Backend:   BackendNftables,
+		Port:      port,
+		Installed: true,
+	}
+	portStr := strconv.Itoa(int(port))
+	for _, c := range chains {
+		p.Commands = append(p.Commands, Command{Args: []string{
+			"nft", "insert", "rule", c.family, c.table, c.chain,
+			"udp", "dport", portStr, "accept", "comment", `"` + ruleComment + `"`,
+		}})
+		out, err := run(ctx, "nft", "list", "chain", c.family, c.table, c.chain)
+		if err != nil || !strings.Contains(out, "udp dport "+portStr+" accept comment \""+ruleComment+"\"") {
+			p.Installed = false
+		}
+	}
+	p.Notes = append(p.Notes, "nftables rules don't persist across reboots unless saved to the host's ruleset, such as /etc/nftables.conf")
+	return p
+}
+
+// iptablesInputOpen reports whether the output of "iptables -S INPUT"
+// shows a chain that accepts everything: no rules and an ACCEPT policy.
+func iptablesInputOpen(out string) bool {
+	sc := bufio.NewScanner(strings.NewReader(out))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case line == "" || line == "-P INPUT ACCEPT":
+		case strings.HasPrefix(line, "-A INPUT -j ts-input"):
+			// Tailscale's own chain only filters traffic on the
+			// Tailscale interface.
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func iptablesPlan(ctx context.Context, port uint16) *Plan {
+	p := &Plan{
+		Backend:   BackendIptables,
+		Port:      port,
+		Installed: true,
+	}
+	rule := []string{"INPUT", "-p", "udp", "--dport", strconv.Itoa(int(port)), "-m", "comment", "--comment", ruleComment, "-j", "ACCEPT"}
+	for _, ipt := range []string{"iptables", "ip6tables"} {
+		if !have(ipt) {
+			continue
+		}
+		p.Commands = append(p.Commands, Command{Args: append([]string{ipt, "-I"}, rule...)})
+		if _, err := run(ctx, ipt, append([]string{"-C"}, rule...)...); err != nil {
+			p.Installed = false
+		}
+	}
+	p.Notes = append(p.Notes, "iptables rules don't persist across reboots unless saved, such as with iptables-save or netfilter-persistent")
+	return p
+}
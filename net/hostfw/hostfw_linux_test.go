@@ -0,0 +1,56 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package hostfw
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNFTInputChains(t *testing.T) {
+	const out = `table ip filter {
+	chain INPUT {
+		type filter hook input priority filter; policy accept;
+	}
+}
+table inet filter {
+	chain input {
+		type filter hook input priority filter; policy drop;
+	}
+	chain forward {
+		type filter hook forward priority filter; policy drop;
+	}
+	chain helper {
+	}
+}
+table ip nat {
+	chain PREROUTING {
+		type nat hook prerouting priority dstnat; policy accept;
+	}
+}
+`
+	got := nftInputChains(out)
+	want := []nftChain{{"inet", "filter", "input"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v; want %+v", got, want)
+	}
+}
+
+func TestIptablesInputOpen(t *testing.T) {
+	tests := []struct {
+		out  string
+		want bool
+	}{
+		{"-P INPUT ACCEPT\n", true},
+		{"-P INPUT ACCEPT\n-A INPUT -j ts-input\n", true},
+		{"-P INPUT DROP\n", false},
+		{"-P INPUT ACCEPT\n-A INPUT -p tcp --dport 22 -j ACCEPT\n-A INPUT -j REJECT\n", false},
+	}
+	for _, tt := range tests {
+		if got := iptablesInputOpen(tt.out); got != tt.want {
+			t.Errorf("iptablesInputOpen(%q) = %v; want %v", tt.out, got, tt.want)
+		}
+	}
+}
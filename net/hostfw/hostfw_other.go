@@ -0,0 +1,14 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux && !windows && !darwin && !freebsd
+// +build !linux,!windows,!darwin,!freebsd
+
+package hostfw
+
+import "context"
+
+func newPlan(ctx context.Context, port uint16) (*Plan, error) {
+	return nil, ErrNoFirewall
+}
@@ -0,0 +1,47 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build darwin || freebsd
+// +build darwin freebsd
+
+package hostfw
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// pfAnchor returns the pf anchor the rules are loaded into. macOS's
+// default ruleset already includes the anchors under "com.apple/", so
+// rules there take effect without editing pf.conf.
+func pfAnchor() string {
+	if runtime.GOOS == "darwin" {
+		return "com.apple/" + ruleComment
+	}
+	return ruleComment
+}
+
+func newPlan(ctx context.Context, port uint16) (*Plan, error) {
+	out, err := run(ctx, "pfctl", "-s", "info")
+	if err != nil || !strings.Contains(out, "Status: Enabled") {
+		return nil, ErrNoFirewall
+	}
+	anchor := pfAnchor()
+	p := &Plan{
+		Backend: BackendPF,
+		Port:    port,
+		Commands: []Command{{
+			Args:  []string{"pfctl", "-a", anchor, "-f", "-"},
+			Stdin: fmt.Sprintf("pass in quick proto udp from any to any port %d\n", port),
+		}},
+	}
+	out, err = run(ctx, "pfctl", "-a", anchor, "-s", "rules")
+	p.Installed = err == nil && strings.Contains(out, fmt.Sprintf("port = %d", port))
+	if runtime.GOOS != "darwin" {
+		p.Notes = append(p.Notes, fmt.Sprintf("the main ruleset in /etc/pf.conf must include the line: anchor %q", anchor))
+	}
+	return p, nil
+}
@@ -0,0 +1,36 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package hostfw
+
+import "testing"
+
+func TestCommandString(t *testing.T) {
+	tests := []struct {
+		c    Command
+		want string
+	}{
+		{
+			Command{Args: []string{"firewall-cmd", "--permanent", "--add-port=41641/udp"}},
+			"firewall-cmd --permanent --add-port=41641/udp",
+		},
+		{
+			Command{Args: []string{"nft", "insert", "rule", "inet", "filter", "input", "udp", "dport", "41641", "accept", "comment", `"tailscale-direct"`}},
+			`nft insert rule inet filter input udp dport 41641 accept comment '"tailscale-direct"'`,
+		},
+		{
+			Command{Args: []string{"pfctl", "-a", "tailscale-direct", "-f", "-"}, Stdin: "pass in quick proto udp from any to any port 41641\n"},
+			"pfctl -a tailscale-direct -f - <<'EOF'\npass in quick proto udp from any to any port 41641\nEOF",
+		},
+		{
+			Command{Args: []string{"echo", "it's", ""}},
+			`echo 'it'\''s' ''`,
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.c.String(); got != tt.want {
+			t.Errorf("got %q; want %q", got, tt.want)
+		}
+	}
+}
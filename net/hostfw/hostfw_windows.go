@@ -0,0 +1,40 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package hostfw
+
+import (
+	"context"
+	"fmt"
+)
+
+// newPlan returns the plan for Windows Defender Firewall, which is on by
+// default, so it never returns ErrNoFirewall. Its rules are enforced by
+// the Windows Filtering Platform.
+func newPlan(ctx context.Context, port uint16) (*Plan, error) {
+	name := fmt.Sprintf("name=%s-udp-%d", ruleComment, port)
+	p := &Plan{
+		Backend: BackendWindows,
+		Port:    port,
+		Commands: []Command{{Args: []string{
+			"netsh", "advfirewall", "firewall", "add", "rule", name,
+			"dir=in", "action=allow", "protocol=UDP", fmt.Sprintf("localport=%d", port),
+		}}},
+	}
+	_, err := run(ctx, "netsh", "advfirewall", "firewall", "show", "rule", name)
+	p.Installed = err == nil
+	if !p.Installed {
+		// tailscaled's router adds a rule allowing inbound UDP to
+		// its own program, whatever the port; see
+		// wgengine/router/router_windows.go. netsh fails when no
+		// rule matches.
+		_, err := run(ctx, "netsh", "advfirewall", "firewall", "show", "rule", "name="+processRule, "dir=in")
+		p.Installed = err == nil
+	}
+	return p, nil
+}
+
+// processRule is the name of the rule tailscaled's router adds to
+// allow inbound UDP to tailscaled.
+const processRule = "Tailscale-Process"
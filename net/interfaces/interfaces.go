@@ -19,6 +19,7 @@
 	"tailscale.com/net/netaddr"
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/net/tshttpproxy"
+	"tailscale.com/types/opt"
 )
 
 // LoginEndpointForProxyDetermination is the URL used for testing
@@ -161,6 +162,7 @@ func LocalAddresses() (regular, loopback []netip.Addr, err error) {
 		}
 		regular6 = ula6
 	}
+	regular6 = preferStableIPv6(regular6)
 	regular = append(regular4, regular6...)
 	sortIPs(regular)
 	sortIPs(loopback)
@@ -460,6 +462,64 @@ func UseAllIPs(ips netip.Addr) bool { return true }
 
 func (s *State) HasPAC() bool { return s != nil && s.PAC != "" }
 
+// Class describes how an interface is treated when computing a State.
+type Class string
+
+const (
+	ClassTailscale     Class = "tailscale"     // Tailscale's own interface; ignored
+	ClassLoopback      Class = "loopback"      // a loopback interface
+	ClassDown          Class = "down"          // not up
+	ClassUsable        Class = "usable"        // has an address counted in HaveV4 or HaveV6
+	ClassUninteresting Class = "uninteresting" // up, but with no usable address
+	ClassUnknown       Class = "unknown"       // not in the State
+)
+
+// Class returns how the named interface is classified in s, which
+// determines whether its addresses count towards s.HaveV4 and s.HaveV6
+// and whether changes to it are considered link changes.
+func (s *State) Class(name string) Class {
+	iface, ok := s.Interface[name]
+	if !ok || iface.Interface == nil {
+		return ClassUnknown
+	}
+	pfxs := s.InterfaceIPs[name]
+	switch {
+	case isTailscaleInterface(name, pfxs):
+		return ClassTailscale
+	case iface.IsLoopback():
+		return ClassLoopback
+	case !iface.IsUp():
+		return ClassDown
+	}
+	for _, pfx := range pfxs {
+		if ip := pfx.Addr(); !ip.IsLoopback() && (isUsableV4(ip) || isUsableV6(ip)) {
+			return ClassUsable
+		}
+	}
+	return ClassUninteresting
+}
+
+// Subnets returns the subnets of the interfaces classified as
+// ClassUsable, by interface name: each of their addresses, other than
+// link-local ones, with its host bits zeroed, such as 192.168.1.0/24 for
+// 192.168.1.1/24. They're the routes a subnet router can advertise to
+// reach those interfaces' networks.
+func (s *State) Subnets() map[string][]netip.Prefix {
+	ret := map[string][]netip.Prefix{}
+	for name, pfxs := range s.InterfaceIPs {
+		if s.Class(name) != ClassUsable {
+			continue
+		}
+		for _, pfx := range pfxs {
+			if ip := pfx.Addr(); ip.IsLinkLocalUnicast() || ip.IsLoopback() {
+				continue
+			}
+			ret[name] = append(ret[name], pfx.Masked())
+		}
+	}
+	return ret
+}
+
 // AnyInterfaceUp reports whether any interface seems like it has Internet access.
 func (s *State) AnyInterfaceUp() bool {
 	if runtime.GOOS == "js" {
@@ -580,6 +640,177 @@ func HTTPOfListener(ln net.Listener) string {
 
 var likelyHomeRouterIP func() (netip.Addr, bool)
 
+// temporaryIPv6Addrs, if non-nil, returns the machine's temporary IPv6
+// addresses. It's only set on platforms that can tell them apart from
+// stable addresses.
+var temporaryIPv6Addrs func() (map[netip.Addr]bool, error)
+
+// deprecatedIPv6Addrs, if non-nil, returns the machine's deprecated
+// IPv6 addresses. It's only set on platforms that report them.
+var deprecatedIPv6Addrs func() (map[netip.Addr]bool, error)
+
+// CanDetectTemporaryIPv6 reports whether TemporaryIPv6Addrs can tell
+// temporary IPv6 addresses apart on this platform.
+func CanDetectTemporaryIPv6() bool {
+	return temporaryIPv6Addrs != nil
+}
+
+// TemporaryIPv6Addrs returns the machine's temporary IPv6 addresses:
+// those made up by privacy extensions (RFC 8981), which the OS replaces
+// every few hours or sooner. If CanDetectTemporaryIPv6 reports false,
+// it returns no addresses.
+func TemporaryIPv6Addrs() (map[netip.Addr]bool, error) {
+	if temporaryIPv6Addrs == nil {
+		return nil, nil
+	}
+	return temporaryIPv6Addrs()
+}
+
+// DeprecatedIPv6Addrs returns the machine's deprecated IPv6 addresses:
+// those whose preferred lifetime has passed, which the OS keeps for
+// existing connections but shouldn't pick as the source of new ones.
+// On platforms that don't report them, it returns no addresses.
+func DeprecatedIPv6Addrs() (map[netip.Addr]bool, error) {
+	if deprecatedIPv6Addrs == nil {
+		return nil, nil
+	}
+	return deprecatedIPv6Addrs()
+}
+
+// L3Topology is how the network stack the process sees is divided up:
+// which Linux network namespace the process is in, the VRFs (L3 master
+// devices) in that namespace, and the bonds, bridges and teams (L2
+// master devices) that aggregate its interfaces.
+type L3Topology struct {
+	// Netns identifies the process's network namespace, as in
+	// "net:[4026531992]". It's empty if unknown.
+	Netns string
+	// InitNetns is whether Netns is the namespace of PID 1, which is
+	// the host's default namespace unless the process is in a
+	// container with its own PID namespace. It's unset if unknown.
+	InitNetns opt.Bool
+	// VRFs are the VRF devices in the namespace.
+	VRFs []VRF
+	// RawL3mdevAccept is whether raw sockets not bound to a VRF
+	// receive packets arriving on interfaces in any VRF, per the
+	// net.ipv4.raw_l3mdev_accept sysctl, which covers IPv6 as well.
+	RawL3mdevAccept bool
+	// L2Masters are the bond, bridge and team devices in the
+	// namespace. Packets received on their members are delivered to
+	// the IP stack as received on the master device.
+	L2Masters []L2Master
+}
+
+// VRF is a VRF device: an L3 master device whose member interfaces
+// route with their own routing table.
+type VRF struct {
+	Name    string
+	Index   int
+	Members []string // names of the interfaces enslaved to it
+}
+
+// L2Master is a bond, bridge or team device and the interfaces
+// enslaved to it.
+type L2Master struct {
+	Name string
+	// Kind is "bond", "bridge" or "team", or empty for other kinds of
+	// master device.
+	Kind    string
+	Members []string // names of the interfaces enslaved to it
+	// Active is, for a bond in active-backup mode, its active member.
+	// Its other members are passive: they drop what they receive
+	// until the bond fails over to them.
+	Active string
+	// Master is the name of the device that this one is itself
+	// enslaved to, such as a VRF or a bridge, or empty if none.
+	Master string
+}
+
+// String returns a description of m, such as "bond bond0 (members
+// eth0, eth1; active eth1)".
+func (m L2Master) String() string {
+	kind := m.Kind
+	if kind == "" {
+		kind = "master"
+	}
+	s := fmt.Sprintf("%s %s (members %s", kind, m.Name, strings.Join(m.Members, ", "))
+	if len(m.Members) == 0 {
+		s = fmt.Sprintf("%s %s (no members", kind, m.Name)
+	}
+	if m.Active != "" {
+		s += "; active " + m.Active
+	}
+	return s + ")"
+}
+
+// VRFOf returns the name of the VRF that the named interface is in,
+// directly or through the L2 master devices it's enslaved to, or the
+// empty string if it's in none.
+func (t *L3Topology) VRFOf(name string) string {
+	seen := map[string]bool{}
+	for name != "" && !seen[name] {
+		seen[name] = true
+		for _, vrf := range t.VRFs {
+			for _, m := range vrf.Members {
+				if m == name {
+					return vrf.Name
+				}
+			}
+		}
+		name = t.masterOf(name)
+	}
+	return ""
+}
+
+// masterOf returns the name of the L2 master device the named interface
+// is enslaved to, or the empty string if none.
+func (t *L3Topology) masterOf(name string) string {
+	for _, m := range t.L2Masters {
+		for _, mem := range m.Members {
+			if mem == name {
+				return m.Name
+			}
+		}
+	}
+	return ""
+}
+
+// l3Topology, if non-nil, returns the platform's L3Topology. It's
+// only set on Linux.
+var l3Topology func() (*L3Topology, error)
+
+// GetL3Topology returns the network namespace and VRFs the process
+// sees. On platforms without either, it returns an empty L3Topology.
+func GetL3Topology() (*L3Topology, error) {
+	if l3Topology == nil {
+		return &L3Topology{}, nil
+	}
+	return l3Topology()
+}
+
+// preferStableIPv6 returns ips without its temporary IPv6 addresses,
+// unless they're all temporary. Temporary addresses make poor
+// endpoints, as they change whenever the OS rotates them.
+func preferStableIPv6(ips []netip.Addr) []netip.Addr {
+	if len(ips) == 0 {
+		return ips
+	}
+	tmp, err := TemporaryIPv6Addrs()
+	if err != nil || len(tmp) == 0 {
+		return ips
+	}
+	var stable []netip.Addr
+	for _, ip := range ips {
+		if !tmp[ip] {
+			stable = append(stable, ip)
+		}
+	}
+	if len(stable) == 0 {
+		return ips
+	}
+	return stable
+}
+
 // LikelyHomeRouterIP returns the likely IP of the residential router,
 // which will always be an IPv4 private address, if found.
 // In addition, it returns the IP address of the current machine on
@@ -7,6 +7,7 @@
 import (
 	"bufio"
 	"bytes"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -15,7 +16,9 @@
 	"net/netip"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync/atomic"
 
@@ -29,6 +32,9 @@
 
 func init() {
 	likelyHomeRouterIP = likelyHomeRouterIPLinux
+	temporaryIPv6Addrs = temporaryIPv6AddrsLinux
+	deprecatedIPv6Addrs = deprecatedIPv6AddrsLinux
+	l3Topology = l3TopologyLinux
 }
 
 var procNetRouteErr atomic.Bool
@@ -309,3 +315,156 @@ func defaultRouteInterfaceAndroidIPRoute() (ifname string, err error) {
 	}
 	return ifname, nil
 }
+
+var procNetIfInet6Path = "/proc/net/if_inet6"
+
+// IPv6 address flags from linux/if_addr.h.
+const (
+	ifaFTemporary  = 0x01 // IFA_F_TEMPORARY
+	ifaFDeprecated = 0x20 // IFA_F_DEPRECATED
+)
+
+func temporaryIPv6AddrsLinux() (map[netip.Addr]bool, error) {
+	return ipv6AddrsWithFlagLinux(ifaFTemporary)
+}
+
+func deprecatedIPv6AddrsLinux() (map[netip.Addr]bool, error) {
+	return ipv6AddrsWithFlagLinux(ifaFDeprecated)
+}
+
+/*
+Parse the addresses with flag set out of /proc/net/if_inet6, whose
+columns are the address, interface index, prefix length, scope, flags
+and interface name:
+
+$ cat /proc/net/if_inet6
+20010db8000000001c2a3bfffe4d5e6f 02 64 00 00     eth0
+20010db800000000a1b2c3d4e5f60718 02 64 00 01     eth0
+fe80000000000000021c2afffe4d5e6f 02 64 20 80     eth0
+*/
+func ipv6AddrsWithFlagLinux(flag uint64) (map[netip.Addr]bool, error) {
+	ret := map[netip.Addr]bool{}
+	err := lineread.File(procNetIfInet6Path, func(line []byte) error {
+		f := strings.Fields(string(line))
+		if len(f) < 5 {
+			return nil
+		}
+		flags, err := strconv.ParseUint(f[4], 16, 32)
+		if err != nil || flags&flag == 0 {
+			return nil
+		}
+		b, err := hex.DecodeString(f[0])
+		if err != nil {
+			return nil
+		}
+		if ip, ok := netip.AddrFromSlice(b); ok && ip.Is6() {
+			ret[ip] = true
+		}
+		return nil
+	})
+	return ret, err
+}
+
+var (
+	sysClassNetPath     = "/sys/class/net"
+	procRawL3mdevAccept = "/proc/sys/net/ipv4/raw_l3mdev_accept"
+	procSelfNetnsPath   = "/proc/self/ns/net"
+	procInitNetnsPath   = "/proc/1/ns/net"
+)
+
+func l3TopologyLinux() (*L3Topology, error) {
+	t := &L3Topology{
+		// Kernels without VRF support lack the sysctl; as there
+		// are no VRFs, there's nothing for raw sockets to miss.
+		RawL3mdevAccept: true,
+	}
+	if self, err := os.Readlink(procSelfNetnsPath); err == nil {
+		t.Netns = self
+		// Reading PID 1's namespace needs privileges.
+		if init, err := os.Readlink(procInitNetnsPath); err == nil {
+			t.InitNetns.Set(self == init)
+		}
+	}
+	if b, err := os.ReadFile(procRawL3mdevAccept); err == nil {
+		t.RawL3mdevAccept = strings.TrimSpace(string(b)) != "0"
+	}
+	ents, err := os.ReadDir(sysClassNetPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range ents {
+		dir := filepath.Join(sysClassNetPath, e.Name())
+		uevent, err := os.ReadFile(filepath.Join(dir, "uevent"))
+		if err != nil {
+			continue
+		}
+		switch devType := ueventDevType(uevent); devType {
+		case "vrf":
+			vrf := VRF{Name: e.Name(), Members: lowerDevs(dir)}
+			if b, err := os.ReadFile(filepath.Join(dir, "ifindex")); err == nil {
+				vrf.Index, _ = strconv.Atoi(strings.TrimSpace(string(b)))
+			}
+			t.VRFs = append(t.VRFs, vrf)
+		case "bond", "bridge", "team":
+			m := L2Master{
+				Name:    e.Name(),
+				Kind:    devType,
+				Members: lowerDevs(dir),
+				Master:  masterDev(dir),
+			}
+			if devType == "bond" {
+				m.Active = bondActiveMember(dir)
+			}
+			t.L2Masters = append(t.L2Masters, m)
+		}
+	}
+	return t, nil
+}
+
+// lowerDevs returns the names of the members of the master device whose
+// sysfs directory is dir. A master device links to each of its members
+// as "lower_<name>".
+func lowerDevs(dir string) []string {
+	var ret []string
+	lowers, _ := filepath.Glob(filepath.Join(dir, "lower_*"))
+	for _, l := range lowers {
+		ret = append(ret, strings.TrimPrefix(filepath.Base(l), "lower_"))
+	}
+	return ret
+}
+
+// masterDev returns the name of the device that the device whose sysfs
+// directory is dir is enslaved to, or the empty string if none.
+func masterDev(dir string) string {
+	target, err := os.Readlink(filepath.Join(dir, "master"))
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(target)
+}
+
+// bondActiveMember returns the active member of the bond whose sysfs
+// directory is dir if it's in active-backup mode, or the empty string
+// otherwise. In the other modes, all members receive.
+func bondActiveMember(dir string) string {
+	mode, err := os.ReadFile(filepath.Join(dir, "bonding", "mode"))
+	if err != nil || !strings.HasPrefix(string(mode), "active-backup") {
+		return ""
+	}
+	active, err := os.ReadFile(filepath.Join(dir, "bonding", "active_slave"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(active))
+}
+
+// ueventDevType returns the DEVTYPE of a device's uevent file, such as
+// "vrf" or "bridge", or the empty string if it has none.
+func ueventDevType(uevent []byte) string {
+	for _, line := range strings.Split(string(uevent), "\n") {
+		if strings.HasPrefix(line, "DEVTYPE=") {
+			return strings.TrimPrefix(line, "DEVTYPE=")
+		}
+	}
+	return ""
+}
@@ -9,8 +9,10 @@
 	"fmt"
 	"io/fs"
 	"io/ioutil"
+	"net/netip"
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -120,3 +122,111 @@ func TestRouteLinuxNetlink(t *testing.T) {
 	}
 	t.Logf("Got: %+v", d)
 }
+
+func TestTemporaryIPv6AddrsLinux(t *testing.T) {
+	dir := t.TempDir()
+	savedProcNetIfInet6Path := procNetIfInet6Path
+	defer func() { procNetIfInet6Path = savedProcNetIfInet6Path }()
+	procNetIfInet6Path = filepath.Join(dir, "if_inet6")
+	buf := []byte("20010db8000000001c2a3bfffe4d5e6f 02 40 00 00     eth0\n" +
+		"20010db800000000a1b2c3d4e5f60718 02 40 00 01     eth0\n" +
+		"20010db800000000aaaabbbbccccdddd 02 40 00 21     eth0\n" +
+		"fe80000000000000021c2afffe4d5e6f 02 40 20 80     eth0\n" +
+		"00000000000000000000000000000001 01 80 10 80       lo\n")
+	if err := os.WriteFile(procNetIfInet6Path, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := temporaryIPv6AddrsLinux()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[netip.Addr]bool{
+		netip.MustParseAddr("2001:db8::a1b2:c3d4:e5f6:718"):  true,
+		netip.MustParseAddr("2001:db8::aaaa:bbbb:cccc:dddd"): true,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+
+	got, err = deprecatedIPv6AddrsLinux()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = map[netip.Addr]bool{
+		netip.MustParseAddr("2001:db8::aaaa:bbbb:cccc:dddd"): true,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("deprecated: got %v; want %v", got, want)
+	}
+}
+
+func TestL3TopologyLinux(t *testing.T) {
+	dir := t.TempDir()
+	defer func(a, b, c, d string) {
+		sysClassNetPath, procRawL3mdevAccept, procSelfNetnsPath, procInitNetnsPath = a, b, c, d
+	}(sysClassNetPath, procRawL3mdevAccept, procSelfNetnsPath, procInitNetnsPath)
+	sysClassNetPath = filepath.Join(dir, "net")
+	procRawL3mdevAccept = filepath.Join(dir, "raw_l3mdev_accept")
+	procSelfNetnsPath = filepath.Join(dir, "self-ns")
+	procInitNetnsPath = filepath.Join(dir, "init-ns")
+
+	mustWrite := func(name, contents string) {
+		t.Helper()
+		name = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustWrite("net/eth0/uevent", "INTERFACE=eth0\nIFINDEX=2\n")
+	mustWrite("net/br0/uevent", "DEVTYPE=bridge\nINTERFACE=br0\n")
+	mustWrite("net/blue/uevent", "DEVTYPE=vrf\nINTERFACE=blue\nIFINDEX=5\n")
+	mustWrite("net/blue/ifindex", "5\n")
+	mustWrite("net/blue/lower_eth1", "")
+	mustWrite("net/blue/lower_eth2", "")
+	mustWrite("net/br0/lower_eth3", "")
+	mustWrite("net/br0/lower_eth4", "")
+	mustWrite("net/bond0/uevent", "DEVTYPE=bond\nINTERFACE=bond0\n")
+	mustWrite("net/bond0/lower_eth5", "")
+	mustWrite("net/bond0/lower_eth6", "")
+	mustWrite("net/bond0/bonding/mode", "active-backup 1\n")
+	mustWrite("net/bond0/bonding/active_slave", "eth6\n")
+	mustWrite("net/blue/lower_bond0", "")
+	if err := os.Symlink("../blue", filepath.Join(sysClassNetPath, "bond0", "master")); err != nil {
+		t.Fatal(err)
+	}
+	mustWrite("raw_l3mdev_accept", "0\n")
+	if err := os.Symlink("net:[4026532000]", procSelfNetnsPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("net:[4026531992]", procInitNetnsPath); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := l3TopologyLinux()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &L3Topology{
+		Netns:     "net:[4026532000]",
+		InitNetns: "false",
+		VRFs: []VRF{
+			{Name: "blue", Index: 5, Members: []string{"bond0", "eth1", "eth2"}},
+		},
+		RawL3mdevAccept: false,
+		L2Masters: []L2Master{
+			{Name: "bond0", Kind: "bond", Members: []string{"eth5", "eth6"}, Active: "eth6", Master: "blue"},
+			{Name: "br0", Kind: "bridge", Members: []string{"eth3", "eth4"}},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v; want %+v", got, want)
+	}
+	for name, want := range map[string]string{"eth1": "blue", "bond0": "blue", "eth5": "blue", "eth3": "", "eth0": ""} {
+		if got := got.VRFOf(name); got != want {
+			t.Errorf("VRFOf(%q) = %q; want %q", name, got, want)
+		}
+	}
+}
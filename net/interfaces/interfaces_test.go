@@ -8,6 +8,7 @@
 	"encoding/json"
 	"net"
 	"net/netip"
+	"reflect"
 	"testing"
 )
 
@@ -155,3 +156,61 @@ func TestStateString(t *testing.T) {
 		})
 	}
 }
+
+func TestStateClass(t *testing.T) {
+	s := &State{
+		Interface: map[string]Interface{
+			"lo":         {Interface: &net.Interface{Flags: net.FlagUp | net.FlagLoopback}},
+			"eth0":       {Interface: &net.Interface{Flags: net.FlagUp}},
+			"eth1":       {Interface: &net.Interface{}},
+			"eth2":       {Interface: &net.Interface{Flags: net.FlagUp}},
+			"tailscale0": {Interface: &net.Interface{Flags: net.FlagUp}},
+		},
+		InterfaceIPs: map[string][]netip.Prefix{
+			"lo":         {netip.MustParsePrefix("127.0.0.1/8")},
+			"eth0":       {netip.MustParsePrefix("10.0.0.2/8")},
+			"eth1":       {netip.MustParsePrefix("10.1.0.2/16")},
+			"eth2":       {netip.MustParsePrefix("fe80::1/64")},
+			"tailscale0": {netip.MustParsePrefix("100.101.102.103/32")},
+		},
+	}
+	tests := []struct {
+		name string
+		want Class
+	}{
+		{"lo", ClassLoopback},
+		{"eth0", ClassUsable},
+		{"eth1", ClassDown},
+		{"eth2", ClassUninteresting},
+		{"tailscale0", ClassTailscale},
+		{"nope", ClassUnknown},
+	}
+	for _, tt := range tests {
+		if got := s.Class(tt.name); got != tt.want {
+			t.Errorf("Class(%q) = %q; want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStateSubnets(t *testing.T) {
+	s := &State{
+		Interface: map[string]Interface{
+			"lo":         {Interface: &net.Interface{Flags: net.FlagUp | net.FlagLoopback}},
+			"eth0":       {Interface: &net.Interface{Flags: net.FlagUp}},
+			"eth1":       {Interface: &net.Interface{}},
+			"tailscale0": {Interface: &net.Interface{Flags: net.FlagUp}},
+		},
+		InterfaceIPs: map[string][]netip.Prefix{
+			"lo":         {netip.MustParsePrefix("127.0.0.1/8")},
+			"eth0":       {netip.MustParsePrefix("192.168.1.1/24"), netip.MustParsePrefix("fe80::1/64"), netip.MustParsePrefix("2001:db8::1/64")},
+			"eth1":       {netip.MustParsePrefix("10.1.0.2/16")},
+			"tailscale0": {netip.MustParsePrefix("100.101.102.103/32")},
+		},
+	}
+	want := map[string][]netip.Prefix{
+		"eth0": {netip.MustParsePrefix("192.168.1.0/24"), netip.MustParsePrefix("2001:db8::/64")},
+	}
+	if got := s.Subnets(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Subnets = %v; want %v", got, want)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netcheck
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"tailscale.com/net/netns"
+	"tailscale.com/net/tshttpproxy"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/opt"
+)
+
+// captivePortalTimeout bounds the captive portal check.
+const captivePortalTimeout = 3 * time.Second
+
+// checkHTTPPath checks the HTTP path to the DERP region with the lowest
+// latency so far, recording in rs.report whether a captive portal
+// intercepts it and which HTTP proxy, if any, is used.
+func (c *Client) checkHTTPPath(ctx context.Context, rs *reportState, dm *tailcfg.DERPMap) {
+	rs.mu.Lock()
+	node := nearestDERPNode(dm, rs.report.RegionLatency)
+	rs.mu.Unlock()
+	if node == nil {
+		return
+	}
+
+	var proxy string
+	req, err := http.NewRequest("GET", "https://"+node.HostName+"/derp/latency-check", nil)
+	if err == nil {
+		if u, err := tshttpproxy.ProxyFromEnvironment(req); err == nil && u != nil {
+			u.User = nil
+			proxy = u.String()
+		}
+	}
+
+	captive, err := c.checkCaptivePortal(ctx, node)
+	if err != nil {
+		c.logf("[v1] netcheck: captive portal check of %v: %v", node.HostName, err)
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.report.CaptivePortal = captive
+	rs.report.HTTPProxy = proxy
+}
+
+// checkCaptivePortal requests /generate_204 from node over plain HTTP,
+// which DERP servers answer with an empty 204 response. Anything else,
+// such as a redirect to a login page, means a captive portal is
+// intercepting traffic. If the request fails, the result is empty.
+func (c *Client) checkCaptivePortal(ctx context.Context, node *tailcfg.DERPNode) (opt.Bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, captivePortalTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+node.HostName+"/generate_204", nil)
+	if err != nil {
+		return "", err
+	}
+	hc := &http.Client{
+		Transport: &http.Transport{
+			Proxy:       tshttpproxy.ProxyFromEnvironment,
+			DialContext: netns.NewDialer(c.logf).DialContext,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	defer hc.CloseIdleConnections()
+	res, err := hc.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 1<<10))
+	var ret opt.Bool
+	ret.Set(res.StatusCode != http.StatusNoContent || len(body) > 0)
+	if ret.EqualBool(true) {
+		c.vlogf("captive portal suspected: %s returned %s", req.URL, res.Status)
+	}
+	return ret, nil
+}
+
+// nearestDERPNode returns the first DERP node of the region with the
+// lowest latency, or of the lowest-numbered region if there are no
+// latencies. It returns nil if there are no DERP nodes.
+func nearestDERPNode(dm *tailcfg.DERPMap, latency map[int]time.Duration) *tailcfg.DERPNode {
+	var best *tailcfg.DERPRegion
+	for id, d := range latency {
+		r := dm.Regions[id]
+		if r == nil || !regionHasDERPNode(r) {
+			continue
+		}
+		if best == nil || d < latency[best.RegionID] || (d == latency[best.RegionID] && id < best.RegionID) {
+			best = r
+		}
+	}
+	if best == nil {
+		for _, id := range dm.RegionIDs() {
+			if r := dm.Regions[id]; regionHasDERPNode(r) {
+				best = r
+				break
+			}
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	for _, n := range best.Nodes {
+		if !n.STUNOnly {
+			return n
+		}
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netcheck
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"time"
+
+	"tailscale.com/net/netns"
+	"tailscale.com/net/stun"
+	"tailscale.com/tailcfg"
+)
+
+// mappingLifetimeFirstWait is the first idle period tested by
+// MeasureMappingLifetime. Later ones double it.
+const mappingLifetimeFirstWait = 5 * time.Second
+
+// MappingLifetime is how long the NAT in front of this machine keeps an
+// idle UDP mapping, as measured by MeasureMappingLifetime.
+type MappingLifetime struct {
+	// Survived is the longest idle period after which the mapping
+	// still existed, or zero if it never did.
+	Survived time.Duration
+
+	// Expired is the shortest idle period after which the mapping
+	// was gone, or zero if it never was.
+	Expired time.Duration
+}
+
+// MeasureMappingLifetime measures how long the NAT in front of this
+// machine keeps idle UDP mappings. It maps a socket with a STUN request
+// to the nearest DERP region's STUN server, as per the last report, and
+// after increasingly long idle periods up to max, checks whether the
+// mapping still exists. It takes up to about twice max.
+//
+// The check sends to the mapped address from a second socket, as a
+// peer would, so that it doesn't refresh or recreate the mapping
+// itself. That needs the NAT to hairpin; if it doesn't, the check
+// instead sends another STUN request from the mapped socket and
+// compares the mapped addresses, which misses expired mappings on NATs
+// that map the socket to the same port again.
+func (c *Client) MeasureMappingLifetime(ctx context.Context, dm *tailcfg.DERPMap, max time.Duration) (*MappingLifetime, error) {
+	c.mu.Lock()
+	var latency map[int]time.Duration
+	if c.last != nil {
+		latency = c.last.RegionLatency
+	}
+	node := nearestSTUNNode(dm, latency)
+	c.mu.Unlock()
+	if node == nil {
+		return nil, errors.New("no IPv4 STUN server in the DERP map")
+	}
+	server, err := stunServerAddr(ctx, node)
+	if err != nil {
+		return nil, err
+	}
+
+	pc, err := netns.Listener(c.logf).ListenPacket(ctx, "udp4", ":0")
+	if err != nil {
+		return nil, err
+	}
+	defer pc.Close()
+	probe, err := netns.Listener(c.logf).ListenPacket(ctx, "udp4", ":0")
+	if err != nil {
+		return nil, err
+	}
+	defer probe.Close()
+
+	first, err := stunMappedAddr(ctx, pc, server)
+	if err != nil {
+		return nil, err
+	}
+	hairpin, err := mappingReachable(ctx, probe, pc, first)
+	if err != nil {
+		return nil, err
+	}
+	if !hairpin {
+		c.vlogf("mapping lifetime: %v not reachable from a second socket; comparing mapped addresses instead", first)
+	}
+	ret := new(MappingLifetime)
+	for _, wait := range mappingLifetimeWaits(max) {
+		c.vlogf("mapping lifetime: %v mapped to %v; idling for %v", pc.LocalAddr(), first, wait)
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ret, ctx.Err()
+		case <-t.C:
+		}
+		if hairpin {
+			ok, err := mappingReachable(ctx, probe, pc, first)
+			if err != nil {
+				return ret, err
+			}
+			if !ok {
+				ret.Expired = wait
+				return ret, nil
+			}
+		}
+		// Without hairpinning, this is the check; with it, it starts
+		// the next idle period, in case the NAT only refreshes
+		// mappings on outbound packets.
+		got, err := stunMappedAddr(ctx, pc, server)
+		if err != nil {
+			return ret, err
+		}
+		if got != first {
+			ret.Expired = wait
+			return ret, nil
+		}
+		ret.Survived = wait
+	}
+	return ret, nil
+}
+
+// mappingReachable reports whether packets sent from probe to mapped,
+// the mapped address of pc, reach pc.
+func mappingReachable(ctx context.Context, probe, pc net.PacketConn, mapped netip.AddrPort) (bool, error) {
+	buf := make([]byte, 1500)
+	for try := 0; try < 3; try++ {
+		token := stun.NewTxID()
+		if _, err := probe.WriteTo(token[:], net.UDPAddrFromAddrPort(mapped)); err != nil {
+			return false, err
+		}
+		pc.SetReadDeadline(time.Now().Add(time.Second))
+		for {
+			n, _, err := pc.ReadFrom(buf)
+			if err != nil {
+				if errors.Is(err, os.ErrDeadlineExceeded) {
+					break
+				}
+				return false, err
+			}
+			if bytes.Equal(buf[:n], token[:]) {
+				return true, nil
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// mappingLifetimeWaits returns the idle periods that
+// MeasureMappingLifetime tests, up to max.
+func mappingLifetimeWaits(max time.Duration) []time.Duration {
+	if max < mappingLifetimeFirstWait {
+		return []time.Duration{max}
+	}
+	var ret []time.Duration
+	for d := mappingLifetimeFirstWait; d <= max; d *= 2 {
+		ret = append(ret, d)
+	}
+	return ret
+}
+
+// nearestSTUNNode returns the first IPv4 STUN node of the region with
+// the lowest latency, or of the lowest-numbered region if there are no
+// latencies.
+func nearestSTUNNode(dm *tailcfg.DERPMap, latency map[int]time.Duration) *tailcfg.DERPNode {
+	ids := dm.RegionIDs()
+	best := -1
+	for id, d := range latency {
+		if best == -1 || d < latency[best] || (d == latency[best] && id < best) {
+			if stunNode(dm.Regions[id]) != nil {
+				best = id
+			}
+		}
+	}
+	if best != -1 {
+		return stunNode(dm.Regions[best])
+	}
+	for _, id := range ids {
+		if n := stunNode(dm.Regions[id]); n != nil {
+			return n
+		}
+	}
+	return nil
+}
+
+func stunNode(r *tailcfg.DERPRegion) *tailcfg.DERPNode {
+	if r == nil {
+		return nil
+	}
+	for _, n := range r.Nodes {
+		if n.STUNPort >= 0 && n.IPv4 != "none" {
+			return n
+		}
+	}
+	return nil
+}
+
+// stunServerAddr returns the IPv4 address and port of node's STUN
+// server.
+func stunServerAddr(ctx context.Context, node *tailcfg.DERPNode) (netip.AddrPort, error) {
+	port := node.STUNPort
+	if port == 0 {
+		port = 3478
+	}
+	if ip, err := netip.ParseAddr(node.IPv4); err == nil {
+		return netip.AddrPortFrom(ip, uint16(port)), nil
+	}
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip4", node.HostName)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	if len(ips) == 0 {
+		return netip.AddrPort{}, fmt.Errorf("no IPv4 address for %s", node.HostName)
+	}
+	return netip.AddrPortFrom(ips[0].Unmap(), uint16(port)), nil
+}
+
+// stunMappedAddr sends STUN requests from pc to server, retrying a few
+// times, and returns the address the server saw them from.
+func stunMappedAddr(ctx context.Context, pc net.PacketConn, server netip.AddrPort) (netip.AddrPort, error) {
+	buf := make([]byte, 1500)
+	for try := 0; try < 3; try++ {
+		tx := stun.NewTxID()
+		if _, err := pc.WriteTo(stun.Request(tx), net.UDPAddrFromAddrPort(server)); err != nil {
+			return netip.AddrPort{}, err
+		}
+		pc.SetReadDeadline(time.Now().Add(time.Second))
+		for {
+			n, _, err := pc.ReadFrom(buf)
+			if err != nil {
+				if errors.Is(err, os.ErrDeadlineExceeded) {
+					break
+				}
+				return netip.AddrPort{}, err
+			}
+			gotTx, addr, err := stun.ParseResponse(buf[:n])
+			if err == nil && gotTx == tx {
+				return addr, nil
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return netip.AddrPort{}, err
+		}
+	}
+	return netip.AddrPort{}, fmt.Errorf("no STUN reply from %v", server)
+}
@@ -44,6 +44,9 @@
 // Debugging and experimentation tweakables.
 var (
 	debugNetcheck = envknob.Bool("TS_DEBUG_NETCHECK")
+	// debugQUICProbe enables the experimental QUIC-framed STUN probes
+	// to UDP port 443, sent when plain STUN gets no replies.
+	debugQUICProbe = envknob.Bool("TS_DEBUG_NETCHECK_QUIC")
 )
 
 // The various default timeouts for things.
@@ -85,6 +88,7 @@ type Report struct {
 	IPv4CanSend bool // an IPv4 packet was able to be sent
 	OSHasIPv6   bool // could bind a socket to ::1
 	ICMPv4      bool // an ICMPv4 round trip completed
+	QUIC        bool // a QUIC-framed STUN round trip to UDP port 443 completed
 
 	// MappingVariesByDestIP is whether STUN results depend which
 	// STUN server you're talking to (on IPv4).
@@ -113,6 +117,29 @@ type Report struct {
 	GlobalV4 string // ip:port of global IPv4
 	GlobalV6 string // [ip]:port of global IPv6
 
+	// GlobalV6Temporary is whether GlobalV6 is one of this machine's
+	// temporary IPv6 addresses (RFC 8981 privacy extensions), which
+	// the OS replaces every few hours or sooner.
+	GlobalV6Temporary bool
+
+	// CaptivePortal is whether a plain HTTP request to a DERP server
+	// got an unexpected response, as from a captive portal that
+	// intercepts traffic until the user logs in.
+	// Empty means not checked; see Client.CheckHTTPPath.
+	CaptivePortal opt.Bool
+
+	// HTTPProxy is the HTTP proxy, without any credentials, used to
+	// reach DERP servers over HTTPS, or empty if none. It's only set
+	// if Client.CheckHTTPPath is.
+	HTTPProxy string
+
+	// UDPOpenPorts are the local UDP ports from which a STUN probe
+	// got answered when the probes from our usual port didn't, which
+	// suggests only that port is blocked. It's only set if
+	// Client.CheckUDPPorts is. The ports are only probed for full
+	// reports; incremental ones carry over the previous result.
+	UDPOpenPorts []uint16 `json:",omitempty"`
+
 	// TODO: update Clone when adding new fields
 }
 
@@ -129,6 +156,7 @@ func (r *Report) Clone() *Report {
 	r2.RegionLatency = cloneDurationMap(r2.RegionLatency)
 	r2.RegionV4Latency = cloneDurationMap(r2.RegionV4Latency)
 	r2.RegionV6Latency = cloneDurationMap(r2.RegionV6Latency)
+	r2.UDPOpenPorts = append([]uint16(nil), r2.UDPOpenPorts...)
 	return &r2
 }
 
@@ -176,6 +204,24 @@ type Client struct {
 	// If nil, portmap discovery is not done.
 	PortMapper *portmapper.Client // lazily initialized on first use
 
+	// CheckHTTPPath controls whether GetReport also checks the HTTP
+	// path to the nearest DERP server for a captive portal and an HTTP
+	// proxy, setting Report.CaptivePortal and Report.HTTPProxy. It
+	// costs an extra HTTP request per report.
+	CheckHTTPPath bool
+
+	// CheckUDPPorts controls whether full reports in which no STUN
+	// probe was answered also probe from a few alternative local
+	// ports, setting Report.UDPOpenPorts. The probes take up to 2
+	// seconds beyond the deadline of the context GetReport is passed.
+	CheckUDPPorts bool
+
+	// CheckWellKnownUDPPorts controls whether CheckUDPPorts also
+	// probes from ports 53, 443 and 3478, which servers on the
+	// machine may need. It's for one-off checks, such as 'tailscale
+	// netcheck', rather than those a daemon runs periodically.
+	CheckWellKnownUDPPorts bool
+
 	mu       sync.Mutex            // guards following
 	nextFull bool                  // do a full region scan, even if last != nil
 	prev     map[time.Time]*Report // some previous reports
@@ -243,6 +289,10 @@ func (c *Client) MakeNextReportFull() {
 func (c *Client) ReceiveSTUNPacket(pkt []byte, src netip.AddrPort) {
 	c.vlogf("received STUN packet from %s", src)
 
+	if msg, ok := stun.FromQUIC(pkt); ok {
+		pkt = msg
+	}
+
 	if src.Addr().Is4() {
 		metricSTUNRecv4.Add(1)
 	} else if src.Addr().Is6() {
@@ -517,7 +567,7 @@ func (c *Client) readPackets(ctx context.Context, pc net.PacketConn) {
 			continue
 		}
 		pkt := buf[:n]
-		if !stun.Is(pkt) {
+		if !stun.Is(pkt) && !stun.IsQUIC(pkt) {
 			continue
 		}
 		if ap := netaddr.Unmap(ua.AddrPort()); ap.IsValid() {
@@ -932,6 +982,13 @@ func (c *Client) GetReport(ctx context.Context, dm *tailcfg.DERPMap) (_ *Report,
 					c.logf("[v1] measureAllICMPLatency: %v", err)
 				}
 			}()
+			if debugQUICProbe {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					c.measureAllQUICLatency(ctx, rs, need)
+				}()
+			}
 
 			wg.Add(len(need))
 			c.logf("netcheck: UDP is blocked, trying HTTPS")
@@ -966,6 +1023,22 @@ func (c *Client) GetReport(ctx context.Context, dm *tailcfg.DERPMap) (_ *Report,
 		wg.Wait()
 	}
 
+	if c.CheckHTTPPath && !c.SkipExternalNetwork && ctx.Err() == nil {
+		c.checkHTTPPath(ctx, rs, dm)
+	}
+
+	// Not ctx.Err() == nil: when no STUN probe was answered, they waited
+	// until ctx's deadline, and the port probes have their own.
+	if c.CheckUDPPorts && !rs.anyUDP() && !c.SkipExternalNetwork && ctx.Err() != context.Canceled {
+		if rs.incremental {
+			rs.mu.Lock()
+			rs.report.UDPOpenPorts = last.UDPOpenPorts
+			rs.mu.Unlock()
+		} else {
+			c.checkUDPPorts(ctx, rs, dm)
+		}
+	}
+
 	return c.finishAndStoreReport(rs, dm), nil
 }
 
@@ -974,12 +1047,26 @@ func (c *Client) finishAndStoreReport(rs *reportState, dm *tailcfg.DERPMap) *Rep
 	report := rs.report.Clone()
 	rs.mu.Unlock()
 
+	report.GlobalV6Temporary = isTemporaryIPv6(report.GlobalV6)
 	c.addReportHistoryAndSetPreferredDERP(report)
 	c.logConciseReport(report, dm)
 
 	return report
 }
 
+// isTemporaryIPv6 reports whether the [ip]:port ipPort is one of the
+// machine's temporary IPv6 addresses. IPv6 isn't NATed, so the address
+// STUN sees is the source address the OS picked, which with privacy
+// extensions is usually a temporary one.
+func isTemporaryIPv6(ipPort string) bool {
+	ipp, err := netip.ParseAddrPort(ipPort)
+	if err != nil {
+		return false
+	}
+	tmp, err := interfaces.TemporaryIPv6Addrs()
+	return err == nil && tmp[ipp.Addr()]
+}
+
 // runHTTPOnlyChecks is the netcheck done by environments that can
 // only do HTTP requests, such as ws/wasm.
 func (c *Client) runHTTPOnlyChecks(ctx context.Context, last *Report, rs *reportState, dm *tailcfg.DERPMap) error {
@@ -1186,6 +1273,9 @@ func (c *Client) logConciseReport(r *Report, dm *tailcfg.DERPMap) {
 		if !r.UDP {
 			fmt.Fprintf(w, " icmpv4=%v", r.ICMPv4)
 		}
+		if r.QUIC {
+			fmt.Fprintf(w, " quic=%v", r.QUIC)
+		}
 
 		fmt.Fprintf(w, " v6=%v", r.IPv6)
 		fmt.Fprintf(w, " mapvarydest=%v", r.MappingVariesByDestIP)
@@ -1200,6 +1290,18 @@ func (c *Client) logConciseReport(r *Report, dm *tailcfg.DERPMap) {
 		}
 		if r.GlobalV6 != "" {
 			fmt.Fprintf(w, " v6a=%v", r.GlobalV6)
+			if r.GlobalV6Temporary {
+				fmt.Fprintf(w, "(temp)")
+			}
+		}
+		if r.CaptivePortal.EqualBool(true) {
+			fmt.Fprintf(w, " captiveportal=true")
+		}
+		if r.HTTPProxy != "" {
+			fmt.Fprintf(w, " proxy=%v", r.HTTPProxy)
+		}
+		if len(r.UDPOpenPorts) > 0 {
+			fmt.Fprintf(w, " udpports=%v", r.UDPOpenPorts)
 		}
 		fmt.Fprintf(w, " derp=%v", r.PreferredDERP)
 		if r.PreferredDERP != 0 {
@@ -1473,4 +1575,7 @@ func conciseOptBool(b opt.Bool, trueVal string) string {
 	metricSTUNRecv4 = clientmetric.NewCounter("netcheck_stun_recv_ipv4")
 	metricSTUNRecv6 = clientmetric.NewCounter("netcheck_stun_recv_ipv6")
 	metricHTTPSend  = clientmetric.NewCounter("netcheck_https_measure")
+	metricQUICSend  = clientmetric.NewCounter("netcheck_quic_send")
+
+	metricUDPPortSend = clientmetric.NewCounter("netcheck_udp_port_send")
 )
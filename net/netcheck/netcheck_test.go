@@ -8,7 +8,10 @@
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"net/netip"
 	"reflect"
 	"sort"
@@ -21,6 +24,7 @@
 	"tailscale.com/net/stun"
 	"tailscale.com/net/stun/stuntest"
 	"tailscale.com/tailcfg"
+	"tailscale.com/types/opt"
 	"tailscale.com/util/strs"
 )
 
@@ -661,3 +665,150 @@ func TestSortRegions(t *testing.T) {
 		t.Errorf("got %v; want %v", got, want)
 	}
 }
+
+func TestMappingLifetimeWaits(t *testing.T) {
+	tests := []struct {
+		max  time.Duration
+		want []time.Duration
+	}{
+		{time.Second, []time.Duration{time.Second}},
+		{5 * time.Second, []time.Duration{5 * time.Second}},
+		{time.Minute, []time.Duration{5 * time.Second, 10 * time.Second, 20 * time.Second, 40 * time.Second}},
+	}
+	for _, tt := range tests {
+		if got := mappingLifetimeWaits(tt.max); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("mappingLifetimeWaits(%v) = %v; want %v", tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestMappingReachable(t *testing.T) {
+	listen := func() net.PacketConn {
+		pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { pc.Close() })
+		return pc
+	}
+	addrOf := func(pc net.PacketConn) netip.AddrPort {
+		return pc.LocalAddr().(*net.UDPAddr).AddrPort()
+	}
+	probe, pc, other := listen(), listen(), listen()
+
+	ctx := context.Background()
+	if ok, err := mappingReachable(ctx, probe, pc, addrOf(pc)); !ok || err != nil {
+		t.Errorf("mappingReachable to pc = %v, %v; want true", ok, err)
+	}
+	// Packets to another address don't reach pc.
+	if ok, err := mappingReachable(ctx, probe, pc, addrOf(other)); ok || err != nil {
+		t.Errorf("mappingReachable to other = %v, %v; want false", ok, err)
+	}
+}
+
+func TestCheckCaptivePortal(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    opt.Bool
+	}{
+		{
+			name: "no_portal",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			},
+			want: "false",
+		},
+		{
+			name: "redirect",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Redirect(w, r, "http://login.example/", http.StatusFound)
+			},
+			want: "true",
+		},
+		{
+			name: "login_page",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, "<html>please log in</html>")
+			},
+			want: "true",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/generate_204" {
+					http.NotFound(w, r)
+					return
+				}
+				tt.handler(w, r)
+			}))
+			defer ts.Close()
+			c := &Client{Logf: t.Logf}
+			node := &tailcfg.DERPNode{HostName: ts.Listener.Addr().String()}
+			got, err := c.checkCaptivePortal(context.Background(), node)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckUDPPorts(t *testing.T) {
+	stunAddr, cleanup := stuntest.Serve(t)
+	defer cleanup()
+
+	free, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	freePort := uint16(free.LocalAddr().(*net.UDPAddr).Port)
+	free.Close()
+	busy, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	busyPort := uint16(busy.LocalAddr().(*net.UDPAddr).Port)
+
+	old := udpPortCandidates
+	udpPortCandidates = []uint16{freePort, busyPort}
+	defer func() { udpPortCandidates = old }()
+
+	c := &Client{Logf: t.Logf}
+	rs := &reportState{c: c, report: newReport()}
+	c.checkUDPPorts(context.Background(), rs, stuntest.DERPMapOf(stunAddr.String()))
+	if want := []uint16{freePort}; !reflect.DeepEqual(rs.report.UDPOpenPorts, want) {
+		t.Errorf("UDPOpenPorts = %v; want %v", rs.report.UDPOpenPorts, want)
+	}
+}
+
+func TestCheckUDPPortsAfterDeadline(t *testing.T) {
+	stunAddr, cleanup := stuntest.Serve(t)
+	defer cleanup()
+
+	free, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	freePort := uint16(free.LocalAddr().(*net.UDPAddr).Port)
+	free.Close()
+
+	old := udpPortCandidates
+	udpPortCandidates = []uint16{freePort}
+	defer func() { udpPortCandidates = old }()
+
+	// The STUN probes from the usual port used up the deadline of the
+	// report's context; the port probes still get their own.
+	ctx, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	c := &Client{Logf: t.Logf}
+	rs := &reportState{c: c, report: newReport()}
+	c.checkUDPPorts(ctx, rs, stuntest.DERPMapOf(stunAddr.String()))
+	if want := []uint16{freePort}; !reflect.DeepEqual(rs.report.UDPOpenPorts, want) {
+		t.Errorf("UDPOpenPorts = %v; want %v", rs.report.UDPOpenPorts, want)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netcheck
+
+import (
+	"context"
+	"net/netip"
+	"sync"
+	"time"
+
+	"tailscale.com/net/stun"
+	"tailscale.com/tailcfg"
+)
+
+const (
+	// quicProbePort is the UDP port QUIC-framed STUN probes are sent
+	// to. DERP servers answer them there when run with --quic-stun.
+	quicProbePort = 443
+
+	// quicProbeTimeout is how long to wait for replies to QUIC-framed
+	// STUN probes.
+	quicProbeTimeout = 2 * time.Second
+)
+
+// measureAllQUICLatency sends a QUIC-framed STUN probe over IPv4 and
+// IPv6 to a DERP node in each of the need regions, for networks that
+// block STUN's port but allow QUIC. The probes are sent from the same
+// sockets as STUN probes, so replies are handled by ReceiveSTUNPacket
+// and fill in the same report fields, including our global IP:port.
+func (c *Client) measureAllQUICLatency(ctx context.Context, rs *reportState, need []*tailcfg.DERPRegion) {
+	ctx, cancel := context.WithTimeout(ctx, quicProbeTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, reg := range need {
+		var node *tailcfg.DERPNode
+		for _, n := range reg.Nodes {
+			if !n.STUNOnly {
+				node = n
+				break
+			}
+		}
+		if node == nil {
+			continue
+		}
+		for _, proto := range []probeProto{probeIPv4, probeIPv6} {
+			pc := rs.pc4
+			if proto == probeIPv6 {
+				pc = rs.pc6
+			}
+			if pc == nil {
+				continue
+			}
+			addr := c.nodeAddr(ctx, node, proto)
+			if !addr.IsValid() {
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				rs.runQUICProbe(ctx, node, pc, netip.AddrPortFrom(addr.Addr(), quicProbePort))
+			}()
+		}
+	}
+	wg.Wait()
+}
+
+// runQUICProbe sends a QUIC-framed STUN probe to node at addr over pc
+// and waits for the reply or for ctx to be done.
+func (rs *reportState) runQUICProbe(ctx context.Context, node *tailcfg.DERPNode, pc STUNConn, addr netip.AddrPort) {
+	txID := stun.NewTxID()
+	req := stun.QUICRequest(txID)
+	done := make(chan struct{})
+
+	sent := time.Now()
+	rs.mu.Lock()
+	rs.inFlight[txID] = func(ipp netip.AddrPort) {
+		rs.addNodeLatency(node, ipp, time.Since(sent))
+		rs.mu.Lock()
+		rs.report.QUIC = true
+		rs.mu.Unlock()
+		close(done)
+	}
+	rs.mu.Unlock()
+	defer func() {
+		rs.mu.Lock()
+		delete(rs.inFlight, txID)
+		rs.mu.Unlock()
+	}()
+
+	metricQUICSend.Add(1)
+	if _, err := pc.WriteToUDPAddrPort(req, addr); err != nil {
+		rs.c.vlogf("QUIC probe to %v: %v", addr, err)
+		return
+	}
+	rs.c.vlogf("sent QUIC probe to %v", addr)
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
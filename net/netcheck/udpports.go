@@ -0,0 +1,152 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netcheck
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+
+	"tailscale.com/net/netns"
+	"tailscale.com/net/stun"
+	"tailscale.com/tailcfg"
+)
+
+// udpPortProbeTimeout is how long to wait for replies to the STUN
+// probes sent from alternative local ports.
+const udpPortProbeTimeout = 2 * time.Second
+
+// udpPortCandidates are the local UDP ports that checkUDPPorts sends
+// STUN probes from: a few high ones, which nothing on the machine is
+// likely to need while they're briefly bound.
+var udpPortCandidates = []uint16{10000, 50000, 60000}
+
+// wellKnownUDPPortCandidates are the local UDP ports that firewalls
+// commonly let through because of DNS, QUIC and STUN itself, which
+// checkUDPPorts also probes from if Client.CheckWellKnownUDPPorts is
+// set. A DNS or QUIC server on the machine may fail to start while
+// one of them is bound.
+var wellKnownUDPPortCandidates = []uint16{53, 443, 3478}
+
+// checkUDPPorts sends a STUN probe from each of udpPortCandidates, and
+// wellKnownUDPPortCandidates if c.CheckWellKnownUDPPorts, to a STUN
+// server of the nearest DERP region, recording in rs.report the ports
+// whose probe was answered. It's for when the STUN probes from our
+// usual port failed: if some of these succeed, it's our local port
+// that's blocked rather than UDP as a whole. Candidates that are in
+// use or that we lack permission to bind are skipped.
+//
+// The probes get udpPortProbeTimeout of their own rather than what's
+// left of ctx's deadline, which the failed STUN probes will have used
+// up waiting for replies. They're only stopped early if ctx is
+// canceled.
+func (c *Client) checkUDPPorts(ctx context.Context, rs *reportState, dm *tailcfg.DERPMap) {
+	pctx, cancel := context.WithTimeout(context.Background(), udpPortProbeTimeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.Canceled {
+				cancel()
+			}
+		case <-pctx.Done():
+		}
+	}()
+	ctx = pctx
+
+	rs.mu.Lock()
+	node := udpPortProbeNode(dm, rs.report.RegionLatency)
+	rs.mu.Unlock()
+	if node == nil {
+		return
+	}
+	dst := c.nodeAddr(ctx, node, probeIPv4)
+	if !dst.IsValid() {
+		return
+	}
+
+	ports := udpPortCandidates
+	if c.CheckWellKnownUDPPorts {
+		ports = append(append([]uint16(nil), wellKnownUDPPortCandidates...), ports...)
+	}
+	var (
+		mu   sync.Mutex
+		open []uint16
+		wg   sync.WaitGroup
+	)
+	for _, port := range ports {
+		port := port
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.probeFromPort(ctx, port, dst); err != nil {
+				c.vlogf("UDP port probe from %d to %v: %v", port, dst, err)
+				return
+			}
+			mu.Lock()
+			open = append(open, port)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	sort.Slice(open, func(i, j int) bool { return open[i] < open[j] })
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.report.UDPOpenPorts = open
+}
+
+// probeFromPort sends a STUN request from local UDP port to dst and
+// waits for the matching response or for ctx to be done.
+func (c *Client) probeFromPort(ctx context.Context, port uint16, dst netip.AddrPort) error {
+	pc, err := netns.Listener(c.logf).ListenPacket(ctx, "udp4", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return err
+	}
+	defer pc.Close()
+	if d, ok := ctx.Deadline(); ok {
+		pc.SetReadDeadline(d)
+	}
+
+	txID := stun.NewTxID()
+	metricUDPPortSend.Add(1)
+	if _, err := pc.WriteTo(stun.Request(txID), net.UDPAddrFromAddrPort(dst)); err != nil {
+		return err
+	}
+	buf := make([]byte, 1<<10)
+	for {
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		if !stun.Is(buf[:n]) {
+			continue
+		}
+		if tx, _, err := stun.ParseResponse(buf[:n]); err == nil && tx == txID {
+			return nil
+		}
+	}
+}
+
+// udpPortProbeNode returns the first node of the DERP region with the
+// lowest latency in latency, or, if none is known, of the first region
+// in dm. Unlike nearestDERPNode, STUN-only nodes qualify.
+func udpPortProbeNode(dm *tailcfg.DERPMap, latency map[int]time.Duration) *tailcfg.DERPNode {
+	if n := nearestDERPNode(dm, latency); n != nil {
+		return n
+	}
+	for _, id := range dm.RegionIDs() {
+		for _, n := range dm.Regions[id].Nodes {
+			if n.STUNPort >= 0 {
+				return n
+			}
+		}
+	}
+	return nil
+}
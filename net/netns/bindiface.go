@@ -0,0 +1,31 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netns
+
+import (
+	"net"
+	"syscall"
+
+	"tailscale.com/types/logger"
+)
+
+// ListenerBoundTo is like Listener, but its sockets are also bound to
+// the network interface with the given name and index, so that their
+// traffic only ever uses that interface regardless of the routing
+// table. Binding isn't supported on all platforms; where it's not, the
+// sockets fail to be created.
+func ListenerBoundTo(logf logger.Logf, ifName string, ifIndex int) *net.ListenConfig {
+	lc := Listener(logf)
+	base := lc.Control
+	lc.Control = func(network, address string, c syscall.RawConn) error {
+		if base != nil {
+			if err := base(network, address, c); err != nil {
+				return err
+			}
+		}
+		return bindToInterface(network, address, c, ifName, ifIndex)
+	}
+	return lc
+}
@@ -0,0 +1,27 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build darwin || ios
+// +build darwin ios
+
+package netns
+
+import (
+	"fmt"
+	"syscall"
+)
+
+func bindToInterface(network, address string, c syscall.RawConn, ifName string, ifIndex int) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = bindInterface(fd, network, address, ifIndex)
+	})
+	if err != nil {
+		return err
+	}
+	if sockErr != nil {
+		return fmt.Errorf("binding to %s: %w", ifName, sockErr)
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux && !darwin && !ios && !windows
+// +build !linux,!darwin,!ios,!windows
+
+package netns
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+)
+
+func bindToInterface(network, address string, c syscall.RawConn, ifName string, ifIndex int) error {
+	return fmt.Errorf("binding sockets to an interface isn't supported on %s", runtime.GOOS)
+}
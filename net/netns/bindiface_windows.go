@@ -0,0 +1,22 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netns
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+)
+
+func bindToInterface(network, address string, c syscall.RawConn, ifName string, ifIndex int) error {
+	bind := bindSocket4
+	if strings.HasSuffix(network, "6") {
+		bind = bindSocket6
+	}
+	if err := bind(c, uint32(ifIndex)); err != nil {
+		return fmt.Errorf("binding to %s: %w", ifName, err)
+	}
+	return nil
+}
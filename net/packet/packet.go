@@ -458,6 +458,67 @@ func (q *Parsed) EchoIDSeq() uint32 {
 	}
 }
 
+// ErrorEchoIDSeq extracts the identifier/sequence bytes, as returned by
+// EchoIDSeq, of the ICMP Echo Request quoted in the ICMP error q (see
+// IsError), such as the Time Exceeded errors routers send in reply to
+// traceroute probes. It reports false if q isn't an ICMP error quoting
+// an Echo Request.
+func (q *Parsed) ErrorEchoIDSeq() (idSeq uint32, ok bool) {
+	if !q.IsError() {
+		return 0, false
+	}
+	// The error's header is 8 bytes in both ICMPv4 and ICMPv6, and is
+	// followed by as much of the original packet as fits.
+	inner := q.b[q.subofs+8:]
+	switch q.IPProto {
+	case ipproto.ICMPv4:
+		if len(inner) < ip4HeaderLength || inner[0]>>4 != 4 {
+			return 0, false
+		}
+		ihl := int(inner[0]&0x0f) * 4
+		if ihl < ip4HeaderLength || len(inner) < ihl+icmp4HeaderLength+4 {
+			return 0, false
+		}
+		if ipproto.Proto(inner[9]) != ipproto.ICMPv4 || ICMP4Type(inner[ihl]) != ICMP4EchoRequest {
+			return 0, false
+		}
+		return binary.LittleEndian.Uint32(inner[ihl+icmp4HeaderLength:]), true
+	case ipproto.ICMPv6:
+		if len(inner) < ip6HeaderLength+icmp6HeaderLength+4 || inner[0]>>4 != 6 {
+			return 0, false
+		}
+		if ipproto.Proto(inner[6]) != ipproto.ICMPv6 || ICMP6Type(inner[ip6HeaderLength]) != ICMP6EchoRequest {
+			return 0, false
+		}
+		return binary.LittleEndian.Uint32(inner[ip6HeaderLength+icmp6HeaderLength:]), true
+	}
+	return 0, false
+}
+
+// SetTTL sets the TTL of the IPv4 packet b, or the hop limit of the
+// IPv6 packet b, such as one made by Generate. It updates the IPv4
+// header checksum.
+func SetTTL(b []byte, ttl uint8) {
+	if len(b) == 0 {
+		return
+	}
+	switch b[0] >> 4 {
+	case 4:
+		ihl := int(b[0]&0x0f) * 4
+		if ihl < ip4HeaderLength || len(b) < ihl {
+			return
+		}
+		b[8] = ttl
+		binary.BigEndian.PutUint16(b[10:12], 0)
+		binary.BigEndian.PutUint16(b[10:12], ip4Checksum(b[:ihl]))
+	case 6:
+		if len(b) < ip6HeaderLength {
+			return
+		}
+		b[7] = ttl
+	}
+}
+
 func Hexdump(b []byte) string {
 	out := new(strings.Builder)
 	for i := 0; i < len(b); i += 16 {
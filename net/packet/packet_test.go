@@ -561,3 +561,87 @@ func BenchmarkString(b *testing.B) {
 		})
 	}
 }
+
+func TestTracerouteProbe(t *testing.T) {
+	tests := []struct {
+		name   string
+		probe  func(src, dst netip.Addr) Header
+		errorh func(src, dst netip.Addr) Header
+		self   netip.Addr
+		router netip.Addr
+		dst    netip.Addr
+	}{
+		{
+			name: "v4",
+			probe: func(src, dst netip.Addr) Header {
+				return ICMP4Header{
+					IP4Header: IP4Header{IPProto: ipproto.ICMPv4, Src: src, Dst: dst},
+					Type:      ICMP4EchoRequest,
+				}
+			},
+			errorh: func(src, dst netip.Addr) Header {
+				return ICMP4Header{
+					IP4Header: IP4Header{IPProto: ipproto.ICMPv4, Src: src, Dst: dst},
+					Type:      ICMP4TimeExceeded,
+				}
+			},
+			self:   netip.MustParseAddr("100.64.0.1"),
+			router: netip.MustParseAddr("100.64.0.2"),
+			dst:    netip.MustParseAddr("192.168.1.1"),
+		},
+		{
+			name: "v6",
+			probe: func(src, dst netip.Addr) Header {
+				return ICMP6Header{
+					IP6Header: IP6Header{IPProto: ipproto.ICMPv6, Src: src, Dst: dst},
+					Type:      ICMP6EchoRequest,
+				}
+			},
+			errorh: func(src, dst netip.Addr) Header {
+				return ICMP6Header{
+					IP6Header: IP6Header{IPProto: ipproto.ICMPv6, Src: src, Dst: dst},
+					Type:      ICMP6TimeExceeded,
+				}
+			},
+			self:   netip.MustParseAddr("fd7a:115c:a1e0::1"),
+			router: netip.MustParseAddr("fd7a:115c:a1e0::2"),
+			dst:    netip.MustParseAddr("fd00::1"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idSeq, payload := ICMPEchoPayload([]byte("probe"))
+			probe := Generate(tt.probe(tt.self, tt.dst), payload)
+			SetTTL(probe, 3)
+
+			var p Parsed
+			p.Decode(probe)
+			if p.IPVersion == 4 {
+				if probe[8] != 3 {
+					t.Errorf("TTL = %d; want 3", probe[8])
+				}
+				if c := ip4Checksum(probe[:ip4HeaderLength]); c != 0 {
+					t.Errorf("IPv4 header checksum doesn't verify: %x", c)
+				}
+			} else if probe[7] != 3 {
+				t.Errorf("hop limit = %d; want 3", probe[7])
+			}
+			if _, ok := p.ErrorEchoIDSeq(); ok {
+				t.Error("ErrorEchoIDSeq of the probe itself = ok")
+			}
+
+			// The router quotes the probe after the 4 unused bytes
+			// of the ICMP error header.
+			quoted := append(make([]byte, 4), probe...)
+			errPkt := Generate(tt.errorh(tt.router, tt.self), quoted)
+			p.Decode(errPkt)
+			if !p.IsError() {
+				t.Fatal("IsError = false")
+			}
+			got, ok := p.ErrorEchoIDSeq()
+			if !ok || got != idSeq {
+				t.Errorf("ErrorEchoIDSeq = %x, %v; want %x, true", got, ok, idSeq)
+			}
+		})
+	}
+}
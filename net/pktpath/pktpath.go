@@ -0,0 +1,182 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package pktpath measures how many packets each layer of the data
+// path handles and how long it spends on them, to find which layer is
+// the bottleneck on a slow machine.
+//
+// Measurement is off except while Collect runs, so that the hooks in
+// the packet path cost one atomic load per packet the rest of the time.
+package pktpath
+
+import (
+	"context"
+	"errors"
+	"runtime/metrics"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tailscale.com/tstime/mono"
+)
+
+// Layer is a layer of the packet path.
+type Layer uint8
+
+// Layers, in the order an outbound packet passes through them, followed
+// by the inbound ones.
+//
+// wireguard-go's encryption and decryption happen between the tstun and
+// magicsock layers, in its own goroutines, and aren't measured directly.
+const (
+	TUNRead       Layer = iota // tstun: preparing a packet read from the TUN device for wireguard-go
+	FilterOut                  // tstun: filtering an outbound packet
+	MagicsockSend              // magicsock: sending an encrypted packet over UDP or DERP
+	MagicsockRecv              // magicsock: classifying a received UDP packet for wireguard-go
+	FilterIn                   // tstun: filtering an inbound packet
+	TUNWrite                   // tstun: writing a decrypted packet to the TUN device
+	numLayers
+)
+
+var layerNames = [numLayers]string{
+	TUNRead:       "tstun-read",
+	FilterOut:     "filter-out",
+	MagicsockSend: "magicsock-send",
+	MagicsockRecv: "magicsock-recv",
+	FilterIn:      "filter-in",
+	TUNWrite:      "tstun-write",
+}
+
+func (l Layer) String() string {
+	if l < numLayers {
+		return layerNames[l]
+	}
+	return "unknown"
+}
+
+var (
+	enabled atomic.Bool
+	counts  [numLayers]struct {
+		packets atomic.Int64
+		nanos   atomic.Int64
+	}
+)
+
+// Start returns the time at which a layer starts handling a packet, to
+// pass to Done, or zero if measurement is off.
+func Start() mono.Time {
+	if !enabled.Load() {
+		return 0
+	}
+	return mono.Now()
+}
+
+// Done records that l finished handling a packet it started handling at
+// start, as returned by Start.
+func (l Layer) Done(start mono.Time) {
+	if start == 0 {
+		return
+	}
+	c := &counts[l]
+	c.packets.Add(1)
+	c.nanos.Add(int64(mono.Since(start)))
+}
+
+// Stats are the measurements from one run of Collect.
+type Stats struct {
+	Duration time.Duration
+	Layers   []LayerStats
+
+	// HeapAllocs and HeapAllocBytes are the number and total size of
+	// the heap allocations made by the whole process during the
+	// measurement, not just by the packet path.
+	HeapAllocs     uint64
+	HeapAllocBytes uint64
+}
+
+// LayerStats are the measurements of one layer.
+type LayerStats struct {
+	Name    string
+	Packets int64
+
+	// Time is the total time spent handling packets in the layer.
+	// Layers run concurrently, so their times can add up to more
+	// than Duration.
+	Time time.Duration
+}
+
+// PerPacket returns the average time the layer spent on a packet.
+func (s LayerStats) PerPacket() time.Duration {
+	if s.Packets == 0 {
+		return 0
+	}
+	return s.Time / time.Duration(s.Packets)
+}
+
+var collectMu sync.Mutex
+
+// allocMetrics are the runtime/metrics samples read at the start and
+// end of Collect.
+var allocMetrics = []string{
+	"/gc/heap/allocs:objects",
+	"/gc/heap/allocs:bytes",
+}
+
+// Collect turns measurement on for d and returns what it measured. It
+// returns early, with ctx's error, if ctx is done first. Only one
+// Collect can run at a time.
+func Collect(ctx context.Context, d time.Duration) (*Stats, error) {
+	if !collectMu.TryLock() {
+		return nil, errors.New("packet path stats are already being collected")
+	}
+	defer collectMu.Unlock()
+
+	for i := range counts {
+		counts[i].packets.Store(0)
+		counts[i].nanos.Store(0)
+	}
+	before := readAllocs()
+	t0 := time.Now()
+	enabled.Store(true)
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		enabled.Store(false)
+		return nil, ctx.Err()
+	}
+	enabled.Store(false)
+	after := readAllocs()
+
+	s := &Stats{
+		Duration:       time.Since(t0).Round(time.Millisecond),
+		HeapAllocs:     after[0] - before[0],
+		HeapAllocBytes: after[1] - before[1],
+	}
+	for l := Layer(0); l < numLayers; l++ {
+		s.Layers = append(s.Layers, LayerStats{
+			Name:    l.String(),
+			Packets: counts[l].packets.Load(),
+			Time:    time.Duration(counts[l].nanos.Load()),
+		})
+	}
+	return s, nil
+}
+
+func readAllocs() [2]uint64 {
+	samples := make([]metrics.Sample, len(allocMetrics))
+	for i, name := range allocMetrics {
+		samples[i].Name = name
+	}
+	metrics.Read(samples)
+	var ret [2]uint64
+	for i, s := range samples {
+		if s.Value.Kind() == metrics.KindUint64 {
+			ret[i] = s.Value.Uint64()
+		}
+	}
+	return ret
+}
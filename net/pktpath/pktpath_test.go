@@ -0,0 +1,73 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pktpath
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCollect(t *testing.T) {
+	if s := Start(); s != 0 {
+		t.Fatalf("Start outside Collect = %v; want 0", s)
+	}
+	FilterIn.Done(Start()) // must not count
+
+	type result struct {
+		st  *Stats
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		st, err := Collect(context.Background(), 200*time.Millisecond)
+		done <- result{st, err}
+	}()
+	for !enabled.Load() {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := Collect(context.Background(), time.Millisecond); err == nil {
+		t.Error("concurrent Collect succeeded")
+	}
+	for i := 0; i < 3; i++ {
+		start := Start()
+		time.Sleep(time.Millisecond)
+		MagicsockSend.Done(start)
+	}
+
+	r := <-done
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if len(r.st.Layers) != int(numLayers) {
+		t.Fatalf("got %d layers; want %d", len(r.st.Layers), numLayers)
+	}
+	for _, l := range r.st.Layers {
+		switch l.Name {
+		case "magicsock-send":
+			if l.Packets != 3 || l.PerPacket() < time.Millisecond {
+				t.Errorf("%s: %d packets, %v per packet; want 3 packets, at least 1ms per packet", l.Name, l.Packets, l.PerPacket())
+			}
+		default:
+			if l.Packets != 0 {
+				t.Errorf("%s: %d packets; want 0", l.Name, l.Packets)
+			}
+		}
+	}
+	if s := Start(); s != 0 {
+		t.Errorf("Start after Collect = %v; want 0", s)
+	}
+}
+
+func TestCollectCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Collect(ctx, time.Minute); err != context.Canceled {
+		t.Errorf("Collect = %v; want context.Canceled", err)
+	}
+	if enabled.Load() {
+		t.Error("measurement still on after Collect returned")
+	}
+}
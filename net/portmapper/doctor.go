@@ -0,0 +1,52 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package portmapper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"tailscale.com/doctor"
+	"tailscale.com/types/logger"
+)
+
+func init() {
+	doctor.Register(doctor.InCategory(doctor.CheckFunc("portmap", runDoctorCheck), doctor.CategoryNetwork))
+}
+
+// runDoctorCheck probes the gateway for port mapping services, which
+// let peers reach this node directly through its NAT.
+func runDoctorCheck(ctx context.Context, logf logger.Logf) error {
+	c := NewClient(logger.Discard, nil)
+	defer c.Close()
+	res, err := c.Probe(ctx)
+	if errors.Is(err, ErrGatewayRange) {
+		logf("no gateway to probe")
+		doctor.Report(ctx, doctor.SeverityOK, "no gateway to probe", nil)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var have []string
+	if res.UPnP {
+		have = append(have, "UPnP")
+	}
+	if res.PMP {
+		have = append(have, "NAT-PMP")
+	}
+	if res.PCP {
+		have = append(have, "PCP")
+	}
+	summary := "no port mapping services found on the gateway"
+	if len(have) > 0 {
+		summary = fmt.Sprintf("gateway offers %s", strings.Join(have, ", "))
+	}
+	logf("%s", summary)
+	doctor.Report(ctx, doctor.SeverityOK, summary, res)
+	return nil
+}
@@ -58,9 +58,11 @@
 type Client struct {
 	logf         logger.Logf
 	ipAndGateway func() (gw, ip netip.Addr, ok bool)
-	onChange     func() // or nil
-	testPxPPort  uint16 // if non-zero, pxpPort to use for tests
-	testUPnPPort uint16 // if non-zero, uPnPPort to use for tests
+	onChange     func()           // or nil
+	onRenewal    func(error)      // or nil; see SetRenewalHealthFunc
+	testPxPPort  uint16           // if non-zero, pxpPort to use for tests
+	testUPnPPort uint16           // if non-zero, uPnPPort to use for tests
+	testTimeNow  func() time.Time // if non-nil, time.Now to use for tests
 
 	mu sync.Mutex // guards following, and all fields thereof
 
@@ -88,6 +90,12 @@ type Client struct {
 	localPort uint16
 
 	mapping mapping // non-nil if we have a mapping
+
+	// renewFailures is the number of consecutive failed attempts to
+	// renew mapping, and renewExpired is whether it expired before
+	// being renewed, raising a health warning.
+	renewFailures int
+	renewExpired  bool
 }
 
 // mapping represents a created port-mapping over some protocol.  It specifies a lease duration,
@@ -161,6 +169,14 @@ func NewClient(logf logger.Logf, onChange func()) *Client {
 	}
 }
 
+// SetRenewalHealthFunc sets the func that's called with an error when
+// a previously working mapping expires without being renewed, and with
+// nil once a mapping is working again or the network changes. It must
+// be called before the client is used.
+func (c *Client) SetRenewalHealthFunc(f func(error)) {
+	c.onRenewal = f
+}
+
 // SetGatewayLookupFunc set the func that returns the machine's default gateway IP, and
 // the primary IP address for that gateway. It must be called before the client is used.
 // If not called, interfaces.LikelyHomeRouterIP is used.
@@ -273,6 +289,7 @@ func (c *Client) invalidateMappingsLocked(releaseOld bool) {
 		}
 		c.mapping = nil
 	}
+	c.resetRenewFailuresLocked()
 	c.pmpPubIP = netip.Addr{}
 	c.pmpPubIPTime = time.Time{}
 	c.pcpSawTime = time.Time{}
@@ -389,7 +406,13 @@ func (c *Client) createMapping() {
 		c.runningCreate = false
 	}()
 
-	if _, err := c.createOrGetMapping(ctx); err == nil && c.onChange != nil {
+	c.mu.Lock()
+	prev := c.mapping
+	c.mu.Unlock()
+
+	_, err := c.createOrGetMapping(ctx)
+	c.noteMappingResult(prev, err)
+	if err == nil && c.onChange != nil {
 		go c.onChange()
 	} else if err != nil && !IsNoMappingError(err) {
 		c.logf("createOrGetMapping: %v", err)
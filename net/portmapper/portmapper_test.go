@@ -6,6 +6,8 @@
 
 import (
 	"context"
+	"errors"
+	"net/netip"
 	"os"
 	"reflect"
 	"strconv"
@@ -123,3 +125,56 @@ func TestPCPIntegration(t *testing.T) {
 		t.Errorf("got nil mapping after successful createOrGetMapping")
 	}
 }
+
+func TestNoteMappingResult(t *testing.T) {
+	now := time.Unix(1000, 0)
+	var healthErr error
+	c := NewClient(t.Logf, nil)
+	c.testTimeNow = func() time.Time { return now }
+	c.SetRenewalHealthFunc(func(err error) { healthErr = err })
+
+	m := &pmpMapping{
+		external:   netip.MustParseAddrPort("1.2.3.4:5678"),
+		renewAfter: now.Add(time.Hour),
+		goodUntil:  now.Add(2 * time.Hour),
+	}
+	c.mapping = m
+	errRenew := errors.New("no response")
+
+	// Failing to renew before the lease expires isn't a problem yet.
+	c.noteMappingResult(m, errRenew)
+	if healthErr != nil {
+		t.Fatalf("health error before expiry: %v", healthErr)
+	}
+
+	now = m.goodUntil
+	c.noteMappingResult(m, errRenew)
+	if healthErr == nil {
+		t.Fatal("no health error after expiry")
+	}
+	if c.renewFailures != 2 {
+		t.Errorf("renewFailures = %d; want 2", c.renewFailures)
+	}
+
+	c.noteMappingResult(m, nil)
+	if healthErr != nil {
+		t.Errorf("health error after renewal: %v", healthErr)
+	}
+	if c.renewFailures != 0 {
+		t.Errorf("renewFailures = %d; want 0", c.renewFailures)
+	}
+
+	// A network change clears the warning too.
+	c.noteMappingResult(m, errRenew)
+	if healthErr == nil {
+		t.Fatal("no health error after expiry")
+	}
+	c.NoteNetworkDown()
+	if healthErr != nil {
+		t.Errorf("health error after network change: %v", healthErr)
+	}
+	c.noteMappingResult(m, errRenew)
+	if healthErr != nil {
+		t.Errorf("health error for invalidated mapping: %v", healthErr)
+	}
+}
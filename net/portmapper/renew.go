@@ -0,0 +1,86 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package portmapper
+
+import (
+	"fmt"
+	"time"
+
+	"tailscale.com/util/clientmetric"
+)
+
+// noteMappingResult records the outcome of an attempt to create a
+// mapping, where prev is the mapping that was being renewed, or nil if
+// there was none. If a previously working mapping stops renewing and
+// expires, as happens when the gateway reboots or a CGNAT changes, it
+// reports it to the SetRenewalHealthFunc func, since otherwise direct
+// connections silently degrade to DERP.
+func (c *Client) noteMappingResult(prev mapping, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil {
+		if prev != nil {
+			metricRenewOK.Add(1)
+		}
+		if c.renewFailures > 0 {
+			c.logf("mapping renewed after %d failed attempts", c.renewFailures)
+		}
+		c.resetRenewFailuresLocked()
+		return
+	}
+	if prev == nil || c.mapping != prev {
+		// Nothing was being renewed, or the mapping was invalidated
+		// by a network change while we tried.
+		return
+	}
+	metricRenewFailed.Add(1)
+	c.renewFailures++
+	now := c.timeNow()
+	expired := !now.Before(prev.GoodUntil())
+	c.logf("failed to renew mapping %v (attempt %d; lease expires %v): %v",
+		prev.External(), c.renewFailures, prev.GoodUntil().Round(time.Second), err)
+	if expired && !c.renewExpired {
+		c.renewExpired = true
+		metricRenewExpired.Add(1)
+		c.setRenewalHealthLocked(fmt.Errorf("port mapping %v on the gateway expired and couldn't be renewed (%v); direct connections may fall back to DERP relays", prev.External(), err))
+	}
+}
+
+// resetRenewFailuresLocked forgets any failed renewals and clears the
+// health warning, if raised. c.mu must be held.
+func (c *Client) resetRenewFailuresLocked() {
+	c.renewFailures = 0
+	if c.renewExpired {
+		c.renewExpired = false
+		c.setRenewalHealthLocked(nil)
+	}
+}
+
+func (c *Client) setRenewalHealthLocked(err error) {
+	if c.onRenewal != nil {
+		c.onRenewal(err)
+	}
+}
+
+func (c *Client) timeNow() time.Time {
+	if c.testTimeNow != nil {
+		return c.testTimeNow()
+	}
+	return time.Now()
+}
+
+var (
+	// metricRenewOK counts the number of times
+	// an existing mapping was renewed.
+	metricRenewOK = clientmetric.NewCounter("portmap_renew_ok")
+
+	// metricRenewFailed counts the number of times
+	// we failed to renew an existing mapping.
+	metricRenewFailed = clientmetric.NewCounter("portmap_renew_failed")
+
+	// metricRenewExpired counts the number of times
+	// a mapping expired because it couldn't be renewed.
+	metricRenewExpired = clientmetric.NewCounter("portmap_renew_expired")
+)
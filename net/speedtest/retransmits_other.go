@@ -0,0 +1,17 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package speedtest
+
+import "net"
+
+// Retransmits returns the number of TCP segments the kernel has
+// retransmitted on conn. It's only implemented on Linux, and reports
+// false elsewhere.
+func Retransmits(conn net.Conn) (n int64, ok bool) {
+	return 0, false
+}
@@ -19,19 +19,25 @@ func RunClient(direction Direction, duration time.Duration, host string) ([]Resu
 	if err != nil {
 		return nil, err
 	}
+	defer conn.Close()
+	return RunClientConn(conn, direction, duration)
+}
 
+// RunClientConn is like RunClient, but runs the speedtest over conn,
+// which must already be connected to a speedtest server. It doesn't
+// close conn.
+func RunClientConn(conn net.Conn, direction Direction, duration time.Duration) ([]Result, error) {
 	conf := config{TestDuration: duration, Version: version, Direction: direction}
 
-	defer conn.Close()
 	encoder := json.NewEncoder(conn)
 
-	if err = encoder.Encode(conf); err != nil {
+	if err := encoder.Encode(conf); err != nil {
 		return nil, err
 	}
 
 	var response configResponse
 	decoder := json.NewDecoder(conn)
-	if err = decoder.Decode(&response); err != nil {
+	if err := decoder.Decode(&response); err != nil {
 		return nil, err
 	}
 	if response.Error != "" {
@@ -34,13 +34,20 @@ func Serve(l net.Listener) error {
 	}
 }
 
-// handleConnection handles the initial exchange between the server and the client.
+func handleConnection(conn net.Conn) error {
+	defer conn.Close()
+	return ServeConn(conn)
+}
+
+// ServeConn handles the initial exchange between the server and the client.
 // It reads the testconfig message into a config struct. If any errors occur with
 // the testconfig (specifically, if there is a version mismatch), it will return those
 // errors to the client with a configResponse. After the exchange, it will start
 // the speed test.
-func handleConnection(conn net.Conn) error {
-	defer conn.Close()
+//
+// Unlike Serve, it doesn't close conn; the caller must, so that the
+// client sees the end of a download.
+func ServeConn(conn net.Conn) error {
 	var conf config
 
 	decoder := json.NewDecoder(conn)
@@ -62,6 +69,12 @@ func handleConnection(conn net.Conn) error {
 		return err
 	}
 
+	if conf.TestDuration <= 0 || conf.TestDuration > MaxDuration {
+		err = fmt.Errorf("test duration %v must be positive and at most %v", conf.TestDuration, MaxDuration)
+		encoder.Encode(configResponse{Error: err.Error()})
+		return err
+	}
+
 	// Start the test
 	encoder.Encode(configResponse{})
 	_, err = doTest(conn, conf)
@@ -7,6 +7,7 @@
 import (
 	"net"
 	"testing"
+	"time"
 )
 
 func TestDownload(t *testing.T) {
@@ -79,3 +80,18 @@ type state struct {
 		t.Error("server error:", err)
 	}
 }
+
+func TestServeConnRejectsLongDuration(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	errc := make(chan error, 1)
+	go func() { errc <- ServeConn(c2) }()
+
+	if _, err := RunClientConn(c1, Download, MaxDuration+time.Second); err == nil {
+		t.Error("client: unexpected success")
+	}
+	if err := <-errc; err == nil {
+		t.Error("server: unexpected success")
+	}
+}
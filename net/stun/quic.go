@@ -0,0 +1,93 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stun
+
+import (
+	"encoding/binary"
+	"net/netip"
+)
+
+// QUICVersion is the QUIC version number in the long header of
+// QUIC-framed STUN packets. It's one of the versions RFC 9000 section
+// 15 reserves for forcing version negotiation, so a real QUIC server
+// answers a QUIC-framed request with a harmless Version Negotiation
+// packet rather than treating it as a connection attempt.
+const QUICVersion = 0x7a5a3a1a
+
+const (
+	// quicHeaderLen is the length of the QUIC long header we use:
+	// flags, version, an 8-byte destination connection ID, an empty
+	// source connection ID and a 2-byte length of the STUN message
+	// that follows.
+	quicHeaderLen = 1 + 4 + 1 + 8 + 1 + 2
+
+	// quicMinRequestLen is the size QUIC-framed requests are padded
+	// to. RFC 9000 requires datagrams carrying a client's first
+	// packet to be at least this large, and some middleboxes drop
+	// smaller ones; it also keeps responses smaller than requests,
+	// so a server can't be used to amplify traffic.
+	quicMinRequestLen = 1200
+)
+
+// QUICRequest returns a binding request like Request, framed as a QUIC
+// long header packet so that it can be sent to UDP port 443 on networks
+// that block STUN's port but allow QUIC.
+func QUICRequest(tID TxID) []byte {
+	b := appendQUICHeader(make([]byte, 0, quicMinRequestLen), tID, Request(tID))
+	return b[:quicMinRequestLen]
+}
+
+// QUICResponse returns a binding response like Response, framed like a
+// QUICRequest.
+func QUICResponse(tID TxID, addrPort netip.AddrPort) []byte {
+	res := Response(tID, addrPort)
+	return appendQUICHeader(make([]byte, 0, quicHeaderLen+len(res)), tID, res)
+}
+
+func appendQUICHeader(b []byte, tID TxID, msg []byte) []byte {
+	b = append(b, 0xc0) // long header, fixed bit, Initial
+	b = appendU32(b, QUICVersion)
+	b = append(b, 8)
+	b = append(b, tID[:8]...)
+	b = append(b, 0)
+	b = appendU16(b, uint16(len(msg)))
+	return append(b, msg...)
+}
+
+// IsQUIC reports whether b is a QUIC-framed STUN packet, as made by
+// QUICRequest or QUICResponse.
+func IsQUIC(b []byte) bool {
+	_, ok := FromQUIC(b)
+	return ok
+}
+
+// FromQUIC returns the STUN message within the QUIC-framed STUN packet
+// b. It reports false if b isn't one.
+func FromQUIC(b []byte) (msg []byte, ok bool) {
+	if len(b) < quicHeaderLen ||
+		b[0]&0xc0 != 0xc0 ||
+		binary.BigEndian.Uint32(b[1:5]) != QUICVersion ||
+		b[5] != 8 ||
+		b[14] != 0 {
+		return nil, false
+	}
+	n := int(binary.BigEndian.Uint16(b[15:17]))
+	msg = b[quicHeaderLen:]
+	if len(msg) < n || !Is(msg[:n]) {
+		return nil, false
+	}
+	return msg[:n], true
+}
+
+// FromQUICRequest is like FromQUIC, for a server checking a request:
+// it also reports false if b is smaller than a QUICRequest, so that
+// the response, which is larger than a short request, can't be used to
+// amplify traffic sent with a spoofed source address.
+func FromQUICRequest(b []byte) (msg []byte, ok bool) {
+	if len(b) < quicMinRequestLen {
+		return nil, false
+	}
+	return FromQUIC(b)
+}
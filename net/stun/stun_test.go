@@ -275,3 +275,60 @@ func TestResponse(t *testing.T) {
 		}
 	}
 }
+
+func TestQUIC(t *testing.T) {
+	tx := stun.NewTxID()
+	req := stun.QUICRequest(tx)
+	if len(req) < 1200 {
+		t.Errorf("QUIC request is %d bytes; want at least 1200", len(req))
+	}
+	if stun.Is(req) {
+		t.Error("QUIC request looks like plain STUN")
+	}
+	msg, ok := stun.FromQUIC(req)
+	if !ok {
+		t.Fatal("FromQUIC(request) not ok")
+	}
+	gotTx, err := stun.ParseBindingRequest(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotTx != tx {
+		t.Errorf("request txID = %x; want %x", gotTx, tx)
+	}
+
+	addr := netip.MustParseAddrPort("1.2.3.4:5678")
+	res := stun.QUICResponse(tx, addr)
+	if len(res) >= len(req) {
+		t.Errorf("QUIC response is %d bytes, not smaller than the %d byte request", len(res), len(req))
+	}
+	msg, ok = stun.FromQUIC(res)
+	if !ok {
+		t.Fatal("FromQUIC(response) not ok")
+	}
+	gotTx, gotAddr, err := stun.ParseResponse(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotTx != tx || gotAddr != addr {
+		t.Errorf("response = %x, %v; want %x, %v", gotTx, gotAddr, tx, addr)
+	}
+
+	for _, b := range [][]byte{nil, stun.Request(tx), req[:16], res[:len(res)-1]} {
+		if stun.IsQUIC(b) {
+			t.Errorf("IsQUIC(%x) = true", b)
+		}
+	}
+
+	// A server only answers requests at least as large as the padded
+	// ones, so that it doesn't amplify traffic.
+	if _, ok := stun.FromQUICRequest(req); !ok {
+		t.Error("FromQUICRequest(request) not ok")
+	}
+	short := append(res[:len(res):len(res)], make([]byte, 100)...)
+	for _, b := range [][]byte{res, short, req[:len(req)-1]} {
+		if _, ok := stun.FromQUICRequest(b); ok {
+			t.Errorf("FromQUICRequest of %d bytes ok", len(b))
+		}
+	}
+}
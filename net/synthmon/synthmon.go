@@ -0,0 +1,262 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package synthmon periodically runs cheap synthetic checks of a node's
+// connectivity, such as a DNS lookup or a ping, and keeps a history of
+// their results and latencies.
+package synthmon
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/health"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/multierr"
+)
+
+const (
+	// checkInterval is how often the checks run.
+	checkInterval = time.Minute
+
+	// checkTimeout bounds each run of a check.
+	checkTimeout = 10 * time.Second
+
+	// maxResults is the number of results kept per check, two hours'
+	// worth at checkInterval.
+	maxResults = 120
+
+	// failThreshold is the number of consecutive failures after which
+	// a check is considered to be failing, raising a health warning.
+	failThreshold = 3
+
+	// timeLayout is the layout of times in logs, which are in UTC.
+	timeLayout = "15:04:05Z"
+)
+
+// Check is a synthetic check.
+type Check struct {
+	Name string
+	Run  func(context.Context) error
+}
+
+// Result is the result of one run of a check.
+type Result struct {
+	Time    time.Time
+	Latency time.Duration
+	Err     string `json:",omitempty"`
+}
+
+// Series is the recent results of a check.
+type Series struct {
+	Name    string
+	Results []Result // oldest first
+}
+
+// Monitor runs checks every minute, recording their results. Checks
+// that fail failThreshold times in a row raise a health warning.
+type Monitor struct {
+	logf   logger.Logf
+	checks func() []Check
+	stop   chan struct{}
+	done   chan struct{}
+
+	mu      sync.Mutex
+	results map[string][]Result // by check name, oldest first
+}
+
+// New returns a new Monitor that has started running the checks
+// returned by checks, which is called before each round so that the
+// checks can follow configuration changes. Close stops it.
+func New(logf logger.Logf, checks func() []Check) *Monitor {
+	m := &Monitor{
+		logf:   logger.WithPrefix(logf, "synthmon: "),
+		checks: checks,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go m.run()
+	return m
+}
+
+// Close stops m and clears its health warning.
+func (m *Monitor) Close() error {
+	close(m.stop)
+	<-m.done
+	health.SetSyntheticHealth(nil)
+	return nil
+}
+
+func (m *Monitor) run() {
+	defer close(m.done)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-m.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	t := time.NewTicker(checkInterval)
+	defer t.Stop()
+	for {
+		m.runChecks(ctx)
+		select {
+		case <-m.stop:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// runChecks runs each check concurrently and records the results.
+func (m *Monitor) runChecks(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, c := range m.checks() {
+		c := c
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+			defer cancel()
+			t0 := time.Now()
+			err := c.Run(ctx)
+			r := Result{Time: t0, Latency: time.Since(t0).Round(time.Millisecond)}
+			if err != nil {
+				if ctx.Err() != nil && ctx.Err() != context.DeadlineExceeded {
+					return // stopped
+				}
+				r.Err = err.Error()
+			}
+			m.record(c.Name, r)
+		}()
+	}
+	wg.Wait()
+	health.SetSyntheticHealth(m.healthError())
+}
+
+// record appends r to the results of the check name, logging when the
+// check starts or stops failing.
+func (m *Monitor) record(name string, r Result) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	wasFailing := failing(m.results[name])
+	rs := append(m.results[name], r)
+	if len(rs) > maxResults {
+		rs = append(rs[:0], rs[len(rs)-maxResults:]...)
+	}
+	if m.results == nil {
+		m.results = map[string][]Result{}
+	}
+	m.results[name] = rs
+	switch isFailing := failing(rs); {
+	case isFailing && !wasFailing:
+		m.logf("%s: failing: %s", name, r.Err)
+	case !isFailing && wasFailing && r.Err == "":
+		m.logf("%s: recovered", name)
+	}
+}
+
+// failing reports whether the last failThreshold results all failed.
+func failing(rs []Result) bool {
+	if len(rs) < failThreshold {
+		return false
+	}
+	for _, r := range rs[len(rs)-failThreshold:] {
+		if r.Err == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// healthError returns an error describing the failing checks, or nil if
+// none are failing.
+func (m *Monitor) healthError() error {
+	var errs []error
+	for _, s := range m.Series() {
+		if failing(s.Results) {
+			last := s.Results[len(s.Results)-1]
+			errs = append(errs, fmt.Errorf("synthetic check %q failing since %v: %s",
+				s.Name, failingSince(s.Results).UTC().Format(timeLayout), last.Err))
+		}
+	}
+	return multierr.New(errs...)
+}
+
+// failingSince returns the time of the first of the trailing run of
+// failed results in rs.
+func failingSince(rs []Result) time.Time {
+	i := len(rs)
+	for i > 0 && rs[i-1].Err != "" {
+		i--
+	}
+	if i == len(rs) {
+		return time.Time{}
+	}
+	return rs[i].Time
+}
+
+// Series returns the recent results of each check, sorted by name.
+func (m *Monitor) Series() []Series {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ret := make([]Series, 0, len(m.results))
+	for name, rs := range m.results {
+		ret = append(ret, Series{Name: name, Results: append([]Result(nil), rs...)})
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
+
+// LogSummary logs a one-line summary of each check's recent results to
+// logf, for bug reports.
+func (m *Monitor) LogSummary(logf logger.Logf) {
+	series := m.Series()
+	if len(series) == 0 {
+		logf("no results yet")
+		return
+	}
+	lines := make([]string, len(series))
+	for i, s := range series {
+		lines[i] = summarize(s)
+	}
+	logf("%s", strings.Join(lines, "\n"))
+}
+
+// summarize returns a one-line summary of s: how many runs succeeded,
+// their latencies, and when the check last failed or started failing.
+func summarize(s Series) string {
+	var sb strings.Builder
+	var lat []time.Duration
+	var lastFail *Result
+	for i, r := range s.Results {
+		if r.Err == "" {
+			lat = append(lat, r.Latency)
+		} else {
+			lastFail = &s.Results[i]
+		}
+	}
+	fmt.Fprintf(&sb, "%s: %d/%d ok", s.Name, len(lat), len(s.Results))
+	if len(s.Results) > 0 {
+		fmt.Fprintf(&sb, " since %v", s.Results[0].Time.UTC().Format(timeLayout))
+	}
+	if len(lat) > 0 {
+		sort.Slice(lat, func(i, j int) bool { return lat[i] < lat[j] })
+		fmt.Fprintf(&sb, ", latency p50 %v max %v", lat[len(lat)/2], lat[len(lat)-1])
+	}
+	switch {
+	case failing(s.Results):
+		fmt.Fprintf(&sb, "; failing since %v: %s", failingSince(s.Results).UTC().Format(timeLayout), lastFail.Err)
+	case lastFail != nil:
+		fmt.Fprintf(&sb, "; last failed %v: %s", lastFail.Time.UTC().Format(timeLayout), lastFail.Err)
+	}
+	return sb.String()
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package synthmon
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMonitorRecord(t *testing.T) {
+	var logs []string
+	m := &Monitor{logf: func(format string, a ...any) {
+		logs = append(logs, format)
+	}}
+	t0 := time.Date(2022, 10, 1, 14, 30, 0, 0, time.UTC)
+	ok := func(i int, lat time.Duration) {
+		m.record("dns", Result{Time: t0.Add(time.Duration(i) * time.Minute), Latency: lat})
+	}
+	fail := func(i int) {
+		m.record("dns", Result{Time: t0.Add(time.Duration(i) * time.Minute), Err: "timeout"})
+	}
+
+	ok(0, 10*time.Millisecond)
+	ok(1, 30*time.Millisecond)
+	fail(2)
+	if err := m.healthError(); err != nil {
+		t.Fatalf("after one failure: health = %v; want nil", err)
+	}
+	fail(3)
+	fail(4)
+	err := m.healthError()
+	if err == nil || !strings.Contains(err.Error(), "failing since 14:32:00Z") {
+		t.Fatalf("after three failures: health = %v; want failing since 14:32:00Z", err)
+	}
+	if len(logs) != 1 {
+		t.Errorf("logs = %q; want one failing log", logs)
+	}
+
+	const wantFailing = "dns: 2/5 ok since 14:30:00Z, latency p50 30ms max 30ms; failing since 14:32:00Z: timeout"
+	if got := summarize(m.Series()[0]); got != wantFailing {
+		t.Errorf("summary =\n%s\nwant\n%s", got, wantFailing)
+	}
+
+	ok(5, 20*time.Millisecond)
+	if err := m.healthError(); err != nil {
+		t.Errorf("after recovery: health = %v; want nil", err)
+	}
+	if len(logs) != 2 {
+		t.Errorf("logs = %q; want failing and recovered logs", logs)
+	}
+	const wantRecovered = "dns: 3/6 ok since 14:30:00Z, latency p50 20ms max 30ms; last failed 14:34:00Z: timeout"
+	if got := summarize(m.Series()[0]); got != wantRecovered {
+		t.Errorf("summary =\n%s\nwant\n%s", got, wantRecovered)
+	}
+
+	for i := 0; i < maxResults; i++ {
+		ok(6+i, time.Millisecond)
+	}
+	if got := len(m.Series()[0].Results); got != maxResults {
+		t.Errorf("kept %d results; want %d", got, maxResults)
+	}
+}
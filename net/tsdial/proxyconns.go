@@ -0,0 +1,194 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsdial
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tailscale.com/types/logger"
+	"tailscale.com/types/proxyconn"
+	"tailscale.com/util/clientmetric"
+)
+
+// maxClosedProxyConns is how many closed or failed proxy connections
+// are kept for ProxyConns.
+const maxClosedProxyConns = 100
+
+var (
+	metricProxyConns      = clientmetric.NewCounter("tsdial_proxy_conns")
+	metricProxyDialErrors = clientmetric.NewCounter("tsdial_proxy_dial_errors")
+)
+
+// proxyConnTracker tracks the connections of the outbound proxies.
+type proxyConnTracker struct {
+	mu     sync.Mutex
+	nextID int64
+	total  int64
+	failed int64
+	open   map[int64]*proxyConn
+	closed []proxyconn.Conn // oldest first
+}
+
+// ProxyDial returns a func like UserDial for the named outbound proxy,
+// "socks5" or "http", whose connections are logged and tracked for
+// ProxyConns.
+func (d *Dialer) ProxyDial(proxy string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return d.proxyDial(ctx, proxy, network, addr)
+	}
+}
+
+func (d *Dialer) proxyDial(ctx context.Context, proxy, network, addr string) (net.Conn, error) {
+	t := &d.proxyConns
+	t.mu.Lock()
+	t.nextID++
+	t.total++
+	info := proxyconn.Conn{
+		ID:     t.nextID,
+		Proxy:  proxy,
+		Target: addr,
+		Start:  time.Now(),
+	}
+	t.mu.Unlock()
+	metricProxyConns.Add(1)
+
+	c, err := d.userDial(ctx, network, addr, &info)
+	if err != nil {
+		metricProxyDialErrors.Add(1)
+		info.End = time.Now()
+		info.Err = err.Error()
+		d.logf()("[v1] proxy: %s conn %d to %s failed after %v: %v", proxy, info.ID, addr, info.End.Sub(info.Start).Round(time.Millisecond), err)
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.failed++
+		t.addClosedLocked(info)
+		return nil, err
+	}
+	d.logf()("[v1] proxy: %s conn %d to %s (%s by %s) via %s", proxy, info.ID, addr, info.Resolved, info.ResolvedBy, info.Route)
+	pc := &proxyConn{Conn: c, d: d, info: info}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.open == nil {
+		t.open = make(map[int64]*proxyConn)
+	}
+	t.open[info.ID] = pc
+	return pc, nil
+}
+
+func (d *Dialer) logf() logger.Logf {
+	if d.Logf == nil {
+		return logger.Discard
+	}
+	return d.Logf
+}
+
+func (t *proxyConnTracker) addClosedLocked(info proxyconn.Conn) {
+	t.closed = append(t.closed, info)
+	if len(t.closed) > maxClosedProxyConns {
+		t.closed = append(t.closed[:0], t.closed[len(t.closed)-maxClosedProxyConns:]...)
+	}
+}
+
+// ProxyConns returns the open connections of the outbound proxies and
+// the most recent closed ones.
+func (d *Dialer) ProxyConns() *proxyconn.Conns {
+	t := &d.proxyConns
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	st := &proxyconn.Conns{
+		Total:  t.total,
+		Failed: t.failed,
+		Conns:  append([]proxyconn.Conn(nil), t.closed...),
+	}
+	for _, pc := range t.open {
+		st.Conns = append(st.Conns, pc.snapshotLocked())
+	}
+	sort.Slice(st.Conns, func(i, j int) bool { return st.Conns[i].ID < st.Conns[j].ID })
+	return st
+}
+
+// proxyConn is a connection of an outbound proxy to its target,
+// counting the bytes through it.
+type proxyConn struct {
+	net.Conn
+	d *Dialer
+
+	bytesIn  atomic.Int64
+	bytesOut atomic.Int64
+
+	// info's End and Err are guarded by d.proxyConns.mu; the rest
+	// is immutable.
+	info      proxyconn.Conn
+	closeOnce sync.Once
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.bytesIn.Add(int64(n))
+	if err != nil {
+		c.noteErr(err)
+	}
+	return n, err
+}
+
+func (c *proxyConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.bytesOut.Add(int64(n))
+	if err != nil {
+		c.noteErr(err)
+	}
+	return n, err
+}
+
+// noteErr records err as the connection's error, unless it already
+// has one or err is just the end of the connection.
+func (c *proxyConn) noteErr(err error) {
+	if err == io.EOF || errors.Is(err, net.ErrClosed) {
+		return
+	}
+	t := &c.d.proxyConns
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if c.info.Err == "" {
+		c.info.Err = err.Error()
+	}
+}
+
+func (c *proxyConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() {
+		t := &c.d.proxyConns
+		t.mu.Lock()
+		c.info.End = time.Now()
+		info := c.snapshotLocked()
+		delete(t.open, info.ID)
+		t.addClosedLocked(info)
+		t.mu.Unlock()
+
+		msg := ""
+		if info.Err != "" {
+			msg = "; error: " + info.Err
+		}
+		c.d.logf()("[v1] proxy: %s conn %d to %s closed after %v, %d bytes in, %d out%s",
+			info.Proxy, info.ID, info.Target, info.End.Sub(info.Start).Round(time.Millisecond), info.BytesIn, info.BytesOut, msg)
+	})
+	return err
+}
+
+// snapshotLocked returns the connection's info with its byte counts
+// as of now. d.proxyConns.mu must be held.
+func (c *proxyConn) snapshotLocked() proxyconn.Conn {
+	info := c.info
+	info.BytesIn = c.bytesIn.Load()
+	info.BytesOut = c.bytesOut.Load()
+	return info
+}
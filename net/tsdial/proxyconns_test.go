@@ -0,0 +1,67 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsdial
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+)
+
+func TestProxyConns(t *testing.T) {
+	var remote net.Conn
+	d := &Dialer{
+		Logf:             t.Logf,
+		UseNetstackForIP: func(netip.Addr) bool { return true },
+		NetstackDialTCP: func(context.Context, netip.AddrPort) (net.Conn, error) {
+			c1, c2 := net.Pipe()
+			remote = c2
+			return c1, nil
+		},
+	}
+	dial := d.ProxyDial("socks5")
+	ctx := context.Background()
+
+	if _, err := dial(ctx, "tcp", "no-port"); err == nil {
+		t.Fatal("dial of malformed address succeeded")
+	}
+	c, err := dial(ctx, "tcp", "100.64.0.1:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		io.ReadFull(remote, make([]byte, 5))
+		remote.Write([]byte("hi"))
+	}()
+	if _, err := c.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(c, make([]byte, 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	st := d.ProxyConns()
+	if st.Total != 2 || st.Failed != 1 || len(st.Conns) != 2 {
+		t.Fatalf("got %+v; want 2 conns, 1 failed", st)
+	}
+	if failed := st.Conns[0]; failed.Err == "" || failed.End.IsZero() || failed.Resolved != "" {
+		t.Errorf("failed conn = %+v", failed)
+	}
+	open := st.Conns[1]
+	if open.Proxy != "socks5" || open.Target != "100.64.0.1:80" || open.Resolved != "100.64.0.1:80" ||
+		open.ResolvedBy != "literal" || open.Route != "netstack" || !open.End.IsZero() ||
+		open.BytesIn != 2 || open.BytesOut != 5 || open.Err != "" {
+		t.Errorf("open conn = %+v", open)
+	}
+
+	c.Close()
+	c.Close()
+	st = d.ProxyConns()
+	if len(st.Conns) != 2 || st.Conns[1].End.IsZero() {
+		t.Errorf("after close, conns = %+v", st.Conns)
+	}
+}
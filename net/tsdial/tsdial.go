@@ -24,6 +24,7 @@
 	"tailscale.com/net/netns"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/netmap"
+	"tailscale.com/types/proxyconn"
 	"tailscale.com/util/mak"
 	"tailscale.com/wgengine/monitor"
 )
@@ -61,6 +62,8 @@ type Dialer struct {
 	dnsCache          *dnscache.MessageCache // nil until first first non-empty SetExitDNSDoH
 	nextSysConnID     int
 	activeSysConns    map[int]net.Conn // active connections not yet closed
+
+	proxyConns proxyConnTracker // of ProxyDial; see proxyconns.go
 }
 
 // sysConn wraps a net.Conn that was created using d.SystemDial.
@@ -204,7 +207,10 @@ func (d *Dialer) SetNetMap(nm *netmap.NetworkMap) {
 	d.dns = m
 }
 
-func (d *Dialer) userDialResolve(ctx context.Context, network, addr string) (netip.AddrPort, error) {
+// userDialResolve resolves addr for UserDial. It also returns how it
+// was resolved: "literal", "magicdns" or "dns", as documented on
+// proxyconn.Conn.ResolvedBy.
+func (d *Dialer) userDialResolve(ctx context.Context, network, addr string) (_ netip.AddrPort, resolvedBy string, err error) {
 	d.mu.Lock()
 	dns := d.dns
 	exitDNSDoH := d.exitDNSDoHBase
@@ -213,7 +219,14 @@ func (d *Dialer) userDialResolve(ctx context.Context, network, addr string) (net
 	// MagicDNS or otherwise baked in to the NetworkMap? Try that first.
 	ipp, err := dns.resolveMemory(ctx, network, addr)
 	if err != errUnresolved {
-		return ipp, err
+		if err != nil {
+			return ipp, "", err
+		}
+		resolvedBy = "magicdns"
+		if host, _, _ := net.SplitHostPort(addr); isIPLiteral(host) {
+			resolvedBy = "literal"
+		}
+		return ipp, resolvedBy, nil
 	}
 
 	// Otherwise, hit the network.
@@ -223,7 +236,7 @@ func (d *Dialer) userDialResolve(ctx context.Context, network, addr string) (net
 	host, port, err := splitHostPort(addr)
 	if err != nil {
 		// addr is malformed.
-		return netip.AddrPort{}, err
+		return netip.AddrPort{}, "", err
 	}
 
 	var r net.Resolver
@@ -241,13 +254,18 @@ func (d *Dialer) userDialResolve(ctx context.Context, network, addr string) (net
 
 	ips, err := r.LookupIP(ctx, ipNetOfNetwork(network), host)
 	if err != nil {
-		return netip.AddrPort{}, err
+		return netip.AddrPort{}, "dns", err
 	}
 	if len(ips) == 0 {
-		return netip.AddrPort{}, fmt.Errorf("DNS lookup returned no results for %q", host)
+		return netip.AddrPort{}, "dns", fmt.Errorf("DNS lookup returned no results for %q", host)
 	}
 	ip, _ := netip.AddrFromSlice(ips[0])
-	return netip.AddrPortFrom(ip.Unmap(), port), nil
+	return netip.AddrPortFrom(ip.Unmap(), port), "dns", nil
+}
+
+func isIPLiteral(host string) bool {
+	_, err := netip.ParseAddr(host)
+	return err == nil
 }
 
 // ipNetOfNetwork returns "ip", "ip4", or "ip6" corresponding
@@ -302,11 +320,28 @@ func (d *Dialer) SystemDial(ctx context.Context, network, addr string) (net.Conn
 // UserDial connects to the provided network address as if a user were initiating the dial.
 // (e.g. from a SOCKS or HTTP outbound proxy)
 func (d *Dialer) UserDial(ctx context.Context, network, addr string) (net.Conn, error) {
-	ipp, err := d.userDialResolve(ctx, network, addr)
+	return d.userDial(ctx, network, addr, nil)
+}
+
+// userDial is UserDial, also filling in the resolution and route of
+// addr in info, if non-nil.
+func (d *Dialer) userDial(ctx context.Context, network, addr string, info *proxyconn.Conn) (net.Conn, error) {
+	ipp, resolvedBy, err := d.userDialResolve(ctx, network, addr)
+	if info != nil {
+		info.ResolvedBy = resolvedBy
+	}
 	if err != nil {
 		return nil, err
 	}
-	if d.UseNetstackForIP != nil && d.UseNetstackForIP(ipp.Addr()) {
+	useNetstack := d.UseNetstackForIP != nil && d.UseNetstackForIP(ipp.Addr())
+	if info != nil {
+		info.Resolved = ipp.String()
+		info.Route = "system"
+		if useNetstack {
+			info.Route = "netstack"
+		}
+	}
+	if useNetstack {
 		if d.NetstackDialTCP == nil {
 			return nil, errors.New("Dialer not initialized correctly")
 		}
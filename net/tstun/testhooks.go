@@ -0,0 +1,72 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tstun
+
+import (
+	"tailscale.com/wgengine/filter"
+)
+
+// This file holds hooks for tests, including those of other packages,
+// to drive a Wrapper's filter, netstack and disco paths end to end
+// without a real TUN device or root. They're not for use outside tests.
+
+// InjectInboundForTest makes the Wrapper behave as if WireGuard had
+// received pkt from a peer: unlike InjectInboundCopy, it passes through
+// the inbound filters, and is written to the TUN device only if they
+// accept it. It returns the filters' verdict.
+func (t *Wrapper) InjectInboundForTest(pkt []byte) (filter.Response, error) {
+	if len(pkt) > MaxPacketSize {
+		return filter.Drop, errPacketTooBig
+	}
+	buf := make([]byte, PacketStartOffset+len(pkt))
+	copy(buf[PacketStartOffset:], pkt)
+	if !t.disableFilter {
+		if res := t.filterIn(buf[PacketStartOffset:]); res != filter.Accept {
+			return res, nil
+		}
+	}
+	_, err := t.tdevWrite(buf, PacketStartOffset)
+	return filter.Accept, err
+}
+
+// InjectOutboundFromTUNForTest makes the Wrapper behave as if the OS had
+// sent pkt into the TUN device: unlike InjectOutbound, it passes through
+// the outbound filters when WireGuard reads it.
+func (t *Wrapper) InjectOutboundFromTUNForTest(pkt []byte) error {
+	if len(pkt) > MaxPacketSize {
+		return errPacketTooBig
+	}
+	if len(pkt) == 0 {
+		return nil
+	}
+	t.sendOutbound(tunReadResult{data: append([]byte(nil), pkt...)})
+	return nil
+}
+
+// SetCaptureForTest sets funcs to call with a copy of each packet the
+// Wrapper writes to the TUN device (inbound), and of each packet
+// WireGuard reads from it to send to peers (outbound), after filtering.
+// Injected packets are captured too. Either may be nil to stop
+// capturing that direction.
+func (t *Wrapper) SetCaptureForTest(inbound, outbound func(pkt []byte)) {
+	t.captureIn.Store(inbound)
+	t.captureOut.Store(outbound)
+}
+
+// captureInbound passes a copy of pkt to the inbound capture func, if
+// set.
+func (t *Wrapper) captureInbound(pkt []byte) {
+	if f := t.captureIn.Load(); f != nil {
+		f(append([]byte(nil), pkt...))
+	}
+}
+
+// captureOutbound passes a copy of pkt to the outbound capture func,
+// if set.
+func (t *Wrapper) captureOutbound(pkt []byte) {
+	if f := t.captureOut.Load(); f != nil {
+		f(append([]byte(nil), pkt...))
+	}
+}
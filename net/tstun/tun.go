@@ -28,6 +28,12 @@ func init() {
 	}
 }
 
+// ConfiguredMTU returns the MTU New creates the Tailscale interface
+// with: DefaultMTU, unless overridden with the TS_DEBUG_MTU envknob.
+func ConfiguredMTU() int {
+	return tunMTU
+}
+
 // createTAP is non-nil on Linux.
 var createTAP func(tapName, bridgeName string) (tun.Device, error)
 
@@ -23,6 +23,7 @@
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
 	"tailscale.com/disco"
 	"tailscale.com/net/packet"
+	"tailscale.com/net/pktpath"
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/syncs"
 	"tailscale.com/tstime/mono"
@@ -157,15 +158,32 @@ type Wrapper struct {
 	// false otherwise.
 	OnICMPEchoResponseReceived func(*packet.Parsed) bool
 
+	// OnICMPErrorReceived, if non-nil, is called whenever an ICMP
+	// Time Exceeded or Destination Unreachable error arrives. If the
+	// packet is to be handled internally this returns true, false
+	// otherwise.
+	OnICMPErrorReceived func(*packet.Parsed) bool
+
 	// PeerAPIPort, if non-nil, returns the peerapi port that's
 	// running for the given IP address.
 	PeerAPIPort func(netip.Addr) (port uint16, ok bool)
 
+	// RespondToEcho, if non-nil, reports whether ICMP echo requests
+	// to the given IP, one of this node's own, should be answered by
+	// the Wrapper itself once the packet filter accepts them, rather
+	// than by the host OS or netstack, which might not answer.
+	RespondToEcho func(netip.Addr) bool
+
 	// disableFilter disables all filtering when set. This should only be used in tests.
 	disableFilter bool
 
 	// disableTSMPRejected disables TSMP rejected responses. For tests.
 	disableTSMPRejected bool
+
+	// captureIn and captureOut, if set, are called with copies of
+	// packets as they leave the Wrapper. See SetCaptureForTest.
+	captureIn  syncs.AtomicValue[func([]byte)]
+	captureOut syncs.AtomicValue[func([]byte)]
 }
 
 // tunReadResult is the result of a TUN read, or an injected result pretending to be a TUN read.
@@ -521,6 +539,7 @@ func (t *Wrapper) Read(buf []byte, offset int) (int, error) {
 	}
 
 	metricPacketOut.Add(1)
+	start := pktpath.Start()
 
 	var n int
 	if res.packet != nil {
@@ -550,9 +569,13 @@ func (t *Wrapper) Read(buf []byte, offset int) (int, error) {
 		}
 	}
 
+	pktpath.TUNRead.Done(start)
+
 	// Do not filter injected packets.
 	if !res.injected && !t.disableFilter {
+		start := pktpath.Start()
 		response := t.filterOut(p)
+		pktpath.FilterOut.Done(start)
 		if response != filter.Accept {
 			metricPacketOutDrop.Add(1)
 			// WireGuard considers read errors fatal; pretend nothing was read
@@ -561,6 +584,7 @@ func (t *Wrapper) Read(buf []byte, offset int) (int, error) {
 	}
 
 	t.noteActivity()
+	t.captureOutbound(buf[offset : offset+n])
 	return n, nil
 }
 
@@ -589,6 +613,12 @@ func (t *Wrapper) filterIn(buf []byte) filter.Response {
 		}
 	}
 
+	if p.IsError() {
+		if f := t.OnICMPErrorReceived; f != nil && f(p) {
+			return filter.DropSilently
+		}
+	}
+
 	// Issue 1526 workaround: if we see disco packets over
 	// Tailscale from ourselves, then drop them, as that shouldn't
 	// happen unless a networking stack is confused, as it seems
@@ -652,6 +682,12 @@ func (t *Wrapper) filterIn(buf []byte) filter.Response {
 		return filter.Drop
 	}
 
+	if p.IsEchoRequest() && t.RespondToEcho != nil && t.RespondToEcho(p.Dst.Addr()) {
+		t.injectEchoResponse(p)
+		metricPacketInEchoResponded.Add(1)
+		return filter.DropSilently
+	}
+
 	if t.PostFilterIn != nil {
 		if res := t.PostFilterIn(p, t); res.IsDrop() {
 			return res
@@ -666,7 +702,10 @@ func (t *Wrapper) filterIn(buf []byte) filter.Response {
 func (t *Wrapper) Write(buf []byte, offset int) (int, error) {
 	metricPacketIn.Add(1)
 	if !t.disableFilter {
-		if t.filterIn(buf[offset:]) != filter.Accept {
+		start := pktpath.Start()
+		response := t.filterIn(buf[offset:])
+		pktpath.FilterIn.Done(start)
+		if response != filter.Accept {
 			metricPacketInDrop.Add(1)
 			// If we're not accepting the packet, lie to wireguard-go and pretend
 			// that everything is okay with a nil error, so wireguard-go
@@ -686,10 +725,14 @@ func (t *Wrapper) Write(buf []byte, offset int) (int, error) {
 	}
 
 	t.noteActivity()
-	return t.tdevWrite(buf, offset)
+	start := pktpath.Start()
+	n, err := t.tdevWrite(buf, offset)
+	pktpath.TUNWrite.Done(start)
+	return n, err
 }
 
 func (t *Wrapper) tdevWrite(buf []byte, offset int) (int, error) {
+	t.captureInbound(buf[offset:])
 	if t.isTAP {
 		return t.tapWrite(buf, offset)
 	}
@@ -791,6 +834,24 @@ func (t *Wrapper) injectOutboundPong(pp *packet.Parsed, req packet.TSMPPingReque
 	t.InjectOutbound(packet.Generate(pong, nil))
 }
 
+// injectEchoResponse replies to the ICMP echo request pp.
+func (t *Wrapper) injectEchoResponse(pp *packet.Parsed) {
+	var h packet.Header
+	switch pp.IPVersion {
+	case 4:
+		h4 := pp.ICMP4Header()
+		h4.ToResponse()
+		h = h4
+	case 6:
+		h6 := pp.ICMP6Header()
+		h6.ToResponse()
+		h = h6
+	default:
+		return
+	}
+	t.InjectOutbound(packet.Generate(h, pp.Payload()))
+}
+
 // InjectOutbound makes the Wrapper device behave as if a packet
 // with the given contents was sent to the network.
 // It does not block, but takes ownership of the packet.
@@ -834,6 +895,7 @@ func (t *Wrapper) Unwrap() tun.Device {
 	metricPacketInDrop          = clientmetric.NewCounter("tstun_in_from_wg_drop")
 	metricPacketInDropFilter    = clientmetric.NewCounter("tstun_in_from_wg_drop_filter")
 	metricPacketInDropSelfDisco = clientmetric.NewCounter("tstun_in_from_wg_drop_self_disco")
+	metricPacketInEchoResponded = clientmetric.NewCounter("tstun_in_from_wg_echo_responded")
 
 	metricPacketOut              = clientmetric.NewCounter("tstun_out_to_wg")
 	metricPacketOutDrop          = clientmetric.NewCounter("tstun_out_to_wg_drop")
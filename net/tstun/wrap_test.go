@@ -533,3 +533,106 @@ func TestFilterDiscoLoop(t *testing.T) {
 		t.Errorf("log output mismatch\n got: %q\nwant: %q\n", got, want)
 	}
 }
+
+func TestRespondToEcho(t *testing.T) {
+	_, tun := newChannelTUN(t.Logf, false)
+	defer tun.Close()
+	matches := []filter.Match{
+		{IPProto: []ipproto.Proto{ipproto.ICMPv4}, Srcs: nets("5.6.7.8"), Dsts: netports("1.2.3.4:*", "1.2.3.5:*")},
+	}
+	var sb netipx.IPSetBuilder
+	sb.AddPrefix(netip.MustParsePrefix("1.2.0.0/16"))
+	ipSet, _ := sb.IPSet()
+	tun.SetFilter(filter.New(matches, ipSet, ipSet, nil, t.Logf))
+	tun.RespondToEcho = func(ip netip.Addr) bool {
+		return ip == netip.MustParseAddr("1.2.3.4")
+	}
+
+	echo := func(src, dst string) []byte {
+		h := packet.ICMP4Header{
+			IP4Header: packet.IP4Header{
+				IPProto: ipproto.ICMPv4,
+				Src:     netip.MustParseAddr(src),
+				Dst:     netip.MustParseAddr(dst),
+			},
+			Type: packet.ICMP4EchoRequest,
+			Code: packet.ICMP4NoCode,
+		}
+		return packet.Generate(h, []byte("\x00\x01\x00\x02ping"))
+	}
+
+	if got := tun.filterIn(echo("5.6.7.8", "1.2.3.5")); got != filter.Accept {
+		t.Errorf("echo to other IP: got %v; want Accept", got)
+	}
+	if got := tun.filterIn(echo("9.9.9.9", "1.2.3.4")); got != filter.Drop {
+		t.Errorf("echo from disallowed IP: got %v; want Drop", got)
+	}
+	if got := tun.filterIn(echo("5.6.7.8", "1.2.3.4")); got != filter.DropSilently {
+		t.Fatalf("echo to local IP: got %v; want DropSilently", got)
+	}
+
+	var buf [MaxPacketSize]byte
+	n, err := tun.Read(buf[:], 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p packet.Parsed
+	p.Decode(buf[:n])
+	if !p.IsEchoResponse() {
+		t.Fatalf("read %v; want echo response", &p)
+	}
+	if p.Src.Addr() != netip.MustParseAddr("1.2.3.4") || p.Dst.Addr() != netip.MustParseAddr("5.6.7.8") {
+		t.Errorf("response %v -> %v; want 1.2.3.4 -> 5.6.7.8", p.Src, p.Dst)
+	}
+	if got := string(p.Payload()); got != "\x00\x01\x00\x02ping" {
+		t.Errorf("response payload %q; want the request's", got)
+	}
+}
+
+func TestTestHooks(t *testing.T) {
+	_, tun := newFakeTUN(t.Logf, true)
+	defer tun.Close()
+
+	var in, out [][]byte
+	tun.SetCaptureForTest(func(p []byte) {
+		in = append(in, p)
+	}, func(p []byte) {
+		out = append(out, p)
+	})
+
+	allowed := udp4("5.6.7.8", "1.2.3.4", 98, 89)
+	if res, err := tun.InjectInboundForTest(allowed); err != nil || res != filter.Accept {
+		t.Fatalf("inject allowed: %v, %v; want Accept", res, err)
+	}
+	denied := udp4("9.9.9.9", "1.2.3.4", 98, 89)
+	if res, err := tun.InjectInboundForTest(denied); err != nil || res != filter.Drop {
+		t.Fatalf("inject denied: %v, %v; want Drop", res, err)
+	}
+	if len(in) != 1 || !bytes.Equal(in[0], allowed) {
+		t.Errorf("captured inbound %x; want only %x", in, allowed)
+	}
+
+	reply := udp4("1.2.3.4", "5.6.7.8", 89, 98)
+	if err := tun.InjectOutboundFromTUNForTest(reply); err != nil {
+		t.Fatal(err)
+	}
+	var buf [MaxPacketSize]byte
+	n, err := tun.Read(buf[:], 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], reply) {
+		t.Errorf("read %x; want %x", buf[:n], reply)
+	}
+	if len(out) != 1 || !bytes.Equal(out[0], reply) {
+		t.Errorf("captured outbound %x; want only %x", out, reply)
+	}
+
+	tun.SetCaptureForTest(nil, nil)
+	if _, err := tun.InjectInboundForTest(allowed); err != nil {
+		t.Fatal(err)
+	}
+	if len(in) != 1 {
+		t.Errorf("captured %d inbound packets after capture was unset; want 1", len(in))
+	}
+}
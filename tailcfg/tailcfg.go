@@ -79,7 +79,8 @@
 //   - 40: 2022-08-22: added Node.KeySignature, PeersChangedPatch.KeySignature
 //   - 41: 2022-08-30: uses 100.100.100.100 for route-less ExtraRecords if global nameservers is set
 //   - 42: 2022-09-06: NextDNS DoH support; see https://github.com/tailscale/tailscale/pull/5556
-const CurrentCapabilityVersion CapabilityVersion = 42
+//   - 43: 2022-10-17: client understands DNSConfig.RouteStrategies
+const CurrentCapabilityVersion CapabilityVersion = 43
 
 type StableID string
 
@@ -506,6 +507,11 @@ type Hostinfo struct {
 	Userspace       opt.Bool       `json:",omitempty"` // if the client is running in userspace (netstack) mode
 	UserspaceRouter opt.Bool       `json:",omitempty"` // if the client's subnet router is running in userspace (netstack) mode
 
+	// WoLMACs are the MAC addresses ("xx:xx:xx:xx:xx:xx") that other
+	// nodes on the same LAN can send Wake-on-LAN packets to in order
+	// to wake this machine.
+	WoLMACs []string `json:",omitempty"`
+
 	// NOTE: any new fields containing pointers in this type
 	//       require changes to Hostinfo.Equal.
 }
@@ -1082,6 +1088,12 @@ type DNSConfig struct {
 	// as for the purpose of handling ExtraRecords.
 	Routes map[string][]*dnstype.Resolver `json:",omitempty"`
 
+	// RouteStrategies maps Routes keys to how queries are sent to
+	// their resolvers when there's more than one. Routes without an
+	// entry use dnstype.StrategyRace. The key "." sets the strategy
+	// of Resolvers.
+	RouteStrategies map[string]dnstype.Strategy `json:",omitempty"`
+
 	// FallbackResolvers is like Resolvers, but is only used if a
 	// split DNS configuration is requested in a configuration that
 	// doesn't work yet without explicit default resolvers.
@@ -1601,6 +1613,12 @@ type Oauth2Token struct {
 	CapabilityDebugPeer = "https://tailscale.com/cap/debug-peer"
 	// CapabilityWakeOnLAN grants the ability to send a Wake-On-LAN packet.
 	CapabilityWakeOnLAN = "https://tailscale.com/cap/wake-on-lan"
+	// CapabilityDoctorPeer grants the ability to ask a node to run its
+	// doctor checks and return the results, if the node allows it.
+	CapabilityDoctorPeer = "https://tailscale.com/cap/doctor-peer"
+	// CapabilitySpeedTestPeer grants the ability to run a throughput
+	// test against a node, served by its tailscaled.
+	CapabilitySpeedTestPeer = "https://tailscale.com/cap/speedtest-peer"
 )
 
 // SetDNSRequest is a request to add a DNS record.
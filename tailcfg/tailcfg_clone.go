@@ -110,6 +110,7 @@ func (src *Hostinfo) Clone() *Hostinfo {
 	dst.Services = append(src.Services[:0:0], src.Services...)
 	dst.NetInfo = src.NetInfo.Clone()
 	dst.SSH_HostKeys = append(src.SSH_HostKeys[:0:0], src.SSH_HostKeys...)
+	dst.WoLMACs = append(src.WoLMACs[:0:0], src.WoLMACs...)
 	return dst
 }
 
@@ -141,6 +142,7 @@ func (src *Hostinfo) Clone() *Hostinfo {
 	Cloud           string
 	Userspace       opt.Bool
 	UserspaceRouter opt.Bool
+	WoLMACs         []string
 }{})
 
 // Clone makes a deep copy of NetInfo.
@@ -217,6 +219,12 @@ func (src *DNSConfig) Clone() *DNSConfig {
 			dst.Routes[k] = append([]*dnstype.Resolver{}, src.Routes[k]...)
 		}
 	}
+	if dst.RouteStrategies != nil {
+		dst.RouteStrategies = map[string]dnstype.Strategy{}
+		for k, v := range src.RouteStrategies {
+			dst.RouteStrategies[k] = v
+		}
+	}
 	dst.FallbackResolvers = make([]*dnstype.Resolver, len(src.FallbackResolvers))
 	for i := range dst.FallbackResolvers {
 		dst.FallbackResolvers[i] = src.FallbackResolvers[i].Clone()
@@ -233,6 +241,7 @@ func (src *DNSConfig) Clone() *DNSConfig {
 var _DNSConfigCloneNeedsRegeneration = DNSConfig(struct {
 	Resolvers           []*dnstype.Resolver
 	Routes              map[string][]*dnstype.Resolver
+	RouteStrategies     map[string]dnstype.Strategy
 	FallbackResolvers   []*dnstype.Resolver
 	Domains             []string
 	Proxied             bool
@@ -278,6 +278,7 @@ func (v HostinfoView) SSH_HostKeys() views.Slice[string] { return views.SliceOf(
 func (v HostinfoView) Cloud() string                     { return v.ж.Cloud }
 func (v HostinfoView) Userspace() opt.Bool               { return v.ж.Userspace }
 func (v HostinfoView) UserspaceRouter() opt.Bool         { return v.ж.UserspaceRouter }
+func (v HostinfoView) WoLMACs() views.Slice[string]      { return views.SliceOf(v.ж.WoLMACs) }
 func (v HostinfoView) Equal(v2 HostinfoView) bool        { return v.ж.Equal(v2.ж) }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
@@ -308,6 +309,7 @@ func (v HostinfoView) Equal(v2 HostinfoView) bool        { return v.ж.Equal(v2.
 	Cloud           string
 	Userspace       opt.Bool
 	UserspaceRouter opt.Bool
+	WoLMACs         []string
 }{})
 
 // View returns a readonly view of NetInfo.
@@ -505,6 +507,9 @@ func (v DNSConfigView) Routes() views.MapFn[string, []*dnstype.Resolver, views.S
 		return views.SliceOfViews[*dnstype.Resolver, dnstype.ResolverView](t)
 	})
 }
+func (v DNSConfigView) RouteStrategies() views.Map[string, dnstype.Strategy] {
+	return views.MapOf(v.ж.RouteStrategies)
+}
 func (v DNSConfigView) FallbackResolvers() views.SliceView[*dnstype.Resolver, dnstype.ResolverView] {
 	return views.SliceOfViews[*dnstype.Resolver, dnstype.ResolverView](v.ж.FallbackResolvers)
 }
@@ -522,6 +527,7 @@ func (v DNSConfigView) ExitNodeFilteredSet() views.Slice[string] {
 var _DNSConfigViewNeedsRegeneration = DNSConfig(struct {
 	Resolvers           []*dnstype.Resolver
 	Routes              map[string][]*dnstype.Resolver
+	RouteStrategies     map[string]dnstype.Strategy
 	FallbackResolvers   []*dnstype.Resolver
 	Domains             []string
 	Proxied             bool
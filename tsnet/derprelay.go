@@ -0,0 +1,459 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsnet
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"tailscale.com/atomicfile"
+	"tailscale.com/derp"
+	"tailscale.com/derp/derphttp"
+	"tailscale.com/derp/derpmap"
+	"tailscale.com/health"
+	"tailscale.com/net/stun"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tsweb"
+	"tailscale.com/types/key"
+	"tailscale.com/types/logger"
+)
+
+// DERPRelay configures a private DERP relay run by a Server, for
+// tailnets that can't reach Tailscale's DERP servers, such as
+// air-gapped or on-prem deployments. See Server.DERPRelay.
+//
+// The relay is announced with a DERP map of its region alone (see
+// Server.DERPRelayMap), which the Server uses in place of the one from
+// the control server. Other nodes use it by running tailscaled with
+// --derp-map=https://<HostName>:<port>/derpmap.json, or by adding the
+// region to the tailnet's policy file.
+type DERPRelay struct {
+	// Addr is the TCP address to serve DERP over HTTPS on, such as
+	// ":443" or "10.0.0.2:8443". If empty, ":443" is used.
+	Addr string
+
+	// HostName is the name or IP address nodes reach the relay at. The
+	// certificate served with TLSConfig must be valid for it. It's
+	// required.
+	HostName string
+
+	// TLSConfig is the configuration to serve HTTPS with, with a
+	// certificate trusted by the nodes, such as from an internal CA.
+	// It's required, as nodes only connect to DERP servers over TLS.
+	TLSConfig *tls.Config
+
+	// RegionID is the ID of the relay's DERP region. If zero, 900 is
+	// used, the first of the IDs that Tailscale leaves for private
+	// regions.
+	RegionID int
+
+	// RegionCode is the short name of the relay's DERP region. If
+	// empty, "tsnet" is used.
+	RegionCode string
+
+	// STUNPort is the UDP port to serve STUN on, for nodes to discover
+	// their public addresses and make direct connections. Zero means
+	// 3478; -1 disables STUN.
+	STUNPort int
+
+	// MeshKey, if non-empty, is the key, of 64 or more hex digits, that
+	// the relays of one region share to mesh with each other, so that
+	// nodes connected to different relays of the region can reach each
+	// other.
+	MeshKey string
+
+	// MeshWith are the addresses ("host" or "host:port") of the other
+	// relays in the region to mesh with, which requires MeshKey. They're
+	// included in the region's DERP map, assumed to have the same
+	// STUNPort. The relay's own address may be included.
+	MeshWith []string
+}
+
+const (
+	// defaultDERPRelayRegionID is the region ID of a DERPRelay
+	// without one.
+	defaultDERPRelayRegionID = 900
+
+	// derpRelayMapFile is the file, under the Server's Dir, that the
+	// relay's DERP map is written to for the LocalBackend to use.
+	derpRelayMapFile = "derpmap.json"
+
+	// derpRelayCheckInterval is how often the relay's health is
+	// checked.
+	derpRelayCheckInterval = 30 * time.Second
+)
+
+// derpRelay is a running DERPRelay.
+type derpRelay struct {
+	cfg  DERPRelay
+	logf logger.Logf
+	port int // of the HTTPS server
+	s    *derp.Server
+	ln   net.Listener
+	srv  *http.Server
+	stun net.PacketConn // or nil if disabled
+	mesh []*derphttp.Client
+	dm   *tailcfg.DERPMap
+
+	// probeClient is the HTTP client for the health check's probes,
+	// trusting the relay's certificate, or probeClientErr why it
+	// couldn't be made. It's made by the first check, as getting the
+	// certificate may take a while.
+	probeClientOnce sync.Once
+	probeClient     *http.Client
+	probeClientErr  error
+
+	stunRequests expvar.Int
+	stunErrors   expvar.Int
+
+	closeOnce sync.Once
+}
+
+// startDERPRelay starts serving the relay configured by cfg, until ctx
+// is done or it's closed.
+func startDERPRelay(ctx context.Context, logf logger.Logf, cfg DERPRelay) (_ *derpRelay, reterr error) {
+	if cfg.HostName == "" {
+		return nil, errors.New("DERPRelay.HostName is required")
+	}
+	if cfg.TLSConfig == nil {
+		return nil, errors.New("DERPRelay.TLSConfig is required, as nodes only connect to DERP servers over TLS")
+	}
+	if len(cfg.MeshWith) > 0 && cfg.MeshKey == "" {
+		return nil, errors.New("DERPRelay.MeshWith requires MeshKey")
+	}
+	if cfg.Addr == "" {
+		cfg.Addr = ":443"
+	}
+	if cfg.RegionID == 0 {
+		cfg.RegionID = defaultDERPRelayRegionID
+	}
+	if cfg.RegionCode == "" {
+		cfg.RegionCode = "tsnet"
+	}
+	if cfg.STUNPort == 0 {
+		cfg.STUNPort = 3478
+	}
+	listenHost, portStr, err := net.SplitHostPort(cfg.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DERPRelay.Addr: %w", err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DERPRelay.Addr port %q", portStr)
+	}
+
+	logf = logger.WithPrefix(logf, "derp-relay: ")
+	r := &derpRelay{
+		cfg:  cfg,
+		logf: logf,
+		port: port,
+		s:    derp.NewServer(key.NewNode(), logf),
+	}
+	defer func() {
+		if reterr != nil {
+			r.Close()
+		}
+	}()
+	if cfg.MeshKey != "" {
+		if matched, _ := regexp.MatchString(`(?i)^[0-9a-f]{64,}$`, cfg.MeshKey); !matched {
+			return nil, errors.New("DERPRelay.MeshKey must contain 64+ hex digits")
+		}
+		r.s.SetMeshKey(cfg.MeshKey)
+	}
+	r.dm = r.derpMap()
+	if err := derpmap.Validate(r.dm); err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/derp", derphttp.Handler(r.s))
+	mux.HandleFunc("/derp/probe", serveDERPProbe)
+	mux.HandleFunc("/derpmap.json", r.serveDERPMap)
+	mux.Handle("/derp/metrics", tsweb.Protected(http.HandlerFunc(r.serveMetrics)))
+
+	tlsConfig := cfg.TLSConfig.Clone()
+	if tlsConfig.MinVersion < tls.VersionTLS12 {
+		// Disable TLS 1.0 and 1.1, which are obsolete and have
+		// security issues.
+		tlsConfig.MinVersion = tls.VersionTLS12
+	}
+	r.ln, err = net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+	r.srv = &http.Server{
+		Handler:   mux,
+		TLSConfig: tlsConfig,
+		ErrorLog:  logger.StdLogger(logf),
+		// As in cmd/derper, these only affect TLS setup and the
+		// non-DERP handlers, as the DERP server clears the deadlines
+		// of the connections it hijacks.
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+	go func() {
+		if err := r.srv.ServeTLS(r.ln, "", ""); err != nil && err != http.ErrServerClosed {
+			logf("serving: %v", err)
+		}
+	}()
+	logf("serving DERP region %d (%s) on %v as %s", cfg.RegionID, cfg.RegionCode, r.ln.Addr(), cfg.HostName)
+
+	if cfg.STUNPort > 0 {
+		r.stun, err = net.ListenPacket("udp", net.JoinHostPort(listenHost, strconv.Itoa(cfg.STUNPort)))
+		if err != nil {
+			return nil, fmt.Errorf("STUN: %w", err)
+		}
+		go r.serveSTUN()
+	}
+
+	for _, host := range cfg.MeshWith {
+		mlogf := logger.WithPrefix(logf, fmt.Sprintf("mesh(%q): ", host))
+		c, err := derphttp.NewMeshClient(r.s, "https://"+host+"/derp", mlogf)
+		if err != nil {
+			return nil, err
+		}
+		r.mesh = append(r.mesh, c)
+		go c.RunMeshLoop(ctx, r.s, mlogf)
+	}
+
+	go r.runHealthChecks(ctx)
+	return r, nil
+}
+
+// probeClient returns an HTTP client for probing the relay configured
+// by cfg. It trusts cfg.TLSConfig's RootCAs, or the system's, and the
+// certificates the relay serves, which may be from an internal CA that
+// this machine doesn't trust but the nodes do.
+func probeClient(cfg DERPRelay) (*http.Client, error) {
+	var roots *x509.CertPool
+	if cfg.TLSConfig.RootCAs != nil {
+		roots = cfg.TLSConfig.RootCAs.Clone()
+	} else if sys, err := x509.SystemCertPool(); err == nil {
+		roots = sys
+	} else {
+		roots = x509.NewCertPool()
+	}
+	certs := cfg.TLSConfig.Certificates
+	if get := cfg.TLSConfig.GetCertificate; get != nil {
+		if c, err := get(&tls.ClientHelloInfo{ServerName: cfg.HostName}); err == nil && c != nil {
+			certs = append(certs[:len(certs):len(certs)], *c)
+		}
+	}
+	for _, c := range certs {
+		for _, der := range c.Certificate {
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				return nil, fmt.Errorf("DERPRelay.TLSConfig certificate: %w", err)
+			}
+			roots.AddCert(cert)
+		}
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: roots},
+			DisableKeepAlives: true,
+		},
+	}, nil
+}
+
+// Close stops the relay.
+func (r *derpRelay) Close() error {
+	r.closeOnce.Do(func() {
+		if r.srv != nil {
+			r.srv.Close()
+		}
+		if r.ln != nil {
+			r.ln.Close()
+		}
+		if r.stun != nil {
+			r.stun.Close()
+		}
+		for _, c := range r.mesh {
+			c.Close()
+		}
+		r.s.Close()
+		health.SetDERPRelayHealth(nil)
+	})
+	return nil
+}
+
+// derpMap returns the DERP map of the relay's region, with a node for
+// the relay and each relay it meshes with.
+func (r *derpRelay) derpMap() *tailcfg.DERPMap {
+	cfg := r.cfg
+	region := &tailcfg.DERPRegion{
+		RegionID:   cfg.RegionID,
+		RegionCode: cfg.RegionCode,
+		RegionName: "Private relay " + cfg.HostName,
+	}
+	seen := map[string]bool{}
+	add := func(host string, port int) {
+		hp := net.JoinHostPort(host, strconv.Itoa(port))
+		if seen[hp] {
+			return
+		}
+		seen[hp] = true
+		n := &tailcfg.DERPNode{
+			Name:     fmt.Sprintf("%d%c", cfg.RegionID, 'a'+len(region.Nodes)),
+			RegionID: cfg.RegionID,
+			HostName: host,
+			STUNPort: cfg.STUNPort,
+		}
+		if port != 443 {
+			n.DERPPort = port
+		}
+		if ip, err := netip.ParseAddr(host); err == nil {
+			if ip.Is4() {
+				n.IPv4, n.IPv6 = host, "none"
+			} else {
+				n.IPv4, n.IPv6 = "none", host
+			}
+		}
+		region.Nodes = append(region.Nodes, n)
+	}
+	add(cfg.HostName, r.port)
+	for _, hp := range cfg.MeshWith {
+		host, portStr, err := net.SplitHostPort(hp)
+		if err != nil {
+			add(hp, 443)
+			continue
+		}
+		port, _ := strconv.Atoi(portStr)
+		add(host, port)
+	}
+	return &tailcfg.DERPMap{
+		Regions:            map[int]*tailcfg.DERPRegion{cfg.RegionID: region},
+		OmitDefaultRegions: true,
+	}
+}
+
+// writeDERPMap writes the relay's DERP map to path.
+func (r *derpRelay) writeDERPMap(path string) error {
+	j, err := json.MarshalIndent(r.dm, "", "\t")
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(path, j, 0600)
+}
+
+func (r *derpRelay) serveDERPMap(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(r.dm)
+}
+
+// serveMetrics writes the relay's metrics in the Prometheus format.
+func (r *derpRelay) serveMetrics(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	tsweb.WritePrometheusExpvar(w, expvar.KeyValue{Key: "derp", Value: r.s.ExpVar()})
+	tsweb.WritePrometheusExpvar(w, expvar.KeyValue{Key: "counter_stun_requests", Value: &r.stunRequests})
+	tsweb.WritePrometheusExpvar(w, expvar.KeyValue{Key: "counter_stun_errors", Value: &r.stunErrors})
+}
+
+// serveDERPProbe answers the probes that nodes, and runHealthChecks,
+// make to check that the relay is reachable, as cmd/derper does.
+func serveDERPProbe(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "HEAD", "GET":
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	default:
+		http.Error(w, "bogus probe method", http.StatusMethodNotAllowed)
+	}
+}
+
+// serveSTUN answers STUN binding requests on r.stun until it's closed.
+func (r *derpRelay) serveSTUN() {
+	var buf [64 << 10]byte
+	for {
+		n, addr, err := r.stun.ReadFrom(buf[:])
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			r.logf("STUN: %v", err)
+			r.stunErrors.Add(1)
+			time.Sleep(time.Second)
+			continue
+		}
+		pkt := buf[:n]
+		if !stun.Is(pkt) {
+			continue
+		}
+		txid, err := stun.ParseBindingRequest(pkt)
+		if err != nil {
+			continue
+		}
+		ua, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		r.stunRequests.Add(1)
+		ap := ua.AddrPort()
+		ap = netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())
+		if _, err := r.stun.WriteTo(stun.Response(txid, ap), addr); err != nil {
+			r.stunErrors.Add(1)
+		}
+	}
+}
+
+// runHealthChecks checks the relay every derpRelayCheckInterval until
+// ctx is done, setting the health of health.SysDERPRelay.
+func (r *derpRelay) runHealthChecks(ctx context.Context) {
+	t := time.NewTicker(derpRelayCheckInterval)
+	defer t.Stop()
+	for {
+		health.SetDERPRelayHealth(r.check(ctx))
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// check returns an error if the relay's consistency check fails or it
+// doesn't answer a probe at the address nodes reach it at.
+func (r *derpRelay) check(ctx context.Context) error {
+	if err := r.s.ConsistencyCheck(); err != nil {
+		return fmt.Errorf("DERP relay consistency check: %w", err)
+	}
+	r.probeClientOnce.Do(func() {
+		r.probeClient, r.probeClientErr = probeClient(r.cfg)
+	})
+	if r.probeClientErr != nil {
+		return fmt.Errorf("DERP relay probe: %w", r.probeClientErr)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	probeURL := "https://" + net.JoinHostPort(r.cfg.HostName, strconv.Itoa(r.port)) + "/derp/probe"
+	req, err := http.NewRequestWithContext(ctx, "GET", probeURL, nil)
+	if err != nil {
+		return err
+	}
+	res, err := r.probeClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("DERP relay probe: %w", err)
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 4<<10))
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("DERP relay probe %s: %v", probeURL, res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsnet
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"tailscale.com/derp/derpmap"
+)
+
+func TestDERPRelayMap(t *testing.T) {
+	r := &derpRelay{
+		cfg: DERPRelay{
+			HostName:   "relay1.corp.example",
+			RegionID:   901,
+			RegionCode: "corp",
+			STUNPort:   3478,
+			MeshWith:   []string{"relay1.corp.example:8443", "relay2.corp.example", "10.0.0.3:8443"},
+		},
+		port: 8443,
+	}
+	dm := r.derpMap()
+	if err := derpmap.Validate(dm); err != nil {
+		t.Fatal(err)
+	}
+	if !dm.OmitDefaultRegions {
+		t.Error("OmitDefaultRegions not set")
+	}
+	nodes := dm.Regions[901].Nodes
+	if len(nodes) != 3 {
+		t.Fatalf("got %d nodes; want 3, without the duplicate of the relay itself", len(nodes))
+	}
+	for i, want := range []struct {
+		name, host, ipv4 string
+		port             int
+	}{
+		{"901a", "relay1.corp.example", "", 8443},
+		{"901b", "relay2.corp.example", "", 0},
+		{"901c", "10.0.0.3", "10.0.0.3", 8443},
+	} {
+		n := nodes[i]
+		if n.Name != want.name || n.HostName != want.host || n.IPv4 != want.ipv4 || n.DERPPort != want.port || n.STUNPort != 3478 {
+			t.Errorf("node %d = %+v; want %+v", i, n, want)
+		}
+	}
+}
+
+func TestDERPRelayCheck(t *testing.T) {
+	// httptest's certificate is valid for 127.0.0.1 but isn't trusted
+	// by the system, like one from an internal CA.
+	ts := httptest.NewTLSServer(http.NotFoundHandler())
+	certs := ts.TLS.Certificates
+	ts.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, err := startDERPRelay(ctx, t.Logf, DERPRelay{
+		Addr:      addr,
+		HostName:  "127.0.0.1",
+		TLSConfig: &tls.Config{Certificates: certs},
+		STUNPort:  -1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if err := r.check(ctx); err != nil {
+		t.Errorf("check: %v", err)
+	}
+}
@@ -5,6 +5,9 @@
 // Package tsnet provides Tailscale as a library.
 //
 // It is an experimental work in progress.
+//
+// Programs using it can add their own checks to bug reports' in-depth
+// diagnostics with tailscale.com/doctor.Register.
 package tsnet
 
 import (
@@ -37,6 +40,7 @@
 	"tailscale.com/net/nettest"
 	"tailscale.com/net/tsdial"
 	"tailscale.com/smallzstd"
+	"tailscale.com/tailcfg"
 	"tailscale.com/types/logger"
 	"tailscale.com/wgengine"
 	"tailscale.com/wgengine/monitor"
@@ -82,6 +86,11 @@ type Server struct {
 	// used.
 	AuthKey string
 
+	// DERPRelay, if non-nil, runs a private DERP relay in the program
+	// as configured, which the Server uses in place of the control
+	// server's DERP map. See DERPRelay.
+	DERPRelay *DERPRelay
+
 	initOnce         sync.Once
 	initErr          error
 	lb               *ipnlocal.LocalBackend
@@ -94,6 +103,7 @@ type Server struct {
 	localClient      *tailscale.LocalClient
 	logbuffer        *filch.Filch
 	logtail          *logtail.Logger
+	derpRelay        *derpRelay // or nil
 
 	mu        sync.Mutex
 	listeners map[listenKey]*listener
@@ -120,6 +130,16 @@ func (s *Server) LocalClient() (*tailscale.LocalClient, error) {
 	return s.localClient, nil
 }
 
+// DERPRelayMap returns the DERP map of the region of the private DERP
+// relay run as per s.DERPRelay, for adding to the tailnet's policy file
+// or serving to other nodes, or nil if s runs none or hasn't started.
+func (s *Server) DERPRelayMap() *tailcfg.DERPMap {
+	if s.derpRelay == nil {
+		return nil
+	}
+	return s.derpRelay.dm
+}
+
 // Start connects the server to the tailnet.
 // Optional: any calls to Dial/Listen will also call Start.
 func (s *Server) Start() error {
@@ -154,6 +174,9 @@ func (s *Server) Close() error {
 
 	s.shutdownCancel()
 	s.lb.Shutdown()
+	if s.derpRelay != nil {
+		s.derpRelay.Close()
+	}
 	s.linkMon.Close()
 	s.dialer.Close()
 	s.localAPIListener.Close()
@@ -323,6 +346,21 @@ func (s *Server) start() (reterr error) {
 		return fmt.Errorf("NewLocalBackend: %v", err)
 	}
 	lb.SetVarRoot(s.rootPath)
+	if s.DERPRelay != nil {
+		r, err := startDERPRelay(s.shutdownCtx, logf, *s.DERPRelay)
+		if err != nil {
+			return fmt.Errorf("starting DERP relay: %w", err)
+		}
+		s.derpRelay = r
+		closePool.add(r)
+		mapPath := filepath.Join(s.rootPath, derpRelayMapFile)
+		if err := r.writeDERPMap(mapPath); err != nil {
+			return fmt.Errorf("writing DERP relay map: %w", err)
+		}
+		if err := lb.SetDERPMapSource(mapPath); err != nil {
+			return fmt.Errorf("using DERP relay map: %w", err)
+		}
+	}
 	logf("tsnet starting with hostname %q, varRoot %q", s.hostname, s.rootPath)
 	s.lb = lb
 	closePool.addFunc(func() { s.lb.Shutdown() })
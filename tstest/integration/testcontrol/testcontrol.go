@@ -28,6 +28,7 @@
 
 	"github.com/klauspost/compress/zstd"
 	"go4.org/mem"
+	"tailscale.com/ipn"
 	"tailscale.com/net/netaddr"
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/smallzstd"
@@ -327,6 +328,39 @@ func (s *Server) AddFakeNode() {
 	// TODO: send updates to other (non-fake?) nodes
 }
 
+// AddSnapshotPeers injects fake nodes for the peers in nm, a network map
+// summary from a state snapshot, so that a node under test sees peers
+// like those of the node the snapshot was taken of. The fake nodes get
+// new keys.
+func (s *Server) AddSnapshotPeers(nm *ipn.NetMapSummary) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.nodes == nil {
+		s.nodes = make(map[key.NodePublic]*tailcfg.Node)
+	}
+	for _, p := range nm.Peers {
+		nk := key.NewNode().Public()
+		r := nk.Raw32()
+		id := int64(binary.LittleEndian.Uint64(r[:]))
+		s.nodes[nk] = &tailcfg.Node{
+			ID:                tailcfg.NodeID(id),
+			StableID:          p.ID,
+			Name:              p.Name,
+			User:              tailcfg.UserID(id),
+			Machine:           key.NewMachine().Public(),
+			Key:               nk,
+			MachineAuthorized: true,
+			DiscoKey:          key.NewDisco().Public(),
+			Addresses:         p.Addresses,
+			AllowedIPs:        p.AllowedIPs,
+			PrimaryRoutes:     p.PrimaryRoutes,
+			Tags:              p.Tags,
+			Capabilities:      p.Capabilities,
+			Online:            p.Online,
+		}
+	}
+}
+
 func (s *Server) AllNodes() (nodes []*tailcfg.Node) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
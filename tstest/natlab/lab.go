@@ -0,0 +1,107 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package natlab
+
+import (
+	"fmt"
+	"net/netip"
+	"sync"
+)
+
+// Lab builds multi-node topologies on a simulated internet: nodes
+// directly on the internet, optionally behind their own stateful
+// firewall, and nodes on a LAN of their own behind a NAT router.
+//
+// Loss, latency and MTU are set per network, on Internet and on each
+// node's LAN, before any packets are sent.
+type Lab struct {
+	// Internet is the network that all nodes and routers share.
+	Internet *Network
+
+	mu   sync.Mutex
+	lans int // LANs allocated so far
+}
+
+// NewLab returns a Lab with an empty internet.
+func NewLab() *Lab {
+	return &Lab{Internet: NewInternet()}
+}
+
+// NodeConfig configures a node added to a Lab.
+type NodeConfig struct {
+	// Firewall is whether the node has a stateful firewall of type
+	// FirewallType, allowing inbound packets only in reply to ones it
+	// sent.
+	Firewall     bool
+	FirewallType FirewallType
+
+	// NAT is whether the node is on its own LAN, behind a router that
+	// does NAT of type NATType. The router also filters inbound
+	// packets like a firewall of type RouterFirewallType.
+	NAT                bool
+	NATType            NATType
+	RouterFirewallType FirewallType
+}
+
+// Node is a node of a Lab.
+type Node struct {
+	// Machine is the node itself, to listen for packets on.
+	Machine *Machine
+	// Interface is the node's only interface, on LAN or, if it's
+	// not behind a NAT, the internet.
+	Interface *Interface
+
+	// LAN is the node's network, if it's behind a NAT.
+	LAN *Network
+	// Router is the NAT router between LAN and the internet, if any.
+	Router *Machine
+	// NAT is the Router's NAT, if any.
+	NAT *SNAT44
+}
+
+// IP returns the node's IPv4 address.
+func (n *Node) IP() netip.Addr {
+	return n.Interface.V4()
+}
+
+// AddNode adds a node named name to l, configured per cfg.
+func (l *Lab) AddNode(name string, cfg NodeConfig) *Node {
+	n := &Node{Machine: &Machine{Name: name}}
+	if cfg.Firewall {
+		n.Machine.PacketHandler = &Firewall{Type: cfg.FirewallType}
+	}
+	if !cfg.NAT {
+		n.Interface = n.Machine.Attach("eth0", l.Internet)
+		return n
+	}
+
+	l.mu.Lock()
+	lan := l.lans
+	l.lans++
+	l.mu.Unlock()
+	if lan > 255 {
+		panic("too many LANs")
+	}
+	n.LAN = &Network{
+		Name:    fmt.Sprintf("%s-lan", name),
+		Prefix4: netip.PrefixFrom(netip.AddrFrom4([4]byte{192, 168, byte(lan), 0}), 24),
+	}
+	n.Router = &Machine{Name: fmt.Sprintf("%s-router", name)}
+	wan := n.Router.Attach("wan", l.Internet)
+	lanIf := n.Router.Attach("lan", n.LAN)
+	n.LAN.SetDefaultGateway(lanIf)
+	n.NAT = &SNAT44{
+		Machine:           n.Router,
+		ExternalInterface: wan,
+		Type:              cfg.NATType,
+		Firewall: &Firewall{
+			TrustedInterface: lanIf,
+			Type:             cfg.RouterFirewallType,
+		},
+	}
+	n.Router.PacketHandler = n.NAT
+	n.Interface = n.Machine.Attach("eth0", n.LAN)
+	return n
+}
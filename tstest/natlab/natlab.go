@@ -6,6 +6,10 @@
 // in-memory without running VMs or requiring root, etc. Despite the
 // name, it does more than just NATs. But NATs are the most
 // interesting.
+//
+// Networks can also lose, delay and limit the size of packets,
+// reproducibly, and a Lab builds topologies of nodes behind firewalls
+// and NATs on a shared internet, for end-to-end tests.
 package natlab
 
 import (
@@ -15,6 +19,8 @@
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"math/rand"
 	"net"
 	"net/netip"
@@ -103,11 +109,38 @@ type Network struct {
 	Prefix4 netip.Prefix
 	Prefix6 netip.Prefix
 
+	// Loss is the fraction of the packets crossing the network that
+	// are dropped, from 0 (none) to 1 (all).
+	Loss float64
+	// Latency is how long packets take to cross the network.
+	Latency time.Duration
+	// Jitter is the most that's randomly added to Latency for each
+	// packet, reordering those sent closer together than that.
+	Jitter time.Duration
+	// MTU, if non-zero, is the size of the largest IP packet,
+	// headers included, that the network carries. Larger ones are
+	// dropped, as if sent with the don't-fragment bit set.
+	MTU int
+	// Seed seeds the randomness of Loss and Jitter. Each flow, a
+	// source and destination ip:port pair, draws from its own
+	// sequence derived from Seed, so that its packets are dropped and
+	// delayed the same way each run regardless of how the flows
+	// interleave, as long as each flow's packets are sent in the same
+	// order. Ephemeral ports are picked the same way each run for
+	// the same order of binds on each Machine.
+	Seed int64
+
 	mu        sync.Mutex
 	machine   map[netip.Addr]*Interface
 	defaultGW *Interface // optional
 	lastV4    netip.Addr
 	lastV6    netip.Addr
+	flowRand  map[flow]*rand.Rand // for Loss and Jitter, lazily seeded from Seed
+}
+
+// flow is a source and destination ip:port pair.
+type flow struct {
+	src, dst netip.AddrPort
 }
 
 func (n *Network) SetDefaultGateway(gwIf *Interface) {
@@ -201,13 +234,69 @@ func (n *Network) write(p *Packet) (num int, err error) {
 		iface = n.defaultGW
 	}
 
+	drop, delay := n.impairLocked(p)
+	if drop {
+		return len(p.Payload), nil
+	}
+
 	// Pretend it went across the network. Make a copy so nobody
 	// can later mess with caller's memory.
 	p.Trace("-> mach=%s if=%s", iface.machine.Name, iface.name)
-	go iface.machine.deliverIncomingPacket(p, iface)
+	if delay > 0 {
+		time.AfterFunc(delay, func() { iface.machine.deliverIncomingPacket(p, iface) })
+	} else {
+		go iface.machine.deliverIncomingPacket(p, iface)
+	}
 	return len(p.Payload), nil
 }
 
+// impairLocked reports whether p is dropped on its way across n, per
+// n's MTU and Loss, and otherwise how long it takes to cross.
+// n.mu must be held.
+func (n *Network) impairLocked(p *Packet) (drop bool, delay time.Duration) {
+	if n.MTU > 0 && ipPacketLen(p) > n.MTU {
+		p.Trace("dropped: %d bytes exceeds MTU %d", ipPacketLen(p), n.MTU)
+		return true, 0
+	}
+	if n.Loss > 0 && n.randLocked(p).Float64() < n.Loss {
+		p.Trace("dropped: lost")
+		return true, 0
+	}
+	delay = n.Latency
+	if n.Jitter > 0 {
+		delay += time.Duration(n.randLocked(p).Int63n(int64(n.Jitter) + 1))
+	}
+	return false, delay
+}
+
+// randLocked returns the source of randomness of Loss and Jitter for
+// p's flow.
+// n.mu must be held.
+func (n *Network) randLocked(p *Packet) *rand.Rand {
+	f := flow{p.Src, p.Dst}
+	if r, ok := n.flowRand[f]; ok {
+		return r
+	}
+	if n.flowRand == nil {
+		n.flowRand = map[flow]*rand.Rand{}
+	}
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d %v %v", n.Seed, p.Src, p.Dst)
+	r := rand.New(rand.NewSource(int64(h.Sum64())))
+	n.flowRand[f] = r
+	return r
+}
+
+// ipPacketLen returns the length of the UDP over IP packet that
+// carries p.
+func ipPacketLen(p *Packet) int {
+	const udpHeader = 8
+	if p.Dst.Addr().Is4() {
+		return 20 + udpHeader + len(p.Payload)
+	}
+	return 40 + udpHeader + len(p.Payload)
+}
+
 type Interface struct {
 	machine *Machine
 	net     *Network
@@ -343,6 +432,8 @@ type Machine struct {
 
 	conns4 map[netip.AddrPort]*conn // conns that want IPv4 packets
 	conns6 map[netip.AddrPort]*conn // conns that want IPv6 packets
+
+	portRand *rand.Rand // for ephemeral ports, lazily seeded from Name
 }
 
 func (m *Machine) isLocalIP(ip netip.Addr) bool {
@@ -589,8 +680,13 @@ func (m *Machine) hasv6() bool {
 func (m *Machine) pickEphemPort() (port uint16, err error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	if m.portRand == nil {
+		h := fnv.New64a()
+		io.WriteString(h, m.Name)
+		m.portRand = rand.New(rand.NewSource(int64(h.Sum64())))
+	}
 	for tries := 0; tries < 500; tries++ {
-		port := uint16(rand.Intn(32<<10) + 32<<10)
+		port := uint16(m.portRand.Intn(32<<10) + 32<<10)
 		if !m.portInUseLocked(port) {
 			return port, nil
 		}
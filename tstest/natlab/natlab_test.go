@@ -508,3 +508,161 @@ func testNAT(t *testing.T, n *SNAT44, lanIf, wanIf *Interface, tests []natTest)
 		}
 	}
 }
+
+func TestImpair(t *testing.T) {
+	p := &Packet{
+		Src:     ipp("1.0.0.1:123"),
+		Dst:     ipp("1.0.0.2:456"),
+		Payload: make([]byte, 1000),
+	}
+	drops := func(n *Network) (pattern []bool) {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		for i := 0; i < 1000; i++ {
+			drop, _ := n.impairLocked(p)
+			pattern = append(pattern, drop)
+		}
+		return pattern
+	}
+
+	a := drops(&Network{Loss: 0.25, Seed: 1})
+	b := drops(&Network{Loss: 0.25, Seed: 1})
+	if fmt.Sprint(a) != fmt.Sprint(b) {
+		t.Errorf("same seed dropped different packets")
+	}
+	// Another flow's packets in between don't change which of p's
+	// flow are dropped.
+	other := &Packet{
+		Src:     ipp("1.0.0.3:123"),
+		Dst:     ipp("1.0.0.2:456"),
+		Payload: make([]byte, 1000),
+	}
+	mixed := &Network{Loss: 0.25, Seed: 1}
+	mixed.mu.Lock()
+	var c []bool
+	for i := 0; i < 1000; i++ {
+		for j := 0; j < i%3; j++ {
+			mixed.impairLocked(other)
+		}
+		drop, _ := mixed.impairLocked(p)
+		c = append(c, drop)
+	}
+	mixed.mu.Unlock()
+	if fmt.Sprint(a) != fmt.Sprint(c) {
+		t.Errorf("other flow's packets changed which were dropped")
+	}
+	lost := 0
+	for _, drop := range a {
+		if drop {
+			lost++
+		}
+	}
+	if lost < 200 || lost > 300 {
+		t.Errorf("lost %d of 1000 packets; want about 250", lost)
+	}
+
+	for _, tt := range []struct {
+		mtu  int
+		drop bool
+	}{
+		{0, false},
+		{1500, false},
+		{1028, false},
+		{1027, true},
+	} {
+		n := &Network{MTU: tt.mtu}
+		if drop, _ := n.impairLocked(p); drop != tt.drop {
+			t.Errorf("MTU %d: drop = %v; want %v", tt.mtu, drop, tt.drop)
+		}
+	}
+
+	n := &Network{Latency: 20 * time.Millisecond, Jitter: 10 * time.Millisecond}
+	for i := 0; i < 100; i++ {
+		if _, delay := n.impairLocked(p); delay < 20*time.Millisecond || delay > 30*time.Millisecond {
+			t.Fatalf("delay = %v; want 20-30ms", delay)
+		}
+	}
+}
+
+func TestLatency(t *testing.T) {
+	internet := NewInternet()
+	internet.Latency = 50 * time.Millisecond
+
+	foo := &Machine{Name: "foo"}
+	bar := &Machine{Name: "bar"}
+	foo.Attach("eth0", internet)
+	ifBar := bar.Attach("eth0", internet)
+
+	ctx := context.Background()
+	fooPC, err := foo.ListenPacket(ctx, "udp4", ":123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	barPC, err := bar.ListenPacket(ctx, "udp4", ":456")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if _, err := fooPC.WriteTo([]byte("hi"), net.UDPAddrFromAddrPort(netip.AddrPortFrom(ifBar.V4(), 456))); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1500)
+	if _, _, err := barPC.ReadFrom(buf); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < internet.Latency {
+		t.Errorf("packet arrived after %v; want at least %v", d, internet.Latency)
+	}
+}
+
+func TestLab(t *testing.T) {
+	lab := NewLab()
+	server := lab.AddNode("server", NodeConfig{})
+	client := lab.AddNode("client", NodeConfig{NAT: true, Firewall: true})
+	other := lab.AddNode("other", NodeConfig{NAT: true})
+	if client.LAN.Prefix4 == other.LAN.Prefix4 {
+		t.Fatalf("client and other share LAN %v", client.LAN.Prefix4)
+	}
+	if !client.LAN.Prefix4.Contains(client.IP()) {
+		t.Fatalf("client IP %v not on its LAN %v", client.IP(), client.LAN.Prefix4)
+	}
+
+	ctx := context.Background()
+	clientPC, err := client.Machine.ListenPacket(ctx, "udp4", ":123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverPC, err := server.Machine.ListenPacket(ctx, "udp4", ":456")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverAddr := netip.AddrPortFrom(server.IP(), 456)
+	if _, err := clientPC.WriteTo([]byte("ping"), net.UDPAddrFromAddrPort(serverAddr)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1500)
+	n, addr, err := serverPC.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "ping" {
+		t.Errorf("server read %q; want %q", buf[:n], "ping")
+	}
+	mapped := addr.(*net.UDPAddr).AddrPort()
+	if mapped.Addr() != client.Router.interfaces[0].V4() {
+		t.Errorf("server saw %v; want the client router's WAN address %v", mapped, client.Router.interfaces[0].V4())
+	}
+
+	if _, err := serverPC.WriteTo([]byte("pong"), addr); err != nil {
+		t.Fatal(err)
+	}
+	n, addr, err = clientPC.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "pong" || addr.String() != serverAddr.String() {
+		t.Errorf("client read %q from %v; want %q from %v", buf[:n], addr, "pong", serverAddr)
+	}
+}
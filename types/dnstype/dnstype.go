@@ -35,6 +35,23 @@ type Resolver struct {
 	BootstrapResolution []netip.Addr `json:",omitempty"`
 }
 
+// Strategy is how queries are sent to the resolvers of a DNS route
+// that has more than one.
+type Strategy string
+
+const (
+	// StrategyRace sends each query to all the resolvers at once,
+	// after any head start given to DNS-over-HTTPS, and uses the
+	// first answer. It's the default.
+	StrategyRace Strategy = "race"
+
+	// StrategyFastest sends each query to the resolver that has been
+	// answering fastest, and to the next one only if it fails or is
+	// slow to answer. Resolvers that stopped answering are tried last,
+	// but still probed now and then so that their recovery is noticed.
+	StrategyFastest Strategy = "fastest"
+)
+
 // IPPort returns r.Addr as an IP address and port if either
 // r.Addr is an IP address (the common case) or if r.Addr
 // is an IP:port (as done in tests).
@@ -0,0 +1,44 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package filterstats contains the packet filter's packet counts, as
+// reported by wgengine/filter and returned by the LocalAPI.
+//
+// It's its own package so that wgengine/filter doesn't need to depend on
+// ipn/ipnstate.
+package filterstats
+
+import "time"
+
+// Stats is how many packets the packet filter accepted by each of its
+// rules and dropped for each reason.
+type Stats struct {
+	// Since is when the filter was installed, replacing the previous
+	// one; the counts start then.
+	Since time.Time
+	// ShieldsUp is whether the filter blocks all incoming
+	// connections.
+	ShieldsUp bool `json:",omitempty"`
+	// Rules are the filter's rules, in the order they're checked.
+	Rules []RuleStats
+	// Drops are the packets dropped for each reason, in each
+	// direction. Reasons with no drops are omitted.
+	Drops []DropStats `json:",omitempty"`
+}
+
+// RuleStats is how many packets a packet filter rule accepted. Only the
+// packets that start a flow, such as TCP SYNs, are checked against the
+// rules.
+type RuleStats struct {
+	Rule    string // such as "[6]100.64.0.0/10=>100.101.102.103/32:22"
+	Packets int64
+}
+
+// DropStats is how many packets the packet filter dropped in one
+// direction for one reason.
+type DropStats struct {
+	Dir     string // "in" or "out"
+	Reason  string // such as "no rules matched"
+	Packets int64
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netmap
+
+import (
+	"fmt"
+	"strings"
+
+	"tailscale.com/tailcfg"
+)
+
+// ChangeKind is the kind of a Change between two network maps.
+type ChangeKind string
+
+const (
+	ChangePeerAdded      ChangeKind = "peer-added"
+	ChangePeerRemoved    ChangeKind = "peer-removed"
+	ChangeEndpoints      ChangeKind = "endpoints"      // a peer's UDP endpoints
+	ChangeRoutes         ChangeKind = "routes"         // a peer's AllowedIPs
+	ChangeHomeDERP       ChangeKind = "home-derp"      // a peer's home DERP region
+	ChangeNodeKey        ChangeKind = "node-key"       // a node key rotation, of a peer or this node
+	ChangeDiscoKey       ChangeKind = "disco-key"      // a peer's disco key rotation
+	ChangeSelfAddresses  ChangeKind = "self-addresses" // this node's Tailscale IPs
+	ChangeMachineStatus  ChangeKind = "machine-status" // this node's authorization
+	ChangeControlHealth  ChangeKind = "control-health" // the control server's health warnings
+	ChangePacketFilter   ChangeKind = "packet-filter"  // the number of packet filter rules
+	ChangeDERPMapRegions ChangeKind = "derp-regions"   // the regions of the DERP map
+)
+
+// Change is a single change between two network maps, as returned by
+// ChangesFrom, such as a peer's endpoints changing.
+type Change struct {
+	Kind ChangeKind
+	// Peer is the stable ID of the peer that changed, or empty for a
+	// change of this node or the tailnet.
+	Peer tailcfg.StableNodeID `json:",omitempty"`
+	// Name is the peer's name, for people.
+	Name string `json:",omitempty"`
+	// Old and New are what changed, before and after, formatted for
+	// people. Old is empty for added peers, and New for removed ones.
+	Old string `json:",omitempty"`
+	New string `json:",omitempty"`
+}
+
+func (c Change) String() string {
+	var sb strings.Builder
+	sb.WriteString(string(c.Kind))
+	if c.Peer != "" {
+		fmt.Fprintf(&sb, " %s (%s)", c.Name, c.Peer)
+	}
+	switch {
+	case c.Old == "":
+		fmt.Fprintf(&sb, ": %s", c.New)
+	case c.New == "":
+		fmt.Fprintf(&sb, ": %s", c.Old)
+	default:
+		fmt.Fprintf(&sb, ": %s -> %s", c.Old, c.New)
+	}
+	return sb.String()
+}
+
+// ChangesFrom returns the changes from a to b, such as peers added or
+// removed and endpoints, routes or keys that changed, in a stable
+// order: this node's changes first, then those of the peers of b in
+// its order, then the peers removed in the order of a.
+//
+// Unlike ConciseDiffFrom, which is for reading, its result is for
+// programs, or for logging the changes one per line.
+func (b *NetworkMap) ChangesFrom(a *NetworkMap) []Change {
+	var ret []Change
+	self := func(kind ChangeKind, old, new string) {
+		if old != new {
+			ret = append(ret, Change{Kind: kind, Old: old, New: new})
+		}
+	}
+	self(ChangeNodeKey, a.NodeKey.ShortString(), b.NodeKey.ShortString())
+	self(ChangeSelfAddresses, fmt.Sprint(a.Addresses), fmt.Sprint(b.Addresses))
+	self(ChangeMachineStatus, a.MachineStatus.String(), b.MachineStatus.String())
+	self(ChangeControlHealth, fmt.Sprintf("%q", a.ControlHealth), fmt.Sprintf("%q", b.ControlHealth))
+	self(ChangePacketFilter, fmt.Sprintf("%d rules", len(a.PacketFilter)), fmt.Sprintf("%d rules", len(b.PacketFilter)))
+	self(ChangeDERPMapRegions, derpRegions(a.DERPMap), derpRegions(b.DERPMap))
+
+	old := make(map[tailcfg.NodeID]*tailcfg.Node, len(a.Peers))
+	for _, p := range a.Peers {
+		old[p.ID] = p
+	}
+	for _, pb := range b.Peers {
+		pa, ok := old[pb.ID]
+		if !ok {
+			ret = append(ret, peerChange(ChangePeerAdded, pb, "", fmt.Sprint(pb.Addresses)))
+			continue
+		}
+		delete(old, pb.ID)
+		if pa.Key != pb.Key {
+			ret = append(ret, peerChange(ChangeNodeKey, pb, pa.Key.ShortString(), pb.Key.ShortString()))
+		}
+		if pa.DiscoKey != pb.DiscoKey {
+			ret = append(ret, peerChange(ChangeDiscoKey, pb, pa.DiscoKey.ShortString(), pb.DiscoKey.ShortString()))
+		}
+		if pa.DERP != pb.DERP {
+			ret = append(ret, peerChange(ChangeHomeDERP, pb, derpRegion(pa.DERP), derpRegion(pb.DERP)))
+		}
+		if !eqStringsIgnoreNil(pa.Endpoints, pb.Endpoints) {
+			ret = append(ret, peerChange(ChangeEndpoints, pb, fmt.Sprint(pa.Endpoints), fmt.Sprint(pb.Endpoints)))
+		}
+		if !eqCIDRsIgnoreNil(pa.AllowedIPs, pb.AllowedIPs) {
+			ret = append(ret, peerChange(ChangeRoutes, pb, fmt.Sprint(pa.AllowedIPs), fmt.Sprint(pb.AllowedIPs)))
+		}
+	}
+	for _, pa := range a.Peers {
+		if _, ok := old[pa.ID]; ok {
+			ret = append(ret, peerChange(ChangePeerRemoved, pa, fmt.Sprint(pa.Addresses), ""))
+		}
+	}
+	return ret
+}
+
+// peerChange returns a change of kind to the peer p.
+func peerChange(kind ChangeKind, p *tailcfg.Node, old, new string) Change {
+	name := p.ComputedName
+	if name == "" {
+		name = strings.TrimSuffix(p.Name, ".")
+	}
+	if name == "" {
+		name = p.Key.ShortString()
+	}
+	return Change{Kind: kind, Peer: p.StableID, Name: name, Old: old, New: new}
+}
+
+// derpRegion returns the home DERP region of a peer with the
+// tailcfg.Node.DERP of derp, such as "region 2" for "127.3.3.40:2", or
+// "none".
+func derpRegion(derp string) string {
+	const derpPrefix = "127.3.3.40:"
+	if strings.HasPrefix(derp, derpPrefix) {
+		return "region " + derp[len(derpPrefix):]
+	}
+	if derp == "" {
+		return "none"
+	}
+	return derp
+}
+
+// derpRegions returns the IDs of the regions of dm, in order, such as
+// "[1 2 9]".
+func derpRegions(dm *tailcfg.DERPMap) string {
+	if dm == nil {
+		return "[]"
+	}
+	return fmt.Sprint(dm.RegionIDs())
+}
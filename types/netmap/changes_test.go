@@ -0,0 +1,70 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netmap
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"tailscale.com/tailcfg"
+)
+
+func TestChangesFrom(t *testing.T) {
+	peer := func(id tailcfg.NodeID, name string, key byte, eps ...string) *tailcfg.Node {
+		return &tailcfg.Node{
+			ID:           id,
+			StableID:     tailcfg.StableNodeID(name + "-id"),
+			ComputedName: name,
+			Key:          testNodeKey(key),
+			DERP:         "127.3.3.40:2",
+			Addresses:    []netip.Prefix{netip.MustParsePrefix("100.64.0.1/32")},
+			AllowedIPs:   []netip.Prefix{netip.MustParsePrefix("100.64.0.1/32")},
+			Endpoints:    eps,
+		}
+	}
+	a := &NetworkMap{
+		NodeKey: testNodeKey(1),
+		Peers: []*tailcfg.Node{
+			peer(2, "stays", 2, "192.0.2.1:41641"),
+			peer(3, "leaves", 3),
+			peer(4, "moves", 4, "192.0.2.2:41641"),
+		},
+	}
+
+	moved := peer(4, "moves", 40, "198.51.100.2:41641")
+	moved.DERP = "127.3.3.40:9"
+	moved.AllowedIPs = append(moved.AllowedIPs, netip.MustParsePrefix("10.0.0.0/24"))
+	b := &NetworkMap{
+		NodeKey:       testNodeKey(1),
+		ControlHealth: []string{"bad"},
+		Peers: []*tailcfg.Node{
+			peer(1, "joins", 1),
+			peer(2, "stays", 2, "192.0.2.1:41641"),
+			moved,
+		},
+	}
+
+	if got := a.ChangesFrom(a); len(got) != 0 {
+		t.Errorf("changes from itself: %v", got)
+	}
+
+	var got []string
+	for _, c := range b.ChangesFrom(a) {
+		got = append(got, c.String())
+	}
+	want := []string{
+		`control-health: [] -> ["bad"]`,
+		`peer-added joins (joins-id): [100.64.0.1/32]`,
+		`node-key moves (moves-id): ` + testNodeKey(4).ShortString() + ` -> ` + testNodeKey(40).ShortString(),
+		`home-derp moves (moves-id): region 2 -> region 9`,
+		`endpoints moves (moves-id): [192.0.2.2:41641] -> [198.51.100.2:41641]`,
+		`routes moves (moves-id): [100.64.0.1/32] -> [100.64.0.1/32 10.0.0.0/24]`,
+		`peer-removed leaves (leaves-id): [100.64.0.1/32]`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("changes:\n got: %q\nwant: %q", got, want)
+	}
+}
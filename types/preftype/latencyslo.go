@@ -0,0 +1,98 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package preftype
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LatencyMetric is a latency that a LatencySLO is about.
+type LatencyMetric string
+
+const (
+	// LatencyRTT is the round-trip time of disco pings to a peer, over
+	// whichever path they take.
+	LatencyRTT = LatencyMetric("rtt")
+
+	// LatencyDNS is the time the MagicDNS forwarder takes to get an
+	// answer from the upstream resolvers. Cached answers aren't
+	// counted.
+	LatencyDNS = LatencyMetric("dns")
+)
+
+// DefaultSLOQuantile is the quantile of a LatencySLO that doesn't
+// name one.
+const DefaultSLOQuantile = 0.9
+
+// LatencySLO is a parsed local latency service level objective: that
+// a quantile of a latency stays below a maximum.
+type LatencySLO struct {
+	Metric LatencyMetric
+	// Peer is the name or Tailscale IP of the peer, for LatencyRTT.
+	Peer string
+	// Quantile is the fraction of the latencies that must be below
+	// Max, such as 0.9.
+	Quantile float64
+	Max      time.Duration
+}
+
+func (o LatencySLO) String() string {
+	var sb strings.Builder
+	sb.WriteString(string(o.Metric))
+	if o.Peer != "" {
+		sb.WriteString(":" + o.Peer)
+	}
+	if o.Quantile != DefaultSLOQuantile {
+		sb.WriteString("@p" + strconv.FormatFloat(o.Quantile*100, 'f', -1, 64))
+	}
+	sb.WriteString("<" + o.Max.String())
+	return sb.String()
+}
+
+// ParseLatencySLO parses a latency SLO of the form
+// "<metric>[:<peer>][@p<quantile>]<<max>", such as "rtt:nas<50ms" for
+// the 90th percentile of the round-trip time to the peer "nas" to stay
+// below 50ms, or "dns@p99<100ms" for the 99th percentile of DNS
+// lookups to stay below 100ms.
+func ParseLatencySLO(s string) (LatencySLO, error) {
+	lhs, max, ok := strings.Cut(s, "<")
+	if !ok {
+		return LatencySLO{}, fmt.Errorf("invalid latency SLO %q; want <metric>[:<peer>][@p<quantile>]<<max>", s)
+	}
+	o := LatencySLO{Quantile: DefaultSLOQuantile}
+	d, err := time.ParseDuration(strings.TrimSpace(max))
+	if err != nil || d <= 0 {
+		return LatencySLO{}, fmt.Errorf("invalid maximum %q in latency SLO %q", max, s)
+	}
+	o.Max = d
+	lhs = strings.TrimSpace(lhs)
+	if name, q, ok := strings.Cut(lhs, "@"); ok {
+		p, err := strconv.ParseFloat(strings.TrimPrefix(q, "p"), 64)
+		if err != nil || !strings.HasPrefix(q, "p") || p <= 0 || p >= 100 {
+			return LatencySLO{}, fmt.Errorf("invalid quantile %q in latency SLO %q; want p<percentile>, such as p99", q, s)
+		}
+		o.Quantile = p / 100
+		lhs = name
+	}
+	metric, peer, _ := strings.Cut(lhs, ":")
+	o.Metric = LatencyMetric(metric)
+	o.Peer = peer
+	switch o.Metric {
+	case LatencyRTT:
+		if peer == "" {
+			return LatencySLO{}, fmt.Errorf("latency SLO %q needs a peer, as in \"rtt:<peer><50ms\"", s)
+		}
+	case LatencyDNS:
+		if peer != "" {
+			return LatencySLO{}, fmt.Errorf("latency SLO %q can't name a peer", s)
+		}
+	default:
+		return LatencySLO{}, fmt.Errorf("invalid metric %q in latency SLO %q; want %q or %q", metric, s, LatencyRTT, LatencyDNS)
+	}
+	return o, nil
+}
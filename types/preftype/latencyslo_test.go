@@ -0,0 +1,46 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package preftype
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLatencySLO(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    LatencySLO
+		wantErr bool
+	}{
+		{in: "rtt:nas<50ms", want: LatencySLO{LatencyRTT, "nas", 0.9, 50 * time.Millisecond}},
+		{in: "rtt:fd7a:115c:a1e0::1@p99<1s", want: LatencySLO{LatencyRTT, "fd7a:115c:a1e0::1", 0.99, time.Second}},
+		{in: "dns<100ms", want: LatencySLO{LatencyDNS, "", 0.9, 100 * time.Millisecond}},
+		{in: "dns@p99.5 < 250ms", want: LatencySLO{LatencyDNS, "", 0.995, 250 * time.Millisecond}},
+		{in: "dns", wantErr: true},
+		{in: "dns<fast", wantErr: true},
+		{in: "dns<0s", wantErr: true},
+		{in: "dns:nas<100ms", wantErr: true},
+		{in: "rtt<50ms", wantErr: true},
+		{in: "dns@99<100ms", wantErr: true},
+		{in: "dns@p100<100ms", wantErr: true},
+		{in: "http<100ms", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseLatencySLO(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseLatencySLO(%q) error = %v; want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseLatencySLO(%q) = %+v; want %+v", tt.in, got, tt.want)
+		}
+		if err == nil {
+			if again, err := ParseLatencySLO(got.String()); err != nil || again != got {
+				t.Errorf("ParseLatencySLO(%q) = %+v, %v; want %+v", got.String(), again, err, got)
+			}
+		}
+	}
+}
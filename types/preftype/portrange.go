@@ -0,0 +1,68 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package preftype
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PortRange is an inclusive range of UDP ports that Tailscale may use as
+// the source port of its traffic to peers and STUN servers. The zero
+// value means any port.
+type PortRange struct {
+	First, Last uint16
+}
+
+// IsZero reports whether r is the zero value, allowing any port.
+func (r PortRange) IsZero() bool {
+	return r == PortRange{}
+}
+
+// Contains reports whether port is within r. Any port is within the
+// zero PortRange.
+func (r PortRange) Contains(port uint16) bool {
+	return r.IsZero() || r.First <= port && port <= r.Last
+}
+
+// Len returns the number of ports in r, or 0 for the zero PortRange.
+func (r PortRange) Len() int {
+	if r.IsZero() {
+		return 0
+	}
+	return int(r.Last) - int(r.First) + 1
+}
+
+func (r PortRange) String() string {
+	switch {
+	case r.IsZero():
+		return ""
+	case r.First == r.Last:
+		return strconv.Itoa(int(r.First))
+	}
+	return fmt.Sprintf("%d-%d", r.First, r.Last)
+}
+
+// ParsePortRange parses a port range of the form "<first>-<last>", or a
+// single port. The empty string is the zero PortRange.
+func ParsePortRange(s string) (PortRange, error) {
+	if s == "" {
+		return PortRange{}, nil
+	}
+	first, last, ok := strings.Cut(s, "-")
+	if !ok {
+		last = first
+	}
+	lo, err1 := strconv.ParseUint(first, 10, 16)
+	hi, err2 := strconv.ParseUint(last, 10, 16)
+	if err1 != nil || err2 != nil || lo == 0 {
+		return PortRange{}, fmt.Errorf("invalid port range %q; want <port> or <first>-<last>, with ports between 1 and 65535", s)
+	}
+	if lo > hi {
+		return PortRange{}, fmt.Errorf("invalid port range %q; first port is after last", s)
+	}
+	return PortRange{uint16(lo), uint16(hi)}, nil
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package preftype
+
+import "testing"
+
+func TestParsePortRange(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    PortRange
+		wantErr bool
+	}{
+		{in: "", want: PortRange{}},
+		{in: "41641", want: PortRange{41641, 41641}},
+		{in: "41641-41700", want: PortRange{41641, 41700}},
+		{in: "1-65535", want: PortRange{1, 65535}},
+		{in: "0", wantErr: true},
+		{in: "0-10", wantErr: true},
+		{in: "41700-41641", wantErr: true},
+		{in: "41641-", wantErr: true},
+		{in: "-41641", wantErr: true},
+		{in: "65536", wantErr: true},
+		{in: "foo", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParsePortRange(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParsePortRange(%q) error = %v; want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParsePortRange(%q) = %v; want %v", tt.in, got, tt.want)
+		}
+		if err == nil && got.String() != tt.in {
+			t.Errorf("ParsePortRange(%q).String() = %q", tt.in, got.String())
+		}
+	}
+}
+
+func TestPortRangeContains(t *testing.T) {
+	r := PortRange{100, 200}
+	for port, want := range map[uint16]bool{99: false, 100: true, 150: true, 200: true, 201: false} {
+		if got := r.Contains(port); got != want {
+			t.Errorf("%v.Contains(%d) = %v; want %v", r, port, got, want)
+		}
+	}
+	if !(PortRange{}).Contains(1) {
+		t.Error("zero PortRange doesn't contain port 1")
+	}
+	if got := r.Len(); got != 101 {
+		t.Errorf("Len = %d; want 101", got)
+	}
+}
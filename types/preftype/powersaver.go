@@ -0,0 +1,46 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package preftype
+
+import "fmt"
+
+// PowerSaverMode is when to stretch disco heartbeats and NAT
+// keepalives to save battery and metered data, at the cost of slower
+// detection of broken direct paths and NAT mappings that may expire.
+type PowerSaverMode string
+
+const (
+	// PowerSaverOff never saves power. It's the default.
+	PowerSaverOff = PowerSaverMode("")
+
+	// PowerSaverAuto saves power while the machine is on battery or
+	// its default route is over a metered link, such as LTE, where
+	// the platform exposes either.
+	PowerSaverAuto = PowerSaverMode("auto")
+
+	// PowerSaverOn always saves power.
+	PowerSaverOn = PowerSaverMode("on")
+)
+
+// ParsePowerSaverMode parses a power saver mode: "off" (or empty),
+// "auto" or "on".
+func ParsePowerSaverMode(s string) (PowerSaverMode, error) {
+	switch s {
+	case "", "off":
+		return PowerSaverOff, nil
+	case "auto":
+		return PowerSaverAuto, nil
+	case "on":
+		return PowerSaverOn, nil
+	}
+	return "", fmt.Errorf("invalid power saver mode %q; want \"off\", \"auto\" or \"on\"", s)
+}
+
+func (m PowerSaverMode) String() string {
+	if m == PowerSaverOff {
+		return "off"
+	}
+	return string(m)
+}
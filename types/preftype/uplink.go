@@ -0,0 +1,64 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package preftype
+
+import (
+	"fmt"
+	"strings"
+)
+
+// UplinkMode is how Tailscale may use one of the local network
+// interfaces (uplinks) of a multi-homed machine to reach peers.
+type UplinkMode string
+
+const (
+	// UplinkPrefer binds Tailscale's UDP sockets to the interface
+	// while it's up, so all direct traffic to peers uses it. If more
+	// than one interface is preferred, the first one that's up wins.
+	UplinkPrefer = UplinkMode("prefer")
+
+	// UplinkDiscoOnly allows path discovery over the interface but
+	// never sends bulk (WireGuard) traffic directly over it; peers
+	// only reachable through it are relayed over DERP instead.
+	UplinkDiscoOnly = UplinkMode("disco-only")
+
+	// UplinkExclude never uses the interface to reach peers directly.
+	UplinkExclude = UplinkMode("exclude")
+)
+
+// UplinkRule is a parsed element of an uplink policy.
+type UplinkRule struct {
+	Interface string
+	Mode      UplinkMode
+}
+
+func (r UplinkRule) String() string {
+	return r.Interface + ":" + string(r.Mode)
+}
+
+// ParseUplinkPolicy parses an uplink policy: rules of the form
+// "<interface>:<mode>", such as "eth0:prefer" or "wwan0:disco-only".
+// Each interface may only appear once.
+func ParseUplinkPolicy(rules []string) ([]UplinkRule, error) {
+	var ret []UplinkRule
+	seen := map[string]bool{}
+	for _, s := range rules {
+		name, mode, ok := strings.Cut(s, ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid uplink rule %q; want <interface>:<mode>", s)
+		}
+		switch m := UplinkMode(mode); m {
+		case UplinkPrefer, UplinkDiscoOnly, UplinkExclude:
+		default:
+			return nil, fmt.Errorf("invalid uplink mode %q; want %q, %q or %q", mode, UplinkPrefer, UplinkDiscoOnly, UplinkExclude)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("interface %q appears more than once in uplink policy", name)
+		}
+		seen[name] = true
+		ret = append(ret, UplinkRule{name, UplinkMode(mode)})
+	}
+	return ret, nil
+}
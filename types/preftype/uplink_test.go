@@ -0,0 +1,42 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package preftype
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseUplinkPolicy(t *testing.T) {
+	tests := []struct {
+		in      []string
+		want    []UplinkRule
+		wantErr bool
+	}{
+		{in: nil, want: nil},
+		{
+			in: []string{"eth0:prefer", "wwan0:disco-only", "wlan1:exclude"},
+			want: []UplinkRule{
+				{"eth0", UplinkPrefer},
+				{"wwan0", UplinkDiscoOnly},
+				{"wlan1", UplinkExclude},
+			},
+		},
+		{in: []string{"eth0"}, wantErr: true},
+		{in: []string{":prefer"}, wantErr: true},
+		{in: []string{"eth0:bulk"}, wantErr: true},
+		{in: []string{"eth0:prefer", "eth0:exclude"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseUplinkPolicy(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseUplinkPolicy(%q) error = %v; want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseUplinkPolicy(%q) = %v; want %v", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package proxyconn contains the types describing the connections of
+// tailscaled's outbound proxies, shared by net/tsdial, which tracks
+// them, and the LocalAPI clients.
+package proxyconn
+
+import "time"
+
+// Conns is the recent connections of the userspace SOCKS5 and
+// HTTP outbound proxies (tailscaled's --socks5-server and
+// --outbound-http-proxy-listen).
+type Conns struct {
+	// Total and Failed are how many connections the proxies made or
+	// failed to make since tailscaled started.
+	Total  int64
+	Failed int64
+
+	// Conns are the open connections and the most recent closed or
+	// failed ones, oldest first.
+	Conns []Conn
+}
+
+// Conn is a connection that an outbound proxy made, or tried to
+// make, on behalf of a client.
+type Conn struct {
+	ID     int64
+	Proxy  string // "socks5" or "http"
+	Target string // the host:port the client asked for
+
+	// Resolved is the ip:port dialed, if Target's host was resolved.
+	Resolved string `json:",omitempty"`
+
+	// ResolvedBy is how Target's host was resolved: "literal" if it's
+	// an IP, "magicdns" if it's the name of a peer or an extra DNS
+	// record in the netmap, or "dns" otherwise.
+	ResolvedBy string `json:",omitempty"`
+
+	// Route is how Resolved was dialed: "netstack" if with
+	// tailscaled's userspace network stack, as are peers and subnet
+	// routes in userspace networking mode, or "system" if with the
+	// host's, which routes it over Tailscale itself when using a TUN.
+	Route string `json:",omitempty"`
+
+	Start time.Time
+	End   time.Time `json:",omitempty"` // zero while open
+
+	BytesIn  int64 // received from Target
+	BytesOut int64 // sent to Target
+
+	// Err is the first error dialing, reading or writing, if any.
+	Err string `json:",omitempty"`
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package crashreport lets a program record panics in goroutines that
+// are started by packages that don't know how it records them.
+package crashreport
+
+import (
+	"runtime/debug"
+
+	"tailscale.com/syncs"
+)
+
+var writer syncs.AtomicValue[func(p any, stack []byte)]
+
+// SetWriter sets the func that Handle and Report pass panics to, along
+// with the stack of the panicking goroutine. It's typically set once,
+// by main.
+func SetWriter(f func(p any, stack []byte)) {
+	writer.Store(f)
+}
+
+// Report passes p, a panic value the caller recovered, to the writer
+// set by SetWriter, if any. It must be called from the deferred func
+// that recovered p, so that the stack still shows where p came from.
+func Report(p any) {
+	if f := writer.Load(); f != nil {
+		f(p, debug.Stack())
+	}
+}
+
+// Handle reports the panic, if the goroutine it's deferred in is
+// panicking, and then continues it. It must be called directly by
+// defer:
+//
+//	defer crashreport.Handle()
+func Handle() {
+	p := recover()
+	if p == nil {
+		return
+	}
+	Report(p)
+	panic(p)
+}
+
+// Go runs f in a new goroutine that reports any panic with Handle.
+func Go(f func()) {
+	go func() {
+		defer Handle()
+		f()
+	}()
+}
@@ -0,0 +1,37 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package crashreport
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHandle(t *testing.T) {
+	var gotP any
+	var gotStack string
+	SetWriter(func(p any, stack []byte) {
+		gotP, gotStack = p, string(stack)
+	})
+	defer SetWriter(nil)
+
+	func() {
+		defer func() {
+			if p := recover(); p != "boom" {
+				t.Errorf("panic not continued; recovered %v", p)
+			}
+		}()
+		defer Handle()
+		panicky()
+	}()
+	if gotP != "boom" {
+		t.Errorf("reported %v; want boom", gotP)
+	}
+	if !strings.Contains(gotStack, "panicky") {
+		t.Errorf("stack doesn't show where the panic came from:\n%s", gotStack)
+	}
+}
+
+func panicky() { panic("boom") }
@@ -0,0 +1,150 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package latencyhist provides histograms of the latencies observed
+// over the last few minutes, such as the round-trip times to a peer,
+// for checking them against latency objectives.
+package latencyhist
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// bucketBounds are the upper bounds of the histogram buckets. Latencies
+// above the last bound go in a final overflow bucket.
+var bucketBounds = [...]time.Duration{
+	time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2 * time.Second,
+	5 * time.Second,
+}
+
+const (
+	// slotDuration is the period of time each slot of a Histogram
+	// counts the latencies of.
+	slotDuration = time.Minute
+
+	numSlots = 15
+
+	// MaxWindow is the longest window a Snapshot can cover.
+	MaxWindow = numSlots * slotDuration
+)
+
+type counts [len(bucketBounds) + 1]int64
+
+type slot struct {
+	start  time.Time // of the slotDuration period counted
+	counts counts
+}
+
+// Histogram counts the latencies observed over the last MaxWindow, by
+// minute. The zero value is ready to use. It's safe for concurrent use.
+type Histogram struct {
+	mu    sync.Mutex
+	slots [numSlots]slot // ring, by minute
+}
+
+// Observe records a latency of d, observed now.
+func (h *Histogram) Observe(d time.Duration) {
+	h.ObserveAt(time.Now(), d)
+}
+
+// ObserveAt records a latency of d, observed at now.
+func (h *Histogram) ObserveAt(now time.Time, d time.Duration) {
+	start := now.Truncate(slotDuration)
+	i := int(start.Unix()/int64(slotDuration/time.Second)) % numSlots
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := &h.slots[i]
+	if !s.start.Equal(start) {
+		*s = slot{start: start}
+	}
+	s.counts[bucketFor(d)]++
+}
+
+// bucketFor returns the index of the bucket that d is counted in.
+func bucketFor(d time.Duration) int {
+	for i, b := range bucketBounds {
+		if d <= b {
+			return i
+		}
+	}
+	return len(bucketBounds)
+}
+
+// Snapshot returns the latencies observed in the window before now,
+// which is rounded up to whole minutes and at most MaxWindow.
+func (h *Histogram) Snapshot(now time.Time, window time.Duration) Snapshot {
+	if window > MaxWindow {
+		window = MaxWindow
+	}
+	cur := now.Truncate(slotDuration)
+	oldest := cur.Add(-window.Truncate(slotDuration))
+	if window%slotDuration == 0 {
+		oldest = oldest.Add(slotDuration)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var ret Snapshot
+	for _, s := range h.slots {
+		if s.start.IsZero() || s.start.Before(oldest) || s.start.After(cur) {
+			continue
+		}
+		for i, n := range s.counts {
+			ret.counts[i] += n
+			ret.count += n
+		}
+	}
+	return ret
+}
+
+// Snapshot is the latencies a Histogram observed over a window of time.
+type Snapshot struct {
+	count  int64
+	counts counts
+}
+
+// Count returns the number of latencies observed.
+func (s Snapshot) Count() int64 { return s.count }
+
+// Quantile returns an estimate of the q quantile of the latencies,
+// such as the median for 0.5, interpolated within the bucket it falls
+// in. It returns zero if there are none. Latencies beyond the largest
+// bucket are counted as the largest bucket's bound, so higher
+// quantiles are underestimated once more than a few latencies are
+// that high.
+func (s Snapshot) Quantile(q float64) time.Duration {
+	if s.count == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(q * float64(s.count)))
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	var lo time.Duration
+	for i, n := range s.counts {
+		if i == len(bucketBounds) {
+			return lo
+		}
+		hi := bucketBounds[i]
+		if seen+n >= rank {
+			frac := float64(rank-seen) / float64(n)
+			return lo + time.Duration(frac*float64(hi-lo))
+		}
+		seen += n
+		lo = hi
+	}
+	return lo
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package latencyhist
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistogram(t *testing.T) {
+	var h Histogram
+	start := time.Unix(1_600_000_000, 0).Truncate(time.Minute)
+	if got := h.Snapshot(start, 5*time.Minute).Count(); got != 0 {
+		t.Fatalf("empty histogram has %d latencies", got)
+	}
+
+	// 10 minutes ago: slow.
+	for i := 0; i < 10; i++ {
+		h.ObserveAt(start.Add(-10*time.Minute), 3*time.Second)
+	}
+	// In the last two minutes: 90 fast, 10 slow.
+	for i := 0; i < 90; i++ {
+		h.ObserveAt(start.Add(-time.Minute+time.Second), 4*time.Millisecond)
+	}
+	for i := 0; i < 10; i++ {
+		h.ObserveAt(start.Add(30*time.Second), 150*time.Millisecond)
+	}
+
+	s := h.Snapshot(start.Add(30*time.Second), 5*time.Minute)
+	if got := s.Count(); got != 100 {
+		t.Errorf("5m count = %d; want 100", got)
+	}
+	if got := s.Quantile(0.5); got <= 2*time.Millisecond || got > 5*time.Millisecond {
+		t.Errorf("p50 = %v; want in (2ms, 5ms]", got)
+	}
+	if got := s.Quantile(0.95); got <= 100*time.Millisecond || got > 200*time.Millisecond {
+		t.Errorf("p95 = %v; want in (100ms, 200ms]", got)
+	}
+	if got := h.Snapshot(start.Add(30*time.Second), time.Minute).Count(); got != 10 {
+		t.Errorf("1m count = %d; want 10", got)
+	}
+	if got := h.Snapshot(start.Add(30*time.Second), time.Hour).Count(); got != 110 {
+		t.Errorf("1h count = %d; want 110, capped at MaxWindow", got)
+	}
+
+	// After the ring wraps around, the oldest minutes are forgotten.
+	later := start.Add(MaxWindow + time.Minute)
+	h.ObserveAt(later, time.Millisecond)
+	if got := h.Snapshot(later, MaxWindow).Count(); got != 1 {
+		t.Errorf("count after wrapping = %d; want 1", got)
+	}
+}
+
+func TestQuantileOverflow(t *testing.T) {
+	var h Histogram
+	now := time.Unix(1_600_000_000, 0)
+	h.ObserveAt(now, time.Minute)
+	if got, want := h.Snapshot(now, time.Minute).Quantile(0.9), 5*time.Second; got != want {
+		t.Errorf("p90 = %v; want %v", got, want)
+	}
+}
@@ -0,0 +1,29 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package leakwatch
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// fdKinds returns the number of open file descriptors of each kind (see
+// fdKind).
+func fdKinds() map[string]int {
+	const dir = "/proc/self/fd"
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	m := map[string]int{}
+	for _, de := range des {
+		target, err := os.Readlink(filepath.Join(dir, de.Name()))
+		if err != nil {
+			continue // closed since ReadDir
+		}
+		m[fdKind(target)]++
+	}
+	return m
+}
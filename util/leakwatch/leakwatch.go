@@ -0,0 +1,263 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package leakwatch watches the process for goroutine and file
+// descriptor leaks.
+package leakwatch
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"tailscale.com/health"
+	"tailscale.com/metrics"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/multierr"
+)
+
+const (
+	// sampleInterval is how often goroutines and file descriptors
+	// are counted.
+	sampleInterval = time.Minute
+
+	// windowSamples is the number of samples a growth trend is
+	// detected over.
+	windowSamples = 30
+
+	// minGoroutineGrowth and minFDGrowth are how much the goroutine
+	// and file descriptor counts must grow over windowSamples to be
+	// considered a leak.
+	minGoroutineGrowth = 200
+	minFDGrowth        = 100
+
+	// maxCulprits is the number of goroutine stacks and file
+	// descriptor kinds logged when a leak is detected.
+	maxCulprits = 5
+)
+
+// Watcher periodically counts the process's goroutines and file
+// descriptors. When either grows steadily, it logs the most common
+// goroutine stacks or file descriptor kinds and raises a health
+// warning.
+type Watcher struct {
+	logf logger.Logf
+	stop chan struct{}
+	done chan struct{}
+
+	// Owned by the run goroutine.
+	goroutines series
+	fds        series
+}
+
+// New returns a new Watcher that has started watching. Close stops it.
+func New(logf logger.Logf) *Watcher {
+	w := newWatcher(logf)
+	go w.run()
+	return w
+}
+
+func newWatcher(logf logger.Logf) *Watcher {
+	return &Watcher{
+		logf:       logger.WithPrefix(logf, "leakwatch: "),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+		goroutines: series{name: "goroutine", minGrowth: minGoroutineGrowth},
+		fds:        series{name: "file descriptor", minGrowth: minFDGrowth},
+	}
+}
+
+// Close stops w.
+func (w *Watcher) Close() error {
+	close(w.stop)
+	<-w.done
+	return nil
+}
+
+func (w *Watcher) run() {
+	defer close(w.done)
+	t := time.NewTicker(sampleInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-t.C:
+			w.check(runtime.NumGoroutine(), metrics.CurrentFDs())
+		}
+	}
+}
+
+// check records a sample of the goroutine and file descriptor counts,
+// logging culprits of newly detected leaks and updating health.
+func (w *Watcher) check(goroutines, fds int) {
+	gStarted, gErr := w.goroutines.add(goroutines)
+	fStarted, fErr := w.fds.add(fds)
+	if gStarted {
+		w.logf("%v; most common stacks:\n%s", gErr, formatStacks(goroutineStacks()))
+	}
+	if fStarted {
+		w.logf("%v; most common kinds:\n%s", fErr, formatFDKinds(fdKinds()))
+	}
+	health.SetResourceLeakHealth(multierr.New(gErr, fErr))
+}
+
+// series is a window of recent samples of a count.
+type series struct {
+	name      string // "goroutine" or "file descriptor"
+	minGrowth int
+	samples   []int // oldest first
+	leaking   bool
+}
+
+// add appends the sample v. It returns an error describing the leak if
+// the series is growing, and whether it only just started growing.
+func (s *series) add(v int) (started bool, err error) {
+	s.samples = append(s.samples, v)
+	if len(s.samples) > windowSamples {
+		s.samples = append(s.samples[:0], s.samples[1:]...)
+	}
+	wasLeaking := s.leaking
+	s.leaking = growing(s.samples, s.minGrowth)
+	if !s.leaking {
+		return false, nil
+	}
+	err = fmt.Errorf("%s count grew from %d to %d in the last %v; possible leak",
+		s.name, s.samples[0], v, time.Duration(len(s.samples)-1)*sampleInterval)
+	return !wasLeaking, err
+}
+
+// growing reports whether the full window of samples shows sustained
+// growth of at least minGrowth: every sample in its second half must
+// exceed every sample in its first half, so one-off spikes that go away
+// again aren't mistaken for leaks.
+func growing(samples []int, minGrowth int) bool {
+	if len(samples) < windowSamples {
+		return false
+	}
+	if samples[len(samples)-1]-samples[0] < minGrowth {
+		return false
+	}
+	half := len(samples) / 2
+	maxFirst := samples[0]
+	for _, v := range samples[:half] {
+		if v > maxFirst {
+			maxFirst = v
+		}
+	}
+	for _, v := range samples[half:] {
+		if v <= maxFirst {
+			return false
+		}
+	}
+	return true
+}
+
+// stack is a goroutine stack and how many goroutines have it.
+type stack struct {
+	count  int
+	frames []string // "func file:line", innermost first
+}
+
+// goroutineStacks returns the most common goroutine stacks.
+func goroutineStacks() []stack {
+	var buf bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, 1); err != nil {
+		return nil
+	}
+	return parseGoroutineProfile(buf.Bytes(), maxCulprits)
+}
+
+// parseGoroutineProfile parses the n most common stacks from a
+// goroutine profile in its debug=1 text format, which groups the
+// goroutines by stack.
+func parseGoroutineProfile(profile []byte, n int) []stack {
+	var stacks []stack
+	s := string(profile)
+	if strings.HasPrefix(s, "goroutine profile:") {
+		_, s, _ = strings.Cut(s, "\n")
+	}
+	for _, block := range strings.Split(s, "\n\n") {
+		lines := strings.Split(strings.TrimSpace(block), "\n")
+		countStr, _, ok := strings.Cut(lines[0], " @ ")
+		if !ok {
+			continue
+		}
+		count, err := strconv.Atoi(countStr)
+		if err != nil {
+			continue
+		}
+		st := stack{count: count}
+		for _, line := range lines[1:] {
+			// "#\t0x4374f5\truntime.gopark+0x115\t/usr/lib/go/src/runtime/proc.go:363"
+			f := strings.Fields(line)
+			if len(f) != 4 || f[0] != "#" {
+				continue
+			}
+			fn, _, _ := strings.Cut(f[2], "+")
+			st.frames = append(st.frames, fn+" "+f[3])
+		}
+		stacks = append(stacks, st)
+	}
+	sort.SliceStable(stacks, func(i, j int) bool { return stacks[i].count > stacks[j].count })
+	if len(stacks) > n {
+		stacks = stacks[:n]
+	}
+	return stacks
+}
+
+func formatStacks(stacks []stack) string {
+	var sb strings.Builder
+	for _, st := range stacks {
+		fmt.Fprintf(&sb, "%d goroutines:\n", st.count)
+		for _, f := range st.frames {
+			fmt.Fprintf(&sb, "\t%s\n", f)
+		}
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// formatFDKinds formats the maxCulprits most common kinds in m, a map
+// of file descriptor kinds to their counts.
+func formatFDKinds(m map[string]int) string {
+	if len(m) == 0 {
+		return "\tunknown"
+	}
+	kinds := make([]string, 0, len(m))
+	for k := range m {
+		kinds = append(kinds, k)
+	}
+	sort.Slice(kinds, func(i, j int) bool {
+		if m[kinds[i]] != m[kinds[j]] {
+			return m[kinds[i]] > m[kinds[j]]
+		}
+		return kinds[i] < kinds[j]
+	})
+	if len(kinds) > maxCulprits {
+		kinds = kinds[:maxCulprits]
+	}
+	var sb strings.Builder
+	for _, k := range kinds {
+		fmt.Fprintf(&sb, "\t%d %s\n", m[k], k)
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// fdKind returns the kind of file descriptor whose /proc/self/fd link
+// target is target: the target without its inode number for sockets
+// and pipes ("socket", "pipe"), and the target itself otherwise.
+func fdKind(target string) string {
+	if strings.HasPrefix(target, "anon_inode:") {
+		return target
+	}
+	if kind, _, ok := strings.Cut(target, ":["); ok {
+		return kind
+	}
+	return target
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package leakwatch
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func ramp(start, step int) []int {
+	s := make([]int, windowSamples)
+	for i := range s {
+		s[i] = start + i*step
+	}
+	return s
+}
+
+func TestGrowing(t *testing.T) {
+	spike := ramp(100, 0)
+	spike[5] = 1000
+	spike[len(spike)-1] = 1000
+
+	tests := []struct {
+		name    string
+		samples []int
+		want    bool
+	}{
+		{"short", []int{1, 100, 1000}, false},
+		{"flat", ramp(100, 0), false},
+		{"slow", ramp(100, 1), false},
+		{"leak", ramp(100, 10), true},
+		{"spike", spike, false},
+		{"shrinking", ramp(1000, -10), false},
+	}
+	for _, tt := range tests {
+		if got := growing(tt.samples, 100); got != tt.want {
+			t.Errorf("%s: growing = %v; want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSeries(t *testing.T) {
+	s := &series{name: "goroutine", minGrowth: 100}
+	var starts int
+	for i := 0; i < 2*windowSamples; i++ {
+		started, err := s.add(100 + 10*i)
+		if started {
+			starts++
+		}
+		if wantErr := i >= windowSamples-1; (err != nil) != wantErr {
+			t.Fatalf("sample %d: err = %v; want error %v", i, err, wantErr)
+		}
+	}
+	if starts != 1 {
+		t.Errorf("started %d times; want 1", starts)
+	}
+	if len(s.samples) != windowSamples {
+		t.Errorf("kept %d samples; want %d", len(s.samples), windowSamples)
+	}
+
+	// Once the count stops growing, the leak clears.
+	for i := 0; i < windowSamples; i++ {
+		s.add(1000)
+	}
+	if s.leaking {
+		t.Error("still leaking after the count stopped growing")
+	}
+}
+
+func TestParseGoroutineProfile(t *testing.T) {
+	const profile = `goroutine profile: total 13
+2 @ 0x43a4f6 0x406a9c
+#	0x4374f5	runtime.gopark+0x115	/usr/lib/go/src/runtime/proc.go:363
+#	0x5d1e2b	net.(*netFD).accept+0x2b	/usr/lib/go/src/net/fd_unix.go:172
+
+10 @ 0x43a4f6 0x406a9c
+#	0x4374f5	runtime.gopark+0x115	/usr/lib/go/src/runtime/proc.go:363
+#	0x8a1f00	tailscale.com/net/tstun.(*Wrapper).poll+0x40	/src/net/tstun/wrap.go:420
+
+1 @ 0x43a4f6
+#	0x4374f5	runtime.gopark+0x115	/usr/lib/go/src/runtime/proc.go:363
+`
+	got := parseGoroutineProfile([]byte(profile), 2)
+	want := []stack{
+		{10, []string{
+			"runtime.gopark /usr/lib/go/src/runtime/proc.go:363",
+			"tailscale.com/net/tstun.(*Wrapper).poll /src/net/tstun/wrap.go:420",
+		}},
+		{2, []string{
+			"runtime.gopark /usr/lib/go/src/runtime/proc.go:363",
+			"net.(*netFD).accept /usr/lib/go/src/net/fd_unix.go:172",
+		}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v; want %+v", got, want)
+	}
+}
+
+func TestGoroutineStacks(t *testing.T) {
+	stacks := goroutineStacks()
+	if len(stacks) == 0 {
+		t.Fatal("no goroutine stacks")
+	}
+	if !strings.Contains(formatStacks(stacks), "TestGoroutineStacks") {
+		t.Errorf("stacks don't include the test's own goroutine:\n%s", formatStacks(stacks))
+	}
+}
+
+func TestFDKind(t *testing.T) {
+	tests := map[string]string{
+		"socket:[12345]":       "socket",
+		"pipe:[678]":           "pipe",
+		"anon_inode:[eventfd]": "anon_inode:[eventfd]",
+		"/dev/net/tun":         "/dev/net/tun",
+	}
+	for in, want := range tests {
+		if got := fdKind(in); got != want {
+			t.Errorf("fdKind(%q) = %q; want %q", in, got, want)
+		}
+	}
+}
+
+func TestFormatFDKinds(t *testing.T) {
+	got := formatFDKinds(map[string]int{"socket": 30, "pipe": 2, "/dev/null": 2})
+	want := "\t30 socket\n\t2 /dev/null\n\t2 pipe"
+	if got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package powerstate reports whether the machine is running on battery
+// power or reaching the internet over a likely metered link, where the
+// platform exposes it.
+package powerstate
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// onBatteryFunc, if non-nil, is the platform's implementation of
+// OnBattery.
+var onBatteryFunc func() (onBattery, ok bool)
+
+// OnBattery reports whether the machine is running on battery power.
+// If the platform doesn't say, ok is false.
+func OnBattery() (onBattery, ok bool) {
+	if onBatteryFunc == nil {
+		return false, false
+	}
+	return onBatteryFunc()
+}
+
+// IsMeteredInterface reports whether the network interface named name
+// is likely metered, judging by its name: Linux names cellular modems
+// like "wwan0", "wwp0s20f0u6" or "rmnet_data0". Platforms that know
+// better, such as Android and iOS, tell the engine via its LinkChange
+// method instead.
+func IsMeteredInterface(name string) bool {
+	for _, prefix := range []string{"wwan", "wwp", "wwx", "rmnet"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// onBatterySysfs implements OnBattery by reading the power supplies in
+// dir, as in Linux's /sys/class/power_supply. The machine is on battery
+// if it has a battery that's discharging and no external power. The
+// batteries of peripherals, such as wireless mice, don't count.
+func onBatterySysfs(dir string) (onBattery, ok bool) {
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		return false, false
+	}
+	var discharging, mains bool
+	for _, e := range ents {
+		read := func(name string) string {
+			bs, _ := os.ReadFile(filepath.Join(dir, e.Name(), name))
+			return strings.TrimSpace(string(bs))
+		}
+		switch read("type") {
+		case "Mains", "USB":
+			if read("online") == "1" {
+				mains = true
+			}
+		case "Battery":
+			if read("scope") == "Device" {
+				continue
+			}
+			if read("status") == "Discharging" {
+				discharging = true
+			}
+		}
+	}
+	return discharging && !mains, true
+}
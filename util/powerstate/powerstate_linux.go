@@ -0,0 +1,14 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !android
+// +build !android
+
+package powerstate
+
+func init() {
+	onBatteryFunc = func() (onBattery, ok bool) {
+		return onBatterySysfs("/sys/class/power_supply")
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package powerstate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOnBatterySysfs(t *testing.T) {
+	type supply map[string]string // file name => contents
+	tests := []struct {
+		name     string
+		supplies map[string]supply
+		want     bool
+	}{
+		{
+			name: "desktop",
+			want: false,
+		},
+		{
+			name: "laptop-unplugged",
+			supplies: map[string]supply{
+				"AC":   {"type": "Mains", "online": "0"},
+				"BAT0": {"type": "Battery", "scope": "System", "status": "Discharging"},
+			},
+			want: true,
+		},
+		{
+			name: "laptop-plugged-in",
+			supplies: map[string]supply{
+				"AC":   {"type": "Mains", "online": "1"},
+				"BAT0": {"type": "Battery", "status": "Charging"},
+			},
+			want: false,
+		},
+		{
+			name: "laptop-full-but-reported-discharging",
+			supplies: map[string]supply{
+				"ADP1": {"type": "Mains", "online": "1"},
+				"BAT0": {"type": "Battery", "status": "Discharging"},
+			},
+			want: false,
+		},
+		{
+			name: "desktop-with-wireless-mouse",
+			supplies: map[string]supply{
+				"hidpp_battery_0": {"type": "Battery", "scope": "Device", "status": "Discharging"},
+			},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, files := range tt.supplies {
+				if err := os.Mkdir(filepath.Join(dir, name), 0755); err != nil {
+					t.Fatal(err)
+				}
+				for f, contents := range files {
+					if err := os.WriteFile(filepath.Join(dir, name, f), []byte(contents+"\n"), 0644); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			got, ok := onBatterySysfs(dir)
+			if !ok {
+				t.Fatal("not ok")
+			}
+			if got != tt.want {
+				t.Errorf("onBattery = %v; want %v", got, tt.want)
+			}
+		})
+	}
+	if _, ok := onBatterySysfs(filepath.Join(t.TempDir(), "missing")); ok {
+		t.Error("ok without a power_supply directory")
+	}
+}
+
+func TestIsMeteredInterface(t *testing.T) {
+	for name, want := range map[string]bool{
+		"wwan0":       true,
+		"wwp0s20f0u6": true,
+		"rmnet_data0": true,
+		"eth0":        false,
+		"wlan0":       false,
+		"tailscale0":  false,
+	} {
+		if got := IsMeteredInterface(name); got != want {
+			t.Errorf("IsMeteredInterface(%q) = %v; want %v", name, got, want)
+		}
+	}
+}
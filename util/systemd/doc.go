@@ -10,5 +10,9 @@
 systemd unit with the Type=notify flag set. On other operating systems (or
 when running in a Linux distro without being run from inside systemd) this
 package will become a no-op.
+
+It also gives access to the file descriptors that systemd passes to the
+process, for socket activation, and lets the process store them with
+systemd to keep them open across restarts.
 */
 package systemd
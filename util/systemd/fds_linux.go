@@ -0,0 +1,131 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+// +build linux
+
+package systemd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// listenFDsStart is the first file descriptor that systemd passes to
+// a process, per sd_listen_fds(3).
+const listenFDsStart = 3
+
+var listenFiles struct {
+	once sync.Once
+	mu   sync.Mutex
+	m    map[string][]*os.File // by name
+}
+
+// listenFDNames returns the names of the file descriptors that systemd
+// passed to the process with PID pid, in order from listenFDsStart,
+// given the values of the LISTEN_PID, LISTEN_FDS and LISTEN_FDNAMES
+// environment variables. It returns nil if none were passed to pid.
+func listenFDNames(pid int, listenPID, listenFDs, fdNames string) ([]string, error) {
+	if listenPID == "" || listenFDs == "" {
+		return nil, nil
+	}
+	p, err := strconv.Atoi(listenPID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LISTEN_PID %q", listenPID)
+	}
+	if p != pid {
+		// Meant for our parent, which didn't unset them.
+		return nil, nil
+	}
+	n, err := strconv.Atoi(listenFDs)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", listenFDs)
+	}
+	var given []string
+	if fdNames != "" {
+		given = strings.Split(fdNames, ":")
+	}
+	names := make([]string, n)
+	for i := range names {
+		// "unknown" is what systemd names file descriptors without
+		// a FileDescriptorName=.
+		names[i] = "unknown"
+		if i < len(given) && given[i] != "" {
+			names[i] = given[i]
+		}
+	}
+	return names, nil
+}
+
+func loadListenFiles() {
+	names, err := listenFDNames(os.Getpid(), os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES"))
+	// Don't let child processes think they're for them.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if err != nil {
+		log.Printf("systemd: %v", err)
+		return
+	}
+	listenFiles.m = make(map[string][]*os.File)
+	for i, name := range names {
+		fd := listenFDsStart + i
+		syscall.CloseOnExec(fd)
+		listenFiles.m[name] = append(listenFiles.m[name], os.NewFile(uintptr(fd), name))
+	}
+}
+
+// ListenFile returns a file descriptor that systemd passed to the
+// process with the given name, such as a socket of a .socket unit with
+// FileDescriptorName=name or one stored by StoreFiles before a restart.
+// The caller owns the returned file; later calls don't return it again.
+// It returns nil if there's none.
+func ListenFile(name string) *os.File {
+	listenFiles.once.Do(loadListenFiles)
+	listenFiles.mu.Lock()
+	defer listenFiles.mu.Unlock()
+	fs := listenFiles.m[name]
+	if len(fs) == 0 {
+		return nil
+	}
+	listenFiles.m[name] = fs[1:]
+	return fs[0]
+}
+
+// StoreFiles asks systemd to keep duplicates of files in the service's
+// file descriptor store under name, replacing any stored under it
+// before, and to pass them back when the service restarts, retrievable
+// with ListenFile. This lets sockets outlive a restart, such as for an
+// upgrade. The unit must set FileDescriptorStoreMax= for systemd to
+// keep them.
+func StoreFiles(name string, files ...*os.File) error {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return errors.New("not running under systemd")
+	}
+	c, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if _, _, err := c.WriteMsgUnix([]byte("FDSTOREREMOVE=1\nFDNAME="+name), nil, nil); err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	fds := make([]int, len(files))
+	for i, f := range files {
+		fds[i] = int(f.Fd())
+	}
+	_, _, err = c.WriteMsgUnix([]byte("FDSTORE=1\nFDNAME="+name), syscall.UnixRights(fds...), nil)
+	return err
+}
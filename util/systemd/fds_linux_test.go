@@ -0,0 +1,39 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package systemd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestListenFDNames(t *testing.T) {
+	tests := []struct {
+		name    string
+		pid     string
+		fds     string
+		names   string
+		want    []string
+		wantErr bool
+	}{
+		{name: "none"},
+		{name: "other_pid", pid: "42", fds: "1"},
+		{name: "unnamed", pid: "7", fds: "2", want: []string{"unknown", "unknown"}},
+		{name: "named", pid: "7", fds: "3", names: "localapi:udp4:", want: []string{"localapi", "udp4", "unknown"}},
+		{name: "bad_pid", pid: "x", fds: "1", wantErr: true},
+		{name: "bad_fds", pid: "7", fds: "-1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := listenFDNames(7, tt.pid, tt.fds, tt.names)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v; wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -7,5 +7,17 @@
 
 package systemd
 
+import (
+	"errors"
+	"os"
+	"runtime"
+)
+
 func Ready()                {}
 func Status(string, ...any) {}
+
+func ListenFile(string) *os.File { return nil }
+
+func StoreFiles(string, ...*os.File) error {
+	return errors.New("systemd not supported on " + runtime.GOOS)
+}
@@ -0,0 +1,242 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package watchdog restarts subsystems whose goroutines stop making
+// progress, such as a DERP reader blocked forever handing a packet to
+// wireguard-go.
+//
+// A supervised subsystem registers a Heartbeat, marks the work it
+// expects to finish promptly with Busy and Idle, and supplies a func
+// that restarts it. If work stays outstanding for the Heartbeat's
+// timeout without any completing, the subsystem is considered wedged
+// and the func is called, with exponential backoff between restarts of
+// subsystems of the same name. Subsystems that can't be restarted
+// without the cooperation of their wedged goroutines supply no func,
+// and are only reported.
+package watchdog
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tailscale.com/envknob"
+	"tailscale.com/util/clientmetric"
+)
+
+const (
+	// checkInterval is how often heartbeats are checked.
+	checkInterval = 5 * time.Second
+
+	// minBackoff and maxBackoff bound the time between restarts of
+	// subsystems of the same name.
+	minBackoff = 10 * time.Second
+	maxBackoff = 5 * time.Minute
+)
+
+// noRestart, if set, makes wedged subsystems only be reported, so they
+// can be inspected in the state they wedged in.
+var noRestart = envknob.RegisterBool("TS_DEBUG_WATCHDOG_NO_RESTART")
+
+var metricRestarts = clientmetric.NewCounter("watchdog_restarts")
+
+// Event describes a wedged subsystem.
+type Event struct {
+	Name       string        // the Heartbeat's name
+	Stuck      time.Duration // how long it made no progress
+	Restarts   int           // times subsystems of this name were found wedged, including this one
+	Restarted  bool          // false if ReportOnly or restarts are disabled for debugging
+	ReportOnly bool          // whether the subsystem has no restart func
+}
+
+// Heartbeat tracks the progress of a supervised subsystem. Its methods
+// are safe for concurrent use, and cheap enough for per-packet use.
+type Heartbeat struct {
+	s       *supervisor
+	name    string
+	timeout time.Duration
+	restart func() // or nil to only report
+
+	pending  atomic.Int32  // Busy calls not yet matched by Idle
+	progress atomic.Uint64 // Idle calls
+
+	// Owned by the supervisor, guarded by s.mu.
+	lastProgress uint64
+	stuckSince   time.Time // zero if not stuck
+}
+
+// Busy records the start of work that should finish within the
+// Heartbeat's timeout.
+func (h *Heartbeat) Busy() { h.pending.Add(1) }
+
+// Idle records the end of work started with Busy.
+func (h *Heartbeat) Idle() {
+	h.pending.Add(-1)
+	h.progress.Add(1)
+}
+
+// Close stops supervising h's subsystem, such as when it shuts down.
+// It's safe to call more than once.
+func (h *Heartbeat) Close() {
+	h.s.mu.Lock()
+	defer h.s.mu.Unlock()
+	delete(h.s.hbs, h)
+	if len(h.s.hbs) == 0 && h.s.timer != nil {
+		h.s.timer.Stop()
+		h.s.timer = nil
+	}
+}
+
+type restartState struct {
+	count   int
+	last    time.Time
+	backoff time.Duration // before the next restart is allowed
+}
+
+type supervisor struct {
+	mu       sync.Mutex
+	hbs      map[*Heartbeat]bool
+	restarts map[string]*restartState
+	watchers map[*watchHandle]func(Event)
+	timer    *time.Timer
+	timeNow  func() time.Time // or nil for time.Now
+}
+
+type watchHandle byte
+
+var std = &supervisor{}
+
+// Register starts supervising a subsystem named name, which is wedged
+// if work it marked Busy is outstanding for timeout with none of it
+// finishing. restart is then called in its own goroutine. It may make
+// the wedged goroutines exit and start new ones that register a new
+// Heartbeat, after closing the old one; otherwise the same Heartbeat
+// gets another timeout to recover before the next restart.
+//
+// restart may be nil if the subsystem can't be restarted safely, such
+// as when that would wait on the wedged goroutines. The subsystem is
+// then only reported to the restart watchers.
+//
+// Subsystems with the same name, such as the same loop of different
+// instances, share the backoff between restarts.
+func Register(name string, timeout time.Duration, restart func()) *Heartbeat {
+	return std.register(name, timeout, restart)
+}
+
+// RegisterRestartWatcher adds a func that's called, in its own
+// goroutine, each time a wedged subsystem is detected. The returned
+// func unregisters it.
+func RegisterRestartWatcher(cb func(Event)) (unregister func()) {
+	s := std
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	handle := new(watchHandle)
+	if s.watchers == nil {
+		s.watchers = map[*watchHandle]func(Event){}
+	}
+	s.watchers[handle] = cb
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.watchers, handle)
+	}
+}
+
+func (s *supervisor) now() time.Time {
+	if s.timeNow != nil {
+		return s.timeNow()
+	}
+	return time.Now()
+}
+
+func (s *supervisor) register(name string, timeout time.Duration, restart func()) *Heartbeat {
+	h := &Heartbeat{
+		s:       s,
+		name:    name,
+		timeout: timeout,
+		restart: restart,
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.hbs == nil {
+		s.hbs = map[*Heartbeat]bool{}
+	}
+	s.hbs[h] = true
+	if s.timer == nil && s.timeNow == nil {
+		s.timer = time.AfterFunc(checkInterval, s.timerCheck)
+	}
+	return h
+}
+
+func (s *supervisor) timerCheck() {
+	s.check()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.timer != nil {
+		s.timer.Reset(checkInterval)
+	}
+}
+
+// check restarts the wedged subsystems whose backoff has passed.
+func (s *supervisor) check() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for h := range s.hbs {
+		p := h.progress.Load()
+		if h.pending.Load() <= 0 || p != h.lastProgress {
+			h.lastProgress = p
+			h.stuckSince = time.Time{}
+			continue
+		}
+		if h.stuckSince.IsZero() {
+			h.stuckSince = now
+			continue
+		}
+		stuck := now.Sub(h.stuckSince)
+		if stuck < h.timeout {
+			continue
+		}
+		st := s.restarts[h.name]
+		if st != nil && now.Sub(st.last) < st.backoff {
+			continue
+		}
+		switch {
+		case st == nil:
+			st = new(restartState)
+			if s.restarts == nil {
+				s.restarts = map[string]*restartState{}
+			}
+			s.restarts[h.name] = st
+			fallthrough
+		case now.Sub(st.last) > 2*maxBackoff:
+			// Stable for a while; start over.
+			st.backoff = minBackoff
+		default:
+			st.backoff *= 2
+			if st.backoff > maxBackoff {
+				st.backoff = maxBackoff
+			}
+		}
+		st.count++
+		st.last = now
+		// Give it another timeout to recover.
+		h.stuckSince = now
+
+		ev := Event{
+			Name:       h.name,
+			Stuck:      stuck,
+			Restarts:   st.count,
+			Restarted:  h.restart != nil && !noRestart(),
+			ReportOnly: h.restart == nil,
+		}
+		for _, cb := range s.watchers {
+			go cb(ev)
+		}
+		if ev.Restarted {
+			metricRestarts.Add(1)
+			go h.restart()
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package watchdog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSupervisor(t *testing.T) {
+	now := time.Unix(1_000_000, 0)
+	s := &supervisor{timeNow: func() time.Time { return now }}
+	restarted := make(chan bool, 10)
+	h := s.register("test", time.Minute, func() { restarted <- true })
+
+	// step advances the clock by d, checking every checkInterval,
+	// and reports how many restarts there were.
+	step := func(d time.Duration) int {
+		t.Helper()
+		for end := now.Add(d); now.Before(end); {
+			now = now.Add(checkInterval)
+			s.check()
+		}
+		n := 0
+		for {
+			select {
+			case <-restarted:
+				n++
+			case <-time.After(10 * time.Millisecond):
+				return n
+			}
+		}
+	}
+
+	// Idle, or busy but making progress, isn't wedged.
+	if n := step(5 * time.Minute); n != 0 {
+		t.Fatalf("idle: %d restarts", n)
+	}
+	h.Busy()
+	for i := 0; i < 10; i++ {
+		h.Idle()
+		h.Busy()
+		if n := step(30 * time.Second); n != 0 {
+			t.Fatalf("progressing: %d restarts", n)
+		}
+	}
+
+	// Stuck for the timeout restarts it once, then again only
+	// after the backoff.
+	if n := step(time.Minute + checkInterval); n != 1 {
+		t.Fatalf("wedged: %d restarts; want 1", n)
+	}
+	if n := step(time.Minute); n != 1 {
+		t.Fatalf("still wedged after %v backoff: %d restarts; want 1", minBackoff, n)
+	}
+	if n := step(minBackoff); n != 0 {
+		t.Fatalf("within %v backoff: %d restarts; want 0", 2*minBackoff, n)
+	}
+	if got := s.restarts["test"].count; got != 2 {
+		t.Errorf("restart count = %d; want 2", got)
+	}
+
+	// Recovering stops the restarts; closing forgets it.
+	h.Idle()
+	if n := step(10 * time.Minute); n != 0 {
+		t.Fatalf("recovered: %d restarts", n)
+	}
+	h.Busy()
+	h.Close()
+	if n := step(10 * time.Minute); n != 0 {
+		t.Fatalf("closed: %d restarts", n)
+	}
+	if len(s.hbs) != 0 {
+		t.Errorf("heartbeats left after Close: %d", len(s.hbs))
+	}
+}
+
+func TestReportOnly(t *testing.T) {
+	now := time.Unix(1_000_000, 0)
+	s := &supervisor{timeNow: func() time.Time { return now }}
+	events := make(chan Event, 10)
+	s.watchers = map[*watchHandle]func(Event){
+		new(watchHandle): func(ev Event) { events <- ev },
+	}
+	h := s.register("test", time.Minute, nil)
+	h.Busy()
+	for end := now.Add(time.Minute + 2*checkInterval); now.Before(end); {
+		now = now.Add(checkInterval)
+		s.check() // must not call the nil restart func
+	}
+	select {
+	case ev := <-events:
+		if !ev.ReportOnly || ev.Restarted {
+			t.Errorf("got %+v; want ReportOnly and not Restarted", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("wedged subsystem not reported")
+	}
+}
@@ -9,6 +9,7 @@
 	"fmt"
 	"net/netip"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go4.org/netipx"
@@ -17,6 +18,7 @@
 	"tailscale.com/net/netaddr"
 	"tailscale.com/net/packet"
 	"tailscale.com/tstime/rate"
+	"tailscale.com/types/filterstats"
 	"tailscale.com/types/ipproto"
 	"tailscale.com/types/logger"
 )
@@ -43,6 +45,19 @@ type Filter struct {
 	matches4 matches
 	matches6 matches
 
+	// rules are the matches the filter was created with, and rules4
+	// and rules6 map the indexes of matches4 and matches6 to the
+	// indexes of the rules they came from.
+	rules          []Match
+	rules4, rules6 []int
+
+	// ruleHits counts the packets accepted by each of rules, by
+	// index, and drops the packets dropped in each direction for each
+	// of dropReasons, since created.
+	ruleHits []atomic.Int64
+	drops    [2][len(dropReasons) + 1]atomic.Int64 // last is other
+	created  time.Time
+
 	// cap4 and cap6 are the subsets of the matches that are about
 	// capability grants, partitioned by source IP address family.
 	cap4, cap6 matches
@@ -181,22 +196,25 @@ func New(matches []Match, localNets *netipx.IPSet, logIPs *netipx.IPSet, shareSt
 	}
 	f := &Filter{
 		logf:     logf,
-		matches4: matchesFamily(matches, netip.Addr.Is4),
-		matches6: matchesFamily(matches, netip.Addr.Is6),
 		cap4:     capMatchesFunc(matches, netip.Addr.Is4),
 		cap6:     capMatchesFunc(matches, netip.Addr.Is6),
 		local:    localNets,
 		logIPs:   logIPs,
 		state:    state,
+		rules:    matches,
+		ruleHits: make([]atomic.Int64, len(matches)),
+		created:  time.Now(),
 	}
+	f.matches4, f.rules4 = matchesFamily(matches, netip.Addr.Is4)
+	f.matches6, f.rules6 = matchesFamily(matches, netip.Addr.Is6)
 	return f
 }
 
 // matchesFamily returns the subset of ms for which keep(srcNet.IP)
-// and keep(dstNet.IP) are both true.
-func matchesFamily(ms matches, keep func(netip.Addr) bool) matches {
-	var ret matches
-	for _, m := range ms {
+// and keep(dstNet.IP) are both true, along with the index in ms of
+// each of them.
+func matchesFamily(ms matches, keep func(netip.Addr) bool) (ret matches, idx []int) {
+	for i, m := range ms {
 		var retm Match
 		retm.IPProto = m.IPProto
 		for _, src := range m.Srcs {
@@ -211,9 +229,10 @@ func matchesFamily(ms matches, keep func(netip.Addr) bool) matches {
 		}
 		if len(retm.Srcs) > 0 && len(retm.Dsts) > 0 {
 			ret = append(ret, retm)
+			idx = append(idx, i)
 		}
 	}
-	return ret
+	return ret, idx
 }
 
 // capMatchesFunc returns a copy of the subset of ms for which keep(srcNet.IP)
@@ -370,6 +389,9 @@ func (f *Filter) RunIn(q *packet.Parsed, rf RunFlags) Response {
 	default:
 		r, why = Drop, "not-ip"
 	}
+	if r == Drop {
+		f.countDrop(dir, why)
+	}
 	f.logRateLimit(rf, q, dir, r, why)
 	return r
 }
@@ -405,8 +427,9 @@ func (f *Filter) runIn4(q *packet.Parsed) (r Response, why string) {
 			//  related to an existing ICMP-Echo, TCP, or UDP
 			//  session.
 			return Accept, "icmp response ok"
-		} else if f.matches4.matchIPsOnly(q) {
+		} else if i := f.matches4.matchIPsOnly(q); i >= 0 {
 			// If any port is open to an IP, allow ICMP to it.
+			f.countRule(f.rules4, i)
 			return Accept, "icmp ok"
 		}
 	case ipproto.TCP:
@@ -421,7 +444,8 @@ func (f *Filter) runIn4(q *packet.Parsed) (r Response, why string) {
 		if !q.IsTCPSyn() {
 			return Accept, "tcp non-syn"
 		}
-		if f.matches4.match(q) {
+		if i := f.matches4.match(q); i >= 0 {
+			f.countRule(f.rules4, i)
 			return Accept, "tcp ok"
 		}
 	case ipproto.UDP, ipproto.SCTP:
@@ -434,13 +458,15 @@ func (f *Filter) runIn4(q *packet.Parsed) (r Response, why string) {
 		if ok {
 			return Accept, "cached"
 		}
-		if f.matches4.match(q) {
+		if i := f.matches4.match(q); i >= 0 {
+			f.countRule(f.rules4, i)
 			return Accept, "ok"
 		}
 	case ipproto.TSMP:
 		return Accept, "tsmp ok"
 	default:
-		if f.matches4.matchProtoAndIPsOnlyIfAllPorts(q) {
+		if i := f.matches4.matchProtoAndIPsOnlyIfAllPorts(q); i >= 0 {
+			f.countRule(f.rules4, i)
 			return Accept, "otherproto ok"
 		}
 		return Drop, "Unknown proto"
@@ -465,8 +491,9 @@ func (f *Filter) runIn6(q *packet.Parsed) (r Response, why string) {
 			//  related to an existing ICMP-Echo, TCP, or UDP
 			//  session.
 			return Accept, "icmp response ok"
-		} else if f.matches6.matchIPsOnly(q) {
+		} else if i := f.matches6.matchIPsOnly(q); i >= 0 {
 			// If any port is open to an IP, allow ICMP to it.
+			f.countRule(f.rules6, i)
 			return Accept, "icmp ok"
 		}
 	case ipproto.TCP:
@@ -481,7 +508,8 @@ func (f *Filter) runIn6(q *packet.Parsed) (r Response, why string) {
 		if q.IPProto == ipproto.TCP && !q.IsTCPSyn() {
 			return Accept, "tcp non-syn"
 		}
-		if f.matches6.match(q) {
+		if i := f.matches6.match(q); i >= 0 {
+			f.countRule(f.rules6, i)
 			return Accept, "tcp ok"
 		}
 	case ipproto.UDP, ipproto.SCTP:
@@ -494,13 +522,15 @@ func (f *Filter) runIn6(q *packet.Parsed) (r Response, why string) {
 		if ok {
 			return Accept, "cached"
 		}
-		if f.matches6.match(q) {
+		if i := f.matches6.match(q); i >= 0 {
+			f.countRule(f.rules6, i)
 			return Accept, "ok"
 		}
 	case ipproto.TSMP:
 		return Accept, "tsmp ok"
 	default:
-		if f.matches6.matchProtoAndIPsOnlyIfAllPorts(q) {
+		if i := f.matches6.matchProtoAndIPsOnlyIfAllPorts(q); i >= 0 {
+			f.countRule(f.rules6, i)
 			return Accept, "otherproto ok"
 		}
 		return Drop, "Unknown proto"
@@ -553,24 +583,20 @@ func (f *Filter) pre(q *packet.Parsed, rf RunFlags, dir direction) Response {
 		return Accept
 	}
 	if len(q.Buffer()) < 20 {
-		f.logRateLimit(rf, q, dir, Drop, "too short")
-		return Drop
+		return f.drop(rf, q, dir, "too short")
 	}
 
 	if q.Dst.Addr().IsMulticast() {
-		f.logRateLimit(rf, q, dir, Drop, "multicast")
-		return Drop
+		return f.drop(rf, q, dir, "multicast")
 	}
 	if q.Dst.Addr().IsLinkLocalUnicast() && q.Dst.Addr() != gcpDNSAddr {
-		f.logRateLimit(rf, q, dir, Drop, "link-local-unicast")
-		return Drop
+		return f.drop(rf, q, dir, "link-local-unicast")
 	}
 
 	switch q.IPProto {
 	case ipproto.Unknown:
 		// Unknown packets are dangerous; always drop them.
-		f.logRateLimit(rf, q, dir, Drop, "unknown")
-		return Drop
+		return f.drop(rf, q, dir, "unknown")
 	case ipproto.Fragment:
 		// Fragments after the first always need to be passed through.
 		// Very small fragments are considered Junk by Parsed.
@@ -581,6 +607,77 @@ func (f *Filter) pre(q *packet.Parsed, rf RunFlags, dir direction) Response {
 	return noVerdict
 }
 
+// drop counts and logs q being dropped for why, and returns Drop.
+func (f *Filter) drop(rf RunFlags, q *packet.Parsed, dir direction, why string) Response {
+	f.countDrop(dir, why)
+	f.logRateLimit(rf, q, dir, Drop, why)
+	return Drop
+}
+
+// dropReasons are the reasons the filter drops packets for, as counted
+// for Stats.
+var dropReasons = [...]string{
+	"too short",
+	"multicast",
+	"link-local-unicast",
+	"unknown",
+	"not-ip",
+	"destination not allowed",
+	"Unknown proto",
+	"no rules matched",
+}
+
+// countRule counts a packet accepted by the match at index i of
+// matches4 or matches6, whose rule indexes are idx.
+func (f *Filter) countRule(idx []int, i int) {
+	f.ruleHits[idx[i]].Add(1)
+}
+
+// countDrop counts a packet dropped in direction dir for why.
+func (f *Filter) countDrop(dir direction, why string) {
+	i := 0
+	for i < len(dropReasons) && dropReasons[i] != why {
+		i++
+	}
+	f.drops[dir][i].Add(1)
+}
+
+// Stats returns how many packets each of the filter's rules accepted,
+// and how many the filter dropped for each reason, since it was
+// created. A rule only counts the packets that start a flow, such as
+// TCP SYNs; later packets are accepted without consulting the rules.
+func (f *Filter) Stats() *filterstats.Stats {
+	st := &filterstats.Stats{
+		Since:     f.created,
+		ShieldsUp: f.shieldsUp,
+		Rules:     make([]filterstats.RuleStats, len(f.rules)),
+	}
+	for i, m := range f.rules {
+		st.Rules[i] = filterstats.RuleStats{
+			Rule:    m.String(),
+			Packets: f.ruleHits[i].Load(),
+		}
+	}
+	for _, dir := range []direction{in, out} {
+		for i := range f.drops[dir] {
+			n := f.drops[dir][i].Load()
+			if n == 0 {
+				continue
+			}
+			reason := "other"
+			if i < len(dropReasons) {
+				reason = dropReasons[i]
+			}
+			st.Drops = append(st.Drops, filterstats.DropStats{
+				Dir:     dir.String(),
+				Reason:  reason,
+				Packets: n,
+			})
+		}
+	}
+	return st
+}
+
 // loggingAllowed reports whether p can appear in logs at all.
 func (f *Filter) loggingAllowed(p *packet.Parsed) bool {
 	return f.logIPs.Contains(p.Src.Addr()) && f.logIPs.Contains(p.Dst.Addr())
@@ -20,6 +20,7 @@
 	"tailscale.com/tailcfg"
 	"tailscale.com/tstest"
 	"tailscale.com/tstime/rate"
+	"tailscale.com/types/filterstats"
 	"tailscale.com/types/ipproto"
 	"tailscale.com/types/logger"
 )
@@ -842,6 +843,47 @@ func TestNewAllowAllForTest(t *testing.T) {
 	}
 }
 
+func TestStats(t *testing.T) {
+	f := newFilter(t.Logf)
+	for _, p := range []packet.Parsed{
+		parsed(ipproto.TCP, "8.1.1.1", "1.2.3.4", 999, 22),
+		parsed(ipproto.TCP, "8.2.2.2", "1.2.3.4", 999, 22),
+		parsed(ipproto.TCP, "::1", "2001::1", 999, 22),
+		parsed(ipproto.TCP, "::3", "2001::5", 999, 443),
+		parsed(ipproto.TCP, "8.1.1.1", "1.2.3.4", 999, 21),
+		parsed(ipproto.TCP, "8.1.1.1", "9.9.9.9", 999, 22),
+	} {
+		f.RunIn(&p, 0)
+	}
+	p := parsed(ipproto.UDP, "1.2.3.4", "224.0.0.1", 999, 5353)
+	f.RunOut(&p, 0)
+
+	st := f.Stats()
+	if len(st.Rules) != len(f.rules) {
+		t.Fatalf("got %d rules; want %d", len(st.Rules), len(f.rules))
+	}
+	if got, want := st.Rules[0].Rule, f.rules[0].String(); got != want {
+		t.Errorf("rule 0 = %q; want %q", got, want)
+	}
+	got := map[int]int64{}
+	for i, r := range st.Rules {
+		if r.Packets != 0 {
+			got[i] = r.Packets
+		}
+	}
+	if want := map[int]int64{0: 2, 7: 1, 8: 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("rule packets = %v; want %v", got, want)
+	}
+	wantDrops := []filterstats.DropStats{
+		{Dir: "in", Reason: "destination not allowed", Packets: 1},
+		{Dir: "in", Reason: "no rules matched", Packets: 1},
+		{Dir: "out", Reason: "multicast", Packets: 1},
+	}
+	if !reflect.DeepEqual(st.Drops, wantDrops) {
+		t.Errorf("drops = %+v; want %+v", st.Drops, wantDrops)
+	}
+}
+
 func TestMatchesMatchProtoAndIPsOnlyIfAllPorts(t *testing.T) {
 	tests := []struct {
 		name string
@@ -871,7 +913,7 @@ func TestMatchesMatchProtoAndIPsOnlyIfAllPorts(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			matches := matches{tt.m}
-			got := matches.matchProtoAndIPsOnlyIfAllPorts(&tt.p)
+			got := matches.matchProtoAndIPsOnlyIfAllPorts(&tt.p) >= 0
 			if got != tt.want {
 				t.Errorf("got = %v; want %v", got, tt.want)
 			}
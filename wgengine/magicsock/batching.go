@@ -0,0 +1,154 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package magicsock
+
+import (
+	"net"
+	"net/netip"
+	"runtime"
+	"sync"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"tailscale.com/net/netaddr"
+	"tailscale.com/util/clientmetric"
+)
+
+const (
+	// defaultUDPBatchSize is the maximum number of packets read from a
+	// UDP socket in a single recvmmsg call, unless changed by
+	// Conn.SetBufferLimits.
+	defaultUDPBatchSize = 8
+
+	// MaxRecvBatchSize is the largest batch size SetBufferLimits
+	// accepts.
+	MaxRecvBatchSize = 64
+
+	// maxUDPPayloadSize is the size of each buffer in a udpBatch. It
+	// is large enough to hold any UDP datagram, so that reads never
+	// truncate.
+	maxUDPPayloadSize = 1<<16 - 1
+)
+
+// batchReader is the subset of ipv4.PacketConn and ipv6.PacketConn used
+// for batched reads. ipv4.Message and ipv6.Message are both aliases of
+// the same type, so either satisfies it.
+type batchReader interface {
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+// udpBatch is the reusable state for reading packets from a
+// *net.UDPConn in batches with recvmmsg.
+//
+// Packets are read into the batch's buffers in bulk and then handed
+// out one at a time by next, since wireguard-go's receive funcs
+// consume a single packet per call.
+type udpBatch struct {
+	uc   *net.UDPConn // the conn that br reads from
+	br   batchReader
+	msgs []ipv4.Message
+
+	n int // number of valid entries in msgs from the last read
+	i int // index of the next entry in msgs to hand out
+}
+
+var udpBatchPool = &sync.Pool{
+	New: func() any {
+		return newUDPBatch(defaultUDPBatchSize)
+	},
+}
+
+func newUDPBatch(size int) *udpBatch {
+	msgs := make([]ipv4.Message, size)
+	for i := range msgs {
+		msgs[i].Buffers = [][]byte{make([]byte, maxUDPPayloadSize)}
+	}
+	return &udpBatch{msgs: msgs}
+}
+
+// canBatchUDP reports whether UDP reads should use recvmmsg batching.
+// x/net only implements real batching on Linux; elsewhere ReadBatch
+// reads a single message per call and is no better than ReadFrom.
+func canBatchUDP() bool {
+	return runtime.GOOS == "linux" && !debugDisableUDPBatching
+}
+
+// getUDPBatch returns a udpBatch of size packets, set up to read from
+// uc. Batches of the default size come from the pool.
+func getUDPBatch(uc *net.UDPConn, size int) *udpBatch {
+	var b *udpBatch
+	if size == defaultUDPBatchSize {
+		b = udpBatchPool.Get().(*udpBatch)
+	} else {
+		b = newUDPBatch(size)
+	}
+	metricRecvBatchBytes.Add(int64(size * maxUDPPayloadSize))
+	b.uc = uc
+	b.n, b.i = 0, 0
+	if la, ok := uc.LocalAddr().(*net.UDPAddr); ok && la.IP.To4() == nil && len(la.IP) == net.IPv6len {
+		b.br = ipv6.NewPacketConn(uc)
+	} else {
+		b.br = ipv4.NewPacketConn(uc)
+	}
+	return b
+}
+
+// putUDPBatch releases b, returning it to the pool if it's of the
+// default size. b must not be used afterwards.
+func putUDPBatch(b *udpBatch) {
+	metricRecvBatchBytes.Add(-int64(len(b.msgs) * maxUDPPayloadSize))
+	b.uc = nil
+	b.br = nil
+	b.n, b.i = 0, 0
+	if len(b.msgs) == defaultUDPBatchSize {
+		udpBatchPool.Put(b)
+	}
+}
+
+// drained reports whether every packet read into b has been handed out.
+func (b *udpBatch) drained() bool {
+	return b.i >= b.n
+}
+
+// next copies the next packet in the batch into p, reading a new batch
+// from the socket if the current one has been consumed.
+func (b *udpBatch) next(p []byte) (n int, ipp netip.AddrPort, err error) {
+	for b.i >= b.n {
+		b.i = 0
+		b.n, err = b.br.ReadBatch(b.msgs, 0)
+		if err != nil {
+			b.n = 0
+			return 0, netip.AddrPort{}, err
+		}
+		if b.n > 0 {
+			metricRecvBatches.Add(1)
+			metricRecvBatchPackets.Add(int64(b.n))
+			if b.n == len(b.msgs) {
+				metricRecvBatchFull.Add(1)
+			}
+		}
+	}
+	m := &b.msgs[b.i]
+	b.i++
+	if ua, ok := m.Addr.(*net.UDPAddr); ok {
+		ipp = netaddr.Unmap(ua.AddrPort())
+	}
+	n = copy(p, m.Buffers[0][:m.N])
+	return n, ipp, nil
+}
+
+var (
+	// metricRecvBatches is the number of recvmmsg calls that returned at
+	// least one packet; dividing metricRecvBatchPackets by it gives the
+	// average batch size.
+	metricRecvBatches      = clientmetric.NewCounter("magicsock_recv_batches")
+	metricRecvBatchPackets = clientmetric.NewCounter("magicsock_recv_batch_packets")
+	// metricRecvBatchFull is the number of recvmmsg calls that filled
+	// every buffer in the batch, suggesting the batch size is too small.
+	metricRecvBatchFull = clientmetric.NewCounter("magicsock_recv_batch_full")
+	// metricRecvBatchBytes is the memory held by the buffers of the
+	// batches in use.
+	metricRecvBatchBytes = clientmetric.NewGauge("magicsock_recv_batch_bytes")
+)
@@ -0,0 +1,38 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package magicsock
+
+import (
+	"fmt"
+
+	"tailscale.com/util/clientmetric"
+)
+
+// metricDERPSendQueueLen is the number of packets currently queued for
+// all DERP connections.
+var metricDERPSendQueueLen = clientmetric.NewGauge("magicsock_derp_send_queue_len")
+
+// SetBufferLimits bounds the memory c uses for buffering packets, for
+// low-memory devices. recvBatch is the number of packets read per
+// recvmmsg call, each into its own 64 KiB buffer, on each of the IPv4
+// and IPv6 sockets; 1 disables batching. derpSendQueue is the number of
+// packets queued for each DERP server before further ones are dropped;
+// it applies to DERP connections made afterwards. Zero restores either
+// default.
+func (c *Conn) SetBufferLimits(recvBatch, derpSendQueue int) error {
+	if recvBatch < 0 || recvBatch > MaxRecvBatchSize {
+		return fmt.Errorf("receive batch size %d out of range [0, %d]", recvBatch, MaxRecvBatchSize)
+	}
+	if derpSendQueue < 0 || derpSendQueue > MaxDERPSendQueue {
+		return fmt.Errorf("DERP send queue %d out of range [0, %d]", derpSendQueue, MaxDERPSendQueue)
+	}
+	old4 := c.pconn4.batchSize.Swap(int32(recvBatch))
+	c.pconn6.batchSize.Store(int32(recvBatch))
+	oldDERP := c.derpSendQueue.Swap(int32(derpSendQueue))
+	if old4 != int32(recvBatch) || oldDERP != int32(derpSendQueue) {
+		c.logf("magicsock: buffer limits: receive batch %d, DERP send queue %d (0 is the default)", recvBatch, derpSendQueue)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package magicsock
+
+import (
+	"net/netip"
+	"sort"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/util/mak"
+)
+
+// LocalEndpoints returns the UDP endpoints the Conn last found for
+// itself, as advertised to peers via the control server.
+func (c *Conn) LocalEndpoints() []tailcfg.Endpoint {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]tailcfg.Endpoint(nil), c.lastEndpoints...)
+}
+
+// ImportPeerEndpoints adds eps to the endpoints of the peer with the
+// provided node key, as if the peer had advertised them, replacing
+// those imported before. Empty eps removes them. It's a debugging aid:
+// with a peer's endpoints copied over by hand, the peer can be reached
+// directly even if the control server or call-me-maybe never conveyed
+// them, which tells whether discovery or connectivity is broken.
+//
+// Imported endpoints are pinged along with the others the next time
+// the peer is sent to. They're kept across network map updates, but
+// not across restarts.
+func (c *Conn) ImportPeerEndpoints(pub key.NodePublic, eps []netip.AddrPort) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(eps) == 0 {
+		delete(c.importedEndpoints, pub)
+	} else {
+		mak.Set(&c.importedEndpoints, pub, append([]netip.AddrPort(nil), eps...))
+	}
+	if ep, ok := c.peerMap.endpointForNodeKey(pub); ok {
+		ep.setImportedEndpoints(eps)
+	}
+	c.logf("magicsock: imported endpoints for %v set to %v", pub.ShortString(), eps)
+}
+
+// applyImportedEndpointsLocked adds any endpoints imported for a newly
+// created endpoint. c.mu must be held.
+func (c *Conn) applyImportedEndpointsLocked(de *endpoint) {
+	if eps, ok := c.importedEndpoints[de.publicKey]; ok {
+		de.setImportedEndpoints(eps)
+	}
+}
+
+// setImportedEndpoints sets the endpoints imported for de, dropping
+// those previously imported that aren't otherwise known.
+func (de *endpoint) setImportedEndpoints(eps []netip.AddrPort) {
+	de.mu.Lock()
+	defer de.mu.Unlock()
+
+	want := make(map[netip.AddrPort]bool, len(eps))
+	for _, ep := range eps {
+		want[ep] = true
+	}
+	for ep, st := range de.endpointState {
+		if st.imported && !want[ep] {
+			st.imported = false
+			if st.shouldDeleteLocked() {
+				de.deleteEndpointLocked(ep)
+			}
+		}
+	}
+	for ep := range want {
+		st, ok := de.endpointState[ep]
+		if !ok {
+			st = &endpointState{index: indexSentinelDeleted}
+			de.endpointState[ep] = st
+		}
+		st.imported = true
+	}
+	// Ping them all on the next send, rather than wait for the
+	// current best path to become stale.
+	de.lastFullPing = 0
+}
+
+// importedEndpointsLocked returns the endpoints imported for de, in
+// order. de.mu must be held.
+func (de *endpoint) importedEndpointsLocked() []netip.AddrPort {
+	var ret []netip.AddrPort
+	for ep, st := range de.endpointState {
+		if st.imported {
+			ret = append(ret, ep)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].String() < ret[j].String() })
+	return ret
+}
@@ -12,11 +12,12 @@
 )
 
 // Various debugging and experimental tweakables, set by environment
-// variable.
+// variable. Those that are funcs can also be changed at runtime with
+// envknob.Setenv.
 var (
 	// debugDisco prints verbose logs of active discovery events as
 	// they happen.
-	debugDisco = envknob.Bool("TS_DEBUG_DISCO")
+	debugDisco = envknob.RegisterBool("TS_DEBUG_DISCO")
 	// debugOmitLocalAddresses removes all local interface addresses
 	// from magicsock's discovered local endpoints. Used in some tests.
 	debugOmitLocalAddresses = envknob.Bool("TS_DEBUG_OMIT_LOCAL_ADDRS")
@@ -25,14 +26,29 @@
 	debugUseDerpRoute = envknob.OptBool("TS_DEBUG_ENABLE_DERP_ROUTE")
 	// logDerpVerbose logs all received DERP packets, including their
 	// full payload.
-	logDerpVerbose = envknob.Bool("TS_DEBUG_DERP")
+	logDerpVerbose = envknob.RegisterBool("TS_DEBUG_DERP")
 	// debugReSTUNStopOnIdle unconditionally enables the "shut down
 	// STUN if magicsock is idle" behavior that normally only triggers
 	// on mobile devices, lowers the shutdown interval, and logs more
 	// verbosely about idle measurements.
 	debugReSTUNStopOnIdle = envknob.Bool("TS_DEBUG_RESTUN_STOP_ON_IDLE")
 	// debugAlwaysDERP disables the use of UDP, forcing all peer communication over DERP.
-	debugAlwaysDERP = envknob.Bool("TS_DEBUG_ALWAYS_USE_DERP")
+	debugAlwaysDERP = envknob.RegisterBool("TS_DEBUG_ALWAYS_USE_DERP")
+	// debugDisableUDPBatching disables reading UDP packets in batches
+	// with recvmmsg, falling back to one syscall per packet.
+	debugDisableUDPBatching = envknob.Bool("TS_DEBUG_DISABLE_UDP_BATCHING")
+	// derpReadTimeout and derpWriteTimeout, if non-zero, override
+	// how long reads from and writes to a DERP server may block
+	// before the connection is considered dead.
+	derpReadTimeout, _  = envknob.LookupDuration("TS_DERP_READ_TIMEOUT")
+	derpWriteTimeout, _ = envknob.LookupDuration("TS_DERP_WRITE_TIMEOUT")
+	// pathMinImprovement is the percentage by which a direct address
+	// must be faster than the one in use before switching to it.
+	pathMinImprovement = envknob.RegisterInt("TS_PATH_MIN_IMPROVEMENT_PERCENT")
+	// pathMinDwell is how long a direct address must have been in use
+	// before switching to a faster one, and the least time a peer stays
+	// on DERP after falling back to it before going direct again.
+	pathMinDwell = envknob.RegisterDuration("TS_PATH_MIN_DWELL")
 )
 
 // inTest reports whether the running program is a test that set the
@@ -7,18 +7,29 @@
 
 package magicsock
 
-import "tailscale.com/types/opt"
+import (
+	"time"
+
+	"tailscale.com/types/opt"
+)
 
 // All knobs are disabled on iOS and Wasm.
 // Further, they're const, so the toolchain can produce smaller binaries.
 const (
-	debugDisco                       = false
 	debugOmitLocalAddresses          = false
 	debugUseDerpRouteEnv             = ""
 	debugUseDerpRoute       opt.Bool = ""
-	logDerpVerbose                   = false
 	debugReSTUNStopOnIdle            = false
-	debugAlwaysDERP                  = false
+	debugDisableUDPBatching          = false
+	derpReadTimeout                  = 0
+	derpWriteTimeout                 = 0
 )
 
+func debugDisco() bool      { return false }
+func logDerpVerbose() bool  { return false }
+func debugAlwaysDERP() bool { return false }
+
+func pathMinImprovement() int     { return 0 }
+func pathMinDwell() time.Duration { return 0 }
+
 func inTest() bool { return false }
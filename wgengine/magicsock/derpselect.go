@@ -0,0 +1,151 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package magicsock
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/netcheck"
+	"tailscale.com/tailcfg"
+)
+
+// SetDERPRegionPrefs sets the DERP region to use as home regardless of
+// latency, if home is non-zero, and the regions not to use as home.
+// A pinned region missing from the DERP map is ignored. Peers whose
+// home is an avoided region are still reached through it. If every
+// region is avoided, or none that isn't replied to netcheck, the least
+// bad one is used anyway rather than leaving the node without a home.
+func (c *Conn) SetDERPRegionPrefs(home int, avoid []int) {
+	c.mu.Lock()
+	if c.derpHomePin == home && intsEqual(c.derpAvoid, avoid) {
+		c.mu.Unlock()
+		return
+	}
+	c.derpHomePin = home
+	c.derpAvoid = append([]int(nil), avoid...)
+	c.mu.Unlock()
+
+	c.logf("magicsock: DERP home region pinned to %d, avoiding %v", home, avoid)
+	c.ReSTUN("derp-region-prefs")
+}
+
+// selectHomeDERP returns the home DERP region per report and the DERP
+// region prefs, and why it was selected. It returns 0 if no region
+// qualifies.
+//
+// c.mu must NOT be held.
+func (c *Conn) selectHomeDERP(report *netcheck.Report) (int, string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return selectHomeDERP(c.derpMap, report, c.derpHomePin, c.derpAvoid)
+}
+
+// selectHomeDERP returns the region of dm to use as home given the
+// netcheck report, the pinned region pin (or 0), and the avoided
+// regions, and why it was selected. If only avoided regions replied,
+// it returns the one with the lowest latency. It returns 0 if no region
+// replied.
+func selectHomeDERP(dm *tailcfg.DERPMap, report *netcheck.Report, pin int, avoid []int) (int, string) {
+	if dm == nil {
+		return 0, ""
+	}
+	if pin != 0 {
+		if _, ok := dm.Regions[pin]; ok {
+			return pin, "pinned"
+		}
+	}
+	if pref := report.PreferredDERP; pref != 0 && !intsContain(avoid, pref) {
+		return pref, "lowest latency"
+	}
+	rls := regionLatencies(dm, report, avoid)
+	for _, rl := range rls {
+		if rl.Latency != 0 && !rl.Avoided {
+			return rl.ID, "lowest latency of the regions not avoided"
+		}
+	}
+	if len(rls) > 0 && rls[0].Latency != 0 {
+		return rls[0].ID, "lowest latency; every region that replied is avoided"
+	}
+	return 0, ""
+}
+
+// noteDERPSelection records the latencies in report and reason, why the
+// home DERP region was selected, for DERPSelection.
+//
+// c.mu must NOT be held.
+func (c *Conn) noteDERPSelection(report *netcheck.Report, reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.derpSelection = ipnstate.DERPSelection{
+		Reason:  reason,
+		Pinned:  c.derpHomePin,
+		Avoided: c.derpAvoid,
+		Regions: regionLatencies(c.derpMap, report, c.derpAvoid),
+		AsOf:    time.Now(),
+	}
+	if c.derpHomePin != 0 && reason != "pinned" {
+		c.derpSelection.Reason = fmt.Sprintf("%s (pinned region %d isn't in the DERP map)", reason, c.derpHomePin)
+	}
+}
+
+// DERPSelection returns the home DERP region and how it was selected.
+func (c *Conn) DERPSelection() *ipnstate.DERPSelection {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sel := c.derpSelection
+	sel.Home = c.myDerp
+	sel.Avoided = append([]int(nil), sel.Avoided...)
+	sel.Regions = append([]ipnstate.DERPRegionLatency(nil), sel.Regions...)
+	return &sel
+}
+
+// regionLatencies returns the latency in report of each region of dm,
+// lowest latency first, then those that didn't reply by ID.
+func regionLatencies(dm *tailcfg.DERPMap, report *netcheck.Report, avoid []int) []ipnstate.DERPRegionLatency {
+	if dm == nil {
+		return nil
+	}
+	var ret []ipnstate.DERPRegionLatency
+	for _, id := range dm.RegionIDs() {
+		ret = append(ret, ipnstate.DERPRegionLatency{
+			ID:      id,
+			Code:    dm.Regions[id].RegionCode,
+			Latency: report.RegionLatency[id],
+			Avoided: intsContain(avoid, id),
+		})
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		li, lj := ret[i].Latency, ret[j].Latency
+		if li == 0 || lj == 0 {
+			return li != 0 && lj == 0
+		}
+		return li < lj
+	})
+	return ret
+}
+
+func intsContain(s []int, v int) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+func intsEqual(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
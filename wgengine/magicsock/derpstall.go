@@ -0,0 +1,176 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package magicsock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tailscale.com/derp/derphttp"
+	"tailscale.com/health"
+	"tailscale.com/syncs"
+	"tailscale.com/util/clientmetric"
+)
+
+const (
+	// derpSlowWrite is how long a single write to a DERP server may
+	// block before it counts as a stall.
+	derpSlowWrite = time.Second
+
+	// derpKeepAliveInterval is how often we ping each active DERP
+	// server to measure whether it's acknowledging us promptly.
+	derpKeepAliveInterval = 30 * time.Second
+
+	// derpKeepAliveTimeout is how long we wait for a keep-alive ping
+	// to be acknowledged before treating it as lost.
+	derpKeepAliveTimeout = 5 * time.Second
+
+	// derpSlowKeepAlive is the keep-alive round trip time at or
+	// above which the path to the DERP server is considered congested.
+	derpSlowKeepAlive = 2 * time.Second
+
+	// derpCongestionClearAfter is how long a DERP region must go
+	// without a stall before its congestion warning is cleared.
+	derpCongestionClearAfter = time.Minute
+)
+
+// derpStalls tracks write stalls and keep-alive acknowledgements for
+// a single DERP connection, and raises a health warning while the
+// path to the server appears congested.
+type derpStalls struct {
+	regionID int
+
+	// congested is whether a health warning is currently set. It's
+	// only written with mu held, but read without it on the
+	// per-packet fast path.
+	congested atomic.Bool
+
+	mu        sync.Mutex
+	lastStall time.Time
+	closed    bool
+
+	now func() time.Time // or nil for time.Now
+}
+
+func newDERPStalls(regionID int) *derpStalls {
+	return &derpStalls{regionID: regionID}
+}
+
+func (s *derpStalls) timeNow() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+// noteWrite records that a write to the DERP server took d.
+func (s *derpStalls) noteWrite(d time.Duration) {
+	metricDERPWriteBlockedMS.Add(d.Milliseconds())
+	if d >= derpSlowWrite {
+		metricDERPWriteStalls.Add(1)
+		s.stall(fmt.Sprintf("writes to relay blocked for %v", d.Round(time.Millisecond)))
+		return
+	}
+	s.ok()
+}
+
+// noteKeepAlive records the result of a keep-alive ping to the DERP
+// server that took rtt to complete.
+func (s *derpStalls) noteKeepAlive(rtt time.Duration, err error) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		metricDERPKeepAliveTimeout.Add(1)
+		s.stall(fmt.Sprintf("relay keep-alive not acknowledged within %v", derpKeepAliveTimeout))
+	case err != nil:
+		// Not connected, or the connection failed. That's
+		// reported elsewhere; it's not a sign of congestion.
+	case rtt >= derpSlowKeepAlive:
+		metricDERPKeepAliveAcked.Add(1)
+		s.stall(fmt.Sprintf("relay keep-alive acknowledged after %v", rtt.Round(time.Millisecond)))
+	default:
+		metricDERPKeepAliveAcked.Add(1)
+		s.ok()
+	}
+}
+
+func (s *derpStalls) stall(why string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.lastStall = s.timeNow()
+	if !s.congested.Load() {
+		s.congested.Store(true)
+		health.SetDERPRegionCongested(s.regionID, "relay path congested: "+why)
+	}
+}
+
+func (s *derpStalls) ok() {
+	if !s.congested.Load() {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || !s.congested.Load() || s.timeNow().Sub(s.lastStall) < derpCongestionClearAfter {
+		return
+	}
+	s.congested.Store(false)
+	health.SetDERPRegionCongested(s.regionID, "")
+}
+
+// close clears any health warning and stops further tracking.
+func (s *derpStalls) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.congested.Load() {
+		s.congested.Store(false)
+		health.SetDERPRegionCongested(s.regionID, "")
+	}
+}
+
+// runDerpKeepAlive periodically pings the DERP server behind dc until
+// ctx is done, feeding the results into stalls.
+func (c *Conn) runDerpKeepAlive(ctx context.Context, dc *derphttp.Client, stalls *derpStalls, wg *syncs.WaitGroupChan, startGate <-chan struct{}) {
+	defer wg.Decr()
+	defer stalls.close()
+	select {
+	case <-startGate:
+	case <-ctx.Done():
+		return
+	}
+
+	t := time.NewTicker(derpKeepAliveInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		metricDERPKeepAliveSent.Add(1)
+		pctx, cancel := context.WithTimeout(ctx, derpKeepAliveTimeout)
+		t0 := time.Now()
+		err := dc.Ping(pctx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		stalls.noteKeepAlive(time.Since(t0), err)
+	}
+}
+
+var (
+	metricDERPWriteBlockedMS   = clientmetric.NewCounter("magicsock_derp_write_blocked_ms")
+	metricDERPWriteStalls      = clientmetric.NewCounter("magicsock_derp_write_stalls")
+	metricDERPKeepAliveSent    = clientmetric.NewCounter("magicsock_derp_keepalive_sent")
+	metricDERPKeepAliveAcked   = clientmetric.NewCounter("magicsock_derp_keepalive_acked")
+	metricDERPKeepAliveTimeout = clientmetric.NewCounter("magicsock_derp_keepalive_timeout")
+)
@@ -0,0 +1,47 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package magicsock
+
+import (
+	"context"
+	"strings"
+
+	"tailscale.com/doctor"
+	"tailscale.com/types/logger"
+)
+
+func init() {
+	doctor.Register(doctor.InCategory(doctor.LightweightCheckFunc("magicsock-knobs", runDoctorCheck), doctor.CategoryNetwork))
+}
+
+// runDoctorCheck logs the debug knobs that change how magicsock
+// reaches peers, as they're easily left set by accident.
+func runDoctorCheck(ctx context.Context, logf logger.Logf) error {
+	var set []string
+	if debugAlwaysDERP() {
+		set = append(set, "TS_DEBUG_ALWAYS_USE_DERP")
+	}
+	if debugDisableUDPBatching {
+		set = append(set, "TS_DEBUG_DISABLE_UDP_BATCHING")
+	}
+	if derpReadTimeout != 0 || derpWriteTimeout != 0 {
+		set = append(set, "TS_DERP_READ_TIMEOUT/TS_DERP_WRITE_TIMEOUT")
+	}
+	if pathMinImprovement() != 0 || pathMinDwell() != 0 {
+		set = append(set, "TS_PATH_MIN_IMPROVEMENT_PERCENT/TS_PATH_MIN_DWELL")
+	}
+	if len(set) == 0 {
+		logf("no debug knobs set")
+		return nil
+	}
+	summary := "debug knobs set: " + strings.Join(set, ", ")
+	logf("%s", summary)
+	if debugAlwaysDERP() {
+		doctor.Report(ctx, doctor.SeverityWarning, summary+"; direct connections are disabled", set)
+	} else {
+		doctor.Report(ctx, doctor.SeverityInfo, summary, set)
+	}
+	return nil
+}
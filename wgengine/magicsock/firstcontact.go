@@ -0,0 +1,211 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package magicsock
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tstime/mono"
+	"tailscale.com/types/key"
+)
+
+// firstContactTimeout is how long a first contact trace waits for the
+// first data from the peer before it's abandoned as incomplete.
+const firstContactTimeout = time.Minute
+
+// WireGuard message types, from the first byte of its packets.
+const (
+	wgMsgInitiation = 1
+	wgMsgResponse   = 2
+	wgMsgData       = 4
+)
+
+// Stages of a first contact trace, in the order they usually happen.
+const (
+	stagePeerConfig        = "peer-config"        // peer looked up in the netmap and added to WireGuard
+	stageHandshakeInit     = "handshake-init"     // WireGuard handshake initiation sent
+	stageDiscoPing         = "disco-ping"         // first disco ping sent to a candidate endpoint
+	stageDERPSend          = "derp-send"          // first packet sent via DERP
+	stageHandshakeResponse = "handshake-response" // WireGuard handshake response received
+	stageDirect            = "direct-path"        // a direct path answered a disco ping
+	stageFirstData         = "first-data"         // first WireGuard data packet received
+)
+
+// firstContact is a trace of the setup of a connection to a peer that
+// had no recent traffic, from the first packet sent to it until the
+// first data comes back.
+type firstContact struct {
+	start     time.Time
+	startMono mono.Time
+	stages    []ipnstate.FirstContactStage
+	done      bool // got first data, or timed out
+}
+
+// NotePeerConfigured starts a first contact trace for the peer with
+// node key nk, which the engine has just looked up in the netmap and
+// added to WireGuard's config in response to traffic, taking took.
+func (c *Conn) NotePeerConfigured(nk key.NodePublic, took time.Duration) {
+	c.mu.Lock()
+	de, ok := c.peerMap.endpointForNodeKey(nk)
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+	de.mu.Lock()
+	defer de.mu.Unlock()
+	now := mono.Now()
+	de.startFirstContactLocked(now.Add(-took))
+	de.noteFirstContactLocked(stagePeerConfig, "", now)
+}
+
+// FirstContact returns the most recent first contact trace for the
+// peer with node key nk, if any.
+func (c *Conn) FirstContact(nk key.NodePublic) (*ipnstate.FirstContactTrace, bool) {
+	c.mu.Lock()
+	de, ok := c.peerMap.endpointForNodeKey(nk)
+	c.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	de.mu.Lock()
+	defer de.mu.Unlock()
+	fc := de.firstContact
+	if fc == nil {
+		return nil, false
+	}
+	de.expireFirstContactLocked(mono.Now())
+	ret := &ipnstate.FirstContactTrace{
+		Peer:   nk,
+		Start:  fc.start,
+		Stages: append([]ipnstate.FirstContactStage(nil), fc.stages...),
+	}
+	if n := len(fc.stages); n > 0 && fc.stages[n-1].Name == stageFirstData {
+		ret.Complete = true
+	} else if !fc.done {
+		ret.InProgress = true
+	}
+	return ret, true
+}
+
+// startFirstContactLocked starts a new first contact trace at start,
+// replacing any previous one.
+//
+// de.mu must be held.
+func (de *endpoint) startFirstContactLocked(start mono.Time) {
+	de.firstContact = &firstContact{
+		start:     start.WallTime(),
+		startMono: start,
+	}
+	de.firstContactActive.Store(true)
+}
+
+// noteHandshakeInitLocked records that a WireGuard handshake initiation
+// is being sent at now, starting a trace if the peer had no recent
+// traffic and none is in progress. WireGuard also starts handshakes to
+// rekey established sessions, which aren't traced.
+//
+// de.mu must be held.
+func (de *endpoint) noteHandshakeInitLocked(now mono.Time) {
+	if !de.firstContactActive.Load() {
+		lastRecv := de.lastRecv.LoadAtomic()
+		if !lastRecv.IsZero() && now.Sub(lastRecv) < sessionActiveTimeout {
+			return
+		}
+		de.startFirstContactLocked(now)
+	}
+	de.noteFirstContactLocked(stageHandshakeInit, "", now)
+}
+
+// noteFirstContactLocked records that stage of the first contact trace
+// in progress, if any, was reached at now. Only the first time each
+// stage is reached is recorded.
+//
+// de.mu must be held.
+func (de *endpoint) noteFirstContactLocked(stage, detail string, now mono.Time) {
+	fc := de.firstContact
+	if fc == nil || fc.done || de.expireFirstContactLocked(now) {
+		return
+	}
+	for _, s := range fc.stages {
+		if s.Name == stage {
+			return
+		}
+	}
+	fc.stages = append(fc.stages, ipnstate.FirstContactStage{
+		Name:    stage,
+		Elapsed: now.Sub(fc.startMono),
+		Detail:  detail,
+	})
+	if stage != stageFirstData {
+		return
+	}
+	fc.done = true
+	de.firstContactActive.Store(false)
+	de.c.logf("magicsock: first contact with %v took %v: %s", de.publicKey.ShortString(), now.Sub(fc.startMono).Round(time.Millisecond), fc.summary())
+}
+
+// expireFirstContactLocked ends the first contact trace in progress if
+// it's been waiting for the first data for longer than
+// firstContactTimeout, and reports whether it did.
+//
+// de.mu must be held.
+func (de *endpoint) expireFirstContactLocked(now mono.Time) bool {
+	fc := de.firstContact
+	if fc == nil || fc.done || now.Sub(fc.startMono) < firstContactTimeout {
+		return false
+	}
+	fc.done = true
+	de.firstContactActive.Store(false)
+	de.c.logf("magicsock: first contact with %v incomplete after %v: %s", de.publicKey.ShortString(), firstContactTimeout, fc.summary())
+	return true
+}
+
+// noteFirstContactRecv records the WireGuard packet b received from
+// src in the first contact trace in progress. It's a no-op if there's
+// none, without taking de.mu.
+func (de *endpoint) noteFirstContactRecv(b []byte, src netip.AddrPort) {
+	if !de.firstContactActive.Load() || len(b) == 0 {
+		return
+	}
+	var stage string
+	switch b[0] {
+	case wgMsgResponse:
+		stage = stageHandshakeResponse
+	case wgMsgData:
+		stage = stageFirstData
+	default:
+		return
+	}
+	de.mu.Lock()
+	defer de.mu.Unlock()
+	de.noteFirstContactLocked(stage, de.c.pathString(src), mono.Now())
+}
+
+// pathString describes the path of a packet from or to ipp, which may
+// be a DERP magic address.
+func (c *Conn) pathString(ipp netip.AddrPort) string {
+	if ipp.Addr() != derpMagicIPAddr {
+		return ipp.String()
+	}
+	if code := c.derpRegionCodeOfIDAtomic(int(ipp.Port())); code != "" {
+		return "DERP(" + code + ")"
+	}
+	return fmt.Sprintf("DERP(%d)", ipp.Port())
+}
+
+func (fc *firstContact) summary() string {
+	var sb strings.Builder
+	for i, s := range fc.stages {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		fmt.Fprintf(&sb, "%s +%v", s.Name, s.Elapsed.Round(time.Millisecond))
+	}
+	return sb.String()
+}
@@ -0,0 +1,179 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package magicsock
+
+import (
+	"net/netip"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tstime/mono"
+	"tailscale.com/util/crashreport"
+)
+
+// maxDERPFallbacks is the number of recent DERP fallback transitions
+// kept for Status.
+const maxDERPFallbacks = 20
+
+// sendPath is the kind of path an endpoint last sent a packet over.
+type sendPath uint8
+
+const (
+	sendPathUnknown sendPath = iota // nothing sent yet
+	sendPathDirect                  // direct UDP, possibly alongside DERP
+	sendPathDERP                    // DERP only, over TCP port 443
+)
+
+// SetForceDERP sets whether all traffic to peers is relayed over DERP,
+// disabling direct UDP paths. It exists to reproduce the behavior of
+// networks that block UDP, on demand.
+func (c *Conn) SetForceDERP(v bool) {
+	if c.forceDERP.Swap(v) == v {
+		return
+	}
+	c.logf("magicsock: force DERP set to %v", v)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.peerMap.forEachEndpoint(func(de *endpoint) {
+		de.mu.Lock()
+		defer de.mu.Unlock()
+		if v {
+			de.bestAddr = addrLatency{}
+			de.trustBestAddrUntil = 0
+		} else {
+			// Start path discovery again on the next send, and don't
+			// hold the peer on DERP as if it had fallen back.
+			de.lastFullPing = 0
+			de.derpSince = 0
+		}
+	})
+}
+
+// checkAlwaysDERP rebinds the UDP sockets in the background if
+// TS_DEBUG_ALWAYS_USE_DERP changed since they were bound, as it can at
+// runtime with envknob.Setenv. While it's set, pingAllowedLocked keeps
+// packets to peers off UDP right away; the rebind then closes the
+// sockets, so that peers' packets stop arriving over UDP too, or binds
+// them again once it's unset.
+func (c *Conn) checkAlwaysDERP() {
+	if debugAlwaysDERP() == c.alwaysDERPBound.Load() || !c.alwaysDERPRebinding.CompareAndSwap(false, true) {
+		return
+	}
+	crashreport.Go(func() {
+		defer c.alwaysDERPRebinding.Store(false)
+		c.mu.Lock()
+		closed := c.closed
+		c.mu.Unlock()
+		if closed {
+			return
+		}
+		c.logf("magicsock: TS_DEBUG_ALWAYS_USE_DERP changed to %v; rebinding", debugAlwaysDERP())
+		c.Rebind()
+		c.ReSTUN("always-derp")
+	})
+}
+
+// noteSendPathLocked records the path of a packet being sent to de,
+// logging and remembering changes between direct UDP and DERP.
+//
+// de.mu must be held.
+func (de *endpoint) noteSendPathLocked(udpAddr, derpAddr netip.AddrPort) {
+	var p sendPath
+	switch {
+	case udpAddr.IsValid():
+		p = sendPathDirect
+	case derpAddr.IsValid():
+		p = sendPathDERP
+	default:
+		return
+	}
+	prev := de.sendPath
+	de.sendPath = p
+	if p == sendPathDirect {
+		de.derpSince = 0
+	} else if prev == sendPathDirect {
+		de.derpSince = mono.Now()
+	}
+	if prev == p || prev == sendPathUnknown && p == sendPathDirect {
+		return
+	}
+
+	c := de.c
+	if prev != sendPathUnknown {
+		c.notePathSwitch(true)
+	}
+	ev := ipnstate.DERPFallback{
+		Time:   time.Now(),
+		Peer:   de.publicKey,
+		Region: c.derpRegionCodeOfIDAtomic(int(de.derpAddr.Port())),
+		Direct: p == sendPathDirect,
+	}
+	if ev.Direct {
+		ev.Addr = udpAddr.String()
+		c.logf("magicsock: %v now direct via %v", de.publicKey.ShortString(), udpAddr)
+	} else {
+		c.logf("magicsock: %v fell back to DERP (%s) over TCP 443", de.publicKey.ShortString(), ev.Region)
+	}
+
+	c.fallbackMu.Lock()
+	defer c.fallbackMu.Unlock()
+	c.fallbacks = append(c.fallbacks, ev)
+	if n := len(c.fallbacks); n > maxDERPFallbacks {
+		c.fallbacks = append(c.fallbacks[:0], c.fallbacks[n-maxDERPFallbacks:]...)
+	}
+}
+
+// derpRegionCodeOfIDAtomic is like derpRegionCodeOfIDLocked, but
+// doesn't require c.mu.
+func (c *Conn) derpRegionCodeOfIDAtomic(regionID int) string {
+	dm := c.derpMapAtomic.Load()
+	if dm == nil {
+		return ""
+	}
+	if r, ok := dm.Regions[regionID]; ok {
+		return r.RegionCode
+	}
+	return ""
+}
+
+// updateDERPStatusLocked adds the ForceDERP setting, the connected DERP
+// regions and the recent DERP fallbacks to sb.
+//
+// c.mu must be held.
+func (c *Conn) updateDERPStatusLocked(sb *ipnstate.StatusBuilder) {
+	now := mono.Now()
+	relayed := map[int]int{}
+	c.peerMap.forEachEndpoint(func(de *endpoint) {
+		de.mu.Lock()
+		defer de.mu.Unlock()
+		if de.lastSend.IsZero() || now.Sub(de.lastSend) >= sessionActiveTimeout {
+			return
+		}
+		if udpAddr, derpAddr := de.addrForSendLocked(now); !udpAddr.IsValid() && derpAddr.IsValid() {
+			relayed[int(derpAddr.Port())]++
+		}
+	})
+
+	var regions []ipnstate.DERPRegionStatus
+	c.foreachActiveDerpSortedLocked(func(regionID int, _ activeDerp) {
+		regions = append(regions, ipnstate.DERPRegionStatus{
+			RegionID:     regionID,
+			RegionCode:   c.derpRegionCodeOfIDLocked(regionID),
+			Home:         regionID == c.myDerp,
+			RelayedPeers: relayed[regionID],
+		})
+	})
+
+	c.fallbackMu.Lock()
+	fallbacks := append([]ipnstate.DERPFallback(nil), c.fallbacks...)
+	c.fallbackMu.Unlock()
+
+	sb.MutateStatus(func(st *ipnstate.Status) {
+		st.ForceDERP = c.forceDERP.Load()
+		st.DERPRegions = regions
+		st.DERPFallbacks = fallbacks
+	})
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package magicsock
+
+import (
+	"time"
+
+	"tailscale.com/tstime/mono"
+	"tailscale.com/types/preftype"
+	"tailscale.com/util/clientmetric"
+)
+
+// maxPathSwitches bounds the number of recent path switch times kept to
+// compute metricPathSwitchesPerHour.
+const maxPathSwitches = 1000
+
+// minDERPDwell is how long an endpoint stays on DERP after falling back
+// to it from a direct path before going direct again, unless
+// TS_PATH_MIN_DWELL asks for longer. A direct path that keeps losing
+// pongs then doesn't bounce the peer between it and DERP every few
+// seconds.
+const minDERPDwell = 10 * time.Second
+
+// wantBestAddrLocked reports whether de should switch its best address
+// to cand, which just answered a ping at now.
+//
+// Switching needs cand to be better, per betterUplinkAddr. Beyond that,
+// while the current best address is still trusted, the
+// TS_PATH_MIN_IMPROVEMENT_PERCENT and TS_PATH_MIN_DWELL knobs can demand
+// that cand be faster by some margin and that the current address have
+// been in use for some time, so networks whose latencies jitter don't
+// make the path flap.
+//
+// de.mu must be held.
+func (de *endpoint) wantBestAddrLocked(cand addrLatency, now mono.Time) bool {
+	cur := de.bestAddr
+	if !de.c.betterUplinkAddr(cand, cur) {
+		return false
+	}
+	if !cur.IsValid() || now.After(de.trustBestAddrUntil) {
+		return true
+	}
+	if de.c.uplinkModeOf(cand.Addr()) == preftype.UplinkPrefer && de.c.uplinkModeOf(cur.Addr()) != preftype.UplinkPrefer {
+		// Moving to a preferred uplink is policy, not churn.
+		return true
+	}
+	if !pastHysteresis(cur, cand, now.Sub(de.bestAddrSince), pathMinImprovement(), pathMinDwell()) {
+		if debugDisco() {
+			de.c.logf("magicsock: disco: node %v %v staying on %v (%v) over %v (%v)", de.publicKey.ShortString(), de.discoShort, cur.AddrPort, cur.latency, cand.AddrPort, cand.latency)
+		}
+		return false
+	}
+	return true
+}
+
+// holdDERPLocked reports whether de should keep sending over DERP only,
+// even though it has a direct address again, because it fell back to
+// DERP less than minDERPDwell (or TS_PATH_MIN_DWELL, if longer) ago.
+// The first switch to a direct path isn't held back.
+//
+// de.mu must be held.
+func (de *endpoint) holdDERPLocked(now mono.Time) bool {
+	if de.derpSince.IsZero() || !de.derpAddr.IsValid() {
+		return false
+	}
+	dwell := minDERPDwell
+	if d := pathMinDwell(); d > dwell {
+		dwell = d
+	}
+	return now.Sub(de.derpSince) < dwell
+}
+
+// pastHysteresis reports whether switching from the address cur, used
+// for dwell, to the better address cand clears the minimum latency
+// improvement of minImprovePct percent and the minimum dwell time of
+// minDwell. Zero or negative values disable either check.
+func pastHysteresis(cur, cand addrLatency, dwell time.Duration, minImprovePct int, minDwell time.Duration) bool {
+	if minDwell > 0 && dwell < minDwell {
+		return false
+	}
+	if minImprovePct > 0 && minImprovePct < 100 {
+		if cand.latency > cur.latency*time.Duration(100-minImprovePct)/100 {
+			return false
+		}
+	}
+	return true
+}
+
+// notePathSwitch records that a peer's path changed, either between
+// direct addresses or between direct and DERP, for the path switch
+// metrics.
+func (c *Conn) notePathSwitch(derp bool) {
+	if derp {
+		metricPathSwitchDERP.Add(1)
+	} else {
+		metricPathSwitchDirect.Add(1)
+	}
+	c.pathSwitchMu.Lock()
+	defer c.pathSwitchMu.Unlock()
+	c.pathSwitches = append(c.pathSwitches, mono.Now())
+	if n := len(c.pathSwitches); n > maxPathSwitches {
+		c.pathSwitches = append(c.pathSwitches[:0], c.pathSwitches[n-maxPathSwitches:]...)
+	}
+	c.updatePathSwitchRateLocked()
+}
+
+// updatePathSwitchRate updates metricPathSwitchesPerHour, so it decays
+// even without new switches.
+func (c *Conn) updatePathSwitchRate() {
+	c.pathSwitchMu.Lock()
+	defer c.pathSwitchMu.Unlock()
+	c.updatePathSwitchRateLocked()
+}
+
+// c.pathSwitchMu must be held.
+func (c *Conn) updatePathSwitchRateLocked() {
+	now := mono.Now()
+	i := 0
+	for i < len(c.pathSwitches) && now.Sub(c.pathSwitches[i]) > time.Hour {
+		i++
+	}
+	c.pathSwitches = append(c.pathSwitches[:0], c.pathSwitches[i:]...)
+	metricPathSwitchesPerHour.Set(int64(len(c.pathSwitches)))
+}
+
+var (
+	// metricPathSwitchDirect counts the number of times a peer's best
+	// direct address changed to another one.
+	metricPathSwitchDirect = clientmetric.NewCounter("magicsock_path_switch_direct")
+
+	// metricPathSwitchDERP counts the number of times a peer's path
+	// changed between direct and DERP, in either direction.
+	metricPathSwitchDERP = clientmetric.NewCounter("magicsock_path_switch_derp")
+
+	// metricPathSwitchesPerHour is the number of path switches of
+	// either kind in the past hour.
+	metricPathSwitchesPerHour = clientmetric.NewGauge("magicsock_path_switches_per_hour")
+)
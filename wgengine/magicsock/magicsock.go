@@ -43,6 +43,7 @@
 	"tailscale.com/net/netcheck"
 	"tailscale.com/net/neterror"
 	"tailscale.com/net/netns"
+	"tailscale.com/net/pktpath"
 	"tailscale.com/net/portmapper"
 	"tailscale.com/net/stun"
 	"tailscale.com/net/tsaddr"
@@ -54,9 +55,13 @@
 	"tailscale.com/types/logger"
 	"tailscale.com/types/netmap"
 	"tailscale.com/types/nettype"
+	"tailscale.com/types/preftype"
 	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/crashreport"
+	"tailscale.com/util/latencyhist"
 	"tailscale.com/util/mak"
 	"tailscale.com/util/uniq"
+	"tailscale.com/util/watchdog"
 	"tailscale.com/version"
 	"tailscale.com/wgengine/monitor"
 )
@@ -71,6 +76,11 @@
 	discoMagic2 = 0x92ac
 )
 
+// wedgeTimeout is how long a DERP reader, a receive func or a raw disco
+// reader may be stuck handling a packet before the watchdog restarts
+// or reports it.
+const wedgeTimeout = time.Minute
+
 // useDerpRoute reports whether magicsock should enable the DERP
 // return path optimization (Issue 150).
 func useDerpRoute() bool {
@@ -329,6 +339,62 @@ type Conn struct {
 	// port is the preferred port from opts.Port; 0 means auto.
 	port atomic.Uint32
 
+	// portRange restricts which local ports the UDP sockets are bound
+	// to. See SetPortRange.
+	portRange syncs.AtomicValue[preftype.PortRange]
+
+	// forceDERP is whether direct UDP paths to peers are disabled, so
+	// all traffic goes over DERP. See SetForceDERP.
+	forceDERP atomic.Bool
+
+	// alwaysDERPBound is whether TS_DEBUG_ALWAYS_USE_DERP was set
+	// when the UDP sockets were last bound, leaving them unbound, and
+	// alwaysDERPRebinding whether they're being rebound since it
+	// changed. See checkAlwaysDERP.
+	alwaysDERPBound     atomic.Bool
+	alwaysDERPRebinding atomic.Bool
+
+	// uplink is the uplink policy as applied to the current network
+	// interfaces. See SetUplinkPolicy.
+	uplink atomic.Pointer[uplinkState]
+
+	// powerSaver is whether disco heartbeats and periodic STUN are
+	// stretched to save power. See SetPowerSaver.
+	powerSaver atomic.Bool
+
+	// linkExpensive is whether the platform said the current
+	// network link is expensive (metered). See SetLinkExpensive.
+	linkExpensive atomic.Bool
+
+	// heartbeatsSaved and reSTUNsSaved count the disco heartbeats
+	// and periodic STUN runs that power saver mode skipped.
+	heartbeatsSaved atomic.Int64
+	reSTUNsSaved    atomic.Int64
+
+	// derpSendQueue is the number of packets queued for each new DERP
+	// connection before dropping, or zero for
+	// bufferedDerpWritesBeforeDrop. See SetBufferLimits.
+	derpSendQueue atomic.Int32
+
+	// tunMTU is the MTU of the Tailscale interface, for detecting
+	// path MTU black holes, or zero to not detect them. See
+	// SetTunMTU.
+	tunMTU atomic.Int32
+
+	// mtuBlackHoleFunc, if non-nil, is called with each path MTU
+	// black hole found. See SetMTUBlackHoleCallback.
+	mtuBlackHoleFunc syncs.AtomicValue[func(MTUBlackHole)]
+
+	// fallbackMu guards fallbacks. It may be acquired with an
+	// endpoint.mu held.
+	fallbackMu sync.Mutex
+	fallbacks  []ipnstate.DERPFallback // most recent last
+
+	// pathSwitchMu guards pathSwitches. It may be acquired with an
+	// endpoint.mu held.
+	pathSwitchMu sync.Mutex
+	pathSwitches []mono.Time // times of path switches in the past hour, oldest first
+
 	// ============================================================
 	// mu guards all following fields; see userspaceEngine lock
 	// ordering rules against the engine. For derphttp, mu must
@@ -392,6 +458,15 @@ type Conn struct {
 	// discoInfo is the state for an active DiscoKey.
 	discoInfo map[key.DiscoPublic]*discoInfo
 
+	// pathPins are the user-requested path restrictions per peer,
+	// applied to endpoints as they're created. See SetPeerPathPin.
+	pathPins map[key.NodePublic]ipnstate.PathPin
+
+	// importedEndpoints are the endpoints imported per peer for
+	// debugging, applied to endpoints as they're created. See
+	// ImportPeerEndpoints.
+	importedEndpoints map[key.NodePublic][]netip.AddrPort
+
 	// netInfoFunc is a callback that provides a tailcfg.NetInfo when
 	// discovered network conditions change.
 	//
@@ -416,6 +491,13 @@ type Conn struct {
 	activeDerp  map[int]activeDerp // DERP regionID -> connection to a node in that region
 	prevDerp    map[int]*syncs.WaitGroupChan
 
+	// derpHomePin and derpAvoid are the DERP region preferences set
+	// by SetDERPRegionPrefs.
+	derpHomePin int
+	derpAvoid   []int
+	// derpSelection is how the home DERP region was last selected.
+	derpSelection ipnstate.DERPSelection
+
 	// derpRoute contains optional alternate routes to use as an
 	// optimization instead of contacting a peer via their home
 	// DERP connection.  If they sent us a message on a different
@@ -513,6 +595,14 @@ type Options struct {
 	// LinkMonitor is the link monitor to use.
 	// With one, the portmapper won't be used.
 	LinkMonitor *monitor.Mon
+
+	// PacketConn4 and PacketConn6 optionally provide already bound
+	// IPv4 and IPv6 UDP sockets to use instead of binding new ones,
+	// such as those passed by systemd. They're kept across rebinds
+	// unless the port has to change, as whoever passed them may
+	// still have them open, and they're closed by Close.
+	PacketConn4 nettype.PacketConn
+	PacketConn6 nettype.PacketConn
 }
 
 func (o *Options) logf() logger.Logf {
@@ -565,10 +655,13 @@ func NewConn(opts Options) (*Conn, error) {
 	c.testOnlyPacketListener = opts.TestOnlyPacketListener
 	c.noteRecvActivity = opts.NoteRecvActivity
 	c.portMapper = portmapper.NewClient(logger.WithPrefix(c.logf, "portmapper: "), c.onPortMapChanged)
+	c.portMapper.SetRenewalHealthFunc(health.SetPortMapHealth)
 	if opts.LinkMonitor != nil {
 		c.portMapper.SetGatewayLookupFunc(opts.LinkMonitor.GatewayAndSelfIP)
 	}
 	c.linkMon = opts.LinkMonitor
+	c.pconn4.inherited = opts.PacketConn4
+	c.pconn6.inherited = opts.PacketConn6
 
 	if err := c.rebind(keepCurrentPort); err != nil {
 		return nil, err
@@ -582,6 +675,7 @@ func NewConn(opts Options) (*Conn, error) {
 		GetSTUNConn6:        func() netcheck.STUNConn { return &c.pconn6 },
 		SkipExternalNetwork: inTest(),
 		PortMapper:          c.portMapper,
+		CheckUDPPorts:       true,
 	}
 
 	c.ignoreSTUNPackets()
@@ -628,7 +722,7 @@ func (c *Conn) updateEndpoints(why string) {
 		c.wantEndpointsUpdate = ""
 		if !c.closed {
 			if why != "" {
-				go c.updateEndpoints(why)
+				crashreport.Go(func() { c.updateEndpoints(why) })
 				return
 			}
 			if c.shouldDoPeriodicReSTUNLocked() {
@@ -637,6 +731,10 @@ func (c *Conn) updateEndpoints(why string) {
 				// common UDP NAT timeout on Linux,
 				// etc)
 				d := tstime.RandomDurationBetween(20*time.Second, 26*time.Second)
+				if c.powerSaver.Load() {
+					d *= powerSaverReSTUNFactor
+					c.noteReSTUNsSaved()
+				}
 				if t := c.periodicReSTUNTimer; t != nil {
 					if debugReSTUNStopOnIdle {
 						c.logf("resetting existing periodicSTUN to run in %v", d)
@@ -683,6 +781,23 @@ func (c *Conn) updateEndpoints(why string) {
 	}
 }
 
+// stableIPv6Addrs returns the machine's global IPv6 addresses that
+// aren't temporary ones.
+func stableIPv6Addrs() []netip.Addr {
+	ips, _, err := interfaces.LocalAddresses()
+	if err != nil {
+		return nil
+	}
+	tmp, _ := interfaces.TemporaryIPv6Addrs()
+	var ret []netip.Addr
+	for _, ip := range ips {
+		if ip.Is6() && !ip.IsPrivate() && !tmp[ip] {
+			ret = append(ret, ip)
+		}
+	}
+	return ret
+}
+
 // setEndpoints records the new endpoints, reporting whether they're changed.
 // It takes ownership of the slice.
 func (c *Conn) setEndpoints(endpoints []tailcfg.Endpoint) (changed bool) {
@@ -712,7 +827,7 @@ func (c *Conn) setEndpoints(endpoints []tailcfg.Endpoint) (changed bool) {
 
 	c.lastEndpointsTime = time.Now()
 	for de, fn := range c.onEndpointRefreshed {
-		go fn()
+		crashreport.Go(fn)
 		delete(c.onEndpointRefreshed, de)
 	}
 
@@ -783,13 +898,16 @@ func (c *Conn) updateNetInfo(ctx context.Context) (*netcheck.Report, error) {
 	ni.WorkingIPv6.Set(report.IPv6)
 	ni.WorkingUDP.Set(report.UDP)
 	ni.WorkingICMPv4.Set(report.ICMPv4)
-	ni.PreferredDERP = report.PreferredDERP
+	var reason string
+	ni.PreferredDERP, reason = c.selectHomeDERP(report)
 
 	if ni.PreferredDERP == 0 {
 		// Perhaps UDP is blocked. Pick a deterministic but arbitrary
 		// one.
 		ni.PreferredDERP = c.pickDERPFallback()
+		reason = "no latency measurements; picked arbitrarily"
 	}
+	c.noteDERPSelection(report, reason)
 	if !c.setNearestDERP(ni.PreferredDERP) {
 		ni.PreferredDERP = 0
 	}
@@ -815,7 +933,17 @@ func (c *Conn) pickDERPFallback() int {
 	if !c.wantDerpLocked() {
 		return 0
 	}
-	ids := c.derpMap.RegionIDs()
+	var ids []int
+	for _, id := range c.derpMap.RegionIDs() {
+		if !intsContain(c.derpAvoid, id) {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		// All avoided. Better to use one of them than to have no
+		// home at all.
+		ids = c.derpMap.RegionIDs()
+	}
 	if len(ids) == 0 {
 		// No DERP regions in non-nil map.
 		return 0
@@ -832,7 +960,7 @@ func (c *Conn) pickDERPFallback() int {
 	// We used to do the above for legacy clients, but never updated
 	// it for disco.
 
-	if c.myDerp != 0 {
+	if c.myDerp != 0 && intsContain(ids, c.myDerp) {
 		return c.myDerp
 	}
 
@@ -861,7 +989,8 @@ func (c *Conn) callNetInfoCallbackLocked(ni *tailcfg.NetInfo) {
 	c.netInfoLast = ni
 	if c.netInfoFunc != nil {
 		c.logf("[v1] magicsock: netInfo update: %+v", ni)
-		go c.netInfoFunc(ni)
+		fn := c.netInfoFunc
+		crashreport.Go(func() { fn(ni) })
 	}
 }
 
@@ -1013,7 +1142,8 @@ func (c *Conn) setNearestDERP(derpNum int) (wantDERP bool) {
 		c.logf("magicsock: home is now derp-%v (%v)", derpNum, c.derpMap.Regions[derpNum].RegionCode)
 	}
 	for i, ad := range c.activeDerp {
-		go ad.c.NotePreferred(i == c.myDerp)
+		dc, preferred := ad.c, i == c.myDerp
+		crashreport.Go(func() { dc.NotePreferred(preferred) })
 	}
 	c.goDerpConnect(derpNum)
 	return true
@@ -1034,7 +1164,9 @@ func (c *Conn) goDerpConnect(node int) {
 	if node == 0 {
 		return
 	}
-	go c.derpWriteChanOfAddr(netip.AddrPortFrom(derpMagicIPAddr, uint16(node)), key.NodePublic{})
+	crashreport.Go(func() {
+		c.derpWriteChanOfAddr(netip.AddrPortFrom(derpMagicIPAddr, uint16(node)), key.NodePublic{})
+	})
 }
 
 // determineEndpoints returns the machine's endpoint addresses. It
@@ -1107,7 +1239,18 @@ func (c *Conn) determineEndpoints(ctx context.Context) ([]tailcfg.Endpoint, erro
 		}
 	}
 	if nr.GlobalV6 != "" {
-		addAddr(ipp(nr.GlobalV6), tailcfg.EndpointSTUN)
+		gv6 := ipp(nr.GlobalV6)
+		addAddr(gv6, tailcfg.EndpointSTUN)
+		if nr.GlobalV6Temporary {
+			// IPv6 isn't usually NATed, so the port STUN saw likely
+			// works with the stable addresses too, which unlike the
+			// temporary address don't change every time the OS
+			// rotates it. They're extra candidates; the temporary
+			// address is the one known to work.
+			for _, ip := range stableIPv6Addrs() {
+				addAddr(netip.AddrPortFrom(ip, gv6.Port()), tailcfg.EndpointLocal)
+			}
+		}
 	}
 
 	c.ignoreSTUNPackets()
@@ -1201,7 +1344,11 @@ func (c *Conn) Send(b []byte, ep conn.Endpoint) error {
 		metricSendDataNetworkDown.Add(1)
 		return errNetworkDown
 	}
-	return ep.(*endpoint).send(b)
+	c.checkAlwaysDERP()
+	start := pktpath.Start()
+	err := ep.(*endpoint).send(b)
+	pktpath.MagicsockSend.Done(start)
+	return err
 }
 
 var errConnClosed = errors.New("Conn closed")
@@ -1282,6 +1429,7 @@ func (c *Conn) sendAddr(addr netip.AddrPort, pubKey key.NodePublic, b []byte) (s
 		return false, errConnClosed
 	case ch <- derpWriteRequest{addr, pubKey, pkt}:
 		metricSendDERPQueued.Add(1)
+		metricDERPSendQueueLen.Add(1)
 		return true, nil
 	default:
 		metricSendDERPErrorQueue.Add(1)
@@ -1292,11 +1440,15 @@ func (c *Conn) sendAddr(addr netip.AddrPort, pubKey key.NodePublic, b []byte) (s
 
 // bufferedDerpWritesBeforeDrop is how many packets writes can be
 // queued up the DERP client to write on the wire before we start
-// dropping.
+// dropping, unless changed by SetBufferLimits.
 //
 // TODO: this is currently arbitrary. Figure out something better?
 const bufferedDerpWritesBeforeDrop = 32
 
+// MaxDERPSendQueue is the largest DERP send queue SetBufferLimits
+// accepts.
+const MaxDERPSendQueue = 1024
+
 // derpWriteChanOfAddr returns a DERP client for fake UDP addresses that
 // represent DERP servers, creating them as necessary. For real UDP
 // addresses, it returns nil.
@@ -1390,9 +1542,15 @@ func (c *Conn) derpWriteChanOfAddr(addr netip.AddrPort, peer key.NodePublic) cha
 	dc.NotePreferred(c.myDerp == regionID)
 	dc.SetAddressFamilySelector(derpAddrFamSelector{c})
 	dc.DNSCache = dnscache.Get()
+	dc.ReadTimeout = derpReadTimeout
+	dc.WriteTimeout = derpWriteTimeout
 
 	ctx, cancel := context.WithCancel(c.connCtx)
-	ch := make(chan derpWriteRequest, bufferedDerpWritesBeforeDrop)
+	queue := int(c.derpSendQueue.Load())
+	if queue == 0 {
+		queue = bufferedDerpWritesBeforeDrop
+	}
+	ch := make(chan derpWriteRequest, queue)
 
 	ad.c = dc
 	ad.writeCh = ch
@@ -1406,7 +1564,7 @@ func (c *Conn) derpWriteChanOfAddr(addr netip.AddrPort, peer key.NodePublic) cha
 	c.setPeerLastDerpLocked(peer, regionID, regionID)
 	c.scheduleCleanStaleDerpLocked()
 
-	// Build a startGate for the derp reader+writer
+	// Build a startGate for the derp reader+writer+keepalive
 	// goroutines, so they don't start running until any
 	// previous generation is closed.
 	startGate := syncs.ClosedChan()
@@ -1415,21 +1573,23 @@ func (c *Conn) derpWriteChanOfAddr(addr netip.AddrPort, peer key.NodePublic) cha
 	}
 	// And register a WaitGroup(Chan) for this generation.
 	wg := syncs.NewWaitGroupChan()
-	wg.Add(2)
+	wg.Add(3)
 	c.prevDerp[regionID] = wg
 
 	if firstDerp {
 		startGate = c.derpStarted
-		go func() {
+		crashreport.Go(func() {
 			dc.Connect(ctx)
 			close(c.derpStarted)
 			c.muCond.Broadcast()
-		}()
+		})
 	}
 
-	go c.runDerpReader(ctx, addr, dc, wg, startGate)
-	go c.runDerpWriter(ctx, dc, ch, wg, startGate)
-	go c.derpActiveFunc()
+	crashreport.Go(func() { c.runDerpReader(ctx, addr, dc, wg, startGate) })
+	stalls := newDERPStalls(regionID)
+	crashreport.Go(func() { c.runDerpWriter(ctx, dc, ch, stalls, wg, startGate) })
+	crashreport.Go(func() { c.runDerpKeepAlive(ctx, dc, stalls, wg, startGate) })
+	crashreport.Go(c.derpActiveFunc)
 
 	return ad.writeCh
 }
@@ -1513,6 +1673,11 @@ func (c *Conn) runDerpReader(ctx context.Context, derpFakeAddr netip.AddrPort, d
 	defer health.SetDERPRegionConnectedState(regionID, false)
 	defer health.SetDERPRegionHealth(regionID, "")
 
+	hb := watchdog.Register(fmt.Sprintf("derp-%d-reader", regionID), wedgeTimeout, func() {
+		c.restartDerpReader(regionID, dc)
+	})
+	defer hb.Close()
+
 	// peerPresent is the set of senders we know are present on this
 	// connection, based on messages we've received from the server.
 	peerPresent := map[key.NodePublic]bool{}
@@ -1575,7 +1740,7 @@ func (c *Conn) runDerpReader(ctx context.Context, derpFakeAddr netip.AddrPort, d
 			pkt = m
 			res.n = len(m.Data)
 			res.src = m.Source
-			if logDerpVerbose {
+			if logDerpVerbose() {
 				c.logf("magicsock: got derp-%v packet: %q", regionID, m.Data)
 			}
 			// If this is a new sender we hadn't seen before, remember it and
@@ -1590,11 +1755,11 @@ func (c *Conn) runDerpReader(ctx context.Context, derpFakeAddr netip.AddrPort, d
 		case derp.PingMessage:
 			// Best effort reply to the ping.
 			pingData := [8]byte(m)
-			go func() {
+			crashreport.Go(func() {
 				if err := dc.SendPong(pingData); err != nil {
 					c.logf("magicsock: derp-%d SendPong error: %v", regionID, err)
 				}
-			}()
+			})
 			continue
 		case derp.HealthMessage:
 			health.SetDERPRegionHealth(regionID, m.Problem)
@@ -1605,6 +1770,7 @@ func (c *Conn) runDerpReader(ctx context.Context, derpFakeAddr netip.AddrPort, d
 			continue
 		}
 
+		hb.Busy()
 		select {
 		case <-ctx.Done():
 			return
@@ -1615,11 +1781,25 @@ func (c *Conn) runDerpReader(ctx context.Context, derpFakeAddr netip.AddrPort, d
 		case <-ctx.Done():
 			return
 		case <-didCopy:
+			hb.Idle()
 			continue
 		}
 	}
 }
 
+// restartDerpReader closes the connection to DERP region regionID, if
+// it's still dc, whose reader the watchdog found wedged. It reconnects
+// if it's the home region.
+func (c *Conn) restartDerpReader(regionID int, dc *derphttp.Client) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ad, ok := c.activeDerp[regionID]; !ok || ad.c != dc {
+		return
+	}
+	c.closeOrReconectDERPLocked(regionID, "watchdog: reader wedged")
+	c.logActiveDerpLocked()
+}
+
 type derpWriteRequest struct {
 	addr   netip.AddrPort
 	pubKey key.NodePublic
@@ -1628,7 +1808,7 @@ type derpWriteRequest struct {
 
 // runDerpWriter runs in a goroutine for the life of a DERP
 // connection, handling received packets.
-func (c *Conn) runDerpWriter(ctx context.Context, dc *derphttp.Client, ch <-chan derpWriteRequest, wg *syncs.WaitGroupChan, startGate <-chan struct{}) {
+func (c *Conn) runDerpWriter(ctx context.Context, dc *derphttp.Client, ch <-chan derpWriteRequest, stalls *derpStalls, wg *syncs.WaitGroupChan, startGate <-chan struct{}) {
 	defer wg.Decr()
 	select {
 	case <-startGate:
@@ -1636,12 +1816,30 @@ func (c *Conn) runDerpWriter(ctx context.Context, dc *derphttp.Client, ch <-chan
 		return
 	}
 
+	// wasConnected is whether the previous Send succeeded. If it
+	// didn't, the next Send may include (re)dialing the server, so
+	// its duration says nothing about congestion.
+	wasConnected := false
 	for {
 		select {
 		case <-ctx.Done():
-			return
+			// Account for the packets dropped with the queue.
+			for {
+				select {
+				case <-ch:
+					metricDERPSendQueueLen.Add(-1)
+				default:
+					return
+				}
+			}
 		case wr := <-ch:
+			metricDERPSendQueueLen.Add(-1)
+			t0 := time.Now()
 			err := dc.Send(wr.pubKey, wr.b)
+			if wasConnected {
+				stalls.noteWrite(time.Since(t0))
+			}
+			wasConnected = err == nil
 			if err != nil {
 				c.logf("magicsock: derp.Send(%v): %v", wr.addr, err)
 				metricSendDERPError.Add(1)
@@ -1689,7 +1887,8 @@ func (c *Conn) receiveIPv4(b []byte) (n int, ep conn.Endpoint, err error) {
 // ok is whether this read should be reported up to wireguard-go (our
 // caller).
 func (c *Conn) receiveIP(b []byte, ipp netip.AddrPort, cache *ippEndpointCache, checkDisco bool) (ep *endpoint, ok bool) {
-	if stun.Is(b) {
+	defer pktpath.MagicsockRecv.Done(pktpath.Start())
+	if stun.Is(b) || stun.IsQUIC(b) {
 		c.stunReceiveFunc.Load()(b, ipp)
 		return nil, false
 	}
@@ -1723,6 +1922,8 @@ func (c *Conn) receiveIP(b []byte, ipp netip.AddrPort, cache *ippEndpointCache,
 		ep = de
 	}
 	ep.noteRecvActivity()
+	ep.noteFirstContactRecv(b, ipp)
+	ep.noteMTURecv(b)
 	return ep, true
 }
 
@@ -1778,6 +1979,8 @@ func (c *Conn) processDERPReadResult(dm derpReadResult, b []byte) (n int, ep *en
 	}
 
 	ep.noteRecvActivity()
+	ep.noteFirstContactRecv(b[:n], ipp)
+	ep.noteMTURecv(b[:n])
 	return n, ep
 }
 
@@ -1826,7 +2029,7 @@ func (c *Conn) sendDiscoMessage(dst netip.AddrPort, dstKey key.NodePublic, dstDi
 	pkt = append(pkt, box...)
 	sent, err = c.sendAddr(dst, dstKey, pkt)
 	if sent {
-		if logLevel == discoLog || (logLevel == discoVerboseLog && debugDisco) {
+		if logLevel == discoLog || (logLevel == discoVerboseLog && debugDisco()) {
 			node := "?"
 			if !dstKey.IsZero() {
 				node = dstKey.ShortString()
@@ -1890,7 +2093,7 @@ func (c *Conn) handleDiscoMessage(msg []byte, src netip.AddrPort, derpNodeSrc ke
 	if c.closed {
 		return
 	}
-	if debugDisco {
+	if debugDisco() {
 		c.logf("magicsock: disco: got disco-looking frame from %v", sender.ShortString())
 	}
 	if c.privateKey.IsZero() {
@@ -1899,7 +2102,7 @@ func (c *Conn) handleDiscoMessage(msg []byte, src netip.AddrPort, derpNodeSrc ke
 		return
 	}
 	if c.discoPrivate.IsZero() {
-		if debugDisco {
+		if debugDisco() {
 			c.logf("magicsock: disco: ignoring disco-looking frame, no local key")
 		}
 		return
@@ -1907,7 +2110,7 @@ func (c *Conn) handleDiscoMessage(msg []byte, src netip.AddrPort, derpNodeSrc ke
 
 	if !c.peerMap.anyEndpointForDiscoKey(sender) {
 		metricRecvDiscoBadPeer.Add(1)
-		if debugDisco {
+		if debugDisco() {
 			c.logf("magicsock: disco: ignoring disco-looking frame, don't know endpoint for %v", sender.ShortString())
 		}
 		return
@@ -1931,7 +2134,7 @@ func (c *Conn) handleDiscoMessage(msg []byte, src netip.AddrPort, derpNodeSrc ke
 		// Don't log in normal case. Pass on to wireguard, in case
 		// it's actually a wireguard packet (super unlikely,
 		// but).
-		if debugDisco {
+		if debugDisco() {
 			c.logf("magicsock: disco: failed to open naclbox from %v (wrong rcpt?)", sender)
 		}
 		metricRecvDiscoBadKey.Add(1)
@@ -1939,7 +2142,7 @@ func (c *Conn) handleDiscoMessage(msg []byte, src netip.AddrPort, derpNodeSrc ke
 	}
 
 	dm, err := disco.Parse(payload)
-	if debugDisco {
+	if debugDisco() {
 		c.logf("magicsock: disco: disco.Parse = %T, %v", dm, err)
 	}
 	if err != nil {
@@ -2001,7 +2204,7 @@ func (c *Conn) handleDiscoMessage(msg []byte, src netip.AddrPort, derpNodeSrc ke
 			c.discoShort, ep.discoShort,
 			ep.publicKey.ShortString(), derpStr(src.String()),
 			len(dm.MyNumber))
-		go ep.handleCallMeMaybe(dm)
+		crashreport.Go(func() { ep.handleCallMeMaybe(dm) })
 	}
 	return
 }
@@ -2094,7 +2297,7 @@ func (c *Conn) handlePingLocked(dm *disco.Ping, src netip.AddrPort, di *discoInf
 		return
 	}
 
-	if !likelyHeartBeat || debugDisco {
+	if !likelyHeartBeat || debugDisco() {
 		pingNodeSrcStr := dstKey.ShortString()
 		if numNodes > 1 {
 			pingNodeSrcStr = "[one-of-multi]"
@@ -2104,10 +2307,11 @@ func (c *Conn) handlePingLocked(dm *disco.Ping, src netip.AddrPort, di *discoInf
 
 	ipDst := src
 	discoDest := di.discoKey
-	go c.sendDiscoMessage(ipDst, dstKey, discoDest, &disco.Pong{
+	pong := &disco.Pong{
 		TxID: dm.TxID,
 		Src:  src,
-	}, discoVerboseLog)
+	}
+	crashreport.Go(func() { c.sendDiscoMessage(ipDst, dstKey, discoDest, pong, discoVerboseLog) })
 }
 
 // enqueueCallMeMaybe schedules a send of disco.CallMeMaybe to de via derpAddr
@@ -2136,7 +2340,7 @@ func (c *Conn) enqueueCallMeMaybe(derpAddr netip.AddrPort, de *endpoint) {
 		// "full" ReSTUN which may or may not be a full one
 		// (depending on age) and may do HTTPS timing queries
 		// (if UDP is blocked). Good enough for now.
-		go c.ReSTUN("refresh-for-peering")
+		crashreport.Go(func() { c.ReSTUN("refresh-for-peering") })
 		return
 	}
 
@@ -2144,7 +2348,10 @@ func (c *Conn) enqueueCallMeMaybe(derpAddr netip.AddrPort, de *endpoint) {
 	for _, ep := range c.lastEndpoints {
 		eps = append(eps, ep.Addr)
 	}
-	go de.c.sendDiscoMessage(derpAddr, de.publicKey, de.discoKey, &disco.CallMeMaybe{MyNumber: eps}, discoLog)
+	pub, discoKey := de.publicKey, de.discoKey
+	crashreport.Go(func() {
+		de.c.sendDiscoMessage(derpAddr, pub, discoKey, &disco.CallMeMaybe{MyNumber: eps}, discoLog)
+	})
 }
 
 // discoInfoLocked returns the previous or new discoInfo for k.
@@ -2222,7 +2429,7 @@ func (c *Conn) SetPrivateKey(privateKey key.NodePrivate) error {
 	if oldKey.IsZero() {
 		c.everHadKey = true
 		c.logf("magicsock: SetPrivateKey called (init)")
-		go c.ReSTUN("set-private-key")
+		crashreport.Go(func() { c.ReSTUN("set-private-key") })
 	} else if newKey.IsZero() {
 		c.logf("magicsock: SetPrivateKey called (zeroed)")
 		c.closeAllDerpLocked("zero-private-key")
@@ -2269,7 +2476,7 @@ func (c *Conn) UpdatePeers(newPeers map[key.NodePublic]struct{}) {
 	}
 
 	if len(oldPeers) == 0 && len(newPeers) > 0 {
-		go c.ReSTUN("non-zero-peers")
+		crashreport.Go(func() { c.ReSTUN("non-zero-peers") })
 	}
 }
 
@@ -2309,7 +2516,7 @@ func (c *Conn) SetDERPMap(dm *tailcfg.DERPMap) {
 		}
 	}
 
-	go c.ReSTUN("derp-map-update")
+	crashreport.Go(func() { c.ReSTUN("derp-map-update") })
 }
 
 func nodesEqual(x, y []*tailcfg.Node) bool {
@@ -2381,7 +2588,7 @@ func (c *Conn) SetNetworkMap(nm *netmap.NetworkMap) {
 		}
 		ep.wgEndpoint = n.Key.UntypedHexString()
 		ep.initFakeUDPAddr()
-		if debugDisco { // rather than making a new knob
+		if debugDisco() { // rather than making a new knob
 			c.logf("magicsock: created endpoint key=%s: disco=%s; %v", n.Key.ShortString(), n.DiscoKey.ShortString(), logger.ArgWriter(func(w *bufio.Writer) {
 				const derpPrefix = "127.3.3.40:"
 				if strings.HasPrefix(n.DERP, derpPrefix) {
@@ -2407,6 +2614,8 @@ func (c *Conn) SetNetworkMap(nm *netmap.NetworkMap) {
 			}))
 		}
 		ep.updateFromNode(n)
+		c.applyPathPinLocked(ep)
+		c.applyImportedEndpointsLocked(ep)
 		c.peerMap.upsertEndpoint(ep, key.DiscoPublic{})
 	}
 
@@ -2466,7 +2675,7 @@ func (c *Conn) maybeCloseDERPsOnRebind(okayLocalIPs []netip.Prefix) {
 		}
 		regionID := regionID
 		dc := ad.c
-		go func() {
+		crashreport.Go(func() {
 			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 			defer cancel()
 			if err := dc.Ping(ctx); err != nil {
@@ -2476,7 +2685,7 @@ func (c *Conn) maybeCloseDERPsOnRebind(okayLocalIPs []netip.Prefix) {
 				return
 			}
 			c.logf("post-rebind ping of DERP region %d okay", regionID)
-		}()
+		})
 	}
 	c.logActiveDerpLocked()
 }
@@ -2500,7 +2709,8 @@ func (c *Conn) closeOrReconectDERPLocked(regionID int, why string) {
 func (c *Conn) closeDerpLocked(regionID int, why string) {
 	if ad, ok := c.activeDerp[regionID]; ok {
 		c.logf("magicsock: closing connection to derp-%v (%v), age %v", regionID, why, time.Since(ad.createTime).Round(time.Second))
-		go ad.c.Close()
+		dc := ad.c
+		crashreport.Go(func() { dc.Close() })
 		ad.cancel()
 		delete(c.activeDerp, regionID)
 		metricNumDERPConns.Set(int64(len(c.activeDerp)))
@@ -2603,6 +2813,41 @@ func (c *Conn) DERPs() int {
 	return len(c.activeDerp)
 }
 
+// LastNetcheckReport returns a copy of the most recent netcheck
+// report, or nil if none has completed yet.
+func (c *Conn) LastNetcheckReport() *netcheck.Report {
+	return c.lastNetCheckReport.Load().Clone()
+}
+
+// LastEndpoints returns a copy of the endpoints most recently
+// advertised to peers.
+func (c *Conn) LastEndpoints() []tailcfg.Endpoint {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]tailcfg.Endpoint(nil), c.lastEndpoints...)
+}
+
+// RawDiscoListeners returns descriptions of the raw socket disco
+// listeners, such as "ip4" or "ip6 vrf blue". It's empty where disco
+// packets are only received on the regular UDP sockets.
+func (c *Conn) RawDiscoListeners() []string {
+	var ret []string
+	for _, cl := range []io.Closer{c.closeDisco4, c.closeDisco6} {
+		if l, ok := cl.(interface{ listeners() []string }); ok {
+			ret = append(ret, l.listeners()...)
+		}
+	}
+	return ret
+}
+
+// LastNetInfo returns a copy of the most recent NetInfo reported to
+// the SetNetInfoCallback func, or nil if there's none yet.
+func (c *Conn) LastNetInfo() *tailcfg.NetInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.netInfoLast.Clone()
+}
+
 // Bind returns the wireguard-go conn.Bind for c.
 func (c *Conn) Bind() conn.Bind {
 	return c.bind
@@ -2617,6 +2862,7 @@ type connBind struct {
 	*Conn
 	mu     sync.Mutex
 	closed bool
+	hbs    []*watchdog.Heartbeat // of the receive funcs, while open
 }
 
 // Open is called by WireGuard to create a UDP binding.
@@ -2630,14 +2876,37 @@ func (c *connBind) Open(ignoredPort uint16) ([]conn.ReceiveFunc, uint16, error)
 	}
 	c.closed = false
 	fns := []conn.ReceiveFunc{c.receiveIPv4, c.receiveIPv6, c.receiveDERP}
+	names := []string{"receive-ipv4", "receive-ipv6", "receive-derp"}
 	if runtime.GOOS == "js" {
 		fns = []conn.ReceiveFunc{c.receiveDERP}
+		names = names[2:]
+	}
+	for i, fn := range fns {
+		// Only report a wedged receive func. Restarting it would
+		// take reopening the Bind, and wireguard-go closes it by
+		// waiting, with the device's net lock held, for the very
+		// goroutine that's stuck.
+		hb := watchdog.Register(names[i], wedgeTimeout, nil)
+		c.hbs = append(c.hbs, hb)
+		fns[i] = watchReceiveFunc(fn, hb)
 	}
 	// TODO: Combine receiveIPv4 and receiveIPv6 and receiveIP into a single
 	// closure that closes over a *RebindingUDPConn?
 	return fns, c.LocalPort(), nil
 }
 
+// watchReceiveFunc returns fn, supervised by hb. The time wireguard-go
+// spends between calls to fn, handling the packet it returned, counts
+// as busy, so that hb sees it wedged if wireguard-go stops calling fn.
+func watchReceiveFunc(fn conn.ReceiveFunc, hb *watchdog.Heartbeat) conn.ReceiveFunc {
+	hb.Busy() // until the first call
+	return func(b []byte) (int, conn.Endpoint, error) {
+		hb.Idle()
+		defer hb.Busy()
+		return fn(b)
+	}
+}
+
 // SetMark is used by wireguard-go to set a mark bit for packets to avoid routing loops.
 // We handle that ourselves elsewhere.
 func (c *connBind) SetMark(value uint32) error {
@@ -2652,6 +2921,10 @@ func (c *connBind) Close() error {
 		return nil
 	}
 	c.closed = true
+	for _, hb := range c.hbs {
+		hb.Close()
+	}
+	c.hbs = nil
 	// Unblock all outstanding receives.
 	c.pconn4.Close()
 	c.pconn6.Close()
@@ -2802,7 +3075,7 @@ func (c *Conn) ReSTUN(why string) {
 		}
 	} else {
 		c.endpointsUpdateActive = true
-		go c.updateEndpoints(why)
+		crashreport.Go(func() { c.updateEndpoints(why) })
 	}
 }
 
@@ -2814,6 +3087,13 @@ func (c *Conn) listenPacket(network string, port uint16) (nettype.PacketConn, er
 	if c.testOnlyPacketListener != nil {
 		return nettype.MakePacketListenerWithNetIP(c.testOnlyPacketListener).ListenPacket(ctx, network, addr)
 	}
+	if s := c.uplink.Load(); s != nil && s.bound != "" {
+		pconn, err := nettype.MakePacketListenerWithNetIP(netns.ListenerBoundTo(c.logf, s.bound, s.boundIndex)).ListenPacket(ctx, network, addr)
+		if err == nil {
+			return pconn, nil
+		}
+		c.logf("magicsock: binding %v to interface %q failed, using all interfaces: %v", network, s.bound, err)
+	}
 	return nettype.MakePacketListenerWithNetIP(netns.Listener(c.logf)).ListenPacket(ctx, network, addr)
 }
 
@@ -2834,26 +3114,60 @@ func (c *Conn) bindSocket(ruc *RebindingUDPConn, network string, curPortFate cur
 		return nil
 	}
 
-	if debugAlwaysDERP {
+	if debugAlwaysDERP() {
 		c.logf("disabled %v per TS_DEBUG_ALWAYS_USE_DERP", network)
 		ruc.setConnLocked(newBlockForeverConn())
 		return nil
 	}
 
+	// Keep using a socket passed to us unless we have to change
+	// ports or bind to an interface: whoever passed it may still have
+	// it open, in which case binding its port again would fail.
+	if ruc.inherited != nil {
+		why := c.inheritedUnusable(ruc.inherited, curPortFate)
+		if why == "" {
+			if ruc.pconn != ruc.inherited {
+				ruc.closeLocked()
+				c.logf("magicsock: using passed %v socket on %v", network, ruc.inherited.LocalAddr())
+				ruc.setConnLocked(ruc.inherited)
+			}
+			if network == "udp4" {
+				health.SetUDP4Unbound(false)
+			}
+			return nil
+		}
+		c.logf("magicsock: no longer using passed %v socket on %v: %s", network, ruc.inherited.LocalAddr(), why)
+		if ruc.pconn != ruc.inherited {
+			// Otherwise it's closed below, as the current socket.
+			ruc.inherited.Close()
+		}
+		ruc.inherited = nil
+	}
+
 	// Build a list of preferred ports.
 	// Best is the port that the user requested.
 	// Second best is the port that is currently in use.
-	// If those fail, fall back to 0.
+	// If those fail, fall back to 0, or to other ports of the
+	// configured port range if there is one.
+	portRange := c.portRange.Load()
 	var ports []uint16
-	if port := uint16(c.port.Load()); port != 0 {
+	if port := uint16(c.port.Load()); port != 0 && portRange.Contains(port) {
 		ports = append(ports, port)
 	}
 	if ruc.pconn != nil && curPortFate == keepCurrentPort {
 		curPort := uint16(ruc.localAddrLocked().Port)
-		ports = append(ports, curPort)
+		if portRange.Contains(curPort) {
+			ports = append(ports, curPort)
+		}
 	}
-	ports = append(ports, 0)
-	// Remove duplicates. (All duplicates are consecutive.)
+	if portRange.IsZero() {
+		ports = append(ports, 0)
+	} else {
+		ports = append(ports, portsToTry(portRange)...)
+	}
+	// Remove duplicates. (All duplicates are consecutive, except
+	// that a port from portRange may repeat an earlier one, which
+	// only costs a redundant bind attempt.)
 	uniq.ModifySlice(&ports)
 
 	var pconn nettype.PacketConn
@@ -2888,6 +3202,25 @@ func (c *Conn) bindSocket(ruc *RebindingUDPConn, network string, curPortFate cur
 	return fmt.Errorf("failed to bind any ports (tried %v)", ports)
 }
 
+// inheritedUnusable returns why the socket pc passed in Options can't
+// be used any more, or the empty string if it can.
+func (c *Conn) inheritedUnusable(pc nettype.PacketConn, curPortFate currentPortFate) string {
+	if curPortFate == dropCurrentPort {
+		return "port must change"
+	}
+	port := uint16(pc.LocalAddr().(*net.UDPAddr).Port)
+	if want := uint16(c.port.Load()); want != 0 && want != port {
+		return fmt.Sprintf("port %d requested", want)
+	}
+	if r := c.portRange.Load(); !r.Contains(port) {
+		return fmt.Sprintf("port outside range %v", r)
+	}
+	if s := c.uplink.Load(); s != nil && s.bound != "" {
+		return fmt.Sprintf("must bind to interface %q", s.bound)
+	}
+	return ""
+}
+
 type currentPortFate uint8
 
 const (
@@ -2898,6 +3231,7 @@ func (c *Conn) bindSocket(ruc *RebindingUDPConn, network string, curPortFate cur
 // rebind closes and re-binds the UDP sockets.
 // We consider it successful if we manage to bind the IPv4 socket.
 func (c *Conn) rebind(curPortFate currentPortFate) error {
+	c.alwaysDERPBound.Store(debugAlwaysDERP())
 	if err := c.bindSocket(&c.pconn6, "udp6", curPortFate); err != nil {
 		c.logf("magicsock: Rebind ignoring IPv6 bind failure: %v", err)
 	}
@@ -2912,6 +3246,7 @@ func (c *Conn) rebind(curPortFate currentPortFate) error {
 // It should be followed by a call to ReSTUN.
 func (c *Conn) Rebind() {
 	metricRebindCalls.Add(1)
+	c.updateUplinkState(c.uplinkRules())
 	if err := c.rebind(keepCurrentPort); err != nil {
 		c.logf("%w", err)
 		return
@@ -2926,6 +3261,7 @@ func (c *Conn) Rebind() {
 	}
 
 	c.maybeCloseDERPsOnRebind(ifIPs)
+	c.updateRawDisco()
 	c.resetEndpointStates()
 }
 
@@ -2992,6 +3328,20 @@ type RebindingUDPConn struct {
 	mu    sync.Mutex // held while changing pconn (and pconnAtomic)
 	pconn nettype.PacketConn
 	port  uint16
+
+	// inherited, if non-nil, is the socket passed in Options to use
+	// instead of binding one. See bindSocket.
+	inherited nettype.PacketConn
+
+	// batchMu guards batch, the recvmmsg state used by ReadFromNetaddr
+	// when canBatchUDP reports true. It is only contended if there are
+	// concurrent readers, which magicsock doesn't have.
+	batchMu sync.Mutex
+	batch   *udpBatch // nil until the first batched read
+
+	// batchSize is the number of packets to read per recvmmsg call,
+	// or zero for defaultUDPBatchSize. 1 disables batching.
+	batchSize atomic.Int32
 }
 
 func (c *RebindingUDPConn) setConnLocked(p nettype.PacketConn) {
@@ -3035,7 +3385,11 @@ func (c *RebindingUDPConn) ReadFromNetaddr(b []byte) (n int, ipp netip.AddrPort,
 		// This lets us avoid allocations by calling ReadFromUDPAddrPort.
 		// The non-*net.UDPConn case works, but it allocates.
 		if udpConn, ok := pconn.(*net.UDPConn); ok {
-			n, ipp, err = udpConn.ReadFromUDPAddrPort(b)
+			if canBatchUDP() {
+				n, ipp, err = c.readFromBatch(udpConn, b)
+			} else {
+				n, ipp, err = udpConn.ReadFromUDPAddrPort(b)
+			}
 		} else {
 			var addr net.Addr
 			n, addr, err = pconn.ReadFrom(b)
@@ -3059,6 +3413,36 @@ func (c *RebindingUDPConn) ReadFromNetaddr(b []byte) (n int, ipp netip.AddrPort,
 	}
 }
 
+// readFromBatch reads the next packet from uc into b, using recvmmsg to
+// read several packets per syscall when more than one is queued.
+func (c *RebindingUDPConn) readFromBatch(uc *net.UDPConn, b []byte) (n int, ipp netip.AddrPort, err error) {
+	c.batchMu.Lock()
+	defer c.batchMu.Unlock()
+	if c.batch != nil && c.batch.uc != uc {
+		// Rebound since the last read; any packets still buffered
+		// belong to the old socket and are dropped, as they would
+		// have been had they still been queued in the kernel.
+		putUDPBatch(c.batch)
+		c.batch = nil
+	}
+	size := int(c.batchSize.Load())
+	if size == 0 {
+		size = defaultUDPBatchSize
+	}
+	if c.batch != nil && len(c.batch.msgs) != size && c.batch.drained() {
+		// Resized by SetBufferLimits.
+		putUDPBatch(c.batch)
+		c.batch = nil
+	}
+	if c.batch == nil {
+		if size == 1 {
+			return uc.ReadFromUDPAddrPort(b)
+		}
+		c.batch = getUDPBatch(uc, size)
+	}
+	return c.batch.next(b)
+}
+
 func (c *RebindingUDPConn) Port() uint16 {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -3265,6 +3649,11 @@ func (c *Conn) UpdateStatus(sb *ipnstate.StatusBuilder) {
 		// TODO(bradfitz): add to ipnstate.StatusBuilder
 		//f("<li><b>derp-%v</b>: cr%v,wr%v</li>", node, simpleDur(now.Sub(ad.createTime)), simpleDur(now.Sub(*ad.lastWrite)))
 	})
+
+	c.updateDERPStatusLocked(sb)
+	c.updatePathSwitchRate()
+	c.updateUplinkStatus(sb)
+	c.updatePortStatus(sb)
 }
 
 func ippDebugString(ua netip.AddrPort) string {
@@ -3288,6 +3677,8 @@ type endpoint struct {
 	fakeWGAddr netip.AddrPort // the UDP address we tell wireguard-go we're using
 	wgEndpoint string         // string from ParseEndpoint, holds a JSON-serialized wgcfg.Endpoints
 
+	rtts latencyhist.Histogram // round-trip times of disco pings over bestAddr, or DERP without one; has its own lock
+
 	// mu protects all following fields.
 	mu sync.Mutex // Lock ordering: Conn.mu, then endpoint.mu
 
@@ -3297,16 +3688,31 @@ type endpoint struct {
 	heartBeatTimer *time.Timer    // nil when idle
 	lastSend       mono.Time      // last time there was outgoing packets sent to this peer (from wireguard-go)
 	lastFullPing   mono.Time      // last time we pinged all endpoints
+	lastDiscoPing  mono.Time      // last time we sent a disco ping to any endpoint
+	lastDiscoPong  mono.Time      // last time we got a disco pong
 	derpAddr       netip.AddrPort // fallback/bootstrap path, if non-zero (non-zero for well-behaved clients)
 
 	bestAddr           addrLatency // best non-DERP path; zero if none
 	bestAddrAt         mono.Time   // time best address re-confirmed
+	bestAddrSince      mono.Time   // time best address last changed
 	trustBestAddrUntil mono.Time   // time when bestAddr expires
 	sentPing           map[stun.TxID]sentPing
 	endpointState      map[netip.AddrPort]*endpointState
 	isCallMeMaybeEP    map[netip.AddrPort]bool
 
 	pendingCLIPings []pendingCLIPing // any outstanding "tailscale ping" commands running
+
+	pathPin     ipnstate.PathPin // user restriction on paths; see Conn.SetPeerPathPin
+	pathPinDeny []netip.Prefix   // subnets of pathPin.ForbidInterfaces
+	sendPath    sendPath         // path of the most recent send, for fallback logging
+	derpSince   mono.Time        // when sends last fell back from direct to DERP; zero if direct since
+
+	firstContact       *firstContact // current or most recent first contact trace; nil if none
+	firstContactActive atomic.Bool   // whether firstContact is in progress; readable without mu
+
+	mtuSuspectSends atomic.Int32 // full-size sends over a direct path since data last came back
+	mtuProbe        *mtuProbe    // MTU probe in progress; nil if none
+	lastMTUProbe    mono.Time    // when the last MTU probe started
 }
 
 type pendingCLIPing struct {
@@ -3387,6 +3793,15 @@ type endpointState struct {
 	recentPong  uint16      // index into recentPongs of most recent; older before, wrapped
 
 	index int16 // index in nodecfg.Node.Endpoints; meaningless if lastGotPing non-zero
+
+	// pinned is whether this endpoint was added (or is kept alive)
+	// by a path pin. Pinned endpoints are never deleted.
+	pinned bool
+
+	// imported is whether this endpoint was added (or is kept alive)
+	// by Conn.ImportPeerEndpoints. Like pinned ones, imported
+	// endpoints are never deleted.
+	imported bool
 }
 
 // indexSentinelDeleted is the temporary value that endpointState.index takes while
@@ -3396,6 +3811,8 @@ type endpointState struct {
 // shouldDeleteLocked reports whether we should delete this endpoint.
 func (st *endpointState) shouldDeleteLocked() bool {
 	switch {
+	case st.pinned, st.imported:
+		return false
 	case !st.callMeMaybeTime.IsZero():
 		return false
 	case st.lastGotPing.IsZero():
@@ -3429,6 +3846,7 @@ type sentPing struct {
 	at      mono.Time
 	timer   *time.Timer // timeout timer
 	purpose discoPingPurpose
+	mtu     int // for pingMTUProbe, the MTU it's padded to probe for; zero for the unpadded one
 }
 
 // initFakeUDPAddr populates fakeWGAddr with a globally unique fake UDPAddr.
@@ -3476,7 +3894,7 @@ func (de *endpoint) DstToBytes() []byte  { return packIPPort(de.fakeWGAddr) }
 // As of 2021-08-25, only a few hundred pre-0.100 clients understand
 // DERP but not disco, so this returns false very rarely.
 func (de *endpoint) canP2P() bool {
-	return !de.discoKey.IsZero()
+	return !de.discoKey.IsZero() && !de.pathPin.DERPOnly
 }
 
 // addrForSendLocked returns the address(es) that should be used for
@@ -3486,16 +3904,23 @@ func (de *endpoint) canP2P() bool {
 // de.mu must be held.
 func (de *endpoint) addrForSendLocked(now mono.Time) (udpAddr, derpAddr netip.AddrPort) {
 	udpAddr = de.bestAddr.AddrPort
+	if udpAddr.IsValid() && !de.pathAllowedLocked(udpAddr) {
+		udpAddr = netip.AddrPort{}
+	}
 	if !udpAddr.IsValid() || now.After(de.trustBestAddrUntil) {
 		// We had a bestAddr but it expired so send both to it
 		// and DERP.
 		derpAddr = de.derpAddr
 	}
+	if udpAddr.IsValid() && de.holdDERPLocked(now) {
+		udpAddr, derpAddr = netip.AddrPort{}, de.derpAddr
+	}
 	return
 }
 
-// heartbeat is called every heartbeatInterval to keep the best UDP path alive,
-// or kick off discovery of other paths.
+// heartbeat is called every heartbeatInterval (or in power saver mode,
+// powerSaverHeartbeatInterval) to keep the best UDP path alive, or kick
+// off discovery of other paths.
 func (de *endpoint) heartbeat() {
 	de.mu.Lock()
 	defer de.mu.Unlock()
@@ -3523,13 +3948,16 @@ func (de *endpoint) heartbeat() {
 	if udpAddr.IsValid() {
 		// We have a preferred path. Ping that every 2 seconds.
 		de.startPingLocked(udpAddr, now, pingHeartbeat)
+		if de.c.powerSaver.Load() {
+			de.c.noteHeartbeatsSaved()
+		}
 	}
 
 	if de.wantFullPingLocked(now) {
 		de.sendPingsLocked(now, true)
 	}
 
-	de.heartBeatTimer = time.AfterFunc(heartbeatInterval, de.heartbeat)
+	de.heartBeatTimer = time.AfterFunc(de.c.heartbeatPeriod(), de.heartbeat)
 }
 
 // wantFullPingLocked reports whether we should ping to all our peers looking for
@@ -3552,7 +3980,7 @@ func (de *endpoint) wantFullPingLocked(now mono.Time) bool {
 	if de.bestAddr.latency <= goodEnoughLatency {
 		return false
 	}
-	if now.Sub(de.lastFullPing) >= upgradeInterval {
+	if now.Sub(de.lastFullPing) >= de.c.upgradePeriod() {
 		return true
 	}
 	return false
@@ -3561,7 +3989,7 @@ func (de *endpoint) wantFullPingLocked(now mono.Time) bool {
 func (de *endpoint) noteActiveLocked() {
 	de.lastSend = mono.Now()
 	if de.heartBeatTimer == nil && de.canP2P() {
-		de.heartBeatTimer = time.AfterFunc(heartbeatInterval, de.heartbeat)
+		de.heartBeatTimer = time.AfterFunc(de.c.heartbeatPeriod(), de.heartbeat)
 	}
 }
 
@@ -3596,11 +4024,19 @@ func (de *endpoint) send(b []byte) error {
 	now := mono.Now()
 
 	de.mu.Lock()
+	if len(b) > 0 && b[0] == wgMsgInitiation {
+		de.noteHandshakeInitLocked(now)
+	}
 	udpAddr, derpAddr := de.addrForSendLocked(now)
+	if derpAddr.IsValid() && de.firstContactActive.Load() {
+		de.noteFirstContactLocked(stageDERPSend, de.c.pathString(derpAddr), now)
+	}
 	if de.canP2P() && (!udpAddr.IsValid() || now.After(de.trustBestAddrUntil)) {
 		de.sendPingsLocked(now, true)
 	}
 	de.noteActiveLocked()
+	de.noteSendPathLocked(udpAddr, derpAddr)
+	de.noteMTUSendLocked(b, udpAddr, derpAddr, now)
 	de.mu.Unlock()
 
 	if !udpAddr.IsValid() && !derpAddr.IsValid() {
@@ -3626,9 +4062,12 @@ func (de *endpoint) pingTimeout(txid stun.TxID) {
 	if !ok {
 		return
 	}
-	if debugDisco || !de.bestAddr.IsValid() || mono.Now().After(de.trustBestAddrUntil) {
+	if debugDisco() || !de.bestAddr.IsValid() || mono.Now().After(de.trustBestAddrUntil) {
 		de.c.logf("[v1] magicsock: disco: timeout waiting for pong %x from %v (%v, %v)", txid[:6], sp.to, de.publicKey.ShortString(), de.discoShort)
 	}
+	if sp.purpose == pingMTUProbe {
+		de.noteMTUProbeReplyLocked(sp, false)
+	}
 	de.removeSentPingLocked(txid, sp)
 }
 
@@ -3647,19 +4086,24 @@ func (de *endpoint) removeSentPingLocked(txid stun.TxID, sp sentPing) {
 	// In the case of a timer already having fired, this is a no-op:
 	sp.timer.Stop()
 	delete(de.sentPing, txid)
+	if sp.purpose == pingMTUProbe {
+		de.mtuProbePingDoneLocked()
+	}
 }
 
 // sendDiscoPing sends a ping with the provided txid to ep using de's discoKey.
+// If padding is non-zero, the ping is padded with that many bytes.
 //
 // The caller (startPingLocked) should've already recorded the ping in
 // sentPing and set up the timer.
 //
 // The caller should use de.discoKey as the discoKey argument.
 // It is passed in so that sendDiscoPing doesn't need to lock de.mu.
-func (de *endpoint) sendDiscoPing(ep netip.AddrPort, discoKey key.DiscoPublic, txid stun.TxID, logLevel discoLogLevel) {
+func (de *endpoint) sendDiscoPing(ep netip.AddrPort, discoKey key.DiscoPublic, txid stun.TxID, padding int, logLevel discoLogLevel) {
 	sent, _ := de.c.sendDiscoMessage(ep, de.publicKey, discoKey, &disco.Ping{
 		TxID:    [12]byte(txid),
 		NodeKey: de.c.publicKeyAtomic.Load(),
+		Padding: padding,
 	}, logLevel)
 	if !sent {
 		de.forgetPing(txid)
@@ -3682,6 +4126,11 @@ func (de *endpoint) sendDiscoPing(ep netip.AddrPort, discoKey key.DiscoPublic, t
 	// pingCLI means that the user is running "tailscale ping"
 	// from the CLI. These types of pings can go over DERP.
 	pingCLI
+
+	// pingMTUProbe means that the purpose of a ping was to find
+	// whether packets of a given size make it over a direct path.
+	// See startMTUProbeLocked.
+	pingMTUProbe
 )
 
 func (de *endpoint) startPingLocked(ep netip.AddrPort, now mono.Time, purpose discoPingPurpose) {
@@ -3700,7 +4149,11 @@ func (de *endpoint) startPingLocked(ep netip.AddrPort, now mono.Time, purpose di
 			return
 		}
 		st.lastPing = now
+		if de.firstContactActive.Load() {
+			de.noteFirstContactLocked(stageDiscoPing, ep.String(), now)
+		}
 	}
+	de.lastDiscoPing = now
 
 	txid := stun.NewTxID()
 	de.sentPing[txid] = sentPing{
@@ -3713,7 +4166,8 @@ func (de *endpoint) startPingLocked(ep netip.AddrPort, now mono.Time, purpose di
 	if purpose == pingHeartbeat {
 		logLevel = discoVerboseLog
 	}
-	go de.sendDiscoPing(ep, de.discoKey, txid, logLevel)
+	discoKey := de.discoKey
+	crashreport.Go(func() { de.sendDiscoPing(ep, discoKey, txid, 0, logLevel) })
 }
 
 func (de *endpoint) sendPingsLocked(now mono.Time, sendCallMeMaybe bool) {
@@ -3727,6 +4181,9 @@ func (de *endpoint) sendPingsLocked(now mono.Time, sendCallMeMaybe bool) {
 		if runtime.GOOS == "js" {
 			continue
 		}
+		if !de.pingAllowedLocked(ep) {
+			continue
+		}
 		if !st.lastPing.IsZero() && now.Sub(st.lastPing) < discoPingInterval {
 			continue
 		}
@@ -3747,7 +4204,7 @@ func (de *endpoint) sendPingsLocked(now mono.Time, sendCallMeMaybe bool) {
 		// message to our peer via DERP informing them that we've
 		// sent so our firewall ports are probably open and now
 		// would be a good time for them to connect.
-		go de.c.enqueueCallMeMaybe(derpAddr, de)
+		crashreport.Go(func() { de.c.enqueueCallMeMaybe(derpAddr, de) })
 	}
 }
 
@@ -3861,11 +4318,20 @@ func (de *endpoint) handlePongConnLocked(m *disco.Pong, di *discoInfo, src netip
 		return false
 	}
 	knownTxID = true // for naked returns below
+	if sp.purpose == pingMTUProbe {
+		de.noteMTUProbeReplyLocked(sp, true)
+	}
 	de.removeSentPingLocked(m.TxID, sp)
 	di.setNodeKey(de.publicKey)
 
 	now := mono.Now()
 	latency := now.Sub(sp.at)
+	de.lastDiscoPong = now
+	// Only the path in use counts towards the peer's RTTs; pongs to
+	// pings probing the other candidates would skew them.
+	if sp.to == de.bestAddr.AddrPort || isDerp && !de.bestAddr.IsValid() {
+		de.rtts.Observe(latency)
+	}
 
 	if !isDerp {
 		st, ok := de.endpointState[sp.to]
@@ -3884,7 +4350,7 @@ func (de *endpoint) handlePongConnLocked(m *disco.Pong, di *discoInfo, src netip
 		})
 	}
 
-	if sp.purpose != pingHeartbeat {
+	if sp.purpose != pingHeartbeat && sp.purpose != pingMTUProbe {
 		de.c.logf("[v1] magicsock: disco: %v<-%v (%v, %v)  got pong tx=%x latency=%v pong.src=%v%v", de.c.discoShort, de.discoShort, de.publicKey.ShortString(), src, m.TxID[:6], latency.Round(time.Millisecond), m.Src, logger.ArgWriter(func(bw *bufio.Writer) {
 			if sp.to != src {
 				fmt.Fprintf(bw, " ping.to=%v", sp.to)
@@ -3894,22 +4360,28 @@ func (de *endpoint) handlePongConnLocked(m *disco.Pong, di *discoInfo, src netip
 
 	for _, pp := range de.pendingCLIPings {
 		de.c.populateCLIPingResponseLocked(pp.res, latency, sp.to)
-		go pp.cb(pp.res)
+		cb, res := pp.cb, pp.res
+		crashreport.Go(func() { cb(res) })
 	}
 	de.pendingCLIPings = nil
 
 	// Promote this pong response to our current best address if it's lower latency.
 	// TODO(bradfitz): decide how latency vs. preference order affects decision
-	if !isDerp {
+	if !isDerp && de.pathAllowedLocked(sp.to) {
 		thisPong := addrLatency{sp.to, latency}
-		if betterAddr(thisPong, de.bestAddr) {
+		if de.wantBestAddrLocked(thisPong, now) {
 			de.c.logf("magicsock: disco: node %v %v now using %v", de.publicKey.ShortString(), de.discoShort, sp.to)
+			if de.bestAddr.IsValid() {
+				de.c.notePathSwitch(false)
+			}
+			de.noteFirstContactLocked(stageDirect, sp.to.String(), now)
 			de.bestAddr = thisPong
+			de.bestAddrSince = now
 		}
 		if de.bestAddr.AddrPort == thisPong.AddrPort {
 			de.bestAddr.latency = latency
 			de.bestAddrAt = now
-			de.trustBestAddrUntil = now.Add(trustUDPAddrDuration)
+			de.trustBestAddrUntil = now.Add(de.c.trustUDPAddrPeriod())
 		}
 	}
 	return
@@ -4032,17 +4504,43 @@ func (de *endpoint) populatePeerStatus(ps *ipnstate.PeerStatus) {
 	defer de.mu.Unlock()
 
 	ps.Relay = de.c.derpRegionCodeOfIDLocked(int(de.derpAddr.Port()))
+	if !de.pathPin.IsZero() {
+		pin := de.pathPin
+		ps.PathPin = &pin
+	}
+	ps.ImportedEndpoints = de.importedEndpointsLocked()
+
+	if !de.lastDiscoPing.IsZero() {
+		ps.LastDiscoPing = de.lastDiscoPing.WallTime()
+	}
+	if !de.lastDiscoPong.IsZero() {
+		ps.LastDiscoPong = de.lastDiscoPong.WallTime()
+	}
+
+	now := mono.Now()
+	if de.bestAddr.IsValid() && now.After(de.trustBestAddrUntil) &&
+		de.lastDiscoPing.After(de.lastDiscoPong) && now.Sub(de.lastDiscoPing) > pingTimeoutDuration {
+		// The path expired and the pings sent on it since timed
+		// out. LocalBackend keeps this only if there's no WireGuard
+		// session with the peer; see DisconnectEndpointExpired.
+		ps.DisconnectReason = ipnstate.DisconnectEndpointExpired
+	}
 
 	if de.lastSend.IsZero() {
 		return
 	}
 
-	now := mono.Now()
 	ps.LastWrite = de.lastSend.WallTime()
 	ps.Active = now.Sub(de.lastSend) < sessionActiveTimeout
 
 	if udpAddr, derpAddr := de.addrForSendLocked(now); udpAddr.IsValid() && !derpAddr.IsValid() {
 		ps.CurAddr = udpAddr.String()
+		if udpAddr == de.bestAddr.AddrPort && !de.bestAddrSince.IsZero() {
+			ps.CurAddrSince = de.bestAddrSince.WallTime()
+		}
+		if s := de.c.uplink.Load(); s != nil && len(s.rules) > 0 {
+			ps.Uplink = s.uplinkOf(udpAddr.Addr())
+		}
 	}
 }
 
@@ -4073,10 +4571,13 @@ func (de *endpoint) resetLocked() {
 	de.lastFullPing = 0
 	de.bestAddr = addrLatency{}
 	de.bestAddrAt = 0
+	de.bestAddrSince = 0
 	de.trustBestAddrUntil = 0
+	de.derpSince = 0
 	for _, es := range de.endpointState {
 		es.lastPing = 0
 	}
+	de.mtuProbe = nil
 	for txid, sp := range de.sentPing {
 		de.removeSentPingLocked(txid, sp)
 	}
@@ -15,3 +15,5 @@
 func (c *Conn) listenRawDisco(family string) (io.Closer, error) {
 	return nil, errors.New("raw disco listening not supported on this OS")
 }
+
+func (c *Conn) updateRawDisco() {}
@@ -6,19 +6,25 @@
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/netip"
+	"sync"
+	"syscall"
 	"time"
 	"unsafe"
 
 	"golang.org/x/net/bpf"
 	"golang.org/x/sys/unix"
 	"tailscale.com/envknob"
+	"tailscale.com/net/interfaces"
 	"tailscale.com/types/key"
+	"tailscale.com/util/crashreport"
+	"tailscale.com/util/watchdog"
 )
 
 const (
@@ -123,11 +129,89 @@
 // address family, which must be "ip4" or "ip6", using a raw socket
 // and BPF filter.
 // https://github.com/tailscale/tailscale/issues/3824
+//
+// If the host has VRFs whose packets a raw socket outside them doesn't
+// receive, it also listens in each VRF. Rebind looks for VRFs again,
+// as they can come and go.
 func (c *Conn) listenRawDisco(family string) (io.Closer, error) {
 	if debugDisableRawDisco {
 		return nil, errors.New("raw disco listening disabled by debug flag")
 	}
+	pc, err := openRawDisco(family, "")
+	if err != nil {
+		return nil, err
+	}
+	s := &rawDiscoSet{c: c, family: family, ds: []*rawDisco{c.startRawDisco(family, "", pc)}}
+
+	topo, err := interfaces.GetL3Topology()
+	if err != nil {
+		c.logf("magicsock: can't list VRFs for raw %s disco listeners: %v", family, err)
+		return s, nil
+	}
+	if v, ok := topo.InitNetns.Get(); ok && !v && family == "ip4" {
+		c.logf("[v1] magicsock: running in network namespace %s, not the host's", topo.Netns)
+	}
+	s.syncVRFs(topo, true)
+	return s, nil
+}
+
+// updateRawDisco starts raw disco listeners in the VRFs that appeared
+// since they were last looked for and closes those of the VRFs that are
+// gone.
+func (c *Conn) updateRawDisco() {
+	var sets []*rawDiscoSet
+	for _, cl := range []io.Closer{c.closeDisco4, c.closeDisco6} {
+		if s, ok := cl.(*rawDiscoSet); ok {
+			sets = append(sets, s)
+		}
+	}
+	if len(sets) == 0 {
+		return
+	}
+	topo, err := interfaces.GetL3Topology()
+	if err != nil {
+		c.logf("[v1] magicsock: can't list VRFs for raw disco listeners: %v", err)
+		return
+	}
+	for _, s := range sets {
+		s.syncVRFs(topo, false)
+	}
+}
+
+// rawDiscoAttachment returns which of the raw disco listeners of family
+// receives the disco packets arriving on the members of m.
+//
+// The raw sockets are IP sockets, which receive packets once the bond,
+// bridge or team has passed them up to the IP stack as m's own, so the
+// listener to receive them is the one of m's VRF, whichever member they
+// arrived on. A passive bond member drops what it receives, so nothing
+// is missed there that a listener could catch.
+func rawDiscoAttachment(topo *interfaces.L3Topology, listeners []string, family string, m interfaces.L2Master) string {
+	want := family
+	if vrf := topo.VRFOf(m.Name); vrf != "" && !topo.RawL3mdevAccept {
+		want = family + " vrf " + vrf
+	}
+	for _, l := range listeners {
+		if l == want {
+			return "received on " + m.Name + " by listener " + l
+		}
+	}
+	return "no listener " + want + "; only received if it reaches the UDP socket"
+}
 
+func (c *Conn) startRawDisco(family, vrf string, pc net.PacketConn) *rawDisco {
+	d := &rawDisco{c: c, family: family, vrf: vrf}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.startLocked(pc)
+	return d
+}
+
+// openRawDisco opens and self-tests a raw socket that receives disco
+// packets for the given address family. If vrf is non-empty, the
+// socket is bound to that VRF device, and only receives the packets
+// of the VRF's interfaces.
+func openRawDisco(family, vrf string) (net.PacketConn, error) {
 	var (
 		network  string
 		addr     string
@@ -154,7 +238,19 @@ func (c *Conn) listenRawDisco(family string) (io.Closer, error) {
 		return nil, fmt.Errorf("assembling filter: %w", err)
 	}
 
-	pc, err := net.ListenPacket(network, addr)
+	var lc net.ListenConfig
+	if vrf != "" {
+		lc.Control = func(network, address string, c syscall.RawConn) error {
+			var sockErr error
+			if err := c.Control(func(fd uintptr) {
+				sockErr = unix.BindToDevice(int(fd), vrf)
+			}); err != nil {
+				return err
+			}
+			return sockErr
+		}
+	}
+	pc, err := lc.ListenPacket(context.Background(), network, addr)
 	if err != nil {
 		return nil, fmt.Errorf("creating packet conn: %w", err)
 	}
@@ -163,6 +259,10 @@ func (c *Conn) listenRawDisco(family string) (io.Closer, error) {
 		pc.Close()
 		return nil, fmt.Errorf("installing BPF filter: %w", err)
 	}
+	if vrf != "" {
+		// The self-test's packet over loopback isn't in the VRF.
+		return pc, nil
+	}
 
 	// If all the above succeeds, we should be ready to receive. Just
 	// out of paranoia, check that we do receive a well-formed disco
@@ -183,6 +283,10 @@ func (c *Conn) listenRawDisco(family string) (io.Closer, error) {
 		n, _, err := pc.ReadFrom(buf[:])
 		if err != nil {
 			pc.Close()
+			if lo, lerr := net.InterfaceByName("lo"); lerr == nil && lo.Flags&net.FlagUp == 0 {
+				// As in a freshly created network namespace.
+				return nil, fmt.Errorf("reading during raw disco self-test: %w (loopback interface is down)", err)
+			}
 			return nil, fmt.Errorf("reading during raw disco self-test: %w", err)
 		}
 		if n < udpHeaderSize {
@@ -194,19 +298,201 @@ func (c *Conn) listenRawDisco(family string) (io.Closer, error) {
 		break
 	}
 	pc.SetReadDeadline(time.Time{})
-
-	go c.receiveDisco(pc, family == "ip6")
 	return pc, nil
 }
 
-func (c *Conn) receiveDisco(pc net.PacketConn, isIPV6 bool) {
+// rawDiscoSet is the raw disco listeners of one address family: the
+// one outside any VRF, then one per VRF.
+type rawDiscoSet struct {
+	c      *Conn
+	family string // "ip4" or "ip6"
+
+	mu     sync.Mutex
+	ds     []*rawDisco
+	closed bool
+}
+
+// syncVRFs makes s listen in the VRFs of topo that need a listener of
+// their own, and no others. It logs which listener receives the disco
+// packets of each bond, bridge or team if the listeners changed, or
+// always if logAttachments.
+func (s *rawDiscoSet) syncVRFs(topo *interfaces.L3Topology, logAttachments bool) {
+	var want []string
+	if !topo.RawL3mdevAccept {
+		for _, vrf := range topo.VRFs {
+			want = append(want, vrf.Name)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	c, family := s.c, s.family
+	changed := false
+	have := map[string]bool{}
+	ds := s.ds[:1]
+	for _, d := range s.ds[1:] {
+		if !stringsContain(want, d.vrf) {
+			c.logf("magicsock: VRF %s is gone; closing its raw %s disco listener", d.vrf, family)
+			d.Close()
+			changed = true
+			continue
+		}
+		have[d.vrf] = true
+		ds = append(ds, d)
+	}
+	for _, vrf := range want {
+		if have[vrf] {
+			continue
+		}
+		pc, err := openRawDisco(family, vrf)
+		if err != nil {
+			c.logf("magicsock: raw %s disco listener in VRF %s: %v", family, vrf, err)
+			continue
+		}
+		c.logf("[v1] using BPF disco receiver for %s in VRF %s", family, vrf)
+		ds = append(ds, c.startRawDisco(family, vrf, pc))
+		changed = true
+	}
+	s.ds = ds
+
+	if !changed && !logAttachments {
+		return
+	}
+	listeners := s.listenersLocked()
+	for _, m := range topo.L2Masters {
+		c.logf("[v1] magicsock: raw %s disco for %v: %s", family, m, rawDiscoAttachment(topo, listeners, family, m))
+	}
+}
+
+func (s *rawDiscoSet) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	var ret error
+	for _, d := range s.ds {
+		if err := d.Close(); err != nil && ret == nil {
+			ret = err
+		}
+	}
+	return ret
+}
+
+func (s *rawDiscoSet) listeners() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.listenersLocked()
+}
+
+// s.mu must be held.
+func (s *rawDiscoSet) listenersLocked() []string {
+	ret := make([]string, len(s.ds))
+	for i, d := range s.ds {
+		ret[i] = d.String()
+	}
+	return ret
+}
+
+func stringsContain(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+// rawDisco is a raw socket disco listener for one address family and
+// VRF. The watchdog replaces its socket and reader if the reader wedges
+// or fails.
+type rawDisco struct {
+	c      *Conn
+	family string // "ip4" or "ip6"
+	vrf    string // or empty if not bound to a VRF
+
+	mu     sync.Mutex
+	pc     net.PacketConn // nil if reopening it failed
+	hb     *watchdog.Heartbeat
+	closed bool
+}
+
+// String returns a description of d, such as "ip4" or "ip6 vrf blue".
+func (d *rawDisco) String() string {
+	if d.vrf == "" {
+		return d.family
+	}
+	return d.family + " vrf " + d.vrf
+}
+
+func (d *rawDisco) heartbeatName() string {
+	if d.vrf == "" {
+		return "disco-raw-" + d.family
+	}
+	return "disco-raw-" + d.family + "-vrf-" + d.vrf
+}
+
+// startLocked starts reading disco packets from pc.
+//
+// d.mu must be held.
+func (d *rawDisco) startLocked(pc net.PacketConn) {
+	d.pc = pc
+	d.hb = watchdog.Register(d.heartbeatName(), wedgeTimeout, d.restart)
+	isIPv6, hb := d.family == "ip6", d.hb
+	crashreport.Go(func() { d.c.receiveDisco(pc, isIPv6, hb) })
+}
+
+// restart replaces d's socket and reader.
+func (d *rawDisco) restart() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return
+	}
+	d.hb.Close()
+	if d.pc != nil {
+		d.pc.Close()
+	}
+	pc, err := openRawDisco(d.family, d.vrf)
+	if err != nil {
+		d.c.logf("magicsock: reopening raw %s disco listener: %v", d, err)
+		// Keep a heartbeat that looks wedged, so the watchdog
+		// tries again after its backoff.
+		d.pc = nil
+		d.hb = watchdog.Register(d.heartbeatName(), wedgeTimeout, d.restart)
+		d.hb.Busy()
+		return
+	}
+	d.c.logf("magicsock: reopened raw %s disco listener", d)
+	d.startLocked(pc)
+}
+
+func (d *rawDisco) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return nil
+	}
+	d.closed = true
+	d.hb.Close()
+	if d.pc == nil {
+		return nil
+	}
+	return d.pc.Close()
+}
+
+func (c *Conn) receiveDisco(pc net.PacketConn, isIPV6 bool, hb *watchdog.Heartbeat) {
 	var buf [1500]byte
 	for {
 		n, src, err := pc.ReadFrom(buf[:])
 		if errors.Is(err, net.ErrClosed) {
 			return
 		} else if err != nil {
+			// Leave hb busy, so that the watchdog sees this
+			// reader is gone and restarts it.
 			c.logf("disco raw reader failed: %v", err)
+			hb.Busy()
 			return
 		}
 		if n < udpHeaderSize {
@@ -250,7 +536,9 @@ func (c *Conn) receiveDisco(pc net.PacketConn, isIPV6 bool) {
 			metricRecvDiscoPacketIPv6.Add(1)
 		}
 
+		hb.Busy()
 		c.handleDiscoMessage(buf[udpHeaderSize:n], netip.AddrPortFrom(srcIP, srcPort), key.NodePublic{})
+		hb.Idle()
 	}
 }
 
@@ -19,7 +19,9 @@
 	"net/http/httptest"
 	"net/netip"
 	"os"
+	"reflect"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -33,17 +35,24 @@
 	"tailscale.com/derp"
 	"tailscale.com/derp/derphttp"
 	"tailscale.com/disco"
+	"tailscale.com/envknob"
+	"tailscale.com/health"
 	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/interfaces"
 	"tailscale.com/net/netaddr"
+	"tailscale.com/net/netcheck"
+	"tailscale.com/net/packet"
 	"tailscale.com/net/stun/stuntest"
 	"tailscale.com/net/tstun"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tstest"
 	"tailscale.com/tstest/natlab"
+	"tailscale.com/tstime/mono"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/netmap"
 	"tailscale.com/types/nettype"
+	"tailscale.com/types/preftype"
 	"tailscale.com/util/cibuild"
 	"tailscale.com/util/racebuild"
 	"tailscale.com/wgengine/filter"
@@ -478,6 +487,100 @@ func TestDeviceStartStop(t *testing.T) {
 	dev.Close()
 }
 
+func TestPassedPacketConn(t *testing.T) {
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := NewConn(Options{
+		Logf:        t.Logf,
+		PacketConn4: pc.(*net.UDPConn),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	port := uint16(pc.LocalAddr().(*net.UDPAddr).Port)
+	if got := conn.LocalPort(); got != port {
+		t.Fatalf("LocalPort = %d; want passed socket's %d", got, port)
+	}
+	conn.Rebind()
+	if got := conn.LocalPort(); got != port {
+		t.Fatalf("after Rebind, LocalPort = %d; want passed socket's %d", got, port)
+	}
+	conn.SetPreferredPort(port + 1)
+	if conn.pconn4.inherited != nil {
+		t.Fatal("passed socket still used after the port changed")
+	}
+}
+
+func TestPassedPacketConnOutsidePortRange(t *testing.T) {
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := NewConn(Options{
+		Logf:        t.Logf,
+		PacketConn4: pc.(*net.UDPConn),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	port := uint16(pc.LocalAddr().(*net.UDPAddr).Port)
+	// Store the range without SetPortRange, which would drop the
+	// current port itself, to check that a rebind keeping the current
+	// port doesn't go back to the passed socket.
+	r := preftype.PortRange{First: port + 1, Last: port + 1}
+	if port == 65535 {
+		r = preftype.PortRange{First: port - 1, Last: port - 1}
+	}
+	conn.portRange.Store(r)
+	conn.Rebind()
+	if conn.pconn4.inherited != nil {
+		t.Fatal("passed socket still used outside the port range")
+	}
+	if got := conn.LocalPort(); got == port {
+		t.Fatalf("LocalPort = %d, the passed socket's, outside range %v", got, r)
+	}
+	if _, err := pc.WriteTo([]byte("x"), pc.LocalAddr()); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("passed socket not closed once dropped; WriteTo err = %v", err)
+	}
+}
+
+func TestAlwaysDERPAtRuntime(t *testing.T) {
+	conn, err := NewConn(Options{Logf: t.Logf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.LocalPort() == 0 {
+		t.Fatal("UDP socket not bound")
+	}
+
+	waitPort := func(bound bool) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			conn.checkAlwaysDERP()
+			if (conn.LocalPort() != 0) == bound && !conn.alwaysDERPRebinding.Load() {
+				return
+			}
+		}
+		t.Fatalf("LocalPort = %d; want bound = %v", conn.LocalPort(), bound)
+	}
+
+	if err := envknob.Setenv("TS_DEBUG_ALWAYS_USE_DERP", "true"); err != nil {
+		t.Fatal(err)
+	}
+	defer envknob.Setenv("TS_DEBUG_ALWAYS_USE_DERP", "")
+	waitPort(false)
+
+	envknob.Setenv("TS_DEBUG_ALWAYS_USE_DERP", "")
+	waitPort(true)
+}
+
 // Exercise a code path in sendDiscoMessage if the connection has been closed.
 func TestConnClosed(t *testing.T) {
 	mstun := &natlab.Machine{Name: "stun"}
@@ -830,6 +933,81 @@ func TestActiveDiscovery(t *testing.T) {
 		}
 		testActiveDiscovery(t, n)
 	})
+
+	t.Run("facing_nats_over_slow_internet", func(t *testing.T) {
+		lab := natlab.NewLab()
+		lab.Internet.Latency = 20 * time.Millisecond
+		lab.Internet.Jitter = 10 * time.Millisecond
+		lab.Internet.Seed = 1
+		stun := lab.AddNode("stun", natlab.NodeConfig{})
+		m1 := lab.AddNode("m1", natlab.NodeConfig{Firewall: true, NAT: true})
+		m2 := lab.AddNode("m2", natlab.NodeConfig{Firewall: true, NAT: true})
+
+		n := &devices{
+			m1:     m1.Machine,
+			m1IP:   m1.IP(),
+			m2:     m2.Machine,
+			m2IP:   m2.IP(),
+			stun:   stun.Machine,
+			stunIP: stun.IP(),
+		}
+		testActiveDiscovery(t, n)
+	})
+}
+
+// TestEndpointSelectionNatlab checks that of two direct paths to a
+// peer, over a slow internet and a fast LAN, magicsock settles on the
+// LAN's.
+func TestEndpointSelectionNatlab(t *testing.T) {
+	tstest.ResourceCheck(t)
+
+	lab := natlab.NewLab()
+	lab.Internet.Latency = 50 * time.Millisecond
+	lab.Internet.Seed = 1
+	stun := lab.AddNode("stun", natlab.NodeConfig{})
+	n1 := lab.AddNode("m1", natlab.NodeConfig{})
+	n2 := lab.AddNode("m2", natlab.NodeConfig{})
+	lan := &natlab.Network{
+		Name:    "lan",
+		Prefix4: netip.MustParsePrefix("192.168.77.0/24"),
+	}
+	lanIPs := []netip.Addr{
+		n1.Machine.Attach("eth1", lan).V4(),
+		n2.Machine.Attach("eth1", lan).V4(),
+	}
+
+	logf, closeLogf := logger.LogfCloser(t.Logf)
+	defer closeLogf()
+
+	derpMap, cleanup := runDERPAndStun(t, logf, stun.Machine, stun.IP())
+	defer cleanup()
+
+	m1 := newMagicStack(t, logger.WithPrefix(logf, "conn1: "), n1.Machine, derpMap)
+	defer m1.Close()
+	m2 := newMagicStack(t, logger.WithPrefix(logf, "conn2: "), n2.Machine, derpMap)
+	defer m2.Close()
+	ms := []*magicStack{m1, m2}
+
+	// The nodes only learn their internet endpoints from STUN, so
+	// tell each about the other's LAN one.
+	cleanup = meshStacks(logf, func(idx int, nm *netmap.NetworkMap) {
+		peer := 1 - idx
+		lanEP := netip.AddrPortFrom(lanIPs[peer], ms[peer].conn.LocalPort())
+		nm.Peers[0].Endpoints = append(nm.Peers[0].Endpoints, lanEP.String())
+	}, m1, m2)
+	defer cleanup()
+
+	cleanup = newPinger(t, logf, m1, m2)
+	defer cleanup()
+
+	want := netip.AddrPortFrom(lanIPs[1], m2.conn.LocalPort()).String()
+	var got string
+	for deadline := time.Now().Add(30 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if got = m1.Status().Peer[m2.Public()].CurAddr; got == want {
+			return
+		}
+	}
+	t.Errorf("path from m1 to m2 is %q; want the LAN's, %v", got, want)
 }
 
 type devices struct {
@@ -1648,6 +1826,72 @@ func TestBetterAddr(t *testing.T) {
 
 }
 
+func TestPastHysteresis(t *testing.T) {
+	const ms = time.Millisecond
+	al := func(ipps string, d time.Duration) addrLatency {
+		return addrLatency{netip.MustParseAddrPort(ipps), d}
+	}
+	cur := al("1.2.3.4:555", 100*ms)
+	tests := []struct {
+		name       string
+		cand       addrLatency
+		dwell      time.Duration
+		improvePct int
+		minDwell   time.Duration
+		want       bool
+	}{
+		{"disabled", al("5.6.7.8:555", 99*ms), 0, 0, 0, true},
+		{"small-improvement", al("5.6.7.8:555", 95*ms), time.Hour, 10, 0, false},
+		{"exact-improvement", al("5.6.7.8:555", 90*ms), time.Hour, 10, 0, true},
+		{"big-improvement", al("5.6.7.8:555", 20*ms), time.Hour, 10, 0, true},
+		{"too-soon", al("5.6.7.8:555", 20*ms), 5 * time.Second, 10, time.Minute, false},
+		{"dwelled", al("5.6.7.8:555", 20*ms), time.Minute, 10, time.Minute, true},
+		{"invalid-percent", al("5.6.7.8:555", 99*ms), 0, 100, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := pastHysteresis(cur, tt.cand, tt.dwell, tt.improvePct, tt.minDwell)
+			if got != tt.want {
+				t.Errorf("got %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHoldDERPAfterFallback(t *testing.T) {
+	derp := netip.AddrPortFrom(derpMagicIPAddr, 1)
+	lan := netip.MustParseAddrPort("192.168.1.2:41641")
+	now := mono.Now()
+	de := &endpoint{
+		c:        &Conn{logf: t.Logf},
+		derpAddr: derp,
+	}
+	de.bestAddr = addrLatency{AddrPort: lan}
+	de.trustBestAddrUntil = now.Add(time.Minute)
+
+	check := func(now mono.Time, wantUDP bool) {
+		t.Helper()
+		udpAddr, derpAddr := de.addrForSendLocked(now)
+		if udpAddr.IsValid() != wantUDP || derpAddr.IsValid() == wantUDP {
+			t.Fatalf("addrForSendLocked = %v, %v; want direct = %v", udpAddr, derpAddr, wantUDP)
+		}
+		de.noteSendPathLocked(udpAddr, derpAddr)
+	}
+
+	// Going direct for the first time isn't held back.
+	check(now, true)
+
+	// Losing the direct path falls back to DERP...
+	de.bestAddr = addrLatency{}
+	check(now, false)
+
+	// ... where the peer stays for minDERPDwell, even once the direct
+	// path is back.
+	de.bestAddr = addrLatency{AddrPort: lan}
+	check(now.Add(minDERPDwell/2), false)
+	check(now.Add(minDERPDwell+time.Second), true)
+}
+
 func epStrings(eps []tailcfg.Endpoint) (ret []string) {
 	for _, ep := range eps {
 		ret = append(ret, ep.Addr.String())
@@ -1818,3 +2062,554 @@ func TestDiscoMagicMatches(t *testing.T) {
 		t.Errorf("last 2 bytes of disco magic don't match, got %v want %v", discoMagic2, m2)
 	}
 }
+
+func TestRebindingUDPConnBatchRead(t *testing.T) {
+	uc, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ruc RebindingUDPConn
+	ruc.mu.Lock()
+	ruc.setConnLocked(uc)
+	ruc.mu.Unlock()
+	defer ruc.Close()
+
+	sender, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	want := []string{"one", "two", "three"}
+	for _, s := range want {
+		if _, err := sender.WriteTo([]byte(s), uc.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wantFrom := netaddr.Unmap(sender.LocalAddr().(*net.UDPAddr).AddrPort())
+	buf := make([]byte, 100)
+	for _, s := range want {
+		uc.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, ipp, err := ruc.ReadFromNetaddr(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(buf[:n]); got != s {
+			t.Errorf("got %q; want %q", got, s)
+		}
+		if ipp != wantFrom {
+			t.Errorf("from %v; want %v", ipp, wantFrom)
+		}
+	}
+}
+
+func TestEndpointPathPin(t *testing.T) {
+	lan := netip.MustParseAddrPort("192.168.1.5:41641")
+	wan := netip.MustParseAddrPort("1.2.3.4:41641")
+	derp := netip.AddrPortFrom(derpMagicIPAddr, 1)
+
+	tests := []struct {
+		name    string
+		pin     ipnstate.PathPin
+		deny    []netip.Prefix
+		best    netip.AddrPort
+		wantUDP netip.AddrPort
+	}{
+		{
+			name:    "no-pin",
+			best:    lan,
+			wantUDP: lan,
+		},
+		{
+			name: "derp-only",
+			pin:  ipnstate.PathPin{DERPOnly: true},
+			best: lan,
+		},
+		{
+			name:    "endpoint-match",
+			pin:     ipnstate.PathPin{Endpoint: wan},
+			best:    wan,
+			wantUDP: wan,
+		},
+		{
+			name: "endpoint-mismatch",
+			pin:  ipnstate.PathPin{Endpoint: wan},
+			best: lan,
+		},
+		{
+			name: "forbidden-interface",
+			pin:  ipnstate.PathPin{ForbidInterfaces: []string{"eth0"}},
+			deny: []netip.Prefix{netip.MustParsePrefix("192.168.1.0/24")},
+			best: lan,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := mono.Now()
+			de := &endpoint{
+				c:             &Conn{},
+				derpAddr:      derp,
+				endpointState: map[netip.AddrPort]*endpointState{},
+			}
+			de.bestAddr = addrLatency{AddrPort: tt.best}
+			de.trustBestAddrUntil = now.Add(time.Minute)
+			de.setPathPin(tt.pin, tt.deny)
+
+			udpAddr, derpAddr := de.addrForSendLocked(now)
+			if udpAddr != tt.wantUDP {
+				t.Errorf("udpAddr = %v; want %v", udpAddr, tt.wantUDP)
+			}
+			if wantDERP := !tt.wantUDP.IsValid(); derpAddr.IsValid() != wantDERP {
+				t.Errorf("derpAddr = %v; want DERP used = %v", derpAddr, wantDERP)
+			}
+		})
+	}
+}
+
+func TestEndpointImportedEndpoints(t *testing.T) {
+	nmEP := netip.MustParseAddrPort("1.2.3.4:41641")
+	a := netip.MustParseAddrPort("5.6.7.8:41641")
+	b := netip.MustParseAddrPort("192.168.1.5:41641")
+	n := &tailcfg.Node{Endpoints: []string{nmEP.String()}}
+	de := &endpoint{
+		c:             &Conn{},
+		endpointState: map[netip.AddrPort]*endpointState{},
+	}
+	de.updateFromNode(n)
+	have := func() []netip.AddrPort {
+		var ret []netip.AddrPort
+		for ep := range de.endpointState {
+			ret = append(ret, ep)
+		}
+		sort.Slice(ret, func(i, j int) bool { return ret[i].String() < ret[j].String() })
+		return ret
+	}
+
+	de.setImportedEndpoints([]netip.AddrPort{b, a, nmEP})
+	de.updateFromNode(n) // imported ones survive network map updates
+	if got, want := have(), []netip.AddrPort{nmEP, b, a}; !reflect.DeepEqual(got, want) {
+		t.Errorf("endpoints = %v; want %v", got, want)
+	}
+	if got, want := de.importedEndpointsLocked(), []netip.AddrPort{nmEP, b, a}; !reflect.DeepEqual(got, want) {
+		t.Errorf("imported = %v; want %v", got, want)
+	}
+
+	de.setImportedEndpoints([]netip.AddrPort{a})
+	if got, want := have(), []netip.AddrPort{nmEP, a}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after replacing, endpoints = %v; want %v", got, want)
+	}
+	de.setImportedEndpoints(nil)
+	if got, want := have(), []netip.AddrPort{nmEP}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after removing, endpoints = %v; want %v", got, want)
+	}
+	if got := de.importedEndpointsLocked(); len(got) != 0 {
+		t.Errorf("after removing, imported = %v; want none", got)
+	}
+}
+
+func TestUplinkPolicy(t *testing.T) {
+	st := &interfaces.State{
+		Interface: map[string]interfaces.Interface{
+			"eth0":  {Interface: &net.Interface{Name: "eth0", Index: 2, Flags: net.FlagUp}},
+			"wwan0": {Interface: &net.Interface{Name: "wwan0", Index: 3, Flags: net.FlagUp}},
+			"wlan0": {Interface: &net.Interface{Name: "wlan0", Index: 4}},
+		},
+		InterfaceIPs: map[string][]netip.Prefix{
+			"eth0":  {netip.MustParsePrefix("192.168.1.2/24")},
+			"wwan0": {netip.MustParsePrefix("10.64.0.7/16")},
+		},
+		DefaultRouteInterface: "wwan0",
+	}
+	lan := netip.MustParseAddrPort("192.168.1.5:41641")
+	cell := netip.MustParseAddrPort("10.64.3.3:41641")
+	wan := netip.MustParseAddrPort("1.2.3.4:41641")
+	contains := func(ipps []netip.AddrPort, ipp netip.AddrPort) bool {
+		for _, v := range ipps {
+			if v == ipp {
+				return true
+			}
+		}
+		return false
+	}
+
+	tests := []struct {
+		name      string
+		rules     []preftype.UplinkRule
+		wantBound string
+		wantPing  []netip.AddrPort
+		wantData  []netip.AddrPort
+	}{
+		{
+			name:     "none",
+			wantPing: []netip.AddrPort{lan, cell, wan},
+			wantData: []netip.AddrPort{lan, cell, wan},
+		},
+		{
+			name:     "disco-only-default",
+			rules:    []preftype.UplinkRule{{Interface: "wwan0", Mode: preftype.UplinkDiscoOnly}},
+			wantPing: []netip.AddrPort{lan, cell, wan},
+			wantData: []netip.AddrPort{lan},
+		},
+		{
+			name: "prefer-binds",
+			rules: []preftype.UplinkRule{
+				{Interface: "wlan0", Mode: preftype.UplinkPrefer}, // down
+				{Interface: "eth0", Mode: preftype.UplinkPrefer},
+				{Interface: "wwan0", Mode: preftype.UplinkExclude},
+			},
+			wantBound: "eth0",
+			wantPing:  []netip.AddrPort{lan, wan},
+			wantData:  []netip.AddrPort{lan, wan},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Conn{}
+			c.uplink.Store(newUplinkState(tt.rules, st))
+			if got := c.uplink.Load().bound; got != tt.wantBound {
+				t.Errorf("bound = %q; want %q", got, tt.wantBound)
+			}
+			de := &endpoint{c: c}
+			for _, ipp := range []netip.AddrPort{lan, cell, wan} {
+				wantPing := contains(tt.wantPing, ipp)
+				if got := de.pingAllowedLocked(ipp); got != wantPing {
+					t.Errorf("pingAllowed(%v) = %v; want %v", ipp, got, wantPing)
+				}
+				wantData := contains(tt.wantData, ipp)
+				if got := de.pathAllowedLocked(ipp); got != wantData {
+					t.Errorf("pathAllowed(%v) = %v; want %v", ipp, got, wantData)
+				}
+			}
+		})
+	}
+}
+
+func TestPortsToTry(t *testing.T) {
+	small := preftype.PortRange{First: 40000, Last: 40009}
+	got := portsToTry(small)
+	if len(got) != small.Len() {
+		t.Fatalf("portsToTry(%v) returned %d ports; want %d", small, len(got), small.Len())
+	}
+	seen := map[uint16]bool{}
+	for _, p := range got {
+		if !small.Contains(p) || seen[p] {
+			t.Errorf("portsToTry(%v) = %v; want each port of the range once", small, got)
+			break
+		}
+		seen[p] = true
+	}
+
+	big := preftype.PortRange{First: 1024, Last: 65535}
+	got = portsToTry(big)
+	if len(got) != maxPortsToTry {
+		t.Fatalf("portsToTry(%v) returned %d ports; want %d", big, len(got), maxPortsToTry)
+	}
+	seen = map[uint16]bool{}
+	for _, p := range got {
+		if !big.Contains(p) || seen[p] {
+			t.Errorf("portsToTry(%v) = %v; want distinct ports of the range", big, got)
+			break
+		}
+		seen[p] = true
+	}
+}
+
+func TestPortRangeSuggestion(t *testing.T) {
+	tests := []struct {
+		name   string
+		report *netcheck.Report
+		want   string
+	}{
+		{"no_report", nil, ""},
+		{"udp_works", &netcheck.Report{UDP: true, UDPOpenPorts: []uint16{443}}, ""},
+		{"all_blocked", &netcheck.Report{}, ""},
+		{
+			"port_blocked",
+			&netcheck.Report{UDPOpenPorts: []uint16{53, 443, 50000}},
+			"UDP from port 41641 appears blocked but works from port 50000; try --udp-port-range=50000",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := portRangeSuggestion(41641, tt.report); got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectHomeDERP(t *testing.T) {
+	dm := &tailcfg.DERPMap{
+		Regions: map[int]*tailcfg.DERPRegion{
+			1: {RegionID: 1, RegionCode: "one"},
+			2: {RegionID: 2, RegionCode: "two"},
+			3: {RegionID: 3, RegionCode: "three"},
+		},
+	}
+	report := &netcheck.Report{
+		PreferredDERP: 1,
+		RegionLatency: map[int]time.Duration{
+			1: 10 * time.Millisecond,
+			2: 30 * time.Millisecond,
+			3: 20 * time.Millisecond,
+		},
+	}
+	tests := []struct {
+		name       string
+		pin        int
+		avoid      []int
+		wantID     int
+		wantReason string
+	}{
+		{"default", 0, nil, 1, "lowest latency"},
+		{"pinned", 2, nil, 2, "pinned"},
+		{"pinned_missing", 4, nil, 1, "lowest latency"},
+		{"avoided", 0, []int{1}, 3, "lowest latency of the regions not avoided"},
+		{"all_avoided", 0, []int{1, 2, 3}, 1, "lowest latency; every region that replied is avoided"},
+		{"replied_avoided", 0, []int{1, 3}, 2, "lowest latency of the regions not avoided"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, reason := selectHomeDERP(dm, report, tt.pin, tt.avoid)
+			if id != tt.wantID || reason != tt.wantReason {
+				t.Errorf("got %d, %q; want %d, %q", id, reason, tt.wantID, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestDERPStalls(t *testing.T) {
+	now := time.Unix(1000, 0)
+	s := newDERPStalls(999)
+	s.now = func() time.Time { return now }
+	defer s.close()
+
+	congested := func() bool {
+		err := health.OverallError()
+		return err != nil && strings.Contains(err.Error(), "derp999: relay path congested")
+	}
+
+	s.noteWrite(10 * time.Millisecond)
+	if s.congested.Load() || congested() {
+		t.Fatal("congested after fast write")
+	}
+	s.noteWrite(3 * time.Second)
+	if !s.congested.Load() || !congested() {
+		t.Fatal("not congested after slow write")
+	}
+
+	// A good observation soon after the stall doesn't clear it.
+	now = now.Add(derpCongestionClearAfter / 2)
+	s.noteKeepAlive(50*time.Millisecond, nil)
+	if !s.congested.Load() {
+		t.Fatal("congestion cleared too soon")
+	}
+
+	// An unacknowledged keep-alive extends it.
+	s.noteKeepAlive(derpKeepAliveTimeout, context.DeadlineExceeded)
+	now = now.Add(derpCongestionClearAfter / 2)
+	s.noteWrite(time.Millisecond)
+	if !s.congested.Load() {
+		t.Fatal("congestion cleared too soon after keep-alive timeout")
+	}
+
+	now = now.Add(derpCongestionClearAfter)
+	s.noteWrite(time.Millisecond)
+	if s.congested.Load() || congested() {
+		t.Fatal("congestion not cleared")
+	}
+
+	s.noteWrite(3 * time.Second)
+	s.close()
+	if s.congested.Load() || congested() {
+		t.Fatal("congestion not cleared on close")
+	}
+}
+
+func TestFirstContactTrace(t *testing.T) {
+	const ms = time.Millisecond
+	src := netip.MustParseAddrPort("1.2.3.4:41641")
+	de := &endpoint{c: &Conn{logf: t.Logf}}
+
+	start := mono.Now()
+	de.mu.Lock()
+	de.noteHandshakeInitLocked(start)
+	de.noteFirstContactLocked(stageDERPSend, "DERP(1)", start.Add(1*ms))
+	de.noteFirstContactLocked(stageDERPSend, "DERP(1)", start.Add(2*ms)) // dup, ignored
+	de.noteFirstContactLocked(stageHandshakeResponse, src.String(), start.Add(30*ms))
+	de.noteFirstContactLocked(stageFirstData, src.String(), start.Add(40*ms))
+	de.noteFirstContactLocked(stageDirect, src.String(), start.Add(50*ms)) // after done, ignored
+	fc := de.firstContact
+	de.mu.Unlock()
+
+	if fc == nil || !fc.done {
+		t.Fatalf("trace not done: %+v", fc)
+	}
+	if de.firstContactActive.Load() {
+		t.Error("trace still active after first data")
+	}
+	var got []string
+	for _, s := range fc.stages {
+		got = append(got, fmt.Sprintf("%s+%v", s.Name, s.Elapsed))
+	}
+	want := []string{"handshake-init+0s", "derp-send+1ms", "handshake-response+30ms", "first-data+40ms"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("stages = %q; want %q", got, want)
+	}
+
+	// A rekey handshake soon after traffic isn't a first contact.
+	de.lastRecv.StoreAtomic(start.Add(40 * ms))
+	de.mu.Lock()
+	de.noteHandshakeInitLocked(start.Add(time.Second))
+	de.mu.Unlock()
+	if de.firstContact != fc {
+		t.Error("rekey started a new trace")
+	}
+
+	// One that never gets data back expires.
+	de.mu.Lock()
+	de.startFirstContactLocked(start)
+	if !de.expireFirstContactLocked(start.Add(firstContactTimeout)) {
+		t.Error("trace didn't expire")
+	}
+	de.mu.Unlock()
+}
+
+func TestMTUProbeMTUs(t *testing.T) {
+	tests := []struct {
+		mtu  int
+		want []int
+	}{
+		{1280, []int{1280}},
+		{1300, []int{1300, 1280}},
+		{1420, []int{1420, 1412, 1400, 1380, 1360, 1340, 1320, 1300, 1280}},
+		{1500, []int{1500, 1420, 1412, 1400, 1380, 1360, 1340, 1320, 1300, 1280}},
+	}
+	for _, tt := range tests {
+		if got := mtuProbeMTUs(tt.mtu); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("mtuProbeMTUs(%v) = %v; want %v", tt.mtu, got, tt.want)
+		}
+	}
+}
+
+func TestMTUProbe(t *testing.T) {
+	path := netip.MustParseAddrPort("1.2.3.4:41641")
+	// run runs an MTU probe at mtu 1300 with two pings of each size,
+	// those of the MTUs in pongs getting pongs and the rest timing
+	// out, and returns the black hole reported, if any.
+	run := func(pongs ...int) (bh MTUBlackHole, ok bool) {
+		c := &Conn{logf: t.Logf}
+		got := make(chan MTUBlackHole, 1)
+		c.SetMTUBlackHoleCallback(func(bh MTUBlackHole) { got <- bh })
+		de := &endpoint{c: c}
+		de.mu.Lock()
+		de.mtuProbe = &mtuProbe{path: path, mtu: 1300, sent: 6, pending: 6}
+		for _, m := range []int{0, 0, 1280, 1280, 1300, 1300} {
+			gotPong := false
+			for _, p := range pongs {
+				gotPong = gotPong || p == m
+			}
+			de.noteMTUProbeReplyLocked(sentPing{to: path, mtu: m}, gotPong)
+			de.mtuProbePingDoneLocked()
+		}
+		de.mu.Unlock()
+		select {
+		case bh := <-got:
+			return bh, true
+		case <-time.After(100 * time.Millisecond):
+			return MTUBlackHole{}, false
+		}
+	}
+
+	if bh, ok := run(0, 1280, 1300); ok {
+		t.Errorf("black hole reported when everything got through: %+v", bh)
+	}
+	if bh, ok := run(); ok {
+		t.Errorf("black hole reported when the path is down: %+v", bh)
+	}
+	bh, ok := run(0, 1280)
+	if want := (MTUBlackHole{Path: path, MTU: 1300, FitMTU: 1280}); !ok || bh != want {
+		t.Errorf("got %+v, %v; want %+v", bh, ok, want)
+	}
+	bh, ok = run(0)
+	if want := (MTUBlackHole{Path: path, MTU: 1300}); !ok || bh != want {
+		t.Errorf("got %+v, %v; want %+v", bh, ok, want)
+	}
+}
+
+// TestMTUBlackHoleNatlab checks that a path that drops full-size
+// WireGuard packets, but not smaller ones, is found to be an MTU black
+// hole, and the largest MTU that fits it with it.
+func TestMTUBlackHoleNatlab(t *testing.T) {
+	tstest.ResourceCheck(t)
+
+	lab := natlab.NewLab()
+	lab.Internet.MTU = 1400
+	lab.Internet.Seed = 1
+	stun := lab.AddNode("stun", natlab.NodeConfig{})
+	n1 := lab.AddNode("m1", natlab.NodeConfig{})
+	n2 := lab.AddNode("m2", natlab.NodeConfig{})
+
+	logf, closeLogf := logger.LogfCloser(t.Logf)
+	defer closeLogf()
+
+	derpMap, cleanup := runDERPAndStun(t, logf, stun.Machine, stun.IP())
+	defer cleanup()
+
+	m1 := newMagicStack(t, logger.WithPrefix(logf, "conn1: "), n1.Machine, derpMap)
+	defer m1.Close()
+	m2 := newMagicStack(t, logger.WithPrefix(logf, "conn2: "), n2.Machine, derpMap)
+	defer m2.Close()
+
+	cleanup = meshStacks(logf, nil, m1, m2)
+	defer cleanup()
+
+	got := make(chan MTUBlackHole, 1)
+	m1.conn.SetMTUBlackHoleCallback(func(bh MTUBlackHole) {
+		select {
+		case got <- bh:
+		default:
+		}
+	})
+	const tunMTU = 1420
+	m1.conn.SetTunMTU(tunMTU)
+
+	cleanup = newPinger(t, logf, m1, m2)
+	mustDirect(t, logf, m1, m2)
+	cleanup()
+
+	// Send packets of the full MTU, which the internet drops, until
+	// m1 probes the path and finds the black hole.
+	src, dst := m1.IP(), m2.IP()
+	pkt := packet.Generate(packet.UDP4Header{
+		IP4Header: packet.IP4Header{Src: src, Dst: dst},
+		SrcPort:   1234,
+		DstPort:   5678,
+	}, make([]byte, tunMTU-20-8))
+	timeout := time.After(30 * time.Second)
+	for {
+		select {
+		case m1.tun.Outbound <- pkt:
+		case <-timeout:
+			t.Fatal("no MTU black hole found")
+		}
+		select {
+		case bh := <-got:
+			// A packet of the Tailscale interface's MTU m is sent in
+			// one of m + wgDataOverhead + 28 (IPv4) or + 48 (IPv6)
+			// bytes.
+			wantFit := lab.Internet.MTU - wgDataOverhead - 28
+			if bh.Path.Addr().Is6() {
+				wantFit = lab.Internet.MTU - wgDataOverhead - 48
+			}
+			want := MTUBlackHole{Peer: m2.Public(), Path: bh.Path, MTU: tunMTU, FitMTU: wantFit}
+			if bh != want {
+				t.Errorf("got %+v; want %+v", bh, want)
+			}
+			return
+		case <-m2.tun.Inbound:
+			t.Fatal("packet larger than the internet's MTU got through")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package magicsock
+
+import (
+	"net/netip"
+	"time"
+
+	"tailscale.com/disco"
+	"tailscale.com/net/stun"
+	"tailscale.com/tstime/mono"
+	"tailscale.com/types/key"
+	"tailscale.com/util/crashreport"
+)
+
+const (
+	// mtuSuspectSends is how many full-size packets can be sent to
+	// a peer over a direct path with no data coming back before the
+	// path is probed for an MTU black hole: the WireGuard handshake
+	// completed, but the data never got through.
+	mtuSuspectSends = 10
+
+	// mtuProbeInterval is the minimum time between MTU probes of a
+	// peer.
+	mtuProbeInterval = time.Minute
+
+	// mtuProbeAttempts is how many pings of each size an MTU probe
+	// sends, so a single lost one isn't taken for a black hole.
+	mtuProbeAttempts = 2
+
+	// minTunMTU is the lowest MTU of the Tailscale interface: the
+	// minimum MTU for IPv6.
+	minTunMTU = 1280
+
+	// wgDataOverhead is the bytes WireGuard adds to an IP packet in
+	// a data message: a 16 byte header and a 16 byte auth tag. A
+	// packet of the full MTU of the Tailscale interface is sent in a
+	// UDP payload of the MTU plus wgDataOverhead.
+	wgDataOverhead = 32
+
+	// wgUnderlayOverhead is the most bytes the underlying network
+	// needs to carry a packet of the Tailscale interface: an IPv6
+	// header (40 bytes), a UDP header (8) and wgDataOverhead.
+	wgUnderlayOverhead = 40 + 8 + wgDataOverhead
+
+	// discoPingOverhead is the size of a sealed, unpadded disco ping
+	// with a node key: the magic, the sender's disco key, a nonce,
+	// the secretbox overhead (16 bytes) and the ping itself (type,
+	// version, TxID and node key).
+	discoPingOverhead = len(disco.Magic) + key.DiscoPublicRawLen + disco.NonceLen + 16 + 2 + 12 + key.NodePublicRawLen
+)
+
+// commonLinkMTUs are MTUs of common underlying networks, such as
+// Ethernet, PPPoE and various tunnels and clouds, largest first. An
+// MTU probe tries the Tailscale interface MTUs that fit in them.
+var commonLinkMTUs = []int{1500, 1492, 1480, 1460, 1440, 1420, 1400, 1380, 1360}
+
+// MTUBlackHole is a direct path to a peer found to silently drop
+// packets of the full MTU of the Tailscale interface.
+type MTUBlackHole struct {
+	Peer key.NodePublic
+	Path netip.AddrPort // the direct path to Peer
+
+	// MTU is the MTU of the Tailscale interface when the black hole
+	// was found.
+	MTU int
+
+	// FitMTU is the largest lower MTU whose packets made it over
+	// Path, or zero if none of those probed did.
+	FitMTU int
+}
+
+// mtuProbe is an MTU probe of a direct path in progress.
+type mtuProbe struct {
+	path     netip.AddrPort
+	mtu      int  // MTU of the Tailscale interface being probed
+	sent     int  // pings sent
+	pending  int  // pings sent but not yet removed from sentPing
+	answered int  // pings that got a pong or timed out
+	gotSmall bool // whether an unpadded ping got a pong
+	fit      int  // largest MTU whose padded ping got a pong
+}
+
+// SetTunMTU sets the MTU of the Tailscale interface. If mtu is
+// non-zero, direct paths to peers that seem to drop packets of that
+// size are probed, and black holes found are reported to the callback
+// set with SetMTUBlackHoleCallback.
+func (c *Conn) SetTunMTU(mtu int) {
+	if int(c.tunMTU.Swap(int32(mtu))) != mtu {
+		c.logf("magicsock: tun MTU %v", mtu)
+	}
+}
+
+// SetMTUBlackHoleCallback sets the func to call, in its own goroutine,
+// with each path MTU black hole found.
+func (c *Conn) SetMTUBlackHoleCallback(fn func(MTUBlackHole)) {
+	c.mtuBlackHoleFunc.Store(fn)
+}
+
+// mtuProbeMTUs returns the MTUs of the Tailscale interface for an MTU
+// probe of a path when it's mtu: mtu itself and those lower MTUs, down
+// to minTunMTU, that fit in commonLinkMTUs.
+func mtuProbeMTUs(mtu int) []int {
+	ret := []int{mtu}
+	for _, link := range commonLinkMTUs {
+		m := link - wgUnderlayOverhead
+		if m < minTunMTU {
+			break
+		}
+		if m < mtu {
+			ret = append(ret, m)
+		}
+	}
+	return ret
+}
+
+// noteMTURecv notes that b was received from the peer. Data from the
+// peer means the path isn't a black hole, at least not one in both
+// directions.
+func (de *endpoint) noteMTURecv(b []byte) {
+	// Skip keepalives, which are just the WireGuard overhead.
+	if len(b) > wgDataOverhead && b[0] == wgMsgData {
+		de.mtuSuspectSends.Store(0)
+	}
+}
+
+// noteMTUSendLocked notes that b is being sent at now to udpAddr and
+// derpAddr, starting an MTU probe of udpAddr if too many full-size
+// packets have been sent there with nothing coming back.
+//
+// de.mu must be held.
+func (de *endpoint) noteMTUSendLocked(b []byte, udpAddr, derpAddr netip.AddrPort, now mono.Time) {
+	mtu := int(de.c.tunMTU.Load())
+	if mtu == 0 || !udpAddr.IsValid() || derpAddr.IsValid() || len(b) < mtu+wgDataOverhead || b[0] != wgMsgData {
+		return
+	}
+	if de.mtuSuspectSends.Add(1) < mtuSuspectSends || de.mtuProbe != nil || !de.canP2P() {
+		return
+	}
+	if !de.lastMTUProbe.IsZero() && now.Sub(de.lastMTUProbe) < mtuProbeInterval {
+		return
+	}
+	de.startMTUProbeLocked(udpAddr, mtu, now)
+}
+
+// startMTUProbeLocked starts probing ep, the direct path to the peer,
+// for an MTU black hole with pings padded to the size WireGuard sends
+// packets of each MTU in mtuProbeMTUs(mtu) in, along with unpadded
+// ones to tell a black hole from a path that's down.
+//
+// de.mu must be held.
+func (de *endpoint) startMTUProbeLocked(ep netip.AddrPort, mtu int, now mono.Time) {
+	de.c.logf("[v1] magicsock: disco: probing %v (%v) for an MTU black hole at %v", ep, de.publicKey.ShortString(), mtu)
+	de.lastMTUProbe = now
+	p := &mtuProbe{path: ep, mtu: mtu}
+	de.mtuProbe = p
+	mtus := append([]int{0}, mtuProbeMTUs(mtu)...)
+	for _, m := range mtus {
+		padding := 0
+		if m != 0 {
+			padding = m + wgDataOverhead - discoPingOverhead
+		}
+		for i := 0; i < mtuProbeAttempts; i++ {
+			txid := stun.NewTxID()
+			de.sentPing[txid] = sentPing{
+				to:      ep,
+				at:      now,
+				timer:   time.AfterFunc(pingTimeoutDuration, func() { de.pingTimeout(txid) }),
+				purpose: pingMTUProbe,
+				mtu:     m,
+			}
+			p.sent++
+			p.pending++
+			discoKey := de.discoKey
+			crashreport.Go(func() { de.sendDiscoPing(ep, discoKey, txid, padding, discoVerboseLog) })
+		}
+	}
+}
+
+// noteMTUProbeReplyLocked records that the MTU probe ping sp got a
+// pong, if gotPong, or else timed out.
+//
+// de.mu must be held.
+func (de *endpoint) noteMTUProbeReplyLocked(sp sentPing, gotPong bool) {
+	p := de.mtuProbe
+	if p == nil || sp.to != p.path {
+		return
+	}
+	p.answered++
+	if !gotPong {
+		return
+	}
+	if sp.mtu == 0 {
+		p.gotSmall = true
+	} else if sp.mtu > p.fit {
+		p.fit = sp.mtu
+	}
+}
+
+// mtuProbePingDoneLocked is called when an MTU probe ping is removed
+// from sentPing, and finishes the probe after its last ping.
+//
+// de.mu must be held.
+func (de *endpoint) mtuProbePingDoneLocked() {
+	p := de.mtuProbe
+	if p == nil {
+		return
+	}
+	p.pending--
+	if p.pending > 0 {
+		return
+	}
+	de.mtuProbe = nil
+	if p.answered < p.sent {
+		// Some pings failed to send. Try again later.
+		return
+	}
+	if p.fit == p.mtu {
+		de.mtuSuspectSends.Store(0)
+		return
+	}
+	if !p.gotSmall {
+		// The path is down altogether, which disco handles.
+		return
+	}
+	bh := MTUBlackHole{
+		Peer:   de.publicKey,
+		Path:   p.path,
+		MTU:    p.mtu,
+		FitMTU: p.fit,
+	}
+	de.c.logf("magicsock: disco: %v (%v) drops packets of MTU %v; largest that fit: %v", p.path, de.publicKey.ShortString(), p.mtu, p.fit)
+	if fn := de.c.mtuBlackHoleFunc.Load(); fn != nil {
+		crashreport.Go(func() { fn(bh) })
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package magicsock
+
+import (
+	"fmt"
+	"net/netip"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/interfaces"
+	"tailscale.com/types/key"
+	"tailscale.com/types/preftype"
+	"tailscale.com/util/mak"
+)
+
+// SetPeerPathPin restricts the paths used to reach the peer with the
+// provided node key, replacing any previous pin. A zero pin removes
+// the restriction. See ipnstate.PathPin for the meaning of each field.
+//
+// Pins are kept across network map updates, but not across restarts.
+func (c *Conn) SetPeerPathPin(pub key.NodePublic, pin ipnstate.PathPin) error {
+	deny, err := forbiddenPrefixes(pin.ForbidInterfaces)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if pin.IsZero() {
+		delete(c.pathPins, pub)
+	} else {
+		mak.Set(&c.pathPins, pub, pin)
+	}
+	if ep, ok := c.peerMap.endpointForNodeKey(pub); ok {
+		ep.setPathPin(pin, deny)
+	}
+	c.logf("magicsock: path pin for %v set to %v", pub.ShortString(), pin)
+	return nil
+}
+
+// PeerPathPins returns the current path pins, keyed by peer node key.
+func (c *Conn) PeerPathPins() map[key.NodePublic]ipnstate.PathPin {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ret := make(map[key.NodePublic]ipnstate.PathPin, len(c.pathPins))
+	for k, v := range c.pathPins {
+		ret[k] = v
+	}
+	return ret
+}
+
+// applyPathPinLocked applies any stored path pin to a newly created
+// endpoint. c.mu must be held.
+func (c *Conn) applyPathPinLocked(de *endpoint) {
+	pin, ok := c.pathPins[de.publicKey]
+	if !ok {
+		return
+	}
+	deny, err := forbiddenPrefixes(pin.ForbidInterfaces)
+	if err != nil {
+		c.logf("magicsock: path pin for %v: %v", de.publicKey.ShortString(), err)
+	}
+	de.setPathPin(pin, deny)
+}
+
+// forbiddenPrefixes returns the subnets of the named local interfaces.
+func forbiddenPrefixes(ifNames []string) ([]netip.Prefix, error) {
+	if len(ifNames) == 0 {
+		return nil, nil
+	}
+	want := map[string]bool{}
+	for _, n := range ifNames {
+		want[n] = true
+	}
+	var ret []netip.Prefix
+	err := interfaces.ForeachInterface(func(iface interfaces.Interface, pfxs []netip.Prefix) {
+		if !want[iface.Name] {
+			return
+		}
+		delete(want, iface.Name)
+		for _, pfx := range pfxs {
+			ret = append(ret, pfx.Masked())
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	for n := range want {
+		return nil, fmt.Errorf("unknown interface %q", n)
+	}
+	return ret, nil
+}
+
+// setPathPin sets de's path pin and the subnets it forbids.
+func (de *endpoint) setPathPin(pin ipnstate.PathPin, deny []netip.Prefix) {
+	de.mu.Lock()
+	defer de.mu.Unlock()
+
+	for ep, st := range de.endpointState {
+		if st.pinned && ep != pin.Endpoint {
+			st.pinned = false
+			if st.shouldDeleteLocked() {
+				de.deleteEndpointLocked(ep)
+			}
+		}
+	}
+	de.pathPin = pin
+	de.pathPinDeny = deny
+	if ep := pin.Endpoint; ep.IsValid() {
+		st, ok := de.endpointState[ep]
+		if !ok {
+			st = &endpointState{index: indexSentinelDeleted}
+			de.endpointState[ep] = st
+		}
+		st.pinned = true
+	}
+	if de.bestAddr.AddrPort.IsValid() && !de.pathAllowedLocked(de.bestAddr.AddrPort) {
+		de.bestAddr = addrLatency{}
+		de.trustBestAddrUntil = 0
+	}
+}
+
+// pathAllowedLocked reports whether de's path pin, and the Conn's
+// ForceDERP setting, TS_DEBUG_ALWAYS_USE_DERP and uplink policy, permit
+// sending data directly to ipp. de.mu must be held.
+func (de *endpoint) pathAllowedLocked(ipp netip.AddrPort) bool {
+	if !de.pingAllowedLocked(ipp) {
+		return false
+	}
+	return de.c.uplinkModeOf(ipp.Addr()) != preftype.UplinkDiscoOnly
+}
+
+// pingAllowedLocked is like pathAllowedLocked, but for disco pings,
+// which are also allowed over disco-only uplinks. de.mu must be held.
+func (de *endpoint) pingAllowedLocked(ipp netip.AddrPort) bool {
+	if de.pathPin.DERPOnly || de.c.forceDERP.Load() || debugAlwaysDERP() {
+		return false
+	}
+	if de.c.uplinkModeOf(ipp.Addr()) == preftype.UplinkExclude {
+		return false
+	}
+	if ep := de.pathPin.Endpoint; ep.IsValid() && ep != ipp {
+		return false
+	}
+	for _, pfx := range de.pathPinDeny {
+		if pfx.Contains(ipp.Addr()) {
+			return false
+		}
+	}
+	return true
+}
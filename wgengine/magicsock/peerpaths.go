@@ -0,0 +1,61 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package magicsock
+
+import (
+	"time"
+
+	"tailscale.com/tstime/mono"
+	"tailscale.com/types/key"
+	"tailscale.com/util/latencyhist"
+)
+
+// PeerPath is the path that a Conn sends a peer's packets over.
+type PeerPath struct {
+	// Direct is whether the path is direct UDP rather than via DERP.
+	Direct bool
+	// Latency is the round-trip time of the direct path, or zero if
+	// unknown or not Direct.
+	Latency time.Duration
+}
+
+// ActivePeerPaths returns the path in use to each peer that packets
+// were sent to recently. Peers without recent packets don't have a
+// path in use, so they're omitted.
+func (c *Conn) ActivePeerPaths() map[key.NodePublic]PeerPath {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := mono.Now()
+	ret := make(map[key.NodePublic]PeerPath)
+	c.peerMap.forEachEndpoint(func(ep *endpoint) {
+		ep.mu.Lock()
+		defer ep.mu.Unlock()
+		if ep.lastSend.IsZero() || now.Sub(ep.lastSend) >= sessionActiveTimeout {
+			return
+		}
+		var p PeerPath
+		if udpAddr, derpAddr := ep.addrForSendLocked(now); udpAddr.IsValid() && !derpAddr.IsValid() {
+			p.Direct = true
+			if udpAddr == ep.bestAddr.AddrPort {
+				p.Latency = ep.bestAddr.latency
+			}
+		}
+		ret[ep.publicKey] = p
+	})
+	return ret
+}
+
+// PeerRTTs returns the round-trip times of the disco pings to the peer
+// with node key k, over any path, in the window before now. It reports
+// false if there's no such peer.
+func (c *Conn) PeerRTTs(k key.NodePublic, now time.Time, window time.Duration) (latencyhist.Snapshot, bool) {
+	c.mu.Lock()
+	ep, ok := c.peerMap.endpointForNodeKey(k)
+	c.mu.Unlock()
+	if !ok {
+		return latencyhist.Snapshot{}, false
+	}
+	return ep.rtts.Snapshot(now, window), true
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package magicsock
+
+import (
+	"fmt"
+	"math/rand"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/netcheck"
+	"tailscale.com/types/preftype"
+)
+
+// maxPortsToTry is the most ports of a port range that bindSocket tries
+// before giving up.
+const maxPortsToTry = 32
+
+// SetPortRange restricts the local ports of the UDP sockets, and so the
+// source ports of disco and STUN traffic, to r. If the current port is
+// outside r, the sockets are rebound. The zero PortRange allows any
+// port.
+func (c *Conn) SetPortRange(r preftype.PortRange) {
+	if c.portRange.Load() == r {
+		return
+	}
+	c.portRange.Store(r)
+	c.logf("magicsock: UDP port range set to %q", r)
+	if r.Contains(c.LocalPort()) {
+		return
+	}
+	if err := c.rebind(dropCurrentPort); err != nil {
+		c.logf("%v", err)
+		return
+	}
+	c.resetEndpointStates()
+	c.ReSTUN("port-range")
+}
+
+// updatePortStatus adds the local UDP port, the port range it was
+// chosen from and any suggested port range to sb.
+func (c *Conn) updatePortStatus(sb *ipnstate.StatusBuilder) {
+	port := c.LocalPort()
+	portRange := c.portRange.Load().String()
+	suggestion := portRangeSuggestion(port, c.lastNetCheckReport.Load())
+	sb.MutateStatus(func(st *ipnstate.Status) {
+		st.UDPPort = port
+		st.UDPPortRange = portRange
+		st.UDPPortSuggestion = suggestion
+	})
+}
+
+// portRangeSuggestion returns the UDPPortRange pref to suggest to the
+// user when report shows that STUN failed from our local port but
+// worked from some of the alternative ones netcheck probed, or the
+// empty string if there's nothing to suggest.
+func portRangeSuggestion(port uint16, report *netcheck.Report) string {
+	if report == nil || report.UDP || len(report.UDPOpenPorts) == 0 {
+		return ""
+	}
+	// Prefer the high ports, which don't need privileges to bind and
+	// aren't in use by DNS or HTTPS servers.
+	best := report.UDPOpenPorts[len(report.UDPOpenPorts)-1]
+	return fmt.Sprintf("UDP from port %d appears blocked but works from port %d; try --udp-port-range=%d", port, best, best)
+}
+
+// portsToTry returns the ports of the non-zero r to try binding, in
+// random order so that several processes sharing a range don't all
+// contend for its first ports. At most maxPortsToTry are returned.
+func portsToTry(r preftype.PortRange) []uint16 {
+	n := r.Len()
+	if n <= maxPortsToTry {
+		ports := make([]uint16, 0, n)
+		for _, i := range rand.Perm(n) {
+			ports = append(ports, r.First+uint16(i))
+		}
+		return ports
+	}
+	seen := make(map[uint16]bool, maxPortsToTry)
+	ports := make([]uint16, 0, maxPortsToTry)
+	for len(ports) < maxPortsToTry {
+		p := r.First + uint16(rand.Intn(n))
+		if !seen[p] {
+			seen[p] = true
+			ports = append(ports, p)
+		}
+	}
+	return ports
+}
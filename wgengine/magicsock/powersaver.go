@@ -0,0 +1,135 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package magicsock
+
+import (
+	"time"
+
+	"tailscale.com/disco"
+	"tailscale.com/types/key"
+	"tailscale.com/util/clientmetric"
+)
+
+const (
+	// powerSaverHeartbeatInterval is heartbeatInterval in power saver
+	// mode. Being a multiple of it, each heartbeat stands in for a
+	// whole number of regular ones.
+	powerSaverHeartbeatInterval = 4 * heartbeatInterval
+
+	// powerSaverTrustUDPAddrDuration is trustUDPAddrDuration in power
+	// saver mode, likewise a little over two heartbeats, so a single
+	// lost pong doesn't start path discovery.
+	powerSaverTrustUDPAddrDuration = 2*powerSaverHeartbeatInterval + 500*time.Millisecond
+
+	// powerSaverUpgradeInterval is upgradeInterval in power saver
+	// mode.
+	powerSaverUpgradeInterval = 5 * time.Minute
+
+	// powerSaverReSTUNFactor is how many times longer the periodic
+	// STUN interval is in power saver mode. It's then longer than
+	// common UDP NAT timeouts, so NAT mappings of idle sockets may
+	// expire and be rediscovered.
+	powerSaverReSTUNFactor = 2
+
+	// heartbeatBytes is about the bytes on the wire of a heartbeat: a
+	// disco ping (46 bytes: type, version, TxID and node key) and its
+	// pong (32 bytes: type, version, TxID and source), each sealed in
+	// a secretbox (16 bytes of overhead) after the magic, the sender's
+	// disco key and a nonce, in a UDP packet over IPv4 (28 bytes of
+	// headers).
+	heartbeatBytes = int64(2*(len(disco.Magic)+key.DiscoPublicRawLen+disco.NonceLen+16+28) + 46 + 32)
+)
+
+var (
+	metricPowerSaverHeartbeatsSaved = clientmetric.NewCounter("magicsock_powersaver_heartbeats_saved")
+	metricPowerSaverBytesSaved      = clientmetric.NewCounter("magicsock_powersaver_bytes_saved")
+	metricPowerSaverReSTUNsSaved    = clientmetric.NewCounter("magicsock_powersaver_restuns_saved")
+)
+
+// SetPowerSaver sets whether to save power (and metered data) by
+// sending disco heartbeats to peers with active sessions, probing for
+// better paths and doing periodic STUN less often. Broken direct paths
+// then take longer to fall back to DERP.
+func (c *Conn) SetPowerSaver(on bool) {
+	if c.powerSaver.Swap(on) == on {
+		return
+	}
+	if on {
+		c.logf("magicsock: power saver on; heartbeats every %v", powerSaverHeartbeatInterval)
+	} else {
+		c.logf("magicsock: power saver off")
+	}
+}
+
+// PowerSaver reports whether power saver mode is on.
+func (c *Conn) PowerSaver() bool {
+	return c.powerSaver.Load()
+}
+
+// SetLinkExpensive records whether the platform considers the current
+// network link expensive, such as LTE rather than Wi-Fi, for
+// LinkExpensive.
+func (c *Conn) SetLinkExpensive(expensive bool) {
+	c.linkExpensive.Store(expensive)
+}
+
+// LinkExpensive reports whether the platform said, with
+// SetLinkExpensive, that the current network link is expensive. It's
+// false on platforms that don't say.
+func (c *Conn) LinkExpensive() bool {
+	return c.linkExpensive.Load()
+}
+
+// PowerSaverSavings returns the disco heartbeats and periodic STUN
+// runs that power saver mode skipped since c was created, and about
+// how many bytes the heartbeats would have taken.
+func (c *Conn) PowerSaverSavings() (heartbeats, heartbeatBytesSaved, reSTUNs int64) {
+	heartbeats = c.heartbeatsSaved.Load()
+	return heartbeats, heartbeats * heartbeatBytes, c.reSTUNsSaved.Load()
+}
+
+// noteHeartbeatsSaved records that a heartbeat was sent in power saver
+// mode, in place of several regular ones.
+func (c *Conn) noteHeartbeatsSaved() {
+	const n = int64(powerSaverHeartbeatInterval/heartbeatInterval) - 1
+	c.heartbeatsSaved.Add(n)
+	metricPowerSaverHeartbeatsSaved.Add(n)
+	metricPowerSaverBytesSaved.Add(n * heartbeatBytes)
+}
+
+// noteReSTUNsSaved records that periodic STUN was scheduled in power
+// saver mode, in place of several regular runs.
+func (c *Conn) noteReSTUNsSaved() {
+	const n = powerSaverReSTUNFactor - 1
+	c.reSTUNsSaved.Add(n)
+	metricPowerSaverReSTUNsSaved.Add(n)
+}
+
+// heartbeatPeriod returns how often to send disco heartbeats to peers
+// with active sessions.
+func (c *Conn) heartbeatPeriod() time.Duration {
+	if c.powerSaver.Load() {
+		return powerSaverHeartbeatInterval
+	}
+	return heartbeatInterval
+}
+
+// trustUDPAddrPeriod returns how long to trust a UDP address as the
+// only path to a peer without hearing a pong.
+func (c *Conn) trustUDPAddrPeriod() time.Duration {
+	if c.powerSaver.Load() {
+		return powerSaverTrustUDPAddrDuration
+	}
+	return trustUDPAddrDuration
+}
+
+// upgradePeriod returns how often to look for a better path to a peer
+// that has a working one.
+func (c *Conn) upgradePeriod() time.Duration {
+	if c.powerSaver.Load() {
+		return powerSaverUpgradeInterval
+	}
+	return upgradeInterval
+}
@@ -0,0 +1,218 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package magicsock
+
+import (
+	"net/netip"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/interfaces"
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/types/preftype"
+)
+
+// uplinkState is the uplink policy as applied to the current network
+// interfaces. It's replaced, never mutated.
+type uplinkState struct {
+	rules []preftype.UplinkRule
+	modes map[string]preftype.UplinkMode // interface name => mode
+
+	// subnets are the local subnets of the interfaces that are up.
+	subnets map[string][]netip.Prefix
+
+	// up is the set of interfaces that are up.
+	up map[string]bool
+
+	// defIf is the interface of the default route, if known.
+	defIf string
+
+	// bound is the interface that the UDP sockets are bound to, the
+	// first preferred one that's up, or empty if none.
+	bound      string
+	boundIndex int
+}
+
+// newUplinkState returns the uplink state for the policy rules given
+// the interface state st, which may be nil if unknown.
+func newUplinkState(rules []preftype.UplinkRule, st *interfaces.State) *uplinkState {
+	s := &uplinkState{
+		rules:   rules,
+		modes:   map[string]preftype.UplinkMode{},
+		subnets: map[string][]netip.Prefix{},
+		up:      map[string]bool{},
+	}
+	for _, r := range rules {
+		s.modes[r.Interface] = r.Mode
+	}
+	if st == nil {
+		return s
+	}
+	s.defIf = st.DefaultRouteInterface
+	for name, iface := range st.Interface {
+		if iface.Interface == nil || !iface.IsUp() || iface.IsLoopback() {
+			continue
+		}
+		s.up[name] = true
+		for _, pfx := range st.InterfaceIPs[name] {
+			if tsaddr.IsTailscaleIP(pfx.Addr()) {
+				continue
+			}
+			s.subnets[name] = append(s.subnets[name], pfx.Masked())
+		}
+	}
+	for _, r := range rules {
+		if r.Mode == preftype.UplinkPrefer && s.up[r.Interface] {
+			s.bound = r.Interface
+			s.boundIndex = st.Interface[r.Interface].Index
+			break
+		}
+	}
+	return s
+}
+
+// uplinkOf returns the name of the local interface that packets to ip
+// leave through: the interface on the same subnet as ip, or else the
+// interface the sockets are bound to, or else the default route's.
+func (s *uplinkState) uplinkOf(ip netip.Addr) string {
+	var best string
+	bestBits := -1
+	for name, pfxs := range s.subnets {
+		for _, pfx := range pfxs {
+			if pfx.Contains(ip) && pfx.Bits() > bestBits {
+				best, bestBits = name, pfx.Bits()
+			}
+		}
+	}
+	if best != "" {
+		return best
+	}
+	if s.bound != "" {
+		return s.bound
+	}
+	return s.defIf
+}
+
+// modeOf returns the policy mode of the uplink used to reach ip, or the
+// empty string if no rule applies to it.
+func (s *uplinkState) modeOf(ip netip.Addr) preftype.UplinkMode {
+	if s == nil || len(s.rules) == 0 {
+		return ""
+	}
+	return s.modes[s.uplinkOf(ip)]
+}
+
+// SetUplinkPolicy sets which of the machine's network interfaces are
+// preferred for, limited to disco pings on, or excluded from direct
+// paths to peers. The UDP sockets are bound to the first preferred
+// interface that's up.
+func (c *Conn) SetUplinkPolicy(rules []preftype.UplinkRule) {
+	var oldRules []preftype.UplinkRule
+	if old := c.uplink.Load(); old != nil {
+		oldRules = old.rules
+	}
+	if uplinkRulesEqual(oldRules, rules) {
+		return
+	}
+	c.logf("magicsock: uplink policy set to %v", rules)
+	if c.updateUplinkState(rules) {
+		if err := c.rebind(keepCurrentPort); err != nil {
+			c.logf("%v", err)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.peerMap.forEachEndpoint(func(de *endpoint) {
+		de.mu.Lock()
+		defer de.mu.Unlock()
+		if de.bestAddr.AddrPort.IsValid() && !de.pathAllowedLocked(de.bestAddr.AddrPort) {
+			de.bestAddr = addrLatency{}
+			de.trustBestAddrUntil = 0
+		}
+		// Start path discovery again on the next send.
+		de.lastFullPing = 0
+	})
+}
+
+// updateUplinkState applies the uplink policy rules to the current
+// network interfaces. It reports whether the interface that the UDP
+// sockets should be bound to changed, in which case the caller must
+// rebind them.
+func (c *Conn) updateUplinkState(rules []preftype.UplinkRule) (rebind bool) {
+	var st *interfaces.State
+	if c.linkMon != nil {
+		st = c.linkMon.InterfaceState()
+	}
+	s := newUplinkState(rules, st)
+	old := c.uplink.Swap(s)
+	var oldBound string
+	if old != nil {
+		oldBound = old.bound
+	}
+	if s.bound == oldBound {
+		return false
+	}
+	c.logf("magicsock: binding UDP sockets to interface %q (was %q)", s.bound, oldBound)
+	return true
+}
+
+// uplinkRules returns the current uplink policy rules.
+func (c *Conn) uplinkRules() []preftype.UplinkRule {
+	if s := c.uplink.Load(); s != nil {
+		return s.rules
+	}
+	return nil
+}
+
+// uplinkModeOf returns the uplink policy mode that applies to sending
+// to ip, or the empty string if none.
+func (c *Conn) uplinkModeOf(ip netip.Addr) preftype.UplinkMode {
+	return c.uplink.Load().modeOf(ip)
+}
+
+// betterUplinkAddr is like betterAddr, but first prefers addresses
+// reached over a preferred uplink.
+func (c *Conn) betterUplinkAddr(a, b addrLatency) bool {
+	if a.AddrPort != b.AddrPort && a.IsValid() && b.IsValid() {
+		pa := c.uplinkModeOf(a.Addr()) == preftype.UplinkPrefer
+		pb := c.uplinkModeOf(b.Addr()) == preftype.UplinkPrefer
+		if pa != pb {
+			return pa
+		}
+	}
+	return betterAddr(a, b)
+}
+
+// updateUplinkStatus adds the state of the uplink policy to sb.
+func (c *Conn) updateUplinkStatus(sb *ipnstate.StatusBuilder) {
+	s := c.uplink.Load()
+	if s == nil || len(s.rules) == 0 {
+		return
+	}
+	uplinks := make([]ipnstate.UplinkStatus, 0, len(s.rules))
+	for _, r := range s.rules {
+		uplinks = append(uplinks, ipnstate.UplinkStatus{
+			Interface: r.Interface,
+			Mode:      string(r.Mode),
+			Up:        s.up[r.Interface],
+			Bound:     r.Interface == s.bound,
+		})
+	}
+	sb.MutateStatus(func(st *ipnstate.Status) {
+		st.Uplinks = uplinks
+	})
+}
+
+func uplinkRulesEqual(a, b []preftype.UplinkRule) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
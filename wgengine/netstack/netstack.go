@@ -20,6 +20,7 @@
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"gvisor.dev/gvisor/pkg/bufferv2"
@@ -134,6 +135,23 @@ type Impl struct {
 const nicID = 1
 const mtu = tstun.DefaultMTU
 
+// setTCPMaxSeg, if non-nil, sets the MSS of the TCP socket fd before
+// it connects. It's set on platforms that support it.
+var setTCPMaxSeg func(fd uintptr, mss int) error
+
+// clampedMSS returns the TCP MSS used for forwarded connections to ip
+// when the ClampMSS pref is on: the largest segment that fits in the
+// Tailscale MTU. netstack's side of each forwarded connection is
+// bounded by that MTU already, as netstack terminates it; clamping the
+// connection it dials on the peer's behalf to match keeps it working on
+// LAN and uplink paths whose path MTU discovery is broken.
+func clampedMSS(ip netip.Addr) int {
+	if ip.Is4() {
+		return mtu - 20 - 20 // IPv4 and TCP headers
+	}
+	return mtu - 40 - 20 // IPv6 and TCP headers
+}
+
 // maxUDPPacketSize is the maximum size of a UDP packet we copy in startPacketCopy
 // when relaying UDP packets. We don't use the 'mtu' const in anticipation of
 // one day making the MTU more dynamic.
@@ -876,6 +894,16 @@ func (ns *Impl) forwardTCP(getClient func() *gonet.TCPConn, clientRemoteIP netip
 
 	// Attempt to dial the outbound connection before we accept the inbound one.
 	var stdDialer net.Dialer
+	if ns.lb != nil && ns.lb.ShouldClampMSS() && setTCPMaxSeg != nil {
+		mss := clampedMSS(dialAddr.Addr())
+		stdDialer.Control = func(network, address string, c syscall.RawConn) error {
+			var sockErr error
+			if err := c.Control(func(fd uintptr) { sockErr = setTCPMaxSeg(fd, mss) }); err != nil {
+				return err
+			}
+			return sockErr
+		}
+	}
 	server, err := stdDialer.DialContext(ctx, "tcp", dialAddrStr)
 	if err != nil {
 		ns.logf("netstack: could not connect to local server at %s: %v", dialAddr.String(), err)
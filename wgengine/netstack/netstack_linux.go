@@ -17,4 +17,7 @@ func init() {
 			AmbientCaps: []uintptr{unix.CAP_NET_RAW},
 		}
 	}
+	setTCPMaxSeg = func(fd uintptr, mss int) error {
+		return unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_MAXSEG, mss)
+	}
 }
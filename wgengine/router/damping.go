@@ -0,0 +1,242 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/types/logger"
+)
+
+// Defaults for RouteDamper.
+const (
+	// DefaultMaxReinstalls is how many times a subnet route may be
+	// reinstalled within DefaultDampingWindow before it's dampened.
+	DefaultMaxReinstalls = 3
+
+	// DefaultDampingWindow is the window over which reinstalls count
+	// towards DefaultMaxReinstalls.
+	DefaultDampingWindow = 10 * time.Minute
+
+	// DefaultHoldDown is how long a dampened route is held down after
+	// it last changed.
+	DefaultHoldDown = 5 * time.Minute
+)
+
+// DampenedRoute is a subnet route a RouteDamper is holding down.
+type DampenedRoute struct {
+	Route      netip.Prefix
+	Reinstalls int       // reinstalls within the damping window
+	Until      time.Time // when it's released, unless it changes again
+}
+
+// RouteDamper keeps subnet routes advertised by flapping subnet routers
+// out of the Config given to a Router, so that the system routing table
+// doesn't churn each time the route is withdrawn and re-advertised.
+//
+// A route that's reinstalled more than MaxReinstalls times within
+// Window is held down, uninstalled, until HoldDown has passed without
+// it changing. The routes to peers' own Tailscale IPs and default
+// routes are never dampened.
+type RouteDamper struct {
+	MaxReinstalls int
+	Window        time.Duration
+	HoldDown      time.Duration
+
+	logf     logger.Logf
+	onChange func()
+
+	mu      sync.Mutex
+	routes  map[netip.Prefix]*routeDamping
+	timer   *time.Timer
+	timeNow func() time.Time // or nil for time.Now
+}
+
+type routeDamping struct {
+	present    bool        // whether the route was in the last Config
+	reinstalls []time.Time // times it was reinstalled within the window
+	lastChange time.Time
+	heldUntil  time.Time // zero if not dampened
+}
+
+// NewRouteDamper returns a new RouteDamper with the default limits.
+// onChange is called, in its own goroutine, when a dampened route's
+// hold-down ends, so the caller can pass its last Config through Damp
+// again.
+func NewRouteDamper(logf logger.Logf, onChange func()) *RouteDamper {
+	return &RouteDamper{
+		MaxReinstalls: DefaultMaxReinstalls,
+		Window:        DefaultDampingWindow,
+		HoldDown:      DefaultHoldDown,
+		logf:          logger.WithPrefix(logf, "router: damping: "),
+		onChange:      onChange,
+		routes:        map[netip.Prefix]*routeDamping{},
+	}
+}
+
+func (d *RouteDamper) now() time.Time {
+	if d.timeNow != nil {
+		return d.timeNow()
+	}
+	return time.Now()
+}
+
+// dampable reports whether r is a subnet route that may be dampened.
+func dampable(r netip.Prefix) bool {
+	if r.Bits() == 0 {
+		return false
+	}
+	return !(r.IsSingleIP() && tsaddr.IsTailscaleIP(r.Addr()))
+}
+
+// Damp records the routes in cfg and returns cfg without the routes
+// being held down. It returns cfg itself if none are.
+func (d *RouteDamper) Damp(cfg *Config) *Config {
+	if cfg == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := d.now()
+
+	want := map[netip.Prefix]bool{}
+	for _, r := range cfg.Routes {
+		if dampable(r) {
+			want[r] = true
+		}
+	}
+	for r := range want {
+		if _, ok := d.routes[r]; !ok {
+			// First seen; installing it isn't a flap.
+			d.routes[r] = &routeDamping{present: true, lastChange: now}
+		}
+	}
+	held := map[netip.Prefix]bool{}
+	var next time.Time
+	for r, st := range d.routes {
+		d.updateLocked(r, st, want[r], now)
+		if !st.heldUntil.IsZero() {
+			held[r] = true
+			if next.IsZero() || st.heldUntil.Before(next) {
+				next = st.heldUntil
+			}
+		} else if !st.present && now.Sub(st.lastChange) > d.Window {
+			// Withdrawn long enough that re-advertising it
+			// wouldn't count as a flap.
+			delete(d.routes, r)
+		}
+	}
+	d.scheduleLocked(next, now)
+
+	if len(held) == 0 {
+		return cfg
+	}
+	ret := *cfg
+	ret.Routes = make([]netip.Prefix, 0, len(cfg.Routes))
+	for _, r := range cfg.Routes {
+		if !held[r] {
+			ret.Routes = append(ret.Routes, r)
+		}
+	}
+	return &ret
+}
+
+// updateLocked updates the damping state st of route r, which is
+// present in the latest Config or not.
+//
+// d.mu must be held.
+func (d *RouteDamper) updateLocked(r netip.Prefix, st *routeDamping, present bool, now time.Time) {
+	i := 0
+	for i < len(st.reinstalls) && now.Sub(st.reinstalls[i]) > d.Window {
+		i++
+	}
+	st.reinstalls = st.reinstalls[i:]
+
+	if present != st.present {
+		st.present = present
+		st.lastChange = now
+		if present {
+			st.reinstalls = append(st.reinstalls, now)
+		}
+		if !st.heldUntil.IsZero() {
+			st.heldUntil = now.Add(d.HoldDown)
+			d.logf("%v %s while held down; holding until %v", r, presence(present), st.heldUntil.Format(time.RFC3339))
+			return
+		}
+		if len(st.reinstalls) > d.MaxReinstalls {
+			st.heldUntil = now.Add(d.HoldDown)
+			d.logf("%v reinstalled %d times in %v; holding down until %v", r, len(st.reinstalls), d.Window, st.heldUntil.Format(time.RFC3339))
+			return
+		}
+		d.logf("[v1] %v %s", r, presence(present))
+		return
+	}
+	if !st.heldUntil.IsZero() && !now.Before(st.heldUntil) {
+		st.heldUntil = time.Time{}
+		st.reinstalls = nil
+		if present {
+			d.logf("%v stable for %v; reinstalling", r, d.HoldDown)
+		} else {
+			d.logf("%v stable for %v; released", r, d.HoldDown)
+		}
+	}
+}
+
+func presence(present bool) string {
+	if present {
+		return "advertised"
+	}
+	return "withdrawn"
+}
+
+// scheduleLocked arranges for onChange to be called at next, replacing
+// any earlier schedule. A zero next cancels it.
+//
+// d.mu must be held.
+func (d *RouteDamper) scheduleLocked(next, now time.Time) {
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	if next.IsZero() || d.onChange == nil {
+		return
+	}
+	d.timer = time.AfterFunc(next.Sub(now), d.onChange)
+}
+
+// Dampened returns the routes being held down, sorted.
+func (d *RouteDamper) Dampened() []DampenedRoute {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var ret []DampenedRoute
+	for r, st := range d.routes {
+		if !st.heldUntil.IsZero() {
+			ret = append(ret, DampenedRoute{
+				Route:      r,
+				Reinstalls: len(st.reinstalls),
+				Until:      st.heldUntil,
+			})
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		a, b := ret[i].Route, ret[j].Route
+		if a.Addr() != b.Addr() {
+			return a.Addr().Less(b.Addr())
+		}
+		return a.Bits() < b.Bits()
+	})
+	return ret
+}
+
+// Close stops any pending onChange call.
+func (d *RouteDamper) Close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.scheduleLocked(time.Time{}, time.Time{})
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRouteDamper(t *testing.T) {
+	now := time.Unix(1_000_000, 0)
+	d := NewRouteDamper(t.Logf, nil)
+	d.timeNow = func() time.Time { return now }
+
+	peer := "100.64.0.1/32"
+	subnet := "10.0.0.0/24"
+	with := &Config{Routes: mustCIDRs(peer, subnet, "0.0.0.0/0")}
+	without := &Config{Routes: mustCIDRs(peer, "0.0.0.0/0")}
+	heldDown := mustCIDRs(peer, "0.0.0.0/0")
+
+	check := func(cfg *Config, want []netip.Prefix) {
+		t.Helper()
+		got := d.Damp(cfg)
+		if !reflect.DeepEqual(got.Routes, want) {
+			t.Fatalf("at %v: routes %v; want %v", now.Sub(time.Unix(1_000_000, 0)), got.Routes, want)
+		}
+	}
+
+	// Installing the route, then up to MaxReinstalls flaps, is fine.
+	check(with, with.Routes)
+	for i := 0; i < d.MaxReinstalls; i++ {
+		now = now.Add(time.Second)
+		check(without, without.Routes)
+		now = now.Add(time.Second)
+		check(with, with.Routes)
+	}
+	if got := d.Dampened(); len(got) != 0 {
+		t.Fatalf("dampened %v before limit", got)
+	}
+
+	// One more reinstall holds it down.
+	now = now.Add(time.Second)
+	check(without, without.Routes)
+	now = now.Add(time.Second)
+	check(with, heldDown)
+	got := d.Dampened()
+	if len(got) != 1 || got[0].Route != netip.MustParsePrefix(subnet) || got[0].Reinstalls != d.MaxReinstalls+1 {
+		t.Fatalf("dampened = %+v; want %v with %d reinstalls", got, subnet, d.MaxReinstalls+1)
+	}
+
+	// Flapping during the hold-down extends it.
+	now = now.Add(d.HoldDown / 2)
+	check(without, without.Routes)
+	now = now.Add(d.HoldDown / 2)
+	check(with, heldDown)
+	now = now.Add(d.HoldDown - time.Second)
+	check(with, heldDown)
+
+	// Once stable for HoldDown, it's reinstalled.
+	now = now.Add(time.Second)
+	check(with, with.Routes)
+	if got := d.Dampened(); len(got) != 0 {
+		t.Fatalf("still dampened: %v", got)
+	}
+}
+
+func TestRouteDamperUnchanged(t *testing.T) {
+	d := NewRouteDamper(t.Logf, nil)
+	cfg := &Config{Routes: mustCIDRs("100.64.0.1/32", "10.0.0.0/24")}
+	if got := d.Damp(cfg); got != cfg {
+		t.Errorf("Damp returned a copy with nothing held down")
+	}
+	if got := d.Damp(nil); got != nil {
+		t.Errorf("Damp(nil) = %v; want nil", got)
+	}
+}
@@ -437,9 +437,17 @@ func configureInterface(cfg *Config, tun *tun.NativeTun) (retErr error) {
 		if err != nil {
 			return fmt.Errorf("getting AF_INET interface: %w", err)
 		}
-		if foundDefault4 {
+		switch {
+		case cfg.RouteMetric != 0:
+			ipif4.UseAutomaticMetric = false
+			ipif4.Metric = uint32(cfg.RouteMetric)
+		case foundDefault4:
 			ipif4.UseAutomaticMetric = false
 			ipif4.Metric = 0
+		default:
+			// Go back to the automatic metric if a RouteMetric was
+			// set before and has since been cleared.
+			ipif4.UseAutomaticMetric = true
 		}
 		if mtu > 0 {
 			ipif4.NLMTU = uint32(mtu)
@@ -456,9 +464,17 @@ func configureInterface(cfg *Config, tun *tun.NativeTun) (retErr error) {
 		if err != nil {
 			return fmt.Errorf("getting AF_INET6 interface: %w", err)
 		} else {
-			if foundDefault6 {
+			switch {
+			case cfg.RouteMetric != 0:
+				ipif6.UseAutomaticMetric = false
+				ipif6.Metric = uint32(cfg.RouteMetric)
+			case foundDefault6:
 				ipif6.UseAutomaticMetric = false
 				ipif6.Metric = 0
+			default:
+				// Go back to the automatic metric if a RouteMetric was
+				// set before and has since been cleared.
+				ipif6.UseAutomaticMetric = true
 			}
 			if mtu > 0 {
 				ipif6.NLMTU = uint32(mtu)
@@ -7,8 +7,10 @@
 package router
 
 import (
+	"fmt"
 	"net/netip"
 	"reflect"
+	"strings"
 
 	"golang.zx2c4.com/wireguard/tun"
 	"tailscale.com/types/logger"
@@ -32,6 +34,73 @@ type Router interface {
 	Close() error
 }
 
+// RouteMetricGetter is implemented by Routers that can report the
+// metric of the routes they install.
+type RouteMetricGetter interface {
+	// RouteMetric returns the effective metric of the routes the
+	// router installs, and whether it's known.
+	RouteMetric() (metric int, ok bool)
+}
+
+// RouteErrorGetter is implemented by Routers that record which routes
+// they failed to install or remove.
+type RouteErrorGetter interface {
+	// RouteErrors returns the failures of the last call to Set, or
+	// nil if it installed and removed everything it needed to.
+	RouteErrors() RouteErrors
+}
+
+// RouteError is the failure to add or remove one route or address.
+type RouteError struct {
+	// Kind is what was being added or removed: "route" for a route
+	// into the Tailscale interface, "localRoute" for a route kept out
+	// of it, or "addr" for an address of the interface.
+	Kind string
+	// Op is "add" or "del".
+	Op    string
+	Route netip.Prefix
+	Err   error
+}
+
+func (e RouteError) Error() string {
+	return fmt.Sprintf("%s %s %v: %v", e.Op, e.Kind, e.Route, e.Err)
+}
+
+func (e RouteError) Unwrap() error { return e.Err }
+
+// maxRouteErrorsShown is how many of RouteErrors are named by its
+// Error method.
+const maxRouteErrorsShown = 5
+
+// RouteErrors are the routes and addresses a Router failed to add or
+// remove. As an error, it names each of them, up to a limit.
+type RouteErrors []RouteError
+
+func (e RouteErrors) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d route change(s) failed: ", len(e))
+	for i, re := range e {
+		if i == maxRouteErrorsShown {
+			fmt.Fprintf(&b, "; and %d more", len(e)-i)
+			break
+		}
+		if i > 0 {
+			b.WriteString("; ")
+		}
+		b.WriteString(re.Error())
+	}
+	return b.String()
+}
+
+// Unwrap returns the first failure, so that errors.Is and errors.As
+// see the cause of at least one of them.
+func (e RouteErrors) Unwrap() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e[0]
+}
+
 // New returns a new Router for the current platform, using the
 // provided tun device.
 //
@@ -68,10 +137,18 @@ type Config struct {
 	// routing rules apply.
 	LocalRoutes []netip.Prefix
 
+	// RouteMetric, if non-zero, is the metric of the routes in
+	// Routes: the route priority on Linux, and the interface metric on
+	// Windows. Zero means the OS default. It's ignored on other
+	// platforms.
+	RouteMetric int
+
 	// Linux-only things below, ignored on other platforms.
 	SubnetRoutes     []netip.Prefix         // subnets being advertised to other Tailscale nodes
 	SNATSubnetRoutes bool                   // SNAT traffic to local subnets
+	ClampMSS         bool                   // clamp the TCP MSS of forwarded connections to the path MTU
 	NetfilterMode    preftype.NetfilterMode // how much to manage netfilter rules
+	MTU              int                    // if non-zero, the MTU to set on the Tailscale interface
 }
 
 func (a *Config) Equal(b *Config) bool {
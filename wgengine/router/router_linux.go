@@ -12,6 +12,7 @@
 	"net/netip"
 	"os"
 	"os/exec"
+	"sort"
 	"strconv"
 	"strings"
 	"sync/atomic"
@@ -26,6 +27,7 @@
 	"golang.zx2c4.com/wireguard/tun"
 	"tailscale.com/envknob"
 	"tailscale.com/net/tsaddr"
+	"tailscale.com/syncs"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/preftype"
 	"tailscale.com/util/multierr"
@@ -93,7 +95,11 @@ type linuxRouter struct {
 	routes           map[netip.Prefix]bool
 	localRoutes      map[netip.Prefix]bool
 	snatSubnetRoutes bool
+	clampMSS         bool
 	netfilterMode    preftype.NetfilterMode
+	mtu              int                            // MTU last set on the interface; zero if none
+	routeMetric      atomic.Int64                   // priority of the routes in routes
+	routeErrs        syncs.AtomicValue[RouteErrors] // failures of the last Set
 
 	// ruleRestorePending is whether a timer has been started to
 	// restore deleted ip rules.
@@ -284,6 +290,17 @@ func (r *linuxRouter) Up() error {
 	return nil
 }
 
+// RouteErrors implements RouteErrorGetter.
+func (r *linuxRouter) RouteErrors() RouteErrors {
+	return r.routeErrs.Load()
+}
+
+// RouteMetric implements RouteMetricGetter. Routes installed without
+// a metric get the kernel's default priority of zero.
+func (r *linuxRouter) RouteMetric() (int, bool) {
+	return int(r.routeMetric.Load()), true
+}
+
 func (r *linuxRouter) Close() error {
 	r.closed.Store(true)
 	if r.unregLinkMon != nil {
@@ -320,24 +337,36 @@ func (r *linuxRouter) Set(cfg *Config) error {
 		errs = append(errs, err)
 	}
 
-	newLocalRoutes, err := cidrDiff("localRoute", r.localRoutes, cfg.LocalRoutes, r.addThrowRoute, r.delThrowRoute, r.logf)
-	if err != nil {
-		errs = append(errs, err)
-	}
+	var routeErrs RouteErrors
+	newLocalRoutes, rerrs := cidrDiff("localRoute", r.localRoutes, cfg.LocalRoutes, r.addThrowRoute, r.delThrowRoute, r.logf)
+	routeErrs = append(routeErrs, rerrs...)
 	r.localRoutes = newLocalRoutes
 
-	newRoutes, err := cidrDiff("route", r.routes, cfg.Routes, r.addRoute, r.delRoute, r.logf)
-	if err != nil {
-		errs = append(errs, err)
+	if int64(cfg.RouteMetric) != r.routeMetric.Load() {
+		// The kernel treats routes that differ only in priority as
+		// distinct, so remove the existing ones and let cidrDiff
+		// add them back with the new priority.
+		for cidr := range r.routes {
+			if err := r.delRoute(cidr); err != nil {
+				r.logf("route metric change: deleting route %v: %v", cidr, err)
+			}
+		}
+		r.routes = nil
+		r.routeMetric.Store(int64(cfg.RouteMetric))
 	}
+	newRoutes, rerrs := cidrDiff("route", r.routes, cfg.Routes, r.addRoute, r.delRoute, r.logf)
+	routeErrs = append(routeErrs, rerrs...)
 	r.routes = newRoutes
 
-	newAddrs, err := cidrDiff("addr", r.addrs, cfg.LocalAddrs, r.addAddress, r.delAddress, r.logf)
-	if err != nil {
-		errs = append(errs, err)
-	}
+	newAddrs, rerrs := cidrDiff("addr", r.addrs, cfg.LocalAddrs, r.addAddress, r.delAddress, r.logf)
+	routeErrs = append(routeErrs, rerrs...)
 	r.addrs = newAddrs
 
+	r.routeErrs.Store(routeErrs)
+	if len(routeErrs) > 0 {
+		errs = append(errs, routeErrs)
+	}
+
 	switch {
 	case cfg.SNATSubnetRoutes == r.snatSubnetRoutes:
 		// state already correct, nothing to do.
@@ -352,13 +381,36 @@ func (r *linuxRouter) Set(cfg *Config) error {
 	}
 	r.snatSubnetRoutes = cfg.SNATSubnetRoutes
 
+	switch {
+	case cfg.ClampMSS == r.clampMSS:
+		// state already correct, nothing to do.
+	case cfg.ClampMSS:
+		if err := r.addClampMSSRules(); err != nil {
+			errs = append(errs, err)
+		}
+	default:
+		if err := r.delClampMSSRules(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	r.clampMSS = cfg.ClampMSS
+
+	if cfg.MTU != 0 && cfg.MTU != r.mtu {
+		if err := r.setMTU(cfg.MTU); err != nil {
+			errs = append(errs, err)
+		} else {
+			r.mtu = cfg.MTU
+		}
+	}
+
 	return multierr.New(errs...)
 }
 
 // setNetfilterMode switches the router to the given netfilter
 // mode. Netfilter state is created or deleted appropriately to
-// reflect the new mode, and r.snatSubnetRoutes is updated to reflect
-// the current state of subnet SNATing.
+// reflect the new mode, and r.snatSubnetRoutes and r.clampMSS are
+// updated to reflect the current state of subnet SNATing and MSS
+// clamping.
 func (r *linuxRouter) setNetfilterMode(mode preftype.NetfilterMode) error {
 	if distro.Get() == distro.Synology {
 		mode = netfilterOff
@@ -404,6 +456,7 @@ func (r *linuxRouter) setNetfilterMode(mode preftype.NetfilterMode) error {
 			}
 		}
 		r.snatSubnetRoutes = false
+		r.clampMSS = false
 	case netfilterNoDivert:
 		switch r.netfilterMode {
 		case netfilterOff:
@@ -415,6 +468,7 @@ func (r *linuxRouter) setNetfilterMode(mode preftype.NetfilterMode) error {
 				return err
 			}
 			r.snatSubnetRoutes = false
+			r.clampMSS = false
 		case netfilterOn:
 			if err := r.delNetfilterHooks(); err != nil {
 				return err
@@ -443,6 +497,7 @@ func (r *linuxRouter) setNetfilterMode(mode preftype.NetfilterMode) error {
 				return err
 			}
 			r.snatSubnetRoutes = false
+			r.clampMSS = false
 		case netfilterNoDivert:
 			reprocess = true
 			if err := r.delNetfilterBase(); err != nil {
@@ -455,6 +510,7 @@ func (r *linuxRouter) setNetfilterMode(mode preftype.NetfilterMode) error {
 				return err
 			}
 			r.snatSubnetRoutes = false
+			r.clampMSS = false
 		}
 	default:
 		panic("unhandled netfilter mode")
@@ -579,7 +635,7 @@ func (r *linuxRouter) addRoute(cidr netip.Prefix) error {
 		return nil
 	}
 	if r.useIPCommand() {
-		return r.addRouteDef([]string{normalizeCIDR(cidr), "dev", r.tunname}, cidr)
+		return r.addRouteDef(r.routeDef(cidr), cidr)
 	}
 	linkIndex, err := r.linkIndex()
 	if err != nil {
@@ -589,9 +645,20 @@ func (r *linuxRouter) addRoute(cidr netip.Prefix) error {
 		LinkIndex: linkIndex,
 		Dst:       netipx.PrefixIPNet(cidr.Masked()),
 		Table:     r.routeTable(),
+		Priority:  int(r.routeMetric.Load()),
 	})
 }
 
+// routeDef returns the "ip route" arguments describing the route for
+// cidr into the Tailscale interface.
+func (r *linuxRouter) routeDef(cidr netip.Prefix) []string {
+	def := []string{normalizeCIDR(cidr), "dev", r.tunname}
+	if m := r.routeMetric.Load(); m != 0 {
+		def = append(def, "metric", strconv.FormatInt(m, 10))
+	}
+	return def
+}
+
 // addThrowRoute adds a throw route for the provided cidr.
 // This has the effect that lookup in the routing table is terminated
 // pretending that no route was found. Fails if the route already exists,
@@ -656,7 +723,7 @@ func (r *linuxRouter) delRoute(cidr netip.Prefix) error {
 		return nil
 	}
 	if r.useIPCommand() {
-		return r.delRouteDef([]string{normalizeCIDR(cidr), "dev", r.tunname}, cidr)
+		return r.delRouteDef(r.routeDef(cidr), cidr)
 	}
 	linkIndex, err := r.linkIndex()
 	if err != nil {
@@ -666,6 +733,7 @@ func (r *linuxRouter) delRoute(cidr netip.Prefix) error {
 		LinkIndex: linkIndex,
 		Dst:       netipx.PrefixIPNet(cidr.Masked()),
 		Table:     r.routeTable(),
+		Priority:  int(r.routeMetric.Load()),
 	})
 	if errors.Is(err, errESRCH) {
 		// Didn't exist to begin with.
@@ -778,6 +846,18 @@ func (r *linuxRouter) upInterface() error {
 	return netlink.LinkSetUp(link)
 }
 
+// setMTU sets the MTU of the tunnel interface.
+func (r *linuxRouter) setMTU(mtu int) error {
+	if r.useIPCommand() {
+		return r.cmd.run("ip", "link", "set", "dev", r.tunname, "mtu", strconv.Itoa(mtu))
+	}
+	link, err := r.link()
+	if err != nil {
+		return fmt.Errorf("setting interface MTU, %w", err)
+	}
+	return netlink.LinkSetMTU(link, mtu)
+}
+
 // downInterface sets the tunnel interface administratively down.
 func (r *linuxRouter) downInterface() error {
 	if r.useIPCommand() {
@@ -1405,11 +1485,56 @@ func (r *linuxRouter) delSNATRule() error {
 	return nil
 }
 
+// clampMSSRules returns the netfilter rules, in filter/ts-forward,
+// that clamp the MSS of TCP connections forwarded into or out of the
+// Tailscale interface to the path MTU.
+func (r *linuxRouter) clampMSSRules() [][]string {
+	var ret [][]string
+	for _, dir := range []string{"-i", "-o"} {
+		ret = append(ret, []string{dir, r.tunname, "-p", "tcp", "--tcp-flags", "SYN,RST", "SYN", "-j", "TCPMSS", "--clamp-mss-to-pmtu"})
+	}
+	return ret
+}
+
+// addClampMSSRules adds netfilter rules clamping the MSS of forwarded
+// TCP connections. They go first in ts-forward, ahead of the rules
+// that accept forwarded traffic.
+func (r *linuxRouter) addClampMSSRules() error {
+	if r.netfilterMode == netfilterOff {
+		return nil
+	}
+	for _, ipt := range r.netfilterFamilies() {
+		for i, args := range r.clampMSSRules() {
+			if err := ipt.Insert("filter", "ts-forward", i+1, args...); err != nil {
+				return fmt.Errorf("adding %v in filter/ts-forward: %w", args, err)
+			}
+		}
+	}
+	return nil
+}
+
+// delClampMSSRules removes the netfilter rules clamping the MSS of
+// forwarded TCP connections.
+func (r *linuxRouter) delClampMSSRules() error {
+	if r.netfilterMode == netfilterOff {
+		return nil
+	}
+	for _, ipt := range r.netfilterFamilies() {
+		for _, args := range r.clampMSSRules() {
+			if err := ipt.Delete("filter", "ts-forward", args...); err != nil {
+				return fmt.Errorf("deleting %v in filter/ts-forward: %w", args, err)
+			}
+		}
+	}
+	return nil
+}
+
 // cidrDiff calls add and del as needed to make the set of prefixes in
 // old and new match. Returns a map reflecting the actual new state
 // (which may be somewhere in between old and new if some commands
-// failed), and any error encountered while reconfiguring.
-func cidrDiff(kind string, old map[netip.Prefix]bool, new []netip.Prefix, add, del func(netip.Prefix) error, logf logger.Logf) (map[netip.Prefix]bool, error) {
+// failed), and the prefixes that couldn't be added or deleted. If any
+// deletes fail, no adds are attempted.
+func cidrDiff(kind string, old map[netip.Prefix]bool, new []netip.Prefix, add, del func(netip.Prefix) error, logf logger.Logf) (map[netip.Prefix]bool, RouteErrors) {
 	newMap := make(map[netip.Prefix]bool, len(new))
 	for _, cidr := range new {
 		newMap[cidr] = true
@@ -1423,46 +1548,45 @@ func cidrDiff(kind string, old map[netip.Prefix]bool, new []netip.Prefix, add, d
 		ret[cidr] = true
 	}
 
-	var delFail []error
+	var delFail RouteErrors
 	for cidr := range old {
 		if newMap[cidr] {
 			continue
 		}
 		if err := del(cidr); err != nil {
-			logf("%s del failed: %v", kind, err)
-			delFail = append(delFail, err)
+			logf("%s del of %v failed: %v", kind, cidr, err)
+			delFail = append(delFail, RouteError{Kind: kind, Op: "del", Route: cidr, Err: err})
 		} else {
 			delete(ret, cidr)
 		}
 	}
-	if len(delFail) == 1 {
-		return ret, delFail[0]
-	}
 	if len(delFail) > 0 {
-		return ret, fmt.Errorf("%d delete %s failures; first was: %w", len(delFail), kind, delFail[0])
+		sortRouteErrors(delFail)
+		return ret, delFail
 	}
 
-	var addFail []error
+	var addFail RouteErrors
 	for cidr := range newMap {
 		if old[cidr] {
 			continue
 		}
 		if err := add(cidr); err != nil {
-			logf("%s add failed: %v", kind, err)
-			addFail = append(addFail, err)
+			logf("%s add of %v failed: %v", kind, cidr, err)
+			addFail = append(addFail, RouteError{Kind: kind, Op: "add", Route: cidr, Err: err})
 		} else {
 			ret[cidr] = true
 		}
 	}
+	sortRouteErrors(addFail)
+	return ret, addFail
+}
 
-	if len(addFail) == 1 {
-		return ret, addFail[0]
-	}
-	if len(addFail) > 0 {
-		return ret, fmt.Errorf("%d add %s failures; first was: %w", len(addFail), kind, addFail[0])
-	}
-
-	return ret, nil
+// sortRouteErrors sorts errs by route, as cidrDiff makes them in map
+// order.
+func sortRouteErrors(errs RouteErrors) {
+	sort.Slice(errs, func(i, j int) bool {
+		return errs[i].Route.String() < errs[j].Route.String()
+	})
 }
 
 // tsChain returns the name of the tailscale sub-chain corresponding
@@ -10,9 +10,12 @@
 	"math/rand"
 	"net/netip"
 	"os"
+	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 	"sync/atomic"
+	"syscall"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -70,6 +73,21 @@ func TestRouterStates(t *testing.T) {
 ip route add 192.168.16.0/24 dev tailscale0 table 52` + basic,
 		},
 
+		{
+			name: "addr and routes with metric",
+			in: &Config{
+				LocalAddrs:    mustCIDRs("100.101.102.103/10"),
+				Routes:        mustCIDRs("100.100.100.100/32", "192.168.16.0/24"),
+				RouteMetric:   100,
+				NetfilterMode: netfilterOff,
+			},
+			want: `
+up
+ip addr add 100.101.102.103/10 dev tailscale0
+ip route add 100.100.100.100/32 dev tailscale0 metric 100 table 52
+ip route add 192.168.16.0/24 dev tailscale0 metric 100 table 52` + basic,
+		},
+
 		{
 			name: "addr and routes and subnet routes",
 			in: &Config{
@@ -180,6 +198,45 @@ func TestRouterStates(t *testing.T) {
 v6/filter/ts-forward -m mark --mark 0x40000 -j ACCEPT
 v6/filter/ts-forward -o tailscale0 -j ACCEPT
 v6/nat/POSTROUTING -j ts-postrouting
+`,
+		},
+		{
+			name: "subnet routes with netfilter and MSS clamping",
+			in: &Config{
+				LocalAddrs:       mustCIDRs("100.101.102.104/10"),
+				Routes:           mustCIDRs("100.100.100.100/32", "10.0.0.0/8"),
+				SubnetRoutes:     mustCIDRs("200.0.0.0/8"),
+				SNATSubnetRoutes: true,
+				ClampMSS:         true,
+				NetfilterMode:    netfilterOn,
+			},
+			want: `
+up
+ip addr add 100.101.102.104/10 dev tailscale0
+ip route add 10.0.0.0/8 dev tailscale0 table 52
+ip route add 100.100.100.100/32 dev tailscale0 table 52` + basic +
+				`v4/filter/FORWARD -j ts-forward
+v4/filter/INPUT -j ts-input
+v4/filter/ts-forward -i tailscale0 -p tcp --tcp-flags SYN,RST SYN -j TCPMSS --clamp-mss-to-pmtu
+v4/filter/ts-forward -o tailscale0 -p tcp --tcp-flags SYN,RST SYN -j TCPMSS --clamp-mss-to-pmtu
+v4/filter/ts-forward -i tailscale0 -j MARK --set-mark 0x40000
+v4/filter/ts-forward -m mark --mark 0x40000 -j ACCEPT
+v4/filter/ts-forward -o tailscale0 -s 100.64.0.0/10 -j DROP
+v4/filter/ts-forward -o tailscale0 -j ACCEPT
+v4/filter/ts-input -i lo -s 100.101.102.104 -j ACCEPT
+v4/filter/ts-input ! -i tailscale0 -s 100.115.92.0/23 -j RETURN
+v4/filter/ts-input ! -i tailscale0 -s 100.64.0.0/10 -j DROP
+v4/nat/POSTROUTING -j ts-postrouting
+v4/nat/ts-postrouting -m mark --mark 0x40000 -j MASQUERADE
+v6/filter/FORWARD -j ts-forward
+v6/filter/INPUT -j ts-input
+v6/filter/ts-forward -i tailscale0 -p tcp --tcp-flags SYN,RST SYN -j TCPMSS --clamp-mss-to-pmtu
+v6/filter/ts-forward -o tailscale0 -p tcp --tcp-flags SYN,RST SYN -j TCPMSS --clamp-mss-to-pmtu
+v6/filter/ts-forward -i tailscale0 -j MARK --set-mark 0x40000
+v6/filter/ts-forward -m mark --mark 0x40000 -j ACCEPT
+v6/filter/ts-forward -o tailscale0 -j ACCEPT
+v6/nat/POSTROUTING -j ts-postrouting
+v6/nat/ts-postrouting -m mark --mark 0x40000 -j MASQUERADE
 `,
 		},
 		{
@@ -359,6 +416,41 @@ func TestRouterStates(t *testing.T) {
 	}
 }
 
+func TestRouterMTU(t *testing.T) {
+	mon, err := monitor.New(logger.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mon.Start()
+	defer mon.Close()
+
+	fake := NewFakeOS(t)
+	router, err := newUserspaceRouterAdvanced(t.Logf, "tailscale0", mon, fake.netfilter4, fake.netfilter6, fake, true, true)
+	if err != nil {
+		t.Fatalf("failed to create router: %v", err)
+	}
+	if err := router.Up(); err != nil {
+		t.Fatalf("failed to up router: %v", err)
+	}
+
+	for _, tt := range []struct {
+		mtu  int
+		want int
+	}{
+		{0, 0},       // left alone
+		{1280, 1280}, // lowered
+		{0, 1280},    // still left alone
+		{1420, 1420}, // restored
+	} {
+		if err := router.Set(&Config{MTU: tt.mtu}); err != nil {
+			t.Fatalf("Set(MTU %v): %v", tt.mtu, err)
+		}
+		if fake.mtu != tt.want {
+			t.Errorf("after Set(MTU %v), mtu = %v; want %v", tt.mtu, fake.mtu, tt.want)
+		}
+	}
+}
+
 type fakeNetfilter struct {
 	t *testing.T
 	n map[string][]string
@@ -475,6 +567,7 @@ func (n *fakeNetfilter) DeleteChain(table, chain string) error {
 type fakeOS struct {
 	t          *testing.T
 	up         bool
+	mtu        int
 	ips        []string
 	routes     []string
 	rules      []string
@@ -571,7 +664,15 @@ func (o *fakeOS) run(args ...string) error {
 		case "set dev tailscale0 down":
 			o.up = false
 		default:
-			return unexpected()
+			const prefix = "set dev tailscale0 mtu "
+			if !strings.HasPrefix(got, prefix) {
+				return unexpected()
+			}
+			n, err := strconv.Atoi(strings.TrimPrefix(got, prefix))
+			if err != nil {
+				return unexpected()
+			}
+			o.mtu = n
 		}
 		return nil
 	case "addr":
@@ -811,3 +912,44 @@ func TestCheckIPRuleSupportsV6(t *testing.T) {
 	// Some machines running our tests might not have IPv6.
 	t.Logf("Got: %v", err)
 }
+
+func TestCIDRDiffErrors(t *testing.T) {
+	pfx := netip.MustParsePrefix
+	bad := map[netip.Prefix]error{
+		pfx("10.0.0.0/24"): syscall.EEXIST,
+		pfx("10.0.2.0/24"): syscall.EPERM,
+		pfx("10.9.0.0/16"): syscall.EPERM,
+	}
+	op := func(cidr netip.Prefix) error { return bad[cidr] }
+
+	old := map[netip.Prefix]bool{pfx("10.0.3.0/24"): true}
+	got, errs := cidrDiff("route", old, []netip.Prefix{pfx("10.0.0.0/24"), pfx("10.0.1.0/24"), pfx("10.0.2.0/24")}, op, op, t.Logf)
+	want := map[netip.Prefix]bool{pfx("10.0.1.0/24"): true}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("state = %v; want %v", got, want)
+	}
+	wantErrs := RouteErrors{
+		{Kind: "route", Op: "add", Route: pfx("10.0.0.0/24"), Err: syscall.EEXIST},
+		{Kind: "route", Op: "add", Route: pfx("10.0.2.0/24"), Err: syscall.EPERM},
+	}
+	if !reflect.DeepEqual(errs, wantErrs) {
+		t.Errorf("errors = %v; want %v", errs, wantErrs)
+	}
+	if !errors.Is(errs, syscall.EEXIST) {
+		t.Errorf("errors.Is(%v, EEXIST) = false", errs)
+	}
+	const wantMsg = "2 route change(s) failed: add route 10.0.0.0/24: file exists; add route 10.0.2.0/24: operation not permitted"
+	if errs.Error() != wantMsg {
+		t.Errorf("Error() = %q; want %q", errs.Error(), wantMsg)
+	}
+
+	// A failed delete is kept, and nothing is added.
+	old = map[netip.Prefix]bool{pfx("10.9.0.0/16"): true}
+	got, errs = cidrDiff("addr", old, []netip.Prefix{pfx("10.0.1.0/24")}, op, op, t.Logf)
+	if want := old; !reflect.DeepEqual(got, want) {
+		t.Errorf("state after failed delete = %v; want %v", got, want)
+	}
+	if len(errs) != 1 || errs[0].Op != "del" || errs[0].Kind != "addr" {
+		t.Errorf("errors after failed delete = %v", errs)
+	}
+}
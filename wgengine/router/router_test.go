@@ -22,8 +22,8 @@ func mustCIDRs(ss ...string) []netip.Prefix {
 
 func TestConfigEqual(t *testing.T) {
 	testedFields := []string{
-		"LocalAddrs", "Routes", "LocalRoutes", "SubnetRoutes",
-		"SNATSubnetRoutes", "NetfilterMode",
+		"LocalAddrs", "Routes", "LocalRoutes", "RouteMetric", "SubnetRoutes",
+		"SNATSubnetRoutes", "ClampMSS", "NetfilterMode", "MTU",
 	}
 	configType := reflect.TypeOf(Config{})
 	configFields := []string{}
@@ -103,6 +103,17 @@ func TestConfigEqual(t *testing.T) {
 			true,
 		},
 
+		{
+			&Config{RouteMetric: 0},
+			&Config{RouteMetric: 100},
+			false,
+		},
+		{
+			&Config{RouteMetric: 100},
+			&Config{RouteMetric: 100},
+			true,
+		},
+
 		{
 			&Config{SubnetRoutes: nets("100.1.27.0/24")},
 			&Config{SubnetRoutes: nets("100.2.19.0/24")},
@@ -125,6 +136,12 @@ func TestConfigEqual(t *testing.T) {
 			true,
 		},
 
+		{
+			&Config{ClampMSS: false},
+			&Config{ClampMSS: true},
+			false,
+		},
+
 		{
 			&Config{NetfilterMode: preftype.NetfilterOff},
 			&Config{NetfilterMode: preftype.NetfilterNoDivert},
@@ -135,6 +152,12 @@ func TestConfigEqual(t *testing.T) {
 			&Config{NetfilterMode: preftype.NetfilterNoDivert},
 			true,
 		},
+
+		{
+			&Config{MTU: 1280},
+			&Config{MTU: 1400},
+			false,
+		},
 	}
 	for i, tt := range tests {
 		got := tt.a.Equal(tt.b)
@@ -102,6 +102,16 @@ func hasDefaultRoute(routes []netip.Prefix) bool {
 	return false
 }
 
+// RouteMetric implements RouteMetricGetter, returning the IPv4 metric
+// of the Tailscale interface.
+func (r *winRouter) RouteMetric() (int, bool) {
+	ipif, err := winipcfg.LUID(r.nativeTun.LUID()).IPInterface(windows.AF_INET)
+	if err != nil {
+		return 0, false
+	}
+	return int(ipif.Metric), true
+}
+
 func (r *winRouter) Close() error {
 	r.firewall.clear()
 
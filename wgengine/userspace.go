@@ -41,7 +41,9 @@
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/netmap"
+	"tailscale.com/types/nettype"
 	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/crashreport"
 	"tailscale.com/util/deephash"
 	"tailscale.com/util/mak"
 	"tailscale.com/version"
@@ -100,6 +102,7 @@ type userspaceEngine struct {
 	linkMonOwned      bool       // whether we created linkMon (and thus need to close it)
 	linkMonUnregister func()     // unsubscribes from changes; used regardless of linkMonOwned
 	birdClient        BIRDClient // or nil
+	routeDamper       *router.RouteDamper
 
 	testMaybeReconfigHook func() // for tests; if non-nil, fires if maybeReconfigWireguardLocked called
 
@@ -119,7 +122,8 @@ type userspaceEngine struct {
 	lastEngineSigFull   deephash.Sum // of full wireguard config
 	lastEngineSigTrim   deephash.Sum // of trimmed wireguard config
 	lastDNSConfig       *dns.Config
-	lastIsSubnetRouter  bool // was the node a primary subnet router in the last run.
+	lastRouterConfig    *router.Config // as passed to Reconfig, before route damping
+	lastIsSubnetRouter  bool           // was the node a primary subnet router in the last run.
 	recvActivityAt      map[key.NodePublic]mono.Time
 	trimmedNodes        map[key.NodePublic]bool   // set of node keys of peers currently excluded from wireguard config
 	sentActivityAt      map[netip.Addr]*mono.Time // value is accessed atomically
@@ -145,6 +149,11 @@ type userspaceEngine struct {
 	// value of the ICMP identifer and sequence number concatenated.
 	icmpEchoResponseCallback map[uint32]func()
 
+	// icmpErrorCallback is the map of handlers waiting for ICMP errors,
+	// such as Time Exceeded, in reply to ICMP echo requests. It's keyed
+	// like icmpEchoResponseCallback.
+	icmpErrorCallback map[uint32]func(*packet.Parsed)
+
 	// Lock ordering: magicsock.Conn.mu, wgLock, then mu.
 }
 
@@ -167,6 +176,43 @@ type ResolvingEngine interface {
 	_ ResolvingEngine = (*watchdogEngine)(nil)
 )
 
+// RouterStateGetter is implemented by Engines that can report the
+// routing configuration they last applied.
+type RouterStateGetter interface {
+	RouterState() *ipnstate.RouterState
+}
+
+var (
+	_ RouterStateGetter = (*userspaceEngine)(nil)
+	_ RouterStateGetter = (*watchdogEngine)(nil)
+)
+
+func (e *userspaceEngine) RouterState() *ipnstate.RouterState {
+	st := new(ipnstate.RouterState)
+	e.wgLock.Lock()
+	if cfg := e.lastRouterConfig; cfg != nil {
+		st.Routes = cfg.Routes
+		st.LocalRoutes = cfg.LocalRoutes
+		st.LocalAddrs = cfg.LocalAddrs
+	}
+	e.wgLock.Unlock()
+	if rg, ok := e.router.(router.RouteMetricGetter); ok {
+		if m, ok := rg.RouteMetric(); ok {
+			st.RouteMetric = &m
+		}
+	}
+	for _, r := range e.routeDamper.Dampened() {
+		st.DampenedRoutes = append(st.DampenedRoutes, ipnstate.DampenedRoute{Route: r.Route, Reinstalls: r.Reinstalls, Until: r.Until})
+	}
+	if rg, ok := e.router.(router.RouteErrorGetter); ok {
+		st.ErrorsTracked = true
+		for _, re := range rg.RouteErrors() {
+			st.Errors = append(st.Errors, ipnstate.RouteError{Kind: re.Kind, Op: re.Op, Route: re.Route, Err: re.Err.Error()})
+		}
+	}
+	return st
+}
+
 func (e *userspaceEngine) GetResolver() (r *resolver.Resolver, ok bool) {
 	return e.dns.Resolver(), true
 }
@@ -209,6 +255,12 @@ type Config struct {
 	// If zero, a port is automatically selected.
 	ListenPort uint16
 
+	// PacketConn4 and PacketConn6, if non-nil, are already bound UDP
+	// sockets to listen on instead, such as ones passed by systemd.
+	// See magicsock.Options.
+	PacketConn4 nettype.PacketConn
+	PacketConn6 nettype.PacketConn
+
 	// RespondToPing determines whether this engine should internally
 	// reply to ICMP pings, without involving the OS.
 	// Used in "fake" mode for development.
@@ -304,6 +356,7 @@ func NewUserspaceEngine(logf logger.Logf, conf Config) (_ Engine, reterr error)
 		confListenPort: conf.ListenPort,
 		birdClient:     conf.BIRDClient,
 	}
+	e.routeDamper = router.NewRouteDamper(logf, e.reapplyDampedRoutes)
 
 	if e.birdClient != nil {
 		// Disable the protocol at start time.
@@ -355,6 +408,8 @@ func NewUserspaceEngine(logf logger.Logf, conf Config) (_ Engine, reterr error)
 		IdleFunc:         e.tundev.IdleDuration,
 		NoteRecvActivity: e.noteRecvActivity,
 		LinkMonitor:      e.linkMon,
+		PacketConn4:      conf.PacketConn4,
+		PacketConn6:      conf.PacketConn6,
 	}
 
 	var err error
@@ -369,6 +424,13 @@ func NewUserspaceEngine(logf logger.Logf, conf Config) (_ Engine, reterr error)
 
 	if conf.RespondToPing {
 		e.tundev.PostFilterIn = echoRespondToAll
+	} else if !debugHostAnswersPing {
+		// Answer pings to our own Tailscale IPs ourselves, so they
+		// work the same in every mode, even when the OS's firewall
+		// drops ICMP or there's no OS stack involved at all.
+		e.tundev.RespondToEcho = func(ip netip.Addr) bool {
+			return e.isLocalAddr.Load()(ip)
+		}
 	}
 	e.tundev.PreFilterFromTunToEngine = e.handleLocalPackets
 
@@ -390,7 +452,7 @@ func NewUserspaceEngine(logf logger.Logf, conf Config) (_ Engine, reterr error)
 		cb := e.pongCallback[pong.Data]
 		e.logf("wgengine: got TSMP pong %02x, peerAPIPort=%v; cb=%v", pong.Data, pong.PeerAPIPort, cb != nil)
 		if cb != nil {
-			go cb(pong)
+			crashreport.Go(func() { cb(pong) })
 		}
 	}
 
@@ -404,7 +466,25 @@ func NewUserspaceEngine(logf logger.Logf, conf Config) (_ Engine, reterr error)
 			return false
 		}
 		e.logf("wgengine: got diagnostic ICMP response %02x", idSeq)
-		go cb()
+		crashreport.Go(cb)
+		return true
+	}
+
+	e.tundev.OnICMPErrorReceived = func(p *packet.Parsed) bool {
+		idSeq, ok := p.ErrorEchoIDSeq()
+		if !ok {
+			return false
+		}
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		cb := e.icmpErrorCallback[idSeq]
+		if cb == nil {
+			return false
+		}
+		// p is reused after we return; pass the callback a copy.
+		pc := new(packet.Parsed)
+		pc.Decode(append([]byte(nil), p.Buffer()...))
+		crashreport.Go(func() { cb(pc) })
 		return true
 	}
 
@@ -418,7 +498,7 @@ func NewUserspaceEngine(logf logger.Logf, conf Config) (_ Engine, reterr error)
 		}
 	})
 
-	go func() {
+	crashreport.Go(func() {
 		up := false
 		for event := range e.tundev.EventsUpDown() {
 			if event&tun.EventUp != 0 && !up {
@@ -432,7 +512,7 @@ func NewUserspaceEngine(logf logger.Logf, conf Config) (_ Engine, reterr error)
 				up = false
 			}
 		}
-	}()
+	})
 
 	e.logf("Bringing WireGuard device up...")
 	if err := e.wgdev.Up(); err != nil {
@@ -453,7 +533,7 @@ func NewUserspaceEngine(logf logger.Logf, conf Config) (_ Engine, reterr error)
 	e.logf("Starting link monitor...")
 	e.linkMon.Start()
 
-	go e.pollResolver()
+	crashreport.Go(e.pollResolver)
 
 	e.logf("Engine created.")
 	return e, nil
@@ -537,6 +617,11 @@ func (e *userspaceEngine) pollResolver() {
 
 var debugTrimWireguard = envknob.OptBool("TS_DEBUG_TRIM_WIREGUARD")
 
+// debugHostAnswersPing, if set, leaves ICMP echo requests to our own
+// Tailscale IPs for the host OS (or netstack) to answer, as before
+// tstun answered them itself.
+var debugHostAnswersPing = envknob.Bool("TS_DEBUG_HOST_ANSWERS_PING")
+
 // forceFullWireguardConfig reports whether we should give wireguard
 // our full network map, even for inactive peers
 //
@@ -608,7 +693,7 @@ func (e *userspaceEngine) noteRecvActivity(nk key.NodePublic) {
 	// tailscaled alone did not, hence this.
 	if e.lastStatusPollTime.IsZero() || now.Sub(e.lastStatusPollTime) >= statusPollInterval {
 		e.lastStatusPollTime = now
-		go e.RequestStatus()
+		crashreport.Go(e.RequestStatus)
 	}
 
 	// If the last activity time jumped a bunch (say, at least
@@ -650,6 +735,7 @@ func (e *userspaceEngine) maybeReconfigWireguardLocked(discoChanged map[key.Node
 		return nil
 	}
 
+	start := time.Now()
 	full := e.lastCfgFull
 	e.wgLogger.SetPeers(full.Peers)
 
@@ -712,6 +798,7 @@ func (e *userspaceEngine) maybeReconfigWireguardLocked(discoChanged map[key.Node
 		return nil
 	}
 
+	wasTrimmed := e.trimmedNodes
 	e.trimmedNodes = trimmedNodes
 
 	e.updateActivityMapsLocked(trackNodes, trackIPs)
@@ -741,6 +828,12 @@ func (e *userspaceEngine) maybeReconfigWireguardLocked(discoChanged map[key.Node
 		e.logf("wgdev.Reconfig: %v", err)
 		return err
 	}
+	for nk := range wasTrimmed {
+		if !trimmedNodes[nk] {
+			// Woken up by traffic; trace connecting to it.
+			e.magicConn.NotePeerConfigured(nk, time.Since(start))
+		}
+	}
 	return nil
 }
 
@@ -917,7 +1010,8 @@ func (e *userspaceEngine) Reconfig(cfg *wgcfg.Config, routerCfg *router.Config,
 
 	if routerChanged {
 		e.logf("wgengine: Reconfig: configuring router")
-		err := e.router.Set(routerCfg)
+		e.lastRouterConfig = routerCfg
+		err := e.router.Set(e.routeDamper.Damp(routerCfg))
 		health.SetRouterHealth(err)
 		if err != nil {
 			return err
@@ -953,6 +1047,25 @@ func (e *userspaceEngine) Reconfig(cfg *wgcfg.Config, routerCfg *router.Config,
 	return nil
 }
 
+// reapplyDampedRoutes reconfigures the router when the hold-down of a
+// flapping subnet route ends.
+func (e *userspaceEngine) reapplyDampedRoutes() {
+	e.wgLock.Lock()
+	defer e.wgLock.Unlock()
+	if e.lastRouterConfig == nil {
+		return
+	}
+	e.mu.Lock()
+	closing := e.closing
+	e.mu.Unlock()
+	if closing {
+		return
+	}
+	e.logf("wgengine: reconfiguring router after route hold-down")
+	err := e.router.Set(e.routeDamper.Damp(e.lastRouterConfig))
+	health.SetRouterHealth(err)
+}
+
 func (e *userspaceEngine) GetFilter() *filter.Filter {
 	return e.tundev.GetFilter()
 }
@@ -1078,6 +1191,7 @@ func (e *userspaceEngine) Close() {
 		e.linkMon.Close()
 	}
 	e.dns.Down()
+	e.routeDamper.Close()
 	e.router.Close()
 	e.wgdev.Close()
 	e.tundev.Close()
@@ -1099,7 +1213,8 @@ func (e *userspaceEngine) GetLinkMonitor() *monitor.Mon {
 // LinkChange signals a network change event. It's currently
 // (2021-03-03) only called on Android. On other platforms, linkMon
 // generates link change events for us.
-func (e *userspaceEngine) LinkChange(_ bool) {
+func (e *userspaceEngine) LinkChange(isExpensive bool) {
+	e.magicConn.SetLinkExpensive(isExpensive)
 	e.linkMon.InjectEvent()
 }
 
@@ -1205,6 +1320,24 @@ func (e *userspaceEngine) UpdateStatus(sb *ipnstate.StatusBuilder) {
 		})
 	}
 
+	if rg, ok := e.router.(router.RouteMetricGetter); ok {
+		if m, ok := rg.RouteMetric(); ok {
+			sb.MutateStatus(func(s *ipnstate.Status) {
+				s.RouteMetric = &m
+			})
+		}
+	}
+
+	if dr := e.routeDamper.Dampened(); len(dr) > 0 {
+		routes := make([]ipnstate.DampenedRoute, len(dr))
+		for i, r := range dr {
+			routes[i] = ipnstate.DampenedRoute{Route: r.Route, Reinstalls: r.Reinstalls, Until: r.Until}
+		}
+		sb.MutateStatus(func(s *ipnstate.Status) {
+			s.DampenedRoutes = routes
+		})
+	}
+
 	e.magicConn.UpdateStatus(sb)
 }
 
@@ -1260,46 +1393,164 @@ func (e *userspaceEngine) sendICMPEchoRequest(destIP netip.Addr, peer *tailcfg.N
 		cb(res)
 		return
 	}
-	var icmph packet.Header
-	if srcIP.Is4() {
-		icmph = packet.ICMP4Header{
+	icmph := icmpEchoRequestHeader(srcIP, destIP)
+	idSeq, payload := packet.ICMPEchoPayload(nil)
+
+	var once sync.Once
+	unregister := func() {
+		e.setICMPEchoResponseCallback(idSeq, nil)
+		e.setICMPErrorCallback(idSeq, nil)
+	}
+	expireTimer := time.AfterFunc(10*time.Second, unregister)
+	t0 := time.Now()
+	e.setICMPEchoResponseCallback(idSeq, func() {
+		once.Do(func() {
+			expireTimer.Stop()
+			unregister()
+			d := time.Since(t0)
+			res.LatencySeconds = d.Seconds()
+			res.NodeIP = destIP.String()
+			res.NodeName = peer.ComputedName
+			cb(res)
+		})
+	})
+	e.setICMPErrorCallback(idSeq, func(p *packet.Parsed) {
+		once.Do(func() {
+			expireTimer.Stop()
+			unregister()
+			res.NodeIP = destIP.String()
+			res.NodeName = peer.ComputedName
+			res.Err = e.icmpErrorString(p)
+			cb(res)
+		})
+	})
+
+	icmpPing := packet.Generate(icmph, payload)
+	e.tundev.InjectOutbound(icmpPing)
+}
+
+// icmpErrorString describes the ICMP error p, received in reply to an
+// echo request, and which router reported it.
+func (e *userspaceEngine) icmpErrorString(p *packet.Parsed) string {
+	t, code := p.Transport()[0], p.Transport()[1]
+	var what string
+	if p.IPVersion == 4 {
+		switch packet.ICMP4Type(t) {
+		case packet.ICMP4TimeExceeded:
+			what = "TTL exceeded"
+		case packet.ICMP4Unreachable:
+			switch code {
+			case 0:
+				what = "network unreachable"
+			case 1:
+				what = "host unreachable"
+			case 3:
+				what = "port unreachable"
+			case 9, 10, 13:
+				what = "administratively prohibited"
+			}
+		}
+	} else {
+		switch packet.ICMP6Type(t) {
+		case packet.ICMP6TimeExceeded:
+			what = "hop limit exceeded"
+		case packet.ICMP6Unreachable:
+			switch code {
+			case 0:
+				what = "no route to destination"
+			case 1:
+				what = "administratively prohibited"
+			case 3:
+				what = "address unreachable"
+			case 4:
+				what = "port unreachable"
+			}
+		}
+	}
+	if what == "" {
+		what = fmt.Sprintf("ICMP error type %d code %d", t, code)
+	}
+	from := p.Src.Addr()
+	if pip, ok := e.PeerForIP(from); ok && !pip.IsSelf {
+		return fmt.Sprintf("%s, reported by %v (%s)", what, from, pip.Node.ComputedName)
+	}
+	return fmt.Sprintf("%s, reported by %v", what, from)
+}
+
+// icmpEchoRequestHeader returns the header of an ICMP echo request from
+// src to dst.
+func icmpEchoRequestHeader(src, dst netip.Addr) packet.Header {
+	if src.Is4() {
+		return packet.ICMP4Header{
 			IP4Header: packet.IP4Header{
 				IPProto: ipproto.ICMPv4,
-				Src:     srcIP,
-				Dst:     destIP,
+				Src:     src,
+				Dst:     dst,
 			},
 			Type: packet.ICMP4EchoRequest,
 			Code: packet.ICMP4NoCode,
 		}
-	} else {
-		icmph = packet.ICMP6Header{
-			IP6Header: packet.IP6Header{
-				IPProto: ipproto.ICMPv6,
-				Src:     srcIP,
-				Dst:     destIP,
-			},
-			Type: packet.ICMP6EchoRequest,
-			Code: packet.ICMP6NoCode,
-		}
 	}
+	return packet.ICMP6Header{
+		IP6Header: packet.IP6Header{
+			IPProto: ipproto.ICMPv6,
+			Src:     src,
+			Dst:     dst,
+		},
+		Type: packet.ICMP6EchoRequest,
+		Code: packet.ICMP6NoCode,
+	}
+}
+
+// traceHopTimeout is how long TraceHop waits for a reply to a probe.
+const traceHopTimeout = 3 * time.Second
 
+func (e *userspaceEngine) TraceHop(ip netip.Addr, ttl uint8, cb func(*ipnstate.TracerouteHop)) {
+	res := &ipnstate.TracerouteHop{TTL: int(ttl)}
+	srcIP, err := e.mySelfIPMatchingFamily(ip)
+	if err != nil {
+		res.Err = err.Error()
+		cb(res)
+		return
+	}
 	idSeq, payload := packet.ICMPEchoPayload(nil)
 
-	expireTimer := time.AfterFunc(10*time.Second, func() {
-		e.setICMPEchoResponseCallback(idSeq, nil)
-	})
+	var once sync.Once
 	t0 := time.Now()
+	var expireTimer *time.Timer
+	done := func(from netip.Addr, reached, unreachable bool) {
+		once.Do(func() {
+			expireTimer.Stop()
+			e.setICMPEchoResponseCallback(idSeq, nil)
+			e.setICMPErrorCallback(idSeq, nil)
+			if !from.IsValid() {
+				res.Err = "timeout"
+			} else {
+				res.IP = from.String()
+				res.LatencySeconds = time.Since(t0).Seconds()
+				res.Reached = reached
+				res.Unreachable = unreachable
+			}
+			cb(res)
+		})
+	}
+	expireTimer = time.AfterFunc(traceHopTimeout, func() {
+		done(netip.Addr{}, false, false)
+	})
 	e.setICMPEchoResponseCallback(idSeq, func() {
-		expireTimer.Stop()
-		d := time.Since(t0)
-		res.LatencySeconds = d.Seconds()
-		res.NodeIP = destIP.String()
-		res.NodeName = peer.ComputedName
-		cb(res)
+		done(ip, true, false)
+	})
+	e.setICMPErrorCallback(idSeq, func(p *packet.Parsed) {
+		t := p.Transport()[0]
+		unreachable := p.IPVersion == 4 && packet.ICMP4Type(t) == packet.ICMP4Unreachable ||
+			p.IPVersion == 6 && packet.ICMP6Type(t) == packet.ICMP6Unreachable
+		from := p.Src.Addr()
+		done(from, from == ip, unreachable)
 	})
 
-	icmpPing := packet.Generate(icmph, payload)
-	e.tundev.InjectOutbound(icmpPing)
+	probe := packet.Generate(icmpEchoRequestHeader(srcIP, ip), payload)
+	packet.SetTTL(probe, ttl)
+	e.tundev.InjectOutbound(probe)
 }
 
 func (e *userspaceEngine) sendTSMPPing(ip netip.Addr, peer *tailcfg.Node, res *ipnstate.PingResult, cb func(*ipnstate.PingResult)) {
@@ -1372,6 +1623,16 @@ func (e *userspaceEngine) setICMPEchoResponseCallback(idSeq uint32, cb func()) {
 	}
 }
 
+func (e *userspaceEngine) setICMPErrorCallback(idSeq uint32, cb func(*packet.Parsed)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if cb == nil {
+		delete(e.icmpErrorCallback, idSeq)
+	} else {
+		mak.Set(&e.icmpErrorCallback, idSeq, cb)
+	}
+}
+
 func (e *userspaceEngine) RegisterIPPortIdentity(ipport netip.AddrPort, tsIP netip.Addr) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
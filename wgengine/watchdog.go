@@ -24,6 +24,7 @@
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/key"
 	"tailscale.com/types/netmap"
+	"tailscale.com/util/crashreport"
 	"tailscale.com/wgengine/filter"
 	"tailscale.com/wgengine/magicsock"
 	"tailscale.com/wgengine/monitor"
@@ -84,9 +85,9 @@ func (e *watchdogEngine) watchdogErr(name string, fn func() error) error {
 	}()
 
 	errCh := make(chan error)
-	go func() {
+	crashreport.Go(func() {
 		errCh <- fn()
-	}()
+	})
 	t := time.NewTimer(e.maxWait)
 	select {
 	case err := <-errCh:
@@ -169,6 +170,9 @@ func (e *watchdogEngine) DiscoPublicKey() (k key.DiscoPublic) {
 func (e *watchdogEngine) Ping(ip netip.Addr, pingType tailcfg.PingType, cb func(*ipnstate.PingResult)) {
 	e.watchdog("Ping", func() { e.wrap.Ping(ip, pingType, cb) })
 }
+func (e *watchdogEngine) TraceHop(ip netip.Addr, ttl uint8, cb func(*ipnstate.TracerouteHop)) {
+	e.watchdog("TraceHop", func() { e.wrap.TraceHop(ip, ttl, cb) })
+}
 func (e *watchdogEngine) RegisterIPPortIdentity(ipp netip.AddrPort, tsIP netip.Addr) {
 	e.watchdog("RegisterIPPortIdentity", func() { e.wrap.RegisterIPPortIdentity(ipp, tsIP) })
 }
@@ -194,6 +198,12 @@ func (e *watchdogEngine) GetResolver() (r *resolver.Resolver, ok bool) {
 	}
 	return nil, false
 }
+func (e *watchdogEngine) RouterState() *ipnstate.RouterState {
+	if rg, ok := e.wrap.(RouterStateGetter); ok {
+		return rg.RouterState()
+	}
+	return new(ipnstate.RouterState)
+}
 func (e *watchdogEngine) PeerForIP(ip netip.Addr) (ret PeerForIP, ok bool) {
 	e.watchdog("PeerForIP", func() { ret, ok = e.wrap.PeerForIP(ip) })
 	return ret, ok
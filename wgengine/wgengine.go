@@ -112,7 +112,8 @@ type Engine interface {
 	// LinkChange informs the engine that the system network
 	// link has changed.
 	//
-	// The isExpensive parameter is not used.
+	// The isExpensive parameter is whether the new link is
+	// metered, such as LTE, for power saver mode's "auto" setting.
 	//
 	// LinkChange should be called whenever something changed with
 	// the network, no matter how minor.
@@ -158,6 +159,12 @@ type Engine interface {
 	// then call cb with its ping latency & method.
 	Ping(ip netip.Addr, pingType tailcfg.PingType, cb func(*ipnstate.PingResult))
 
+	// TraceHop sends an ICMP echo request to ip through the tunnel with
+	// its TTL, or IPv6 hop limit, set to ttl, and calls cb with the
+	// reply: from ip itself, or an ICMP error from a router on the way.
+	// It's called with an error if no reply arrives in time.
+	TraceHop(ip netip.Addr, ttl uint8, cb func(*ipnstate.TracerouteHop))
+
 	// RegisterIPPortIdentity registers a given node (identified by its
 	// Tailscale IP) as temporarily having the given IP:port for whois lookups.
 	// The IP:port is generally a localhost IP and an ephemeral port, used